@@ -0,0 +1,198 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trustctl/internal/account"
+	"github.com/trustctl/trustctl/internal/acme"
+	"github.com/trustctl/trustctl/internal/metadata"
+	"github.com/trustctl/trustctl/internal/paths"
+	"github.com/trustctl/trustctl/internal/ui"
+)
+
+var (
+	accountCAFlag    string
+	accountNameFlag  string
+	accountEmailFlag string
+)
+
+var accountCmd = &cobra.Command{
+	Use:   "account",
+	Short: "Manage CA accounts",
+}
+
+var accountUpdateCmd = &cobra.Command{
+	Use:   "update",
+	Short: "Change the contact email of a CA account",
+	Long: "Update the contact on the ACME account at the CA and in the stored account file. " +
+		"Select the account with --ca and --account.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if accountEmailFlag == "" {
+			return errors.New("--email is required")
+		}
+		if _, err := mail.ParseAddress(accountEmailFlag); err != nil {
+			return fmt.Errorf("invalid email %q: %w", accountEmailFlag, err)
+		}
+		if err := account.ValidateName(accountNameFlag); err != nil {
+			return err
+		}
+		acct, err := account.Load(accountCAFlag, accountNameFlag)
+		if err != nil {
+			return err
+		}
+
+		if _, err := os.Stat(acct.AccountKey); err == nil && acct.Directory() != "" {
+			key, err := acct.LoadKey()
+			if err != nil {
+				return fmt.Errorf("failed to load account key: %w", err)
+			}
+			client := acme.NewClient(acct.Directory(), key)
+			client.KID = acct.AccountURL
+			ui.StepStart("Updating contact at %s...", acct.Directory())
+			if _, err := client.UpdateAccount(cmd.Context(), []string{"mailto:" + accountEmailFlag}); err != nil {
+				ui.Error("CA rejected the update: %v", err)
+				return err
+			}
+			ui.Success("CA account contact updated")
+		} else {
+			// Only registered ACME accounts have a key; enterprise accounts are contacted out of band
+			ui.Warning("No ACME account key for %s; updating the stored contact only", acct.CA)
+		}
+
+		acct.Email = accountEmailFlag
+		acct.LastUpdatedAt = time.Now()
+		if err := acct.Store(); err != nil {
+			return fmt.Errorf("failed to store account: %w", err)
+		}
+		ui.Success("Account %s (%s) now uses %s", acct.CA, acct.ProfileName(), accountEmailFlag)
+		return nil
+	},
+}
+
+var accountRotateKeyCmd = &cobra.Command{
+	Use:   "rotate-key",
+	Short: "Replace the account key of a CA account",
+	Long: "Generate a new account key, switch the ACME account to it with the CA's key change " +
+		"and store it. The old key is kept as a timestamped .bak file next to the new one. " +
+		"Select the account with --ca and --account.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := account.ValidateName(accountNameFlag); err != nil {
+			return err
+		}
+		acct, err := account.Load(accountCAFlag, accountNameFlag)
+		if err != nil {
+			return err
+		}
+		before := acct.KeyThumbprint()
+		ui.StepStart("Rotating account key at %s...", acct.Directory())
+		backup, err := acct.RotateKey(cmd.Context(), nil)
+		if err != nil {
+			ui.Error("%v", err)
+			return err
+		}
+		ui.Success("Account %s (%s) now uses key %s", acct.CA, acct.ProfileName(), acct.KeyThumbprint())
+		ui.Info("Previous key (%s) kept in %s", before, backup)
+		return nil
+	},
+}
+
+var accountListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List registered CA accounts",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		accts, err := account.List()
+		if err != nil {
+			return err
+		}
+		if len(accts) == 0 {
+			ui.Info("No accounts in %s", paths.Credentials())
+			return nil
+		}
+		certs, err := metadata.LoadMatching(nil)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "CA\tACCOUNT\tEMAIL\tCREATED\tCERTS")
+		for _, a := range accts {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\n", a.CA, a.ProfileName(), a.Email, a.CreatedAt.Format("2006-01-02"), len(accountCerts(a, certs)))
+		}
+		return w.Flush()
+	},
+}
+
+var accountShowCmd = &cobra.Command{
+	Use:   "show <ca>",
+	Short: "Show details of a CA account",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := account.ValidateName(accountNameFlag); err != nil {
+			return err
+		}
+		a, err := account.Load(args[0], accountNameFlag)
+		if err != nil {
+			return err
+		}
+		certs, err := metadata.LoadMatching(nil)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("CA:          %s\n", a.CA)
+		fmt.Printf("Account:     %s\n", a.ProfileName())
+		fmt.Printf("Directory:   %s\n", valueOr(a.Directory(), "-"))
+		fmt.Printf("Account URL: %s\n", valueOr(a.AccountURL, "-"))
+		fmt.Printf("Email:       %s\n", a.Email)
+		if a.TermsOfService != "" {
+			fmt.Printf("Terms:       %s\n", a.TermsOfService)
+		}
+		fmt.Printf("Created:     %s\n", a.CreatedAt.Format(time.RFC3339))
+		if !a.LastUpdatedAt.IsZero() {
+			fmt.Printf("Updated:     %s\n", a.LastUpdatedAt.Format(time.RFC3339))
+		}
+		fmt.Printf("Key:         %s\n", a.AccountKey)
+		fmt.Printf("Thumbprint:  %s\n", valueOr(a.KeyThumbprint(), "(key not available)"))
+		used := accountCerts(a, certs)
+		fmt.Printf("Certificates (%d):\n", len(used))
+		for _, m := range used {
+			fmt.Printf("  %s (expires %s)\n", m.Domains[0], m.ExpiresAt.Format("2006-01-02"))
+		}
+		return nil
+	},
+}
+
+// accountCerts returns the managed certificates issued under account a.
+func accountCerts(a *account.AccountInfo, certs []*metadata.CertMetadata) []*metadata.CertMetadata {
+	var out []*metadata.CertMetadata
+	for _, m := range certs {
+		name := m.Account
+		if name == "" {
+			name = account.DefaultName
+		}
+		if account.CANameFor(m.CA, m.ServerURL, m.DirectoryURL) == a.CA && name == a.ProfileName() && len(m.Domains) > 0 {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+func valueOr(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+func init() {
+	accountCmd.PersistentFlags().StringVar(&accountCAFlag, "ca", "letsencrypt", "CA the account belongs to (letsencrypt, enterprise-ca)")
+	accountCmd.PersistentFlags().StringVar(&accountNameFlag, "account", account.DefaultName, "Account profile name")
+	accountUpdateCmd.Flags().StringVar(&accountEmailFlag, "email", "", "New contact email")
+
+	accountCmd.AddCommand(accountUpdateCmd, accountRotateKeyCmd, accountListCmd, accountShowCmd)
+	rootCmd.AddCommand(accountCmd)
+}
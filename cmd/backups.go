@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trustctl/internal/install"
+	"github.com/trustctl/trustctl/internal/ui"
+)
+
+var (
+	backupsKeepLastFlag int
+	backupsMaxAgeFlag   string
+	backupsDryRunFlag   bool
+)
+
+var backupsCmd = &cobra.Command{
+	Use:   "backups",
+	Short: "List and prune the vhost backups taken by the installer",
+}
+
+var backupsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List vhost backups, oldest first",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		backups := install.ListBackups()
+		if len(backups) == 0 {
+			ui.Info("No vhost backups in %s", install.BackupsDir())
+			return nil
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "TIME\tFILE\tBACKUP")
+		for _, b := range backups {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", b.Time.Local().Format("2006-01-02 15:04:05"), b.Original, b.Path)
+		}
+		return w.Flush()
+	},
+}
+
+var backupsPruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove vhost backups beyond the retention policy",
+	Long: "Remove the backups of each config file beyond the newest --keep-last and those older than --max-age, " +
+		"and the installer run manifests left without backups. Both default to the backups settings of the " +
+		"configuration file (keep the last 10, no age limit); --keep-last 0 keeps any number.",
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		policy := install.RetentionPolicy()
+		if cmd.Flags().Changed("keep-last") {
+			if backupsKeepLastFlag < 0 {
+				return fmt.Errorf("--keep-last must not be negative")
+			}
+			policy.KeepLast = backupsKeepLastFlag
+		}
+		if backupsMaxAgeFlag != "" {
+			d, err := parseDuration(backupsMaxAgeFlag)
+			if err != nil {
+				return fmt.Errorf("invalid --max-age: %w", err)
+			}
+			policy.MaxAge = d
+		}
+		removed, err := install.PruneBackups(policy, backupsDryRunFlag)
+		for _, b := range removed {
+			if backupsDryRunFlag {
+				ui.Info("would remove %s (%s)", b.Path, b.Time.Local().Format("2006-01-02 15:04:05"))
+			} else {
+				ui.StepDone("removed %s", b.Path)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		switch {
+		case len(removed) == 0:
+			ui.Success("No backups to prune")
+		case backupsDryRunFlag:
+			ui.Warning("%d backup(s) would be removed; rerun without --dry-run to delete them", len(removed))
+		default:
+			ui.Success("Removed %d backup(s)", len(removed))
+		}
+		return nil
+	},
+}
+
+func init() {
+	backupsPruneCmd.Flags().IntVar(&backupsKeepLastFlag, "keep-last", 0, "Backups kept per config file (default from the configuration file, else 10)")
+	backupsPruneCmd.Flags().StringVar(&backupsMaxAgeFlag, "max-age", "", "Also remove backups older than this, e.g. 30d")
+	backupsPruneCmd.Flags().BoolVar(&backupsDryRunFlag, "dry-run", false, "Only list what would be removed")
+	backupsCmd.AddCommand(backupsListCmd, backupsPruneCmd)
+	rootCmd.AddCommand(backupsCmd)
+}
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trustctl/internal/ui"
+	"github.com/trustctl/trustctl/pkg/trustctl"
+)
+
+// Outcomes of one certificate of a --domains-file batch.
+const (
+	batchIssued = "issued"
+	batchExists = "exists" // a valid certificate for the same names was kept
+	batchFailed = "failed"
+)
+
+// batchEntry is one certificate of the --output json result of request --domains-file.
+type batchEntry struct {
+	Domains     []string              `json:"domains"`
+	Outcome     string                `json:"outcome"`
+	Error       string                `json:"error,omitempty"`
+	Certificate *trustctl.Certificate `json:"certificate,omitempty"`
+}
+
+// requestBatch requests every certificate of --domains-file on top of base and prints
+// a summary; it fails when any of them failed.
+func requestBatch(cmd *cobra.Command, base trustctl.RequestOptions) error {
+	if parallelFlag < 1 {
+		return errors.New("--parallel must be at least 1")
+	}
+	specs, err := trustctl.LoadBatch(domainsFileFlag)
+	if err != nil {
+		return err
+	}
+	ui.Info("Requesting %d certificate(s) from %s, %d at a time", len(specs), domainsFileFlag, parallelFlag)
+	results := trustctl.RequestBatch(cmd.Context(), base, specs, trustctl.BatchOptions{Parallel: parallelFlag, Timeout: requestTimeoutFlag})
+
+	entries := make([]batchEntry, 0, len(results))
+	failed := 0
+	for _, r := range results {
+		e := batchEntry{Domains: r.Domains, Outcome: batchExists, Certificate: publicCertificate(r.Certificate)}
+		switch {
+		case r.Err != nil:
+			e.Outcome, e.Error = batchFailed, r.Err.Error()
+			failed++
+		case r.Issued:
+			e.Outcome = batchIssued
+		}
+		entries = append(entries, e)
+	}
+	var summary error
+	if failed > 0 {
+		// The report says what failed; usage text would only bury it
+		cmd.SilenceUsage = true
+		summary = fmt.Errorf("%d of %d certificate request(s) failed", failed, len(results))
+	}
+	if jsonOutput() {
+		return writeResult(cmd, entries, summary)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, e := range entries {
+		detail := e.Error
+		if e.Certificate != nil {
+			detail = "expires " + e.Certificate.ExpiresAt.Format("2006-01-02")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", strings.Join(e.Domains, ","), e.Outcome, detail)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return summary
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trustctl/internal/config"
+	"github.com/trustctl/trustctl/pkg/trustctl"
+)
+
+var configFlag string
+
+// loadConfig reads the configuration file and fills the flags of cmd that it
+// supplies defaults for and that were not given on the command line.
+func loadConfig(cmd *cobra.Command) (*config.File, error) {
+	f, err := config.Load(config.Path(configFlag))
+	if err != nil {
+		return nil, err
+	}
+	if cmd == requestCmd {
+		defaults := map[string]string{
+			"ca":             f.CA,
+			"acme-directory": f.ACMEDirectory,
+			"email":          f.Email,
+			"key-type":       f.KeyType,
+			"pre-hook":       f.Hooks.Pre,
+			"post-hook":      f.Hooks.Post,
+			"deploy-hook":    f.Hooks.Deploy,
+		}
+		for name, v := range defaults {
+			if v == "" || cmd.Flags().Changed(name) {
+				continue
+			}
+			if err := cmd.Flags().Set(name, v); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return f, nil
+}
+
+// configDirs returns the state directories set in the configuration file.
+func configDirs(f *config.File) trustctl.Layout {
+	return trustctl.Layout{
+		Certs:       f.CertsDir,
+		Credentials: f.CredentialsDir,
+		Plugins:     f.PluginsDir,
+		Logs:        f.LogsDir,
+		Database:    f.Database,
+		Webroot:     f.Webroot,
+	}
+}
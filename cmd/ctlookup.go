@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trustctl/internal/ct"
+	"github.com/trustctl/trustctl/internal/metadata"
+	"github.com/trustctl/trustctl/internal/ui"
+)
+
+var (
+	ctSubdomainsFlag bool
+	ctExpiredFlag    bool
+	ctAggregatorFlag string
+)
+
+var ctLookupCmd = &cobra.Command{
+	Use:   "ct-lookup <domain>",
+	Short: "List certificates logged in Certificate Transparency for a domain",
+	Long: "Query a CT log aggregator (crt.sh by default) for every certificate logged for the domain and show issuer, " +
+		"validity and whether trustctl manages it, to find forgotten certificates and unexpected issuance.",
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		domain := strings.ToLower(strings.TrimSuffix(args[0], "."))
+		client := ct.NewClient(ctAggregatorFlag)
+
+		ui.StepStart("Querying %s for %s...", client.BaseURL, domain)
+		entries, err := client.Lookup(domain, ctSubdomainsFlag)
+		if err != nil {
+			ui.Error("CT lookup failed: %v", err)
+			return err
+		}
+
+		// Serials of certificates trustctl currently manages, normalised to crt.sh's form
+		managed := map[string]bool{}
+		if certs, err := metadata.LoadMatching(nil); err == nil {
+			for _, m := range certs {
+				if m.Serial != "" {
+					managed[normalizeSerial(m.Serial)] = true
+				}
+			}
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAMES\tISSUER\tNOT BEFORE\tNOT AFTER\tSERIAL\tMANAGED")
+		shown, unmanaged := 0, 0
+		for _, e := range entries {
+			if e.Expired() && !ctExpiredFlag {
+				continue
+			}
+			shown++
+			mark := "no"
+			if managed[normalizeSerial(e.Serial)] {
+				mark = "yes"
+			} else if !e.Expired() {
+				unmanaged++
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", strings.Join(e.Names, ","), e.Issuer,
+				e.NotBefore.Format("2006-01-02"), e.NotAfter.Format("2006-01-02"), e.Serial, mark)
+		}
+		w.Flush()
+
+		ui.Info("%d certificate(s) shown, %d logged in total", shown, len(entries))
+		if unmanaged > 0 {
+			ui.Warning("%d valid certificate(s) are not managed by trustctl on this host", unmanaged)
+		}
+		return nil
+	},
+}
+
+// normalizeSerial strips separators and leading zeros so colon-separated and plain hex serials compare equal.
+func normalizeSerial(s string) string {
+	s = strings.ToLower(strings.ReplaceAll(s, ":", ""))
+	return strings.TrimLeft(s, "0")
+}
+
+func init() {
+	ctLookupCmd.Flags().BoolVar(&ctSubdomainsFlag, "subdomains", false, "Include certificates for subdomains")
+	ctLookupCmd.Flags().BoolVar(&ctExpiredFlag, "expired", false, "Include expired certificates")
+	ctLookupCmd.Flags().StringVar(&ctAggregatorFlag, "aggregator", ct.DefaultAggregator, "crt.sh-compatible aggregator URL")
+
+	rootCmd.AddCommand(ctLookupCmd)
+}
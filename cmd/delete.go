@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trustctl/pkg/trustctl"
+)
+
+var (
+	deleteArchiveFlag bool
+	deleteRevertFlag  bool
+)
+
+var deleteCmd = &cobra.Command{
+	Use:     "delete <domain>",
+	Aliases: []string{"unregister"},
+	Short:   "Stop managing a certificate and remove its files",
+	Long: "Remove the certificate's metadata so renew no longer touches it and delete (or --archive) its directory. " +
+		"With --revert-install the vhost files that use it are restored from the backups taken before it was installed. " +
+		"The certificate is not revoked; use trustctl revoke first if it must stop being trusted.",
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return trustctl.Delete(args[0], trustctl.DeleteOptions{Archive: deleteArchiveFlag, Uninstall: deleteRevertFlag})
+	},
+}
+
+func init() {
+	deleteCmd.Flags().BoolVar(&deleteArchiveFlag, "archive", false, "Move the certificate directory to <base>/archive instead of deleting it")
+	deleteCmd.Flags().BoolVar(&deleteRevertFlag, "revert-install", false, "Restore the nginx/Apache files that use the certificate from their pre-install backups and reload")
+	rootCmd.AddCommand(deleteCmd)
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"errors"
+
+	"github.com/trustctl/trustctl/pkg/trustctl"
+)
+
+// Exit codes. They are part of the interface: automation branches on them, so
+// existing values never change meaning.
+const (
+	exitOK          = 0
+	exitError       = 1 // any failure without a more specific code
+	exitUsage       = 2 // unknown command or flag, bad flag value
+	exitNothingToDo = 3 // request: a valid certificate already existed; renew: nothing was due
+	exitValidation  = 4 // a challenge could not be published or the CA rejected it
+	exitCA          = 5 // account, order or issuance failed at the CA
+	exitRateLimited = 6 // the CA rate limited the request or renewals are paused by an earlier limit
+	exitInstall     = 7 // issued but not deployed to the web server
+	exitLocked      = 8 // another trustctl instance holds the lock
+)
+
+// nothingToDo is set by commands that succeeded without changing anything, so they
+// exit with exitNothingToDo.
+var nothingToDo bool
+
+// usageError marks flag errors reported by cobra.
+type usageError struct{ err error }
+
+func (e usageError) Error() string { return e.err.Error() }
+func (e usageError) Unwrap() error { return e.err }
+
+// exitCode maps the error a command returned to the process exit status. When a
+// failure has several classes (a rate limit is also a CA error) the most specific wins.
+func exitCode(err error) int {
+	var usage usageError
+	switch {
+	case err == nil && nothingToDo:
+		return exitNothingToDo
+	case err == nil:
+		return exitOK
+	case errors.As(err, &usage):
+		return exitUsage
+	case errors.Is(err, trustctl.ErrLocked):
+		return exitLocked
+	case trustctl.IsRateLimited(err):
+		return exitRateLimited
+	case errors.Is(err, trustctl.ErrValidation):
+		return exitValidation
+	case errors.Is(err, trustctl.ErrInstall):
+		return exitInstall
+	case errors.Is(err, trustctl.ErrCA):
+		return exitCA
+	}
+	return exitError
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"errors"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trustctl/internal/bundle"
+	"github.com/trustctl/trustctl/internal/secret"
+	"github.com/trustctl/trustctl/pkg/trustctl"
+)
+
+var (
+	exportDomainFlag       string
+	exportFormatFlag       string
+	exportContentFlag      string
+	exportOutFlag          string
+	exportAliasFlag        string
+	exportPasswordFileFlag string
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export a managed certificate as PEM, DER, PKCS#12 or P7B",
+	Long: "Write a managed certificate, its chain and optionally its private key in the format appliances and Java " +
+		"applications expect, leaf first and intermediates in order. PKCS#12 files are password-protected.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if exportDomainFlag == "" || exportOutFlag == "" {
+			return errors.New("--domain and --out are required")
+		}
+		opts := trustctl.ExportOptions{Format: exportFormatFlag, Content: exportContentFlag, Out: exportOutFlag, Alias: exportAliasFlag}
+		if exportFormatFlag == bundle.FormatPKCS12 {
+			pass, err := secret.Read(secret.Source{Name: "export password", File: exportPasswordFileFlag, Env: "TRUSTCTL_EXPORT_PASSWORD", Confirm: true})
+			if err != nil {
+				return err
+			}
+			opts.Password = pass
+		}
+		return trustctl.Export(exportDomainFlag, opts)
+	},
+}
+
+func init() {
+	exportCmd.Flags().StringVar(&exportDomainFlag, "domain", "", "Primary domain of the certificate to export (required)")
+	exportCmd.Flags().StringVar(&exportFormatFlag, "format", bundle.FormatPEM, "Output format: pem, der, pkcs12 or p7b")
+	exportCmd.Flags().StringVar(&exportContentFlag, "content", "", "What to export: cert, chain, fullchain or fullchain+key (default: cert for der, fullchain+key for pkcs12, else fullchain)")
+	exportCmd.Flags().StringVar(&exportOutFlag, "out", "", "File to write, - for stdout (required)")
+	exportCmd.Flags().StringVar(&exportAliasFlag, "alias", "", "Friendly name of the PKCS#12 entry, the Java keystore alias (default: the domain)")
+	exportCmd.Flags().StringVar(&exportPasswordFileFlag, "password-file", "", "File with the PKCS#12 password, - for stdin (default $TRUSTCTL_EXPORT_PASSWORD, else prompt)")
+	rootCmd.AddCommand(exportCmd)
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trustctl/internal/bundle"
+	"github.com/trustctl/trustctl/internal/metadata"
+	"github.com/trustctl/trustctl/internal/ui"
+)
+
+var fixChainCmd = &cobra.Command{
+	Use:   "fix-chain <domain>",
+	Short: "Complete a certificate's chain from its AIA URLs",
+	Long: "Rebuild chain.pem and fullchain.pem for a managed certificate, fetching missing intermediates " +
+		"from the Authority Information Access URLs. Use after importing a certificate issued elsewhere " +
+		"or when a server reports an incomplete chain.",
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		domain := args[0]
+		domainLock, err := metadata.LockDomain(domain)
+		if err != nil {
+			return err
+		}
+		defer domainLock.Release()
+
+		meta, err := metadata.Load(domain)
+		if err != nil {
+			return fmt.Errorf("failed to load metadata for %s: %w", domain, err)
+		}
+		data, err := os.ReadFile(meta.CertPath)
+		if err != nil {
+			return err
+		}
+
+		ui.StepStart("Rebuilding chain for %s...", domain)
+		files, err := bundle.Write(filepath.Dir(meta.CertPath), data, meta.KeyPath, meta.Bundle)
+		if err != nil {
+			return fmt.Errorf("failed to write certificate files: %w", err)
+		}
+		for _, subject := range files.Fetched {
+			ui.Info("Fetched missing intermediate via AIA: %s", subject)
+		}
+		meta.SetBundleFiles(files)
+		if err := meta.Store(); err != nil {
+			return err
+		}
+		if files.ChainErr != nil {
+			ui.Warning("chain does not verify against the system roots: %v", files.ChainErr)
+			return nil
+		}
+		ui.Success("Chain complete: %s", files.FullChain)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(fixChainCmd)
+}
@@ -0,0 +1,187 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trustctl/internal/install"
+	"github.com/trustctl/trustctl/internal/lock"
+	"github.com/trustctl/trustctl/internal/metadata"
+	"github.com/trustctl/trustctl/internal/paths"
+	"github.com/trustctl/trustctl/internal/ui"
+)
+
+var (
+	gcRemoveFlag       bool
+	gcBackupMaxAgeFlag string
+)
+
+// gcChallengeMinAge is how old a file in an acme-challenge directory must be to count
+// as a leftover; younger ones may belong to a validation in progress.
+const gcChallengeMinAge = time.Hour
+
+// gcItem is one piece of garbage found by gc.
+type gcItem struct {
+	kind string
+	path string
+	// remove deletes the item; nil means it is only reported
+	remove func() error
+}
+
+var gcCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Find and optionally remove orphaned certificates, stale backups and challenge leftovers",
+	Long: "Report certificate directories without metadata, metadata whose key or certificate files are missing, " +
+		"vhost backups older than the retention period and ACME challenge files older than an hour. Certificates locked by another " +
+		"trustctl process are skipped. Use --remove to delete them.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		maxAge, err := parseDuration(gcBackupMaxAgeFlag)
+		if err != nil {
+			return fmt.Errorf("invalid --backup-max-age: %w", err)
+		}
+
+		ui.StepStart("Scanning for garbage...")
+		var items []gcItem
+		webroots := map[string]bool{paths.Webroot(): true}
+
+		// Certificates another trustctl process is working on are skipped; the locks
+		// are held until the items found are removed.
+		locks := map[string]*lock.Lock{}
+		defer func() {
+			for _, l := range locks {
+				l.Release()
+			}
+		}()
+		lockDomain := func(domain string) (bool, error) {
+			if _, ok := locks[domain]; ok {
+				return true, nil
+			}
+			l, err := metadata.LockDomain(domain)
+			if errors.Is(err, lock.ErrLocked) {
+				ui.Info("Skipping %s: another trustctl process is working on it", domain)
+				return false, nil
+			}
+			if err != nil {
+				return false, err
+			}
+			locks[domain] = l
+			return true, nil
+		}
+
+		// Certificate directories without metadata
+		entries, err := os.ReadDir(paths.Certs())
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		for _, e := range entries {
+			if !e.IsDir() {
+				continue
+			}
+			if ok, err := lockDomain(e.Name()); err != nil {
+				return err
+			} else if !ok {
+				continue
+			}
+			if _, err := metadata.Load(e.Name()); errors.Is(err, os.ErrNotExist) {
+				dir := filepath.Join(paths.Certs(), e.Name())
+				items = append(items, gcItem{"cert directory without metadata", dir, func() error { return os.RemoveAll(dir) }})
+			}
+		}
+
+		// Metadata referencing missing files
+		domains, err := metadata.ListAll()
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		for _, d := range domains {
+			if ok, err := lockDomain(d); err != nil {
+				return err
+			} else if !ok {
+				continue
+			}
+			m, err := metadata.Load(d)
+			if err != nil {
+				continue
+			}
+			if m.Webroot != "" {
+				webroots[m.Webroot] = true
+			}
+			for _, p := range []string{m.CertPath, m.KeyPath} {
+				if p == "" {
+					continue
+				}
+				if _, err := os.Stat(p); errors.Is(err, os.ErrNotExist) {
+					domain := d
+					items = append(items, gcItem{"metadata for " + d + " references missing file", p, func() error { return metadata.Delete(domain) }})
+					break
+				}
+			}
+		}
+
+		// Backups older than retention
+		cutoff := time.Now().Add(-maxAge)
+		for _, b := range install.ListBackups() {
+			if b.Time.Before(cutoff) {
+				p := b.Path
+				items = append(items, gcItem{"vhost backup older than " + gcBackupMaxAgeFlag, p, func() error { return os.Remove(p) }})
+			}
+		}
+
+		// Challenge leftovers
+		challengeCutoff := time.Now().Add(-gcChallengeMinAge)
+		for root := range webroots {
+			dir := filepath.Join(root, ".well-known", "acme-challenge")
+			files, err := os.ReadDir(dir)
+			if err != nil {
+				continue
+			}
+			for _, f := range files {
+				if !f.Type().IsRegular() {
+					continue
+				}
+				if info, err := f.Info(); err == nil && info.ModTime().Before(challengeCutoff) {
+					p := filepath.Join(dir, f.Name())
+					items = append(items, gcItem{"challenge leftover", p, func() error { return os.Remove(p) }})
+				}
+			}
+		}
+
+		if len(items) == 0 {
+			ui.Success("Nothing to clean up")
+			return nil
+		}
+		failed := 0
+		for _, it := range items {
+			if !gcRemoveFlag {
+				ui.Info("%s: %s", it.kind, it.path)
+				continue
+			}
+			if err := it.remove(); err != nil {
+				failed++
+				ui.Error("failed to remove %s: %v", it.path, err)
+				continue
+			}
+			ui.StepDone("removed %s: %s", it.kind, it.path)
+		}
+		if !gcRemoveFlag {
+			ui.Warning("Found %d item(s); rerun with --remove to delete them", len(items))
+			return nil
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d item(s) could not be removed", failed)
+		}
+		ui.Success("Removed %d item(s)", len(items))
+		return nil
+	},
+}
+
+func init() {
+	gcCmd.Flags().BoolVar(&gcRemoveFlag, "remove", false, "Delete what was found instead of only reporting it")
+	gcCmd.Flags().StringVar(&gcBackupMaxAgeFlag, "backup-max-age", "30d", "Vhost backups older than this are garbage")
+
+	rootCmd.AddCommand(gcCmd)
+}
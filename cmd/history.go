@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trustctl/internal/metadata"
+	"github.com/trustctl/trustctl/internal/ui"
+)
+
+var historyLimitFlag int
+
+var historyCmd = &cobra.Command{
+	Use:   "history <domain>",
+	Short: "Show renewal history for a certificate",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		domain := args[0]
+		if _, err := metadata.Load(domain); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("no certificate managed for %s", domain)
+			}
+			return err
+		}
+		records, err := metadata.History(domain)
+		if err != nil {
+			return fmt.Errorf("failed to read history: %w", err)
+		}
+		if len(records) == 0 {
+			ui.Info("No renewal attempts recorded for %s", domain)
+			return nil
+		}
+		streak := metadata.FailureStreak(records)
+		if historyLimitFlag > 0 && len(records) > historyLimitFlag {
+			records = records[len(records)-historyLimitFlag:]
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "TIME\tOUTCOME\tDURATION\tDETAIL")
+		for _, r := range records {
+			detail := r.CAResponse
+			if r.Error != "" {
+				detail = r.Error
+			}
+			d := time.Duration(r.DurationMS) * time.Millisecond
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.At.Local().Format("2006-01-02 15:04:05"), r.Outcome, d, detail)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		if streak > 0 {
+			ui.Warning("%s has failed %d renewal attempt(s) in a row", domain, streak)
+		}
+		return nil
+	},
+}
+
+func init() {
+	historyCmd.Flags().IntVar(&historyLimitFlag, "limit", 20, "Show at most this many recent attempts (0 for all)")
+
+	rootCmd.AddCommand(historyCmd)
+}
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trustctl/internal/install"
+	"github.com/trustctl/trustctl/internal/ui"
+)
+
+var hstsMaxAgeFlag string
+
+var hstsCmd = &cobra.Command{
+	Use:   "hsts",
+	Short: "Add or remove the Strict-Transport-Security header of 443 vhosts",
+}
+
+var hstsSetCmd = &cobra.Command{
+	Use:   "set DOMAIN...",
+	Short: "Add the Strict-Transport-Security header to the 443 vhosts of the domains, or change its max-age",
+	Long: "Set add_header (nginx) or Header always set (Apache) Strict-Transport-Security in the TLS vhosts serving the " +
+		"domains, check the configuration and reload the running server. --max-age 0 tells browsers to drop the policy, " +
+		"which should be served for the previous max-age before the header is removed.",
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		maxAge, err := parseHSTSMaxAge(hstsMaxAgeFlag)
+		if err != nil {
+			return err
+		}
+		if maxAge > 0 {
+			ui.Warning("%s", install.HSTSWarning(maxAge))
+		}
+		files, err := install.SetHSTS(args, maxAge)
+		if err != nil {
+			return err
+		}
+		if len(files) == 0 {
+			ui.Success("No change required")
+		}
+		return nil
+	},
+}
+
+var hstsRemoveCmd = &cobra.Command{
+	Use:   "remove DOMAIN...",
+	Short: "Remove the Strict-Transport-Security header from the 443 vhosts of the domains",
+	Long: "Remove the Strict-Transport-Security header from the TLS vhosts serving the domains, check the configuration " +
+		"and reload the running server. Browsers keep enforcing HTTPS until the max-age they last saw runs out; serve " +
+		"max-age=0 first (hsts set --max-age 0) to release them sooner.",
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		files, err := install.RemoveHSTS(args)
+		if err != nil {
+			return err
+		}
+		if len(files) == 0 {
+			ui.Success("No Strict-Transport-Security header to remove")
+		}
+		return nil
+	},
+}
+
+// parseHSTSMaxAge reads a max-age in seconds, as in the header, or as a duration
+// such as 365d.
+func parseHSTSMaxAge(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil && n >= 0 {
+		return time.Duration(n) * time.Second, nil
+	}
+	d, err := parseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid HSTS max-age %q (seconds, or a duration such as 365d)", s)
+	}
+	return d, nil
+}
+
+func init() {
+	hstsSetCmd.Flags().StringVar(&hstsMaxAgeFlag, "max-age", "730d", "How long browsers enforce HTTPS, in seconds or as a duration such as 365d")
+	hstsCmd.AddCommand(hstsSetCmd, hstsRemoveCmd)
+	rootCmd.AddCommand(hstsCmd)
+}
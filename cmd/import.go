@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trustctl/internal/ui"
+	"github.com/trustctl/trustctl/pkg/trustctl"
+)
+
+var (
+	importDirFlag      string
+	importCertNameFlag []string
+	importAccountFlag  string
+	importSymlinkFlag  bool
+	importDryRunFlag   bool
+)
+
+var importCmd = &cobra.Command{
+	Use:       "import certbot|acme.sh",
+	Short:     "Take over the certificates of certbot or acme.sh",
+	Long:      "Copy (or link) the certificates of certbot (/etc/letsencrypt) or acme.sh (~/.acme.sh) into trustctl, convert their renewal settings into metadata and import their ACME accounts, so trustctl renew takes over. Disable the other client's renewal timer afterwards.",
+	Args:      cobra.ExactArgs(1),
+	ValidArgs: []string{trustctl.ImportCertbot, trustctl.ImportAcmeSh},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		res, err := trustctl.Import(trustctl.ImportOptions{
+			Source:  args[0],
+			Dir:     importDirFlag,
+			Names:   importCertNameFlag,
+			Account: importAccountFlag,
+			Symlink: importSymlinkFlag,
+			DryRun:  importDryRunFlag,
+		})
+		if err != nil {
+			ui.Error("import failed: %v", err)
+			return err
+		}
+		if importDryRunFlag {
+			ui.Info("Dry run: %d certificate(s) would be imported", len(res.Imported))
+			return nil
+		}
+		ui.Success("Imported %d certificate(s) and %d account(s); %d already managed", len(res.Imported), len(res.Accounts), len(res.Skipped))
+		if len(res.Imported) > 0 {
+			stop := "systemctl disable --now certbot.timer"
+			if args[0] == trustctl.ImportAcmeSh {
+				stop = "acme.sh --uninstall-cronjob"
+			}
+			ui.Info("Stop %s from renewing them too: %s", args[0], stop)
+		}
+		return nil
+	},
+}
+
+func init() {
+	importCmd.Flags().StringVar(&importDirFlag, "dir", "", "The client's configuration directory (default /etc/letsencrypt for certbot, ~/.acme.sh for acme.sh)")
+	importCmd.Flags().StringSliceVar(&importCertNameFlag, "cert-name", nil, "Only import this certbot lineage or acme.sh domain (repeatable or comma-separated)")
+	importCmd.Flags().StringVar(&importAccountFlag, "account", "", "Account profile to store the imported ACME accounts under (default \"default\")")
+	importCmd.Flags().BoolVar(&importSymlinkFlag, "symlink", false, "Link the certificate files instead of copying them; the first renewal replaces the links")
+	importCmd.Flags().BoolVar(&importDryRunFlag, "dry-run", false, "Only show what would be imported")
+	rootCmd.AddCommand(importCmd)
+}
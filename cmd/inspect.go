@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trustctl/internal/certinfo"
+	"github.com/trustctl/trustctl/internal/install"
+	"github.com/trustctl/trustctl/internal/keygen"
+	"github.com/trustctl/trustctl/internal/keystore"
+	"github.com/trustctl/trustctl/internal/metadata"
+	"github.com/trustctl/trustctl/internal/ocsp"
+	"github.com/trustctl/trustctl/internal/ui"
+)
+
+var inspectNoOCSPFlag bool
+
+var inspectCmd = &cobra.Command{
+	Use:   "inspect <domain>",
+	Short: "Show everything known about a managed certificate",
+	Long: "Show the SANs, serial, fingerprint, validity and chain of a managed certificate, its OCSP status, " +
+		"whether the private key matches, its stored metadata and the web server files that reference it.",
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		m, err := metadata.Load(args[0])
+		if err != nil {
+			ui.Error("no certificate managed for %s: %v", args[0], err)
+			return fmt.Errorf("failed to load metadata for %s: %w", args[0], err)
+		}
+		info, err := certinfo.ParseFile(m.CertPath)
+		if err != nil {
+			ui.Error("failed to read %s: %v", m.CertPath, err)
+			return fmt.Errorf("failed to read certificate: %w", err)
+		}
+
+		problems := 0
+		ui.Info("Certificate: %s", m.CertPath)
+		ui.Info("  Subject:     %s", info.Leaf.Subject)
+		ui.Info("  SANs:        %s", strings.Join(info.SANs, ", "))
+		ui.Info("  Serial:      %s", info.Serial)
+		ui.Info("  SHA-256:     %s", info.FingerprintSHA256)
+		ui.Info("  Key type:    %s", keygen.PublicKeyType(info.Leaf.PublicKey))
+		ui.Info("  Valid:       %s to %s", info.NotBefore.Format(time.RFC3339), info.NotAfter.Format(time.RFC3339))
+		ui.Info("Chain:")
+		ui.Info("  0: %s (issuer: %s)", info.Leaf.Subject, info.Leaf.Issuer)
+		for i, c := range info.Chain {
+			ui.Info("  %d: %s (issuer: %s, expires %s)", i+1, c.Subject, c.Issuer, c.NotAfter.Format("2006-01-02"))
+		}
+		if days := int(time.Until(info.NotAfter).Hours() / 24); days < 0 {
+			problems++
+			ui.Error("Certificate expired %d day(s) ago", -days)
+		}
+
+		switch {
+		case inspectNoOCSPFlag:
+		case len(info.Chain) == 0:
+			ui.Warning("OCSP: no issuer certificate in %s, status not checked", m.CertPath)
+		default:
+			ctx, cancel := context.WithTimeout(cmd.Context(), 20*time.Second)
+			r, err := ocsp.Check(ctx, info.Leaf, info.Chain[0])
+			cancel()
+			switch {
+			case errors.Is(err, ocsp.ErrNoResponder):
+				ui.Info("OCSP: %v", err)
+			case err != nil:
+				ui.Warning("OCSP: %v", err)
+			case r.Status == ocsp.Good:
+				ui.Success("OCSP: good (%s, updated %s)", r.Responder, r.ThisUpdate.Format(time.RFC3339))
+			case r.Status == ocsp.Revoked:
+				problems++
+				ui.Error("OCSP: revoked at %s (%s)", r.RevokedAt.Format(time.RFC3339), ocsp.ReasonName(r.Reason))
+			default:
+				ui.Warning("OCSP: responder %s does not know this certificate", r.Responder)
+			}
+		}
+
+		if !inspectKeyMatches(m, info.Leaf.PublicKey) {
+			problems++
+		}
+
+		out, err := json.MarshalIndent(publicCertificate(m), "  ", "  ")
+		if err != nil {
+			return err
+		}
+		ui.Info("Metadata:\n  %s", out)
+
+		files := install.FilesReferencing(filepath.Dir(m.CertPath)+string(filepath.Separator), m.CertPath, m.KeyPath, m.CombinedPath)
+		if len(files) == 0 {
+			ui.Info("No nginx or Apache configuration references this certificate")
+		} else {
+			ui.Info("Referenced by:")
+			for _, f := range files {
+				ui.Info("  %s", f)
+			}
+		}
+
+		if problems > 0 {
+			return fmt.Errorf("%d problem(s) found for %s", problems, args[0])
+		}
+		return nil
+	},
+}
+
+// inspectKeyMatches reports whether the certificate's key is the one trustctl holds
+// for it; certificates issued from a supplied CSR have no key to compare.
+func inspectKeyMatches(m *metadata.CertMetadata, pub crypto.PublicKey) bool {
+	type equaler interface{ Equal(crypto.PublicKey) bool }
+	var key crypto.Signer
+	var err error
+	switch {
+	case m.ExternalCSR:
+		ui.Info("Key: held by whoever supplied the CSR, not checked")
+		return true
+	case m.KeyURI != "":
+		ui.Info("Key: %s", keystore.Public(m.KeyURI))
+		key, err = keystore.Open(m.KeyURI)
+	default:
+		ui.Info("Key: %s", m.KeyPath)
+		key, err = keygen.LoadPrivateKey(m.KeyPath)
+	}
+	if err != nil {
+		ui.Error("Private key could not be loaded: %v", err)
+		return false
+	}
+	if k, ok := key.Public().(equaler); !ok || !k.Equal(pub) {
+		ui.Error("Private key does not match the certificate")
+		return false
+	}
+	ui.Success("Private key matches the certificate")
+	return true
+}
+
+func init() {
+	inspectCmd.Flags().BoolVar(&inspectNoOCSPFlag, "no-ocsp", false, "skip the OCSP status query")
+	rootCmd.AddCommand(inspectCmd)
+}
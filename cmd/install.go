@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trustctl/pkg/trustctl"
+)
+
+var (
+	installCertFlag     string
+	installKeyFlag      string
+	installDomainsFlag  string
+	installVerifyFlag   string
+	installTimeoutFlag  time.Duration
+	installNoReloadFlag bool
+	installTLSProfile   string
+	installHSTSFlag     string
+	installHTTP2Flag    bool
+	installHTTP3Flag    bool
+	installServerFlag   string
+)
+
+var installCmd = &cobra.Command{
+	Use:   "install",
+	Short: "Install an existing certificate into a web server, HAProxy, Caddy, Postfix or Dovecot",
+	Long: "Point the nginx or Apache vhosts, or the lighttpd $HTTP[\"host\"] conditionals, for the domains at a certificate obtained elsewhere, " +
+		"check the configuration and reload, " +
+		"rolling back if the server does not serve it. The certificate is not registered for renewal.\n\n" +
+		"With --server tomcat the certificate is written into the keystore of the TLS connectors in server.xml (PKCS#12, " +
+		"or JKS through keytool) and Tomcat is restarted; --server-config-dir is then the directory holding server.xml. " +
+		"With --server haproxy the key, certificate and chain are written as one PEM file where the TLS bind lines of haproxy.cfg " +
+		"load it (crt-list, crt directory or crt file) and handed to the running HAProxy through the runtime API, or HAProxy is reloaded. " +
+		"With --server caddy the certificate is loaded into the running Caddy through its admin API ($CADDY_ADMIN, default localhost:2019), " +
+		"which stops Caddy issuing its own for those names; without the API the files in Caddy's certificate storage are replaced. " +
+		"With --server postfix, dovecot or mail (both) smtpd_tls_cert_file and smtpd_tls_key_file in main.cf and ssl_cert and ssl_key " +
+		"in Dovecot's 10-ssl.conf are set and the services reloaded; the certificate is then checked on port 25 with STARTTLS and on 993.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if installCertFlag == "" || installKeyFlag == "" {
+			return errors.New("--cert and --key are required")
+		}
+		var domains []string
+		for _, d := range strings.Split(installDomainsFlag, ",") {
+			if d = strings.TrimSpace(d); d != "" {
+				domains = append(domains, d)
+			}
+		}
+		var hsts time.Duration
+		if installHSTSFlag != "" {
+			var err error
+			if hsts, err = parseHSTSMaxAge(installHSTSFlag); err != nil {
+				return err
+			}
+			if hsts == 0 {
+				return errors.New("--hsts needs a positive max-age; use hsts set --max-age 0 to withdraw a policy")
+			}
+		}
+		return trustctl.Install(trustctl.InstallOptions{
+			CertPath:   installCertFlag,
+			KeyPath:    installKeyFlag,
+			Domains:    domains,
+			VerifyAddr: installVerifyFlag,
+			Timeout:    installTimeoutFlag,
+			NoReload:   installNoReloadFlag,
+			TLSProfile: installTLSProfile,
+			HSTS:       hsts,
+			HTTP2:      installHTTP2Flag,
+			HTTP3:      installHTTP3Flag,
+			Server:     installServerFlag,
+		})
+	},
+}
+
+func init() {
+	installCmd.Flags().StringVar(&installCertFlag, "cert", "", "PEM certificate followed by its chain, e.g. fullchain.pem (required)")
+	installCmd.Flags().StringVar(&installKeyFlag, "key", "", "PEM private key, or a pkcs11: or tpm: key URI (required)")
+	installCmd.Flags().StringVar(&installDomainsFlag, "domains", "", "Comma-separated vhost names to configure (default: every name on the certificate)")
+	installCmd.Flags().StringVar(&installVerifyFlag, "verify-addr", "", "TLS endpoint checked for the certificate after the reload (default: the listen addresses of the edited vhosts)")
+	installCmd.Flags().DurationVar(&installTimeoutFlag, "timeout", 0, "How long to wait for the reload to take effect (default 30s)")
+	installCmd.Flags().BoolVar(&installNoReloadFlag, "no-reload", false, "Only edit the configuration and print the reload command")
+	installCmd.Flags().StringVar(&installTLSProfile, "tls-profile", "", "Mozilla TLS profile of added 443 vhosts: modern, intermediate or old (default from the configuration file, else intermediate)")
+	installCmd.Flags().BoolVar(&installHTTP2Flag, "http2", false, "Enable HTTP/2 in an added 443 vhost (listen ... http2 or http2 on, by nginx version; Protocols h2 for Apache)")
+	installCmd.Flags().BoolVar(&installHTTP3Flag, "http3", false, "Enable HTTP/3 in an added nginx 443 block (listen 443 quic and an Alt-Svc header; nginx 1.25+)")
+	installCmd.Flags().StringVar(&installHSTSFlag, "hsts", "", "Also send Strict-Transport-Security with this max-age (seconds or e.g. 365d; 730d when given without a value); undo with hsts remove")
+	installCmd.Flags().Lookup("hsts").NoOptDefVal = "730d"
+	installCmd.Flags().StringVar(&installServerFlag, "server", "", "Server to configure: nginx, apache, lighttpd, tomcat, haproxy, caddy, postfix, dovecot or mail (default: the running web server)")
+	rootCmd.AddCommand(installCmd)
+}
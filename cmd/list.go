@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trustctl/internal/metadata"
+	"github.com/trustctl/trustctl/internal/ui"
+)
+
+var (
+	listLabelFlags     []string
+	listExpiringWithin string
+	listCAFlag         string
+	listSortFlag       string
+)
+
+var listCmd = &cobra.Command{
+	Use:         "list",
+	Short:       "List managed certificates",
+	Long:        "List certificates managed by trustctl with their expiry, validation method, CA and labels",
+	Annotations: map[string]string{jsonAnnotation: "true"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		selector, err := metadata.ParseLabels(listLabelFlags)
+		if err != nil {
+			return err
+		}
+
+		var within time.Duration
+		if listExpiringWithin != "" {
+			if within, err = parseDuration(listExpiringWithin); err != nil {
+				return fmt.Errorf("invalid --expiring-within: %w", err)
+			}
+		}
+
+		certs, err := metadata.LoadMatching(selector)
+		if err != nil {
+			ui.Error("failed to list certificates: %v", err)
+			return fmt.Errorf("failed to list certificates: %w", err)
+		}
+		certs = filterCertificates(certs, within, listCAFlag)
+		if err := sortCertificates(certs, listSortFlag); err != nil {
+			return err
+		}
+		if jsonOutput() {
+			out := make([]listEntry, 0, len(certs))
+			for _, m := range certs {
+				e := listEntry{Domain: m.Domains[0], Domains: m.Domains, Validation: m.ValidationMethod, CA: caLabel(m), Labels: m.Labels}
+				if !m.ExpiresAt.IsZero() {
+					e.ExpiresAt = &m.ExpiresAt
+				}
+				out = append(out, e)
+			}
+			return writeResult(cmd, out, nil)
+		}
+		if len(certs) == 0 {
+			ui.Warning("No certificates found")
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "DOMAIN\tEXPIRES\tVALIDATION\tCA\tLABELS")
+		for _, m := range certs {
+			expires := "-"
+			if !m.ExpiresAt.IsZero() {
+				expires = m.ExpiresAt.Format("2006-01-02")
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", m.Domains[0], expires, m.ValidationMethod, caLabel(m), m.LabelString())
+		}
+		return w.Flush()
+	},
+}
+
+// listEntry is one certificate in the --output json result of list.
+type listEntry struct {
+	Domain     string            `json:"domain"`
+	Domains    []string          `json:"domains"`
+	ExpiresAt  *time.Time        `json:"expires_at,omitempty"`
+	Validation string            `json:"validation"`
+	CA         string            `json:"ca"`
+	Labels     map[string]string `json:"labels,omitempty"`
+}
+
+// filterCertificates keeps certificates expiring within the given window (when
+// non-zero) and issued by a CA matching ca (when non-empty).
+func filterCertificates(certs []*metadata.CertMetadata, within time.Duration, ca string) []*metadata.CertMetadata {
+	deadline := time.Now().Add(within)
+	var out []*metadata.CertMetadata
+	for _, m := range certs {
+		if within > 0 && (m.ExpiresAt.IsZero() || m.ExpiresAt.After(deadline)) {
+			continue
+		}
+		if ca != "" && !strings.Contains(strings.ToLower(caLabel(m)), strings.ToLower(ca)) {
+			continue
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+// sortCertificates orders certificates by domain, expiry or issue date.
+// Certificates without a known expiry sort last when ordering by expiry.
+func sortCertificates(certs []*metadata.CertMetadata, key string) error {
+	var less func(a, b *metadata.CertMetadata) bool
+	switch key {
+	case "", "domain":
+		less = func(a, b *metadata.CertMetadata) bool { return a.Domains[0] < b.Domains[0] }
+	case "expiry":
+		less = func(a, b *metadata.CertMetadata) bool {
+			if a.ExpiresAt.IsZero() || b.ExpiresAt.IsZero() {
+				return !a.ExpiresAt.IsZero()
+			}
+			return a.ExpiresAt.Before(b.ExpiresAt)
+		}
+	case "issued":
+		less = func(a, b *metadata.CertMetadata) bool { return a.IssuedAt.Before(b.IssuedAt) }
+	default:
+		return fmt.Errorf("unknown sort key: %s (expected domain, expiry or issued)", key)
+	}
+	sort.SliceStable(certs, func(i, j int) bool { return less(certs[i], certs[j]) })
+	return nil
+}
+
+// parseDuration extends time.ParseDuration with day (d) and week (w) units, e.g. 30d or 2w.
+func parseDuration(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			v, err := strconv.Atoi(n)
+			if err != nil || v < 0 {
+				return 0, fmt.Errorf("invalid duration %q", s)
+			}
+			return time.Duration(v) * unit, nil
+		}
+	}
+	return time.ParseDuration(s)
+}
+
+// caLabel returns a short human name for the CA a certificate was issued by.
+func caLabel(m *metadata.CertMetadata) string {
+	if m.ServerURL != "" {
+		return m.ServerURL
+	}
+	if m.CA != "" {
+		return m.CA
+	}
+	return "letsencrypt"
+}
+
+func init() {
+	listCmd.Flags().StringArrayVar(&listLabelFlags, "label", nil, "Only show certificates with this key=value label (repeatable)")
+	listCmd.Flags().StringVar(&listExpiringWithin, "expiring-within", "", "Only show certificates expiring within this window (e.g. 30d, 2w, 72h)")
+	listCmd.Flags().StringVar(&listCAFlag, "ca", "", "Only show certificates issued by a matching CA (e.g. letsencrypt or part of the server URL)")
+	listCmd.Flags().StringVar(&listSortFlag, "sort", "domain", "Sort by domain|expiry|issued")
+
+	rootCmd.AddCommand(listCmd)
+}
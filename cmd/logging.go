@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trustctl/internal/paths"
+	"github.com/trustctl/trustctl/internal/ui"
+)
+
+var (
+	verboseFlag bool
+	logFileFlag string
+	logFile     *ui.LogFile
+)
+
+// openLog adds the JSON log file (--log-file, default <logs>/trustctl.log) to the
+// active sink, so every run leaves a detailed record even when the console is quiet.
+// A log file that cannot be opened is reported and skipped.
+func openLog(cmd *cobra.Command) {
+	if logFileFlag == "-" {
+		return
+	}
+	path := logFileFlag
+	if path == "" {
+		path = filepath.Join(paths.Logs(), "trustctl.log")
+	}
+	f, err := ui.OpenLogFile(path, map[string]interface{}{"command": cmd.CommandPath(), "pid": os.Getpid()})
+	if err != nil {
+		ui.Warning("cannot write log file %s: %v", path, err)
+		return
+	}
+	logFile = f
+	ui.SetSink(ui.Tee(ui.CurrentSink(), f))
+}
+
+// closeLog records how the command ended and closes the log file.
+func closeLog(err error) {
+	if logFile == nil {
+		return
+	}
+	if err != nil {
+		logFile.Message(ui.LevelError, err.Error())
+	}
+	logFile.Close()
+}
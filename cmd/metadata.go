@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trustctl/internal/metadata"
+	"github.com/trustctl/trustctl/internal/ui"
+)
+
+var metadataCmd = &cobra.Command{
+	Use:   "metadata",
+	Short: "Manage stored certificate metadata",
+}
+
+var metadataEncryptCmd = &cobra.Command{
+	Use:   "encrypt",
+	Short: "Encrypt all certificate metadata at rest",
+	Long: "Generate a metadata key (default <credentials>/metadata.key, or $TRUSTCTL_METADATA_KEY_FILE) if none exists " +
+		"and rewrite every metadata record encrypted with AES-256-GCM. Keep a copy of the key with your backups.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !metadata.EncryptionEnabled() {
+			ui.StepStart("Generating metadata key: %s", metadata.KeyFile())
+			if err := metadata.GenerateEncryptionKey(); err != nil {
+				return fmt.Errorf("failed to create metadata key: %w", err)
+			}
+			ui.Success("Metadata key created (chmod 600)")
+		}
+		n, err := rewriteAllMetadata()
+		if err != nil {
+			return err
+		}
+		ui.Success("Encrypted metadata for %d certificate(s)", n)
+		return nil
+	},
+}
+
+var metadataDecryptCmd = &cobra.Command{
+	Use:   "decrypt",
+	Short: "Rewrite all certificate metadata in plaintext and retire the metadata key",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !metadata.EncryptionEnabled() {
+			ui.Info("Metadata encryption is not enabled")
+			return nil
+		}
+		metadata.DisableEncryption()
+		n, err := rewriteAllMetadata()
+		if err != nil {
+			return err
+		}
+		retired := metadata.KeyFile() + ".retired"
+		if err := os.Rename(metadata.KeyFile(), retired); err != nil {
+			return fmt.Errorf("metadata decrypted but key could not be retired: %w", err)
+		}
+		ui.Success("Decrypted metadata for %d certificate(s); key moved to %s", n, retired)
+		return nil
+	},
+}
+
+// rewriteAllMetadata loads and stores every record so it is written with the current encryption setting.
+func rewriteAllMetadata() (int, error) {
+	domains, err := metadata.ListAll()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return 0, err
+	}
+	for _, d := range domains {
+		l, err := metadata.LockDomain(d)
+		if err != nil {
+			return 0, err
+		}
+		m, err := metadata.Load(d)
+		if err == nil {
+			err = m.Store()
+		}
+		l.Release()
+		if err != nil {
+			return 0, fmt.Errorf("failed to rewrite metadata for %s: %w", d, err)
+		}
+	}
+	return len(domains), nil
+}
+
+func init() {
+	metadataCmd.AddCommand(metadataEncryptCmd, metadataDecryptCmd)
+
+	rootCmd.AddCommand(metadataCmd)
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trustctl/internal/migrate"
+	"github.com/trustctl/trustctl/internal/secret"
+	"github.com/trustctl/trustctl/internal/ui"
+)
+
+var (
+	migrateOutFlag        string
+	migrateInFlag         string
+	migratePassphraseFile string
+	migrateForceFlag      bool
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Move certificates, keys, accounts and metadata between hosts",
+}
+
+var migrateExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export all trustctl state into an encrypted archive",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if migrateOutFlag == "" {
+			return errors.New("--out is required")
+		}
+		pass, err := readPassphrase(migratePassphraseFile, true)
+		if err != nil {
+			return err
+		}
+
+		ui.StepStart("Exporting trustctl state to %s", migrateOutFlag)
+		f, err := os.OpenFile(migrateOutFlag, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return err
+		}
+		man, err := migrate.Export(f, pass)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(migrateOutFlag)
+			ui.Error("export failed: %v", err)
+			return fmt.Errorf("export failed: %w", err)
+		}
+		ui.Success("Exported %d certificate(s) to %s", len(man.Domains), migrateOutFlag)
+		return nil
+	},
+}
+
+var migrateImportCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Restore an archive produced by migrate export",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if migrateInFlag == "" {
+			return errors.New("--in is required")
+		}
+		pass, err := readPassphrase(migratePassphraseFile, false)
+		if err != nil {
+			return err
+		}
+
+		ui.StepStart("Importing trustctl state from %s", migrateInFlag)
+		f, err := os.Open(migrateInFlag)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		man, err := migrate.Import(f, pass, migrateForceFlag)
+		if err != nil {
+			ui.Error("import failed: %v", err)
+			return fmt.Errorf("import failed: %w", err)
+		}
+		ui.Success("Imported %d certificate(s) exported from %s on %s", len(man.Domains), man.Hostname, man.CreatedAt.Format("2006-01-02"))
+		ui.Info("Paths rewritten from %s to the local layout", man.Certs)
+		return nil
+	},
+}
+
+// readPassphrase reads the archive passphrase from file ("-" for stdin), $TRUSTCTL_PASSPHRASE,
+// or an interactive prompt; confirm asks twice when creating an archive.
+func readPassphrase(file string, confirm bool) ([]byte, error) {
+	return secret.Read(secret.Source{Name: "archive passphrase", File: file, Env: "TRUSTCTL_PASSPHRASE", Confirm: confirm})
+}
+
+func init() {
+	migrateExportCmd.Flags().StringVar(&migrateOutFlag, "out", "", "Archive file to write (required)")
+	migrateImportCmd.Flags().StringVar(&migrateInFlag, "in", "", "Archive file to read (required)")
+	migrateImportCmd.Flags().BoolVar(&migrateForceFlag, "force", false, "Overwrite certificates that already exist on this host")
+	for _, c := range []*cobra.Command{migrateExportCmd, migrateImportCmd} {
+		c.Flags().StringVar(&migratePassphraseFile, "passphrase-file", "", "File containing the archive passphrase, - for stdin (default $TRUSTCTL_PASSPHRASE, else prompt)")
+		migrateCmd.AddCommand(c)
+	}
+
+	rootCmd.AddCommand(migrateCmd)
+}
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"errors"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trustctl/pkg/trustctl"
+)
+
+var (
+	modifyAddFlags    []string
+	modifyRemoveFlags []string
+)
+
+var modifyCmd = &cobra.Command{
+	Use:   "modify <domain>",
+	Short: "Add or remove names on an existing certificate",
+	Long: "Change the SAN list of the certificate whose primary domain is <domain>, then generate a new key (unless it reuses its key) and CSR, " +
+		"reissue with the stored validation and CA settings, and reinstall it.",
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(modifyAddFlags) == 0 && len(modifyRemoveFlags) == 0 {
+			return errors.New("nothing to do: pass --add-domain and/or --remove-domain")
+		}
+		_, err := trustctl.Modify(cmd.Context(), args[0], modifyAddFlags, modifyRemoveFlags)
+		return err
+	},
+}
+
+func init() {
+	modifyCmd.Flags().StringSliceVar(&modifyAddFlags, "add-domain", nil, "Name to add (repeatable or comma-separated)")
+	modifyCmd.Flags().StringSliceVar(&modifyRemoveFlags, "remove-domain", nil, "Name to remove (repeatable or comma-separated)")
+
+	rootCmd.AddCommand(modifyCmd)
+}
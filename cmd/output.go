@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trustctl/internal/keystore"
+	"github.com/trustctl/trustctl/internal/ui"
+	"github.com/trustctl/trustctl/pkg/trustctl"
+)
+
+// Output formats for --output.
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// jsonAnnotation marks commands that print a structured result with --output json.
+const jsonAnnotation = "trustctl/output-json"
+
+var (
+	outputFlag  string
+	quietFlag   bool
+	noEmojiFlag bool
+)
+
+// jsonMessage is one progress message captured for the JSON result.
+type jsonMessage struct {
+	Level   string `json:"level"`
+	Message string `json:"message"`
+}
+
+// jsonResult is the object every command supporting --output json prints to stdout.
+type jsonResult struct {
+	Command  string        `json:"command"`
+	OK       bool          `json:"ok"`
+	Error    string        `json:"error,omitempty"`
+	Result   interface{}   `json:"result,omitempty"`
+	Messages []jsonMessage `json:"messages,omitempty"`
+}
+
+// jsonSink sends progress messages to stderr, so stdout carries only the result,
+// and keeps them for the result's messages.
+type jsonSink struct {
+	mu       sync.Mutex
+	console  ui.Console
+	messages []jsonMessage
+}
+
+func (s *jsonSink) Message(level ui.Level, msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.console.Message(level, msg)
+	if level == ui.LevelDebug && !s.console.Debug {
+		return
+	}
+	s.messages = append(s.messages, jsonMessage{Level: level.String(), Message: msg})
+}
+
+var (
+	captured      = &jsonSink{}
+	resultWritten bool
+)
+
+// jsonOutput reports whether --output json was given.
+func jsonOutput() bool {
+	return outputFlag == outputJSON
+}
+
+// outputSink validates --output for cmd and returns the sink messages go to: the
+// console configured by --quiet, --no-emoji, --verbose and NO_COLOR, on stderr only and
+// captured for the result with JSON output.
+func outputSink(cmd *cobra.Command) (ui.Sink, error) {
+	console := ui.Console{
+		Out:   os.Stdout,
+		Err:   os.Stderr,
+		Quiet: quietFlag,
+		Plain: noEmojiFlag,
+		Debug: verboseFlag,
+		Color: ui.ColorEnabled(os.Stdout) && ui.ColorEnabled(os.Stderr),
+	}
+	switch outputFlag {
+	case outputText:
+		return console, nil
+	case outputJSON:
+		if cmd.Annotations[jsonAnnotation] == "" {
+			return nil, fmt.Errorf("%s does not support --output json", cmd.CommandPath())
+		}
+		console.Out = os.Stderr
+		captured.console = console
+		return captured, nil
+	}
+	return nil, fmt.Errorf("unknown output format %q (expected text or json)", outputFlag)
+}
+
+// writeResult prints the JSON result of cmd carrying result and err, and returns err
+// so the exit status is unchanged.
+func writeResult(cmd *cobra.Command, result interface{}, err error) error {
+	cmd.SilenceUsage = true
+	resultWritten = true
+	r := jsonResult{Command: cmd.CommandPath(), OK: err == nil, Result: result}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	captured.mu.Lock()
+	r.Messages = captured.messages
+	captured.mu.Unlock()
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if werr := enc.Encode(&r); werr != nil && err == nil {
+		return werr
+	}
+	return err
+}
+
+// finishJSON prints a result for a JSON-capable command that failed before it
+// could write its own, e.g. on a flag error.
+func finishJSON(cmd *cobra.Command, err error) {
+	if !jsonOutput() || resultWritten || cmd == nil || cmd.Annotations[jsonAnnotation] == "" {
+		return
+	}
+	writeResult(cmd, nil, err)
+}
+
+// publicCertificate returns a copy of m that is safe to print: the PIN or module a
+// key URI may carry is removed.
+func publicCertificate(m *trustctl.Certificate) *trustctl.Certificate {
+	if m == nil {
+		return nil
+	}
+	shown := *m
+	shown.KeyURI = keystore.Public(m.KeyURI)
+	return &shown
+}
@@ -1,130 +1,236 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"math/rand"
 	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
-	"github.com/trustctl/trustctl/internal/ca"
-	"github.com/trustctl/trustctl/internal/creds"
-	"github.com/trustctl/trustctl/internal/dns"
 	"github.com/trustctl/trustctl/internal/metadata"
 	"github.com/trustctl/trustctl/internal/ui"
-	"github.com/trustctl/trustctl/internal/validation"
+	"github.com/trustctl/trustctl/pkg/trustctl"
+)
+
+var (
+	renewLabelFlags    []string
+	renewStagingFlag   bool
+	renewDirectoryFlag string
+	renewTimeoutFlag   time.Duration
+	renewReuseKeyFlag  bool
+	renewNewKeyFlag    bool
+	renewRotateEvery   int
+	renewRotateDays    int
+	renewCertNameFlag  string
+	renewDomainsFlag   string
+	renewForceFlag     bool
+	renewBeforeDays    int
+	renewJitterFlag    time.Duration
+	renewPreHook       string
+	renewPostHook      string
+	renewDeployHook    string
 )
 
 var renewCmd = &cobra.Command{
 	Use:   "renew",
 	Short: "Renew certificates for registered domains",
-	Long:  "Automatically renew certificates using stored metadata (domains, validation method, credentials, installer type)",
+	Long: "Automatically renew certificates using stored metadata (domains, validation method, credentials, installer type). " +
+		"Only certificates inside the renewal window suggested by the CA, or in the last third of their lifetime, are renewed unless --force is given.",
+	Annotations: map[string]string{jsonAnnotation: "true"},
 	RunE: func(cmd *cobra.Command, args []string) error {
+		selector, err := metadata.ParseLabels(renewLabelFlags)
+		if err != nil {
+			return err
+		}
+
+		if renewStagingFlag && renewDirectoryFlag != "" {
+			return errors.New("--staging and --acme-directory are mutually exclusive")
+		}
+		if renewReuseKeyFlag && renewNewKeyFlag {
+			return errors.New("--reuse-key and --no-reuse-key are mutually exclusive")
+		}
+		opts := trustctl.RenewOptions{DirectoryURL: renewDirectoryFlag, Staging: renewStagingFlag, ReuseKey: renewReuseKeyFlag, NewKey: renewNewKeyFlag, Force: renewForceFlag}
+		opts.KeyRotation = keyRotation(cmd, renewRotateEvery, renewRotateDays)
+		if cmd.Flags().Changed("renew-days-before-expiry") {
+			opts.RenewDaysBeforeExpiry = &renewBeforeDays
+		}
+		if cmd.Flags().Changed("pre-hook") {
+			opts.PreHook = &renewPreHook
+		}
+		if cmd.Flags().Changed("post-hook") {
+			opts.PostHook = &renewPostHook
+		}
+		if cmd.Flags().Changed("deploy-hook") {
+			opts.DeployHook = &renewDeployHook
+		}
+
 		ui.StepStart("Checking for certificates to renew...")
 
-		domains, err := metadata.ListAll()
+		certs, err := trustctl.Certificates(selector)
 		if err != nil {
 			ui.Error("failed to list certificates: %v", err)
 			return fmt.Errorf("failed to list certificates: %w", err)
 		}
-		if len(domains) == 0 {
+		if certs, err = selectCertificates(certs, renewCertNameFlag, renewDomainsFlag); err != nil {
+			return err
+		}
+		results := []renewResult{}
+		if len(certs) == 0 {
 			ui.Warning("No certificates found for renewal")
+			nothingToDo = true
+			if jsonOutput() {
+				return writeResult(cmd, results, nil)
+			}
 			return nil
 		}
 
-		ui.Info("Found %d certificate(s) to check for renewal", len(domains))
+		ui.Info("Found %d certificate(s) to check for renewal", len(certs))
+		if renewJitterFlag > 0 {
+			delay := time.Duration(rand.Int63n(int64(renewJitterFlag)))
+			ui.Info("Waiting %s before contacting the CA (--jitter)", delay.Round(time.Second))
+			select {
+			case <-time.After(delay):
+			case <-cmd.Context().Done():
+				ui.Warning("Renewal check interrupted")
+				return cmd.Context().Err()
+			}
+		}
 
-		for _, domain := range domains {
-			if err := renewDomain(domain); err != nil {
+		var firstFailure error
+		failed, renewed := 0, 0
+		for _, m := range certs {
+			if err := cmd.Context().Err(); err != nil {
+				ui.Warning("Renewal check interrupted")
+				return err
+			}
+			domain := m.Domains[0]
+			ctx, cancel := withTimeout(cmd.Context(), renewTimeoutFlag)
+			rec, err := trustctl.Renew(ctx, domain, opts)
+			cancel()
+			res := renewResult{Domain: domain, Outcome: renewRenewed}
+			if rec != nil {
+				res.DurationMS, res.CAResponse = rec.DurationMS, rec.CAResponse
+			}
+			if errors.Is(err, trustctl.ErrNotDue) || errors.Is(err, trustctl.ErrRateLimited) || errors.Is(err, trustctl.ErrRevoked) || errors.Is(err, trustctl.ErrLocked) {
+				if errors.Is(err, trustctl.ErrLocked) {
+					ui.Warning("Skipping %s: %v", domain, err)
+				}
+				res.Outcome, res.Reason = renewSkipped, err.Error()
+				results = append(results, res)
+				continue
+			} else if err != nil {
 				ui.Error("renewal failed for %s: %v", domain, err)
+				res.Outcome, res.Error = renewFailed, err.Error()
+				results = append(results, res)
+				if failed++; firstFailure == nil {
+					firstFailure = err
+				}
 				// Continue with next domain instead of stopping
+				continue
 			}
+			results = append(results, res)
+			renewed++
 		}
 
 		ui.Success("Renewal check complete")
-		return nil
+		// The exit status carries the class of the first failure for automation
+		var summary error
+		if failed > 0 {
+			cmd.SilenceUsage = true
+			summary = fmt.Errorf("%d of %d renewal(s) failed, first: %w", failed, len(certs), firstFailure)
+		} else if renewed == 0 {
+			nothingToDo = true
+		}
+		if jsonOutput() {
+			return writeResult(cmd, results, summary)
+		}
+		return summary
 	},
 }
 
-func renewDomain(domain string) error {
-	ui.StepStart("Renewing certificate for %s", domain)
-
-	// Load metadata
-	meta, err := metadata.Load(domain)
-	if err != nil {
-		return fmt.Errorf("failed to load metadata for %s: %w", domain, err)
-	}
+// Outcomes of one certificate in the --output json result of renew.
+const (
+	renewRenewed = "renewed"
+	renewSkipped = "skipped" // not due, rate limited, revoked or locked by another run; Reason says which
+	renewFailed  = "failed"
+)
 
-	ui.Info("Validation method: %s | Domains: %s | CA: %s",
-		meta.ValidationMethod, strings.Join(meta.Domains, ","),
-		func() string {
-			if meta.ServerURL != "" {
-				return meta.ServerURL
-			}
-			return "Let's Encrypt"
-		}())
+// renewResult is the outcome of one certificate in the --output json result of renew.
+type renewResult struct {
+	Domain     string `json:"domain"`
+	Outcome    string `json:"outcome"`
+	Reason     string `json:"reason,omitempty"`
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms,omitempty"`
+	CAResponse string `json:"ca_response,omitempty"`
+}
 
-	// Verify credentials exist
-	if err := creds.AssertPermissions(meta.CredentialsPath); err != nil {
-		return fmt.Errorf("credentials check failed: %w", err)
-	}
-	ui.StepDone("Credentials verified")
+func init() {
+	renewCmd.Flags().StringArrayVar(&renewLabelFlags, "label", nil, "Only renew certificates with this key=value label (repeatable)")
+	renewCmd.Flags().StringVar(&renewCertNameFlag, "cert-name", "", "Only renew the certificate with this primary domain")
+	renewCmd.Flags().StringVar(&renewDomainsFlag, "domains", "", "Only renew certificates covering any of these comma-separated names")
+	renewCmd.Flags().BoolVar(&renewForceFlag, "force", false, "Renew even certificates that are not due yet (combine with --cert-name to avoid CA rate limits)")
+	renewCmd.Flags().DurationVar(&renewJitterFlag, "jitter", 0, "Wait a random time up to this long, e.g. 1h, before contacting the CA so a fleet run from timers does not renew at once")
+	renewCmd.Flags().BoolVar(&renewStagingFlag, "staging", false, "Renew from the CA's staging environment (kept for later renewals)")
+	renewCmd.Flags().DurationVar(&renewTimeoutFlag, "timeout", 0, "Give up on a certificate after this long, e.g. 10m, and move on to the next (default: no limit)")
+	renewCmd.Flags().BoolVar(&renewReuseKeyFlag, "reuse-key", false, "Keep the existing private key from now on (kept for later renewals)")
+	renewCmd.Flags().BoolVar(&renewNewKeyFlag, "no-reuse-key", false, "Generate a new private key again on every renewal (kept for later renewals)")
+	renewCmd.Flags().IntVar(&renewRotateEvery, "key-rotation-renewals", 0, "Rotate a reused key on every Nth renewal, archiving the old one; 0 turns it off (kept for later renewals)")
+	renewCmd.Flags().IntVar(&renewRotateDays, "key-rotation-days", 0, "Rotate a reused key once it is N days old, archiving the old one; 0 turns it off (kept for later renewals)")
+	renewCmd.Flags().IntVar(&renewBeforeDays, "renew-days-before-expiry", 0, "Renew the selected certificates this many days before expiry from now on; 0 goes back to the default (kept for later renewals)")
+	renewCmd.Flags().StringVar(&renewPreHook, "pre-hook", "", "Shell command run before validation; \"\" removes it (kept for later renewals)")
+	renewCmd.Flags().StringVar(&renewPostHook, "post-hook", "", "Shell command run after every renewal attempt; \"\" removes it (kept for later renewals)")
+	renewCmd.Flags().StringVar(&renewDeployHook, "deploy-hook", "", "Shell command run after a renewed certificate is saved and installed; \"\" removes it (kept for later renewals)")
+	renewCmd.Flags().StringVar(&renewDirectoryFlag, "acme-directory", "", "Renew from this ACME directory URL (kept for later renewals)")
 
-	// Resolve CA using stored settings
-	resolver := ca.NewResolver(meta.CredentialsPath)
-	caClient, err := resolver.Resolve(meta.ServerURL, meta.HMACIDCred, "")
-	if err != nil {
-		return fmt.Errorf("CA resolution failed: %w", err)
-	}
+	rootCmd.AddCommand(renewCmd)
+}
 
-	// Setup validation using stored method
-	var dnsProvider dns.DNSProvider
-	if meta.ValidationMethod == "dns" {
-		if meta.DNSProvider == "" {
-			return fmt.Errorf("dns validation configured but no dns_provider in metadata")
-		}
-		ui.StepStart("Loading DNS provider: %s", meta.DNSProvider)
-		loader := dns.NewPluginLoader(pluginsPath, meta.CredentialsPath)
-		dnsProvider, err = loader.Load(meta.DNSProvider)
-		if err != nil {
-			return fmt.Errorf("failed to load dns provider: %w", err)
-		}
-		ui.Success("DNS provider loaded")
+// keyRotation returns the key rotation policy set by the --key-rotation-* flags of
+// cmd, or nil when neither was given.
+func keyRotation(cmd *cobra.Command, renewals, days int) *trustctl.KeyRotation {
+	if !cmd.Flags().Changed("key-rotation-renewals") && !cmd.Flags().Changed("key-rotation-days") {
+		return nil
 	}
+	return &trustctl.KeyRotation{EveryRenewals: renewals, EveryDays: days}
+}
 
-	// Validate domains
-	ui.StepStart("Validating domains for renewal...")
-	validator := validation.NewValidator(meta.ValidationMethod, dnsProvider)
-	if err := validator.Validate(meta.Domains); err != nil {
-		return fmt.Errorf("validation failed: %w", err)
+// selectCertificates narrows certs to the one whose primary domain is certName and to
+// those covering any of the comma-separated names in domains; empty filters match all.
+// A filter that matches nothing is an error, so a typo does not look like "nothing due".
+func selectCertificates(certs []*trustctl.Certificate, certName, domains string) ([]*trustctl.Certificate, error) {
+	if certName == "" && domains == "" {
+		return certs, nil
 	}
-	ui.Success("Validation successful")
-
-	// Request renewed certificate
-	ui.StepStart("Requesting renewed certificate...")
-	certMeta, err := caClient.RequestCertificate(meta.Domains)
-	if err != nil {
-		return fmt.Errorf("certificate request failed: %w", err)
+	want := map[string]bool{}
+	for _, d := range strings.Split(domains, ",") {
+		if d = strings.ToLower(strings.TrimSpace(d)); d != "" {
+			want[d] = true
+		}
 	}
-	ui.Success("Certificate renewed by %s", certMeta.Issuer)
-
-	// Install renewed certificate
-	ui.StepStart("Installing renewed certificate...")
-	if err := ca.InstallCertificate(certMeta); err != nil {
-		return fmt.Errorf("installation failed: %w", err)
+	var out []*trustctl.Certificate
+	for _, m := range certs {
+		if certName != "" && !strings.EqualFold(m.Domains[0], certName) {
+			continue
+		}
+		covered := len(want) == 0
+		for _, d := range m.Domains {
+			covered = covered || want[strings.ToLower(d)]
+		}
+		if covered {
+			out = append(out, m)
+		}
 	}
-	ui.Success("Certificate reinstalled")
-
-	// Update metadata with renewal timestamp
-	meta.LastRenewalAt = time.Now()
-	meta.RenewalAttempts++
-	if err := meta.Store(); err != nil {
-		ui.Warning("failed to update renewal metadata: %v", err)
+	if len(out) == 0 {
+		switch {
+		case certName != "" && domains != "":
+			return nil, fmt.Errorf("certificate %s does not cover %s", certName, domains)
+		case certName != "":
+			return nil, fmt.Errorf("no managed certificate named %s", certName)
+		default:
+			return nil, fmt.Errorf("no managed certificate covers %s", domains)
+		}
 	}
-
-	ui.Success("Renewal complete for %s", domain)
-	return nil
-}
-
-func init() {
-	rootCmd.AddCommand(renewCmd)
+	return out, nil
 }
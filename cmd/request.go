@@ -1,9 +1,9 @@
 package cmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"log"
 	"os"
 	"strings"
 	"time"
@@ -11,254 +11,293 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/trustctl/trustctl/internal/account"
 	"github.com/trustctl/trustctl/internal/ca"
-	"github.com/trustctl/trustctl/internal/creds"
-	"github.com/trustctl/trustctl/internal/dns"
+	"github.com/trustctl/trustctl/internal/install"
 	"github.com/trustctl/trustctl/internal/keygen"
 	"github.com/trustctl/trustctl/internal/metadata"
+	"github.com/trustctl/trustctl/internal/secret"
 	"github.com/trustctl/trustctl/internal/ui"
-	"github.com/trustctl/trustctl/internal/validation"
+	"github.com/trustctl/trustctl/pkg/trustctl"
 )
 
 var (
-	domainsFlag     string
-	validationFlag  string
-	dnsProviderFlag string
-	serverURLFlag   string
-	hmacIDFlag      string
-	hmacKeyFlag     string
-	webrootFlag     string
-	emailFlag       string
-	credentialsPath = "/opt/trustctl/credentials"
-	pluginsPath     = "/opt/trustctl/plugins"
-	certsPath       = "/opt/trustctl/certs"
+	domainsFlag        string
+	validationFlag     string
+	dnsProviderFlag    string
+	serverURLFlag      string
+	hmacIDFlag         string
+	hmacKeyFlag        string
+	hmacKeyFileFlag    string
+	webrootFlag        string
+	httpAddrFlag       string
+	emailFlag          string
+	labelFlags         []string
+	accountFlag        string
+	keyTypeFlag        string
+	keyFormatFlag      string
+	rsaKeySizeFlag     int
+	reuseKeyFlag       bool
+	keyURIFlag         string
+	tpmFlag            bool
+	csrFlag            string
+	rotateRenewalsFlag int
+	rotateDaysFlag     int
+	renewBeforeFlag    int
+	forceRenewalFlag   bool
+	noInstallFlag      bool
+	serverFlag         string
+	tlsProfileFlag     string
+	hstsFlag           string
+	http2Flag          bool
+	http3Flag          bool
+	includeRootFlag    bool
+	combinedFlag       bool
+	agreeTOSFlag       bool
+	caFlag             string
+	stagingFlag        bool
+	directoryFlag      string
+	preferredChainFlag string
+	approverEmailFlag  string
+	profileFlag        string
+	preHookFlag        string
+	postHookFlag       string
+	deployHookFlag     string
+	manualChallenge    string
+	manualAuthHook     string
+	manualCleanupHook  string
+	requestTimeoutFlag time.Duration
+	domainsFileFlag    string
+	parallelFlag       int
 )
 
 var requestCmd = &cobra.Command{
-	Use:   "request",
-	Short: "Request a certificate (like certbot)",
-	Long:  "Request and install a certificate, auto-generating keys and storing account credentials",
+	Use:         "request",
+	Short:       "Request a certificate (like certbot)",
+	Long:        "Request and install a certificate, auto-generating keys and storing account credentials. Without --domains on a terminal, an interactive wizard offers the names found in the web server's vhosts.",
+	Annotations: map[string]string{jsonAnnotation: "true"},
 	RunE: func(cmd *cobra.Command, args []string) error {
-		if domainsFlag == "" {
-			return errors.New("--domains is required")
+		if domainsFileFlag != "" && (domainsFlag != "" || csrFlag != "") {
+			return errors.New("--domains-file replaces --domains and --csr")
 		}
-
-		domains := strings.Split(domainsFlag, ",")
-		for i := range domains {
-			domains[i] = strings.TrimSpace(domains[i])
+		if domainsFlag == "" && csrFlag == "" && domainsFileFlag == "" {
+			if jsonOutput() || !secret.IsTerminal(os.Stdin) {
+				return errors.New("--domains is required")
+			}
+			if err := runWizard(); err != nil {
+				return err
+			}
 		}
-
-		primaryDomain := domains[0]
-		certDir := fmt.Sprintf("%s/%s", certsPath, primaryDomain)
-
-		ui.StepStart("🤝 trustctl - Certificate Automation Agent")
-		ui.Info("Processing %d domain(s): %s", len(domains), strings.Join(domains, ", "))
-
-		// Setup directory structure
-		ui.StepStart("Creating certificate directory: %s", certDir)
-		if err := os.MkdirAll(certDir, 0700); err != nil {
-			ui.Error("failed to create cert directory: %v", err)
-			return err
+		if stagingFlag && directoryFlag != "" {
+			return errors.New("--staging and --acme-directory are mutually exclusive")
 		}
-		ui.Success("Directory created with chmod 700")
-
-		// Generate private key
-		ui.StepStart("Generating 2048-bit RSA private key...")
-		privateKey, err := keygen.GeneratePrivateKey()
-		if err != nil {
-			ui.Error("failed to generate private key: %v", err)
-			return err
+		if httpAddrFlag != "" && webrootFlag != "" {
+			return errors.New("--http-addr and --webroot are mutually exclusive")
 		}
-
-		keyPath := fmt.Sprintf("%s/privkey.pem", certDir)
-		if err := keygen.SavePrivateKey(privateKey, keyPath); err != nil {
-			ui.Error("failed to save private key: %v", err)
-			return err
+		if serverFlag != "" && noInstallFlag {
+			return errors.New("--server and --no-install are mutually exclusive")
+		}
+		if (manualAuthHook != "" || manualCleanupHook != "") && !strings.EqualFold(validationFlag, "manual") {
+			return errors.New("--manual-auth-hook and --manual-cleanup-hook need --validation manual")
 		}
-		ui.Success("Private key saved: %s (chmod 600)", keyPath)
 
-		// Generate CSR
-		ui.StepStart("Generating Certificate Signing Request (CSR)...")
-		csr, err := keygen.GenerateCSR(privateKey, domains)
+		labels, err := metadata.ParseLabels(labelFlags)
 		if err != nil {
-			ui.Error("failed to generate CSR: %v", err)
 			return err
 		}
-
-		csrPath := fmt.Sprintf("%s/csr.pem", certDir)
-		if err := keygen.SaveCSR(csr, csrPath); err != nil {
-			ui.Error("failed to save CSR: %v", err)
-			return err
+		var hsts time.Duration
+		if hstsFlag != "" {
+			if noInstallFlag {
+				return errors.New("--hsts and --no-install are mutually exclusive")
+			}
+			if hsts, err = parseHSTSMaxAge(hstsFlag); err != nil {
+				return err
+			}
+			if hsts == 0 {
+				return errors.New("--hsts needs a positive max-age; use hsts set --max-age 0 to withdraw a policy")
+			}
 		}
-		ui.Success("CSR generated and saved: %s", csrPath)
 
-		// Setup HTTP validation
-		if vtype := strings.ToLower(validationFlag); vtype == "" || vtype == "http" {
-			if webrootFlag == "" {
-				webrootFlag = "/var/www/html"
+		var domains []string
+		if domainsFlag != "" {
+			domains = strings.Split(domainsFlag, ",")
+			for i := range domains {
+				domains[i] = strings.TrimSpace(domains[i])
 			}
-			ui.StepStart("Setting up HTTP validation with webroot: %s", webrootFlag)
-			challengeDir := fmt.Sprintf("%s/.well-known/acme-challenge", webrootFlag)
-			if err := os.MkdirAll(challengeDir, 0755); err != nil {
-				ui.Error("failed to create challenge directory: %v", err)
+		}
+		var csr []byte
+		if csrFlag != "" {
+			if csr, err = os.ReadFile(csrFlag); err != nil {
 				return err
 			}
-			ui.Success("Challenge directory ready: %s", challengeDir)
 		}
 
-		// Check/create account credentials
-		caName := "letsencrypt"
-		if serverURLFlag != "" {
-			caName = "enterprise-ca"
+		// An unset --key-type lets a configured minimum RSA key size pick the default
+		keyType := ""
+		if cmd.Flags().Changed("key-type") {
+			keyType = keyTypeFlag
 		}
-
-		ui.StepStart("Checking %s account...", caName)
-		var acc *account.AccountInfo
-		if account.Exists(caName) {
-			ui.Info("Account found for %s", caName)
-			acc, _ = account.Load(caName)
-		} else {
-			ui.StepStart("Creating new %s account...", caName)
-			if emailFlag == "" {
-				emailFlag = "admin@" + primaryDomain
-			}
-			acc, err = account.Create(caName, emailFlag)
+		if rsaKeySizeFlag != 0 {
+			t, err := keygen.RSAKeyType(rsaKeySizeFlag)
 			if err != nil {
-				ui.Error("failed to create account: %v", err)
 				return err
 			}
-			if err := acc.Store(); err != nil {
-				ui.Error("failed to store account: %v", err)
-				return err
+			if keyType != "" && !strings.EqualFold(keyType, t) {
+				return fmt.Errorf("--rsa-key-size %d conflicts with --key-type %s", rsaKeySizeFlag, keyType)
 			}
-			ui.Success("Account created and stored: %s", acc.AccountURL)
-		}
-
-		ui.Info("Checking credential permissions...")
-		if err := creds.AssertPermissions(credentialsPath); err != nil {
-			ui.Error("credentials permission check failed: %v", err)
-			return fmt.Errorf("credentials permission check failed: %w", err)
+			keyType = t
 		}
 
-		// Resolve CA
-		ui.StepStart("Resolving Certificate Authority...")
-		resolver := ca.NewResolver(credentialsPath)
-		caClient, err := resolver.Resolve(serverURLFlag, hmacIDFlag, hmacKeyFlag)
-		if err != nil {
-			ui.Error("CA resolution failed: %v", err)
-			return fmt.Errorf("CA resolution failed: %w", err)
-		}
-		if serverURLFlag == "" {
-			ui.Info("Using Let's Encrypt (ACME v2)")
-		} else {
-			ui.Info("Using enterprise CA: %s", serverURLFlag)
+		// --ca letsencrypt,zerossl: the first CA issues, the others are fallbacks
+		cas := strings.Split(caFlag, ",")
+		for i := range cas {
+			cas[i] = strings.TrimSpace(cas[i])
 		}
-		ui.StepDone("CA resolved")
 
-		// Detect validation method
-		vtype := strings.ToLower(validationFlag)
-		if vtype == "" {
-			vtype = "http"
-		}
-
-		// DNS plugin loader (only needed for dns validation)
-		var dnsProvider dns.DNSProvider
-		if vtype == "dns" {
-			if dnsProviderFlag == "" {
-				ui.Error("--dns-provider is required for dns validation")
-				return errors.New("--dns-provider is required for dns validation")
+		// The enterprise HMAC key (or EAB HMAC key for an ACME CA) should not travel on the command line
+		hmacKey := hmacKeyFlag
+		if serverURLFlag != "" || hmacIDFlag != "" {
+			if hmacKeyFlag != "" {
+				ui.Warning("--hmac-key is visible in the process list; prefer --hmac-key-file, TRUSTCTL_HMAC_KEY or the prompt")
 			}
-			ui.StepStart("Loading DNS provider plugin: %s", dnsProviderFlag)
-			loader := dns.NewPluginLoader(pluginsPath, credentialsPath)
-			dnsProvider, err = loader.Load(dnsProviderFlag)
+			key, err := secret.Read(secret.Source{Name: "HMAC key", Value: hmacKeyFlag, File: hmacKeyFileFlag, Env: "TRUSTCTL_HMAC_KEY"})
 			if err != nil {
-				ui.Error("failed to load dns provider: %v", err)
-				return fmt.Errorf("failed to load dns provider: %w", err)
+				return err
 			}
-			ui.Success("Loaded DNS provider: %s", dnsProviderFlag)
+			hmacKey = string(key)
 		}
 
-		// Run validation
-		ui.StepStart("🔐 Validating domains via %s...", strings.ToUpper(vtype))
-		validator := validation.NewValidator(vtype, dnsProvider)
-		if vtype == "http" && webrootFlag != "" {
-			// Pass webroot to validator (if implemented)
-			ui.Info("Using webroot: %s", webrootFlag)
+		opts := trustctl.RequestOptions{
+			Domains:        domains,
+			Validation:     validationFlag,
+			DNSProvider:    dnsProviderFlag,
+			CA:             cas[0],
+			FallbackCAs:    cas[1:],
+			DirectoryURL:   directoryFlag,
+			Staging:        stagingFlag,
+			ServerURL:      serverURLFlag,
+			HMACID:         hmacIDFlag,
+			HMACKey:        hmacKey,
+			Webroot:        webrootFlag,
+			HTTPAddr:       httpAddrFlag,
+			Email:          emailFlag,
+			Labels:         labels,
+			Account:        accountFlag,
+			KeyType:        keyType,
+			KeyFormat:      keyFormatFlag,
+			ReuseKey:       reuseKeyFlag,
+			KeyURI:         keyURIFlag,
+			TPM:            tpmFlag,
+			CSR:            csr,
+			KeyRotation:    keyRotation(cmd, rotateRenewalsFlag, rotateDaysFlag),
+			PreferredChain: preferredChainFlag,
+			ApproverEmail:  approverEmailFlag,
+			Profile:        profileFlag,
+			ForceRenewal:   forceRenewalFlag,
+			AgreeTOS:       agreeTOSFlag,
+			NoInstall:      noInstallFlag,
+			Server:         serverFlag,
+			TLSProfile:     tlsProfileFlag,
+			HSTS:           hsts,
+			HTTP2:          http2Flag,
+			HTTP3:          http3Flag,
+			Bundle:         trustctl.BundleOptions{IncludeRoot: includeRootFlag, Combined: combinedFlag},
+
+			RenewDaysBeforeExpiry: renewBeforeFlag,
+			Hooks:                 trustctl.Hooks{Pre: preHookFlag, Post: postHookFlag, Deploy: deployHookFlag},
+			Manual:                trustctl.ManualValidation{Challenge: manualChallenge, AuthHook: manualAuthHook, CleanupHook: manualCleanupHook},
 		}
-		if err := validator.Validate(domains); err != nil {
-			ui.Error("validation failed: %v", err)
-			return fmt.Errorf("validation failed: %w", err)
+		if domainsFileFlag != "" {
+			return requestBatch(cmd, opts)
 		}
-		ui.Success("✅ Validation successful for: %s", strings.Join(domains, ", "))
 
-		// Request certificate from CA
-		ui.StepStart("📝 Requesting certificate from CA...")
-		certMeta, err := caClient.RequestCertificate(domains)
-		if err != nil {
-			ui.Error("certificate request failed: %v", err)
-			return fmt.Errorf("certificate request failed: %w", err)
+		ctx, cancel := withTimeout(cmd.Context(), requestTimeoutFlag)
+		defer cancel()
+		cert, err := trustctl.Request(ctx, opts)
+		if errors.Is(err, trustctl.ErrDuplicate) {
+			ui.Info("A valid certificate for exactly these names already exists (%s, expires %s)", cert.CertPath, cert.ExpiresAt.Format("2006-01-02"))
+			ui.Info("Nothing to do; pass --force-renewal to issue a new one anyway")
+			nothingToDo = true
+			if jsonOutput() {
+				return writeResult(cmd, &requestResult{Certificate: publicCertificate(cert)}, nil)
+			}
+			return nil
 		}
-		ui.Success("📜 Certificate issued by %s", certMeta.Issuer)
-
-		// Save certificate files
-		ui.StepStart("💾 Saving certificate files...")
-		fullchainPath := fmt.Sprintf("%s/fullchain.pem", certDir)
-		if err := os.WriteFile(fullchainPath, certMeta.PEM, 0644); err != nil {
-			ui.Error("failed to save certificate: %v", err)
-			return err
+		var tos *trustctl.TermsError
+		if errors.As(err, &tos) {
+			ui.Info("Read the terms at %s and rerun with --agree-tos to accept them", tos.URL)
 		}
-		ui.Success("Certificate saved: %s", fullchainPath)
-
-		// Install certificate (installer is a stub for now)
-		ui.StepStart("🔗 Installing certificate for %s", strings.Join(domains, ", "))
-		if err := ca.InstallCertificate(certMeta); err != nil {
-			ui.Error("installation failed: %v", err)
-			return fmt.Errorf("installation failed: %w", err)
+		if errors.Is(err, context.DeadlineExceeded) {
+			ui.Info("Gave up after --timeout %s; published challenges were removed", requestTimeoutFlag)
 		}
-		ui.Success("Certificate installed")
-
-		// Save metadata for renewal
-		ui.StepStart("📋 Saving certificate metadata for renewal...")
-		meta := &metadata.CertMetadata{
-			Domains:          domains,
-			ValidationMethod: vtype,
-			DNSProvider:      dnsProviderFlag,
-			ServerURL:        serverURLFlag,
-			HMACIDCred:       hmacIDFlag,
-			CredentialsPath:  credentialsPath,
-			CertPath:         fullchainPath,
-			KeyPath:          keyPath,
-			IssuedAt:         time.Now(),
-			RenewalAttempts:  0,
+		if errors.Is(err, trustctl.ErrEABRequired) {
+			ui.Info("Pass the EAB key ID and HMAC key from the CA's dashboard with --hmac-id and --hmac-key-file")
 		}
-		if err := meta.Store(); err != nil {
-			ui.Warning("failed to save metadata: %v", err)
-		} else {
-			ui.Success("Metadata saved for renewal")
+		if jsonOutput() {
+			var res *requestResult
+			if err == nil {
+				res = &requestResult{Issued: true, Certificate: publicCertificate(cert)}
+			}
+			return writeResult(cmd, res, err)
 		}
-
-		ui.Success("✨ Certificate request complete!")
-		ui.Info("Files stored in: %s", certDir)
-		ui.Info("Next: Configure your web server to use %s and %s", fullchainPath, keyPath)
-		ui.Info("To renew: trustctl renew")
-
-		return nil
+		return err
 	},
 }
 
+// requestResult is the --output json result of request. Issued is false when a valid
+// certificate for the same names already existed.
+type requestResult struct {
+	Issued      bool                  `json:"issued"`
+	Certificate *trustctl.Certificate `json:"certificate"`
+}
+
 func init() {
-	requestCmd.Flags().StringVar(&domainsFlag, "domains", "", "Comma-separated domains (required)")
-	requestCmd.Flags().StringVar(&validationFlag, "validation", "", "Validation method: dns|http|email (default http)")
+	requestCmd.Flags().StringVar(&domainsFlag, "domains", "", "Comma-separated domains and IP addresses (required unless --csr)")
+	requestCmd.Flags().StringVar(&validationFlag, "validation", "", "Validation method: dns|http|email|manual (default http; email needs an enterprise CA; manual prints what to publish)")
 	requestCmd.Flags().StringVar(&dnsProviderFlag, "dns-provider", "", "DNS provider name (for dns validation)")
+	requestCmd.Flags().StringVar(&manualChallenge, "manual-challenge", "dns", "Challenge to answer with --validation manual: dns (a TXT record) or http (a token file)")
+	requestCmd.Flags().StringVar(&manualAuthHook, "manual-auth-hook", "", "With --validation manual, run this shell command to publish each response ($TRUSTCTL_DOMAIN, $TRUSTCTL_VALIDATION, $TRUSTCTL_TOKEN) instead of prompting; needed for unattended renewals")
+	requestCmd.Flags().StringVar(&manualCleanupHook, "manual-cleanup-hook", "", "With --validation manual, run this shell command to remove each response once the CA has checked it")
+	requestCmd.Flags().StringVar(&approverEmailFlag, "approver-email", "", "Address the enterprise CA sends the validation email to (for email validation; default: the CA's first)")
+	requestCmd.Flags().StringVar(&caFlag, "ca", "", "ACME CA: "+strings.Join(ca.ACMENames(), ", ")+" (default letsencrypt); a comma-separated list adds fallbacks tried in order, e.g. letsencrypt,zerossl")
+	requestCmd.Flags().BoolVar(&stagingFlag, "staging", false, "Use the CA's staging environment (Let's Encrypt, Buypass) to avoid production rate limits")
+	requestCmd.Flags().StringVar(&directoryFlag, "acme-directory", "", "ACME directory URL to use instead of the CA's (e.g. a local Pebble)")
 	requestCmd.Flags().StringVar(&serverURLFlag, "serverurl", "", "Enterprise CA server URL (optional)")
-	requestCmd.Flags().StringVar(&hmacIDFlag, "hmac-id", "", "HMAC ID for enterprise CA (optional)")
-	requestCmd.Flags().StringVar(&hmacKeyFlag, "hmac-key", "", "HMAC key for enterprise CA (optional)")
-	requestCmd.Flags().StringVar(&webrootFlag, "webroot", "/var/www/html", "Webroot for HTTP validation (default /var/www/html)")
+	requestCmd.Flags().StringVar(&hmacIDFlag, "hmac-id", "", "HMAC ID for enterprise CA, or EAB key ID for an ACME CA (optional)")
+	requestCmd.Flags().StringVar(&hmacKeyFlag, "hmac-key", "", "HMAC key for enterprise CA or EAB HMAC key (prefer --hmac-key-file)")
+	requestCmd.Flags().StringVar(&hmacKeyFileFlag, "hmac-key-file", "", "File containing the HMAC key, - for stdin (default $TRUSTCTL_HMAC_KEY, else prompt)")
+	requestCmd.Flags().StringVar(&domainsFileFlag, "domains-file", "", "Request every certificate listed in this YAML (or .csv) file of specs with domains, validation, dns_provider, webroot, http_addr, installer, key_type and labels; other flags are the defaults")
+	requestCmd.Flags().IntVar(&parallelFlag, "parallel", 1, "With --domains-file, request this many certificates at the same time")
+	requestCmd.Flags().StringVar(&webrootFlag, "webroot", "", "Webroot for HTTP validation (default /var/www/html)")
+	requestCmd.Flags().StringVar(&httpAddrFlag, "http-addr", "", "Answer HTTP validation from trustctl's own listener on this address instead of the webroot, e.g. :8080 behind a port 80 redirect for rootless use (kept for renewals)")
 	requestCmd.Flags().StringVar(&emailFlag, "email", "", "Email for CA account (default admin@<domain>)")
+	requestCmd.Flags().StringArrayVar(&labelFlags, "label", nil, "Attach a key=value label to the certificate (repeatable)")
+	requestCmd.Flags().StringVar(&accountFlag, "account", account.DefaultName, "CA account profile to issue under (e.g. payments, staging)")
+	requestCmd.Flags().StringVar(&keyTypeFlag, "key-type", keygen.DefaultKeyType, "Private key type: "+strings.Join(keygen.KeyTypes, ", ")+" (kept for renewals)")
+	requestCmd.Flags().IntVar(&rsaKeySizeFlag, "rsa-key-size", 0, "RSA key size: 2048, 3072 or 4096 (shorthand for --key-type rsa<size>)")
+	requestCmd.Flags().BoolVar(&reuseKeyFlag, "reuse-key", false, "Keep this private key across renewals (for DANE/TLSA or key pinning; kept for renewals)")
+	requestCmd.Flags().IntVar(&renewBeforeFlag, "renew-days-before-expiry", 0, "Renew this many days before expiry, e.g. 7 for short-lived certificates (default $TRUSTCTL_RENEW_DAYS_BEFORE_EXPIRY, else the last third of the lifetime; kept for renewals)")
+	requestCmd.Flags().IntVar(&rotateRenewalsFlag, "key-rotation-renewals", 0, "With --reuse-key, generate a new key on every Nth renewal and archive the old one (kept for renewals)")
+	requestCmd.Flags().IntVar(&rotateDaysFlag, "key-rotation-days", 0, "With --reuse-key, generate a new key once the key is N days old and archive the old one (kept for renewals)")
+	requestCmd.Flags().StringVar(&keyURIFlag, "key-uri", "", "Sign with this HSM key instead of generating one, e.g. \"pkcs11:token=web;object=www?pin-source=/etc/trustctl/pin\" (no key file is written; kept for renewals)")
+	requestCmd.Flags().BoolVar(&tpmFlag, "tpm", false, "Generate and hold the private key in the host TPM 2.0; writes privkey.tss (a TSS2 key file for the OpenSSL tpm2 provider) instead of privkey.pem")
+	requestCmd.Flags().StringVar(&csrFlag, "csr", "", "Submit this PEM or DER CSR instead of generating a key and CSR (domains default to its names; renewals resubmit it)")
+	requestCmd.Flags().StringVar(&keyFormatFlag, "key-format", keygen.DefaultKeyFormat, "Private key file format: pkcs8 (\"PRIVATE KEY\"), pkcs1 (\"RSA/EC PRIVATE KEY\") or pkcs8-encrypted (AES-256 under the key passphrase) (kept for renewals)")
+	requestCmd.Flags().StringVar(&preferredChainFlag, "preferred-chain", "", "Install the chain leading to this root CN when the CA offers alternates (e.g. \"ISRG Root X1\"; kept for renewals)")
+	requestCmd.Flags().StringVar(&profileFlag, "profile", "", "ACME certificate profile the CA offers, e.g. tlsserver or shortlived (kept for renewals)")
+	requestCmd.Flags().StringVar(&preHookFlag, "pre-hook", "", "Shell command run before validation, e.g. to stop a server holding port 80 (kept for renewals)")
+	requestCmd.Flags().StringVar(&postHookFlag, "post-hook", "", "Shell command run after every issuance attempt, successful or not (kept for renewals)")
+	requestCmd.Flags().StringVar(&deployHookFlag, "deploy-hook", "", "Shell command run after a new certificate is saved and installed, with $TRUSTCTL_CERT_PATH, $TRUSTCTL_KEY_PATH and $TRUSTCTL_DOMAINS set (kept for renewals)")
+	requestCmd.Flags().BoolVar(&includeRootFlag, "include-root", false, "Keep the root certificate in chain.pem/fullchain.pem if the CA sends it")
+	requestCmd.Flags().BoolVar(&combinedFlag, "combined", false, "Also write combined.pem (private key + fullchain) for HAProxy-style servers")
+	requestCmd.Flags().BoolVar(&agreeTOSFlag, "agree-tos", false, "Accept the CA's terms of service when registering a new account")
+	requestCmd.Flags().DurationVar(&requestTimeoutFlag, "timeout", 0, "Abort the request after this long, e.g. 10m (default: no limit; Ctrl-C also aborts cleanly)")
+	requestCmd.Flags().BoolVar(&noInstallFlag, "no-install", false, "Only obtain and store the certificate, leaving web server configuration alone (certonly; kept for renewals)")
+	requestCmd.Flags().StringVar(&serverFlag, "server", "", "Server to install the certificate into: "+strings.Join(install.Servers(), ", ")+" (default: the running web server; kept for renewals)")
+	requestCmd.Flags().StringVar(&tlsProfileFlag, "tls-profile", "", "Mozilla TLS profile of 443 vhosts the installer adds: modern, intermediate or old (default from the configuration file, else intermediate)")
+	requestCmd.Flags().BoolVar(&http2Flag, "http2", false, "Enable HTTP/2 in a 443 vhost the installer adds (listen ... http2 or http2 on, by nginx version; Protocols h2 for Apache)")
+	requestCmd.Flags().BoolVar(&http3Flag, "http3", false, "Enable HTTP/3 in a 443 nginx block the installer adds (listen 443 quic and an Alt-Svc header; nginx 1.25+)")
+	requestCmd.Flags().StringVar(&hstsFlag, "hsts", "", "Also send Strict-Transport-Security with this max-age from the vhosts the certificate is installed into (seconds or e.g. 365d; 730d when given without a value); undo with hsts remove")
+	requestCmd.Flags().Lookup("hsts").NoOptDefVal = "730d"
+	requestCmd.Flags().BoolVar(&forceRenewalFlag, "force-renewal", false, "Issue even if a valid certificate for the same names exists")
 
 	rootCmd.AddCommand(requestCmd)
-
-	// Ensure logs directory exists
-	if err := os.MkdirAll("/opt/trustctl/logs", 0700); err != nil {
-		log.Println("warning: couldn't create logs dir:", err)
-	}
 }
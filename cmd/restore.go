@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trustctl/internal/install"
+	"github.com/trustctl/trustctl/internal/ui"
+)
+
+var (
+	restoreFileFlag string
+	restoreAtFlag   string
+	restoreRunFlag  string
+)
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "List and restore the vhost backups taken by the installer",
+	Long: "Without flags, list the recorded installer runs. --file lists the backups of one config file and with --at " +
+		"(the unix time shown, RFC 3339, or latest) restores one of them. --run puts back every file an installer run " +
+		"edited, undoing a whole install. The configuration is checked and the running server reloaded; the content " +
+		"replaced is backed up first, so a restore can be undone the same way.",
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		switch {
+		case restoreRunFlag != "" && restoreFileFlag != "":
+			return errors.New("--run and --file cannot be combined")
+		case restoreRunFlag != "":
+			return restoreRun(restoreRunFlag)
+		case restoreFileFlag != "":
+			return restoreFile(restoreFileFlag, restoreAtFlag)
+		case restoreAtFlag != "":
+			return errors.New("--at needs --file")
+		}
+		return listRuns()
+	},
+}
+
+func listRuns() error {
+	runs, err := install.Runs()
+	if err != nil {
+		return err
+	}
+	if len(runs) == 0 {
+		ui.Info("No installer runs recorded in %s", install.RunsDir())
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "RUN\tTIME\tKIND\tDOMAINS\tFILES")
+	for _, r := range runs {
+		kind := r.Kind
+		if r.Reverted {
+			kind += " (reverted)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r.ID, r.Time.Local().Format("2006-01-02 15:04:05"), kind, strings.Join(r.Domains, ","), strings.Join(r.Files(), " "))
+	}
+	return w.Flush()
+}
+
+func restoreRun(id string) error {
+	if id == "last" {
+		runs, err := install.Runs()
+		if err != nil {
+			return err
+		}
+		if len(runs) == 0 {
+			return errors.New("no installer runs recorded")
+		}
+		id = runs[0].ID
+	}
+	run, err := install.RevertRun(id)
+	if err != nil {
+		return err
+	}
+	ui.Success("Restored %d file(s) edited by %s run %s", len(run.Changes), run.Kind, run.ID)
+	return nil
+}
+
+func restoreFile(file, at string) error {
+	file, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+	if at == "" {
+		backups := install.FileBackups(file)
+		if len(backups) == 0 {
+			ui.Info("No trustctl backups of %s", file)
+			return nil
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "AT\tTIME\tBACKUP")
+		for _, b := range backups {
+			fmt.Fprintf(w, "%d\t%s\t%s\n", b.Time.Unix(), b.Time.Local().Format("2006-01-02 15:04:05"), b.Path)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		ui.Info("Restore one with --at <AT> (or --at latest)")
+		return nil
+	}
+	var when time.Time
+	if at != "latest" {
+		if when, err = parseBackupTime(at); err != nil {
+			return err
+		}
+	}
+	b, err := install.RestoreFile(file, when)
+	if err != nil {
+		return err
+	}
+	ui.Success("Restored %s from %s", file, b.Path)
+	return nil
+}
+
+// parseBackupTime accepts the unix seconds of a backup name or an RFC 3339 time.
+func parseBackupTime(s string) (time.Time, error) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(n, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --at %q: expected unix seconds, RFC 3339 or latest", s)
+	}
+	return t, nil
+}
+
+func init() {
+	restoreCmd.Flags().StringVar(&restoreFileFlag, "file", "", "Config file whose backups to list or restore")
+	restoreCmd.Flags().StringVar(&restoreAtFlag, "at", "", "Backup of --file to restore: unix time as listed, RFC 3339, or latest")
+	restoreCmd.Flags().StringVar(&restoreRunFlag, "run", "", "Installer run to undo, as listed (or last)")
+	rootCmd.AddCommand(restoreCmd)
+}
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trustctl/internal/acme"
+	"github.com/trustctl/trustctl/internal/metadata"
+	"github.com/trustctl/trustctl/internal/secret"
+	"github.com/trustctl/trustctl/internal/ui"
+	"github.com/trustctl/trustctl/pkg/trustctl"
+)
+
+var (
+	revokeDomainsFlag     string
+	revokeReasonFlag      string
+	revokeCertKeyFlag     bool
+	revokeDeleteFlag      bool
+	revokeHMACKeyFileFlag string
+)
+
+var revokeCmd = &cobra.Command{
+	Use:   "revoke",
+	Short: "Revoke a managed certificate at its CA",
+	Long: "Revoke the certificate at the CA that issued it, signed by the issuing account or the certificate's own key " +
+		"(ACME) or through the enterprise CA's API, and mark it revoked so renewals skip it. Request a new certificate to replace it.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if revokeDomainsFlag == "" {
+			return fmt.Errorf("--domains is required")
+		}
+		if _, err := acme.ParseRevocationReason(revokeReasonFlag); err != nil {
+			return err
+		}
+		failed := 0
+		for _, d := range strings.Split(revokeDomainsFlag, ",") {
+			d = strings.TrimSpace(d)
+			if d == "" {
+				continue
+			}
+			opts := trustctl.RevokeOptions{Reason: revokeReasonFlag, UseCertKey: revokeCertKeyFlag, DeleteFiles: revokeDeleteFlag}
+			if m, err := metadata.Load(d); err == nil && m.ServerURL != "" {
+				key, err := secret.Read(secret.Source{Name: "HMAC key", File: revokeHMACKeyFileFlag, Env: "TRUSTCTL_HMAC_KEY"})
+				if err != nil {
+					return err
+				}
+				opts.HMACKey = string(key)
+			}
+			if _, err := trustctl.Revoke(cmd.Context(), d, opts); err != nil {
+				ui.Error("%s: %v", d, err)
+				failed++
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d certificate(s) could not be revoked", failed)
+		}
+		return nil
+	},
+}
+
+func init() {
+	revokeCmd.Flags().StringVar(&revokeDomainsFlag, "domains", "", "Comma-separated primary domains of the certificates to revoke")
+	revokeCmd.Flags().StringVar(&revokeReasonFlag, "reason", "", "Revocation reason: unspecified, keyCompromise, affiliationChanged, superseded or cessationOfOperation")
+	revokeCmd.Flags().BoolVar(&revokeCertKeyFlag, "use-cert-key", false, "Sign the ACME revocation with the certificate's private key instead of the account key")
+	revokeCmd.Flags().BoolVar(&revokeDeleteFlag, "delete-files", false, "Remove the certificate, chain and private key files once revoked")
+	revokeCmd.Flags().StringVar(&revokeHMACKeyFileFlag, "hmac-key-file", "", "File containing the enterprise CA HMAC key, - for stdin (default $TRUSTCTL_HMAC_KEY, else prompt)")
+	rootCmd.AddCommand(revokeCmd)
+}
@@ -1,31 +1,168 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/trustctl/trustctl/internal/metadata"
+	"github.com/trustctl/trustctl/internal/secret"
+	"github.com/trustctl/trustctl/pkg/trustctl"
+)
+
+var (
+	strictCryptoFlag    bool
+	fipsFlag            bool
+	minRSAKeySizeFlag   int
+	storeFlag           string
+	serverConfigDirFlag string
+	checkPwnedKeysFlag  bool
+	proxyFlag           string
+	keyPassphraseFile   string
+	pkcs11ModuleFlag    string
+	tpmDeviceFlag       string
+	homeFlag            string
+	reloadCommandFlag   string
+	lockTimeoutFlag     time.Duration
 )
 
 var rootCmd = &cobra.Command{
 	Use:   "trustctl",
 	Short: "trustctl - certificate automation agent",
 	Long:  "trustctl automates certificate issuance and renewal for Let's Encrypt and enterprise CAs.",
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if serverConfigDirFlag == "" {
+			serverConfigDirFlag = os.Getenv("TRUSTCTL_SERVER_CONFIG_DIR")
+		}
+		if reloadCommandFlag == "" {
+			reloadCommandFlag = os.Getenv("TRUSTCTL_RELOAD_COMMAND")
+		}
+		cfgFile, err := loadConfig(cmd)
+		if err != nil {
+			return err
+		}
+		renewDays := cfgFile.RenewDaysBeforeExpiry
+		if v := os.Getenv("TRUSTCTL_RENEW_DAYS_BEFORE_EXPIRY"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("invalid TRUSTCTL_RENEW_DAYS_BEFORE_EXPIRY: %w", err)
+			}
+			renewDays = n
+		}
+		var backupMaxAge time.Duration
+		if cfgFile.Backups.MaxAge != "" {
+			if backupMaxAge, err = parseDuration(cfgFile.Backups.MaxAge); err != nil {
+				return fmt.Errorf("invalid backups.max_age in the configuration file: %w", err)
+			}
+		}
+		sink, err := outputSink(cmd)
+		if err != nil {
+			return err
+		}
+		err = trustctl.Open(trustctl.Config{
+			Store:           storeFlag,
+			StrictCrypto:    strictCryptoFlag,
+			FIPS:            fipsFlag || os.Getenv("TRUSTCTL_FIPS") == "1",
+			MinRSAKeySize:   minRSAKeySizeFlag,
+			ServerConfigDir: serverConfigDirFlag,
+			ReloadCommand:   reloadCommandFlag,
+			TemplatesDir:    cfgFile.TemplatesDir,
+			TLSProfile:      cfgFile.TLSProfile,
+			LockTimeout:     lockTimeoutFlag,
+			CheckPwnedKeys:  checkPwnedKeysFlag || os.Getenv("TRUSTCTL_CHECK_PWNEDKEYS") == "1",
+			Proxy:           proxyFlag,
+			KeyPassphrase:   readKeyPassphrase,
+			PKCS11Module:    pkcs11ModuleFlag,
+			PKCS11PIN:       readPKCS11PIN,
+			TPMDevice:       tpmDeviceFlag,
+			Sink:            sink,
+
+			RenewDaysBeforeExpiry: renewDays,
+			BackupRetention:       trustctl.BackupRetention{KeepLast: cfgFile.Backups.KeepLast, MaxAge: backupMaxAge},
+			Home:                  homeFlag,
+			Dirs:                  configDirs(cfgFile),
+		})
+		if err == nil {
+			openLog(cmd)
+		}
+		return err
+	},
+	PersistentPostRunE: func(cmd *cobra.Command, args []string) error {
+		return trustctl.Close()
+	},
 }
 
-// Execute executes the root command.
+// Execute executes the root command. Ctrl-C or SIGTERM cancels the command's
+// context, so in-flight orders stop and published challenges are cleaned up.
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	cmd, err := rootCmd.ExecuteContextC(ctx)
+	stop()
+	finishJSON(cmd, err)
+	closeLog(err)
+	if err != nil {
 		log.Println(err)
-		os.Exit(1)
+	}
+	if code := exitCode(err); code != exitOK {
+		os.Exit(code)
 	}
 }
 
-func init() {
-	// global persistent flags could be added here
-	if os.Geteuid() != 0 {
-		// Warn but allow non-root for development; production expects root-owned install
-		fmt.Fprintln(os.Stderr, "warning: running as non-root; production expects root ownership of /opt/trustctl")
+// withTimeout bounds ctx by d when d is positive.
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(ctx)
 	}
+	return context.WithTimeout(ctx, d)
+}
+
+func init() {
+	rootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return usageError{err}
+	})
+	rootCmd.PersistentFlags().StringVar(&homeFlag, "home", "", "Base directory of all trustctl state (default $TRUSTCTL_HOME, else /opt/trustctl for root and ~/.config/trustctl for other users)")
+	rootCmd.PersistentFlags().StringVar(&configFlag, "config", "", "Configuration file with defaults for request and the state directories (default $TRUSTCTL_CONFIG, else /etc/trustctl/config.yaml when present)")
+	rootCmd.PersistentFlags().BoolVar(&strictCryptoFlag, "strict-crypto", false, "Restrict keys, curves, hashes and TLS versions to an approved set (FIPS-style)")
+	rootCmd.PersistentFlags().BoolVar(&fipsFlag, "fips", false, "FIPS mode: strict crypto limited to RSA 2048/3072/4096, NIST P-curves and SHA-2 (default $TRUSTCTL_FIPS=1)")
+	rootCmd.PersistentFlags().IntVar(&minRSAKeySizeFlag, "min-rsa-key-size", 0, "Refuse RSA keys smaller than this (2048, 3072 or 4096) when generating, reusing or importing keys")
+	rootCmd.PersistentFlags().StringVar(&storeFlag, "store", metadata.BackendJSON, "Metadata store: json|sqlite")
+	rootCmd.PersistentFlags().BoolVar(&checkPwnedKeysFlag, "check-pwnedkeys", false, "Refuse new keys listed on pwnedkeys.com (or $TRUSTCTL_CHECK_PWNEDKEYS=1; sends only the key hash)")
+	rootCmd.PersistentFlags().StringVar(&proxyFlag, "proxy", "", "Proxy for CA and other outbound connections, e.g. http://proxy:3128 (default $HTTPS_PROXY/$HTTP_PROXY; $NO_PROXY is honored)")
+	rootCmd.PersistentFlags().StringVar(&keyPassphraseFile, "key-passphrase-file", "", "File with the passphrase of encrypted private keys, - for stdin (default: systemd credential trustctl-key-passphrase, $TRUSTCTL_KEY_PASSPHRASE, else prompt)")
+	rootCmd.PersistentFlags().StringVar(&pkcs11ModuleFlag, "pkcs11-module", "", "PKCS#11 library for --key-uri keys, e.g. /usr/lib/softhsm/libsofthsm2.so (default $TRUSTCTL_PKCS11_MODULE)")
+	rootCmd.PersistentFlags().StringVar(&tpmDeviceFlag, "tpm-device", "", "TPM 2.0 device for --tpm keys (default $TRUSTCTL_TPM_DEVICE, then /dev/tpmrm0)")
+	rootCmd.PersistentFlags().StringVarP(&outputFlag, "output", "o", outputText, "Output format: text, or json for a structured result on stdout (request, renew, list, status); progress goes to stderr")
+	rootCmd.PersistentFlags().BoolVarP(&quietFlag, "quiet", "q", false, "Only print warnings and errors, e.g. from cron")
+	rootCmd.PersistentFlags().BoolVar(&noEmojiFlag, "no-emoji", false, "Prefix messages with plain text instead of emoji (colors follow NO_COLOR and whether output is a terminal)")
+	rootCmd.PersistentFlags().BoolVarP(&verboseFlag, "verbose", "v", false, "Also print debug messages (server detection, CA requests, config test output)")
+	rootCmd.PersistentFlags().BoolVar(&verboseFlag, "debug", false, "Same as --verbose")
+	rootCmd.PersistentFlags().StringVar(&logFileFlag, "log-file", "", "JSON log of every message, debug included, rotated at 10 MiB (default <logs>/trustctl.log; - disables it)")
+	rootCmd.PersistentFlags().DurationVar(&lockTimeoutFlag, "lock-timeout", 0, "Wait this long, e.g. 5m, for another trustctl instance working on the same certificate or web server configuration instead of failing at once (renew skips such certificates)")
+	rootCmd.PersistentFlags().StringVar(&reloadCommandFlag, "reload-command", "", "Shell command that reloads the web server after installing, instead of systemctl (skipped without root) or nginx -s reload/apachectl graceful (or $TRUSTCTL_RELOAD_COMMAND)")
+	rootCmd.PersistentFlags().StringVar(&serverConfigDirFlag, "server-config-dir", "", "Only look for web server vhost files in this directory (or $TRUSTCTL_SERVER_CONFIG_DIR)")
+}
+
+// readKeyPassphrase reads the passphrase of encrypted private keys from
+// --key-passphrase-file, the trustctl-key-passphrase systemd credential,
+// $TRUSTCTL_KEY_PASSPHRASE or an interactive prompt.
+func readKeyPassphrase(confirm bool) ([]byte, error) {
+	return secret.Read(secret.Source{
+		Name:       "key passphrase",
+		File:       keyPassphraseFile,
+		Credential: "trustctl-key-passphrase",
+		Env:        "TRUSTCTL_KEY_PASSPHRASE",
+		Confirm:    confirm,
+	})
+}
+
+// readPKCS11PIN reads the PIN of a PKCS#11 token whose key URI carries neither
+// pin-value nor pin-source.
+func readPKCS11PIN() ([]byte, error) {
+	return secret.Read(secret.Source{Name: "PKCS#11 PIN", Credential: "trustctl-pkcs11-pin", Env: "TRUSTCTL_PKCS11_PIN"})
 }
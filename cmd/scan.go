@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trustctl/internal/install"
+	"github.com/trustctl/trustctl/internal/probe"
+	"github.com/trustctl/trustctl/internal/ui"
+)
+
+var (
+	scanStartTLSFlag   string
+	scanServerNameFlag string
+	scanTimeoutFlag    time.Duration
+	scanServerFlag     string
+)
+
+var scanCmd = &cobra.Command{
+	Use:   "scan <host[:port]>",
+	Short: "Grade an endpoint's TLS configuration",
+	Long: "Probe which protocol versions and weak cipher suites an endpoint accepts, check the served chain, " +
+		"OCSP stapling and HSTS, and print a grade with the TLS directives of the configured profile that trustctl installs.",
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		addr := args[0]
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(addr, "443")
+		}
+		_, port, _ := net.SplitHostPort(addr)
+		starttls := scanStartTLSFlag
+		if !cmd.Flags().Changed("starttls") {
+			starttls = probe.DefaultStartTLS(port)
+		}
+
+		ui.StepStart("Scanning %s...", addr)
+		rep, err := probe.Scan(addr, probe.Options{ServerName: scanServerNameFlag, StartTLS: starttls, Timeout: scanTimeoutFlag})
+		if err != nil {
+			ui.Error("handshake failed: %v", err)
+			return fmt.Errorf("scan of %s failed: %w", addr, err)
+		}
+
+		for _, f := range rep.Findings {
+			switch f.Severity {
+			case probe.SeverityFail:
+				ui.Error("%s", f.Message)
+			case probe.SeverityWarn:
+				ui.Warning("%s", f.Message)
+			default:
+				ui.Success("%s", f.Message)
+			}
+		}
+		ui.Info("Grade: %s", rep.Grade)
+
+		if rep.Grade != "A" && starttls == probe.StartTLSNone {
+			servers := []string{"nginx", "apache"}
+			switch strings.ToLower(scanServerFlag) {
+			case "apache", "nginx":
+				servers = []string{strings.ToLower(scanServerFlag)}
+			}
+			for _, srv := range servers {
+				directives, err := install.TLSDirectives(srv, "")
+				if err != nil {
+					return err
+				}
+				if srv == "apache" {
+					ui.Info("Suggested directives for the apache <VirtualHost *:443> block:")
+				} else {
+					ui.Info("Suggested directives for the nginx server block:")
+				}
+				fmt.Print(directives)
+			}
+			if rep.HSTS == "" {
+				host, _, _ := net.SplitHostPort(addr)
+				if scanServerNameFlag != "" {
+					host = scanServerNameFlag
+				}
+				ui.Info("Add the Strict-Transport-Security header with: trustctl hsts set %s", host)
+			}
+		}
+
+		if rep.Grade == "F" {
+			return fmt.Errorf("%s graded F", addr)
+		}
+		return nil
+	},
+}
+
+func init() {
+	scanCmd.Flags().StringVar(&scanStartTLSFlag, "starttls", "", "Upgrade with STARTTLS first: smtp|imap|pop3|ldap (default chosen by port)")
+	scanCmd.Flags().StringVar(&scanServerNameFlag, "servername", "", "SNI name to send (default the host)")
+	scanCmd.Flags().DurationVar(&scanTimeoutFlag, "timeout", 10*time.Second, "Timeout for each handshake")
+	scanCmd.Flags().StringVar(&scanServerFlag, "server", "", "Only suggest directives for this server: nginx|apache")
+
+	rootCmd.AddCommand(scanCmd)
+}
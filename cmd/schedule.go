@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trustctl/internal/paths"
+	"github.com/trustctl/trustctl/internal/schedule"
+	"github.com/trustctl/trustctl/internal/ui"
+)
+
+var scheduleJitterFlag time.Duration
+
+var scheduleCmd = &cobra.Command{
+	Use:   "schedule",
+	Short: "Manage the periodic renewal job (systemd timer on Linux, launchd on macOS)",
+}
+
+var scheduleInstallCmd = &cobra.Command{
+	Use:   "install",
+	Short: "Run `trustctl renew` twice a day",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		job, err := schedule.NewJob(paths.Logs())
+		if err != nil {
+			return err
+		}
+		job.Jitter = scheduleJitterFlag
+		p, err := job.Install()
+		if err != nil {
+			ui.Error("%v", err)
+			return err
+		}
+		ui.Success("Renewal job installed: %s", p)
+		return nil
+	},
+}
+
+var scheduleRemoveCmd = &cobra.Command{
+	Use:   "remove",
+	Short: "Remove the renewal job",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		p, err := schedule.Remove()
+		if err != nil {
+			ui.Error("%v", err)
+			return err
+		}
+		ui.Success("Renewal job removed: %s", p)
+		return nil
+	},
+}
+
+func init() {
+	scheduleInstallCmd.Flags().DurationVar(&scheduleJitterFlag, "jitter", schedule.DefaultJitter, "Delay each run by a random time up to this long; 0 runs exactly on schedule")
+	scheduleCmd.AddCommand(scheduleInstallCmd, scheduleRemoveCmd)
+	rootCmd.AddCommand(scheduleCmd)
+}
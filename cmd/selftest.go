@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trustctl/internal/acmetest"
+	"github.com/trustctl/trustctl/internal/dns"
+	"github.com/trustctl/trustctl/internal/selftest"
+	"github.com/trustctl/trustctl/internal/ui"
+)
+
+var (
+	selftestDirectoryFlag    string
+	selftestCAFileFlag       string
+	selftestChallTestSrvFlag string
+	selftestHTTPAddrFlag     string
+	selftestKeepFlag         bool
+	selftestShowStepsFlag    bool
+)
+
+var selftestCmd = &cobra.Command{
+	Use:   "selftest",
+	Short: "Run the issuance pipeline end to end against a mock CA",
+	Long: "Run request, validation (http and dns), issuance, installation and renewal in a temporary directory " +
+		"against an in-process mock ACME server and DNS provider. Nothing outside the temporary directory is read or changed. " +
+		"--acme-directory runs the pipeline against an external ACME server such as Pebble instead, " +
+		"with --challtestsrv publishing dns-01 records through pebble-challtestsrv.",
+	// The real state directories and metadata store are deliberately not opened
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error { return nil },
+	RunE: func(cmd *cobra.Command, args []string) error {
+		opts := selftest.Options{
+			DirectoryURL: selftestDirectoryFlag,
+			CAFile:       selftestCAFileFlag,
+			ChallTestSrv: selftestChallTestSrvFlag,
+			HTTPAddr:     selftestHTTPAddrFlag,
+			Keep:         selftestKeepFlag,
+		}
+		if selftestShowStepsFlag {
+			opts.Sink = ui.CurrentSink()
+		}
+
+		ui.StepStart("Running selftest...")
+		rep, err := selftest.Run(cmd.Context(), opts)
+		if err != nil {
+			ui.Error("selftest could not start: %v", err)
+			return err
+		}
+		failed := 0
+		for _, s := range rep.Steps {
+			switch {
+			case s.Err != nil:
+				failed++
+				ui.Error("%s: %v", s.Name, s.Err)
+			case s.Detail != "":
+				ui.Success("%s (%s)", s.Name, s.Detail)
+			default:
+				ui.Success("%s", s.Name)
+			}
+		}
+		if selftestKeepFlag {
+			ui.Info("Temporary state kept in %s", rep.Dir)
+		}
+		if failed > 0 {
+			return fmt.Errorf("selftest failed: %d of %d checks", failed, len(rep.Steps))
+		}
+		ui.Success("Selftest passed (%d checks)", len(rep.Steps))
+		return nil
+	},
+}
+
+func init() {
+	selftestCmd.Flags().StringVar(&selftestDirectoryFlag, "acme-directory", "", "Run against this external ACME directory (e.g. Pebble at "+acmetest.PebbleDirectory+")")
+	selftestCmd.Flags().StringVar(&selftestCAFileFlag, "acme-ca-file", "", "PEM bundle to trust for --acme-directory (Pebble's minica root)")
+	selftestCmd.Flags().StringVar(&selftestChallTestSrvFlag, "challtestsrv", "", "pebble-challtestsrv management URL for dns-01 with --acme-directory (e.g. "+acmetest.DefaultChallTestSrv+")")
+	selftestCmd.Flags().StringVar(&selftestHTTPAddrFlag, "http-addr", acmetest.DefaultHTTPAddr, "Address to serve http-01 tokens on for --acme-directory (Pebble's httpPort)")
+	selftestCmd.Flags().BoolVar(&selftestKeepFlag, "keep", false, "Keep the temporary directory for inspection")
+	selftestCmd.Flags().BoolVar(&selftestShowStepsFlag, "show-steps", false, "Show the pipeline's own progress output")
+
+	rootCmd.AddCommand(selftestCmd)
+
+	// --dns-provider challtestsrv lets request and renew run against Pebble
+	if u := os.Getenv(acmetest.EnvChallTestSrv); u != "" {
+		dns.Register(acmetest.ProviderName, acmetest.NewChallTestSrv(u))
+	}
+}
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trustctl/internal/metadata"
+	"github.com/trustctl/trustctl/internal/ui"
+	"github.com/trustctl/trustctl/pkg/trustctl"
+)
+
+var (
+	statusLabelFlags []string
+	statusWarnDays   int
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Print a health summary per certificate for monitoring",
+	Long: "Print one line per managed certificate: OK, EXPIRING <N days>, EXPIRED, REVOKED or RENEWAL-FAILING " +
+		"(the last renewal attempt failed). Exits non-zero when any certificate needs attention.",
+	Annotations: map[string]string{jsonAnnotation: "true"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		selector, err := metadata.ParseLabels(statusLabelFlags)
+		if err != nil {
+			return err
+		}
+		statuses, err := trustctl.Status(selector, trustctl.StatusOptions{WarnDays: statusWarnDays})
+		if err != nil {
+			return err
+		}
+		bad := 0
+		for _, s := range statuses {
+			if !s.OK() {
+				bad++
+			}
+		}
+		var attention error
+		if bad > 0 {
+			// The summary is the report; usage text would only clutter monitoring output
+			cmd.SilenceUsage = true
+			attention = fmt.Errorf("%d of %d certificate(s) need attention", bad, len(statuses))
+		}
+		if jsonOutput() {
+			return writeResult(cmd, statuses, attention)
+		}
+		if len(statuses) == 0 {
+			ui.Warning("No certificates found")
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for _, s := range statuses {
+			health := s.Health
+			if s.Health == trustctl.HealthExpiring {
+				health = fmt.Sprintf("%s %d days", s.Health, s.DaysLeft)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.Domain, health, s.ExpiresAt.Format("2006-01-02"), s.Detail)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		return attention
+	},
+}
+
+func init() {
+	statusCmd.Flags().StringArrayVar(&statusLabelFlags, "label", nil, "Only check certificates with this key=value label (repeatable)")
+	statusCmd.Flags().IntVar(&statusWarnDays, "warn-days", trustctl.DefaultWarnDays, "Report certificates expiring within this many days as EXPIRING")
+	rootCmd.AddCommand(statusCmd)
+}
@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trustctl/internal/install"
+)
+
+var templatesCmd = &cobra.Command{
+	Use:   "templates [name]",
+	Short: "Show the templates of the 443 vhosts and TLS directives the installer creates",
+	Long: "List the vhost templates and whether a file in the templates directory (templates_dir in the configuration " +
+		"file, default /etc/trustctl/templates) overrides the built-in one, or print the template in effect for name. " +
+		"The vhost templates are Go text/template files with .Domain, .ServerName, .CertPath, .KeyPath, .TLSProfile, .TLS, " +
+		".HTTP2, .HTTP3 and .Listen (the listen directives, or Apache's Protocols, for them); .TLS holds " +
+		"the directives rendered from the TLS template with .Name, .Version, .Protocols, .Ciphers, .PreferServerCiphers and .SessionTimeout of the profile.",
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 1 {
+			text, _, err := install.Template(args[0])
+			if err != nil {
+				return err
+			}
+			fmt.Print(text)
+			return nil
+		}
+		for _, name := range install.TemplateNames() {
+			_, source, err := install.Template(name)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s\t%s\n", name, source)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(templatesCmd)
+}
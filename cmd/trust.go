@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trustctl/internal/metadata"
+	"github.com/trustctl/trustctl/internal/truststore"
+	"github.com/trustctl/trustctl/internal/ui"
+)
+
+var (
+	trustFileFlag   string
+	trustDomainFlag string
+	trustNameFlag   string
+)
+
+var trustCmd = &cobra.Command{
+	Use:   "trust",
+	Short: "Manage internal CA roots in the system trust store",
+}
+
+var trustInstallRootCmd = &cobra.Command{
+	Use:   "install-root",
+	Short: "Install a CA root into the OS trust store",
+	Long: "Add an internal/enterprise CA root to the system trust store (update-ca-certificates or update-ca-trust) " +
+		"so certificates it issues validate on this host. The root comes from --file, or from the chain of a managed domain (--domain).",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		file := trustFileFlag
+		if trustDomainFlag != "" {
+			if file != "" {
+				return errors.New("use either --file or --domain, not both")
+			}
+			m, err := metadata.Load(trustDomainFlag)
+			if err != nil {
+				return fmt.Errorf("no metadata for %s: %w", trustDomainFlag, err)
+			}
+			file = m.CertPath
+		}
+		if file == "" {
+			return errors.New("--file or --domain is required")
+		}
+		root, err := truststore.ReadRoot(file)
+		if err != nil {
+			return err
+		}
+		store, err := truststore.Detect()
+		if err != nil {
+			return err
+		}
+		ui.StepStart("Installing %s into the %s trust store as %q...", root.Subject, store.Name, trustNameFlag)
+		p, err := store.Install(trustNameFlag, root)
+		if err != nil {
+			ui.Error("install failed: %v", err)
+			return err
+		}
+		ui.Success("Root installed: %s", p)
+		return nil
+	},
+}
+
+var trustRemoveRootCmd = &cobra.Command{
+	Use:   "remove-root",
+	Short: "Remove a CA root previously installed by trustctl",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store, err := truststore.Detect()
+		if err != nil {
+			return err
+		}
+		p, err := store.Remove(trustNameFlag)
+		if err != nil {
+			ui.Error("remove failed: %v", err)
+			return err
+		}
+		ui.Success("Root removed: %s", p)
+		return nil
+	},
+}
+
+var trustListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List CA roots installed by trustctl",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store, err := truststore.Detect()
+		if err != nil {
+			return err
+		}
+		names, err := store.List()
+		if err != nil {
+			return err
+		}
+		if len(names) == 0 {
+			ui.Info("No roots installed by trustctl in %s", store.AnchorDir)
+			return nil
+		}
+		for _, n := range names {
+			fmt.Println(n)
+		}
+		return nil
+	},
+}
+
+func init() {
+	trustInstallRootCmd.Flags().StringVar(&trustFileFlag, "file", "", "PEM file holding the root (or a chain ending in it)")
+	trustInstallRootCmd.Flags().StringVar(&trustDomainFlag, "domain", "", "Take the root from the chain of this managed domain")
+	for _, c := range []*cobra.Command{trustInstallRootCmd, trustRemoveRootCmd} {
+		c.Flags().StringVar(&trustNameFlag, "name", "enterprise-ca", "Anchor name in the trust store")
+	}
+
+	trustCmd.AddCommand(trustInstallRootCmd, trustRemoveRootCmd, trustListCmd)
+	rootCmd.AddCommand(trustCmd)
+}
@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trustctl/internal/metadata"
+	"github.com/trustctl/trustctl/internal/secret"
+	"github.com/trustctl/trustctl/internal/truststore"
+	"github.com/trustctl/trustctl/internal/ui"
+)
+
+var (
+	javaKeystoreFlag string
+	javaAliasFlag    string
+	javaRemoveFlag   bool
+	javaNoTrackFlag  bool
+	javaPassFileFlag string
+)
+
+var trustJavaCmd = &cobra.Command{
+	Use:   "java <domain>",
+	Short: "Insert or refresh a domain's issuing chain in a Java truststore",
+	Long: "Import the CA certificates of the domain's chain into a Java truststore (e.g. $JAVA_HOME/lib/security/cacerts) " +
+		"as <alias>-0, <alias>-1, ..., replacing entries from earlier runs. The truststore is recorded in the domain's " +
+		"metadata and refreshed after every renewal. The password is read from " + truststore.JavaStorepassEnv + " (default changeit).",
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		domain := args[0]
+		if javaKeystoreFlag == "" {
+			return errors.New("--keystore is required")
+		}
+		keystore, err := filepath.Abs(javaKeystoreFlag)
+		if err != nil {
+			return err
+		}
+		alias := javaAliasFlag
+		if alias == "" {
+			alias = "trustctl-" + domain
+		}
+
+		lk, err := metadata.LockDomain(domain)
+		if err != nil {
+			return err
+		}
+		defer lk.Release()
+		meta, err := metadata.Load(domain)
+		if err != nil {
+			return fmt.Errorf("no metadata for %s: %w", domain, err)
+		}
+		pass := truststore.DefaultJavaStorepass()
+		if javaPassFileFlag != "" {
+			p, err := secret.Read(secret.Source{Name: "truststore password", File: javaPassFileFlag})
+			if err != nil {
+				return err
+			}
+			pass = string(p)
+		}
+		js, err := truststore.NewJavaStore(keystore, pass)
+		if err != nil {
+			return err
+		}
+
+		if javaRemoveFlag {
+			removed, err := js.Remove(alias)
+			if err != nil {
+				ui.Error("%v", err)
+				return err
+			}
+			ui.Success("Removed %d truststore entries (%s-*) from %s", len(removed), alias, keystore)
+			meta.JavaTruststores = withoutTruststore(meta.JavaTruststores, keystore)
+			return meta.Store()
+		}
+
+		ui.StepStart("Importing chain of %s into %s...", domain, keystore)
+		if err := js.RefreshFromFile(meta.CertPath, alias); err != nil {
+			ui.Error("%v", err)
+			return err
+		}
+		if !javaNoTrackFlag {
+			meta.JavaTruststores = append(withoutTruststore(meta.JavaTruststores, keystore), metadata.JavaTruststore{Path: keystore, Alias: alias})
+			if err := meta.Store(); err != nil {
+				return err
+			}
+			ui.Info("Truststore will be refreshed after each renewal")
+		}
+		return nil
+	},
+}
+
+func withoutTruststore(list []metadata.JavaTruststore, path string) []metadata.JavaTruststore {
+	var out []metadata.JavaTruststore
+	for _, t := range list {
+		if t.Path != path {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+func init() {
+	trustJavaCmd.Flags().StringVar(&javaKeystoreFlag, "keystore", "", "Path to the Java truststore (cacerts, JKS or PKCS12)")
+	trustJavaCmd.Flags().StringVar(&javaAliasFlag, "alias", "", "Alias prefix for the imported entries (default trustctl-<domain>)")
+	trustJavaCmd.Flags().BoolVar(&javaRemoveFlag, "remove", false, "Remove the entries instead and stop refreshing this truststore")
+	trustJavaCmd.Flags().StringVar(&javaPassFileFlag, "storepass-file", "", "File containing the truststore password, - for stdin (default $"+truststore.JavaStorepassEnv+", else changeit)")
+	trustJavaCmd.Flags().BoolVar(&javaNoTrackFlag, "no-track", false, "Import once without refreshing after renewals")
+
+	trustCmd.AddCommand(trustJavaCmd)
+}
@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/trustctl/trustctl/internal/certinfo"
+	"github.com/trustctl/trustctl/internal/metadata"
+	"github.com/trustctl/trustctl/internal/probe"
+	"github.com/trustctl/trustctl/internal/ui"
+)
+
+var (
+	verifyStartTLSFlag   string
+	verifyServerNameFlag string
+	verifyTimeoutFlag    time.Duration
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify <host[:port]>",
+	Short: "Check the certificate a live endpoint serves",
+	Long: "Perform a TLS handshake (with SNI, and STARTTLS for smtp/imap/pop3/ldap ports) and report the served chain, " +
+		"expiry, hostname match and whether it matches the certificate trustctl manages for that domain.",
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		addr := args[0]
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(addr, "443")
+		}
+		host, port, _ := net.SplitHostPort(addr)
+		starttls := verifyStartTLSFlag
+		if !cmd.Flags().Changed("starttls") {
+			starttls = probe.DefaultStartTLS(port)
+		}
+		serverName := verifyServerNameFlag
+		if serverName == "" {
+			serverName = host
+		}
+
+		if starttls != "" {
+			ui.StepStart("Connecting to %s (STARTTLS %s, SNI %s)...", addr, starttls, serverName)
+		} else {
+			ui.StepStart("Connecting to %s (SNI %s)...", addr, serverName)
+		}
+		res, err := probe.Dial(addr, probe.Options{ServerName: serverName, StartTLS: starttls, Timeout: verifyTimeoutFlag})
+		if err != nil {
+			ui.Error("handshake failed: %v", err)
+			return fmt.Errorf("handshake with %s failed: %w", addr, err)
+		}
+
+		leaf := res.Leaf()
+		if leaf == nil {
+			ui.Error("%s presented no certificate", addr)
+			return fmt.Errorf("no certificate served by %s", addr)
+		}
+		ui.Info("Protocol: %s", tlsVersionName(res.State.Version))
+		ui.Info("Served chain:")
+		for i, c := range res.State.PeerCertificates {
+			ui.Info("  %d: %s (issuer: %s, expires %s)", i, c.Subject, c.Issuer, c.NotAfter.Format("2006-01-02"))
+		}
+
+		problems := 0
+		days := int(time.Until(leaf.NotAfter).Hours() / 24)
+		switch {
+		case days < 0:
+			problems++
+			ui.Error("Certificate expired %d day(s) ago", -days)
+		case days < 14:
+			ui.Warning("Certificate expires in %d day(s)", days)
+		default:
+			ui.Success("Certificate valid for %d more day(s)", days)
+		}
+		if res.HostnameErr != nil {
+			problems++
+			ui.Error("Hostname mismatch: %v", res.HostnameErr)
+		} else {
+			ui.Success("Certificate covers %s", serverName)
+		}
+		if res.VerifyErr != nil {
+			problems++
+			ui.Error("Chain does not verify: %v", res.VerifyErr)
+		} else {
+			ui.Success("Chain verifies against system roots")
+		}
+
+		// Compare with the certificate trustctl manages for this name
+		if m, err := metadata.Load(serverName); err == nil {
+			served := certinfo.Fingerprint(leaf)
+			local := m.FingerprintSHA256
+			if info, err := certinfo.ParseFile(m.CertPath); err == nil {
+				local = info.FingerprintSHA256
+			}
+			switch {
+			case local == "":
+				ui.Warning("Managed certificate for %s has no recorded fingerprint", serverName)
+			case local == served:
+				ui.Success("Endpoint serves the certificate managed by trustctl")
+			default:
+				problems++
+				ui.Error("Endpoint serves a different certificate than the one managed by trustctl (served %s, managed %s)", short(served), short(local))
+			}
+		} else {
+			ui.Info("%s is not managed by trustctl on this host", serverName)
+		}
+
+		if problems > 0 {
+			return fmt.Errorf("%d problem(s) found on %s", problems, addr)
+		}
+		return nil
+	},
+}
+
+func tlsVersionName(v uint16) string {
+	switch v {
+	case 0x0301:
+		return "TLS 1.0"
+	case 0x0302:
+		return "TLS 1.1"
+	case 0x0303:
+		return "TLS 1.2"
+	case 0x0304:
+		return "TLS 1.3"
+	}
+	return fmt.Sprintf("0x%04x", v)
+}
+
+// short abbreviates a hex fingerprint for display.
+func short(fp string) string {
+	if len(fp) > 16 {
+		return strings.ToUpper(fp[:16]) + "…"
+	}
+	return strings.ToUpper(fp)
+}
+
+func init() {
+	verifyCmd.Flags().StringVar(&verifyStartTLSFlag, "starttls", "", "Upgrade with STARTTLS first: smtp|imap|pop3|ldap (default chosen by port)")
+	verifyCmd.Flags().StringVar(&verifyServerNameFlag, "servername", "", "SNI name to send (default the host)")
+	verifyCmd.Flags().DurationVar(&verifyTimeoutFlag, "timeout", 10*time.Second, "Connection and handshake timeout")
+
+	rootCmd.AddCommand(verifyCmd)
+}
@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/trustctl/trustctl/internal/install"
+	"github.com/trustctl/trustctl/internal/paths"
+	"github.com/trustctl/trustctl/internal/ui"
+)
+
+// errWizardAborted is returned when the user declines the summary.
+var errWizardAborted = errors.New("request cancelled")
+
+// wizard asks the questions of `trustctl request` run without domains on a terminal
+// and reads the answers from stdin.
+type wizard struct {
+	in *bufio.Reader
+}
+
+// ask prints question with its default and returns the answer, or def when it is blank.
+func (w *wizard) ask(question, def string) (string, error) {
+	if def != "" {
+		fmt.Fprintf(os.Stderr, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(os.Stderr, "%s: ", question)
+	}
+	line, err := w.in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	if line = strings.TrimSpace(line); line != "" {
+		return line, nil
+	}
+	return def, nil
+}
+
+// runWizard detects the web server, offers the names its vhosts serve, asks for the
+// validation method and confirms before filling in the request flags.
+func runWizard() error {
+	w := &wizard{in: bufio.NewReader(os.Stdin)}
+	ui.Info("No --domains given; starting interactive setup (Ctrl-C to quit)")
+
+	srv, running, err := install.Server()
+	var names []string
+	switch {
+	case err != nil:
+		ui.Warning("%v; enter the domains yourself", err)
+	case running:
+		ui.Info("Detected running %s", srv)
+		names = install.VhostNames(srv)
+	default:
+		ui.Info("Found %s configuration (server not running)", srv)
+		names = install.VhostNames(srv)
+	}
+
+	var domains []string
+	for len(domains) == 0 {
+		question := "Domains (comma-separated)"
+		if len(names) > 0 {
+			fmt.Fprintln(os.Stderr, "Names found in the vhost files:")
+			for i, n := range names {
+				fmt.Fprintf(os.Stderr, "  %d) %s\n", i+1, n)
+			}
+			question = "Numbers or names to include, comma-separated (blank for all)"
+		}
+		answer, err := w.ask(question, "")
+		if err != nil {
+			return err
+		}
+		if domains, err = pickDomains(answer, names); err != nil {
+			ui.Warning("%v", err)
+		}
+	}
+
+	validation := ""
+	for validation == "" {
+		answer, err := w.ask("Validation method: http, dns or manual", validationOr("http"))
+		if err != nil {
+			return err
+		}
+		switch answer = strings.ToLower(answer); answer {
+		case "http", "dns", "manual":
+			validation = answer
+		default:
+			ui.Warning("unknown validation method %q", answer)
+		}
+	}
+	switch validation {
+	case "http":
+		if httpAddrFlag == "" {
+			def := webrootFlag
+			if def == "" {
+				def = paths.Webroot()
+			}
+			if webrootFlag, err = w.ask("Webroot served for "+domains[0], def); err != nil {
+				return err
+			}
+		}
+	case "dns":
+		for dnsProviderFlag == "" {
+			if dnsProviderFlag, err = w.ask("DNS provider plugin", ""); err != nil {
+				return err
+			}
+		}
+	}
+	if emailFlag == "" {
+		if emailFlag, err = w.ask("Email for account and expiry notices (optional)", ""); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "\nRequest a certificate for %s using %s validation", strings.Join(domains, ", "), validation)
+	if !agreeTOSFlag {
+		fmt.Fprint(os.Stderr, ", accepting the CA's terms of service")
+	}
+	answer, err := w.ask("? (y/N)", "")
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(answer, "y") && !strings.EqualFold(answer, "yes") {
+		return errWizardAborted
+	}
+	domainsFlag = strings.Join(domains, ",")
+	validationFlag = validation
+	agreeTOSFlag = true
+	return nil
+}
+
+// validationOr returns --validation when given, else def.
+func validationOr(def string) string {
+	if validationFlag != "" {
+		return validationFlag
+	}
+	return def
+}
+
+// pickDomains turns an answer of list numbers and names into domains; blank selects
+// every name offered.
+func pickDomains(answer string, names []string) ([]string, error) {
+	if answer == "" {
+		return names, nil
+	}
+	var out []string
+	for _, f := range strings.FieldsFunc(answer, func(r rune) bool { return r == ',' || r == ' ' }) {
+		if n, err := strconv.Atoi(f); err == nil {
+			if n < 1 || n > len(names) {
+				return nil, fmt.Errorf("no name numbered %d", n)
+			}
+			out = append(out, names[n-1])
+			continue
+		}
+		out = append(out, f)
+	}
+	return out, nil
+}
@@ -3,6 +3,7 @@ module github.com/trustctl/trustctl
 go 1.20
 
 require (
+	github.com/mattn/go-sqlite3 v1.14.22
 	github.com/spf13/cobra v1.10.2
 	gopkg.in/yaml.v3 v3.0.1
 )
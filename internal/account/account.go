@@ -1,54 +1,130 @@
 package account
 
 import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
 	"time"
+
+	"github.com/trustctl/trustctl/internal/acme"
+	"github.com/trustctl/trustctl/internal/paths"
 )
 
+// DefaultName is the account profile used when --account is not given. It maps to the
+// historical <ca>-account.json file so existing installs keep working.
+const DefaultName = "default"
+
+var validName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
 // AccountInfo stores ACME account credentials (for Let's Encrypt or other ACME-compliant CAs)
 type AccountInfo struct {
-	CA            string    `json:"ca"` // e.g., "letsencrypt", "sectigo"
-	Email         string    `json:"email"`
-	AccountURL    string    `json:"account_url"`
-	AccountKey    string    `json:"account_key"` // path to account private key
-	CreatedAt     time.Time `json:"created_at"`
-	LastUpdatedAt time.Time `json:"last_updated_at"`
+	CA             string    `json:"ca"`             // e.g., "letsencrypt", "sectigo"
+	Name           string    `json:"name,omitempty"` // profile name, e.g. "payments" (empty means default)
+	Email          string    `json:"email"`
+	DirectoryURL   string    `json:"directory_url,omitempty"` // ACME directory the account is registered with
+	AccountURL     string    `json:"account_url"`
+	AccountKey     string    `json:"account_key"`                // path to account private key
+	TermsOfService string    `json:"terms_of_service,omitempty"` // terms accepted at registration
+	CreatedAt      time.Time `json:"created_at"`
+	LastUpdatedAt  time.Time `json:"last_updated_at"`
+}
+
+// CANameFor returns the account namespace for a CA: enterprise-ca when an enterprise
+// server URL is set, otherwise the ACME CA name (letsencrypt when empty). Accounts
+// exist per directory, so the CA's staging directory maps to <ca>-staging and any
+// other directoryURL to acme-<host>.
+func CANameFor(ca, serverURL, directoryURL string) string {
+	if serverURL != "" {
+		return "enterprise-ca"
+	}
+	if ca == "" {
+		ca = "letsencrypt"
+	}
+	switch directoryURL {
+	case "", acme.KnownDirectories[ca]:
+		return ca
+	case acme.StagingDirectories[ca]:
+		return ca + "-staging"
+	}
+	host := directoryURL
+	if u, err := url.Parse(directoryURL); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	return "acme-" + strings.Trim(unsafeNameChars.ReplaceAllString(strings.ToLower(host), "-"), "-")
+}
+
+var unsafeNameChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// ValidateName checks that an account profile name is safe to use in file names.
+func ValidateName(name string) error {
+	if name == "" || name == DefaultName {
+		return nil
+	}
+	if !validName.MatchString(name) {
+		return fmt.Errorf("invalid account name %q (use lowercase letters, digits, - and _)", name)
+	}
+	return nil
+}
+
+// fileBase returns the credentials file prefix for a CA account profile:
+// <ca> for the default profile, <ca>.<name> otherwise.
+func fileBase(ca, name string) string {
+	if name == "" || name == DefaultName {
+		return ca
+	}
+	return ca + "." + name
+}
+
+// File returns the path of the account JSON for a CA account profile.
+func File(ca, name string) string {
+	return filepath.Join(paths.Credentials(), fileBase(ca, name)+"-account.json")
 }
 
-// Store saves account info to /opt/trustctl/credentials/<ca>-account.json with chmod 600
+// Store saves account info to <credentials>/<ca>[.<name>]-account.json with chmod 600
 func (a *AccountInfo) Store() error {
 	if a.CA == "" {
 		return fmt.Errorf("CA name required")
 	}
+	if err := ValidateName(a.Name); err != nil {
+		return err
+	}
 
-	credDir := "/opt/trustctl/credentials"
+	credDir := paths.Credentials()
 	if err := os.MkdirAll(credDir, 0700); err != nil {
 		return err
 	}
 
-	accountFile := filepath.Join(credDir, fmt.Sprintf("%s-account.json", a.CA))
 	data, err := json.MarshalIndent(a, "", "  ")
 	if err != nil {
 		return err
 	}
 
 	// Write with restricted permissions
-	if err := os.WriteFile(accountFile, data, 0600); err != nil {
+	if err := os.WriteFile(File(a.CA, a.Name), data, 0600); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-// Load loads account info from /opt/trustctl/credentials/<ca>-account.json
-func Load(ca string) (*AccountInfo, error) {
-	accountFile := filepath.Join("/opt/trustctl/credentials", fmt.Sprintf("%s-account.json", ca))
-	data, err := os.ReadFile(accountFile)
+// Load loads account info from <credentials>/<ca>[.<name>]-account.json
+func Load(ca, name string) (*AccountInfo, error) {
+	data, err := os.ReadFile(File(ca, name))
 	if err != nil {
-		return nil, fmt.Errorf("account file not found for CA %s: %w", ca, err)
+		return nil, fmt.Errorf("account file not found for CA %s (account %s): %w", ca, displayName(name), err)
 	}
 
 	var a AccountInfo
@@ -59,29 +135,234 @@ func Load(ca string) (*AccountInfo, error) {
 	return &a, nil
 }
 
-// Exists checks if account info exists for a CA
-func Exists(ca string) bool {
-	accountFile := filepath.Join("/opt/trustctl/credentials", fmt.Sprintf("%s-account.json", ca))
-	_, err := os.Stat(accountFile)
+// Exists checks if account info exists for a CA account profile
+func Exists(ca, name string) bool {
+	_, err := os.Stat(File(ca, name))
 	return err == nil
 }
 
-// Create creates a new account (scaffold - will integrate with ACME library)
-func Create(ca, email string) (*AccountInfo, error) {
+// List loads every account file in the credentials directory.
+func List() ([]*AccountInfo, error) {
+	matches, err := filepath.Glob(filepath.Join(paths.Credentials(), "*-account.json"))
+	if err != nil {
+		return nil, err
+	}
+	var out []*AccountInfo
+	for _, m := range matches {
+		data, err := os.ReadFile(m)
+		if err != nil {
+			return nil, err
+		}
+		var a AccountInfo
+		if err := json.Unmarshal(data, &a); err != nil {
+			return nil, fmt.Errorf("%s: %w", m, err)
+		}
+		out = append(out, &a)
+	}
+	return out, nil
+}
+
+// ProfileName returns the profile name, DefaultName for the default profile.
+func (a *AccountInfo) ProfileName() string {
+	return displayName(a.Name)
+}
+
+// KeyThumbprint returns the RFC 7638 thumbprint of the account key, or "" when the key is unavailable.
+func (a *AccountInfo) KeyThumbprint() string {
+	key, err := a.LoadKey()
+	if err != nil {
+		return ""
+	}
+	tp, err := acme.Thumbprint(key)
+	if err != nil {
+		return ""
+	}
+	return tp
+}
+
+// CreateOptions control account registration.
+type CreateOptions struct {
+	DirectoryURL string // ACME directory; defaults to the CA's (Let's Encrypt for letsencrypt)
+	AgreeTOS     bool   // accept the terms of service advertised by the directory
+	EAB          *acme.ExternalAccountBinding
+	HTTPClient   *http.Client // nil uses the ACME client's default
+}
+
+// ErrEABRequired is returned by Create when the CA only registers accounts with external account binding.
+var ErrEABRequired = errors.New("the CA requires external account binding (EAB key ID and HMAC key)")
+
+// TermsError is returned by Create when the CA has terms of service that were not accepted.
+type TermsError struct {
+	URL string
+}
+
+func (e *TermsError) Error() string {
+	return fmt.Sprintf("the CA requires acceptance of its terms of service (%s)", e.URL)
+}
+
+// Create generates an account key, stores it next to the account file and registers
+// it with the CA's ACME directory. An existing key file is reused, so a registration
+// that failed halfway is picked up again (the CA returns the existing account).
+// CAs without an ACME directory (enterprise-ca) only get a local account record.
+// The caller stores the returned account.
+func Create(ctx context.Context, ca, name, email string, opts CreateOptions) (*AccountInfo, error) {
 	if ca == "" || email == "" {
 		return nil, fmt.Errorf("CA name and email required")
 	}
+	if err := ValidateName(name); err != nil {
+		return nil, err
+	}
+	if name == DefaultName {
+		name = ""
+	}
 
 	account := &AccountInfo{
-		CA:        ca,
-		Email:     email,
-		CreatedAt: time.Now(),
+		CA:           ca,
+		Name:         name,
+		Email:        email,
+		DirectoryURL: opts.DirectoryURL,
+		CreatedAt:    time.Now(),
+	}
+	account.DirectoryURL = account.Directory()
+	account.AccountKey = filepath.Join(paths.Credentials(), fileBase(ca, name)+"-account-key.pem")
+	if account.DirectoryURL == "" {
+		return account, nil
 	}
 
-	// In production, integrate with lego or similar to register account with ACME server
-	// For now, scaffold returns account ready to be used
-	account.AccountURL = "https://acme-v02.api.letsencrypt.org/acme/acct/12345" // placeholder
-	account.AccountKey = filepath.Join("/opt/trustctl/credentials", ca+"-account-key.pem")
-
+	key, err := account.loadOrGenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	client := acme.NewClient(account.DirectoryURL, key)
+	if opts.HTTPClient != nil {
+		client.HTTP = opts.HTTPClient
+	}
+	dir, err := client.Directory(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if tos := dir.Meta.TermsOfService; tos != "" {
+		if !opts.AgreeTOS {
+			return nil, &TermsError{URL: tos}
+		}
+		account.TermsOfService = tos
+	}
+	if dir.Meta.ExternalAccountRequired && opts.EAB == nil {
+		return nil, ErrEABRequired
+	}
+	if _, err := client.NewAccount(ctx, []string{"mailto:" + email}, opts.AgreeTOS, opts.EAB); err != nil {
+		return nil, fmt.Errorf("account registration at %s failed: %w", account.DirectoryURL, err)
+	}
+	account.AccountURL = client.KID
 	return account, nil
 }
+
+// loadOrGenerateKey returns the key at AccountKey, creating a P-256 key (chmod 600) if there is none.
+func (a *AccountInfo) loadOrGenerateKey() (crypto.Signer, error) {
+	if _, err := os.Stat(a.AccountKey); err == nil {
+		return a.LoadKey()
+	}
+	if err := os.MkdirAll(filepath.Dir(a.AccountKey), 0700); err != nil {
+		return nil, err
+	}
+	return generateKey(a.AccountKey)
+}
+
+// generateKey writes a new P-256 key to path (chmod 600) and returns it.
+func generateKey(path string) (crypto.Signer, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write account key: %w", err)
+	}
+	return key, nil
+}
+
+// RotateKey replaces the account key with a new P-256 key through the CA's ACME key
+// change and stores the account. The new key is written next to the old one before
+// the CA is asked, so it is never lost; once the CA accepted it, the old key is kept
+// as <key>.<timestamp>.bak and the new one renamed into place. It returns the backup
+// path. httpClient may be nil.
+func (a *AccountInfo) RotateKey(ctx context.Context, httpClient *http.Client) (string, error) {
+	if a.Directory() == "" || a.AccountURL == "" {
+		return "", fmt.Errorf("%s account %s is not registered over ACME; it has no account key to rotate", a.CA, a.ProfileName())
+	}
+	oldKey, err := a.LoadKey()
+	if err != nil {
+		return "", fmt.Errorf("failed to load account key: %w", err)
+	}
+	pending := a.AccountKey + ".new"
+	newKey, err := generateKey(pending)
+	if err != nil {
+		return "", err
+	}
+	client := acme.NewClient(a.Directory(), oldKey)
+	client.KID = a.AccountURL
+	if httpClient != nil {
+		client.HTTP = httpClient
+	}
+	if err := client.ChangeKey(ctx, newKey); err != nil {
+		os.Remove(pending)
+		return "", fmt.Errorf("key change at %s failed: %w", a.Directory(), err)
+	}
+
+	backup := fmt.Sprintf("%s.%s.bak", a.AccountKey, time.Now().UTC().Format("20060102T150405Z"))
+	if err := os.Link(a.AccountKey, backup); err != nil {
+		return "", fmt.Errorf("the CA now expects the key in %s, but backing up the old key failed: %w", pending, err)
+	}
+	if err := os.Rename(pending, a.AccountKey); err != nil {
+		return "", fmt.Errorf("the CA now expects the key in %s, but installing it failed: %w", pending, err)
+	}
+	a.LastUpdatedAt = time.Now()
+	return backup, a.Store()
+}
+
+// Directory returns the ACME directory URL of the account, defaulting to the
+// production directory of a known ACME CA for accounts created before it was recorded.
+func (a *AccountInfo) Directory() string {
+	if a.DirectoryURL != "" {
+		return a.DirectoryURL
+	}
+	return acme.KnownDirectories[a.CA]
+}
+
+// LoadKey reads the account private key (PKCS#1, PKCS#8 or SEC 1 PEM).
+func (a *AccountInfo) LoadKey() (crypto.Signer, error) {
+	data, err := os.ReadFile(a.AccountKey)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", a.AccountKey)
+	}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := k.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type in %s", a.AccountKey)
+	}
+	return signer, nil
+}
+
+func displayName(name string) string {
+	if name == "" {
+		return DefaultName
+	}
+	return name
+}
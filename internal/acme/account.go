@@ -0,0 +1,119 @@
+package acme
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Account is the RFC 8555 §7.1.2 account object.
+type Account struct {
+	Status  string   `json:"status"`
+	Contact []string `json:"contact"`
+	Orders  string   `json:"orders,omitempty"`
+}
+
+// ExternalAccountBinding ties a new ACME account to an existing account at the CA
+// (RFC 8555 §7.3.4). KID and HMACKey come from the CA's dashboard.
+type ExternalAccountBinding struct {
+	KID     string
+	HMACKey []byte
+}
+
+// NewExternalAccountBinding decodes the base64url HMAC key the CA hands out.
+func NewExternalAccountBinding(kid, hmacKey string) (*ExternalAccountBinding, error) {
+	if kid == "" || hmacKey == "" {
+		return nil, errors.New("acme: EAB key ID and HMAC key are both required")
+	}
+	key, err := b64.DecodeString(strings.TrimRight(strings.TrimSpace(hmacKey), "="))
+	if err != nil {
+		return nil, fmt.Errorf("acme: EAB HMAC key is not base64url: %w", err)
+	}
+	return &ExternalAccountBinding{KID: kid, HMACKey: key}, nil
+}
+
+// NewAccount registers the client's key with the CA, or finds the account already
+// registered for it, and sets KID to the account URL. termsAgreed must be true when
+// the directory advertises terms of service; eab is required by CAs whose directory
+// sets externalAccountRequired and may be nil otherwise.
+func (c *Client) NewAccount(ctx context.Context, contact []string, termsAgreed bool, eab *ExternalAccountBinding) (*Account, error) {
+	d, err := c.Directory(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if d.NewAccount == "" {
+		return nil, errors.New("acme: directory has no newAccount URL")
+	}
+	req := map[string]interface{}{"termsOfServiceAgreed": termsAgreed}
+	if len(contact) > 0 {
+		req["contact"] = contact
+	}
+	if eab != nil {
+		binding, err := eabJWS(c.Key, eab, d.NewAccount)
+		if err != nil {
+			return nil, err
+		}
+		req["externalAccountBinding"] = binding
+	}
+	var acct Account
+	resp, err := c.post(ctx, d.NewAccount, req, &acct, true)
+	if err != nil {
+		return nil, err
+	}
+	loc := resp.Header.Get("Location")
+	if loc == "" {
+		return nil, errors.New("acme: newAccount response has no Location header")
+	}
+	c.KID = loc
+	return &acct, nil
+}
+
+// UpdateAccount replaces the account's contact URLs (e.g. mailto:ops@example.com).
+func (c *Client) UpdateAccount(ctx context.Context, contact []string) (*Account, error) {
+	var acct Account
+	if _, err := c.post(ctx, c.KID, map[string]interface{}{"contact": contact}, &acct, false); err != nil {
+		return nil, err
+	}
+	return &acct, nil
+}
+
+// GetAccount fetches the current account object.
+func (c *Client) GetAccount(ctx context.Context) (*Account, error) {
+	var acct Account
+	if _, err := c.post(ctx, c.KID, nil, &acct, false); err != nil {
+		return nil, err
+	}
+	return &acct, nil
+}
+
+// ChangeKey replaces the account key with newKey (RFC 8555 §7.3.5). The request is
+// signed by the current key and carries an inner JWS signed by newKey; on success
+// the client signs with newKey from then on.
+func (c *Client) ChangeKey(ctx context.Context, newKey crypto.Signer) error {
+	d, err := c.Directory(ctx)
+	if err != nil {
+		return err
+	}
+	if d.KeyChange == "" {
+		return errors.New("acme: directory has no keyChange URL")
+	}
+	oldKey, err := jwk(c.Key)
+	if err != nil {
+		return err
+	}
+	inner, err := signJWS(newKey, "", "", d.KeyChange, map[string]interface{}{
+		"account": c.KID,
+		"oldKey":  json.RawMessage(oldKey),
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := c.post(ctx, d.KeyChange, json.RawMessage(inner), nil, false); err != nil {
+		return err
+	}
+	c.Key = newKey
+	return nil
+}
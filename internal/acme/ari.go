@@ -0,0 +1,83 @@
+package acme
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/trustctl/trustctl/internal/ratelimit"
+)
+
+// ErrNoRenewalInfo is returned when the directory does not offer ACME Renewal Information.
+var ErrNoRenewalInfo = errors.New("acme: CA does not support renewal information (ARI)")
+
+// DefaultARIRetry is how long to wait before asking again when the CA sends no Retry-After.
+const DefaultARIRetry = 6 * time.Hour
+
+// RenewalInfo is the RFC 9773 renewalInfo object.
+type RenewalInfo struct {
+	SuggestedWindow struct {
+		Start time.Time `json:"start"`
+		End   time.Time `json:"end"`
+	} `json:"suggestedWindow"`
+	ExplanationURL string `json:"explanationURL,omitempty"`
+	// RetryAfter is when the CA wants to be asked again (from Retry-After).
+	RetryAfter time.Time `json:"-"`
+}
+
+// CertID returns the ARI identifier of cert: base64url(authority key ID) "." base64url(serial).
+func CertID(cert *x509.Certificate) (string, error) {
+	if len(cert.AuthorityKeyId) == 0 {
+		return "", errors.New("acme: certificate has no authority key identifier")
+	}
+	serial := cert.SerialNumber.Bytes()
+	// DER INTEGER content: a leading zero keeps positive serials with the high bit set positive
+	if len(serial) == 0 || serial[0]&0x80 != 0 {
+		serial = append([]byte{0}, serial...)
+	}
+	return b64.EncodeToString(cert.AuthorityKeyId) + "." + b64.EncodeToString(serial), nil
+}
+
+// GetRenewalInfo asks the CA when cert should be renewed. The request is unauthenticated.
+func (c *Client) GetRenewalInfo(ctx context.Context, cert *x509.Certificate) (*RenewalInfo, error) {
+	d, err := c.Directory(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if d.RenewalInfo == "" {
+		return nil, ErrNoRenewalInfo
+	}
+	id, err := CertID(cert)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.RenewalInfo+"/"+id, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("acme: renewalInfo %s: %s", id, resp.Status)
+	}
+	var ri RenewalInfo
+	if err := json.NewDecoder(resp.Body).Decode(&ri); err != nil {
+		return nil, fmt.Errorf("acme: decode renewalInfo: %w", err)
+	}
+	w := ri.SuggestedWindow
+	if w.Start.IsZero() || w.End.Before(w.Start) {
+		return nil, errors.New("acme: renewalInfo has an invalid suggested window")
+	}
+	ri.RetryAfter = time.Now().Add(DefaultARIRetry)
+	if t, ok := ratelimit.ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+		ri.RetryAfter = t
+	}
+	return &ri, nil
+}
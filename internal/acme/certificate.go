@@ -0,0 +1,52 @@
+package acme
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+)
+
+// reLinkAlternate matches `<url>;rel="alternate"` entries of a Link header.
+var reLinkAlternate = regexp.MustCompile(`<([^>]+)>\s*;\s*rel="?alternate"?`)
+
+// FetchCertificate downloads the certificate chain at url (the order's certificate
+// URL) and every alternate chain the CA links to (RFC 8555 §7.4.2), default first.
+func (c *Client) FetchCertificate(ctx context.Context, url string) ([][]byte, error) {
+	chain, resp, err := c.fetchPEM(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	chains := [][]byte{chain}
+	for _, alt := range alternateLinks(resp.Header) {
+		alt, _, err := c.fetchPEM(ctx, alt)
+		if err != nil {
+			// The default chain is usable; a broken alternate only narrows the choice
+			continue
+		}
+		chains = append(chains, alt)
+	}
+	return chains, nil
+}
+
+func (c *Client) fetchPEM(ctx context.Context, url string) ([]byte, *http.Response, error) {
+	var raw []byte
+	resp, err := c.post(ctx, url, nil, &raw, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(raw) == 0 {
+		return nil, nil, errors.New("acme: empty certificate response")
+	}
+	return raw, resp, nil
+}
+
+func alternateLinks(h http.Header) []string {
+	var out []string
+	for _, v := range h.Values("Link") {
+		for _, m := range reLinkAlternate.FindAllStringSubmatch(v, -1) {
+			out = append(out, m[1])
+		}
+	}
+	return out
+}
@@ -0,0 +1,111 @@
+package acme
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"fmt"
+)
+
+// Challenge types trustctl can answer.
+const (
+	ChallengeHTTP01 = "http-01"
+	ChallengeDNS01  = "dns-01"
+)
+
+// Challenge is the RFC 8555 §8 challenge object.
+type Challenge struct {
+	Type   string   `json:"type"`
+	URL    string   `json:"url"`
+	Status string   `json:"status"`
+	Token  string   `json:"token"`
+	Error  *Problem `json:"error,omitempty"`
+}
+
+// Authorization is the RFC 8555 §7.1.4 authorization object. For a wildcard order the
+// identifier is the base domain and Wildcard is set.
+type Authorization struct {
+	Identifier Identifier  `json:"identifier"`
+	Status     string      `json:"status"`
+	Wildcard   bool        `json:"wildcard,omitempty"`
+	Challenges []Challenge `json:"challenges"`
+}
+
+// Name returns the name the authorization was created for, "*.example.com" for wildcards.
+func (a *Authorization) Name() string {
+	if a.Wildcard {
+		return "*." + a.Identifier.Value
+	}
+	return a.Identifier.Value
+}
+
+// Challenge returns the challenge of type typ, or nil when the CA did not offer it.
+func (a *Authorization) Challenge(typ string) *Challenge {
+	for i := range a.Challenges {
+		if a.Challenges[i].Type == typ {
+			return &a.Challenges[i]
+		}
+	}
+	return nil
+}
+
+// GetAuthorization fetches the authorization at url.
+func (c *Client) GetAuthorization(ctx context.Context, url string) (*Authorization, error) {
+	var a Authorization
+	if _, err := c.post(ctx, url, nil, &a, false); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// Accept tells the CA the challenge is ready to be validated (RFC 8555 §7.5.1).
+func (c *Client) Accept(ctx context.Context, ch *Challenge) error {
+	_, err := c.post(ctx, ch.URL, struct{}{}, nil, false)
+	return err
+}
+
+// WaitAuthorization polls the authorization at url until the CA has validated it,
+// for at most PollTimeout, and returns the CA's reason when validation failed.
+func (c *Client) WaitAuthorization(ctx context.Context, url string) (*Authorization, error) {
+	ctx, cancel := context.WithTimeout(ctx, PollTimeout)
+	defer cancel()
+	for {
+		var a Authorization
+		resp, err := c.post(ctx, url, nil, &a, false)
+		if err != nil {
+			return nil, err
+		}
+		switch a.Status {
+		case "valid":
+			return &a, nil
+		case "pending":
+		default:
+			for _, ch := range a.Challenges {
+				if ch.Error != nil {
+					return nil, fmt.Errorf("acme: authorization for %s is %s: %w", a.Name(), a.Status, ch.Error)
+				}
+			}
+			return nil, fmt.Errorf("acme: authorization for %s is %s", a.Name(), a.Status)
+		}
+		if err := pollWait(ctx, resp); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// KeyAuthorization returns the RFC 8555 §8.1 key authorization for token:
+// the token and the account key's thumbprint joined by a dot.
+func KeyAuthorization(key crypto.Signer, token string) (string, error) {
+	tp, err := Thumbprint(key)
+	if err != nil {
+		return "", fmt.Errorf("acme: key authorization: %w", err)
+	}
+	return token + "." + tp, nil
+}
+
+// DNS01Value returns the TXT record value for a dns-01 key authorization: its
+// base64url SHA-256 digest (RFC 8555 §8.4).
+func DNS01Value(keyAuth string) string {
+	sum := sha256.Sum256([]byte(keyAuth))
+	return b64.EncodeToString(sum[:])
+}
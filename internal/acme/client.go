@@ -0,0 +1,245 @@
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trustctl/trustctl/internal/httpclient"
+	"github.com/trustctl/trustctl/internal/ratelimit"
+	"github.com/trustctl/trustctl/internal/ui"
+)
+
+// Directory URLs of well-known ACME CAs.
+const (
+	LetsEncryptURL         = "https://acme-v02.api.letsencrypt.org/directory"
+	LetsEncryptStagingURL  = "https://acme-staging-v02.api.letsencrypt.org/directory"
+	ZeroSSLURL             = "https://acme.zerossl.com/v2/DV90"
+	GoogleTrustServicesURL = "https://dv.acme-v02.api.pki.goog/directory"
+	BuypassURL             = "https://api.buypass.com/acme/directory"
+	BuypassStagingURL      = "https://api.test4.buypass.no/acme/directory"
+)
+
+// KnownDirectories maps the ACME CAs that can be selected by name to their production
+// directories. ZeroSSL and Google Trust Services require external account binding.
+var KnownDirectories = map[string]string{
+	"letsencrypt": LetsEncryptURL,
+	"zerossl":     ZeroSSLURL,
+	"google":      GoogleTrustServicesURL,
+	"buypass":     BuypassURL,
+}
+
+// StagingDirectories maps known CAs to their test environments.
+var StagingDirectories = map[string]string{
+	"letsencrypt": LetsEncryptStagingURL,
+	"buypass":     BuypassStagingURL,
+}
+
+// DirectoryFor returns the production or staging directory of a known CA (letsencrypt when empty).
+func DirectoryFor(ca string, staging bool) (string, error) {
+	if ca == "" {
+		ca = "letsencrypt"
+	}
+	dirs := KnownDirectories
+	if staging {
+		dirs = StagingDirectories
+	}
+	if u, ok := dirs[ca]; ok {
+		return u, nil
+	}
+	if staging {
+		return "", fmt.Errorf("no staging environment known for %s", ca)
+	}
+	return "", fmt.Errorf("unknown ACME CA %q", ca)
+}
+
+// Directory is the RFC 8555 §7.1.1 directory object.
+type Directory struct {
+	NewNonce   string `json:"newNonce"`
+	NewAccount string `json:"newAccount"`
+	NewOrder   string `json:"newOrder"`
+	RevokeCert string `json:"revokeCert"`
+	KeyChange  string `json:"keyChange"`
+	// RenewalInfo is the RFC 9773 ARI endpoint, when supported.
+	RenewalInfo string `json:"renewalInfo,omitempty"`
+	Meta        struct {
+		TermsOfService          string   `json:"termsOfService"`
+		ExternalAccountRequired bool     `json:"externalAccountRequired"`
+		CAAIdentities           []string `json:"caaIdentities"`
+		// Profiles maps the certificate profiles the CA offers to their descriptions
+		// (draft-ietf-acme-profiles).
+		Profiles map[string]string `json:"profiles,omitempty"`
+	} `json:"meta"`
+}
+
+// Problem is an RFC 7807 problem document returned by the CA.
+type Problem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+	Status int    `json:"status"`
+}
+
+func (p *Problem) Error() string {
+	return fmt.Sprintf("acme: %s: %s", strings.TrimPrefix(p.Type, "urn:ietf:params:acme:error:"), p.Detail)
+}
+
+// Client talks to one ACME server on behalf of one account key.
+type Client struct {
+	DirectoryURL string
+	Key          crypto.Signer
+	KID          string // account URL, set once the account exists
+	HTTP         *http.Client
+
+	mu     sync.Mutex
+	dir    *Directory
+	nonces []string
+}
+
+// NewClient returns a client for directoryURL signing with key.
+func NewClient(directoryURL string, key crypto.Signer) *Client {
+	return &Client{DirectoryURL: directoryURL, Key: key, HTTP: httpclient.New(30 * time.Second)}
+}
+
+// Directory fetches (once) and returns the server's directory.
+func (c *Client) Directory(ctx context.Context) (*Directory, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.dir != nil {
+		return c.dir, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.DirectoryURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("acme directory %s: %s", c.DirectoryURL, resp.Status)
+	}
+	var d Directory
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return nil, fmt.Errorf("acme directory %s: %w", c.DirectoryURL, err)
+	}
+	c.dir = &d
+	return c.dir, nil
+}
+
+func (c *Client) nonce(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	if n := len(c.nonces); n > 0 {
+		v := c.nonces[n-1]
+		c.nonces = c.nonces[:n-1]
+		c.mu.Unlock()
+		return v, nil
+	}
+	c.mu.Unlock()
+	d, err := c.Directory(ctx)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, d.NewNonce, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	v := resp.Header.Get("Replay-Nonce")
+	if v == "" {
+		return "", errors.New("acme: server returned no nonce")
+	}
+	return v, nil
+}
+
+func (c *Client) saveNonce(resp *http.Response) {
+	if v := resp.Header.Get("Replay-Nonce"); v != "" {
+		c.mu.Lock()
+		c.nonces = append(c.nonces, v)
+		c.mu.Unlock()
+	}
+}
+
+// post sends a signed request to url and decodes a JSON response into out (when non-nil);
+// a *[]byte out receives the body as is.
+// Requests signed with the embedded JWK pass useJWK. badNonce errors are retried once.
+// Cancelling ctx aborts the request.
+func (c *Client) post(ctx context.Context, url string, payload, out interface{}, useJWK bool) (*http.Response, error) {
+	kid := c.KID
+	if useJWK {
+		kid = ""
+	} else if kid == "" {
+		return nil, errors.New("acme: account URL unknown")
+	}
+	for attempt := 0; ; attempt++ {
+		nonce, err := c.nonce(ctx)
+		if err != nil {
+			return nil, err
+		}
+		body, err := signJWS(c.Key, kid, nonce, url, payload)
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/jose+json")
+		resp, err := c.HTTP.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		c.saveNonce(resp)
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		ui.Debug("acme: POST %s: %s", url, resp.Status)
+		if resp.StatusCode >= 400 {
+			p := &Problem{Status: resp.StatusCode}
+			if json.Unmarshal(data, p) != nil || p.Type == "" {
+				err = fmt.Errorf("acme: %s: %s", resp.Status, strings.TrimSpace(string(data)))
+				if rl := ratelimit.FromResponse(resp, strings.TrimSpace(string(data))); rl != nil {
+					rl.Err = err
+					return resp, rl
+				}
+				return resp, err
+			}
+			if strings.HasSuffix(p.Type, ":badNonce") && attempt == 0 {
+				continue
+			}
+			if rl := ratelimit.FromResponse(resp, p.Detail); rl != nil || strings.HasSuffix(p.Type, ":rateLimited") {
+				if rl == nil {
+					rl = &ratelimit.Error{Detail: p.Detail}
+					rl.RetryAfter, _ = ratelimit.RetryAfterFromDetail(p.Detail)
+				}
+				rl.Err = p
+				return resp, rl
+			}
+			return resp, p
+		}
+		if raw, ok := out.(*[]byte); ok {
+			*raw = data
+			return resp, nil
+		}
+		if out != nil && len(data) > 0 {
+			if err := json.Unmarshal(data, out); err != nil {
+				return resp, fmt.Errorf("acme: decode response from %s: %w", url, err)
+			}
+		}
+		return resp, nil
+	}
+}
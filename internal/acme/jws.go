@@ -0,0 +1,157 @@
+package acme
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+var b64 = base64.RawURLEncoding
+
+// jwk returns the RFC 7517 public JWK of key, with members in the lexicographic
+// order RFC 7638 requires for thumbprints.
+func jwk(key crypto.Signer) (string, error) {
+	switch pub := key.Public().(type) {
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		return fmt.Sprintf(`{"crv":"%s","kty":"EC","x":"%s","y":"%s"}`,
+			pub.Curve.Params().Name, b64.EncodeToString(pad(pub.X, size)), b64.EncodeToString(pad(pub.Y, size))), nil
+	case *rsa.PublicKey:
+		return fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`,
+			b64.EncodeToString(big.NewInt(int64(pub.E)).Bytes()), b64.EncodeToString(pub.N.Bytes())), nil
+	}
+	return "", errors.New("unsupported account key type")
+}
+
+// Thumbprint returns the base64url RFC 7638 SHA-256 thumbprint of key's JWK.
+func Thumbprint(key crypto.Signer) (string, error) {
+	j, err := jwk(key)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(j))
+	return b64.EncodeToString(sum[:]), nil
+}
+
+func jwsAlg(key crypto.Signer) (string, error) {
+	switch pub := key.Public().(type) {
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P256():
+			return "ES256", nil
+		case elliptic.P384():
+			return "ES384", nil
+		}
+	case *rsa.PublicKey:
+		return "RS256", nil
+	}
+	return "", errors.New("unsupported account key type")
+}
+
+// signJWS produces a flattened JWS. With kid empty the JWK is embedded (newAccount, revocation by cert key).
+// A nil payload yields the empty payload used for POST-as-GET; an empty nonce is
+// left out (the inner JWS of a key change).
+func signJWS(key crypto.Signer, kid, nonce, url string, payload interface{}) ([]byte, error) {
+	alg, err := jwsAlg(key)
+	if err != nil {
+		return nil, err
+	}
+	protected := map[string]interface{}{"alg": alg, "url": url}
+	if nonce != "" {
+		protected["nonce"] = nonce
+	}
+	if kid != "" {
+		protected["kid"] = kid
+	} else {
+		j, err := jwk(key)
+		if err != nil {
+			return nil, err
+		}
+		protected["jwk"] = json.RawMessage(j)
+	}
+	ph, err := json.Marshal(protected)
+	if err != nil {
+		return nil, err
+	}
+	var pl []byte
+	if payload != nil {
+		if pl, err = json.Marshal(payload); err != nil {
+			return nil, err
+		}
+	}
+	signingInput := b64.EncodeToString(ph) + "." + b64.EncodeToString(pl)
+	sig, err := sign(key, []byte(signingInput))
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]string{
+		"protected": b64.EncodeToString(ph),
+		"payload":   b64.EncodeToString(pl),
+		"signature": b64.EncodeToString(sig),
+	})
+}
+
+// eabJWS returns the external account binding: the account JWK as payload, MACed
+// with HS256 under the CA-issued key and addressed to the newAccount URL.
+func eabJWS(key crypto.Signer, eab *ExternalAccountBinding, url string) (json.RawMessage, error) {
+	j, err := jwk(key)
+	if err != nil {
+		return nil, err
+	}
+	ph, err := json.Marshal(map[string]string{"alg": "HS256", "kid": eab.KID, "url": url})
+	if err != nil {
+		return nil, err
+	}
+	signingInput := b64.EncodeToString(ph) + "." + b64.EncodeToString([]byte(j))
+	mac := hmac.New(sha256.New, eab.HMACKey)
+	mac.Write([]byte(signingInput))
+	return json.Marshal(map[string]string{
+		"protected": b64.EncodeToString(ph),
+		"payload":   b64.EncodeToString([]byte(j)),
+		"signature": b64.EncodeToString(mac.Sum(nil)),
+	})
+}
+
+func sign(key crypto.Signer, data []byte) ([]byte, error) {
+	switch pub := key.Public().(type) {
+	case *ecdsa.PublicKey:
+		h := crypto.SHA256
+		if pub.Curve == elliptic.P384() {
+			h = crypto.SHA384
+		}
+		hh := h.New()
+		hh.Write(data)
+		priv, ok := key.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("EC account keys must be in-memory ECDSA keys")
+		}
+		r, s, err := ecdsa.Sign(rand.Reader, priv, hh.Sum(nil))
+		if err != nil {
+			return nil, err
+		}
+		// JWS wants the fixed-width r||s concatenation, not ASN.1
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		return append(pad(r, size), pad(s, size)...), nil
+	case *rsa.PublicKey:
+		sum := sha256.Sum256(data)
+		return key.Sign(rand.Reader, sum[:], crypto.SHA256)
+	}
+	return nil, errors.New("unsupported account key type")
+}
+
+func pad(n *big.Int, size int) []byte {
+	b := n.Bytes()
+	if len(b) >= size {
+		return b
+	}
+	return append(make([]byte, size-len(b)), b...)
+}
@@ -0,0 +1,168 @@
+package acme
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/trustctl/trustctl/internal/ratelimit"
+)
+
+// Polling intervals for orders and authorizations when the CA sends no Retry-After,
+// and how long to wait for the CA before giving up.
+const (
+	PollInterval    = time.Second
+	MaxPollInterval = 30 * time.Second
+	PollTimeout     = 5 * time.Minute
+)
+
+// Identifier is an RFC 8555 §9.7.7 identifier.
+type Identifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// Identifiers returns identifiers for names: RFC 8738 ip identifiers for IP addresses
+// (in canonical form), dns identifiers for everything else, wildcards included.
+func Identifiers(names []string) []Identifier {
+	ids := make([]Identifier, 0, len(names))
+	for _, n := range names {
+		if ip := net.ParseIP(n); ip != nil {
+			ids = append(ids, Identifier{Type: "ip", Value: ip.String()})
+			continue
+		}
+		ids = append(ids, Identifier{Type: "dns", Value: strings.ToLower(n)})
+	}
+	return ids
+}
+
+// Order is the RFC 8555 §7.1.3 order object.
+type Order struct {
+	URL            string       `json:"-"` // from the Location header
+	Status         string       `json:"status"`
+	Identifiers    []Identifier `json:"identifiers"`
+	Authorizations []string     `json:"authorizations"`
+	Finalize       string       `json:"finalize"`
+	Profile        string       `json:"profile,omitempty"`
+	Certificate    string       `json:"certificate,omitempty"`
+	Error          *Problem     `json:"error,omitempty"`
+}
+
+// NewOrder asks the CA for a certificate covering ids. A non-empty profile selects
+// one of the certificate profiles the directory advertises (e.g. "shortlived").
+func (c *Client) NewOrder(ctx context.Context, ids []Identifier, profile string) (*Order, error) {
+	d, err := c.Directory(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if d.NewOrder == "" {
+		return nil, errors.New("acme: directory has no newOrder URL")
+	}
+	req := map[string]interface{}{"identifiers": ids}
+	if profile != "" {
+		if _, ok := d.Meta.Profiles[profile]; !ok {
+			return nil, fmt.Errorf("acme: the CA does not offer profile %q%s", profile, profileList(d.Meta.Profiles))
+		}
+		req["profile"] = profile
+	}
+	var o Order
+	resp, err := c.post(ctx, d.NewOrder, req, &o, false)
+	if err != nil {
+		return nil, err
+	}
+	o.URL = resp.Header.Get("Location")
+	return &o, nil
+}
+
+// GetOrder fetches the order at url.
+func (c *Client) GetOrder(ctx context.Context, url string) (*Order, error) {
+	var o Order
+	if _, err := c.post(ctx, url, nil, &o, false); err != nil {
+		return nil, err
+	}
+	o.URL = url
+	return &o, nil
+}
+
+// Finalize submits csr (PEM or DER) for the order once all its authorizations are
+// valid (RFC 8555 §7.4) and polls until the certificate is issued, for at most
+// PollTimeout. The returned order carries the certificate URL.
+func (c *Client) Finalize(ctx context.Context, o *Order, csr []byte) (*Order, error) {
+	if b, _ := pem.Decode(csr); b != nil {
+		csr = b.Bytes
+	}
+	ctx, cancel := context.WithTimeout(ctx, PollTimeout)
+	defer cancel()
+	var out Order
+	resp, err := c.post(ctx, o.Finalize, map[string]string{"csr": b64.EncodeToString(csr)}, &out, false)
+	if err != nil {
+		return nil, err
+	}
+	out.URL = o.URL
+	for {
+		switch out.Status {
+		case "valid":
+			if out.Certificate == "" {
+				return nil, errors.New("acme: valid order without certificate URL")
+			}
+			return &out, nil
+		case "processing", "ready", "pending":
+		default:
+			if out.Error != nil {
+				return nil, fmt.Errorf("acme: order is %s: %w", out.Status, out.Error)
+			}
+			return nil, fmt.Errorf("acme: order is %s", out.Status)
+		}
+		if out.URL == "" {
+			return nil, errors.New("acme: order URL unknown")
+		}
+		if err := pollWait(ctx, resp); err != nil {
+			return nil, err
+		}
+		out = Order{}
+		if resp, err = c.post(ctx, o.URL, nil, &out, false); err != nil {
+			return nil, err
+		}
+		out.URL = o.URL
+	}
+}
+
+// pollWait sleeps for the response's Retry-After, PollInterval without one, and at
+// most MaxPollInterval, or until ctx is done.
+func pollWait(ctx context.Context, resp *http.Response) error {
+	wait := PollInterval
+	if t, ok := ratelimit.ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+		wait = time.Until(t)
+	}
+	if wait > MaxPollInterval {
+		wait = MaxPollInterval
+	} else if wait < PollInterval {
+		wait = PollInterval
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+func profileList(profiles map[string]string) string {
+	if len(profiles) == 0 {
+		return " (it advertises none)"
+	}
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return " (offered: " + strings.Join(names, ", ") + ")"
+}
@@ -0,0 +1,57 @@
+package acme
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// RevocationReasons are the RFC 5280 §5.3.1 reason codes a subscriber may give when
+// revoking, by name.
+var RevocationReasons = map[string]int{
+	"unspecified":          0,
+	"keyCompromise":        1,
+	"affiliationChanged":   3,
+	"superseded":           4,
+	"cessationOfOperation": 5,
+}
+
+// ParseRevocationReason returns the reason code for name (unspecified when empty),
+// ignoring case.
+func ParseRevocationReason(name string) (int, error) {
+	if name == "" {
+		return 0, nil
+	}
+	for n, code := range RevocationReasons {
+		if strings.EqualFold(n, name) {
+			return code, nil
+		}
+	}
+	names := make([]string, 0, len(RevocationReasons))
+	for n := range RevocationReasons {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return 0, fmt.Errorf("unknown revocation reason %q (one of %s)", name, strings.Join(names, ", "))
+}
+
+// RevokeCert revokes the DER certificate cert (RFC 8555 §7.6). With KID set the
+// request is signed by the account that ordered it; without, Key must be the
+// certificate's own private key and is embedded as a JWK.
+func (c *Client) RevokeCert(ctx context.Context, cert []byte, reason int) error {
+	d, err := c.Directory(ctx)
+	if err != nil {
+		return err
+	}
+	if d.RevokeCert == "" {
+		return errors.New("acme: directory has no revokeCert URL")
+	}
+	req := map[string]interface{}{"certificate": b64.EncodeToString(cert)}
+	if reason != 0 {
+		req["reason"] = reason
+	}
+	_, err = c.post(ctx, d.RevokeCert, req, nil, c.KID == "")
+	return err
+}
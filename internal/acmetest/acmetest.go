@@ -0,0 +1,130 @@
+// Package acmetest lets the real request and renew pipeline run against a local
+// Pebble test CA (https://github.com/letsencrypt/pebble): dns-01 records are
+// published through pebble-challtestsrv's management API and the http-01 webroot is
+// served on the port Pebble validates. Nothing here is used against production CAs.
+package acmetest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/trustctl/trustctl/internal/acme"
+)
+
+// Defaults of a Pebble started with its test configuration.
+const (
+	PebbleDirectory     = "https://localhost:14000/dir"
+	DefaultChallTestSrv = "http://localhost:8055"
+	// DefaultHTTPAddr is where Pebble's test configuration (httpPort 5002) sends
+	// http-01 requests.
+	DefaultHTTPAddr = ":5002"
+)
+
+// ProviderName is the DNS provider name the challtestsrv publisher is registered under.
+const ProviderName = "challtestsrv"
+
+// EnvChallTestSrv names the environment variable holding the challtestsrv management
+// URL; when set, `--dns-provider challtestsrv` is available to every command.
+const EnvChallTestSrv = "TRUSTCTL_CHALLTESTSRV"
+
+// ChallTestSrv publishes dns-01 records through pebble-challtestsrv, whose DNS server
+// Pebble resolves names with (pebble -dnsserver). It implements dns.ContextProvider.
+type ChallTestSrv struct {
+	URL  string // management API, e.g. http://localhost:8055
+	HTTP *http.Client
+}
+
+// NewChallTestSrv returns a publisher for the management API at url
+// (DefaultChallTestSrv when empty).
+func NewChallTestSrv(url string) *ChallTestSrv {
+	if url == "" {
+		url = DefaultChallTestSrv
+	}
+	return &ChallTestSrv{URL: strings.TrimSuffix(url, "/"), HTTP: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (c *ChallTestSrv) post(ctx context.Context, path string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return fmt.Errorf("challtestsrv: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("challtestsrv %s: %s: %s", path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// SetTXT adds value to the TXT records challtestsrv answers for host.
+func (c *ChallTestSrv) SetTXT(ctx context.Context, host, value string) error {
+	return c.post(ctx, "/set-txt", map[string]string{"host": fqdn(host), "value": value})
+}
+
+// ClearTXT removes every TXT record challtestsrv answers for host.
+func (c *ChallTestSrv) ClearTXT(ctx context.Context, host string) error {
+	return c.post(ctx, "/clear-txt", map[string]string{"host": fqdn(host)})
+}
+
+// PresentContext publishes the dns-01 record for domain.
+func (c *ChallTestSrv) PresentContext(ctx context.Context, domain, token, keyAuth string) error {
+	return c.SetTXT(ctx, "_acme-challenge."+domain, acme.DNS01Value(keyAuth))
+}
+
+// CleanUpContext removes the dns-01 records for domain.
+func (c *ChallTestSrv) CleanUpContext(ctx context.Context, domain, token, keyAuth string) error {
+	return c.ClearTXT(ctx, "_acme-challenge."+domain)
+}
+
+// Present publishes the dns-01 record for domain.
+func (c *ChallTestSrv) Present(domain, token, keyAuth string) error {
+	return c.PresentContext(context.Background(), domain, token, keyAuth)
+}
+
+// CleanUp removes the dns-01 records for domain.
+func (c *ChallTestSrv) CleanUp(domain, token, keyAuth string) error {
+	return c.CleanUpContext(context.Background(), domain, token, keyAuth)
+}
+
+func fqdn(name string) string {
+	return strings.TrimSuffix(name, ".") + "."
+}
+
+// ServeWebroot serves the http-01 tokens under webroot/.well-known/acme-challenge on
+// addr (DefaultHTTPAddr when empty) until the returned server is closed. Point
+// challtestsrv's answers at this host (pebble-challtestsrv -defaultIPv4) and turn off
+// its own http-01 server (-http01 "") so the port is free.
+func ServeWebroot(addr, webroot string) (*http.Server, error) {
+	if addr == "" {
+		addr = DefaultHTTPAddr
+	}
+	if webroot == "" {
+		return nil, errors.New("webroot required")
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("http-01 listener: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/.well-known/acme-challenge/", http.FileServer(http.Dir(webroot)))
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go srv.Serve(ln)
+	return srv, nil
+}
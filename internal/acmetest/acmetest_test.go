@@ -0,0 +1,99 @@
+package acmetest_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/trustctl/trustctl/internal/acmetest"
+	"github.com/trustctl/trustctl/internal/dns"
+	"github.com/trustctl/trustctl/internal/metadata"
+	"github.com/trustctl/trustctl/internal/paths"
+	"github.com/trustctl/trustctl/pkg/trustctl"
+)
+
+// The test runs against a Pebble started as described in the README; it is skipped
+// unless TRUSTCTL_PEBBLE_DIRECTORY names its directory (acmetest.PebbleDirectory).
+// TRUSTCTL_PEBBLE_CA_FILE is Pebble's minica root and TRUSTCTL_CHALLTESTSRV the
+// challtestsrv management URL (acmetest.DefaultChallTestSrv when unset).
+const (
+	envDirectory = "TRUSTCTL_PEBBLE_DIRECTORY"
+	envCAFile    = "TRUSTCTL_PEBBLE_CA_FILE"
+)
+
+func TestPebbleRequestRenew(t *testing.T) {
+	directory := os.Getenv(envDirectory)
+	if directory == "" {
+		t.Skipf("%s not set; no Pebble to run against", envDirectory)
+	}
+	if caFile := os.Getenv(envCAFile); caFile != "" {
+		t.Setenv("SSL_CERT_FILE", caFile)
+	}
+
+	prevLayout := paths.Current()
+	t.Cleanup(func() { paths.Set(prevLayout) })
+	dir := t.TempDir()
+	layout := paths.FromBase(dir)
+	layout.Webroot = filepath.Join(dir, "www")
+	paths.Set(layout)
+	for _, d := range []string{layout.Certs, layout.Credentials, layout.Logs, layout.Plugins, layout.Webroot} {
+		if err := os.MkdirAll(d, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := metadata.Open(metadata.BackendJSON); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { metadata.Close() })
+
+	dns.Register(acmetest.ProviderName, acmetest.NewChallTestSrv(os.Getenv(acmetest.EnvChallTestSrv)))
+	t.Cleanup(func() { dns.Register(acmetest.ProviderName, nil) })
+	srv, err := acmetest.ServeWebroot(acmetest.DefaultHTTPAddr, layout.Webroot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+
+	for _, tc := range []struct {
+		name string
+		opts trustctl.RequestOptions
+	}{
+		{"http-01", trustctl.RequestOptions{Domains: []string{"http.acmetest.trustctl.test"}, Validation: "http"}},
+		{"dns-01", trustctl.RequestOptions{Domains: []string{"dns.acmetest.trustctl.test", "*.dns.acmetest.trustctl.test"},
+			Validation: "dns", DNSProvider: acmetest.ProviderName}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+			defer cancel()
+			opts := tc.opts
+			opts.DirectoryURL, opts.AgreeTOS, opts.NoInstall = directory, true, true
+			opts.Email = "acmetest@trustctl.test"
+			cert, err := trustctl.Request(ctx, opts)
+			if err != nil {
+				t.Fatalf("request: %v", err)
+			}
+			for _, p := range []string{cert.CertPath, cert.KeyPath} {
+				if _, err := os.Stat(p); err != nil {
+					t.Errorf("request: %v", err)
+				}
+			}
+			issued, err := metadata.Load(opts.Domains[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := trustctl.Renew(ctx, opts.Domains[0], trustctl.RenewOptions{Force: true}); err != nil {
+				t.Fatalf("renew: %v", err)
+			}
+			renewed, err := metadata.Load(opts.Domains[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if renewed.Serial == issued.Serial {
+				t.Errorf("renew kept serial %s", issued.Serial)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package bundle
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/trustctl/trustctl/internal/httpclient"
+)
+
+// maxAIAHops bounds how many issuers Complete fetches.
+const maxAIAHops = 5
+
+// AIAClient fetches issuer certificates from Authority Information Access URLs; nil
+// uses an httpclient client with a 15s timeout.
+var AIAClient *http.Client
+
+// Complete orders certs into a path starting at the leaf (certs[0]) and, where an
+// issuer is missing, fetches it from the CA Issuers URL in the Authority Information
+// Access extension. Certificates that are not on the leaf's path are dropped. It
+// returns the path, the certificates that were fetched, and an error if the path
+// still does not verify against the system roots (the path is returned regardless).
+func Complete(certs []*x509.Certificate) (path, fetched []*x509.Certificate, err error) {
+	if len(certs) == 0 {
+		return nil, nil, errors.New("no certificates")
+	}
+	path = []*x509.Certificate{certs[0]}
+	for hops := 0; ; {
+		if verifyPath(path) == nil {
+			// Keep any remaining issuers that were supplied, such as the root
+			for {
+				issuer := findIssuer(path[len(path)-1], certs)
+				if issuer == nil || contains(path, issuer) {
+					return path, fetched, nil
+				}
+				path = append(path, issuer)
+			}
+		}
+		cur := path[len(path)-1]
+		if isSelfSigned(cur) {
+			break
+		}
+		if issuer := findIssuer(cur, certs); issuer != nil && !contains(path, issuer) {
+			path = append(path, issuer)
+			continue
+		}
+		if hops == maxAIAHops {
+			break
+		}
+		hops++
+		issuer, ferr := fetchIssuer(cur)
+		if ferr != nil {
+			return path, fetched, fmt.Errorf("chain incomplete after %s: %w", cur.Subject, ferr)
+		}
+		path = append(path, issuer)
+		fetched = append(fetched, issuer)
+	}
+	return path, fetched, verifyPath(path)
+}
+
+func verifyPath(path []*x509.Certificate) error {
+	inter := x509.NewCertPool()
+	for _, c := range path[1:] {
+		inter.AddCert(c)
+	}
+	_, err := path[0].Verify(x509.VerifyOptions{Intermediates: inter, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}})
+	return err
+}
+
+func isSelfSigned(c *x509.Certificate) bool {
+	return bytes.Equal(c.RawIssuer, c.RawSubject) && c.CheckSignatureFrom(c) == nil
+}
+
+func findIssuer(c *x509.Certificate, pool []*x509.Certificate) *x509.Certificate {
+	for _, p := range pool {
+		if p != c && bytes.Equal(c.RawIssuer, p.RawSubject) && c.CheckSignatureFrom(p) == nil {
+			return p
+		}
+	}
+	return nil
+}
+
+func contains(path []*x509.Certificate, c *x509.Certificate) bool {
+	for _, p := range path {
+		if p.Equal(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchIssuer downloads c's issuer (DER or PEM) from its CA Issuers URLs.
+func fetchIssuer(c *x509.Certificate) (*x509.Certificate, error) {
+	if len(c.IssuingCertificateURL) == 0 {
+		return nil, errors.New("no CA Issuers URL in the Authority Information Access extension")
+	}
+	var lastErr error
+	for _, url := range c.IssuingCertificateURL {
+		issuer, err := fetchCert(url)
+		if err == nil {
+			err = c.CheckSignatureFrom(issuer)
+		}
+		if err != nil {
+			lastErr = fmt.Errorf("%s: %w", url, err)
+			continue
+		}
+		return issuer, nil
+	}
+	return nil, lastErr
+}
+
+func fetchCert(url string) (*x509.Certificate, error) {
+	client := AIAClient
+	if client == nil {
+		client = httpclient.New(15 * time.Second)
+	}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if b, _ := pem.Decode(data); b != nil && b.Type == "CERTIFICATE" {
+		data = b.Bytes
+	}
+	cert, err := x509.ParseCertificate(data)
+	if err != nil {
+		return nil, fmt.Errorf("not a DER or PEM certificate (PKCS#7 bundles are not supported): %w", err)
+	}
+	return cert, nil
+}
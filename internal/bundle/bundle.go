@@ -0,0 +1,122 @@
+package bundle
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+)
+
+// Options control which files are written next to fullchain.pem and what they contain.
+type Options struct {
+	// IncludeRoot keeps a self-signed root in chain.pem/fullchain.pem when the CA sends one.
+	// Servers should not send the root, so it is dropped by default.
+	IncludeRoot bool `json:"include_root,omitempty"`
+	// Combined also writes combined.pem (key, leaf, chain) for HAProxy-style consumers.
+	Combined bool `json:"combined,omitempty"`
+}
+
+// Files are the paths written by Write; empty when not produced.
+type Files struct {
+	Cert      string // leaf only
+	Chain     string // intermediates only
+	FullChain string // leaf first, then intermediates
+	Combined  string // private key, leaf, intermediates
+
+	Fetched  []string // subjects of intermediates fetched through AIA
+	ChainErr error    // set when the chain still does not verify against the system roots
+}
+
+// Write splits the PEM chain issued by the CA into cert.pem, chain.pem and fullchain.pem
+// in dir (plus combined.pem when requested), completing the chain through AIA where
+// needed. Data that holds no parseable certificate is written to fullchain.pem unchanged.
+func Write(dir string, chainPEM []byte, keyPath string, opts Options) (*Files, error) {
+	var certs []*x509.Certificate
+	rest := chainPEM
+	for {
+		var b *pem.Block
+		b, rest = pem.Decode(rest)
+		if b == nil {
+			break
+		}
+		if b.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(b.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, c)
+	}
+
+	files := &Files{FullChain: filepath.Join(dir, "fullchain.pem")}
+	if len(certs) == 0 {
+		return files, os.WriteFile(files.FullChain, chainPEM, 0644)
+	}
+
+	// CAs that return only the leaf, and certificates imported from elsewhere, often
+	// lack intermediates; fetch them rather than serve a chain clients cannot build
+	path, fetched, err := Complete(certs)
+	files.ChainErr = err
+	for _, c := range fetched {
+		files.Fetched = append(files.Fetched, c.Subject.String())
+	}
+
+	leaf, chain := path[0], path[1:]
+	if !opts.IncludeRoot {
+		chain = withoutRoots(chain)
+	}
+	leafPEM := encode(leaf)
+	chainOnly := encode(chain...)
+	full := append(append([]byte{}, leafPEM...), chainOnly...)
+
+	files.Cert = filepath.Join(dir, "cert.pem")
+	if err := os.WriteFile(files.Cert, leafPEM, 0644); err != nil {
+		return nil, err
+	}
+	if len(chain) > 0 {
+		files.Chain = filepath.Join(dir, "chain.pem")
+		if err := os.WriteFile(files.Chain, chainOnly, 0644); err != nil {
+			return nil, err
+		}
+	} else {
+		os.Remove(filepath.Join(dir, "chain.pem"))
+	}
+	if err := os.WriteFile(files.FullChain, full, 0644); err != nil {
+		return nil, err
+	}
+	if opts.Combined {
+		key, err := os.ReadFile(keyPath)
+		if err != nil {
+			return nil, err
+		}
+		files.Combined = filepath.Join(dir, "combined.pem")
+		// Contains the private key: owner-only like privkey.pem
+		if err := os.WriteFile(files.Combined, append(bytes.TrimRight(key, "\n"), append([]byte("\n"), full...)...), 0600); err != nil {
+			return nil, err
+		}
+	} else {
+		os.Remove(filepath.Join(dir, "combined.pem"))
+	}
+	return files, nil
+}
+
+func withoutRoots(chain []*x509.Certificate) []*x509.Certificate {
+	var out []*x509.Certificate
+	for _, c := range chain {
+		if bytes.Equal(c.RawIssuer, c.RawSubject) && c.CheckSignatureFrom(c) == nil {
+			continue
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+func encode(certs ...*x509.Certificate) []byte {
+	var buf bytes.Buffer
+	for _, c := range certs {
+		pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,61 @@
+package bundle
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+)
+
+// SelectChain picks, among the chains offered by the CA (default first, then the
+// alternates), the first whose topmost certificate is issued by or is the root named
+// preferred (its Common Name, compared case-insensitively), as certbot's
+// --preferred-chain does. Without a match, or with preferred empty, the default
+// chain is returned and matched is false.
+func SelectChain(chains [][]byte, preferred string) (chain []byte, matched bool) {
+	if len(chains) == 0 {
+		return nil, false
+	}
+	if preferred == "" {
+		return chains[0], false
+	}
+	for _, c := range chains {
+		top := topmost(c)
+		if top == nil {
+			continue
+		}
+		if strings.EqualFold(top.Issuer.CommonName, preferred) || strings.EqualFold(top.Subject.CommonName, preferred) {
+			return c, true
+		}
+	}
+	return chains[0], false
+}
+
+// ChainRoots returns, for each chain, the Common Name of the root its topmost certificate chains to.
+func ChainRoots(chains [][]byte) []string {
+	out := make([]string, len(chains))
+	for i, c := range chains {
+		if top := topmost(c); top != nil {
+			out[i] = top.Issuer.CommonName
+		}
+	}
+	return out
+}
+
+// topmost returns the last certificate in a PEM chain.
+func topmost(chainPEM []byte) *x509.Certificate {
+	var last *x509.Certificate
+	rest := chainPEM
+	for {
+		var b *pem.Block
+		b, rest = pem.Decode(rest)
+		if b == nil {
+			return last
+		}
+		if b.Type != "CERTIFICATE" {
+			continue
+		}
+		if c, err := x509.ParseCertificate(b.Bytes); err == nil {
+			last = c
+		}
+	}
+}
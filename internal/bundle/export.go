@@ -0,0 +1,104 @@
+package bundle
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/trustctl/trustctl/internal/keygen"
+)
+
+// Export formats.
+const (
+	FormatPEM    = "pem"
+	FormatDER    = "der"    // a single certificate
+	FormatPKCS12 = "pkcs12" // password-protected; the key is optional
+	FormatP7B    = "p7b"    // PEM "PKCS7" certificate bag, no key
+)
+
+// Export contents, in the order they are written.
+const (
+	ContentCert         = "cert"          // leaf only
+	ContentChain        = "chain"         // intermediates only
+	ContentFullChain    = "fullchain"     // leaf first, then intermediates
+	ContentFullChainKey = "fullchain+key" // leaf, intermediates and the private key
+)
+
+// DefaultContent returns what a format carries when no content is asked for: the
+// leaf for DER, the key with its chain for PKCS#12 and the full chain otherwise.
+func DefaultContent(format string) string {
+	switch format {
+	case FormatDER:
+		return ContentCert
+	case FormatPKCS12:
+		return ContentFullChainKey
+	}
+	return ContentFullChain
+}
+
+// Select returns the certificates of leaf and chain that content includes.
+func Select(content string, leaf *x509.Certificate, chain []*x509.Certificate) ([]*x509.Certificate, error) {
+	switch content {
+	case ContentCert:
+		return []*x509.Certificate{leaf}, nil
+	case ContentChain:
+		if len(chain) == 0 {
+			return nil, errors.New("the certificate has no intermediates to export")
+		}
+		return chain, nil
+	case ContentFullChain, ContentFullChainKey:
+		return append([]*x509.Certificate{leaf}, chain...), nil
+	}
+	return nil, fmt.Errorf("unknown content %q (expected cert, chain, fullchain or fullchain+key)", content)
+}
+
+// Export encodes certs, and key when not nil, in format. PKCS#12 files are
+// protected by password, which must then be set; alias becomes the friendly name
+// of the key entry (the Java keystore alias).
+func Export(format string, certs []*x509.Certificate, key crypto.Signer, password []byte, alias string) ([]byte, error) {
+	if len(certs) == 0 {
+		return nil, errors.New("no certificates to export")
+	}
+	switch format {
+	case FormatPEM:
+		out := encode(certs...)
+		if key != nil {
+			der, err := x509.MarshalPKCS8PrivateKey(key)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})...)
+		}
+		return out, nil
+	case FormatDER:
+		if key != nil || len(certs) > 1 {
+			return nil, errors.New("der holds a single certificate; export cert or chain with one intermediate, or use pem, p7b or pkcs12")
+		}
+		return bytes.Clone(certs[0].Raw), nil
+	case FormatP7B:
+		if key != nil {
+			return nil, errors.New("p7b cannot hold a private key; use pkcs12 or pem")
+		}
+		der, err := marshalPKCS7(certs)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PKCS7", Bytes: der}), nil
+	case FormatPKCS12:
+		if len(password) == 0 {
+			return nil, errors.New("pkcs12 needs a password")
+		}
+		var keyDER []byte
+		if key != nil {
+			var err error
+			if keyDER, err = keygen.MarshalEncryptedPKCS8(key, password); err != nil {
+				return nil, err
+			}
+		}
+		return marshalPKCS12(certs, keyDER, password, alias)
+	}
+	return nil, fmt.Errorf("unknown format %q (expected pem, der, pkcs12 or p7b)", format)
+}
@@ -0,0 +1,276 @@
+package bundle
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"hash"
+	"io"
+	"unicode/utf16"
+)
+
+// PKCS#12 files are written as OpenSSL 3 writes them by default: the key in a
+// PBES2-encrypted PKCS#8 bag, the certificates in plain bags and an
+// HMAC-SHA256 MAC over both (RFC 7292).
+var (
+	oidData                = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidCertBag             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidShroudedKeyBag      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidX509Certificate     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidFriendlyName        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidLocalKeyID          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+	oidSHA256              = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidJavaTrustedKeyUsage = asn1.ObjectIdentifier{2, 16, 840, 1, 113894, 746875, 1, 1}
+	oidAnyExtendedKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 37, 0}
+)
+
+const pkcs12MACIterations = 2048
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"optional"`
+}
+
+type safeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue
+	Attributes []pkcs12Attribute `asn1:"set,optional"`
+}
+
+type pkcs12Attribute struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+type certBag struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+type digestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type macData struct {
+	Mac        digestInfo
+	Salt       []byte
+	Iterations int
+}
+
+type pfx struct {
+	Version  int
+	AuthSafe contentInfo
+	MacData  macData
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	ContentInfo      contentInfo
+	Certificates     asn1.RawValue
+	SignerInfos      asn1.RawValue
+}
+
+// explicit wraps DER in a [0] EXPLICIT tag.
+func explicit(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+}
+
+// set wraps the concatenated DER elements in a SET.
+func set(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: der}
+}
+
+// dataContent returns a ContentInfo of type data holding der.
+func dataContent(der []byte) (contentInfo, error) {
+	octets, err := asn1.Marshal(der)
+	if err != nil {
+		return contentInfo{}, err
+	}
+	return contentInfo{ContentType: oidData, Content: explicit(octets)}, nil
+}
+
+func attribute(id asn1.ObjectIdentifier, value interface{}) (pkcs12Attribute, error) {
+	der, err := asn1.Marshal(value)
+	if err != nil {
+		return pkcs12Attribute{}, err
+	}
+	return pkcs12Attribute{ID: id, Value: set(der)}, nil
+}
+
+// bmpString encodes s as a BMPString, the UCS-2 big-endian encoding of PKCS#12
+// friendly names and passwords.
+func bmpString(s string) []byte {
+	var out []byte
+	for _, r := range utf16.Encode([]rune(s)) {
+		out = append(out, byte(r>>8), byte(r))
+	}
+	return out
+}
+
+// marshalPKCS12 returns a PFX holding certs (leaf first) and, when keyDER is set,
+// the EncryptedPrivateKeyInfo of the leaf's key named alias. Without a key the
+// certificates are marked trusted, so Java loads the file as a truststore.
+func marshalPKCS12(certs []*x509.Certificate, keyDER, password []byte, alias string) ([]byte, error) {
+	localKeyID := sha256.Sum256(certs[0].Raw)
+	var keyAttrs []pkcs12Attribute
+	if keyDER != nil {
+		id, err := attribute(oidLocalKeyID, localKeyID[:20])
+		if err != nil {
+			return nil, err
+		}
+		keyAttrs = append(keyAttrs, id)
+		if alias != "" {
+			keyAttrs = append(keyAttrs, pkcs12Attribute{ID: oidFriendlyName, Value: set(bmpAttribute(alias))})
+		}
+	}
+
+	var bags []safeBag
+	for i, c := range certs {
+		octets, err := asn1.Marshal(c.Raw)
+		if err != nil {
+			return nil, err
+		}
+		cb, err := asn1.Marshal(certBag{ID: oidX509Certificate, Value: explicit(octets)})
+		if err != nil {
+			return nil, err
+		}
+		bag := safeBag{ID: oidCertBag, Value: explicit(cb)}
+		switch {
+		case keyDER != nil && i == 0:
+			bag.Attributes = keyAttrs
+		case keyDER == nil:
+			trusted, err := attribute(oidJavaTrustedKeyUsage, oidAnyExtendedKeyUsage)
+			if err != nil {
+				return nil, err
+			}
+			bag.Attributes = []pkcs12Attribute{trusted}
+			if i == 0 && alias != "" {
+				bag.Attributes = append(bag.Attributes, pkcs12Attribute{ID: oidFriendlyName, Value: set(bmpAttribute(alias))})
+			}
+		}
+		bags = append(bags, bag)
+	}
+	certContents, err := asn1.Marshal(bags)
+	if err != nil {
+		return nil, err
+	}
+	certInfo, err := dataContent(certContents)
+	if err != nil {
+		return nil, err
+	}
+	safes := []contentInfo{certInfo}
+	if keyDER != nil {
+		keyContents, err := asn1.Marshal([]safeBag{{ID: oidShroudedKeyBag, Value: explicit(keyDER), Attributes: keyAttrs}})
+		if err != nil {
+			return nil, err
+		}
+		keyInfo, err := dataContent(keyContents)
+		if err != nil {
+			return nil, err
+		}
+		safes = append(safes, keyInfo)
+	}
+	authSafe, err := asn1.Marshal(safes)
+	if err != nil {
+		return nil, err
+	}
+	authInfo, err := dataContent(authSafe)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	macKey := pkcs12KDF(sha256.New, 64, 3, append(bmpString(string(password)), 0, 0), salt, pkcs12MACIterations, 32)
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(authSafe)
+	return asn1.Marshal(pfx{
+		Version:  3,
+		AuthSafe: authInfo,
+		MacData: macData{
+			Mac:        digestInfo{Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue}, Digest: mac.Sum(nil)},
+			Salt:       salt,
+			Iterations: pkcs12MACIterations,
+		},
+	})
+}
+
+// bmpAttribute returns the DER BMPString of s.
+func bmpAttribute(s string) []byte {
+	der, _ := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagBMPString, Bytes: bmpString(s)})
+	return der
+}
+
+// pkcs12KDF derives size bytes of key material of type id (3 for MAC keys) from a
+// BMPString password, as RFC 7292 appendix B.2 describes. v is the hash block size.
+func pkcs12KDF(h func() hash.Hash, v int, id byte, password, salt []byte, iter, size int) []byte {
+	fill := func(b []byte) []byte {
+		if len(b) == 0 {
+			return nil
+		}
+		out := make([]byte, v*((len(b)+v-1)/v))
+		for i := range out {
+			out[i] = b[i%len(b)]
+		}
+		return out
+	}
+	d := make([]byte, v)
+	for i := range d {
+		d[i] = id
+	}
+	in := append(fill(salt), fill(password)...)
+
+	var out []byte
+	for len(out) < size {
+		hh := h()
+		hh.Write(d)
+		hh.Write(in)
+		a := hh.Sum(nil)
+		for i := 1; i < iter; i++ {
+			hh.Reset()
+			hh.Write(a)
+			a = hh.Sum(nil)
+		}
+		out = append(out, a...)
+
+		// I_j = (I_j + B + 1) mod 2^(8v) for every v-byte block of I
+		b := fill(a)[:v]
+		for j := 0; j < len(in); j += v {
+			carry := 1
+			for k := v - 1; k >= 0; k-- {
+				sum := int(in[j+k]) + int(b[k]) + carry
+				in[j+k] = byte(sum)
+				carry = sum >> 8
+			}
+		}
+	}
+	return out[:size]
+}
+
+// marshalPKCS7 returns a degenerate PKCS#7 SignedData carrying certs and no
+// signatures, the certificate-only .p7b bundle Windows and Java import.
+func marshalPKCS7(certs []*x509.Certificate) ([]byte, error) {
+	var raw []byte
+	for _, c := range certs {
+		raw = append(raw, c.Raw...)
+	}
+	sd, err := asn1.Marshal(signedData{
+		Version:          1,
+		DigestAlgorithms: set(nil),
+		ContentInfo:      contentInfo{ContentType: oidData},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: raw},
+		SignerInfos:      set(nil),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(contentInfo{ContentType: oidSignedData, Content: explicit(sd)})
+}
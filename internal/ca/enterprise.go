@@ -0,0 +1,184 @@
+package ca
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/trustctl/trustctl/internal/httpclient"
+	"github.com/trustctl/trustctl/internal/ratelimit"
+	"github.com/trustctl/trustctl/internal/ui"
+)
+
+// Enterprise CA requests are signed with the HMAC credentials: X-Trustctl-Signature is
+// the base64 HMAC-SHA256 of "<method>\n<path?query>\n<X-Trustctl-Date>\n<hex SHA-256
+// of the body>" under the HMAC key, and X-Trustctl-Key-Id names the key.
+func (e *enterpriseClient) do(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	target := path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(e.serverURL, "/")+target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	date := time.Now().UTC().Format(time.RFC3339)
+	sum := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(e.hmacKey))
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s", method, target, date, hex.EncodeToString(sum[:]))
+	req.Header.Set("X-Trustctl-Key-Id", e.hmacID)
+	req.Header.Set("X-Trustctl-Date", date)
+	req.Header.Set("X-Trustctl-Signature", base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := e.http
+	if client == nil {
+		client = httpclient.New(30 * time.Second)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	ui.Debug("enterprise CA: %s %s: %s", method, target, resp.Status)
+	if resp.StatusCode >= 400 {
+		detail := strings.TrimSpace(string(data))
+		if rl := ratelimit.FromResponse(resp, detail); rl != nil {
+			return rl
+		}
+		return fmt.Errorf("enterprise CA %s %s: %s: %s", method, path, resp.Status, detail)
+	}
+	if out != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, out); err != nil {
+			return fmt.Errorf("enterprise CA %s %s: decode response: %w", method, path, err)
+		}
+	}
+	return nil
+}
+
+// Certificate requests the enterprise CA does not answer right away are checked every
+// IssuePollInterval for up to IssueTimeout.
+var (
+	IssueTimeout      = time.Hour
+	IssuePollInterval = 15 * time.Second
+)
+
+// enterpriseCertificate is the CA's view of a certificate request.
+type enterpriseCertificate struct {
+	ID          string `json:"id"`
+	Status      string `json:"status"`                // pending, issued, rejected
+	Certificate string `json:"certificate,omitempty"` // PEM, the leaf followed by its chain
+	Detail      string `json:"detail,omitempty"`
+}
+
+// RequestCertificate submits csr for domains (POST /certificates) and, while the CA
+// reports the request as pending, polls GET /certificates/<id> until it is issued.
+// Rate-limited responses come back as *ratelimit.Error from do.
+func (e *enterpriseClient) RequestCertificate(ctx context.Context, domains []string, csr []byte) (*CertificateMeta, error) {
+	var cert enterpriseCertificate
+	if err := e.do(ctx, http.MethodPost, "/certificates", nil, map[string]interface{}{"domains": domains, "csr": string(csr)}, &cert); err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(IssueTimeout)
+	for cert.Status == "pending" && cert.Certificate == "" {
+		if cert.ID == "" {
+			return nil, errors.New("enterprise CA: pending certificate request without an id")
+		}
+		if !time.Now().Before(deadline) {
+			return nil, fmt.Errorf("enterprise CA: certificate request %s still pending after %s", cert.ID, IssueTimeout)
+		}
+		ui.Debug("enterprise CA: certificate request %s pending", cert.ID)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(IssuePollInterval):
+		}
+		if err := e.do(ctx, http.MethodGet, "/certificates/"+url.PathEscape(cert.ID), nil, nil, &cert); err != nil {
+			return nil, err
+		}
+	}
+	if cert.Certificate == "" {
+		msg := fmt.Sprintf("enterprise CA: certificate request for %s is %s", strings.Join(domains, ", "), cert.Status)
+		if cert.Detail != "" {
+			msg += ": " + cert.Detail
+		}
+		return nil, errors.New(msg)
+	}
+	return &CertificateMeta{Domains: domains, PEM: []byte(cert.Certificate), Issuer: IssuerName("", e.serverURL)}, nil
+}
+
+// ApproverEmails returns the addresses the CA will send a domain control validation
+// email to for domain (WHOIS contacts and the constructed admin@, hostmaster@, ...).
+func (e *enterpriseClient) ApproverEmails(ctx context.Context, domain string) ([]string, error) {
+	var resp struct {
+		Approvers []string `json:"approvers"`
+	}
+	if err := e.do(ctx, http.MethodGet, "/dcv/approvers", url.Values{"domain": {domain}}, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Approvers, nil
+}
+
+// StartEmailDCV asks the CA to send the validation email for domain to approver.
+func (e *enterpriseClient) StartEmailDCV(ctx context.Context, domain, approver string) error {
+	return e.do(ctx, http.MethodPost, "/dcv/email", nil, map[string]string{"domain": domain, "approver": approver}, nil)
+}
+
+// EmailDCVStatus reports whether domain has been approved. A rejected or expired
+// validation is returned as an error.
+func (e *enterpriseClient) EmailDCVStatus(ctx context.Context, domain string) (bool, error) {
+	var resp struct {
+		Status string `json:"status"` // pending, approved, rejected, expired
+		Detail string `json:"detail,omitempty"`
+	}
+	if err := e.do(ctx, http.MethodGet, "/dcv/status", url.Values{"domain": {domain}}, nil, &resp); err != nil {
+		return false, err
+	}
+	switch resp.Status {
+	case "approved":
+		return true, nil
+	case "pending", "":
+		return false, nil
+	}
+	msg := fmt.Sprintf("domain control validation for %s is %s", domain, resp.Status)
+	if resp.Detail != "" {
+		msg += ": " + resp.Detail
+	}
+	return false, errors.New(msg)
+}
+
+// Revoker is implemented by CA clients that revoke certificates through their own
+// API rather than ACME.
+type Revoker interface {
+	Revoke(ctx context.Context, serial string, reason int) error
+}
+
+// Revoke asks the CA to revoke the certificate with the given serial (hex), giving
+// the RFC 5280 reason code.
+func (e *enterpriseClient) Revoke(ctx context.Context, serial string, reason int) error {
+	return e.do(ctx, http.MethodPost, "/certificates/revoke", nil, map[string]interface{}{"serial": serial, "reason": reason}, nil)
+}
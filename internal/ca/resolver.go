@@ -1,10 +1,14 @@
 package ca
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"time"
+	"net/http"
+	"sort"
+	"strings"
 
+	"github.com/trustctl/trustctl/internal/acme"
 	"github.com/trustctl/trustctl/internal/ui"
 )
 
@@ -14,11 +18,15 @@ type CertificateMeta struct {
 	PEM     []byte
 	Key     []byte
 	Issuer  string
+	// Alternates are other chains for the same certificate offered by the CA
+	// (ACME Link rel="alternate"), e.g. through a different root.
+	Alternates [][]byte
 }
 
-// CAClient represents a CA implementation (Let's Encrypt or Enterprise)
+// CAClient represents a CA implementation (Let's Encrypt or Enterprise). csr is the
+// PEM certificate request for domains; cancelling ctx aborts the request.
 type CAClient interface {
-	RequestCertificate(domains []string) (*CertificateMeta, error)
+	RequestCertificate(ctx context.Context, domains []string, csr []byte) (*CertificateMeta, error)
 }
 
 // Resolver chooses CA implementation based on flags/credentials
@@ -30,11 +38,55 @@ func NewResolver(credsDir string) *Resolver {
 	return &Resolver{credsDir: credsDir}
 }
 
-// Resolve chooses LE (ACME v2) if serverURL is empty, else returns an enterprise client.
-func (r *Resolver) Resolve(serverURL, hmacID, hmacKey string) (CAClient, error) {
+// acmeIssuers are the display names of the ACME CAs in acme.KnownDirectories.
+var acmeIssuers = map[string]string{
+	"letsencrypt": "Let's Encrypt",
+	"zerossl":     "ZeroSSL",
+	"google":      "Google Trust Services",
+	"buypass":     "Buypass",
+}
+
+// ACMENames returns the ACME CAs that can be selected by name, sorted.
+func ACMENames() []string {
+	names := make([]string, 0, len(acme.KnownDirectories))
+	for name := range acme.KnownDirectories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IssuerName returns a display name for a CA: the enterprise server URL, or the ACME CA's name.
+func IssuerName(caName, serverURL string) string {
+	if serverURL != "" {
+		return serverURL
+	}
+	if name, ok := acmeIssuers[caName]; ok {
+		return name
+	}
+	if caName == "" {
+		return acmeIssuers["letsencrypt"]
+	}
+	return caName
+}
+
+// Resolve returns an ACME client for caName (letsencrypt when empty) at directoryURL
+// (the CA's production directory when empty) if serverURL is empty, else an enterprise
+// client. For ACME CAs the HMAC credentials are the external account binding, which is
+// only needed when the account is registered.
+func (r *Resolver) Resolve(caName, directoryURL, serverURL, hmacID, hmacKey string) (CAClient, error) {
 	if serverURL == "" {
-		// Default to Let's Encrypt ACME v2 client (scaffold)
-		return &letsencryptClient{}, nil
+		if caName == "" {
+			caName = "letsencrypt"
+		}
+		if _, ok := acme.KnownDirectories[caName]; !ok {
+			return nil, fmt.Errorf("unknown ACME CA %q (known: %s)", caName, strings.Join(ACMENames(), ", "))
+		}
+		if directoryURL == "" {
+			directoryURL = acme.KnownDirectories[caName]
+		}
+		// ACME v2 client (scaffold)
+		return &acmeClient{issuer: IssuerName(caName, ""), directoryURL: directoryURL}, nil
 	}
 	if hmacID == "" || hmacKey == "" {
 		return nil, errors.New("hmac-id and hmac-key are required for enterprise CA")
@@ -43,28 +95,22 @@ func (r *Resolver) Resolve(serverURL, hmacID, hmacKey string) (CAClient, error)
 	return &enterpriseClient{serverURL: serverURL, hmacID: hmacID, hmacKey: hmacKey}, nil
 }
 
-type letsencryptClient struct{}
+type acmeClient struct {
+	issuer       string
+	directoryURL string
+}
 
-func (l *letsencryptClient) RequestCertificate(domains []string) (*CertificateMeta, error) {
-	// Here one would integrate with an ACME library (e.g. lego) to actually request certs.
-	// This scaffold returns placeholder data.
-	return &CertificateMeta{Domains: domains, PEM: []byte("---BEGIN CERT---\n..."), Key: []byte("---KEY---"), Issuer: "Let's Encrypt"}, nil
+func (l *acmeClient) RequestCertificate(ctx context.Context, domains []string, csr []byte) (*CertificateMeta, error) {
+	// Orders of registered accounts are driven through internal/acme by the pipeline,
+	// so only accounts stored without an ACME account URL get here
+	return nil, fmt.Errorf("the %s account was stored without being registered at %s; remove it from the credentials directory so the next request registers it", l.issuer, l.directoryURL)
 }
 
 type enterpriseClient struct {
 	serverURL string
 	hmacID    string
 	hmacKey   string
-}
-
-func (e *enterpriseClient) RequestCertificate(domains []string) (*CertificateMeta, error) {
-	// Implement HMAC authenticated REST calls to the enterprise CA (Sectigo/DigiCert).
-	// Scaffold: simulate a request and response.
-	time.Sleep(1 * time.Second)
-	if e.serverURL == "" {
-		return nil, fmt.Errorf("serverURL required for enterprise client")
-	}
-	return &CertificateMeta{Domains: domains, PEM: []byte("---BEGIN CERT ENTERPRISE---\n..."), Key: []byte("---KEY---"), Issuer: "EnterpriseCA"}, nil
+	http      *http.Client // nil uses a client with a 30s timeout
 }
 
 // InstallCertificate persists the certificate into the file system atomically and returns error on failure.
@@ -74,7 +120,7 @@ func InstallCertificate(meta *CertificateMeta) error {
 	if meta == nil {
 		return errors.New("nil certificate meta")
 	}
-	// In a real implementation write to /opt/trustctl/certs/<domain>/ with chmod 0700 and owner root.
+	// In a real implementation write to <certs>/<domain>/ with chmod 0700 and owner root.
 	// Use UI success message instead of plain fmt
 	// avoid printing secret material
 	// NOTE: actual write/atomic replace is not implemented in this scaffold
@@ -0,0 +1,101 @@
+package certinfo
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"os"
+	"strings"
+	"time"
+)
+
+// Info summarizes a PEM bundle: the leaf certificate followed by any chain certificates.
+type Info struct {
+	Leaf              *x509.Certificate
+	Chain             []*x509.Certificate
+	Serial            string
+	FingerprintSHA256 string
+	IssuerDN          string
+	SANs              []string
+	NotBefore         time.Time
+	NotAfter          time.Time
+}
+
+// Parse decodes every CERTIFICATE block in data. The first block is treated as the leaf.
+func Parse(data []byte) (*Info, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, c)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no certificates found in PEM data")
+	}
+	leaf := certs[0]
+	return &Info{
+		Leaf:              leaf,
+		Chain:             certs[1:],
+		Serial:            FormatSerial(leaf),
+		FingerprintSHA256: Fingerprint(leaf),
+		IssuerDN:          leaf.Issuer.String(),
+		SANs:              SANs(leaf),
+		NotBefore:         leaf.NotBefore,
+		NotAfter:          leaf.NotAfter,
+	}, nil
+}
+
+// ParseFile reads and parses a PEM bundle from disk.
+func ParseFile(path string) (*Info, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Fingerprint returns the lowercase hex SHA-256 digest of the DER certificate.
+func Fingerprint(c *x509.Certificate) string {
+	sum := sha256.Sum256(c.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// FormatSerial renders the serial number as colon-separated hex, as openssl does.
+func FormatSerial(c *x509.Certificate) string {
+	b := c.SerialNumber.Bytes()
+	parts := make([]string, len(b))
+	for i, v := range b {
+		parts[i] = hex.EncodeToString([]byte{v})
+	}
+	return strings.Join(parts, ":")
+}
+
+// SANs returns the DNS and IP subject alternative names of c.
+func SANs(c *x509.Certificate) []string {
+	out := append([]string{}, c.DNSNames...)
+	for _, ip := range c.IPAddresses {
+		out = append(out, ip.String())
+	}
+	return out
+}
+
+// ChainSubjects returns the subject DNs of the chain certificates, leaf excluded.
+func (i *Info) ChainSubjects() []string {
+	out := make([]string, len(i.Chain))
+	for n, c := range i.Chain {
+		out[n] = c.Subject.String()
+	}
+	return out
+}
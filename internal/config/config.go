@@ -0,0 +1,100 @@
+// Package config reads trustctl's configuration file, which supplies defaults for
+// command-line flags and the state directories. Flags and TRUSTCTL_* environment
+// variables take precedence over it.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultPath is read when neither --config nor $TRUSTCTL_CONFIG names a file.
+const DefaultPath = "/etc/trustctl/config.yaml"
+
+// File is the content of the configuration file. Empty values leave the built-in
+// default alone.
+type File struct {
+	// Defaults for request
+	CA            string `yaml:"ca"`             // ACME CA name, or a comma-separated list with fallbacks
+	ACMEDirectory string `yaml:"acme_directory"` // ACME directory URL instead of the CA's
+	Email         string `yaml:"email"`
+	KeyType       string `yaml:"key_type"`
+	Hooks         Hooks  `yaml:"hooks"`
+
+	// RenewDaysBeforeExpiry renews certificates without their own setting this many
+	// days before expiry.
+	RenewDaysBeforeExpiry int `yaml:"renew_days_before_expiry"`
+
+	// Backups limits the vhost backups kept of each web server config file.
+	Backups Backups `yaml:"backups"`
+
+	// State directories; absolute paths
+	CertsDir       string `yaml:"certs_dir"`
+	CredentialsDir string `yaml:"credentials_dir"`
+	PluginsDir     string `yaml:"plugins_dir"`
+	LogsDir        string `yaml:"logs_dir"`
+	Database       string `yaml:"database"`
+	Webroot        string `yaml:"webroot"` // document root for HTTP validation
+
+	// TemplatesDir holds the installer's 443 vhost templates (default /etc/trustctl/templates).
+	TemplatesDir string `yaml:"templates_dir"`
+	// TLSProfile is the Mozilla TLS profile of new 443 vhosts: modern, intermediate
+	// (default) or old.
+	TLSProfile string `yaml:"tls_profile"`
+}
+
+// Backups is the retention policy of vhost backups.
+type Backups struct {
+	KeepLast int    `yaml:"keep_last"` // newest backups kept per file (default 10)
+	MaxAge   string `yaml:"max_age"`   // also remove backups older than this, e.g. 90d
+}
+
+// Hooks are the default hook commands of new certificates.
+type Hooks struct {
+	Pre    string `yaml:"pre"`
+	Post   string `yaml:"post"`
+	Deploy string `yaml:"deploy"`
+}
+
+// Path returns the configuration file to read: flag when set, else $TRUSTCTL_CONFIG,
+// else DefaultPath. explicit reports whether the file was asked for and so must exist.
+func Path(flag string) (path string, explicit bool) {
+	if flag != "" {
+		return flag, true
+	}
+	if p := os.Getenv("TRUSTCTL_CONFIG"); p != "" {
+		return p, true
+	}
+	return DefaultPath, false
+}
+
+// Load reads the configuration file at path. A missing file yields an empty
+// configuration unless explicit is set. Unknown keys are errors, so a typo does not
+// silently leave a default in place.
+func Load(path string, explicit bool) (*File, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return &File{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	f := &File{}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(f); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if f.RenewDaysBeforeExpiry < 0 {
+		return nil, fmt.Errorf("%s: renew_days_before_expiry must not be negative", path)
+	}
+	if f.Backups.KeepLast < 0 {
+		return nil, fmt.Errorf("%s: backups.keep_last must not be negative", path)
+	}
+	return f, nil
+}
@@ -7,7 +7,9 @@ import (
 	"path/filepath"
 )
 
-// AssertPermissions checks that credential files exist and permissions are secure.
+// AssertPermissions checks that credential files exist and permissions are secure:
+// files are owner-only and, like the directory, owned by root or by the user running
+// trustctl, so a rootless home under that user's directory passes.
 func AssertPermissions(dir string) error {
 	// Directory must exist
 	fi, err := os.Stat(dir)
@@ -17,6 +19,9 @@ func AssertPermissions(dir string) error {
 	if !fi.IsDir() {
 		return errors.New("credentials path is not a directory")
 	}
+	if err := assertOwner(dir, fi); err != nil {
+		return err
+	}
 
 	// Check files in directory have at most 0600 permissions
 	entries, err := os.ReadDir(dir)
@@ -36,6 +41,19 @@ func AssertPermissions(dir string) error {
 		if mode&0o077 != 0 {
 			return fmt.Errorf("insecure permissions on %s: %o (expected owner-only)", p, mode)
 		}
+		if err := assertOwner(p, info); err != nil {
+			return err
+		}
 	}
 	return nil
 }
+
+// assertOwner rejects files owned by anyone but root or the current user, who
+// could otherwise swap credentials under trustctl.
+func assertOwner(path string, fi os.FileInfo) error {
+	uid, ok := owner(fi)
+	if !ok || uid == 0 || uid == os.Geteuid() {
+		return nil
+	}
+	return fmt.Errorf("insecure owner of %s: uid %d (expected root or uid %d)", path, uid, os.Geteuid())
+}
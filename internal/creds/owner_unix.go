@@ -0,0 +1,16 @@
+//go:build unix
+
+package creds
+
+import (
+	"os"
+	"syscall"
+)
+
+func owner(fi os.FileInfo) (uid int, ok bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return int(st.Uid), true
+}
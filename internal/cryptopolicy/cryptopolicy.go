@@ -0,0 +1,180 @@
+package cryptopolicy
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+)
+
+// Mode selects how restrictive trustctl is about key types, curves, hash
+// algorithms and TLS versions.
+type Mode string
+
+const (
+	// ModeDefault applies no restrictions beyond trustctl's normal defaults.
+	ModeDefault Mode = "default"
+	// ModeStrict (strict-crypto) refuses anything outside an approved set:
+	// RSA >= 2048, NIST P-curves only, SHA-2 signatures and TLS 1.2+.
+	ModeStrict Mode = "strict"
+	// ModeFIPS (--fips) is strict mode narrowed to what FIPS 186-4/186-5 and
+	// SP 800-131A approve for new keys: RSA moduli of exactly 2048, 3072 or 4096
+	// bits with an odd exponent of at least 65537, NIST P-256/P-384/P-521 and
+	// SHA-2. It restricts algorithms; it does not make Go's crypto a validated module.
+	ModeFIPS Mode = "fips"
+)
+
+// MinRSABits is the smallest RSA modulus accepted in strict mode.
+const MinRSABits = 2048
+
+var current = ModeDefault
+
+// minRSABits is the configured minimum RSA modulus; 0 leaves only the strict-mode floor.
+var minRSABits int
+
+// SetMinRSABits sets the process-wide minimum RSA key size: 2048, 3072, 4096, or 0
+// for no minimum beyond strict mode's.
+func SetMinRSABits(bits int) error {
+	switch bits {
+	case 0, 2048, 3072, 4096:
+		minRSABits = bits
+		return nil
+	}
+	return fmt.Errorf("invalid minimum RSA key size %d (supported: 2048, 3072, 4096)", bits)
+}
+
+// MinRSAKeySize returns the configured minimum RSA key size, 0 if none.
+func MinRSAKeySize() int {
+	return minRSABits
+}
+
+// SetMode sets the process-wide crypto mode.
+func SetMode(m Mode) {
+	current = m
+}
+
+// CurrentMode returns the process-wide crypto mode.
+func CurrentMode() Mode {
+	return current
+}
+
+// Strict reports whether restricted-algorithm mode is active; FIPS mode is a
+// stricter form of it.
+func Strict() bool {
+	return current == ModeStrict || current == ModeFIPS
+}
+
+// FIPS reports whether FIPS mode is active.
+func FIPS() bool {
+	return current == ModeFIPS
+}
+
+// label names the active restricted mode in error messages.
+func label() string {
+	if FIPS() {
+		return "fips"
+	}
+	return "strict-crypto"
+}
+
+// ParseMode converts a stored or user-supplied mode name into a Mode.
+func ParseMode(s string) (Mode, error) {
+	switch s {
+	case "", string(ModeDefault):
+		return ModeDefault, nil
+	case string(ModeStrict), "strict-crypto":
+		return ModeStrict, nil
+	case string(ModeFIPS):
+		return ModeFIPS, nil
+	default:
+		return "", fmt.Errorf("unknown crypto mode: %s", s)
+	}
+}
+
+// CheckRSAKeySize rejects RSA moduli below the configured minimum, below MinRSABits
+// in strict mode, and other than 2048, 3072 or 4096 bits in FIPS mode.
+func CheckRSAKeySize(bits int) error {
+	if bits < minRSABits {
+		return fmt.Errorf("RSA key size %d is below the configured minimum of %d bits", bits, minRSABits)
+	}
+	if Strict() && bits < MinRSABits {
+		return fmt.Errorf("%s: RSA key size %d is below the minimum of %d", label(), bits, MinRSABits)
+	}
+	if FIPS() && bits != 2048 && bits != 3072 && bits != 4096 {
+		return fmt.Errorf("fips: RSA key size %d is not approved (2048, 3072 or 4096)", bits)
+	}
+	return nil
+}
+
+// CheckCurve rejects elliptic curves other than P-256, P-384 and P-521 in strict mode.
+func CheckCurve(c elliptic.Curve) error {
+	if !Strict() {
+		return nil
+	}
+	switch c {
+	case elliptic.P256(), elliptic.P384(), elliptic.P521():
+		return nil
+	}
+	return fmt.Errorf("%s: curve %s is not approved", label(), c.Params().Name)
+}
+
+// CheckPublicKey validates the type and strength of a public key.
+func CheckPublicKey(pub crypto.PublicKey) error {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		if FIPS() && (k.E < 65537 || k.E%2 == 0) {
+			return fmt.Errorf("fips: RSA public exponent %d is not approved (odd, at least 65537)", k.E)
+		}
+		return CheckRSAKeySize(k.N.BitLen())
+	case *ecdsa.PublicKey:
+		return CheckCurve(k.Curve)
+	default:
+		if Strict() {
+			return fmt.Errorf("%s: key type %T is not approved", label(), pub)
+		}
+		return nil
+	}
+}
+
+// CheckSignatureAlgorithm rejects MD5/SHA-1 based and other non-approved
+// signature algorithms in strict mode.
+func CheckSignatureAlgorithm(alg x509.SignatureAlgorithm) error {
+	if !Strict() {
+		return nil
+	}
+	switch alg {
+	case x509.SHA256WithRSA, x509.SHA384WithRSA, x509.SHA512WithRSA,
+		x509.SHA256WithRSAPSS, x509.SHA384WithRSAPSS, x509.SHA512WithRSAPSS,
+		x509.ECDSAWithSHA256, x509.ECDSAWithSHA384, x509.ECDSAWithSHA512:
+		return nil
+	}
+	return fmt.Errorf("%s: signature algorithm %s is not approved", label(), alg)
+}
+
+// CheckCertificate validates a certificate's public key and signature algorithm.
+func CheckCertificate(cert *x509.Certificate) error {
+	if err := CheckPublicKey(cert.PublicKey); err != nil {
+		return err
+	}
+	return CheckSignatureAlgorithm(cert.SignatureAlgorithm)
+}
+
+// TLSConfig returns the client TLS configuration to use for CA and provider
+// connections. TLS 1.2 is the floor in every mode; strict mode additionally
+// pins AEAD cipher suites and NIST curves.
+func TLSConfig() *tls.Config {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if Strict() {
+		cfg.CipherSuites = []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		}
+		cfg.CurvePreferences = []tls.CurveID{tls.CurveP256, tls.CurveP384, tls.CurveP521}
+	}
+	return cfg
+}
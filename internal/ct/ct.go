@@ -0,0 +1,129 @@
+package ct
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/trustctl/trustctl/internal/httpclient"
+)
+
+// DefaultAggregator is the crt.sh endpoint queried when no other is configured.
+const DefaultAggregator = "https://crt.sh/"
+
+// Entry is one certificate found in the CT logs.
+type Entry struct {
+	ID        int64
+	Serial    string
+	Issuer    string
+	Names     []string
+	NotBefore time.Time
+	NotAfter  time.Time
+	LoggedAt  time.Time
+}
+
+// Expired reports whether the certificate is past its notAfter.
+func (e Entry) Expired() bool {
+	return time.Now().After(e.NotAfter)
+}
+
+// crtshEntry mirrors one element of crt.sh's JSON output.
+type crtshEntry struct {
+	ID             int64  `json:"id"`
+	IssuerName     string `json:"issuer_name"`
+	NameValue      string `json:"name_value"`
+	SerialNumber   string `json:"serial_number"`
+	NotBefore      string `json:"not_before"`
+	NotAfter       string `json:"not_after"`
+	EntryTimestamp string `json:"entry_timestamp"`
+}
+
+// Client queries a crt.sh-compatible aggregator.
+type Client struct {
+	BaseURL string
+	HTTP    *http.Client
+}
+
+// NewClient returns a client for baseURL (DefaultAggregator when empty).
+func NewClient(baseURL string) *Client {
+	if baseURL == "" {
+		baseURL = DefaultAggregator
+	}
+	return &Client{BaseURL: baseURL, HTTP: httpclient.New(60 * time.Second)}
+}
+
+// Lookup lists certificates logged for domain, and for its subdomains when
+// subdomains is set. Precertificate and final certificate entries sharing a
+// serial are merged. Results are sorted newest first.
+func (c *Client) Lookup(domain string, subdomains bool) ([]Entry, error) {
+	q := domain
+	if subdomains {
+		q = "%." + domain
+	}
+	u := strings.TrimRight(c.BaseURL, "/") + "/?output=json&q=" + url.QueryEscape(q)
+	resp, err := c.HTTP.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("CT aggregator returned %s", resp.Status)
+	}
+	var raw []crtshEntry
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("decode CT response: %w", err)
+	}
+
+	seen := map[string]int{}
+	var out []Entry
+	for _, r := range raw {
+		key := r.IssuerName + "|" + r.SerialNumber
+		names := strings.Fields(strings.ToLower(r.NameValue))
+		if i, ok := seen[key]; ok {
+			out[i].Names = mergeNames(out[i].Names, names)
+			continue
+		}
+		seen[key] = len(out)
+		out = append(out, Entry{
+			ID:        r.ID,
+			Serial:    r.SerialNumber,
+			Issuer:    r.IssuerName,
+			Names:     mergeNames(nil, names),
+			NotBefore: parseTime(r.NotBefore),
+			NotAfter:  parseTime(r.NotAfter),
+			LoggedAt:  parseTime(r.EntryTimestamp),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].NotBefore.After(out[j].NotBefore) })
+	return out, nil
+}
+
+func mergeNames(dst, add []string) []string {
+	for _, n := range add {
+		dup := false
+		for _, d := range dst {
+			if d == n {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			dst = append(dst, n)
+		}
+	}
+	return dst
+}
+
+// parseTime accepts the timestamp layouts crt.sh emits (with and without fractional seconds).
+func parseTime(s string) time.Time {
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04:05.999999999", time.RFC3339} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
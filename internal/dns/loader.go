@@ -5,8 +5,26 @@ import (
 	"path/filepath"
 	"plugin"
 	"runtime"
+	"sync"
 )
 
+var (
+	builtinMu sync.Mutex
+	builtin   = map[string]DNSProvider{}
+)
+
+// Register makes an in-process provider available under name. Registered providers
+// take precedence over plugin files; they serve tests and programs embedding trustctl.
+func Register(name string, p DNSProvider) {
+	builtinMu.Lock()
+	defer builtinMu.Unlock()
+	if p == nil {
+		delete(builtin, name)
+		return
+	}
+	builtin[name] = p
+}
+
 // PluginLoader loads DNS provider plugins from a configured plugins directory.
 type PluginLoader struct {
 	pluginsDir     string
@@ -19,9 +37,16 @@ func NewPluginLoader(pluginsDir, credentialsDir string) *PluginLoader {
 
 // Load loads provider plugin by name (cloudflare -> cloudflare.so)
 func (l *PluginLoader) Load(name string) (DNSProvider, error) {
-	// Go plugins only supported on linux; return error on unsupported OS
-	if runtime.GOOS != "linux" {
-		return nil, fmt.Errorf("go plugin loading only supported on linux: current=%s", runtime.GOOS)
+	builtinMu.Lock()
+	bp, ok := builtin[name]
+	builtinMu.Unlock()
+	if ok {
+		return bp, nil
+	}
+
+	// Go plugins are only supported on linux and macOS; return error on unsupported OS
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		return nil, fmt.Errorf("go plugin loading only supported on linux and darwin: current=%s", runtime.GOOS)
 	}
 
 	path := filepath.Join(l.pluginsDir, fmt.Sprintf("%s.so", name))
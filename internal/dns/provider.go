@@ -1,7 +1,20 @@
 package dns
 
-// DNSProvider is the interface DNS plugins must implement.
+import "context"
+
+// DNSProvider is the interface DNS plugins must implement. keyAuth is the ACME key
+// authorization; the TXT record at _acme-challenge.<domain> holds its digest,
+// acme.DNS01Value(keyAuth).
 type DNSProvider interface {
 	Present(domain, token, keyAuth string) error
 	CleanUp(domain, token, keyAuth string) error
 }
+
+// ContextProvider is implemented by providers whose API calls can be cancelled; the
+// validator uses it instead of Present and CleanUp when available. CleanUpContext is
+// called with a fresh context, so records are removed even after a cancelled run.
+type ContextProvider interface {
+	DNSProvider
+	PresentContext(ctx context.Context, domain, token, keyAuth string) error
+	CleanUpContext(ctx context.Context, domain, token, keyAuth string) error
+}
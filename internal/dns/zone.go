@@ -0,0 +1,292 @@
+package dns
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// ZoneProvider is implemented by providers that want to be told which zone a
+// challenge record belongs in, as found by ZoneResolver, rather than deriving it
+// from the certificate's domain. fqdn is the absolute record name (trailing dot),
+// after any CNAME at _acme-challenge.<domain> has been followed. value is the TXT
+// record content, already digested.
+type ZoneProvider interface {
+	PresentTXT(zone, fqdn, value string) error
+	CleanUpTXT(zone, fqdn, value string) error
+}
+
+// ChallengeRecord is where the DNS-01 TXT record for a domain has to be created.
+type ChallengeRecord struct {
+	Domain string // name on the certificate
+	FQDN   string // record name, after CNAMEs, with trailing dot
+	Zone   string // apex of the zone containing FQDN, with trailing dot
+}
+
+// Name returns FQDN relative to Zone, "@" at the apex.
+func (c ChallengeRecord) Name() string {
+	if c.FQDN == c.Zone {
+		return "@"
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(c.FQDN, c.Zone), ".")
+}
+
+// ZoneResolver locates the zone for challenge records by following CNAMEs from
+// _acme-challenge.<domain> and walking up the labels of the target with SOA queries,
+// so nested subdomains and delegated child zones end up in the right zone.
+type ZoneResolver struct {
+	Nameservers []string // host:port; defaults to /etc/resolv.conf
+	Timeout     time.Duration
+}
+
+// maxCNAMEHops bounds CNAME chains.
+const maxCNAMEHops = 10
+
+// NewZoneResolver returns a resolver using the system's nameservers.
+func NewZoneResolver() *ZoneResolver {
+	return &ZoneResolver{Nameservers: systemNameservers(), Timeout: 5 * time.Second}
+}
+
+// Resolve returns the challenge record location for domain (a leading "*." is ignored).
+func (r *ZoneResolver) Resolve(domain string) (*ChallengeRecord, error) {
+	fqdn := "_acme-challenge." + strings.TrimSuffix(strings.TrimPrefix(domain, "*."), ".") + "."
+	fqdn = strings.ToLower(fqdn)
+
+	for hop := 0; ; hop++ {
+		if hop == maxCNAMEHops {
+			return nil, fmt.Errorf("too many CNAMEs from _acme-challenge.%s", domain)
+		}
+		msg, err := r.query(fqdn, typeCNAME)
+		if err != nil {
+			return nil, err
+		}
+		target := ""
+		for _, rr := range msg.answer {
+			if rr.typ == typeCNAME && rr.name == fqdn {
+				target = rr.target
+			}
+		}
+		if target == "" {
+			break
+		}
+		fqdn = target
+	}
+
+	for name := fqdn; name != ""; name = parentName(name) {
+		msg, err := r.query(name, typeSOA)
+		if err != nil {
+			return nil, err
+		}
+		for _, rr := range msg.answer {
+			if rr.typ == typeSOA && rr.name == name {
+				return &ChallengeRecord{Domain: domain, FQDN: fqdn, Zone: name}, nil
+			}
+		}
+		// A negative answer carries the SOA of the enclosing zone
+		for _, rr := range msg.authority {
+			if rr.typ == typeSOA && strings.HasSuffix(fqdn, rr.name) {
+				return &ChallengeRecord{Domain: domain, FQDN: fqdn, Zone: rr.name}, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no SOA found for %s", fqdn)
+}
+
+// parentName strips the first label; the root yields "".
+func parentName(name string) string {
+	i := strings.Index(name, ".")
+	if i < 0 || i == len(name)-1 {
+		return ""
+	}
+	return name[i+1:]
+}
+
+func systemNameservers() []string {
+	f, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return []string{"127.0.0.1:53"}
+	}
+	defer f.Close()
+	var out []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			out = append(out, net.JoinHostPort(fields[1], "53"))
+		}
+	}
+	if len(out) == 0 {
+		return []string{"127.0.0.1:53"}
+	}
+	return out
+}
+
+// Minimal RFC 1035 wire format support: enough to ask for SOA and CNAME records.
+
+const (
+	typeCNAME = 5
+	typeSOA   = 6
+	classIN   = 1
+)
+
+type dnsRR struct {
+	name   string
+	typ    uint16
+	target string // CNAME target or SOA MNAME
+}
+
+type dnsMsg struct {
+	rcode     int
+	answer    []dnsRR
+	authority []dnsRR
+}
+
+func (r *ZoneResolver) query(name string, qtype uint16) (*dnsMsg, error) {
+	servers := r.Nameservers
+	if len(servers) == 0 {
+		servers = systemNameservers()
+	}
+	timeout := r.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	var lastErr error
+	for _, ns := range servers {
+		msg, err := exchange(ns, name, qtype, timeout)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		// NOERROR and NXDOMAIN are answers; anything else is a server failure
+		if msg.rcode != 0 && msg.rcode != 3 {
+			lastErr = fmt.Errorf("dns query %s for %s: rcode %d", ns, name, msg.rcode)
+			continue
+		}
+		return msg, nil
+	}
+	return nil, lastErr
+}
+
+func exchange(server, name string, qtype uint16, timeout time.Duration) (*dnsMsg, error) {
+	id := uint16(rand.Intn(1 << 16))
+	q := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(q[0:], id)
+	binary.BigEndian.PutUint16(q[2:], 0x0100) // recursion desired
+	binary.BigEndian.PutUint16(q[4:], 1)
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, fmt.Errorf("invalid dns name %q", name)
+		}
+		q = append(q, byte(len(label)))
+		q = append(q, label...)
+	}
+	q = append(q, 0, byte(qtype>>8), byte(qtype), 0, classIN)
+
+	conn, err := net.DialTimeout("udp", server, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := conn.Write(q); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, fmt.Errorf("dns query %s for %s: %w", server, name, err)
+		}
+		if n >= 12 && binary.BigEndian.Uint16(buf) == id {
+			return parseMsg(buf[:n])
+		}
+	}
+}
+
+var errShortMsg = errors.New("malformed dns response")
+
+func parseMsg(b []byte) (*dnsMsg, error) {
+	if len(b) < 12 {
+		return nil, errShortMsg
+	}
+	m := &dnsMsg{rcode: int(b[3] & 0x0f)}
+	qd := int(binary.BigEndian.Uint16(b[4:]))
+	an := int(binary.BigEndian.Uint16(b[6:]))
+	ns := int(binary.BigEndian.Uint16(b[8:]))
+	off := 12
+	for i := 0; i < qd; i++ {
+		_, next, err := readName(b, off)
+		if err != nil {
+			return nil, err
+		}
+		off = next + 4
+	}
+	for i := 0; i < an+ns; i++ {
+		name, next, err := readName(b, off)
+		if err != nil {
+			return nil, err
+		}
+		if next+10 > len(b) {
+			return nil, errShortMsg
+		}
+		rr := dnsRR{name: name, typ: binary.BigEndian.Uint16(b[next:])}
+		rdlen := int(binary.BigEndian.Uint16(b[next+8:]))
+		rdata := next + 10
+		if rdata+rdlen > len(b) {
+			return nil, errShortMsg
+		}
+		if rr.typ == typeCNAME || rr.typ == typeSOA {
+			if rr.target, _, err = readName(b, rdata); err != nil {
+				return nil, err
+			}
+		}
+		if i < an {
+			m.answer = append(m.answer, rr)
+		} else {
+			m.authority = append(m.authority, rr)
+		}
+		off = rdata + rdlen
+	}
+	return m, nil
+}
+
+// readName decodes a possibly compressed name at off, returning it lower-cased with
+// a trailing dot and the offset just past it.
+func readName(b []byte, off int) (string, int, error) {
+	var labels []string
+	end := -1
+	for jumps := 0; ; {
+		if off >= len(b) {
+			return "", 0, errShortMsg
+		}
+		l := int(b[off])
+		switch {
+		case l == 0:
+			if end < 0 {
+				end = off + 1
+			}
+			return strings.ToLower(strings.Join(labels, ".")) + ".", end, nil
+		case l&0xc0 == 0xc0:
+			if off+1 >= len(b) || jumps > 20 {
+				return "", 0, errShortMsg
+			}
+			if end < 0 {
+				end = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(b[off:]) & 0x3fff)
+			jumps++
+		default:
+			if off+1+l > len(b) {
+				return "", 0, errShortMsg
+			}
+			labels = append(labels, string(b[off+1:off+1+l]))
+			off += 1 + l
+		}
+	}
+}
@@ -0,0 +1,78 @@
+// Package hooks runs user commands around certificate issuance, like certbot's
+// renewal hooks: pre hooks before validation, post hooks after every attempt and
+// deploy hooks once a new certificate is in place.
+package hooks
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/trustctl/trustctl/internal/ui"
+)
+
+// Stage says when a hook runs.
+type Stage string
+
+const (
+	Pre    Stage = "pre"    // before the CA is asked to validate the names
+	Post   Stage = "post"   // after every issuance attempt, successful or not
+	Deploy Stage = "deploy" // after a new certificate is saved and installed
+)
+
+// Run runs the executables in <dir>/<stage> in name order, then command through
+// the shell when set, each with env added to the environment and TRUSTCTL_HOOK set
+// to stage. It stops at the first hook that fails.
+func Run(dir string, stage Stage, command string, env []string) error {
+	var cmds []*exec.Cmd
+	for _, exe := range Executables(dir, stage) {
+		cmds = append(cmds, exec.Command(exe))
+	}
+	if command != "" {
+		cmds = append(cmds, exec.Command("/bin/sh", "-c", command))
+	}
+	for _, cmd := range cmds {
+		name := cmd.Path
+		if len(cmd.Args) == 3 {
+			name = cmd.Args[2]
+		}
+		ui.StepStart("Running %s hook: %s", stage, name)
+		cmd.Env = append(append(os.Environ(), env...), "TRUSTCTL_HOOK="+string(stage))
+		out, err := cmd.CombinedOutput()
+		for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+			if line != "" {
+				ui.Info("  %s", line)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("%s hook %s failed: %v", stage, name, err)
+		}
+	}
+	return nil
+}
+
+// Executables returns the executable files in <dir>/<stage>, sorted by name. Files
+// without an execute bit, and editor or package manager leftovers, are skipped.
+func Executables(dir string, stage Stage) []string {
+	entries, err := os.ReadDir(filepath.Join(dir, string(stage)))
+	if err != nil {
+		return nil
+	}
+	var exes []string
+	for _, e := range entries {
+		name := e.Name()
+		if strings.HasPrefix(name, ".") || strings.HasSuffix(name, "~") || strings.Contains(name, ".dpkg-") || strings.HasSuffix(name, ".rpmnew") {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil || !fi.Mode().IsRegular() || fi.Mode().Perm()&0111 == 0 {
+			continue
+		}
+		exes = append(exes, filepath.Join(dir, string(stage), name))
+	}
+	sort.Strings(exes)
+	return exes
+}
@@ -0,0 +1,168 @@
+// Package httpclient builds the HTTP clients trustctl reaches CAs, AIA issuers and
+// other services with, so proxy and TLS policy apply to every outbound call.
+//
+// Requests go through the proxy set with SetProxy (--proxy) or, without one, the
+// proxy named by HTTPS_PROXY/HTTP_PROXY. NO_PROXY exempts hosts in both cases, and
+// loopback addresses are never proxied.
+package httpclient
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trustctl/trustctl/internal/cryptopolicy"
+)
+
+var (
+	mu    sync.RWMutex
+	proxy *url.URL
+)
+
+// SetProxy routes outbound requests through raw (http://, https:// or socks5://
+// host:port, optionally with user:password@). An empty raw falls back to the
+// environment.
+func SetProxy(raw string) error {
+	if raw == "" {
+		mu.Lock()
+		proxy = nil
+		mu.Unlock()
+		return nil
+	}
+	u, err := parseProxy(raw)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	proxy = u
+	mu.Unlock()
+	return nil
+}
+
+func parseProxy(raw string) (*url.URL, error) {
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q (use http, https or socks5)", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy URL %q: no host", raw)
+	}
+	return u, nil
+}
+
+// ProxyFor returns the proxy for req, or nil for a direct connection. It is the
+// Proxy function of every transport built here.
+func ProxyFor(req *http.Request) (*url.URL, error) {
+	host, port := req.URL.Hostname(), req.URL.Port()
+	if port == "" {
+		port = "80"
+		if req.URL.Scheme == "https" {
+			port = "443"
+		}
+	}
+	if isLoopback(host) || bypassed(host, port, getenv("NO_PROXY")) {
+		return nil, nil
+	}
+	mu.RLock()
+	p := proxy
+	mu.RUnlock()
+	if p != nil {
+		return p, nil
+	}
+	raw := getenv("HTTP_PROXY")
+	if req.URL.Scheme == "https" {
+		raw = getenv("HTTPS_PROXY")
+	}
+	if raw == "" {
+		return nil, nil
+	}
+	return parseProxy(raw)
+}
+
+// getenv reads an upper-case proxy variable or its lower-case spelling.
+func getenv(name string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return os.Getenv(strings.ToLower(name))
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// bypassed reports whether host:port matches a NO_PROXY entry: "*", an IP, a CIDR, or
+// a domain that also covers its subdomains (a leading dot is optional), each with an
+// optional :port.
+func bypassed(host, port, noProxy string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	ip := net.ParseIP(host)
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if h, p, err := net.SplitHostPort(entry); err == nil {
+			if p != port {
+				continue
+			}
+			entry = h
+		}
+		if eip := net.ParseIP(strings.Trim(entry, "[]")); eip != nil {
+			if ip != nil && eip.Equal(ip) {
+				return true
+			}
+			continue
+		}
+		entry = strings.TrimPrefix(entry, "*")
+		entry = strings.TrimPrefix(entry, ".")
+		if host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+	return false
+}
+
+// Transport returns a transport with the proxy settings and the crypto policy's TLS
+// configuration; tlsConfig, when given, is used instead (e.g. to trust a test CA).
+func Transport(tlsConfig *tls.Config) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = ProxyFor
+	if tlsConfig == nil {
+		tlsConfig = cryptopolicy.TLSConfig()
+	}
+	t.TLSClientConfig = tlsConfig
+	return t
+}
+
+// New returns a client with the given overall request timeout.
+func New(timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: Transport(nil)}
+}
@@ -0,0 +1,203 @@
+package importer
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/trustctl/trustctl/internal/keygen"
+)
+
+// AcmeShDir returns acme.sh's default home, ~/.acme.sh.
+func AcmeShDir() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".acme.sh")
+}
+
+// acmeShDirectories are the short names acme.sh accepts for --server.
+var acmeShDirectories = map[string]string{
+	"letsencrypt":      "https://acme-v02.api.letsencrypt.org/directory",
+	"letsencrypt_test": "https://acme-staging-v02.api.letsencrypt.org/directory",
+	"zerossl":          "https://acme.zerossl.com/v2/DV90",
+	"buypass":          "https://api.buypass.com/acme/directory",
+	"buypass_test":     "https://api.test4.buypass.no/acme/directory",
+	"google":           "https://dv.acme-v02.api.pki.goog/directory",
+	"googletest":       "https://dv.acme-v02.test-api.pki.goog/directory",
+	"sslcom":           "https://acme.ssl.com/sslcom-dv-rsa",
+}
+
+// AcmeSh reads the certificates in acme.sh's home, one <domain>[_ecc] directory with
+// a <domain>.conf each, and the accounts in <home>/ca.
+func AcmeSh(home string) ([]*Certificate, []*Account, error) {
+	confs, err := filepath.Glob(filepath.Join(home, "*", "*.conf"))
+	if err != nil {
+		return nil, nil, err
+	}
+	var certs []*Certificate
+	for _, conf := range confs {
+		dir := filepath.Dir(conf)
+		name := strings.TrimSuffix(filepath.Base(conf), ".conf")
+		if strings.TrimSuffix(filepath.Base(dir), "_ecc") != name {
+			continue // account.conf, csr.conf and the like
+		}
+		c, err := acmeShCertificate(dir, conf)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", conf, err)
+		}
+		certs = append(certs, c)
+	}
+	if len(certs) == 0 {
+		return nil, nil, fmt.Errorf("no acme.sh certificates in %s", home)
+	}
+	accounts, err := acmeShAccounts(filepath.Join(home, "ca"))
+	if err != nil {
+		return nil, nil, err
+	}
+	return certs, accounts, nil
+}
+
+func acmeShCertificate(dir, conf string) (*Certificate, error) {
+	v, err := parseShellConf(conf)
+	if err != nil {
+		return nil, err
+	}
+	name := v["Le_Domain"]
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(conf), ".conf")
+	}
+	c := &Certificate{
+		Name:          name,
+		Source:        dir,
+		FullChainPath: filepath.Join(dir, "fullchain.cer"),
+		KeyPath:       filepath.Join(dir, name+".key"),
+		DirectoryURL:  v["Le_API"],
+		PreHook:       v["Le_PreHook"],
+		PostHook:      v["Le_PostHook"],
+		DeployHook:    v["Le_RenewHook"],
+	}
+	if u, ok := acmeShDirectories[c.DirectoryURL]; ok {
+		c.DirectoryURL = u
+	}
+	c.Files = map[string]string{
+		"cert.pem":      filepath.Join(dir, name+".cer"),
+		"chain.pem":     filepath.Join(dir, "ca.cer"),
+		"fullchain.pem": c.FullChainPath,
+		"privkey.pem":   c.KeyPath,
+	}
+	c.PreferredChain = v["Le_Preferred_Chain"]
+
+	// Le_Webroot holds one entry per domain, or a single one shared by all of them
+	webroots := strings.Split(v["Le_Webroot"], ",")
+	switch w := webroots[0]; {
+	case strings.HasPrefix(w, "dns_"):
+		c.Validation = "dns"
+		c.DNSProvider = dnsProvider(w)
+		c.note("install the %s DNS provider plugin and its credentials (acme.sh used %s, configured in account.conf)", c.DNSProvider, w)
+	case w == "dns":
+		c.Validation = "manual"
+		c.ManualType = "dns"
+	case strings.HasPrefix(w, "/"):
+		c.Validation = "http"
+		c.Webroot = w
+		for _, o := range webroots[1:] {
+			if o != w {
+				c.note("acme.sh used several webroots; trustctl validates every name through %s", w)
+				break
+			}
+		}
+	default:
+		// no (standalone), nginx, apache, alpn and the stateless mode
+		c.Validation = "http"
+		c.note("acme.sh validated in %s mode; trustctl serves http-01 from the webroot, so /.well-known/acme-challenge/ must be served from it", w)
+	}
+
+	// acme.sh's --install-cert copies the files elsewhere and runs a reload command;
+	// trustctl does the same with a deploy hook.
+	copies := map[string]string{
+		"Le_RealCertPath":      "\"$TRUSTCTL_CERT_DIR/cert.pem\"",
+		"Le_RealCACertPath":    "\"$TRUSTCTL_CHAIN_PATH\"",
+		"Le_RealKeyPath":       "\"$TRUSTCTL_KEY_PATH\"",
+		"Le_RealFullChainPath": "\"$TRUSTCTL_CERT_PATH\"",
+	}
+	var deploy []string
+	for _, k := range []string{"Le_RealCertPath", "Le_RealCACertPath", "Le_RealKeyPath", "Le_RealFullChainPath"} {
+		if p := v[k]; p != "" {
+			deploy = append(deploy, fmt.Sprintf("cp %s '%s'", copies[k], p))
+		}
+	}
+	if cmd := v["Le_ReloadCmd"]; cmd != "" {
+		deploy = append(deploy, cmd)
+	}
+	if len(deploy) > 0 {
+		if c.DeployHook != "" {
+			deploy = append([]string{c.DeployHook}, deploy...)
+		}
+		c.DeployHook = strings.Join(deploy, " && ")
+	}
+	c.NoInstall = true
+	return c, nil
+}
+
+// parseShellConf reads the KEY='value' lines acme.sh stores settings as, decoding
+// values it wrapped in __ACME_BASE64__START_ ... __ACME_BASE64__END_.
+func parseShellConf(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	v := map[string]string{}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		k, val, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		if !ok {
+			continue
+		}
+		val = strings.Trim(val, `'"`)
+		if strings.HasPrefix(val, "__ACME_BASE64__START_") && strings.HasSuffix(val, "__ACME_BASE64__END_") {
+			b, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(strings.TrimPrefix(val, "__ACME_BASE64__START_"), "__ACME_BASE64__END_"))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			val = string(b)
+		}
+		v[k] = val
+	}
+	return v, sc.Err()
+}
+
+// acmeShAccounts reads <dir>/<server host>/<server path>/{account.key,ca.conf}.
+func acmeShAccounts(dir string) ([]*Account, error) {
+	var accounts []*Account
+	keys, _ := filepath.Glob(filepath.Join(dir, "*", "*", "account.key"))
+	more, _ := filepath.Glob(filepath.Join(dir, "*", "*", "*", "account.key"))
+	for _, keyPath := range append(keys, more...) {
+		caDir := filepath.Dir(keyPath)
+		conf, err := parseShellConf(filepath.Join(caDir, "ca.conf"))
+		if err != nil || conf["ACCOUNT_URL"] == "" {
+			continue // never registered
+		}
+		key, err := keygen.LoadPrivateKey(keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", keyPath, err)
+		}
+		rel, err := filepath.Rel(dir, caDir)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, &Account{
+			DirectoryURL: "https://" + filepath.ToSlash(rel),
+			URL:          conf["ACCOUNT_URL"],
+			Email:        conf["CA_EMAIL"],
+			Key:          key,
+		})
+	}
+	return accounts, nil
+}
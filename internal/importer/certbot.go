@@ -0,0 +1,286 @@
+package importer
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CertbotDir is certbot's default configuration directory.
+const CertbotDir = "/etc/letsencrypt"
+
+// Certbot reads the certificates in <dir>/live with their <dir>/renewal/<name>.conf,
+// and the accounts in <dir>/accounts.
+func Certbot(dir string) ([]*Certificate, []*Account, error) {
+	confs, err := filepath.Glob(filepath.Join(dir, "renewal", "*.conf"))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(confs) == 0 {
+		return nil, nil, fmt.Errorf("no certbot renewal configurations in %s", filepath.Join(dir, "renewal"))
+	}
+	var certs []*Certificate
+	for _, conf := range confs {
+		c, err := certbotCertificate(dir, conf)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", conf, err)
+		}
+		certs = append(certs, c)
+	}
+	accounts, err := certbotAccounts(filepath.Join(dir, "accounts"))
+	if err != nil {
+		return nil, nil, err
+	}
+	return certs, accounts, nil
+}
+
+func certbotCertificate(dir, conf string) (*Certificate, error) {
+	top, params, webroots, err := parseCertbotConf(conf)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.TrimSuffix(filepath.Base(conf), ".conf")
+	live := filepath.Join(dir, "live", name)
+	c := &Certificate{
+		Name:           name,
+		Source:         live,
+		FullChainPath:  orDefault(top["fullchain"], filepath.Join(live, "fullchain.pem")),
+		KeyPath:        orDefault(top["privkey"], filepath.Join(live, "privkey.pem")),
+		DirectoryURL:   params["server"],
+		ReuseKey:       strings.EqualFold(params["reuse_key"], "true"),
+		PreferredChain: params["preferred_chain"],
+		PreHook:        params["pre_hook"],
+		PostHook:       params["post_hook"],
+		DeployHook:     params["renew_hook"],
+	}
+	c.Files = map[string]string{
+		"cert.pem":      orDefault(top["cert"], filepath.Join(live, "cert.pem")),
+		"chain.pem":     orDefault(top["chain"], filepath.Join(live, "chain.pem")),
+		"fullchain.pem": c.FullChainPath,
+		"privkey.pem":   c.KeyPath,
+	}
+	if id := params["account"]; id != "" {
+		c.AccountURL = certbotAccountURL(filepath.Join(dir, "accounts"), id)
+	}
+
+	switch auth := params["authenticator"]; {
+	case auth == "webroot":
+		c.Validation = "http"
+		paths := splitList(params["webroot_path"])
+		if p := webroots[name]; p != "" {
+			c.Webroot = p
+		} else if len(paths) > 0 {
+			c.Webroot = paths[0]
+		}
+		for _, p := range webroots {
+			if p != c.Webroot {
+				c.note("certbot used several webroots; trustctl validates every name through %s", c.Webroot)
+				break
+			}
+		}
+	case auth == "manual":
+		c.Validation = "manual"
+		c.ManualType = "http"
+		if strings.Contains(params["pref_challs"], "dns") {
+			c.ManualType = "dns"
+		}
+		c.ManualAuthHook, c.ManualCleanup = params["manual_auth_hook"], params["manual_cleanup_hook"]
+	case strings.HasPrefix(auth, "dns-"):
+		c.Validation = "dns"
+		c.DNSProvider = dnsProvider(auth)
+		c.note("install the %s DNS provider plugin and its credentials (certbot used %s)", c.DNSProvider, orDefault(params[strings.ReplaceAll(auth, "-", "_")+"_credentials"], auth))
+	default:
+		// nginx, apache and standalone answer http-01 themselves
+		c.Validation = "http"
+		c.note("certbot validated with its %s authenticator; trustctl serves http-01 from the webroot, so /.well-known/acme-challenge/ must be served from it", auth)
+	}
+	if inst := params["installer"]; inst == "" || inst == "None" {
+		c.NoInstall = true
+	}
+	return c, nil
+}
+
+// parseCertbotConf reads a certbot renewal configuration: the top-level keys, the
+// [renewalparams] and the [[webroot_map]] of names to webroots.
+func parseCertbotConf(path string) (top, params, webroots map[string]string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer f.Close()
+	top, params, webroots = map[string]string{}, map[string]string{}, map[string]string{}
+	section := top
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+			continue
+		case line == "[renewalparams]":
+			section = params
+			continue
+		case line == "[[webroot_map]]":
+			section = webroots
+			continue
+		case strings.HasPrefix(line, "["):
+			section = map[string]string{}
+			continue
+		}
+		if k, v, ok := strings.Cut(line, "="); ok {
+			section[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	return top, params, webroots, sc.Err()
+}
+
+// certbotAccounts reads <dir>/<server host>/<server path>/<id>/{regr.json,private_key.json}.
+func certbotAccounts(dir string) ([]*Account, error) {
+	var accounts []*Account
+	err := filepath.WalkDir(dir, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() || d.Name() != "regr.json" {
+			return nil
+		}
+		a, err := certbotAccount(dir, filepath.Dir(p))
+		if err != nil {
+			return fmt.Errorf("%s: %w", filepath.Dir(p), err)
+		}
+		accounts = append(accounts, a)
+		return nil
+	})
+	return accounts, err
+}
+
+func certbotAccount(root, dir string) (*Account, error) {
+	var regr struct {
+		Body struct {
+			Contact []string `json:"contact"`
+		} `json:"body"`
+		URI string `json:"uri"`
+	}
+	if err := readJSON(filepath.Join(dir, "regr.json"), &regr); err != nil {
+		return nil, err
+	}
+	var jwk map[string]string
+	if err := readJSON(filepath.Join(dir, "private_key.json"), &jwk); err != nil {
+		return nil, err
+	}
+	key, err := parseJWK(jwk)
+	if err != nil {
+		return nil, err
+	}
+	rel, err := filepath.Rel(root, filepath.Dir(dir))
+	if err != nil {
+		return nil, err
+	}
+	a := &Account{DirectoryURL: "https://" + filepath.ToSlash(rel), URL: regr.URI, Key: key}
+	for _, c := range regr.Body.Contact {
+		if strings.HasPrefix(c, "mailto:") {
+			a.Email = strings.TrimPrefix(c, "mailto:")
+			break
+		}
+	}
+	return a, nil
+}
+
+// certbotAccountURL returns the URL of the account with certbot id, or "".
+func certbotAccountURL(dir, id string) string {
+	matches, _ := filepath.Glob(filepath.Join(dir, "*", "*", id, "regr.json"))
+	if len(matches) == 0 {
+		matches, _ = filepath.Glob(filepath.Join(dir, "*", "*", "*", id, "regr.json"))
+	}
+	for _, m := range matches {
+		var regr struct {
+			URI string `json:"uri"`
+		}
+		if readJSON(m, &regr) == nil {
+			return regr.URI
+		}
+	}
+	return ""
+}
+
+// parseJWK decodes the RSA or EC private key JWK certbot stores account keys as.
+func parseJWK(jwk map[string]string) (crypto.Signer, error) {
+	num := func(name string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(jwk[name], "="))
+		if err != nil || len(b) == 0 {
+			return nil, fmt.Errorf("invalid JWK parameter %q", name)
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+	switch jwk["kty"] {
+	case "RSA":
+		var v [5]*big.Int
+		for i, name := range []string{"n", "e", "d", "p", "q"} {
+			n, err := num(name)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = n
+		}
+		key := &rsa.PrivateKey{PublicKey: rsa.PublicKey{N: v[0], E: int(v[1].Int64())}, D: v[2], Primes: []*big.Int{v[3], v[4]}}
+		if err := key.Validate(); err != nil {
+			return nil, err
+		}
+		key.Precompute()
+		return key, nil
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, ok := curves[jwk["crv"]]
+		if !ok {
+			return nil, fmt.Errorf("unsupported JWK curve %q", jwk["crv"])
+		}
+		var v [3]*big.Int
+		for i, name := range []string{"x", "y", "d"} {
+			n, err := num(name)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = n
+		}
+		return &ecdsa.PrivateKey{PublicKey: ecdsa.PublicKey{Curve: curve, X: v[0], Y: v[1]}, D: v[2]}, nil
+	}
+	return nil, fmt.Errorf("unsupported JWK key type %q", jwk["kty"])
+}
+
+func readJSON(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// splitList splits certbot's comma-separated list values.
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func orDefault(v, def string) string {
+	if v == "" {
+		return def
+	}
+	return v
+}
@@ -0,0 +1,86 @@
+// Package importer reads the certificates, renewal settings and ACME accounts of other
+// ACME clients (certbot, acme.sh) so trustctl can take over their renewal.
+package importer
+
+import (
+	"crypto"
+	"fmt"
+	"strings"
+
+	"github.com/trustctl/trustctl/internal/acme"
+)
+
+// Certificate is a certificate managed by another client, with the renewal settings
+// that carry over to trustctl.
+type Certificate struct {
+	Name          string // certbot lineage name or acme.sh main domain
+	Source        string // directory the certificate was found in
+	FullChainPath string
+	KeyPath       string
+	// Files maps trustctl's file names (cert.pem, chain.pem, fullchain.pem,
+	// privkey.pem) to the client's files, for linking instead of copying.
+	Files map[string]string
+
+	DirectoryURL   string
+	AccountURL     string // account the client renews with, when known
+	Validation     string // http, dns or manual
+	Webroot        string
+	DNSProvider    string // trustctl provider name, for dns
+	ManualAuthHook string
+	ManualCleanup  string
+	ManualType     string // dns or http, for manual
+	ReuseKey       bool
+	PreferredChain string
+	NoInstall      bool
+	PreHook        string
+	PostHook       string
+	DeployHook     string
+	// Notes are settings that could not be carried over, for the user to review.
+	Notes []string
+}
+
+// Account is an ACME account of another client.
+type Account struct {
+	DirectoryURL string
+	URL          string
+	Email        string
+	Key          crypto.Signer
+}
+
+// CAName returns the known ACME CA whose production or staging directory is url, or
+// "" for other directories.
+func CAName(url string) string {
+	for name, dir := range acme.KnownDirectories {
+		if dir == url {
+			return name
+		}
+	}
+	for name, dir := range acme.StagingDirectories {
+		if dir == url {
+			return name
+		}
+	}
+	return ""
+}
+
+// dnsProviders maps the DNS plugin names of certbot (without "dns-") and acme.sh
+// (without "dns_") to trustctl provider names where they differ.
+var dnsProviders = map[string]string{
+	"cf":   "cloudflare",
+	"aws":  "route53",
+	"gd":   "godaddy",
+	"dgon": "digitalocean",
+}
+
+// dnsProvider returns the trustctl name of another client's DNS plugin.
+func dnsProvider(name string) string {
+	name = strings.TrimPrefix(strings.TrimPrefix(name, "dns-"), "dns_")
+	if p, ok := dnsProviders[name]; ok {
+		return p
+	}
+	return name
+}
+
+func (c *Certificate) note(format string, args ...interface{}) {
+	c.Notes = append(c.Notes, fmt.Sprintf(format, args...))
+}
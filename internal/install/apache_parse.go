@@ -0,0 +1,285 @@
+package install
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// apacheDirective is one directive of an Apache configuration file, or a section such
+// as <VirtualHost> or <IfModule> with its children. Names compare case-insensitively.
+// Offsets index the parsed source like nginxDirective's.
+type apacheDirective struct {
+	Name     string
+	Args     []string
+	Start    int // offset of the directive name, or of '<' for a section
+	End      int // offset of the end of the line, or of the closing tag
+	Line     int
+	IsBlock  bool
+	Children []*apacheDirective
+	Parent   *apacheDirective
+}
+
+// parseApache parses an Apache configuration file. Lines continued with a trailing
+// backslash are joined; comments are whole lines starting with '#'.
+func parseApache(src string) ([]*apacheDirective, error) {
+	root := &apacheDirective{IsBlock: true}
+	cur := root
+	line := 0
+	for pos := 0; pos < len(src); {
+		start := pos
+		line++
+		// A logical line runs to a newline not preceded by a backslash
+		end := pos
+		for end < len(src) && !(src[end] == '\n' && (end == 0 || src[end-1] != '\\')) {
+			if src[end] == '\n' {
+				line++
+			}
+			end++
+		}
+		pos = end + 1
+		text := strings.TrimRight(src[start:end], "\r")
+		text = strings.ReplaceAll(strings.ReplaceAll(text, "\\\r\n", " "), "\\\n", " ")
+		trimmed := strings.TrimLeft(text, " \t")
+		if trimmed == "" || trimmed[0] == '#' {
+			continue
+		}
+		offset := start + len(text) - len(trimmed)
+		lineEnd := strings.TrimRight(src[start:end], "\r")
+		switch {
+		case strings.HasPrefix(trimmed, "</"):
+			name := strings.TrimSpace(strings.TrimSuffix(trimmed[2:], ">"))
+			if cur == root || !strings.EqualFold(name, cur.Name) {
+				return nil, fmt.Errorf("line %d: unexpected </%s>", line, name)
+			}
+			cur.End = start + len(lineEnd)
+			cur = cur.Parent
+		case trimmed[0] == '<':
+			inner := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(trimmed[1:]), ">"))
+			fields := splitApacheArgs(inner)
+			if len(fields) == 0 {
+				return nil, fmt.Errorf("line %d: empty section", line)
+			}
+			d := &apacheDirective{Name: fields[0], Args: fields[1:], Start: offset, Line: line, IsBlock: true, Parent: cur}
+			cur.Children = append(cur.Children, d)
+			cur = d
+		default:
+			fields := splitApacheArgs(trimmed)
+			cur.Children = append(cur.Children, &apacheDirective{Name: fields[0], Args: fields[1:], Start: offset, End: start + len(lineEnd), Line: line, Parent: cur})
+		}
+	}
+	if cur != root {
+		return nil, fmt.Errorf("<%s> opened on line %d is not closed", cur.Name, cur.Line)
+	}
+	for _, d := range root.Children {
+		d.Parent = nil
+	}
+	return root.Children, nil
+}
+
+// splitApacheArgs splits a directive into words, honouring double and single quotes.
+func splitApacheArgs(s string) []string {
+	var out []string
+	var b strings.Builder
+	var quote byte
+	in := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			if c == '\\' && i+1 < len(s) && s[i+1] == quote {
+				i++
+				c = s[i]
+			}
+			b.WriteByte(c)
+		case c == '"' || c == '\'':
+			quote, in = c, true
+		case c == ' ' || c == '\t':
+			if in {
+				out = append(out, b.String())
+				b.Reset()
+				in = false
+			}
+		default:
+			b.WriteByte(c)
+			in = true
+		}
+	}
+	if in {
+		out = append(out, b.String())
+	}
+	return out
+}
+
+// find returns the direct children of d named name, case-insensitively.
+func (d *apacheDirective) find(name string) []*apacheDirective {
+	var out []*apacheDirective
+	for _, c := range d.Children {
+		if strings.EqualFold(c.Name, name) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// walkApache calls fn for every directive in dirs and their children, depth first.
+func walkApache(dirs []*apacheDirective, fn func(*apacheDirective)) {
+	for _, d := range dirs {
+		fn(d)
+		walkApache(d.Children, fn)
+	}
+}
+
+// apacheVhosts returns the <VirtualHost> sections in dirs, including those nested in
+// <IfModule>, <IfDefine> and similar conditional sections.
+func apacheVhosts(dirs []*apacheDirective) []*apacheDirective {
+	var out []*apacheDirective
+	walkApache(dirs, func(d *apacheDirective) {
+		if d.IsBlock && strings.EqualFold(d.Name, "VirtualHost") {
+			out = append(out, d)
+		}
+	})
+	return out
+}
+
+// serverNames returns the names of a vhost's ServerName and ServerAlias directives.
+func (d *apacheDirective) serverNames() []string {
+	var names []string
+	for _, c := range d.Children {
+		if strings.EqualFold(c.Name, "ServerName") || strings.EqualFold(c.Name, "ServerAlias") {
+			names = append(names, c.Args...)
+		}
+	}
+	return names
+}
+
+// serves reports whether the vhost's ServerName or ServerAlias lists domain.
+func (d *apacheDirective) serves(domain string) bool {
+	for _, n := range d.serverNames() {
+		if nameMatches(n, domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// tlsListen returns the address of the vhost: its first <VirtualHost> address on
+// port 443, else its first address, which takes port 443 when it has none.
+func (d *apacheDirective) tlsListen() string {
+	for _, a := range d.Args {
+		if strings.HasSuffix(a, ":443") {
+			return a
+		}
+	}
+	if len(d.Args) > 0 {
+		return d.Args[0]
+	}
+	return "443"
+}
+
+// tls reports whether the vhost serves TLS: it listens on port 443 or turns
+// SSLEngine on.
+func (d *apacheDirective) tls() bool {
+	for _, a := range d.Args {
+		if strings.HasSuffix(a, ":443") {
+			return true
+		}
+	}
+	for _, e := range d.find("SSLEngine") {
+		if len(e.Args) == 1 && strings.EqualFold(e.Args[0], "on") {
+			return true
+		}
+	}
+	return false
+}
+
+// apacheConfPaths are the main Apache configs of the supported layouts, tried in order.
+var apacheConfPaths = []string{
+	"/etc/apache2/apache2.conf",
+	"/etc/httpd/conf/httpd.conf",
+	"/etc/apache2/httpd.conf",
+	"/usr/local/etc/apache24/httpd.conf",
+}
+
+// apacheMainConf returns the first of apacheConfPaths that exists, or "" when there
+// is none or discovery is restricted by SetConfigDir.
+func apacheMainConf() string {
+	if configDirOverride != "" {
+		return ""
+	}
+	for _, conf := range apacheConfPaths {
+		if _, err := os.Stat(conf); err == nil {
+			return conf
+		}
+	}
+	return ""
+}
+
+// apacheIncludes returns conf and every file its Include and IncludeOptional
+// directives pull in, recursively and in include order, wherever they appear
+// (<IfModule> included). Relative patterns resolve against ServerRoot, which defaults
+// to the directory above conf/ for httpd.conf layouts. An included directory
+// includes every file below it, as in Apache.
+func apacheIncludes(conf string) []string {
+	root := filepath.Dir(conf)
+	if filepath.Base(root) == "conf" {
+		root = filepath.Dir(root)
+	}
+	seen := map[string]bool{}
+	var files []string
+	var visit func(string)
+	visit = func(f string) {
+		if seen[f] {
+			return
+		}
+		seen[f] = true
+		if fi, err := os.Stat(f); err == nil && fi.IsDir() {
+			entries, _ := os.ReadDir(f) // sorted by name
+			for _, e := range entries {
+				visit(filepath.Join(f, e.Name()))
+			}
+			return
+		}
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return
+		}
+		files = append(files, f)
+		dirs, err := parseApache(string(data))
+		if err != nil {
+			return
+		}
+		walkApache(dirs, func(d *apacheDirective) {
+			switch {
+			case strings.EqualFold(d.Name, "ServerRoot") && len(d.Args) == 1:
+				root = d.Args[0]
+			case (strings.EqualFold(d.Name, "Include") || strings.EqualFold(d.Name, "IncludeOptional")) && len(d.Args) == 1:
+				pattern := d.Args[0]
+				if !filepath.IsAbs(pattern) {
+					pattern = filepath.Join(root, pattern)
+				}
+				matches, _ := filepath.Glob(pattern)
+				sort.Strings(matches)
+				for _, m := range matches {
+					visit(m)
+				}
+			}
+		})
+	}
+	visit(conf)
+	return files
+}
+
+// apacheFiles merges the include graph of the main Apache config with the well-known
+// vhost directories, skipping duplicates reached through symlinks.
+func apacheFiles() []string {
+	var files []string
+	if conf := apacheMainConf(); conf != "" {
+		files = apacheIncludes(conf)
+	}
+	return uniqueFiles(append(files, collectFiles(apacheSitesDirs)...))
+}
@@ -0,0 +1,197 @@
+package install
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/trustctl/trustctl/internal/paths"
+	"github.com/trustctl/trustctl/internal/ui"
+)
+
+// Backup is a timestamped copy of a vhost file written by backupAndWriteFile.
+type Backup struct {
+	Path     string
+	Original string
+	Time     time.Time
+}
+
+// BackupsDir is where vhost backups and run manifests are kept.
+func BackupsDir() string {
+	return filepath.Join(paths.Base(), "backups")
+}
+
+// backupFilesDir mirrors the config tree: the backup of /etc/nginx/sites-enabled/foo
+// is backupFilesDir()/etc/nginx/sites-enabled/foo.bak.<unix>.
+func backupFilesDir() string {
+	return filepath.Join(BackupsDir(), "files")
+}
+
+// backupPath returns where the backup of original taken at t is stored. Keeping
+// backups out of the config directories stops include globs from loading them.
+func backupPath(original string, t time.Time) string {
+	return fmt.Sprintf("%s.bak.%d", filepath.Join(backupFilesDir(), original), t.Unix())
+}
+
+// ListBackups returns the vhost backups under BackupsDir and, from earlier versions,
+// next to the files in the known nginx/apache config directories, oldest first.
+func ListBackups() []Backup {
+	var out []Backup
+	filepath.WalkDir(backupFilesDir(), func(p string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			if b, ok := parseBackupName(p); ok {
+				out = append(out, b)
+			}
+		}
+		return nil
+	})
+	for _, f := range collectFiles(append(append([]string{}, nginxSitesDirs...), apacheSitesDirs...)) {
+		if b, ok := parseBackupName(f); ok {
+			out = append(out, b)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Time.Before(out[j].Time) })
+	return out
+}
+
+// parseBackupName recognizes <file>.bak.<unix-seconds>, under backupFilesDir or next
+// to the original.
+func parseBackupName(path string) (Backup, bool) {
+	base := filepath.Base(path)
+	i := strings.LastIndex(base, ".bak.")
+	if i <= 0 {
+		return Backup{}, false
+	}
+	ts, err := strconv.ParseInt(base[i+len(".bak."):], 10, 64)
+	if err != nil {
+		return Backup{}, false
+	}
+	original := filepath.Join(filepath.Dir(path), base[:i])
+	if rel, err := filepath.Rel(backupFilesDir(), original); err == nil && !strings.HasPrefix(rel, "..") {
+		original = string(filepath.Separator) + rel
+	}
+	return Backup{
+		Path:     path,
+		Original: original,
+		Time:     time.Unix(ts, 0),
+	}, true
+}
+
+// Retention limits the backups kept of each config file.
+type Retention struct {
+	KeepLast int           // newest backups kept per file; 0 keeps all
+	MaxAge   time.Duration // backups older than this are removed; 0 keeps them
+}
+
+// retention is applied after every backup; see SetRetention.
+var retention = Retention{KeepLast: 10}
+
+// SetRetention replaces the default policy of keeping the last 10 backups of each
+// file, applied whenever a file is backed up.
+func SetRetention(r Retention) {
+	retention = r
+}
+
+// RetentionPolicy returns the policy set by SetRetention.
+func RetentionPolicy() Retention {
+	return retention
+}
+
+// Expired returns the backups r removes, oldest first: those beyond the newest
+// KeepLast of their file and those older than MaxAge.
+func (r Retention) Expired(backups []Backup, now time.Time) []Backup {
+	byFile := map[string][]Backup{}
+	for _, b := range backups {
+		byFile[b.Original] = append(byFile[b.Original], b)
+	}
+	var out []Backup
+	for _, list := range byFile {
+		sort.Slice(list, func(i, j int) bool { return list[i].Time.After(list[j].Time) })
+		for i, b := range list {
+			if (r.KeepLast > 0 && i >= r.KeepLast) || (r.MaxAge > 0 && now.Sub(b.Time) > r.MaxAge) {
+				out = append(out, b)
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Time.Before(out[j].Time) })
+	return out
+}
+
+// PruneBackups removes the backups r expires, or with dryRun only returns them, and
+// deletes the run manifests none of whose backups are left.
+func PruneBackups(r Retention, dryRun bool) ([]Backup, error) {
+	expired := r.Expired(ListBackups(), time.Now())
+	if dryRun {
+		return expired, nil
+	}
+	var errs []string
+	removed := expired[:0]
+	for _, b := range expired {
+		if err := os.Remove(b.Path); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		removed = append(removed, b)
+	}
+	pruneRuns()
+	if len(errs) > 0 {
+		return removed, fmt.Errorf("could not remove %d backup(s): %s", len(errs), strings.Join(errs, "; "))
+	}
+	return removed, nil
+}
+
+// pruneFile applies the retention policy to the backups of one file after it was
+// backed up. Failures only warn: the edit itself succeeded.
+func pruneFile(file string) {
+	expired := retention.Expired(FileBackups(file), time.Now())
+	for _, b := range expired {
+		if err := os.Remove(b.Path); err != nil {
+			ui.Warning("could not remove old backup: %v", err)
+		}
+	}
+	if len(expired) > 0 {
+		pruneRuns()
+	}
+}
+
+// pruneRuns deletes the run manifests whose backups are all gone.
+func pruneRuns() {
+	runs, err := Runs()
+	if err != nil {
+		return
+	}
+	for _, r := range runs {
+		left := false
+		for _, c := range r.Changes {
+			if _, err := os.Stat(c.Backup); err == nil {
+				left = true
+				break
+			}
+		}
+		if !left {
+			os.Remove(filepath.Join(RunsDir(), r.ID+".json"))
+		}
+	}
+}
+
+// FilesReferencing returns the nginx, Apache, lighttpd and mail server configuration files that mention
+// any of paths, skipping trustctl's own backups.
+func FilesReferencing(paths ...string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, f := range append(append(append(nginxFiles(), apacheFiles()...), lighttpdFiles()...), mailFiles()...) {
+		if _, ok := parseBackupName(f); ok || seen[f] {
+			continue
+		}
+		seen[f] = true
+		if data, err := os.ReadFile(f); err == nil && references(data, paths) {
+			out = append(out, f)
+		}
+	}
+	return out
+}
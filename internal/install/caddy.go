@@ -0,0 +1,283 @@
+package install
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/trustctl/trustctl/internal/keystore"
+	"github.com/trustctl/trustctl/internal/paths"
+	"github.com/trustctl/trustctl/internal/ui"
+)
+
+// caddyDataDirs are searched for Caddy's certificate storage when the admin API
+// cannot be reached, the packaged service's first.
+var caddyDataDirs = []string{
+	"/var/lib/caddy/.local/share/caddy",
+	"/var/lib/caddy",
+}
+
+// caddyAdmin is the admin endpoint: $CADDY_ADMIN, the variable Caddy reads itself,
+// else Caddy's default. A unix socket is given as unix//path.
+func caddyAdmin() string {
+	if a := os.Getenv("CADDY_ADMIN"); a != "" {
+		return a
+	}
+	return "localhost:2019"
+}
+
+// caddyClient returns a client for the admin endpoint and the base URL to use with it.
+func caddyClient(admin string) (*http.Client, string) {
+	tr := &http.Transport{Proxy: nil}
+	if strings.HasPrefix(admin, "unix/") {
+		socket := strings.TrimPrefix(admin, "unix/")
+		tr.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		}
+		// Caddy checks the Host header against its listen address
+		return &http.Client{Timeout: 30 * time.Second, Transport: tr}, "http://localhost"
+	}
+	return &http.Client{Timeout: 30 * time.Second, Transport: tr}, "http://" + strings.TrimPrefix(admin, "http://")
+}
+
+// caddyTag marks the load_pem entries trustctl adds, one per certificate.
+func caddyTag(domain string) string {
+	return "trustctl:" + domain
+}
+
+// deployCaddy loads the certificate and key into the running Caddy through its admin
+// API, as a load_pem entry of the tls app tagged with caddyTag, replacing the one an
+// earlier install added. Caddy does not manage certificates for names that have one
+// loaded this way, so it serves them without its own ACME issuance. The PEM is sent
+// inline because the service user usually cannot read the key file. Caddy applies the
+// new configuration atomically; when the endpoint does not serve the certificate in
+// time the previous configuration is loaded again.
+//
+// Without a reachable admin API the certificate and key replace the ones of the
+// domain in Caddy's certificate storage, which Caddy picks up when restarted.
+func deployCaddy(domains []string, certPath, keyPath string, opts DeployOptions) error {
+	if opts.HTTP3 || opts.HSTS > 0 {
+		return errors.New("HTTP/3 and HSTS are not supported for Caddy")
+	}
+	if keystore.IsURI(keyPath) {
+		return errors.New("Caddy needs the private key in a file, not a key URI")
+	}
+	cert, err := os.ReadFile(certPath)
+	if err != nil {
+		return err
+	}
+	key, err := os.ReadFile(keyPath)
+	if err != nil {
+		return err
+	}
+
+	admin := caddyAdmin()
+	client, base := caddyClient(admin)
+	previous, err := caddyRequest(client, http.MethodGet, base+"/config/", nil)
+	if err != nil {
+		ui.Debug("install: Caddy admin API at %s: %v", admin, err)
+		return deployCaddyStorage(domains, cert, key)
+	}
+	if opts.NoReload {
+		return errors.New("Caddy takes certificates through its admin API, which applies them at once; --no-reload does not apply")
+	}
+
+	var config map[string]interface{}
+	if err := json.Unmarshal(previous, &config); err != nil || config == nil {
+		config = map[string]interface{}{}
+	}
+	tlsApp := jsonObject(jsonObject(config, "apps"), "tls")
+	certs := jsonObject(tlsApp, "certificates")
+	tag := caddyTag(domains[0])
+	var entries []interface{}
+	if list, ok := certs["load_pem"].([]interface{}); ok {
+		for _, e := range list {
+			if m, ok := e.(map[string]interface{}); ok && caddyTagged(m, tag) {
+				continue
+			}
+			entries = append(entries, e)
+		}
+	}
+	certs["load_pem"] = append(entries, map[string]interface{}{
+		"certificate": string(cert),
+		"key":         string(key),
+		"tags":        []string{"trustctl", tag},
+	})
+	body, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	if opts.VerifyAddr == "" {
+		opts.VerifyAddr = "127.0.0.1:443"
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 30 * time.Second
+	}
+	ui.StepStart("Loading the certificate into Caddy through %s...", admin)
+	if _, err := caddyRequest(client, http.MethodPost, base+"/load", body); err != nil {
+		return fmt.Errorf("Caddy rejected the configuration, nothing changed: %w", err)
+	}
+	if err := waitForReload("caddy", nil, probeAll(domains, opts.VerifyAddr), leafFingerprint(certPath), opts); err != nil {
+		ui.Warning("Deployment not confirmed (%v); loading the previous configuration", err)
+		if _, rerr := caddyRequest(client, http.MethodPost, base+"/load", previous); rerr != nil {
+			return fmt.Errorf("%v; restoring the previous Caddy configuration failed: %v", err, rerr)
+		}
+		return fmt.Errorf("%v; previous Caddy configuration restored", err)
+	}
+	if !caddyPersisted() {
+		ui.Warning("Caddy keeps this until it restarts; start it with --resume, or add `tls %s %s` to the site in the Caddyfile", certPath, keyPath)
+	}
+	ui.Success("Caddy serving the new certificate on %s", opts.VerifyAddr)
+	return nil
+}
+
+// deployCaddyStorage replaces the certificate and key Caddy stored for each domain.
+func deployCaddyStorage(domains []string, cert, key []byte) error {
+	var dirs []string
+	for _, data := range caddyStorageDirs() {
+		for _, d := range domains {
+			name := strings.ReplaceAll(strings.ToLower(d), "*", "wildcard_")
+			matches, _ := filepath.Glob(filepath.Join(data, "certificates", "*", name, name+".crt"))
+			for _, m := range matches {
+				dirs = append(dirs, filepath.Dir(m))
+			}
+		}
+	}
+	if len(dirs) == 0 {
+		return fmt.Errorf("the Caddy admin API at %s is not reachable and Caddy's storage has no certificate for %s; "+
+			"start Caddy or set CADDY_ADMIN", caddyAdmin(), strings.Join(domains, ", "))
+	}
+	cs := newChangeSet(RunInstall, domains)
+	cs.run.Server = "caddy"
+	for _, dir := range dirs {
+		name := filepath.Base(dir)
+		for _, f := range []struct {
+			path string
+			data []byte
+		}{{filepath.Join(dir, name+".crt"), cert}, {filepath.Join(dir, name+".key"), key}} {
+			if err := cs.write(f.path, f.data); err != nil {
+				if rerr := cs.revert(); rerr != nil {
+					ui.Error("%v", rerr)
+				}
+				return err
+			}
+		}
+		ui.Info("Replaced the certificate in Caddy's storage at %s", dir)
+	}
+	ui.Warning("Caddy renews certificates in its storage itself unless its ACME issuers are disabled for these names")
+	ui.Success("Updated Caddy's certificate storage; restart Caddy to load it: %s", reloadHint("caddy"))
+	return nil
+}
+
+// caddyStorageDirs returns the Caddy data directories on disk: the config directory
+// when set, else $XDG_DATA_HOME/caddy, the packaged service's and the user's.
+func caddyStorageDirs() []string {
+	if configDirOverride != "" {
+		return []string{configDirOverride}
+	}
+	candidates := append([]string{}, caddyDataDirs...)
+	if xdg := os.Getenv("XDG_DATA_HOME"); xdg != "" {
+		candidates = append([]string{filepath.Join(xdg, "caddy")}, candidates...)
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		if runtime.GOOS == "darwin" {
+			candidates = append(candidates, filepath.Join(home, "Library/Application Support/Caddy"))
+		} else {
+			candidates = append(candidates, filepath.Join(home, ".local/share/caddy"))
+		}
+	}
+	var out []string
+	for _, c := range candidates {
+		if isDir(filepath.Join(c, "certificates")) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// caddyPersisted reports whether the running Caddy was started with --resume, so it
+// loads the configuration set through the API again after a restart.
+func caddyPersisted() bool {
+	out, err := exec.Command("pgrep", "-a", "-x", "caddy").Output()
+	return err == nil && strings.Contains(string(out), "--resume")
+}
+
+// caddyRequest sends a request to the admin API and returns the response body; Caddy
+// answers errors with a JSON object holding the message.
+func caddyRequest(client *http.Client, method, url string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(data, &e) == nil && e.Error != "" {
+			return nil, fmt.Errorf("%s %s: %s", method, url, e.Error)
+		}
+		return nil, fmt.Errorf("%s %s: %s", method, url, resp.Status)
+	}
+	return data, nil
+}
+
+// jsonObject returns the object under key in m, adding an empty one when missing.
+func jsonObject(m map[string]interface{}, key string) map[string]interface{} {
+	if o, ok := m[key].(map[string]interface{}); ok {
+		return o
+	}
+	o := map[string]interface{}{}
+	m[key] = o
+	return o
+}
+
+func caddyTagged(entry map[string]interface{}, tag string) bool {
+	tags, _ := entry["tags"].([]interface{})
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// caddyRunning reports whether the Caddy unit is active or a caddy process runs.
+func caddyRunning() bool {
+	return systemdActive("caddy") || exec.Command("pgrep", "-x", "caddy").Run() == nil
+}
+
+// restartCaddy restarts Caddy so it reads certificates from its storage again; a
+// reload with an unchanged configuration keeps the ones in memory.
+func restartCaddy() ([]byte, error) {
+	switch {
+	case runtime.GOOS == "darwin":
+		return exec.Command("brew", "services", "restart", "caddy").CombinedOutput()
+	case paths.Rootless():
+		return nil, errors.New("restarting Caddy needs root; set one with --reload-command")
+	}
+	return exec.Command("systemctl", "restart", "caddy").CombinedOutput()
+}
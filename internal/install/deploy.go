@@ -0,0 +1,649 @@
+package install
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/trustctl/trustctl/internal/paths"
+	"github.com/trustctl/trustctl/internal/ui"
+)
+
+// DeployOptions tune how Deploy confirms that a server picked up a new certificate.
+type DeployOptions struct {
+	// VerifyAddr is the TLS endpoint probed for the new certificate (default: the
+	// listen addresses of the configured blocks, or 127.0.0.1:443 for other servers).
+	VerifyAddr string
+	// Timeout bounds the wait for the reload to take effect (default 30s).
+	Timeout time.Duration
+	// NoReload only edits config files and prints the reload command.
+	NoReload bool
+	// TLSProfile sets the TLS directives of added 443 vhosts: modern, intermediate or
+	// old (default: the one set with SetTLSProfile).
+	TLSProfile string
+	// HTTP2 and HTTP3 enable those protocols in 443 vhosts the installer adds; HTTP/3
+	// is nginx only.
+	HTTP2, HTTP3 bool
+	// HSTS, when positive, also sets the Strict-Transport-Security header of the
+	// domains' 443 vhosts to this max-age.
+	HSTS time.Duration
+	// Server picks the server to configure: nginx, apache, lighttpd, tomcat, haproxy,
+	// caddy, postfix, dovecot or mail for both (default: the web server Server detects).
+	Server string
+}
+
+// Servers returns the names DeployOptions.Server accepts.
+func Servers() []string {
+	return []string{"nginx", "apache", "lighttpd", "tomcat", "haproxy", "caddy", "postfix", "dovecot", "mail"}
+}
+
+// change is one config file edited during a deployment and the backup taken first,
+// or a file the deployment created, such as a keystore.
+type change struct {
+	Path    string `json:"path"`
+	Backup  string `json:"backup,omitempty"`
+	Created bool   `json:"created,omitempty"`
+}
+
+// changeSet records the files a deployment edited so they can be reverted together,
+// and in run, when set, the manifest restore --run reads.
+type changeSet struct {
+	changes []change
+	run     *Run
+	// configured is set once a block for one of the domains was found, edited or not;
+	// endpoints are the TLS addresses of those blocks per domain, probed after the
+	// reload.
+	configured bool
+	endpoints  map[string][]string
+}
+
+// serve records that a block serving domain on the listen address listen was
+// configured. An empty listen marks blocks that are not probed, like stream servers.
+func (cs *changeSet) serve(domain, listen string) {
+	cs.configured = true
+	if listen == "" {
+		return
+	}
+	addr := probeAddr(listen)
+	for _, a := range cs.endpoints[domain] {
+		if a == addr {
+			return
+		}
+	}
+	if cs.endpoints == nil {
+		cs.endpoints = map[string][]string{}
+	}
+	cs.endpoints[domain] = append(cs.endpoints[domain], addr)
+}
+
+// write backs path up the first time it is touched in this deployment, then replaces
+// it. A file that does not exist yet is created and removed again on revert.
+func (cs *changeSet) write(path string, data []byte) error {
+	for _, c := range cs.changes {
+		if c.Path == path {
+			return writeFileAtomic(path, data)
+		}
+	}
+	c := change{Path: path}
+	if _, err := os.Lstat(path); errors.Is(err, os.ErrNotExist) {
+		if err := writeFileAtomic(path, data); err != nil {
+			return err
+		}
+		c.Created = true
+	} else {
+		bak, err := backupAndWriteFile(path, data)
+		if err != nil {
+			return err
+		}
+		c.Backup = bak
+	}
+	cs.record(c)
+	return nil
+}
+
+// remove backs path up, then deletes it.
+func (cs *changeSet) remove(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	bak := backupPath(abs, time.Now())
+	if err := os.MkdirAll(filepath.Dir(bak), 0700); err != nil {
+		return fmt.Errorf("backup failed: %w", err)
+	}
+	if err := copyFile(path, bak); err != nil {
+		return fmt.Errorf("backup failed: %w", err)
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	cs.record(change{Path: path, Backup: bak})
+	return nil
+}
+
+func (cs *changeSet) record(c change) {
+	cs.changes = append(cs.changes, c)
+	if cs.run != nil {
+		cs.run.Changes = cs.changes
+		cs.run.save()
+	}
+}
+
+// revert restores every edited file from its backup.
+func (cs *changeSet) revert() error {
+	var errs []string
+	for i := len(cs.changes) - 1; i >= 0; i-- {
+		c := cs.changes[i]
+		var err error
+		if c.Created {
+			if err = os.Remove(c.Path); errors.Is(err, os.ErrNotExist) {
+				err = nil
+			}
+		} else {
+			var data []byte
+			if data, err = os.ReadFile(c.Backup); err == nil {
+				err = writeFileAtomic(c.Path, data)
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", c.Path, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("revert failed for %s", strings.Join(errs, "; "))
+	}
+	if cs.run != nil && len(cs.changes) > 0 {
+		cs.run.Reverted = true
+		cs.run.save()
+	}
+	return nil
+}
+
+// Deploy edits the nginx or apache vhosts, or the lighttpd host conditionals, for
+// domains to use certPath/keyPath, checks the configuration (restoring the files
+// when the check fails, running or not), reloads the running server and waits until
+// the reload has replaced the worker processes and the endpoint serves the new
+// certificate. If any of that fails within the timeout, the edited files are
+// restored and the server is reloaded again, so the site is left on its previous
+// configuration. Tomcat, HAProxy, Caddy and the mail servers are only configured
+// when opts.Server asks for them; see deployTomcat, deployHAProxy, deployCaddy and
+// deployMail.
+func Deploy(domains []string, certPath, keyPath string, opts DeployOptions) error {
+	if len(domains) == 0 {
+		return errors.New("no domains provided")
+	}
+	switch opts.Server {
+	case "", "nginx", "apache", "lighttpd":
+	case "tomcat":
+		return deployTomcat(domains, certPath, keyPath, opts)
+	case "haproxy":
+		return deployHAProxy(domains, certPath, keyPath, opts)
+	case "caddy":
+		return deployCaddy(domains, certPath, keyPath, opts)
+	case "postfix", "dovecot", "mail":
+		return deployMail(domains, certPath, keyPath, opts)
+	default:
+		return fmt.Errorf("unknown server %q (expected one of %s)", opts.Server, strings.Join(Servers(), ", "))
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 30 * time.Second
+	}
+	if opts.TLSProfile == "" {
+		opts.TLSProfile = tlsProfile
+	}
+	if _, err := lookupTLSProfile(opts.TLSProfile); err != nil {
+		return err
+	}
+	if opts.TLSProfile == TLSProfileOld {
+		ui.Warning("TLS profile old enables TLSv1 and TLSv1.1 in new 443 vhosts; use it only for clients that need them")
+	}
+
+	var srv string
+	var running bool
+	if opts.Server != "" {
+		detected, err := detectRunningServer()
+		if errors.Is(err, errContainerized) {
+			return fmt.Errorf("%v; mount the certificate into the container and configure it there", err)
+		}
+		srv, running = opts.Server, err == nil && detected == opts.Server
+	} else {
+		var err error
+		if srv, running, err = Server(); err != nil {
+			return err
+		}
+	}
+
+	if srv == "apache" && opts.HTTP3 {
+		return errors.New("Apache httpd does not support HTTP/3")
+	}
+	if srv == "lighttpd" && (opts.HTTP3 || opts.HSTS > 0) {
+		return errors.New("HTTP/3 and HSTS are not supported for lighttpd")
+	}
+
+	cs := newChangeSet(RunInstall, domains)
+	cs.run.Server = srv
+	for _, d := range domains {
+		var err error
+		switch srv {
+		case "nginx":
+			err = installNginxForDomain(cs, d, certPath, keyPath, opts)
+		case "lighttpd":
+			err = installLighttpdForDomain(cs, d, certPath, keyPath, opts)
+		default:
+			err = installApacheForDomain(cs, d, certPath, keyPath, opts)
+		}
+		if err == nil && opts.HSTS > 0 {
+			err = applyHSTS(cs, srv, d, opts.HSTS, false)
+		}
+		if err != nil {
+			if rerr := cs.revert(); rerr != nil {
+				ui.Error("%v", rerr)
+			}
+			return err
+		}
+	}
+	if !cs.configured {
+		// Blocks that need no edit still get the reload, which rereads renewed files
+		ui.Warning("No %s configuration serves %s; nothing to reload", srv, strings.Join(domains, ", "))
+		return nil
+	}
+	if opts.HSTS > 0 {
+		ui.Warning("%s", HSTSWarning(opts.HSTS))
+	}
+
+	if err := checkConfig(srv, cs); err != nil {
+		return err
+	}
+	if !running {
+		ui.Success("No running server detected; updated %s configs. Reload: %s", srv, reloadHint(srv))
+		return nil
+	}
+	if opts.NoReload {
+		ui.Success("Detected running %s. Updated config files; reload with: %s", srv, reloadHint(srv))
+		return nil
+	}
+
+	before := workerPIDs(srv)
+	ui.StepStart("Reloading %s...", srv)
+	if out, err := reload(srv); err != nil {
+		return rollback(srv, cs, fmt.Errorf("reload failed: %v: %s", err, strings.TrimSpace(string(out))))
+	}
+
+	want := leafFingerprint(certPath)
+	if want == nil {
+		ui.Warning("could not read %s; only the worker reload is verified", certPath)
+	}
+	endpoints := cs.endpoints
+	if opts.VerifyAddr != "" {
+		endpoints = probeAll(domains, opts.VerifyAddr)
+	}
+	if err := waitForReload(srv, before, endpoints, want, opts); err != nil {
+		return rollback(srv, cs, err)
+	}
+	if len(endpoints) == 0 {
+		ui.Success("%s reloaded", srv)
+		return nil
+	}
+	ui.Success("%s reloaded and serving the new certificate on %s", srv, strings.Join(endpointAddrs(endpoints), ", "))
+	return nil
+}
+
+// probeAll returns the endpoints map probing every domain on addr.
+func probeAll(domains []string, addr string) map[string][]string {
+	endpoints := make(map[string][]string, len(domains))
+	for _, d := range domains {
+		endpoints[d] = []string{addr}
+	}
+	return endpoints
+}
+
+// endpointAddrs returns the addresses in endpoints, sorted and without duplicates.
+func endpointAddrs(endpoints map[string][]string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, addrs := range endpoints {
+		for _, a := range addrs {
+			if !seen[a] {
+				seen[a] = true
+				out = append(out, a)
+			}
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// probeAddr turns a listen address such as 443, *:8443, [::]:443, _default_:443 or
+// 192.0.2.10:443 into one to probe from this host: wildcard hosts become 127.0.0.1
+// and an address without a port gets 443.
+func probeAddr(listen string) string {
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		if listen != "" && strings.Trim(listen, "0123456789") == "" {
+			host, port = "", listen
+		} else {
+			host, port = strings.Trim(listen, "[]"), "443"
+		}
+	}
+	switch host {
+	case "", "*", "0.0.0.0", "::", "_default_":
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// Server returns the web server Deploy configures: the one owning the listening
+// HTTPS socket (running), else the first with configuration directories on disk.
+func Server() (srv string, running bool, err error) {
+	srv, err = detectRunningServer()
+	if errors.Is(err, errContainerized) {
+		// Host vhost files would not be the ones serving traffic
+		return "", false, fmt.Errorf("%v; mount the certificate into the container and configure it there", err)
+	}
+	if err == nil {
+		ui.Debug("install: %s owns the listening HTTPS socket", srv)
+		return srv, true, nil
+	}
+	ui.Debug("install: no running web server detected: %v", err)
+	switch {
+	case hasAnyDir(nginxSitesDirs) || findNginx() != nil || nginxMainConf() != "":
+		return "nginx", false, nil
+	case hasAnyDir(apacheSitesDirs) || apacheMainConf() != "":
+		return "apache", false, nil
+	case lighttpdMainConf() != "":
+		return "lighttpd", false, nil
+	}
+	return "", false, ErrNoServer
+}
+
+// rollback restores the edited files and reloads once more after a failed deployment.
+func rollback(srv string, cs *changeSet, cause error) error {
+	ui.Warning("Deployment not confirmed (%v); reverting configuration", cause)
+	if err := cs.revert(); err != nil {
+		return fmt.Errorf("%v; %v", cause, err)
+	}
+	if out, err := reload(srv); err != nil {
+		return fmt.Errorf("%v; configuration reverted but reload failed: %v: %s", cause, err, strings.TrimSpace(string(out)))
+	}
+	return fmt.Errorf("%v; configuration reverted and %s reloaded", cause, srv)
+}
+
+// waitForReload polls until new workers replaced the ones in before and every domain
+// serves the certificate with fingerprint want (when known) on its endpoints, or the
+// timeout expires.
+func waitForReload(srv string, before map[int]bool, endpoints map[string][]string, want []byte, opts DeployOptions) error {
+	deadline := time.Now().Add(opts.Timeout)
+	var last error
+	for {
+		last = nil
+		if before != nil && !workersReplaced(before, workerPIDs(srv)) {
+			last = errors.New("worker processes were not replaced")
+		}
+		if last == nil && want != nil {
+		probe:
+			for d, addrs := range endpoints {
+				for _, addr := range addrs {
+					got, err := servedFingerprint(addr, d)
+					if err != nil {
+						last = fmt.Errorf("%s on %s: %v", d, addr, err)
+						break probe
+					}
+					if !bytes.Equal(got, want) {
+						last = fmt.Errorf("%s still serves the previous certificate on %s", d, addr)
+						break probe
+					}
+				}
+			}
+		}
+		if last == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("not confirmed within %s: %v", opts.Timeout, last)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// configTest runs the server's own syntax check. Tomcat has none that runs beside a
+// started instance (catalina.sh configtest binds its ports); deployTomcat checks that
+// server.xml is well-formed before writing it instead. Caddy validates what its admin
+// API loads itself.
+func configTest(srv string) ([]byte, error) {
+	switch srv {
+	case "tomcat", "caddy":
+		return nil, nil
+	case "haproxy":
+		cfg, err := haproxyConfigPath()
+		if err != nil {
+			return nil, nil
+		}
+		return exec.Command("haproxy", "-c", "-f", cfg).CombinedOutput()
+	case "lighttpd":
+		return lighttpdConfigTest()
+	case "postfix", "dovecot", "mail":
+		return mailConfigTest(srv)
+	}
+	if srv == "nginx" {
+		args := []string{"-t"}
+		if b := findNginx(); b != nil && b.ConfPath != "" {
+			args = append(args, "-c", b.ConfPath)
+		}
+		return exec.Command(nginxBinary(), args...).CombinedOutput()
+	}
+	return exec.Command(apachectl(), "configtest").CombinedOutput()
+}
+
+// checkConfig runs configTest after cs edited the configuration and restores the
+// backups when it fails, so a later reload or restart still finds a valid config.
+// When the server's binary is not installed here (configs edited for a server on
+// another host or in a container) the edits are kept with a warning.
+func checkConfig(srv string, cs *changeSet) error {
+	if len(cs.changes) == 0 {
+		return nil
+	}
+	out, err := configTest(srv)
+	ui.Debug("install: %s config test: %s", srv, strings.TrimSpace(string(out)))
+	var execErr *exec.Error
+	if errors.As(err, &execErr) {
+		ui.Warning("Could not run the %s configuration test (%v); check the edited files before reloading", srv, execErr.Err)
+		return nil
+	}
+	if err != nil {
+		if rerr := cs.revert(); rerr != nil {
+			ui.Error("%v", rerr)
+		}
+		return fmt.Errorf("%s configuration test failed, changes reverted: %v\n%s", srv, err, out)
+	}
+	return nil
+}
+
+// reloadCommand is set by SetReloadCommand.
+var reloadCommand string
+
+// SetReloadCommand makes reloads run command through /bin/sh instead of systemctl,
+// service or the server's own control command, e.g. for a server run by another user.
+func SetReloadCommand(command string) {
+	reloadCommand = command
+}
+
+// reload asks the server to re-read its configuration without dropping connections.
+// Without root, system units cannot be reloaded, so the server is signalled directly
+// unless a reload command is set.
+func reload(srv string) ([]byte, error) {
+	switch {
+	case reloadCommand != "":
+		return exec.Command("/bin/sh", "-c", reloadCommand).CombinedOutput()
+	case srv == "tomcat":
+		return restartTomcat()
+	case srv == "haproxy":
+		return reloadHAProxy()
+	case srv == "caddy":
+		return restartCaddy()
+	case srv == "postfix" || srv == "dovecot" || srv == "mail":
+		return reloadMail(srv)
+	case srv == "lighttpd" && (distro == "freebsd" || runtime.GOOS == "darwin" || paths.Rootless() || !systemdActive("lighttpd")):
+		return reloadLighttpd()
+	case distro == "freebsd" && srv == "nginx":
+		return exec.Command("service", "nginx", "reload").CombinedOutput()
+	case distro == "freebsd":
+		return exec.Command("service", apacheService(), "graceful").CombinedOutput()
+	case runtime.GOOS != "darwin" && !paths.Rootless() && systemdActive(unitFor(srv)):
+		return exec.Command("systemctl", "reload", unitFor(srv)).CombinedOutput()
+	case srv == "nginx":
+		return exec.Command(nginxBinary(), "-s", "reload").CombinedOutput()
+	default:
+		return exec.Command(apachectl(), "graceful").CombinedOutput()
+	}
+}
+
+func unitFor(srv string) string {
+	switch srv {
+	case "nginx", "lighttpd", "haproxy", "caddy":
+		return srv
+	case "tomcat":
+		return tomcatService()
+	}
+	return apacheService()
+}
+
+// webServer reports whether srv is a web server found through its listening socket
+// and vhost files, rather than a service configured by name such as Tomcat or Caddy.
+func webServer(srv string) bool {
+	return srv == "" || srv == "nginx" || srv == "apache" || srv == "lighttpd"
+}
+
+// serviceRunning reports whether the service srv is running.
+func serviceRunning(srv string) bool {
+	switch srv {
+	case "tomcat":
+		return tomcatRunning()
+	case "haproxy":
+		return haproxyRunning()
+	case "caddy":
+		return caddyRunning()
+	case "postfix", "dovecot", "mail":
+		return mailRunning(srv)
+	}
+	return systemdActive(unitFor(srv))
+}
+
+func systemdActive(unit string) bool {
+	if _, err := exec.LookPath("systemctl"); err != nil {
+		return false
+	}
+	return exec.Command("systemctl", "is-active", "--quiet", unit).Run() == nil
+}
+
+func nginxBinary() string {
+	if b := findNginx(); b != nil {
+		return b.Binary
+	}
+	return "nginx"
+}
+
+func apachectl() string {
+	for _, name := range []string{"apachectl", "apache2ctl"} {
+		if p, err := exec.LookPath(name); err == nil {
+			return p
+		}
+	}
+	return "apachectl"
+}
+
+// workerPIDs returns the children of the server's oldest (master) process, or nil
+// when it cannot be found, in which case worker replacement is not checked. lighttpd
+// reloads in a single process, so only the served certificate is.
+func workerPIDs(srv string) map[int]bool {
+	if srv == "lighttpd" {
+		return nil
+	}
+	names := []string{"nginx"}
+	if srv == "apache" {
+		names = []string{"apache2", "httpd"}
+	}
+	for _, name := range names {
+		out, err := exec.Command("pgrep", "-o", "-x", name).Output()
+		if err != nil {
+			continue
+		}
+		master := strings.TrimSpace(string(out))
+		out, err = exec.Command("pgrep", "-P", master).Output()
+		if err != nil {
+			return nil
+		}
+		pids := map[int]bool{}
+		for _, f := range strings.Fields(string(out)) {
+			var pid int
+			if _, err := fmt.Sscan(f, &pid); err == nil {
+				pids[pid] = true
+			}
+		}
+		return pids
+	}
+	return nil
+}
+
+// workersReplaced reports whether at least one worker started since before was taken.
+func workersReplaced(before, after map[int]bool) bool {
+	for pid := range after {
+		if !before[pid] {
+			return true
+		}
+	}
+	return false
+}
+
+// leafFingerprint returns the SHA-256 of the first certificate in path, or nil.
+func leafFingerprint(path string) []byte {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil
+	}
+	sum := sha256.Sum256(block.Bytes)
+	return sum[:]
+}
+
+// servedFingerprint returns the SHA-256 of the leaf addr presents for domain, after
+// STARTTLS on the mail ports.
+func servedFingerprint(addr, domain string) ([]byte, error) {
+	// A wildcard cannot be sent as SNI; any name it covers selects the same vhost
+	name := domain
+	if strings.HasPrefix(name, "*.") {
+		name = "trustctl-verify" + name[1:]
+	}
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if err := starttls(conn, addr); err != nil {
+		return nil, err
+	}
+	tc := tls.Client(conn, &tls.Config{ServerName: name, InsecureSkipVerify: true})
+	if err := tc.Handshake(); err != nil {
+		return nil, err
+	}
+	certs := tc.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, errors.New("no certificate presented")
+	}
+	sum := sha256.Sum256(certs[0].Raw)
+	return sum[:], nil
+}
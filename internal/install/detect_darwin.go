@@ -0,0 +1,69 @@
+package install
+
+import (
+	"errors"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/trustctl/trustctl/internal/paths"
+)
+
+func init() {
+	// Homebrew nginx includes servers/*; Homebrew httpd and the system Apache include extra/ and other/
+	prefix := paths.HomebrewPrefix()
+	nginxSitesDirs = append([]string{filepath.Join(prefix, "etc/nginx/servers")}, nginxSitesDirs...)
+	nginxConfPaths = append([]string{filepath.Join(prefix, "etc/nginx/nginx.conf")}, nginxConfPaths...)
+	apacheConfPaths = append([]string{filepath.Join(prefix, "etc/httpd/httpd.conf")}, apacheConfPaths...)
+	apacheSitesDirs = append([]string{filepath.Join(prefix, "etc/httpd/extra"), "/etc/apache2/other"}, apacheSitesDirs...)
+	tomcatConfPaths = append([]string{filepath.Join(prefix, "opt/tomcat/libexec/conf/server.xml")}, tomcatConfPaths...)
+	haproxyConfPaths = append([]string{filepath.Join(prefix, "etc/haproxy.cfg")}, haproxyConfPaths...)
+	lighttpdConfDirs = append([]string{filepath.Join(prefix, "etc/lighttpd")}, lighttpdConfDirs...)
+	dovecotConfDirs = append([]string{filepath.Join(prefix, "etc/dovecot")}, dovecotConfDirs...)
+}
+
+// detectRunningServer looks for nginx/httpd/lighttpd processes by exact name; macOS has no
+// systemctl and a substring match on `ps` output also hits editors and log tails.
+func detectRunningServer() (string, error) {
+	if exec.Command("pgrep", "-x", "nginx").Run() == nil {
+		return "nginx", nil
+	}
+	if exec.Command("pgrep", "-x", "httpd").Run() == nil {
+		return "apache", nil
+	}
+	if exec.Command("pgrep", "-x", "lighttpd").Run() == nil {
+		return "lighttpd", nil
+	}
+	return "", errors.New("no running web server detected")
+}
+
+// reloadHint is the command an operator runs to pick up new certificate paths.
+func reloadHint(server string) string {
+	if reloadCommand != "" {
+		return reloadCommand
+	}
+	if server == "apache" {
+		return "brew services restart httpd (or sudo apachectl graceful)"
+	}
+	if server == "tomcat" {
+		return "brew services restart tomcat"
+	}
+	if server == "haproxy" {
+		return "brew services restart haproxy"
+	}
+	if server == "caddy" {
+		return "brew services restart caddy"
+	}
+	if server == "lighttpd" {
+		return "brew services restart lighttpd"
+	}
+	if server == "mail" {
+		return reloadHint("postfix") + " && " + reloadHint("dovecot")
+	}
+	if server == "postfix" {
+		return "sudo postfix reload"
+	}
+	if server == "dovecot" {
+		return "brew services restart dovecot"
+	}
+	return "brew services restart nginx (or sudo nginx -s reload)"
+}
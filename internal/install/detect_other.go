@@ -0,0 +1,87 @@
+//go:build !darwin
+
+package install
+
+import (
+	"errors"
+	"os/exec"
+
+	"github.com/trustctl/trustctl/internal/paths"
+)
+
+// detectRunningServer tries to detect which webserver is currently running.
+// The process owning :80/:443 is authoritative; systemd unit state is the fallback.
+func detectRunningServer() (string, error) {
+	srv, err := socketOwnerServer()
+	if err == nil {
+		return srv, nil
+	}
+	if errors.Is(err, errContainerized) {
+		return "", err
+	}
+
+	// Check via systemctl if available
+	if _, err := exec.LookPath("systemctl"); err == nil {
+		// check nginx
+		if err := exec.Command("systemctl", "is-active", "--quiet", "nginx").Run(); err == nil {
+			return "nginx", nil
+		}
+		// check apache variants
+		if err := exec.Command("systemctl", "is-active", "--quiet", "apache2").Run(); err == nil {
+			return "apache", nil
+		}
+		if err := exec.Command("systemctl", "is-active", "--quiet", "httpd").Run(); err == nil {
+			return "apache", nil
+		}
+		if err := exec.Command("systemctl", "is-active", "--quiet", "lighttpd").Run(); err == nil {
+			return "lighttpd", nil
+		}
+	}
+	return "", errors.New("no running web server detected")
+}
+
+// reloadHint is the command an operator runs to pick up new certificate paths.
+func reloadHint(server string) string {
+	switch {
+	case reloadCommand != "":
+		return reloadCommand
+	case server == "tomcat":
+		return "sudo systemctl restart " + tomcatService()
+	case server == "haproxy" && distro == "freebsd":
+		return "sudo service haproxy reload"
+	case server == "haproxy":
+		return "sudo systemctl reload haproxy"
+	case server == "caddy":
+		return "sudo systemctl restart caddy"
+	case server == "mail":
+		return reloadHint("postfix") + " && " + reloadHint("dovecot")
+	case server == "postfix" && distro == "freebsd":
+		return "sudo postfix reload"
+	case server == "postfix":
+		return "sudo systemctl reload postfix"
+	case server == "dovecot" && distro == "freebsd":
+		return "sudo doveadm reload"
+	case server == "dovecot":
+		return "sudo systemctl reload dovecot"
+	case server == "lighttpd" && distro == "freebsd":
+		return "sudo service lighttpd reload"
+	case server == "lighttpd" && paths.Rootless():
+		return "kill -USR1 $(pgrep -o -x lighttpd)"
+	case server == "lighttpd":
+		return "sudo systemctl reload lighttpd"
+	case paths.Rootless() && server == "apache":
+		return apachectl() + " graceful"
+	case paths.Rootless():
+		return nginxBinary() + " -s reload"
+	}
+	if server == "apache" {
+		if distro == "freebsd" {
+			return "sudo service apache24 graceful"
+		}
+		return "sudo systemctl reload " + apacheService()
+	}
+	if distro == "freebsd" {
+		return "sudo service nginx reload"
+	}
+	return "sudo systemctl reload nginx"
+}
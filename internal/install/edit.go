@@ -0,0 +1,43 @@
+package install
+
+import (
+	"sort"
+	"strings"
+)
+
+// indentOf returns the whitespace before offset on its line.
+func indentOf(src string, offset int) string {
+	i := offset
+	for i > 0 && (src[i-1] == ' ' || src[i-1] == '\t') {
+		i--
+	}
+	return src[i:offset]
+}
+
+// deleteLine returns the edit removing src[start:end] along with the indentation
+// before it and the line break after it.
+func deleteLine(src string, start, end int) textEdit {
+	start -= len(indentOf(src, start))
+	if strings.HasPrefix(src[end:], "\r\n") {
+		end += 2
+	} else if strings.HasPrefix(src[end:], "\n") {
+		end++
+	}
+	return textEdit{Start: start, End: end}
+}
+
+// textEdit replaces src[Start:End] with Text; Start == End inserts.
+type textEdit struct {
+	Start, End int
+	Text       string
+}
+
+// applyEdits applies non-overlapping edits to src.
+func applyEdits(src string, edits []textEdit) string {
+	// Apply from the end so earlier offsets stay valid
+	sort.SliceStable(edits, func(i, j int) bool { return edits[i].Start > edits[j].Start })
+	for _, e := range edits {
+		src = src[:e.Start] + e.Text + src[e.End:]
+	}
+	return src
+}
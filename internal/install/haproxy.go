@@ -0,0 +1,496 @@
+package install
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/trustctl/trustctl/internal/keystore"
+	"github.com/trustctl/trustctl/internal/paths"
+	"github.com/trustctl/trustctl/internal/ui"
+)
+
+// haproxyConfPaths are searched for haproxy.cfg.
+var haproxyConfPaths = []string{
+	"/etc/haproxy/haproxy.cfg",
+	"/usr/local/etc/haproxy/haproxy.cfg",
+}
+
+// haproxySections are the keywords that start a section of haproxy.cfg.
+var haproxySections = map[string]bool{
+	"global": true, "defaults": true, "frontend": true, "backend": true, "listen": true,
+	"peers": true, "resolvers": true, "userlist": true, "mailers": true, "program": true,
+	"http-errors": true, "ring": true, "cache": true,
+}
+
+// haproxyBind is a `bind ... ssl` line of a frontend or listen section.
+type haproxyBind struct {
+	Addr     string
+	Crts     []string // crt files and directories, resolved against crt-base
+	CrtLists []string
+	End      int // offset of the end of the line's arguments, where crt is appended
+	Line     int
+}
+
+// haproxyConfig is what the installer reads from haproxy.cfg.
+type haproxyConfig struct {
+	Path   string
+	Src    string
+	Binds  []haproxyBind
+	Socket string // a stats socket at admin level, for the runtime API
+}
+
+// parseHAProxy reads the TLS bind lines and the admin stats socket of haproxy.cfg.
+func parseHAProxy(path, src string) *haproxyConfig {
+	cfg := &haproxyConfig{Path: path, Src: src}
+	section, crtBase := "", ""
+	resolve := func(p string) string {
+		if filepath.IsAbs(p) {
+			return p
+		}
+		if crtBase != "" {
+			return filepath.Join(crtBase, p)
+		}
+		return filepath.Join(filepath.Dir(path), p)
+	}
+	offset := 0
+	for n, line := range strings.SplitAfter(src, "\n") {
+		start := offset
+		offset += len(line)
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimRight(line, " \t\r\n")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if haproxySections[fields[0]] {
+			section = fields[0]
+			continue
+		}
+		switch {
+		case section == "global" && fields[0] == "crt-base" && len(fields) > 1:
+			crtBase = fields[1]
+		case section == "global" && len(fields) > 2 && fields[0] == "stats" && fields[1] == "socket":
+			level := "admin"
+			for i := 3; i < len(fields)-1; i++ {
+				if fields[i] == "level" {
+					level = fields[i+1]
+				}
+			}
+			if level == "admin" && cfg.Socket == "" {
+				cfg.Socket = fields[2]
+			}
+		case (section == "frontend" || section == "listen") && fields[0] == "bind" && len(fields) > 1:
+			b := haproxyBind{Addr: fields[1], End: start + len(line), Line: n + 1}
+			ssl := false
+			for i := 2; i < len(fields); i++ {
+				switch {
+				case fields[i] == "ssl":
+					ssl = true
+				case fields[i] == "crt" && i+1 < len(fields):
+					i++
+					b.Crts = append(b.Crts, resolve(fields[i]))
+				case fields[i] == "crt-list" && i+1 < len(fields):
+					i++
+					b.CrtLists = append(b.CrtLists, resolve(fields[i]))
+				}
+			}
+			if ssl {
+				cfg.Binds = append(cfg.Binds, b)
+			}
+		}
+	}
+	return cfg
+}
+
+// haproxyConfigPath returns the haproxy.cfg to edit: the one in the config directory
+// when set, else the first of haproxyConfPaths.
+func haproxyConfigPath() (string, error) {
+	candidates := haproxyConfPaths
+	if configDirOverride != "" {
+		candidates = []string{filepath.Join(configDirOverride, "haproxy.cfg")}
+	}
+	for _, c := range candidates {
+		if fileExists(c) {
+			return c, nil
+		}
+	}
+	return "", fmt.Errorf("no haproxy.cfg found (looked at %s)", strings.Join(candidates, ", "))
+}
+
+// haproxyEntry is a line of a crt-list: a certificate file and its SNI filters.
+type haproxyEntry struct {
+	Path    string
+	Filters []string
+}
+
+func readCrtList(path string) []haproxyEntry {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var out []haproxyEntry
+	for _, line := range strings.Split(string(data), "\n") {
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		// Bind options are in brackets between the file and the filters
+		if i, j := strings.IndexByte(line, '['), strings.IndexByte(line, ']'); i >= 0 && j > i {
+			line = line[:i] + line[j+1:]
+		}
+		fields := strings.Fields(line)
+		if len(fields) > 0 {
+			out = append(out, haproxyEntry{Path: fields[0], Filters: fields[1:]})
+		}
+	}
+	return out
+}
+
+// haproxyTarget is where the combined PEM for a domain goes: an existing file that
+// HAProxy loads for it, or a new one that is added to a crt-list or crt directory,
+// or appended to a bind line with `crt`.
+type haproxyTarget struct {
+	File    string
+	Exists  bool
+	CrtList string // the crt-list or crt directory that loads File
+	Bind    *haproxyBind
+}
+
+// findHAProxyTarget returns the file that serves domain in the TLS bind lines: a
+// crt-list entry filtered for it, or a file in a crt-list, crt directory or crt
+// argument whose certificate covers it.
+func findHAProxyTarget(cfg *haproxyConfig, domain string) (haproxyTarget, bool) {
+	for i := range cfg.Binds {
+		b := &cfg.Binds[i]
+		for _, list := range b.CrtLists {
+			for _, e := range readCrtList(list) {
+				file := e.Path
+				if !filepath.IsAbs(file) {
+					file = filepath.Join(filepath.Dir(list), file)
+				}
+				matches := false
+				for _, f := range e.Filters {
+					if !strings.HasPrefix(f, "!") && nameMatches(f, domain) {
+						matches = true
+					}
+				}
+				if matches || (len(e.Filters) == 0 && certFileCovers(file, domain)) {
+					return haproxyTarget{File: file, Exists: true, CrtList: list, Bind: b}, true
+				}
+			}
+		}
+		for _, crt := range b.Crts {
+			if fi, err := os.Stat(crt); err == nil && fi.IsDir() {
+				entries, _ := os.ReadDir(crt)
+				for _, e := range entries {
+					file := filepath.Join(crt, e.Name())
+					if !e.IsDir() && !haproxySidecar(file) && certFileCovers(file, domain) {
+						return haproxyTarget{File: file, Exists: true, CrtList: crt, Bind: b}, true
+					}
+				}
+			} else if certFileCovers(crt, domain) {
+				return haproxyTarget{File: crt, Exists: true, Bind: b}, true
+			}
+		}
+	}
+	return haproxyTarget{}, false
+}
+
+// newHAProxyTarget returns where a certificate HAProxy does not load yet goes: a new
+// file in the first crt-list, else in the first crt directory, else next to the first
+// crt file, added to its bind line.
+func newHAProxyTarget(cfg *haproxyConfig, name string) (haproxyTarget, error) {
+	for i := range cfg.Binds {
+		b := &cfg.Binds[i]
+		for _, list := range b.CrtLists {
+			dir := filepath.Dir(list)
+			if entries := readCrtList(list); len(entries) > 0 && filepath.IsAbs(entries[0].Path) {
+				dir = filepath.Dir(entries[0].Path)
+			}
+			return haproxyTarget{File: filepath.Join(dir, name), CrtList: list, Bind: b}, nil
+		}
+	}
+	for i := range cfg.Binds {
+		b := &cfg.Binds[i]
+		for _, crt := range b.Crts {
+			if fi, err := os.Stat(crt); err == nil && fi.IsDir() {
+				return haproxyTarget{File: filepath.Join(crt, name), CrtList: crt, Bind: b}, nil
+			}
+		}
+	}
+	for i := range cfg.Binds {
+		b := &cfg.Binds[i]
+		if len(b.Crts) > 0 {
+			return haproxyTarget{File: filepath.Join(filepath.Dir(b.Crts[0]), name), Bind: b}, nil
+		}
+	}
+	return haproxyTarget{}, fmt.Errorf("%s has no `bind ... ssl crt` line; add one such as `bind :443 ssl crt /etc/haproxy/certs/` to a frontend", cfg.Path)
+}
+
+// haproxySidecar reports whether file in a crt directory is one HAProxy reads beside
+// a certificate rather than a certificate itself.
+func haproxySidecar(file string) bool {
+	switch filepath.Ext(file) {
+	case ".key", ".ocsp", ".issuer", ".sctl":
+		return true
+	}
+	return false
+}
+
+// deployHAProxy writes the key, certificate and chain as one PEM file where the TLS
+// bind lines of haproxy.cfg load the certificate for domains: over the file that
+// serves them now, or as a new file in their crt-list or crt directory. A running
+// HAProxy gets the certificate through the runtime API (set ssl cert) when haproxy.cfg
+// has an admin stats socket, so no connection is dropped, and is reloaded otherwise
+// or when that fails. Failures restore every file and reload again.
+func deployHAProxy(domains []string, certPath, keyPath string, opts DeployOptions) error {
+	if opts.HTTP3 || opts.HSTS > 0 {
+		return errors.New("HTTP/3 and HSTS are not supported for HAProxy")
+	}
+	if keystore.IsURI(keyPath) {
+		return errors.New("HAProxy needs the private key in a file, not a key URI")
+	}
+	cfgPath, err := haproxyConfigPath()
+	if err != nil {
+		return err
+	}
+	src, err := os.ReadFile(cfgPath)
+	if err != nil {
+		return err
+	}
+	cfg := parseHAProxy(cfgPath, string(src))
+	bundle, err := haproxyBundle(certPath, keyPath)
+	if err != nil {
+		return err
+	}
+
+	var targets []haproxyTarget
+	var uncovered []string
+	for _, d := range domains {
+		t, ok := findHAProxyTarget(cfg, d)
+		if !ok {
+			uncovered = append(uncovered, d)
+			continue
+		}
+		dup := false
+		for _, seen := range targets {
+			dup = dup || seen.File == t.File
+		}
+		if !dup {
+			targets = append(targets, t)
+		}
+	}
+	var added *haproxyTarget
+	if len(uncovered) > 0 {
+		t, err := newHAProxyTarget(cfg, strings.Replace(uncovered[0], "*", "wildcard", 1)+".pem")
+		if err != nil {
+			return err
+		}
+		added = &t
+		targets = append(targets, t)
+	}
+
+	cs := newChangeSet(RunInstall, domains)
+	cs.run.Server = "haproxy"
+	fail := func(err error) error {
+		if rerr := cs.revert(); rerr != nil {
+			ui.Error("%v", rerr)
+		}
+		return err
+	}
+	for _, t := range targets {
+		if err := cs.write(t.File, bundle); err != nil {
+			return fail(err)
+		}
+		if !t.Exists {
+			// Holds the private key; HAProxy reads it as root before dropping privileges
+			os.Chmod(t.File, 0600)
+		}
+		ui.Info("Wrote the certificate bundle %s (bind %s, %s:%d)", t.File, t.Bind.Addr, cfgPath, t.Bind.Line)
+	}
+	if added != nil {
+		switch {
+		case added.CrtList != "" && !isDir(added.CrtList):
+			data, err := os.ReadFile(added.CrtList)
+			if err != nil {
+				return fail(err)
+			}
+			if len(data) > 0 && !bytes.HasSuffix(data, []byte("\n")) {
+				data = append(data, '\n')
+			}
+			data = append(data, added.File+" "+strings.Join(uncovered, " ")+"\n"...)
+			if err := cs.write(added.CrtList, data); err != nil {
+				return fail(err)
+			}
+			ui.Info("Added %s for %s to the crt-list %s", added.File, strings.Join(uncovered, ", "), added.CrtList)
+		case added.CrtList == "":
+			out := applyEdits(cfg.Src, []textEdit{{Start: added.Bind.End, End: added.Bind.End, Text: " crt " + added.File}})
+			if err := cs.write(cfgPath, []byte(out)); err != nil {
+				return fail(err)
+			}
+			ui.Info("Added crt %s to the bind line at %s:%d", added.File, cfgPath, added.Bind.Line)
+		}
+	}
+
+	if err := checkConfig("haproxy", cs); err != nil {
+		return err
+	}
+	if !serviceRunning("haproxy") {
+		ui.Success("No running HAProxy detected; updated its certificates. Reload: %s", reloadHint("haproxy"))
+		return nil
+	}
+	if opts.NoReload {
+		ui.Success("Updated HAProxy's certificates; reload with: %s", reloadHint("haproxy"))
+		return nil
+	}
+
+	if opts.VerifyAddr == "" {
+		opts.VerifyAddr = haproxyVerifyAddr(targets[0].Bind.Addr)
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 30 * time.Second
+	}
+	if cfg.Socket != "" && (added == nil || added.CrtList != "") {
+		if err := haproxyUpdate(cfg.Socket, targets, added, uncovered, bundle); err != nil {
+			ui.Warning("Runtime API update failed (%v); reloading HAProxy instead", err)
+		} else {
+			ui.StepDone("Updated the certificates through the runtime API at %s", cfg.Socket)
+			if err := waitForReload("haproxy", nil, probeAll(domains, opts.VerifyAddr), leafFingerprint(certPath), opts); err != nil {
+				return rollback("haproxy", cs, err)
+			}
+			ui.Success("HAProxy serving the new certificate on %s", opts.VerifyAddr)
+			return nil
+		}
+	}
+	ui.StepStart("Reloading HAProxy...")
+	if out, err := reload("haproxy"); err != nil {
+		return rollback("haproxy", cs, fmt.Errorf("reload failed: %v: %s", err, strings.TrimSpace(string(out))))
+	}
+	if err := waitForReload("haproxy", nil, probeAll(domains, opts.VerifyAddr), leafFingerprint(certPath), opts); err != nil {
+		return rollback("haproxy", cs, err)
+	}
+	ui.Success("HAProxy reloaded and serving the new certificate on %s", opts.VerifyAddr)
+	return nil
+}
+
+// haproxyBundle returns the private key followed by the certificate and its chain,
+// the layout of combined.pem.
+func haproxyBundle(certPath, keyPath string) ([]byte, error) {
+	key, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	full, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, err
+	}
+	return append(bytes.TrimRight(key, "\n"), append([]byte("\n"), full...)...), nil
+}
+
+// haproxyUpdate loads the bundle into the running HAProxy through the runtime API:
+// a transaction per existing file, and for a new one an empty store that is filled
+// and added to its crt-list or crt directory.
+func haproxyUpdate(socket string, targets []haproxyTarget, added *haproxyTarget, filters []string, bundle []byte) error {
+	// The payload of a command ends at the first empty line
+	var payload []string
+	for _, line := range strings.Split(string(bundle), "\n") {
+		if strings.TrimSpace(line) != "" {
+			payload = append(payload, strings.TrimRight(line, "\r"))
+		}
+	}
+	for _, t := range targets {
+		if !t.Exists {
+			if err := haproxyCommand(socket, "new ssl cert "+t.File, "New empty certificate store"); err != nil {
+				return err
+			}
+		}
+		if err := haproxyCommand(socket, "set ssl cert "+t.File+" <<\n"+strings.Join(payload, "\n")+"\n", "Transaction"); err != nil {
+			haproxyCommand(socket, "abort ssl cert "+t.File, "")
+			return err
+		}
+		if err := haproxyCommand(socket, "commit ssl cert "+t.File, "Success"); err != nil {
+			return err
+		}
+	}
+	if added != nil {
+		entry := added.File
+		if !isDir(added.CrtList) {
+			entry += " " + strings.Join(filters, " ")
+		}
+		return haproxyCommand(socket, "add ssl crt-list "+added.CrtList+" <<\n"+entry+"\n", "Success")
+	}
+	return nil
+}
+
+// haproxyCommand sends one command to the stats socket and fails unless the answer
+// contains want.
+func haproxyCommand(socket, command, want string) error {
+	network, addr := "unix", strings.TrimPrefix(socket, "unix@")
+	for _, prefix := range []string{"ipv4@", "ipv6@"} {
+		if strings.HasPrefix(socket, prefix) {
+			network, addr = "tcp", strings.TrimPrefix(socket, prefix)
+		}
+	}
+	conn, err := net.DialTimeout(network, addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	if _, err := io.WriteString(conn, command+"\n"); err != nil {
+		return err
+	}
+	out, err := io.ReadAll(conn)
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(string(out), want) {
+		verb := strings.Join(strings.Fields(command)[:3], " ")
+		return fmt.Errorf("%s: %s", verb, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// haproxyVerifyAddr turns a bind address such as :443 or ipv4@*:8443 into one to
+// probe from this host.
+func haproxyVerifyAddr(bind string) string {
+	bind = strings.Split(bind, ",")[0]
+	if i := strings.Index(bind, "@"); i >= 0 {
+		bind = bind[i+1:]
+	}
+	return probeAddr(bind)
+}
+
+func isDir(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
+}
+
+// haproxyRunning reports whether the HAProxy unit is active or an haproxy process runs.
+func haproxyRunning() bool {
+	return systemdActive("haproxy") || exec.Command("pgrep", "-x", "haproxy").Run() == nil
+}
+
+// reloadHAProxy reloads HAProxy, which starts new processes that take over the
+// listeners while the old ones finish their connections.
+func reloadHAProxy() ([]byte, error) {
+	switch {
+	case runtime.GOOS == "darwin":
+		return exec.Command("brew", "services", "restart", "haproxy").CombinedOutput()
+	case distro == "freebsd":
+		return exec.Command("service", "haproxy", "reload").CombinedOutput()
+	case paths.Rootless():
+		return nil, errors.New("reloading HAProxy needs root; set one with --reload-command")
+	}
+	return exec.Command("systemctl", "reload", "haproxy").CombinedOutput()
+}
@@ -0,0 +1,118 @@
+package install
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TLS profiles of the Mozilla server side TLS guidelines. They set the protocols,
+// ciphers and session settings of the 443 blocks the installer creates and of the
+// directives `trustctl scan` suggests when an endpoint falls short.
+const (
+	TLSProfileModern       = "modern"       // TLSv1.3 only
+	TLSProfileIntermediate = "intermediate" // TLSv1.2 and TLSv1.3, the recommended default
+	TLSProfileOld          = "old"          // back to TLSv1 for legacy clients
+)
+
+// TLSGuidelinesVersion is the version of the Mozilla guidelines the profiles follow.
+// It is written into every generated block so the TLS settings of a fleet can be
+// audited and regenerated when the guidelines move on.
+const TLSGuidelinesVersion = "5.7"
+
+// TLSProfile is the data of the TLS directive templates.
+type TLSProfile struct {
+	Name                string
+	Version             string   // TLSGuidelinesVersion
+	Protocols           []string // e.g. TLSv1.2 TLSv1.3
+	Ciphers             string   // OpenSSL cipher list for TLSv1.2 and below; empty when TLSv1.3 only
+	PreferServerCiphers bool
+	SessionTimeout      int // seconds
+}
+
+const (
+	intermediateCiphers = "ECDHE-ECDSA-AES128-GCM-SHA256:ECDHE-RSA-AES128-GCM-SHA256:ECDHE-ECDSA-AES256-GCM-SHA384:ECDHE-RSA-AES256-GCM-SHA384:" +
+		"ECDHE-ECDSA-CHACHA20-POLY1305:ECDHE-RSA-CHACHA20-POLY1305:DHE-RSA-AES128-GCM-SHA256:DHE-RSA-AES256-GCM-SHA384:DHE-RSA-CHACHA20-POLY1305"
+	oldCiphers = intermediateCiphers + ":ECDHE-ECDSA-AES128-SHA256:ECDHE-RSA-AES128-SHA256:ECDHE-ECDSA-AES128-SHA:ECDHE-RSA-AES128-SHA:" +
+		"ECDHE-ECDSA-AES256-SHA384:ECDHE-RSA-AES256-SHA384:ECDHE-ECDSA-AES256-SHA:ECDHE-RSA-AES256-SHA:DHE-RSA-AES128-SHA256:DHE-RSA-AES256-SHA256:" +
+		"AES128-GCM-SHA256:AES256-GCM-SHA384:AES128-SHA256:AES256-SHA256:AES128-SHA:AES256-SHA:DES-CBC3-SHA"
+)
+
+var tlsProfiles = map[string]TLSProfile{
+	TLSProfileModern: {
+		Name:           TLSProfileModern,
+		Version:        TLSGuidelinesVersion,
+		Protocols:      []string{"TLSv1.3"},
+		SessionTimeout: 86400,
+	},
+	TLSProfileIntermediate: {
+		Name:           TLSProfileIntermediate,
+		Version:        TLSGuidelinesVersion,
+		Protocols:      []string{"TLSv1.2", "TLSv1.3"},
+		Ciphers:        intermediateCiphers,
+		SessionTimeout: 86400,
+	},
+	TLSProfileOld: {
+		Name:                TLSProfileOld,
+		Version:             TLSGuidelinesVersion,
+		Protocols:           []string{"TLSv1", "TLSv1.1", "TLSv1.2", "TLSv1.3"},
+		Ciphers:             oldCiphers,
+		PreferServerCiphers: true,
+		SessionTimeout:      86400,
+	},
+}
+
+// TLSProfiles lists the profile names, strictest first.
+func TLSProfiles() []string {
+	return []string{TLSProfileModern, TLSProfileIntermediate, TLSProfileOld}
+}
+
+var tlsProfile = TLSProfileIntermediate
+
+// SetTLSProfile sets the profile of new 443 blocks when the deployment does not name
+// one; "" restores intermediate.
+func SetTLSProfile(name string) error {
+	if name == "" {
+		name = TLSProfileIntermediate
+	}
+	if _, err := lookupTLSProfile(name); err != nil {
+		return err
+	}
+	tlsProfile = name
+	return nil
+}
+
+// CheckTLSProfile returns an error unless name is one of TLSProfiles(); "" is the
+// configured profile.
+func CheckTLSProfile(name string) error {
+	_, err := lookupTLSProfile(name)
+	return err
+}
+
+func lookupTLSProfile(name string) (TLSProfile, error) {
+	if name == "" {
+		name = tlsProfile
+	}
+	p, ok := tlsProfiles[name]
+	if !ok {
+		return p, fmt.Errorf("unknown TLS profile %q (expected %s)", name, strings.Join(TLSProfiles(), ", "))
+	}
+	return p, nil
+}
+
+// TLSDirectives renders the TLS directives of profile ("" for the configured one) for
+// srv, nginx, apache or lighttpd, one per line and tab-indented, from that server's
+// -tls.conf.tmpl template.
+func TLSDirectives(srv, profile string) (string, error) {
+	p, err := lookupTLSProfile(profile)
+	if err != nil {
+		return "", err
+	}
+	name := nginxTLSTemplate
+	switch srv {
+	case "apache":
+		name = apacheTLSTemplate
+	case "lighttpd":
+		name = lighttpdTLSTemplate
+	}
+	return render(name, p)
+}
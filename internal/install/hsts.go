@@ -0,0 +1,202 @@
+package install
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/trustctl/trustctl/internal/ui"
+)
+
+// DefaultHSTSMaxAge is the Strict-Transport-Security max-age used when none is given:
+// two years, as the Mozilla guidelines recommend.
+const DefaultHSTSMaxAge = 2 * 365 * 24 * time.Hour
+
+const hstsHeader = "Strict-Transport-Security"
+
+// HSTSWarning explains what a Strict-Transport-Security header with maxAge commits
+// the domains to.
+func HSTSWarning(maxAge time.Duration) string {
+	return fmt.Sprintf("HSTS makes browsers that visit over HTTPS refuse plain HTTP and certificate errors for %d day(s). "+
+		"Removing the header later does not release them: first serve max-age=0 with `trustctl hsts set --max-age 0`, "+
+		"then `trustctl hsts remove` once the old max-age has passed", int64(maxAge/(24*time.Hour)))
+}
+
+// SetHSTS adds the Strict-Transport-Security header with maxAge to the TLS vhosts
+// serving domains, or updates its max-age, then checks the configuration and reloads
+// the running server. maxAge 0 tells browsers to forget the policy. It returns the
+// files changed.
+func SetHSTS(domains []string, maxAge time.Duration) ([]string, error) {
+	if maxAge < 0 {
+		return nil, fmt.Errorf("HSTS max-age must not be negative")
+	}
+	return editHSTS(domains, maxAge, false)
+}
+
+// RemoveHSTS removes the Strict-Transport-Security header from the TLS vhosts serving
+// domains, then checks the configuration and reloads the running server.
+func RemoveHSTS(domains []string) ([]string, error) {
+	return editHSTS(domains, 0, true)
+}
+
+func editHSTS(domains []string, maxAge time.Duration, remove bool) ([]string, error) {
+	if len(domains) == 0 {
+		return nil, fmt.Errorf("no domains provided")
+	}
+	srv, _, err := Server()
+	if err != nil {
+		return nil, err
+	}
+	cs := newChangeSet(RunHSTS, domains)
+	cs.run.Server = srv
+	for _, d := range domains {
+		if err := applyHSTS(cs, srv, d, maxAge, remove); err != nil {
+			if rerr := cs.revert(); rerr != nil {
+				ui.Error("%v", rerr)
+			}
+			return nil, err
+		}
+	}
+	if len(cs.changes) == 0 {
+		return nil, nil
+	}
+	files := cs.run.Files()
+	return files, checkAndReload(cs)
+}
+
+// applyHSTS sets the header with maxAge, or removes it, in every TLS vhost of srv
+// serving domain. Vhosts without one get it after their certificate directives; one
+// already set to maxAge is left alone.
+func applyHSTS(cs *changeSet, srv, domain string, maxAge time.Duration, remove bool) error {
+	files := nginxFiles()
+	if srv == "apache" {
+		files = apacheFiles()
+	}
+	found := false
+	for _, f := range files {
+		content, err := os.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		src := string(content)
+		var edits []textEdit
+		if srv == "apache" {
+			dirs, err := parseApache(src)
+			if err != nil {
+				ui.Warning("Not editing %s: %v", f, err)
+				continue
+			}
+			for _, vh := range apacheVhosts(dirs) {
+				if vh.serves(domain) && vh.tls() {
+					found = true
+					edits = append(edits, apacheHSTSEdits(src, vh, maxAge, remove)...)
+				}
+			}
+		} else {
+			dirs, err := parseNginx(src)
+			if err != nil {
+				ui.Warning("Not editing %s: %v", f, err)
+				continue
+			}
+			for _, s := range nginxHTTPServers(dirs) {
+				if s.serves(domain) && s.tls() {
+					found = true
+					edits = append(edits, nginxHSTSEdits(src, s, maxAge, remove)...)
+				}
+			}
+		}
+		if len(edits) == 0 {
+			continue
+		}
+		if err := cs.write(f, []byte(applyEdits(src, edits))); err != nil {
+			return err
+		}
+		if remove {
+			ui.Info("Removed the %s header for %s from %s", hstsHeader, domain, f)
+		} else {
+			ui.Info("Set %s max-age=%d for %s in %s", hstsHeader, int64(maxAge/time.Second), domain, f)
+		}
+	}
+	if !found {
+		ui.Warning("No %s 443 vhost found for %s; HSTS not changed", srv, domain)
+	}
+	return nil
+}
+
+func nginxHSTSEdits(src string, s *nginxDirective, maxAge time.Duration, remove bool) []textEdit {
+	var edits []textEdit
+	var header *nginxDirective
+	for _, h := range s.find("add_header") {
+		if len(h.Args) == 0 || !strings.EqualFold(h.Args[0], hstsHeader) {
+			continue
+		}
+		if remove || header != nil {
+			edits = append(edits, deleteLine(src, h.Start, h.End))
+		} else {
+			header = h
+		}
+	}
+	if remove {
+		return edits
+	}
+	text := fmt.Sprintf(`add_header %s "max-age=%d" always;`, hstsHeader, int64(maxAge/time.Second))
+	if header != nil {
+		if src[header.Start:header.End] != text {
+			edits = append(edits, textEdit{Start: header.Start, End: header.End, Text: text})
+		}
+		return edits
+	}
+	anchor := s.find("server_name")
+	if keys := s.find("ssl_certificate_key"); len(keys) > 0 {
+		anchor = keys
+	}
+	if len(anchor) == 0 {
+		return edits
+	}
+	a := anchor[len(anchor)-1]
+	return append(edits, textEdit{Start: a.End, End: a.End, Text: "\n" + indentOf(src, a.Start) + text})
+}
+
+func apacheHSTSEdits(src string, vh *apacheDirective, maxAge time.Duration, remove bool) []textEdit {
+	var edits []textEdit
+	var header *apacheDirective
+	for _, h := range vh.find("Header") {
+		if !containsFold(h.Args, hstsHeader) {
+			continue
+		}
+		if remove || header != nil {
+			edits = append(edits, deleteLine(src, h.Start, h.End))
+		} else {
+			header = h
+		}
+	}
+	if remove {
+		return edits
+	}
+	text := fmt.Sprintf(`Header always set %s "max-age=%d"`, hstsHeader, int64(maxAge/time.Second))
+	if header != nil {
+		if src[header.Start:header.End] != text {
+			edits = append(edits, textEdit{Start: header.Start, End: header.End, Text: text})
+		}
+		return edits
+	}
+	anchor := vh.find("ServerName")
+	if keys := vh.find("SSLCertificateKeyFile"); len(keys) > 0 {
+		anchor = keys
+	}
+	if len(anchor) == 0 {
+		return edits
+	}
+	a := anchor[len(anchor)-1]
+	return append(edits, textEdit{Start: a.End, End: a.End, Text: "\n" + indentOf(src, a.Start) + text})
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,17 +1,16 @@
 package install
 
 import (
-	"bufio"
 	"errors"
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strings"
 	"time"
 
+	"github.com/trustctl/trustctl/internal/keystore"
 	"github.com/trustctl/trustctl/internal/ui"
 )
 
@@ -20,255 +19,392 @@ import (
 // - Shows which vhost file(s) will be used
 // - If a 443 vhost exists for the same domain, replaces the SSL cert paths
 // - Otherwise creates a new 443 vhost block per domain in the same file
-// Files are backed up and written atomically. This is a practical, text-based approach
+// nginx files are parsed into blocks and only the server blocks of the domain are
+// edited. Files are backed up and written atomically. Apache edits are text-based
 // and should be reviewed before use in production.
 
 var (
-	nginxSitesDirs  = []string{"/etc/nginx/sites-enabled", "/etc/nginx/sites-available", "/etc/nginx/conf.d"}
+	nginxSitesDirs  = []string{"/etc/nginx/sites-enabled", "/etc/nginx/sites-available", "/etc/nginx/conf.d", "/etc/nginx/stream.d", "/etc/nginx/streams-enabled"}
 	apacheSitesDirs = []string{"/etc/apache2/sites-enabled", "/etc/apache2/sites-available", "/etc/httpd/conf.d"}
 )
 
-// InstallForDomains installs/updates certificates for the provided domains.
+// errContainerized marks a server that owns the port from inside a container.
+var errContainerized = errors.New("web server runs in a container")
+
+// ErrNoServer is returned by Server when no supported web server is installed.
+var ErrNoServer = errors.New("no supported web server configuration directories found (nginx/apache/lighttpd)")
+
+// InstallForDomains installs/updates certificates for the provided domains, reloads
+// the server and verifies it serves the new certificate (see Deploy).
 func InstallForDomains(domains []string, certPath, keyPath string) error {
-	if len(domains) == 0 {
-		return errors.New("no domains provided")
-	}
-	// Prefer detecting a running server
-	srv, _ := detectRunningServer()
-	if srv == "nginx" {
-		for _, d := range domains {
-			if err := installNginxForDomain(d, certPath, keyPath); err != nil {
-				return err
-			}
-		}
-		ui.Success("Detected running nginx. Updated config files; reload with: sudo systemctl reload nginx")
-		return nil
-	}
-	if srv == "apache" {
-		for _, d := range domains {
-			if err := installApacheForDomain(d, certPath, keyPath); err != nil {
-				return err
-			}
+	return Deploy(domains, certPath, keyPath, DeployOptions{})
+}
+
+func fileExists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+func hasAnyDir(paths []string) bool {
+	for _, p := range paths {
+		if fi, err := os.Stat(p); err == nil && fi.IsDir() {
+			return true
 		}
-		ui.Success("Detected running apache. Updated config files; reload with: sudo systemctl reload apache2")
-		return nil
 	}
+	return false
+}
 
-	// Fallback to config directories
-	if hasAnyDir(nginxSitesDirs) {
-		for _, d := range domains {
-			if err := installNginxForDomain(d, certPath, keyPath); err != nil {
-				return err
+// installNginxForDomain points the TLS server blocks whose server_name lists domain
+// at certPath/keyPath, editing only those blocks. When the domain has an HTTP server
+// block but no TLS one in any file, a 443 block is added after the HTTP one. TLS
+// server blocks for the domain inside stream {} contexts are updated as well.
+func installNginxForDomain(cs *changeSet, domain, certPath, keyPath string, opts DeployOptions) error {
+	keyPath = nginxKeyRef(keyPath)
+	type parsed struct {
+		file  string
+		src   string
+		dirs  []*nginxDirective
+		edits []textEdit
+	}
+	var files []*parsed
+	var plain *nginxDirective // first HTTP-only server block for domain
+	var plainFile *parsed
+	tls := 0
+	for _, f := range nginxFiles() {
+		content, err := os.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		dirs, err := parseNginx(string(content))
+		if err != nil {
+			ui.Warning("Not editing %s: %v", f, err)
+			continue
+		}
+		p := &parsed{file: f, src: string(content), dirs: dirs}
+		files = append(files, p)
+		for _, srv := range nginxHTTPServers(dirs) {
+			if !srv.serves(domain) {
+				continue
+			}
+			if !srv.tls() {
+				if plain == nil {
+					plain, plainFile = srv, p
+				}
+				continue
+			}
+			tls++
+			cs.serve(domain, srv.tlsListen())
+			if e := nginxSSLEdits(p.src, srv, certPath, keyPath); len(e) > 0 {
+				p.edits = append(p.edits, e...)
+				ui.Info("Updated SSL paths of the 443 server block at %s:%d", f, srv.Line)
+			} else {
+				ui.Info("No change required for 443 server block at %s:%d", f, srv.Line)
 			}
 		}
-		ui.Success("No running server detected; updated nginx configs. Reload: sudo systemctl reload nginx")
-		return nil
 	}
-	if hasAnyDir(apacheSitesDirs) {
-		for _, d := range domains {
-			if err := installApacheForDomain(d, certPath, keyPath); err != nil {
-				return err
-			}
+	if tls > 0 && (opts.HTTP2 || opts.HTTP3) {
+		ui.Info("HTTP/2 and HTTP/3 are only set up in new 443 blocks; the existing ones for %s keep their listen directives", domain)
+	}
+	if tls == 0 && plain != nil {
+		block, err := renderVhost(nginxTemplate, Vhost{Domain: domain, ServerName: strings.Join(plain.serverNames(), " "), CertPath: certPath, KeyPath: keyPath,
+			TLSProfile: opts.TLSProfile, HTTP2: opts.HTTP2, HTTP3: opts.HTTP3})
+		if err != nil {
+			return err
 		}
-		ui.Success("No running server detected; updated apache configs. Reload: sudo systemctl reload apache2")
-		return nil
+		plainFile.edits = append(plainFile.edits, textEdit{Start: plain.End, End: plain.End, Text: "\n\n" + block})
+		cs.serve(domain, "443")
+		ui.Info("Found HTTP vhost at %s:%d for %s; adding a 443 vhost with TLS profile %s after it", plainFile.file, plain.Line, domain, opts.TLSProfile)
 	}
 
-	return errors.New("no supported web server configuration directories found (nginx/apache)")
-}
-
-// detectRunningServer tries to detect which webserver is currently running.
-// It prefers `systemctl` checks and falls back to scanning process list.
-func detectRunningServer() (string, error) {
-	// Check via systemctl if available
-	if _, err := exec.LookPath("systemctl"); err == nil {
-		// check nginx
-		if err := exec.Command("systemctl", "is-active", "--quiet", "nginx").Run(); err == nil {
-			return "nginx", nil
+	matched := tls > 0 || plain != nil
+	for _, p := range files {
+		// Non-HTTP TLS services proxied through stream {} contexts
+		e, blocks := nginxStreamEdits(p.src, p.dirs, domain, certPath, keyPath)
+		if blocks > 0 {
+			matched = true
+			cs.serve(domain, "")
+		}
+		if len(e) > 0 {
+			p.edits = append(p.edits, e...)
+			ui.Info("Updated stream server block(s) for %s in %s", domain, p.file)
 		}
-		// check apache variants
-		if err := exec.Command("systemctl", "is-active", "--quiet", "apache2").Run(); err == nil {
-			return "apache", nil
+		if len(p.edits) == 0 {
+			continue
 		}
-		if err := exec.Command("systemctl", "is-active", "--quiet", "httpd").Run(); err == nil {
-			return "apache", nil
+		if err := cs.write(p.file, []byte(applyEdits(p.src, p.edits))); err != nil {
+			return err
 		}
 	}
+	if !matched {
+		ui.Info("No nginx HTTP vhost or stream server found for %s; skipping", domain)
+	}
+	return nil
+}
 
-	// Fallback: scan process list
-	out, err := exec.Command("ps", "ax").Output()
-	if err == nil {
-		s := string(out)
-		if strings.Contains(s, "nginx: master") || strings.Contains(s, "nginx") {
-			return "nginx", nil
-		}
-		if strings.Contains(s, "apache2") || strings.Contains(s, "httpd") {
-			return "apache", nil
+// nginxSSLEdits points one TLS server block's certificate at certPath/keyPath. With
+// several certificates side by side (RSA and ECDSA) the one in certPath's directory is
+// replaced, else the first. A block inheriting its certificate from http {} gets its
+// own after server_name. Directives already set to the paths produce no edit.
+func nginxSSLEdits(src string, srv *nginxDirective, certPath, keyPath string) []textEdit {
+	certs, keys := srv.find("ssl_certificate"), srv.find("ssl_certificate_key")
+	if len(certs) == 0 {
+		anchor := srv.find("server_name")[0]
+		indent := "\n" + indentOf(src, anchor.Start)
+		text := indent + "ssl_certificate " + certPath + ";" + indent + "ssl_certificate_key " + keyPath + ";"
+		return []textEdit{{Start: anchor.End, End: anchor.End, Text: text}}
+	}
+	i := 0
+	for j, c := range certs {
+		if len(c.Args) == 1 && filepath.Dir(c.Args[0]) == filepath.Dir(certPath) {
+			i = j
+			break
 		}
 	}
-	return "", errors.New("no running web server detected")
+	var edits []textEdit
+	if e, ok := setNginxDirective(certs[i], certPath); ok {
+		edits = append(edits, e)
+	}
+	if i >= len(keys) {
+		text := "\n" + indentOf(src, certs[i].Start) + "ssl_certificate_key " + keyPath + ";"
+		return append(edits, textEdit{Start: certs[i].End, End: certs[i].End, Text: text})
+	}
+	if e, ok := setNginxDirective(keys[i], keyPath); ok {
+		edits = append(edits, e)
+	}
+	return edits
 }
 
-func hasAnyDir(paths []string) bool {
-	for _, p := range paths {
-		if fi, err := os.Stat(p); err == nil && fi.IsDir() {
-			return true
+// setNginxDirective returns the edit rewriting d to take the single argument value,
+// and false when it already does. value is written as is, so it may be quoted.
+func setNginxDirective(d *nginxDirective, value string) (textEdit, bool) {
+	if len(d.Args) == 1 && d.Args[0] == strings.Trim(value, `"`) {
+		return textEdit{}, false
+	}
+	return textEdit{Start: d.Start, End: d.End, Text: d.Name + " " + value + ";"}, true
+}
+
+var (
+	reNginxServerName  = regexp.MustCompile(`(?m)^[ \t]*server_name[ \t]+([^;]+);`)
+	reApacheServerName = regexp.MustCompile(`(?mi)^[ \t]*Server(?:Name|Alias)[ \t]+(.+)$`)
+)
+
+// nameMatches reports whether a server_name, ServerName or ServerAlias entry selects
+// domain. Names compare case-insensitively and a wildcard only matches the same
+// wildcard, so *.example.com does not pick up a vhost for www.example.com. nginx's
+// ".example.com" covers both example.com and *.example.com.
+func nameMatches(entry, domain string) bool {
+	entry = strings.ToLower(strings.TrimSpace(entry))
+	domain = strings.ToLower(domain)
+	if h, _, ok := strings.Cut(entry, ":"); ok {
+		entry = h // Apache ServerName may carry a port
+	}
+	if strings.HasPrefix(entry, ".") {
+		return domain == entry[1:] || domain == "*"+entry
+	}
+	return entry == domain
+}
+
+// servesName reports whether a server name directive matched by re lists domain.
+func servesName(re *regexp.Regexp, content, domain string) bool {
+	return serverNameLine(re, content, domain) != ""
+}
+
+// serverNameLine returns the names of the first directive matched by re that lists domain.
+func serverNameLine(re *regexp.Regexp, content, domain string) string {
+	for _, m := range re.FindAllStringSubmatch(content, -1) {
+		for _, name := range strings.Fields(m[1]) {
+			if nameMatches(name, domain) {
+				return strings.TrimSpace(m[1])
+			}
 		}
 	}
-	return false
+	return ""
 }
 
-// installNginxForDomain finds the 80 vhost file containing the domain and creates/updates 443 vhost.
-func installNginxForDomain(domain, certPath, keyPath string) error {
-	files := collectFiles(nginxSitesDirs)
-	matched := false
+// VhostNames returns the names srv's vhost files serve (server_name, ServerName and
+// ServerAlias), in file order without duplicates. Catch-alls, wildcards and
+// localhost are left out: they cannot be requested as they are.
+func VhostNames(srv string) []string {
+	re, files := reNginxServerName, nginxFiles()
+	if srv == "apache" {
+		re, files = reApacheServerName, apacheFiles()
+	}
+	seen := map[string]bool{}
+	var names []string
 	for _, f := range files {
 		content, err := os.ReadFile(f)
 		if err != nil {
 			continue
 		}
-		s := string(content)
-		if strings.Contains(s, "listen 80") && strings.Contains(s, domain) {
-			matched = true
-			fmt.Printf("Found HTTP vhost in %s for %s\n", f, domain)
-			if strings.Contains(s, "listen 443") && strings.Contains(s, domain) {
-				// Update existing ssl_certificate lines
-				new := updateNginxSSL(s, certPath, keyPath, domain)
-				if new == s {
-					fmt.Printf("No change required for 443 vhost in %s\n", f)
-				} else {
-					if err := backupAndWriteFile(f, []byte(new)); err != nil {
-						return err
-					}
-					fmt.Printf("Updated 443 vhost SSL paths in %s\n", f)
+		for _, m := range re.FindAllStringSubmatch(string(content), -1) {
+			for _, name := range strings.Fields(m[1]) {
+				name = strings.ToLower(strings.Trim(name, `"'`))
+				if h, _, ok := strings.Cut(name, ":"); ok {
+					name = h
 				}
-			} else {
-				// Create new 443 server block for this domain
-				serverName := extractNginxServerName(s, domain)
-				block := buildNginx443Block(serverName, certPath, keyPath)
-				new := s + "\n\n" + block + "\n"
-				if err := backupAndWriteFile(f, []byte(new)); err != nil {
-					return err
+				name = strings.TrimPrefix(name, ".")
+				if name == "" || name == "_" || name == "localhost" || strings.ContainsAny(name, "*~$") || !strings.Contains(name, ".") || seen[name] {
+					continue
 				}
-				fmt.Printf("Appended new 443 vhost for %s into %s\n", domain, f)
+				seen[name] = true
+				names = append(names, name)
 			}
 		}
 	}
-	if !matched {
-		fmt.Printf("No nginx HTTP vhost found for %s; skipping\n", domain)
-	}
-	return nil
-}
-
-func updateNginxSSL(content, certPath, keyPath, domain string) string {
-	// Replace ssl_certificate and ssl_certificate_key for blocks containing domain
-	reCert := regexp.MustCompile(`(?m)^\s*ssl_certificate\s+\S+;`)
-	reKey := regexp.MustCompile(`(?m)^\s*ssl_certificate_key\s+\S+;`)
-	new := reCert.ReplaceAllString(content, fmt.Sprintf("    ssl_certificate %s;", certPath))
-	new = reKey.ReplaceAllString(new, fmt.Sprintf("    ssl_certificate_key %s;", keyPath))
-	return new
+	return names
 }
 
-func extractNginxServerName(content, domain string) string {
-	// Try to extract server_name line containing the domain; fall back to domain
-	scanner := bufio.NewScanner(strings.NewReader(content))
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.Contains(line, "server_name") && strings.Contains(line, domain) {
-			// return the value portion
-			parts := strings.Fields(line)
-			if len(parts) >= 2 {
-				return strings.Join(parts[1:], " ")
-			}
-		}
+// nginxKeyRef returns how nginx refers to the key at keyPath: the file itself, the
+// TSS2 key file of a TPM key (loaded by the OpenSSL tpm2 provider), or for a key
+// held in an HSM its PKCS#11 URI through the OpenSSL pkcs11 engine, quoted because
+// the URI contains ';'. The PIN and module path stay out of the config.
+func nginxKeyRef(keyPath string) string {
+	if file, ok := keystore.TPMKeyFile(keyPath); ok {
+		return file
 	}
-	return domain
+	if !keystore.IsURI(keyPath) {
+		return keyPath
+	}
+	return `"engine:pkcs11:` + keystore.Public(keyPath) + `"`
 }
 
-func buildNginx443Block(serverName, certPath, keyPath string) string {
-	return fmt.Sprintf(`server {
-	listen 443 ssl;
-	server_name %s;
-	ssl_certificate %s;
-	ssl_certificate_key %s;
-	# proxy/serve static content as appropriate
-}
-`, serverName, certPath, keyPath)
+// apacheKeyRef is nginxKeyRef for mod_ssl, which loads PKCS#11 URIs directly.
+func apacheKeyRef(keyPath string) string {
+	if file, ok := keystore.TPMKeyFile(keyPath); ok {
+		return file
+	}
+	if !keystore.IsURI(keyPath) {
+		return keyPath
+	}
+	return `"` + keystore.Public(keyPath) + `"`
 }
 
-// installApacheForDomain performs similar operations for Apache vhost files.
-func installApacheForDomain(domain, certPath, keyPath string) error {
-	files := collectFiles(apacheSitesDirs)
-	matched := false
-	for _, f := range files {
+// installApacheForDomain is installNginxForDomain for Apache: it parses the config
+// tree from the main config through its Include directives and edits the
+// <VirtualHost> sections whose ServerName or ServerAlias lists domain, including those
+// inside <IfModule>.
+func installApacheForDomain(cs *changeSet, domain, certPath, keyPath string, opts DeployOptions) error {
+	keyPath = apacheKeyRef(keyPath)
+	type parsed struct {
+		file  string
+		src   string
+		edits []textEdit
+	}
+	var files []*parsed
+	var plain *apacheDirective // first HTTP-only vhost for domain
+	var plainFile *parsed
+	tls := 0
+	for _, f := range apacheFiles() {
 		content, err := os.ReadFile(f)
 		if err != nil {
 			continue
 		}
-		s := string(content)
-		if (strings.Contains(s, "<VirtualHost") && strings.Contains(s, ":80")) && (strings.Contains(s, "ServerName "+domain) || strings.Contains(s, "ServerAlias "+domain)) {
-			matched = true
-			fmt.Printf("Found HTTP vhost in %s for %s\n", f, domain)
-			if strings.Contains(s, ":443") {
-				new := updateApacheSSL(s, certPath, keyPath, domain)
-				if new == s {
-					fmt.Printf("No change required for 443 vhost in %s\n", f)
-				} else {
-					if err := backupAndWriteFile(f, []byte(new)); err != nil {
-						return err
-					}
-					fmt.Printf("Updated 443 vhost SSL paths in %s\n", f)
+		dirs, err := parseApache(string(content))
+		if err != nil {
+			ui.Warning("Not editing %s: %v", f, err)
+			continue
+		}
+		p := &parsed{file: f, src: string(content)}
+		files = append(files, p)
+		for _, vh := range apacheVhosts(dirs) {
+			if !vh.serves(domain) {
+				continue
+			}
+			if !vh.tls() {
+				if plain == nil {
+					plain, plainFile = vh, p
 				}
+				continue
+			}
+			tls++
+			cs.serve(domain, vh.tlsListen())
+			if e := apacheSSLEdits(p.src, vh, certPath, keyPath); len(e) > 0 {
+				p.edits = append(p.edits, e...)
+				ui.Info("Updated SSL paths of the 443 vhost at %s:%d", f, vh.Line)
 			} else {
-				// Append new 443 VirtualHost
-				serverName := extractApacheServerName(s, domain)
-				block := buildApache443Block(serverName, certPath, keyPath)
-				new := s + "\n\n" + block + "\n"
-				if err := backupAndWriteFile(f, []byte(new)); err != nil {
-					return err
-				}
-				fmt.Printf("Appended new 443 vhost for %s into %s\n", domain, f)
+				ui.Info("No change required for 443 vhost at %s:%d", f, vh.Line)
 			}
 		}
 	}
-	if !matched {
-		fmt.Printf("No apache HTTP vhost found for %s; skipping\n", domain)
+	if tls > 0 && opts.HTTP2 {
+		ui.Info("HTTP/2 is only set up in new 443 vhosts; the existing ones for %s keep their Protocols", domain)
+	}
+	if tls == 0 && plain != nil {
+		block, err := renderVhost(apacheTemplate, Vhost{Domain: domain, ServerName: apacheServerName(plain, domain), CertPath: certPath, KeyPath: keyPath,
+			TLSProfile: opts.TLSProfile, HTTP2: opts.HTTP2, HTTP3: opts.HTTP3})
+		if err != nil {
+			return err
+		}
+		plainFile.edits = append(plainFile.edits, textEdit{Start: plain.End, End: plain.End, Text: "\n\n" + block})
+		cs.serve(domain, "443")
+		ui.Info("Found HTTP vhost at %s:%d for %s; adding a 443 vhost with TLS profile %s after it", plainFile.file, plain.Line, domain, opts.TLSProfile)
+	}
+	if tls == 0 && plain == nil {
+		ui.Info("No apache HTTP vhost found for %s; skipping", domain)
+	}
+	for _, p := range files {
+		if len(p.edits) == 0 {
+			continue
+		}
+		if err := cs.write(p.file, []byte(applyEdits(p.src, p.edits))); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-func updateApacheSSL(content, certPath, keyPath, domain string) string {
-	// Replace SSLCertificateFile and SSLCertificateKeyFile occurrences
-	reCert := regexp.MustCompile(`(?m)^\s*SSLCertificateFile\s+\S+`)
-	reKey := regexp.MustCompile(`(?m)^\s*SSLCertificateKeyFile\s+\S+`)
-	new := reCert.ReplaceAllString(content, fmt.Sprintf("    SSLCertificateFile %s", certPath))
-	new = reKey.ReplaceAllString(new, fmt.Sprintf("    SSLCertificateKeyFile %s", keyPath))
-	return new
+// apacheSSLEdits is nginxSSLEdits for a TLS <VirtualHost>: SSLCertificateFile and
+// SSLCertificateKeyFile are pointed at certPath/keyPath, or added after ServerName.
+func apacheSSLEdits(src string, vh *apacheDirective, certPath, keyPath string) []textEdit {
+	certs, keys := vh.find("SSLCertificateFile"), vh.find("SSLCertificateKeyFile")
+	if len(certs) == 0 {
+		anchor := vh.find("ServerName")
+		if len(anchor) == 0 {
+			anchor = vh.find("ServerAlias")
+		}
+		indent := "\n" + indentOf(src, anchor[0].Start)
+		text := indent + "SSLCertificateFile " + certPath + indent + "SSLCertificateKeyFile " + keyPath
+		return []textEdit{{Start: anchor[0].End, End: anchor[0].End, Text: text}}
+	}
+	i := 0
+	for j, c := range certs {
+		if len(c.Args) == 1 && filepath.Dir(c.Args[0]) == filepath.Dir(certPath) {
+			i = j
+			break
+		}
+	}
+	var edits []textEdit
+	if e, ok := setApacheDirective(certs[i], certPath); ok {
+		edits = append(edits, e)
+	}
+	if i >= len(keys) {
+		text := "\n" + indentOf(src, certs[i].Start) + "SSLCertificateKeyFile " + keyPath
+		return append(edits, textEdit{Start: certs[i].End, End: certs[i].End, Text: text})
+	}
+	if e, ok := setApacheDirective(keys[i], keyPath); ok {
+		edits = append(edits, e)
+	}
+	return edits
 }
 
-func extractApacheServerName(content, domain string) string {
-	scanner := bufio.NewScanner(strings.NewReader(content))
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if strings.HasPrefix(line, "ServerName ") && strings.Contains(line, domain) {
-			parts := strings.Fields(line)
-			if len(parts) >= 2 {
-				return parts[1]
-			}
-		}
+// setApacheDirective is setNginxDirective without the terminating ';'.
+func setApacheDirective(d *apacheDirective, value string) (textEdit, bool) {
+	if len(d.Args) == 1 && d.Args[0] == strings.Trim(value, `"`) {
+		return textEdit{}, false
 	}
-	return domain
+	return textEdit{Start: d.Start, End: d.End, Text: d.Name + " " + value}, true
 }
 
-func buildApache443Block(serverName, certPath, keyPath string) string {
-	return fmt.Sprintf(`<VirtualHost *:443>
-	ServerName %s
-	SSLEngine on
-	SSLCertificateFile %s
-	SSLCertificateKeyFile %s
-	# DocumentRoot /var/www/html
-</VirtualHost>
-`, serverName, certPath, keyPath)
+// apacheServerName returns the ServerName for a new 443 vhost: the HTTP vhost's own
+// when it is the domain, else domain. ServerName takes a single name.
+func apacheServerName(vh *apacheDirective, domain string) string {
+	for _, sn := range vh.find("ServerName") {
+		if len(sn.Args) == 1 && nameMatches(sn.Args[0], domain) {
+			name, _, _ := strings.Cut(sn.Args[0], ":")
+			return name
+		}
+	}
+	return domain
 }
 
 func collectFiles(dirs []string) []string {
@@ -288,17 +424,57 @@ func collectFiles(dirs []string) []string {
 	return out
 }
 
-func backupAndWriteFile(path string, data []byte) error {
-	// create backup
-	bak := fmt.Sprintf("%s.bak.%d", path, time.Now().Unix())
+// backupAndWriteFile writes data to path atomically after copying the current
+// content to a new backup under BackupsDir (see backupPath), and returns the backup's
+// path. Older backups of path beyond the retention policy are removed.
+func backupAndWriteFile(path string, data []byte) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	t := time.Now()
+	bak := backupPath(abs, t)
+	for {
+		// Two runs within a second must not share a backup
+		if _, err := os.Lstat(bak); errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		t = t.Add(time.Second)
+		bak = backupPath(abs, t)
+	}
+	if err := os.MkdirAll(filepath.Dir(bak), 0700); err != nil {
+		return "", fmt.Errorf("backup failed: %w", err)
+	}
 	if err := copyFile(path, bak); err != nil {
-		return fmt.Errorf("backup failed: %w", err)
+		return "", fmt.Errorf("backup failed: %w", err)
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return bak, err
+	}
+	pruneFile(abs)
+	return bak, nil
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	// Replace the target of a symlink (sites-enabled -> sites-available), not the link
+	if real, err := filepath.EvalSymlinks(path); err == nil {
+		path = real
+	}
+	// write to temp and rename, keeping the mode and owner of the file replaced
+	mode := os.FileMode(0644)
+	fi, statErr := os.Stat(path)
+	if statErr == nil {
+		mode = fi.Mode().Perm()
 	}
-	// write to temp and rename
 	tmp := path + ".tmp"
-	if err := os.WriteFile(tmp, data, 0644); err != nil {
+	if err := os.WriteFile(tmp, data, mode); err != nil {
 		return err
 	}
+	if statErr == nil {
+		// WriteFile's mode is filtered through the umask
+		os.Chmod(tmp, mode)
+		keepOwner(tmp, fi)
+	}
 	return os.Rename(tmp, path)
 }
 
@@ -0,0 +1,107 @@
+package install
+
+import (
+	"bufio"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// distroLayout lists where a distribution keeps web server vhost files and what
+// its Apache service is called.
+type distroLayout struct {
+	NginxDirs     []string
+	ApacheDirs    []string
+	ApacheService string
+}
+
+var distroLayouts = map[string]distroLayout{
+	"debian": {
+		NginxDirs:     []string{"/etc/nginx/sites-enabled", "/etc/nginx/conf.d"},
+		ApacheDirs:    []string{"/etc/apache2/sites-enabled"},
+		ApacheService: "apache2",
+	},
+	// RHEL, CentOS, Fedora, Rocky, Alma: ssl.conf in conf.d carries the default 443 vhost
+	"rhel": {
+		NginxDirs:     []string{"/etc/nginx/conf.d", "/etc/nginx/default.d"},
+		ApacheDirs:    []string{"/etc/httpd/conf.d"},
+		ApacheService: "httpd",
+	},
+	"suse": {
+		NginxDirs:     []string{"/etc/nginx/vhosts.d", "/etc/nginx/conf.d"},
+		ApacheDirs:    []string{"/etc/apache2/vhosts.d"},
+		ApacheService: "apache2",
+	},
+	"alpine": {
+		NginxDirs:     []string{"/etc/nginx/http.d", "/etc/nginx/conf.d"},
+		ApacheDirs:    []string{"/etc/apache2/conf.d"},
+		ApacheService: "apache2",
+	},
+	"freebsd": {
+		NginxDirs:     []string{"/usr/local/etc/nginx/conf.d", "/usr/local/etc/nginx/sites-enabled", "/usr/local/etc/nginx"},
+		ApacheDirs:    []string{"/usr/local/etc/apache24/Includes", "/usr/local/etc/apache24/extra"},
+		ApacheService: "apache24",
+	},
+}
+
+// distro is the detected layout family, empty when unknown.
+var distro = detectDistro()
+
+func init() {
+	if l, ok := distroLayouts[distro]; ok {
+		nginxSitesDirs = append(append([]string{}, l.NginxDirs...), nginxSitesDirs...)
+		apacheSitesDirs = append(append([]string{}, l.ApacheDirs...), apacheSitesDirs...)
+	}
+}
+
+// detectDistro maps /etc/os-release ID and ID_LIKE onto a distroLayouts key.
+func detectDistro() string {
+	if runtime.GOOS == "freebsd" {
+		return "freebsd"
+	}
+	f, err := os.Open("/etc/os-release")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	var ids []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		k, v, ok := strings.Cut(sc.Text(), "=")
+		if ok && (k == "ID" || k == "ID_LIKE") {
+			ids = append(ids, strings.Fields(strings.Trim(v, `"'`))...)
+		}
+	}
+	for _, id := range ids {
+		switch id {
+		case "debian", "ubuntu":
+			return "debian"
+		case "rhel", "centos", "fedora", "rocky", "almalinux", "amzn":
+			return "rhel"
+		case "suse", "opensuse", "sles", "opensuse-leap", "opensuse-tumbleweed":
+			return "suse"
+		case "alpine":
+			return "alpine"
+		}
+	}
+	return ""
+}
+
+// apacheService returns the Apache service name on this host.
+func apacheService() string {
+	if l, ok := distroLayouts[distro]; ok {
+		return l.ApacheService
+	}
+	return "apache2"
+}
+
+// configDirOverride is set by SetConfigDir; when non-empty only that directory is searched.
+var configDirOverride string
+
+// SetConfigDir restricts vhost discovery (and backup listing) to dir, bypassing
+// distro detection and nginx -T discovery.
+func SetConfigDir(dir string) {
+	configDirOverride = dir
+	nginxSitesDirs = []string{dir}
+	apacheSitesDirs = []string{dir}
+}
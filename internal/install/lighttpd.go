@@ -0,0 +1,267 @@
+package install
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/trustctl/trustctl/internal/keystore"
+	"github.com/trustctl/trustctl/internal/ui"
+)
+
+// lighttpdConfDirs hold lighttpd.conf, with conf-enabled/ beside it on Debian.
+var lighttpdConfDirs = []string{"/etc/lighttpd", "/usr/local/etc/lighttpd"}
+
+// lighttpdAddedConf is the file new host conditionals go to when conf-enabled/ exists;
+// otherwise they are appended to lighttpd.conf.
+const lighttpdAddedConf = "90-trustctl.conf"
+
+// lighttpdMainConf returns the first lighttpd.conf in lighttpdConfDirs, or "".
+func lighttpdMainConf() string {
+	dirs := lighttpdConfDirs
+	if configDirOverride != "" {
+		dirs = []string{configDirOverride}
+	}
+	for _, d := range dirs {
+		if p := filepath.Join(d, "lighttpd.conf"); fileExists(p) {
+			return p
+		}
+	}
+	return ""
+}
+
+// lighttpdFiles returns lighttpd.conf, the files it includes and those in
+// conf-enabled/, which Debian's include_shell script pulls in.
+func lighttpdFiles() []string {
+	conf := lighttpdMainConf()
+	if conf == "" {
+		return nil
+	}
+	dir := filepath.Dir(conf)
+	files := []string{conf}
+	seen := map[string]bool{}
+	var include func(path string)
+	include = func(path string) {
+		if seen[path] {
+			return
+		}
+		seen[path] = true
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return
+		}
+		cfg, err := parseLighttpd(string(content))
+		if err != nil {
+			return
+		}
+		for _, inc := range cfg.Includes {
+			if !filepath.IsAbs(inc) {
+				inc = filepath.Join(dir, inc)
+			}
+			matches, _ := filepath.Glob(inc)
+			for _, m := range matches {
+				files = append(files, m)
+				include(m)
+			}
+		}
+	}
+	include(conf)
+	files = append(files, collectFiles([]string{filepath.Join(dir, "conf-enabled")})...)
+	var out []string
+	for _, f := range uniqueFiles(files) {
+		if f == conf || strings.HasSuffix(f, ".conf") {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// lighttpdHostMatches reports whether a $HTTP["host"] condition selects domain.
+func lighttpdHostMatches(op, value, domain string) bool {
+	value = strings.ToLower(value)
+	domain = strings.ToLower(domain)
+	switch op {
+	case "==":
+		if h, _, ok := strings.Cut(value, ":"); ok {
+			value = h
+		}
+		return value == domain
+	case "=~":
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return false
+		}
+		if strings.HasPrefix(domain, "*.") {
+			return re.MatchString("trustctl-verify" + domain[1:])
+		}
+		return re.MatchString(domain)
+	case "=^":
+		return strings.HasPrefix(domain, value)
+	case "=$":
+		return strings.HasSuffix(domain, value)
+	}
+	return false
+}
+
+// lighttpdHostCond returns the $HTTP["host"] condition of a new block for domain.
+// lighttpd strings only unescape \", so the regex goes in as written.
+func lighttpdHostCond(domain string) string {
+	if strings.HasPrefix(domain, "*.") {
+		return `$HTTP["host"] =~ "^[^.]+` + regexp.QuoteMeta(domain[1:]) + `$"`
+	}
+	return `$HTTP["host"] == "` + domain + `"`
+}
+
+// installLighttpdForDomain points the $HTTP["host"] conditionals that select domain
+// at certPath/keyPath through ssl.pemfile and ssl.privkey, editing only those blocks.
+// Without one a conditional is added, to conf-enabled/90-trustctl.conf when that
+// directory exists, and when no socket has TLS enabled a 443 socket block rendered
+// from the lighttpd-443.conf.tmpl template comes with it.
+func installLighttpdForDomain(cs *changeSet, domain, certPath, keyPath string, opts DeployOptions) error {
+	if keystore.IsURI(keyPath) {
+		return errors.New("lighttpd needs the private key in a file, not a key URI")
+	}
+	files := lighttpdFiles()
+	if len(files) == 0 {
+		return errors.New("no lighttpd.conf found")
+	}
+	matched, tlsEnabled, openssl := 0, false, false
+	for _, f := range files {
+		content, err := os.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		src := string(content)
+		cfg, err := parseLighttpd(src)
+		if err != nil {
+			ui.Warning("Not editing %s: %v", f, err)
+			continue
+		}
+		openssl = openssl || strings.Contains(src, "mod_openssl")
+		check := func(assigns []*lighttpdAssign) {
+			for _, a := range assigns {
+				if a.Key == "ssl.engine" && a.Value == "enable" {
+					tlsEnabled = true
+				}
+			}
+		}
+		check(cfg.Assigns)
+		var edits []textEdit
+		walkLighttpd(cfg.Conds, "443", func(c *lighttpdCond, socket string) {
+			check(c.Assigns)
+			if c.Field != `$HTTP["host"]` || !lighttpdHostMatches(c.Op, c.Value, domain) {
+				return
+			}
+			matched++
+			cs.serve(domain, socket)
+			e := lighttpdSSLEdits(src, c, certPath, keyPath)
+			if len(e) == 0 {
+				ui.Info("No change required for %s %s \"%s\" at %s:%d", c.Field, c.Op, c.Value, f, c.Line)
+				return
+			}
+			edits = append(edits, e...)
+			ui.Info("Updated SSL paths of %s %s \"%s\" at %s:%d", c.Field, c.Op, c.Value, f, c.Line)
+		})
+		if len(edits) > 0 {
+			if err := cs.write(f, []byte(applyEdits(src, edits))); err != nil {
+				return err
+			}
+		}
+	}
+	if matched > 0 {
+		return nil
+	}
+
+	target := files[0]
+	if dir := filepath.Join(filepath.Dir(files[0]), "conf-enabled"); isDir(dir) {
+		target = filepath.Join(dir, lighttpdAddedConf)
+	}
+	var block strings.Builder
+	if !tlsEnabled {
+		if !openssl {
+			block.WriteString("server.modules += ( \"mod_openssl\" )\n\n")
+		}
+		socket, err := renderVhost(lighttpdTemplate, Vhost{Domain: domain, ServerName: domain, CertPath: certPath, KeyPath: keyPath, TLSProfile: opts.TLSProfile})
+		if err != nil {
+			return err
+		}
+		block.WriteString(socket + "\n")
+		ui.Info("No lighttpd socket has TLS enabled; adding one on :443 with TLS profile %s to %s", opts.TLSProfile, target)
+	}
+	block.WriteString(lighttpdHostCond(domain) + " {\n\tssl.pemfile = \"" + certPath + "\"\n\tssl.privkey = \"" + keyPath + "\"\n}\n")
+	content, err := os.ReadFile(target)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	src := string(content)
+	if src != "" {
+		if !strings.HasSuffix(src, "\n") {
+			src += "\n"
+		}
+		src += "\n"
+	}
+	if err := cs.write(target, []byte(src+block.String())); err != nil {
+		return err
+	}
+	cs.serve(domain, "443")
+	ui.Info("No $HTTP[\"host\"] conditional selects %s; added one to %s", domain, target)
+	return nil
+}
+
+// lighttpdSSLEdits sets ssl.pemfile and ssl.privkey of the conditional c, adding the
+// options that are missing at the end of the block.
+func lighttpdSSLEdits(src string, c *lighttpdCond, certPath, keyPath string) []textEdit {
+	var edits []textEdit
+	var missing []string
+	for _, opt := range [][2]string{{"ssl.pemfile", certPath}, {"ssl.privkey", keyPath}} {
+		a := c.assign(opt[0])
+		switch {
+		case a == nil || a.ValEnd == 0:
+			// Absent, or computed from variables: set it after the block's own options
+			missing = append(missing, opt[0]+" = \""+opt[1]+"\"")
+		case a.Value != opt[1]:
+			edits = append(edits, textEdit{Start: a.ValStart, End: a.ValEnd, Text: opt[1]})
+		}
+	}
+	if len(missing) > 0 {
+		indent := "\t"
+		if len(c.Assigns) > 0 {
+			indent = indentOf(src, c.Assigns[0].Start)
+		}
+		at := c.Close
+		for at > c.Open && (src[at-1] == ' ' || src[at-1] == '\t') {
+			at--
+		}
+		text := ""
+		if !strings.HasSuffix(src[:at], "\n") {
+			text = "\n"
+		}
+		for _, m := range missing {
+			text += indent + m + "\n"
+		}
+		edits = append(edits, textEdit{Start: at, End: at, Text: text})
+	}
+	return edits
+}
+
+// lighttpdConfigTest runs lighttpd's syntax and module check.
+func lighttpdConfigTest() ([]byte, error) {
+	args := []string{"-tt"}
+	if conf := lighttpdMainConf(); conf != "" {
+		args = append(args, "-f", conf)
+	}
+	return exec.Command("lighttpd", args...).CombinedOutput()
+}
+
+// reloadLighttpd signals lighttpd to restart gracefully, finishing the connections it
+// has; used where no systemd unit can be reloaded.
+func reloadLighttpd() ([]byte, error) {
+	out, err := exec.Command("pgrep", "-o", "-x", "lighttpd").Output()
+	if err != nil {
+		return nil, errors.New("no lighttpd process found")
+	}
+	return exec.Command("kill", "-USR1", strings.TrimSpace(string(out))).CombinedOutput()
+}
@@ -0,0 +1,240 @@
+package install
+
+import (
+	"fmt"
+	"strings"
+)
+
+// lighttpdCond is a conditional block of lighttpd.conf, such as
+// $HTTP["host"] == "example.com" { ... }, with the offsets needed to edit it in place.
+type lighttpdCond struct {
+	Field    string // $HTTP["host"]; "else" for a plain else block
+	Op       string // ==, !=, =~, !~, =^ or =$
+	Value    string
+	Open     int // offset just past '{'
+	Close    int // offset of '}'
+	Line     int
+	Assigns  []*lighttpdAssign
+	Children []*lighttpdCond
+}
+
+// lighttpdAssign is an option assignment. Value, ValStart and ValEnd are set when the
+// value is a single string; ValStart and ValEnd delimit its content inside the quotes.
+type lighttpdAssign struct {
+	Key              string
+	Op               string // =, += or :=
+	Value            string
+	Start            int // offset of the key
+	ValStart, ValEnd int
+	Line             int
+}
+
+// lighttpdConfig is a parsed lighttpd configuration file: the assignments and blocks
+// at its top level and the files its include statements name.
+type lighttpdConfig struct {
+	Assigns  []*lighttpdAssign
+	Conds    []*lighttpdCond
+	Includes []string
+}
+
+type lighttpdToken struct {
+	Kind       byte // 's' string, 'f' field such as $HTTP["host"], 'o' operator, 'w' word
+	Text       string
+	Start, End int // a string's content
+	Line       int
+}
+
+var lighttpdOps = []string{"==", "!=", "=~", "!~", "=^", "=$", "+=", ":=", "=>"}
+
+// lighttpdTokens splits src into tokens, dropping comments.
+func lighttpdTokens(src string) ([]lighttpdToken, error) {
+	var out []lighttpdToken
+	line := 1
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '#':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case c == '"':
+			start, l := i+1, line
+			i++
+			for i < len(src) && src[i] != '"' {
+				if src[i] == '\\' {
+					i++
+				} else if src[i] == '\n' {
+					line++
+				}
+				i++
+			}
+			if i >= len(src) {
+				return nil, fmt.Errorf("line %d: unterminated string", l)
+			}
+			text := strings.ReplaceAll(src[start:i], `\"`, `"`)
+			out = append(out, lighttpdToken{Kind: 's', Text: text, Start: start, End: i, Line: l})
+			i++
+		case c == '$':
+			start := i
+			i++
+			for i < len(src) && (isLetter(src[i]) || src[i] == '_') {
+				i++
+			}
+			if i < len(src) && src[i] == '[' {
+				end := strings.IndexByte(src[i:], ']')
+				if end < 0 {
+					return nil, fmt.Errorf("line %d: unterminated %s[", line, src[start:i])
+				}
+				i += end + 1
+			}
+			out = append(out, lighttpdToken{Kind: 'f', Text: src[start:i], Start: start, End: i, Line: line})
+		case strings.IndexByte("{}(),", c) >= 0:
+			out = append(out, lighttpdToken{Kind: 'o', Text: string(c), Start: i, End: i + 1, Line: line})
+			i++
+		case strings.IndexByte("=!~^+:>", c) >= 0:
+			n := 1
+			for _, op := range lighttpdOps {
+				if strings.HasPrefix(src[i:], op) {
+					n = 2
+					break
+				}
+			}
+			out = append(out, lighttpdToken{Kind: 'o', Text: src[i : i+n], Start: i, End: i + n, Line: line})
+			i += n
+		default:
+			start := i
+			for i < len(src) && strings.IndexByte(" \t\r\n#\"{}(),=!~^+:>", src[i]) < 0 {
+				i++
+			}
+			out = append(out, lighttpdToken{Kind: 'w', Text: src[start:i], Start: start, End: i, Line: line})
+		}
+	}
+	return out, nil
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// parseLighttpd parses the assignments, conditional blocks and includes of src.
+func parseLighttpd(src string) (*lighttpdConfig, error) {
+	toks, err := lighttpdTokens(src)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &lighttpdConfig{}
+	var stack []*lighttpdCond
+	addAssign := func(a *lighttpdAssign) {
+		if len(stack) == 0 {
+			cfg.Assigns = append(cfg.Assigns, a)
+		} else {
+			stack[len(stack)-1].Assigns = append(stack[len(stack)-1].Assigns, a)
+		}
+	}
+	open := func(c *lighttpdCond) {
+		if len(stack) == 0 {
+			cfg.Conds = append(cfg.Conds, c)
+		} else {
+			parent := stack[len(stack)-1]
+			parent.Children = append(parent.Children, c)
+		}
+		stack = append(stack, c)
+	}
+	at := func(i int) lighttpdToken {
+		if i < len(toks) {
+			return toks[i]
+		}
+		return lighttpdToken{}
+	}
+	for i := 0; i < len(toks); i++ {
+		t := toks[i]
+		switch {
+		case t.Kind == 'f' && at(i+1).Kind == 'o' && at(i+2).Kind == 's' && at(i+3).Text == "{":
+			open(&lighttpdCond{Field: t.Text, Op: at(i + 1).Text, Value: at(i + 2).Text, Open: at(i + 3).End, Line: t.Line})
+			i += 3
+		case t.Kind == 'w' && t.Text == "else" && at(i+1).Text == "{":
+			open(&lighttpdCond{Field: "else", Open: at(i + 1).End, Line: t.Line})
+			i++
+		case t.Kind == 'o' && t.Text == "}":
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("line %d: unexpected }", t.Line)
+			}
+			stack[len(stack)-1].Close = t.Start
+			stack = stack[:len(stack)-1]
+		case t.Kind == 'w' && (t.Text == "include" || t.Text == "include_shell") && at(i+1).Kind == 's':
+			// include_shell runs a command; Debian's lists conf-enabled/, read directly
+			if t.Text == "include" {
+				cfg.Includes = append(cfg.Includes, at(i+1).Text)
+			}
+			i++
+		case t.Kind == 'w' && (at(i+1).Text == "=" || at(i+1).Text == "+=" || at(i+1).Text == ":="):
+			a := &lighttpdAssign{Key: t.Text, Op: at(i + 1).Text, Start: t.Start, Line: t.Line}
+			i += 2
+			end := lighttpdValueEnd(toks, i)
+			if end == i+1 && at(i).Kind == 's' {
+				a.Value, a.ValStart, a.ValEnd = at(i).Text, at(i).Start, at(i).End
+			}
+			addAssign(a)
+			i = end - 1
+		}
+	}
+	if len(stack) > 0 {
+		return nil, fmt.Errorf("line %d: %s block not closed", stack[len(stack)-1].Line, stack[len(stack)-1].Field)
+	}
+	return cfg, nil
+}
+
+// lighttpdValueEnd returns the index just past the value expression at toks[i]: values
+// joined with +, each a string, word or parenthesized list.
+func lighttpdValueEnd(toks []lighttpdToken, i int) int {
+	for i < len(toks) {
+		if toks[i].Text == "(" {
+			depth := 0
+			for ; i < len(toks); i++ {
+				if toks[i].Text == "(" {
+					depth++
+				} else if toks[i].Text == ")" {
+					if depth--; depth == 0 {
+						break
+					}
+				}
+			}
+		}
+		i++
+		if i >= len(toks) || toks[i].Text != "+" {
+			return i
+		}
+		i++
+	}
+	return i
+}
+
+// assign returns the last assignment of key directly in c.
+func (c *lighttpdCond) assign(key string) *lighttpdAssign {
+	var found *lighttpdAssign
+	for _, a := range c.Assigns {
+		if a.Key == key {
+			found = a
+		}
+	}
+	return found
+}
+
+// walkLighttpd calls fn for every block in conds and their children, depth first,
+// with the address of the innermost $SERVER["socket"] == conditional around it, or
+// socket outside of them.
+func walkLighttpd(conds []*lighttpdCond, socket string, fn func(c *lighttpdCond, socket string)) {
+	for _, c := range conds {
+		fn(c, socket)
+		inner := socket
+		if c.Field == `$SERVER["socket"]` && c.Op == "==" {
+			inner = c.Value
+		}
+		walkLighttpd(c.Children, inner, fn)
+	}
+}
@@ -0,0 +1,63 @@
+package install
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/trustctl/trustctl/internal/ui"
+)
+
+// nginxListen returns the listen directives of a new 443 server block, with those
+// enabling HTTP/2 and HTTP/3 when asked for. The syntax follows the installed nginx:
+// from 1.25.1 HTTP/2 is turned on with `http2 on;` rather than a listen parameter,
+// and HTTP/3 needs 1.25.0 built with the http_v3 module. Without a binary to ask,
+// HTTP/2 uses the listen parameter, which later versions still accept.
+func nginxListen(http2, http3 bool) (string, error) {
+	b := lookupNginx()
+	known := b != nil && b.Version != nginxVersion{}
+	if http2 && known && b.Args != "" && !strings.Contains(b.Args, "--with-http_v2_module") {
+		return "", fmt.Errorf("%s (nginx %s) is not built with --with-http_v2_module", b.Binary, b.Version)
+	}
+	if http3 {
+		switch {
+		case !known:
+			ui.Warning("Could not determine the nginx version; listen ... quic needs nginx 1.25 or later built with --with-http_v3_module")
+		case !b.Version.atLeast(1, 25, 0):
+			return "", fmt.Errorf("HTTP/3 needs nginx 1.25 or later; %s is %s", b.Binary, b.Version)
+		case b.Args != "" && !strings.Contains(b.Args, "--with-http_v3_module"):
+			return "", fmt.Errorf("%s (nginx %s) is not built with --with-http_v3_module", b.Binary, b.Version)
+		}
+	}
+	http2Directive := http2 && known && b.Version.atLeast(1, 25, 1)
+
+	var out strings.Builder
+	if http2 && !http2Directive {
+		out.WriteString("\tlisten 443 ssl http2;\n")
+	} else {
+		out.WriteString("\tlisten 443 ssl;\n")
+	}
+	if http3 {
+		out.WriteString("\tlisten 443 quic;\n")
+	}
+	if http2Directive {
+		out.WriteString("\thttp2 on;\n")
+	}
+	if http3 {
+		// Browsers only try HTTP/3 after an HTTP/1.1 or HTTP/2 response advertises it
+		out.WriteString("\tadd_header Alt-Svc 'h3=\":443\"; ma=86400' always;\n")
+	}
+	return out.String(), nil
+}
+
+// apacheListen is nginxListen for a <VirtualHost *:443>, which listens through its
+// address: HTTP/2 is the Protocols directive of mod_http2 and httpd has no HTTP/3.
+func apacheListen(http2, http3 bool) (string, error) {
+	if http3 {
+		return "", errors.New("Apache httpd does not support HTTP/3")
+	}
+	if http2 {
+		return "\tProtocols h2 http/1.1\n", nil
+	}
+	return "", nil
+}
@@ -0,0 +1,480 @@
+package install
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/trustctl/trustctl/internal/keystore"
+	"github.com/trustctl/trustctl/internal/paths"
+	"github.com/trustctl/trustctl/internal/ui"
+)
+
+// postfixConfDirs hold main.cf; dovecotConfDirs hold dovecot.conf and its conf.d/.
+var (
+	postfixConfDirs = []string{"/etc/postfix", "/usr/local/etc/postfix"}
+	dovecotConfDirs = []string{"/etc/dovecot", "/usr/local/etc/dovecot"}
+)
+
+// mailVerifyAddrs are probed after a reload unless DeployOptions.VerifyAddr names
+// another endpoint for a single service: SMTP with STARTTLS and IMAPS.
+var mailVerifyAddrs = map[string]string{
+	"postfix": "127.0.0.1:25",
+	"dovecot": "127.0.0.1:993",
+}
+
+// postfixMainCF returns main.cf in the config directory when set, else in the first
+// of postfixConfDirs that has one.
+func postfixMainCF() (string, error) {
+	dirs := postfixConfDirs
+	if configDirOverride != "" {
+		dirs = []string{configDirOverride}
+	}
+	for _, d := range dirs {
+		if p := filepath.Join(d, "main.cf"); fileExists(p) {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("no Postfix main.cf found (looked in %s)", strings.Join(dirs, ", "))
+}
+
+// dovecotSSLConf returns the file holding Dovecot's TLS settings: conf.d/10-ssl.conf,
+// else dovecot.conf for a configuration kept in one file.
+func dovecotSSLConf() (string, error) {
+	dirs := dovecotConfDirs
+	if configDirOverride != "" {
+		dirs = []string{configDirOverride}
+	}
+	for _, d := range dirs {
+		for _, name := range []string{"conf.d/10-ssl.conf", "10-ssl.conf", "dovecot.conf"} {
+			if p := filepath.Join(d, name); fileExists(p) {
+				return p, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no Dovecot 10-ssl.conf or dovecot.conf found (looked in %s)", strings.Join(dirs, ", "))
+}
+
+// dovecotMainConf returns the dovecot.conf that includes the file dovecotSSLConf
+// found, or "" when it is not beside it.
+func dovecotMainConf() string {
+	conf, err := dovecotSSLConf()
+	if err != nil {
+		return ""
+	}
+	dir := filepath.Dir(conf)
+	if filepath.Base(dir) == "conf.d" {
+		dir = filepath.Dir(dir)
+	}
+	if p := filepath.Join(dir, "dovecot.conf"); fileExists(p) {
+		return p
+	}
+	return ""
+}
+
+// mailFiles returns the Postfix and Dovecot files the installer edits, where found.
+func mailFiles() []string {
+	var out []string
+	if p, err := postfixMainCF(); err == nil {
+		out = append(out, p)
+	}
+	if p, err := dovecotSSLConf(); err == nil {
+		out = append(out, p)
+	}
+	return out
+}
+
+// mailServices returns the services srv stands for: the one named, or for mail
+// Postfix and Dovecot, each where its configuration is found.
+func mailServices(srv string) []string {
+	if srv != "mail" {
+		return []string{srv}
+	}
+	var out []string
+	if _, err := postfixMainCF(); err == nil {
+		out = append(out, "postfix")
+	}
+	if _, err := dovecotSSLConf(); err == nil {
+		out = append(out, "dovecot")
+	}
+	return out
+}
+
+// deployMail points Postfix's smtpd TLS certificate and Dovecot's ssl_cert and
+// ssl_key at certPath/keyPath (mail configures both, each where installed), checks
+// the configuration, reloads the running services and waits until each serves the
+// certificate: Postfix on port 25 with STARTTLS, Dovecot on IMAPS. Failures restore
+// every file and reload again.
+func deployMail(domains []string, certPath, keyPath string, opts DeployOptions) error {
+	if opts.HTTP3 || opts.HSTS > 0 {
+		return errors.New("HTTP/3 and HSTS do not apply to mail servers")
+	}
+	if keystore.IsURI(keyPath) {
+		return errors.New("Postfix and Dovecot need the private key in a file, not a key URI")
+	}
+	services := mailServices(opts.Server)
+	if len(services) == 0 {
+		return errors.New("no Postfix main.cf or Dovecot 10-ssl.conf found")
+	}
+
+	cs := newChangeSet(RunInstall, domains)
+	cs.run.Server = opts.Server
+	for _, s := range services {
+		var err error
+		if s == "postfix" {
+			err = installPostfix(cs, certPath, keyPath)
+		} else {
+			err = installDovecot(cs, domains, certPath, keyPath)
+		}
+		if err != nil {
+			if rerr := cs.revert(); rerr != nil {
+				ui.Error("%v", rerr)
+			}
+			return err
+		}
+	}
+	if err := checkConfig(opts.Server, cs); err != nil {
+		return err
+	}
+
+	var running []string
+	for _, s := range services {
+		if serviceRunning(s) {
+			running = append(running, s)
+		}
+	}
+	if len(running) == 0 {
+		ui.Success("No running %s detected; updated its configuration. Reload: %s", strings.Join(services, " or "), reloadHint(opts.Server))
+		return nil
+	}
+	if opts.NoReload {
+		ui.Success("Updated the %s configuration; reload with: %s", strings.Join(services, " and "), reloadHint(opts.Server))
+		return nil
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 30 * time.Second
+	}
+	for _, s := range running {
+		ui.StepStart("Reloading %s...", s)
+		if out, err := reload(s); err != nil {
+			return rollback(opts.Server, cs, fmt.Errorf("%s reload failed: %v: %s", s, err, strings.TrimSpace(string(out))))
+		}
+	}
+	want := leafFingerprint(certPath)
+	for _, s := range running {
+		o := opts
+		if o.VerifyAddr == "" || opts.Server == "mail" {
+			o.VerifyAddr = mailVerifyAddrs[s]
+		}
+		if err := waitForReload(s, nil, probeAll(domains, o.VerifyAddr), want, o); err != nil {
+			return rollback(opts.Server, cs, fmt.Errorf("%s: %v", s, err))
+		}
+		ui.Success("%s reloaded and serving the new certificate on %s", s, o.VerifyAddr)
+	}
+	return nil
+}
+
+// installPostfix sets smtpd_tls_cert_file and smtpd_tls_key_file in main.cf, or
+// smtpd_tls_chain_files when that is set, as Postfix then ignores the other two.
+// Without a TLS security level for incoming mail, STARTTLS is offered with
+// smtpd_tls_security_level = may.
+func installPostfix(cs *changeSet, certPath, keyPath string) error {
+	mainCF, err := postfixMainCF()
+	if err != nil {
+		return err
+	}
+	content, err := os.ReadFile(mainCF)
+	if err != nil {
+		return err
+	}
+	src := string(content)
+	params := parsePostfixMain(src)
+	var edits []textEdit
+	var added []string
+	set := func(name, value string) {
+		p := postfixLookup(params, name)
+		switch {
+		case p == nil:
+			added = append(added, name+" = "+value)
+			ui.Info("Added %s to %s", name, mainCF)
+		case p.Value != value:
+			edits = append(edits, textEdit{Start: p.Start, End: p.End, Text: value})
+			ui.Info("Updated %s at %s:%d", name, mainCF, p.Line)
+		}
+	}
+	if p := postfixLookup(params, "smtpd_tls_chain_files"); p != nil && p.Value != "" {
+		// The key comes before the certificate chain it belongs to
+		set("smtpd_tls_chain_files", keyPath+", "+certPath)
+	} else {
+		set("smtpd_tls_cert_file", certPath)
+		set("smtpd_tls_key_file", keyPath)
+	}
+	level := postfixLookup(params, "smtpd_tls_security_level")
+	legacy := postfixLookup(params, "smtpd_use_tls")
+	switch {
+	case level != nil && level.Value == "none":
+		ui.Warning("smtpd_tls_security_level = none in %s; Postfix does not offer STARTTLS", mainCF)
+	case (level == nil || level.Value == "") && (legacy == nil || legacy.Value != "yes"):
+		set("smtpd_tls_security_level", "may")
+	}
+
+	if len(edits) == 0 && len(added) == 0 {
+		ui.Info("No change required in %s", mainCF)
+		return nil
+	}
+	out := applyEdits(src, edits)
+	if len(added) > 0 {
+		if out != "" && !strings.HasSuffix(out, "\n") {
+			out += "\n"
+		}
+		out += strings.Join(added, "\n") + "\n"
+	}
+	return cs.write(mainCF, []byte(out))
+}
+
+// installDovecot points ssl_cert and ssl_key (ssl_server_cert_file and
+// ssl_server_key_file in Dovecot 2.4 configurations) at certPath/keyPath: in the
+// local_name blocks naming one of domains, and at the top level, which serves every
+// other name, unless each domain has a block of its own. ssl = no becomes ssl = yes.
+func installDovecot(cs *changeSet, domains []string, certPath, keyPath string) error {
+	conf, err := dovecotSSLConf()
+	if err != nil {
+		return err
+	}
+	content, err := os.ReadFile(conf)
+	if err != nil {
+		return err
+	}
+	src := string(content)
+	sections := parseDovecot(src)
+
+	certKey, keyKey, certVal, keyVal := "ssl_cert", "ssl_key", "<"+certPath, "<"+keyPath
+	for _, s := range sections {
+		if s.setting("ssl_server_cert_file") != nil || s.setting("ssl_server_key_file") != nil {
+			// 2.4 takes file names, without the < that reads a value from a file
+			certKey, keyKey, certVal, keyVal = "ssl_server_cert_file", "ssl_server_key_file", certPath, keyPath
+		}
+	}
+	var targets []*dovecotSection
+	for _, d := range domains {
+		found := false
+		for _, s := range sections[1:] {
+			if s.Name == "local_name" && dovecotNamesMatch(s.Args, []string{d}) {
+				found = true
+				if !dovecotHasSection(targets, s) {
+					targets = append(targets, s)
+				}
+			}
+		}
+		if !found && !dovecotHasSection(targets, sections[0]) {
+			targets = append(targets, sections[0])
+		}
+	}
+
+	var edits []textEdit
+	if st := sections[0].setting("ssl"); st != nil && st.Value == "no" {
+		edits = append(edits, textEdit{Start: st.Start, End: st.End, Text: "yes"})
+		ui.Info("Enabled TLS (ssl = yes) at %s:%d", conf, st.Line)
+	}
+	for _, s := range targets {
+		where := conf
+		if s.Name != "" {
+			where = fmt.Sprintf("local_name %s at %s:%d", strings.Join(s.Args, " "), conf, s.Line)
+		}
+		var missing []string
+		changed := false
+		for _, kv := range [][2]string{{certKey, certVal}, {keyKey, keyVal}} {
+			st := s.setting(kv[0])
+			switch {
+			case st == nil:
+				missing = append(missing, kv[0]+" = "+kv[1])
+			case st.Value != kv[1]:
+				edits = append(edits, textEdit{Start: st.Start, End: st.End, Text: kv[1]})
+			default:
+				continue
+			}
+			changed = true
+		}
+		if len(missing) > 0 {
+			indent := ""
+			switch {
+			case len(s.Settings) > 0:
+				line := src[strings.LastIndexByte(src[:s.Settings[0].Start], '\n')+1:]
+				indent = line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+			case s.Name != "":
+				rest := src[s.Close:]
+				indent = rest[:len(rest)-len(strings.TrimLeft(rest, " \t"))] + "  "
+			}
+			text := ""
+			if s.Close > 0 && src[s.Close-1] != '\n' {
+				text = "\n"
+			}
+			for _, m := range missing {
+				text += indent + m + "\n"
+			}
+			edits = append(edits, textEdit{Start: s.Close, End: s.Close, Text: text})
+		}
+		if changed {
+			ui.Info("Updated %s and %s of %s", certKey, keyKey, where)
+		} else {
+			ui.Info("No change required for %s", where)
+		}
+	}
+	if len(edits) == 0 {
+		return nil
+	}
+	return cs.write(conf, []byte(applyEdits(src, edits)))
+}
+
+func dovecotHasSection(list []*dovecotSection, s *dovecotSection) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+// dovecotNamesMatch reports whether a local_name block names one of domains.
+func dovecotNamesMatch(names, domains []string) bool {
+	for _, n := range names {
+		for _, d := range domains {
+			if strings.EqualFold(n, d) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// mailConfigTest runs `postfix check` and `doveconf -n` for the services of srv. A
+// missing binary is reported only after the other service was checked.
+func mailConfigTest(srv string) ([]byte, error) {
+	var out []byte
+	var missing error
+	for _, s := range mailServices(srv) {
+		var cmd *exec.Cmd
+		var stderr bytes.Buffer
+		if s == "postfix" {
+			args := []string{"check"}
+			if mainCF, err := postfixMainCF(); err == nil {
+				args = []string{"-c", filepath.Dir(mainCF), "check"}
+			}
+			cmd = exec.Command("postfix", args...)
+			cmd.Stdout = &stderr
+		} else {
+			// doveconf -n prints the whole configuration; only its errors matter
+			args := []string{"-n"}
+			if conf := dovecotMainConf(); conf != "" {
+				args = append(args, "-c", conf)
+			}
+			cmd = exec.Command("doveconf", args...)
+		}
+		cmd.Stderr = &stderr
+		err := cmd.Run()
+		out = append(out, stderr.Bytes()...)
+		var execErr *exec.Error
+		if errors.As(err, &execErr) {
+			missing = err
+			continue
+		}
+		if err != nil {
+			return out, err
+		}
+	}
+	return out, missing
+}
+
+// mailRunning reports whether any service of srv runs.
+func mailRunning(srv string) bool {
+	for _, s := range mailServices(srv) {
+		if s == "postfix" && (systemdActive("postfix") || exec.Command("postfix", "status").Run() == nil) {
+			return true
+		}
+		if s == "dovecot" && (systemdActive("dovecot") || exec.Command("pgrep", "-x", "dovecot").Run() == nil) {
+			return true
+		}
+	}
+	return false
+}
+
+// reloadMail reloads the services of srv, skipping for mail the one not running.
+// Both re-read their certificates for new connections and let open ones finish.
+func reloadMail(srv string) ([]byte, error) {
+	var out []byte
+	for _, s := range mailServices(srv) {
+		if srv == "mail" && !mailRunning(s) {
+			continue
+		}
+		var o []byte
+		var err error
+		switch {
+		case paths.Rootless():
+			return out, fmt.Errorf("reloading %s needs root; set one with --reload-command", s)
+		case runtime.GOOS != "darwin" && distro != "freebsd" && systemdActive(s):
+			o, err = exec.Command("systemctl", "reload", s).CombinedOutput()
+		case s == "postfix":
+			o, err = exec.Command("postfix", "reload").CombinedOutput()
+		default:
+			o, err = exec.Command("doveadm", "reload").CombinedOutput()
+		}
+		out = append(out, o...)
+		if err != nil {
+			return out, err
+		}
+	}
+	return out, nil
+}
+
+// starttls upgrades conn the way the plaintext protocol on addr's port does it:
+// SMTP on 25 and 587, IMAP on 143 and POP3 on 110. Other ports start with TLS.
+func starttls(conn net.Conn, addr string) error {
+	_, port, _ := net.SplitHostPort(addr)
+	r := bufio.NewReader(conn)
+	// reply reads a response and checks that its last line starts with ok: SMTP
+	// continues a reply on lines with - after the code, and IMAP sends untagged
+	// responses before the tagged one
+	reply := func(ok string) error {
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return err
+			}
+			if len(line) > 3 && line[3] == '-' || ok != "* OK" && strings.HasPrefix(line, "* ") {
+				continue
+			}
+			if !strings.HasPrefix(line, ok) {
+				return fmt.Errorf("STARTTLS: unexpected reply %q", strings.TrimSpace(line))
+			}
+			return nil
+		}
+	}
+	var steps [][2]string // command sent, then the reply expected
+	switch port {
+	case "25", "587":
+		steps = [][2]string{{"", "220"}, {"EHLO trustctl\r\n", "250"}, {"STARTTLS\r\n", "220"}}
+	case "143":
+		steps = [][2]string{{"", "* OK"}, {"a STARTTLS\r\n", "a OK"}}
+	case "110":
+		steps = [][2]string{{"", "+OK"}, {"STLS\r\n", "+OK"}}
+	}
+	for _, s := range steps {
+		if s[0] != "" {
+			if _, err := conn.Write([]byte(s[0])); err != nil {
+				return err
+			}
+		}
+		if err := reply(s[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
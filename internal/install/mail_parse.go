@@ -0,0 +1,148 @@
+package install
+
+import (
+	"strings"
+)
+
+// postfixParam is a parameter definition of Postfix's main.cf. Start and End delimit
+// its value, continuation lines included.
+type postfixParam struct {
+	Name       string
+	Value      string
+	Start, End int
+	Line       int
+}
+
+// parsePostfixMain returns the parameter definitions of main.cf in file order. A
+// logical line starts at column 0 and continues on lines starting with whitespace;
+// blank lines and lines whose first non-blank character is # are ignored.
+func parsePostfixMain(src string) []postfixParam {
+	var params []postfixParam
+	var cur *postfixParam
+	offset := 0
+	for n, line := range strings.SplitAfter(src, "\n") {
+		start := offset
+		offset += len(line)
+		body := strings.TrimRight(line, " \t\r\n")
+		trimmed := strings.TrimLeft(body, " \t")
+		switch {
+		case trimmed == "" || trimmed[0] == '#':
+			continue
+		case body[0] == ' ' || body[0] == '\t':
+			if cur != nil && cur.Value == "" {
+				// The value starts on a continuation line
+				cur.Value, cur.Start = trimmed, start+len(body)-len(trimmed)
+			} else if cur != nil {
+				cur.Value += " " + trimmed
+			}
+			if cur != nil {
+				cur.End = start + len(body)
+			}
+			continue
+		}
+		cur = nil
+		name, value, ok := strings.Cut(body, "=")
+		if !ok {
+			continue
+		}
+		params = append(params, postfixParam{
+			Name:  strings.TrimSpace(name),
+			Value: strings.TrimSpace(value),
+			Start: start + len(name) + 1 + len(value) - len(strings.TrimLeft(value, " \t")),
+			End:   start + len(body),
+			Line:  n + 1,
+		})
+		cur = &params[len(params)-1]
+	}
+	return params
+}
+
+// postfixLookup returns the definition of name that takes effect, the last one.
+func postfixLookup(params []postfixParam, name string) *postfixParam {
+	for i := len(params) - 1; i >= 0; i-- {
+		if params[i].Name == name {
+			return &params[i]
+		}
+	}
+	return nil
+}
+
+// dovecotSetting is a `key = value` line of a Dovecot configuration file; Start and
+// End delimit the value.
+type dovecotSetting struct {
+	Key, Value string
+	Start, End int
+	Line       int
+}
+
+// dovecotSection is a `name [args] {` block, or the top level of the file with an
+// empty Name. Close is the offset of the line holding its closing brace (the end of
+// the file for the top level).
+type dovecotSection struct {
+	Name     string
+	Args     []string
+	Close    int
+	Line     int
+	Settings []*dovecotSetting
+}
+
+// setting returns the last definition of key directly in s.
+func (s *dovecotSection) setting(key string) *dovecotSetting {
+	var found *dovecotSetting
+	for _, st := range s.Settings {
+		if st.Key == key {
+			found = st
+		}
+	}
+	return found
+}
+
+// parseDovecot returns the top level of a Dovecot configuration file followed by
+// its sections, nested ones included, in file order.
+func parseDovecot(src string) []*dovecotSection {
+	root := &dovecotSection{Close: len(src)}
+	sections := []*dovecotSection{root}
+	stack := []*dovecotSection{root}
+	offset := 0
+	for n, line := range strings.SplitAfter(src, "\n") {
+		start := offset
+		offset += len(line)
+		body := line
+		if i := strings.IndexByte(body, '#'); i >= 0 {
+			body = body[:i]
+		}
+		body = strings.TrimRight(body, " \t\r\n")
+		trimmed := strings.TrimLeft(body, " \t")
+		switch {
+		case trimmed == "":
+		case trimmed == "}":
+			if len(stack) > 1 {
+				stack[len(stack)-1].Close = start
+				stack = stack[:len(stack)-1]
+			}
+		case strings.Contains(trimmed, "="):
+			key, value, _ := strings.Cut(body, "=")
+			v := strings.TrimSpace(value)
+			s := &dovecotSetting{Key: strings.TrimSpace(key), Value: v, End: start + len(body), Line: n + 1}
+			s.Start = s.End - len(v)
+			top := stack[len(stack)-1]
+			top.Settings = append(top.Settings, s)
+		case strings.HasSuffix(trimmed, "{"):
+			fields := strings.Fields(strings.TrimSuffix(trimmed, "{"))
+			if len(fields) == 0 {
+				continue
+			}
+			s := &dovecotSection{Name: fields[0], Line: n + 1}
+			for _, a := range fields[1:] {
+				s.Args = append(s.Args, strings.Trim(a, `"`))
+			}
+			sections = append(sections, s)
+			stack = append(stack, s)
+		}
+	}
+	for _, s := range stack[1:] {
+		// Not closed; doveconf rejects the file, but keep edits inside the file
+		s.Close = len(src)
+	}
+	return sections
+}
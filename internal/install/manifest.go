@@ -0,0 +1,120 @@
+package install
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/trustctl/trustctl/internal/ui"
+)
+
+// Kinds of Run.
+const (
+	RunInstall   = "install"
+	RunUninstall = "uninstall"
+	RunRestore   = "restore"
+	RunHSTS      = "hsts"
+)
+
+// Run is the manifest of one installer run: the config files it edited and the
+// backup taken of each before its first edit. It is saved after every edit, so a run
+// that was interrupted can still be reverted.
+type Run struct {
+	ID       string    `json:"id"`
+	Kind     string    `json:"kind"`
+	Time     time.Time `json:"time"`
+	Server   string    `json:"server,omitempty"` // nginx, apache, tomcat, ...
+	Domains  []string  `json:"domains,omitempty"`
+	Changes  []change  `json:"changes"`
+	Reverted bool      `json:"reverted,omitempty"` // the run restored its own backups after a failure
+}
+
+// RunsDir is where run manifests are kept.
+func RunsDir() string {
+	return filepath.Join(BackupsDir(), "runs")
+}
+
+// newChangeSet starts a change set whose edits are recorded in a new Run manifest.
+func newChangeSet(kind string, domains []string) *changeSet {
+	now := time.Now()
+	return &changeSet{run: &Run{
+		ID:      now.UTC().Format("20060102T150405") + "-" + strconv.Itoa(os.Getpid()),
+		Kind:    kind,
+		Time:    now,
+		Domains: domains,
+	}}
+}
+
+// save writes the run's manifest. A manifest that cannot be written does not stop
+// the run; only restore --run loses track of it.
+func (r *Run) save() {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err == nil {
+		if err = os.MkdirAll(RunsDir(), 0700); err == nil {
+			err = writeFileAtomic(filepath.Join(RunsDir(), r.ID+".json"), data)
+		}
+	}
+	if err != nil {
+		ui.Warning("could not record installer run %s: %v", r.ID, err)
+	}
+}
+
+// Files returns the config files the run edited.
+func (r *Run) Files() []string {
+	out := make([]string, 0, len(r.Changes))
+	for _, c := range r.Changes {
+		out = append(out, c.Path)
+	}
+	return out
+}
+
+// Runs returns the recorded installer runs, newest first.
+func Runs() ([]Run, error) {
+	entries, err := os.ReadDir(RunsDir())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var runs []Run
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok {
+			continue
+		}
+		r, err := LoadRun(id)
+		if err != nil {
+			ui.Warning("%v", err)
+			continue
+		}
+		runs = append(runs, r)
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].Time.After(runs[j].Time) })
+	return runs, nil
+}
+
+// LoadRun reads the manifest of run id.
+func LoadRun(id string) (Run, error) {
+	var r Run
+	if id == "" || strings.ContainsAny(id, `/\`) {
+		return r, fmt.Errorf("invalid run id %q", id)
+	}
+	data, err := os.ReadFile(filepath.Join(RunsDir(), id+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return r, fmt.Errorf("no installer run %s", id)
+	}
+	if err != nil {
+		return r, err
+	}
+	if err := json.Unmarshal(data, &r); err != nil {
+		return r, fmt.Errorf("run %s: %w", id, err)
+	}
+	return r, nil
+}
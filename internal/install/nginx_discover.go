@@ -0,0 +1,239 @@
+package install
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// nginxBinaries are tried in order when nginx is not on PATH: OpenResty and
+// custom --prefix builds commonly live outside it.
+var nginxBinaries = []string{
+	"/usr/local/openresty/nginx/sbin/nginx",
+	"/usr/local/nginx/sbin/nginx",
+	"/opt/nginx/sbin/nginx",
+	"/usr/local/tengine/sbin/nginx",
+}
+
+// nginxConfPaths are the main configs read when no nginx binary reports its own,
+// as on hosts where only the configuration is mounted.
+var nginxConfPaths = []string{
+	"/etc/nginx/nginx.conf",
+	"/usr/local/etc/nginx/nginx.conf",
+	"/usr/local/nginx/conf/nginx.conf",
+	"/usr/local/openresty/nginx/conf/nginx.conf",
+}
+
+// nginxBuild describes an nginx-compatible binary as reported by `nginx -V`.
+type nginxBuild struct {
+	Binary   string
+	Flavor   string // nginx, openresty, tengine
+	Version  nginxVersion
+	Prefix   string
+	ConfPath string
+	Args     string // configure arguments
+}
+
+// nginxVersion is the nginx core version, the base of OpenResty and Tengine too; zero
+// when unknown.
+type nginxVersion [3]int
+
+var reNginxVersion = regexp.MustCompile(`(?:nginx|openresty)/(\d+)\.(\d+)\.(\d+)`)
+
+func (v nginxVersion) atLeast(major, minor, patch int) bool {
+	for i, want := range []int{major, minor, patch} {
+		if v[i] != want {
+			return v[i] > want
+		}
+	}
+	return true
+}
+
+func (v nginxVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d", v[0], v[1], v[2])
+}
+
+// findNginx locates an nginx, openresty or tengine binary and reads its build
+// configuration. It returns nil when the config directory is overridden, as the
+// binary's configuration is then not the one being edited.
+func findNginx() *nginxBuild {
+	if configDirOverride != "" {
+		return nil
+	}
+	return lookupNginx()
+}
+
+// lookupNginx is findNginx regardless of the config directory.
+func lookupNginx() *nginxBuild {
+	var candidates []string
+	for _, name := range []string{"nginx", "openresty"} {
+		if p, err := exec.LookPath(name); err == nil {
+			candidates = append(candidates, p)
+		}
+	}
+	candidates = append(candidates, nginxBinaries...)
+	for _, bin := range candidates {
+		// nginx -V prints its version and configure arguments on stderr
+		out, err := exec.Command(bin, "-V").CombinedOutput()
+		if err != nil {
+			continue
+		}
+		return parseNginxV(bin, string(out))
+	}
+	return nil
+}
+
+func parseNginxV(bin, out string) *nginxBuild {
+	b := &nginxBuild{Binary: bin, Flavor: "nginx"}
+	switch {
+	case strings.Contains(out, "openresty"):
+		b.Flavor = "openresty"
+	case strings.Contains(out, "Tengine"):
+		b.Flavor = "tengine"
+	}
+	for _, f := range strings.Fields(out) {
+		if v, ok := strings.CutPrefix(f, "--prefix="); ok {
+			b.Prefix = v
+		}
+		if v, ok := strings.CutPrefix(f, "--conf-path="); ok {
+			b.ConfPath = v
+		}
+	}
+	if m := reNginxVersion.FindStringSubmatch(out); m != nil {
+		for i := range b.Version {
+			b.Version[i], _ = strconv.Atoi(m[i+1])
+		}
+	}
+	if _, args, ok := strings.Cut(out, "configure arguments:"); ok {
+		b.Args = args
+	}
+	if b.ConfPath == "" && b.Prefix != "" {
+		b.ConfPath = filepath.Join(b.Prefix, "conf/nginx.conf")
+	} else if b.ConfPath != "" && !filepath.IsAbs(b.ConfPath) && b.Prefix != "" {
+		b.ConfPath = filepath.Join(b.Prefix, b.ConfPath)
+	}
+	return b
+}
+
+// ConfigFiles returns every file in the include graph, as listed by `nginx -T`.
+// When the dump fails (invalid config, insufficient rights) it follows the include
+// directives of the main config itself.
+func (b *nginxBuild) ConfigFiles() []string {
+	args := []string{"-T"}
+	if b.ConfPath != "" {
+		args = append(args, "-c", b.ConfPath)
+	}
+	var files []string
+	if out, err := exec.Command(b.Binary, args...).Output(); err == nil {
+		sc := bufio.NewScanner(bytes.NewReader(out))
+		sc.Buffer(make([]byte, 64*1024), 1024*1024)
+		for sc.Scan() {
+			if f, ok := strings.CutPrefix(sc.Text(), "# configuration file "); ok {
+				files = append(files, strings.TrimSuffix(f, ":"))
+			}
+		}
+		if len(files) > 0 {
+			return files
+		}
+	}
+	if b.ConfPath == "" {
+		return nil
+	}
+	return nginxIncludes(b.ConfPath)
+}
+
+// nginxIncludes returns conf and every file its include directives pull in,
+// recursively and in include order. Relative patterns resolve against the directory
+// of conf, as nginx resolves them against its conf prefix; globs expand in name
+// order. A file that does not parse is listed but its includes are not followed.
+func nginxIncludes(conf string) []string {
+	prefix := filepath.Dir(conf)
+	seen := map[string]bool{}
+	var files []string
+	var visit func(string)
+	visit = func(f string) {
+		if seen[f] {
+			return
+		}
+		seen[f] = true
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return
+		}
+		files = append(files, f)
+		dirs, err := parseNginx(string(data))
+		if err != nil {
+			return
+		}
+		walkNginx(dirs, func(d *nginxDirective) {
+			if d.Name != "include" || len(d.Args) != 1 {
+				return
+			}
+			pattern := d.Args[0]
+			if !filepath.IsAbs(pattern) {
+				pattern = filepath.Join(prefix, pattern)
+			}
+			matches, _ := filepath.Glob(pattern) // sorted; a bad pattern includes nothing
+			for _, m := range matches {
+				visit(m)
+			}
+		})
+	}
+	visit(conf)
+	return files
+}
+
+// nginxMainConf returns the first of nginxConfPaths that exists, or "" when there is
+// none or discovery is restricted by SetConfigDir.
+func nginxMainConf() string {
+	if configDirOverride != "" {
+		return ""
+	}
+	for _, conf := range nginxConfPaths {
+		if _, err := os.Stat(conf); err == nil {
+			return conf
+		}
+	}
+	return ""
+}
+
+// nginxFiles merges the discovered include graph with the well-known site
+// directories, skipping duplicates reached through symlinks. Without an nginx binary
+// the graph is read from the first main config of nginxConfPaths.
+func nginxFiles() []string {
+	var files []string
+	if b := findNginx(); b != nil {
+		files = b.ConfigFiles()
+	} else if conf := nginxMainConf(); conf != "" {
+		files = nginxIncludes(conf)
+	}
+	return uniqueFiles(append(files, collectFiles(nginxSitesDirs)...))
+}
+
+// uniqueFiles drops directories, missing files, MIME type maps, trustctl's own
+// backups and files already listed under another name through symlinks.
+func uniqueFiles(files []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, f := range files {
+		real, err := filepath.EvalSymlinks(f)
+		if err != nil {
+			continue
+		}
+		if _, ok := parseBackupName(f); ok || seen[real] || filepath.Base(f) == "mime.types" {
+			continue
+		}
+		if fi, err := os.Stat(real); err != nil || fi.IsDir() {
+			continue
+		}
+		seen[real] = true
+		out = append(out, f)
+	}
+	return out
+}
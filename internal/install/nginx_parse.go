@@ -0,0 +1,261 @@
+package install
+
+import (
+	"fmt"
+	"strings"
+)
+
+// nginxDirective is one directive of an nginx configuration file: a simple directive
+// ending in ';' or a block with children. Offsets index the parsed source, so edits
+// can replace a single directive and leave formatting and comments elsewhere alone.
+type nginxDirective struct {
+	Name     string
+	Args     []string
+	Start    int // offset of the directive name
+	End      int // offset just past the closing ';' or '}'
+	Line     int
+	IsBlock  bool
+	Children []*nginxDirective
+	Parent   *nginxDirective // nil at the top level
+}
+
+// parseNginx parses an nginx configuration file into its directives. It understands
+// comments, quoted strings and nested blocks; include directives are returned as is.
+func parseNginx(src string) ([]*nginxDirective, error) {
+	p := &nginxParser{src: src, line: 1}
+	dirs, err := p.parseBlock(nil)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(src) {
+		return nil, fmt.Errorf("line %d: unexpected }", p.line)
+	}
+	return dirs, nil
+}
+
+type nginxParser struct {
+	src  string
+	pos  int
+	line int
+}
+
+// token returns the next word, quoted string (unquoted) or one of ";{}", with the
+// offset it starts at. eof is set at the end of the source.
+func (p *nginxParser) token() (tok string, start int, quoted, eof bool) {
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == '\n':
+			p.line++
+			p.pos++
+		case c == ' ' || c == '\t' || c == '\r':
+			p.pos++
+		case c == '#':
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		default:
+			goto found
+		}
+	}
+	return "", p.pos, false, true
+found:
+	start = p.pos
+	switch c := p.src[p.pos]; c {
+	case ';', '{', '}':
+		p.pos++
+		return string(c), start, false, false
+	case '"', '\'':
+		var b strings.Builder
+		for p.pos++; p.pos < len(p.src) && p.src[p.pos] != c; p.pos++ {
+			if p.src[p.pos] == '\\' && p.pos+1 < len(p.src) {
+				p.pos++
+			}
+			if p.src[p.pos] == '\n' {
+				p.line++
+			}
+			b.WriteByte(p.src[p.pos])
+		}
+		p.pos++ // closing quote
+		return b.String(), start, true, false
+	}
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == ';' || c == '}' {
+			break
+		}
+		// A brace opens a block unless it is part of ${variable}
+		if c == '{' && (p.pos == start || p.src[p.pos-1] != '$') {
+			break
+		}
+		if c == '{' {
+			for p.pos < len(p.src) && p.src[p.pos] != '}' {
+				p.pos++
+			}
+		}
+		p.pos++
+	}
+	return p.src[start:p.pos], start, false, false
+}
+
+// parseBlock reads directives until the '}' closing parent, or the end of the source
+// at the top level.
+func (p *nginxParser) parseBlock(parent *nginxDirective) ([]*nginxDirective, error) {
+	var dirs []*nginxDirective
+	var cur *nginxDirective
+	for {
+		tok, start, quoted, eof := p.token()
+		if eof {
+			if parent != nil {
+				return nil, fmt.Errorf("line %d: block %s opened on line %d is not closed", p.line, parent.Name, parent.Line)
+			}
+			if cur != nil {
+				return nil, fmt.Errorf("line %d: directive %s is not terminated by ;", cur.Line, cur.Name)
+			}
+			return dirs, nil
+		}
+		if quoted {
+			tok = "\x00" + tok // keeps a quoted ";" or "{" from acting as syntax
+		}
+		switch {
+		case tok == ";" && cur != nil:
+			cur.End = p.pos
+			dirs = append(dirs, cur)
+			cur = nil
+		case tok == "{" && cur != nil:
+			cur.IsBlock = true
+			children, err := p.parseBlock(cur)
+			if err != nil {
+				return nil, err
+			}
+			cur.Children = children
+			cur.End = p.pos
+			dirs = append(dirs, cur)
+			cur = nil
+		case tok == "}":
+			if cur != nil {
+				return nil, fmt.Errorf("line %d: directive %s is not terminated by ;", cur.Line, cur.Name)
+			}
+			if parent == nil {
+				p.pos = start // reported by parseNginx
+				return dirs, nil
+			}
+			return dirs, nil
+		case tok == ";" || tok == "{":
+			return nil, fmt.Errorf("line %d: unexpected %s", p.line, tok)
+		case cur == nil:
+			cur = &nginxDirective{Name: strings.TrimPrefix(tok, "\x00"), Start: start, Line: p.line, Parent: parent}
+		default:
+			cur.Args = append(cur.Args, strings.TrimPrefix(tok, "\x00"))
+		}
+	}
+}
+
+// find returns the direct children of d named name.
+func (d *nginxDirective) find(name string) []*nginxDirective {
+	var out []*nginxDirective
+	for _, c := range d.Children {
+		if c.Name == name {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// within reports whether d is nested, at any depth, in a block named name.
+func (d *nginxDirective) within(name string) bool {
+	for p := d.Parent; p != nil; p = p.Parent {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// walkNginx calls fn for every directive in dirs and their children, depth first.
+func walkNginx(dirs []*nginxDirective, fn func(*nginxDirective)) {
+	for _, d := range dirs {
+		fn(d)
+		walkNginx(d.Children, fn)
+	}
+}
+
+// nginxHTTPServers returns the server blocks of the http context: those at the top
+// level of an included vhost file or inside http {}, but not in stream {} or mail {}.
+func nginxHTTPServers(dirs []*nginxDirective) []*nginxDirective {
+	var out []*nginxDirective
+	walkNginx(dirs, func(d *nginxDirective) {
+		if d.Name == "server" && d.IsBlock && !d.within("stream") && !d.within("mail") {
+			out = append(out, d)
+		}
+	})
+	return out
+}
+
+// serverNames returns the names of a server block's server_name directives.
+func (d *nginxDirective) serverNames() []string {
+	var names []string
+	for _, sn := range d.find("server_name") {
+		names = append(names, sn.Args...)
+	}
+	return names
+}
+
+// serves reports whether the server block's server_name lists domain.
+func (d *nginxDirective) serves(domain string) bool {
+	for _, n := range d.serverNames() {
+		if nameMatches(n, domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// tlsListen returns the address of the server block's first TLS listen directive,
+// or 443 for a block made TLS by the legacy `ssl on`.
+func (d *nginxDirective) tlsListen() string {
+	for _, l := range d.find("listen") {
+		if len(l.Args) == 0 || strings.HasPrefix(l.Args[0], "unix:") {
+			continue
+		}
+		for i, a := range l.Args {
+			if a == "ssl" || (i == 0 && listenPort(a) == "443") {
+				return l.Args[0]
+			}
+		}
+	}
+	return "443"
+}
+
+// tls reports whether the server block accepts TLS: a listen with the ssl flag or on
+// port 443, or the legacy `ssl on`.
+func (d *nginxDirective) tls() bool {
+	for _, l := range d.find("listen") {
+		for i, a := range l.Args {
+			if a == "ssl" || (i == 0 && listenPort(a) == "443") {
+				return true
+			}
+		}
+	}
+	for _, s := range d.find("ssl") {
+		if len(s.Args) == 1 && s.Args[0] == "on" {
+			return true
+		}
+	}
+	return false
+}
+
+// listenPort returns the port of a listen address: "443", "[::]:443",
+// "127.0.0.1:443" or "*:80". A bare address listens on 80.
+func listenPort(addr string) string {
+	if strings.HasPrefix(addr, "unix:") {
+		return ""
+	}
+	if i := strings.LastIndex(addr, ":"); i >= 0 && !strings.HasSuffix(addr, "]") {
+		return addr[i+1:]
+	}
+	if strings.Trim(addr, "0123456789") == "" {
+		return addr
+	}
+	return "80"
+}
@@ -0,0 +1,58 @@
+package install
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+)
+
+// nginxStreamEdits points the TLS server blocks inside `stream {}` contexts that
+// belong to domain at certPath/keyPath and returns the edits and how many blocks
+// belong to domain. Stream servers (database, MQTT, SMTP proxies) usually have no
+// server_name, so a block belongs to domain when its current certificate lives in
+// the same directory as certPath, covers domain, or its server_name lists domain.
+func nginxStreamEdits(src string, dirs []*nginxDirective, domain, certPath, keyPath string) ([]textEdit, int) {
+	var edits []textEdit
+	blocks := 0
+	walkNginx(dirs, func(d *nginxDirective) {
+		if d.Name == "server" && d.IsBlock && d.within("stream") && streamBlockFor(d, domain, certPath) {
+			blocks++
+			edits = append(edits, nginxSSLEdits(src, d, certPath, keyPath)...)
+		}
+	})
+	return edits, blocks
+}
+
+// streamBlockFor reports whether a stream server block serves domain.
+func streamBlockFor(srv *nginxDirective, domain, certPath string) bool {
+	certs := srv.find("ssl_certificate")
+	if len(certs) == 0 || len(certs[0].Args) != 1 {
+		return false
+	}
+	current := certs[0].Args[0]
+	if filepath.Dir(current) == filepath.Dir(certPath) {
+		return true
+	}
+	return srv.serves(domain) || certFileCovers(current, domain)
+}
+
+// certFileCovers reports whether the leaf in path is valid for domain. Other PEM
+// blocks before it, like the key of an HAProxy bundle, are skipped.
+func certFileCovers(path, domain string) bool {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	for {
+		var block *pem.Block
+		if block, data = pem.Decode(data); block == nil {
+			return false
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		return err == nil && cert.VerifyHostname(domain) == nil
+	}
+}
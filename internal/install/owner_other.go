@@ -0,0 +1,8 @@
+//go:build !unix
+
+package install
+
+import "os"
+
+// keepOwner does nothing where files have no Unix owner.
+func keepOwner(path string, fi os.FileInfo) {}
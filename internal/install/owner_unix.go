@@ -0,0 +1,17 @@
+//go:build unix
+
+package install
+
+import (
+	"os"
+	"syscall"
+)
+
+// keepOwner gives path the owner and group in fi, as root rewriting a file a server
+// reads as another user must not take it away from that user. Failures are ignored:
+// without root the file is already the caller's.
+func keepOwner(path string, fi os.FileInfo) {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		os.Lchown(path, int(st.Uid), int(st.Gid))
+	}
+}
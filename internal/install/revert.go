@@ -0,0 +1,325 @@
+package install
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/trustctl/trustctl/internal/ui"
+)
+
+// Uninstall restores every nginx or Apache file that references any of paths from the
+// newest trustctl backup that does not, then checks the configuration and reloads the
+// running server; a failed check or reload puts the files back. Only the server blocks
+// and vhosts that reference paths are taken from the backup (see restoreVhosts), so
+// other domains edited in the same file since keep their certificates. When any file
+// has no such backup nothing is changed and those files are returned as unresolved.
+func Uninstall(paths ...string) (restored, unresolved []string, err error) {
+	files := FilesReferencing(paths...)
+	backups := map[string][]byte{}
+	for _, f := range files {
+		if data, ok := preInstallBackup(f, paths); ok {
+			backups[f] = data
+			if current, err := os.ReadFile(f); err == nil {
+				if scoped, ok := restoreVhosts(f, string(current), string(data), paths); ok {
+					backups[f] = []byte(scoped)
+				}
+			}
+		} else {
+			unresolved = append(unresolved, f)
+		}
+	}
+	if len(unresolved) > 0 {
+		return nil, unresolved, nil
+	}
+
+	cs := newChangeSet(RunUninstall, nil)
+	for _, f := range files {
+		if err := cs.write(f, backups[f]); err != nil {
+			if rerr := cs.revert(); rerr != nil {
+				ui.Error("%v", rerr)
+			}
+			return nil, nil, err
+		}
+		restored = append(restored, f)
+	}
+	if len(restored) == 0 {
+		return nil, nil, nil
+	}
+	if err := checkAndReload(cs); err != nil {
+		return nil, nil, err
+	}
+	return restored, nil, nil
+}
+
+// checkAndReload checks the configuration cs wrote and reloads the running server,
+// putting the files back when either fails. Without a running server only the check
+// runs and the reload command is printed.
+func checkAndReload(cs *changeSet) error {
+	srv, running := "", false
+	if cs.run != nil {
+		srv = cs.run.Server
+	}
+	if webServer(srv) {
+		if detected, err := detectRunningServer(); err == nil {
+			srv, running = detected, true
+		}
+	} else {
+		running = serviceRunning(srv)
+	}
+	if srv == "" {
+		srv = serverOf(cs.changes[0].Path)
+	}
+	if !running {
+		if err := checkConfig(srv, cs); err != nil {
+			return err
+		}
+		ui.Info("No running server detected; reload it to apply the change: %s", reloadHint(srv))
+		return nil
+	}
+	if err := checkConfig(srv, cs); err != nil {
+		return err
+	}
+	if out, err := reload(srv); err != nil {
+		return rollback(srv, cs, fmt.Errorf("reload failed: %v: %s", err, strings.TrimSpace(string(out))))
+	}
+	ui.Success("%s reloaded with the changed configuration", srv)
+	return nil
+}
+
+// FileBackups returns the backups of the config file at path, newest first. A path
+// reached through a symlink also finds backups taken under the link's target.
+func FileBackups(path string) []Backup {
+	names := []string{path}
+	if real, err := filepath.EvalSymlinks(path); err == nil && real != path {
+		names = append(names, real)
+	}
+	var out []Backup
+	for _, name := range names {
+		// Under BackupsDir, and next to the file where earlier versions put them
+		for _, prefix := range []string{filepath.Join(backupFilesDir(), name), name} {
+			matches, _ := filepath.Glob(prefix + ".bak.*")
+			for _, m := range matches {
+				if b, ok := parseBackupName(m); ok && b.Original == name {
+					out = append(out, b)
+				}
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Time.After(out[j].Time) })
+	return out
+}
+
+// RestoreFile replaces the config file at path with its backup taken at at, or with
+// the newest backup when at is zero, then checks the configuration and reloads the
+// running server. The current content is backed up first, so a restore can be undone
+// like any other run.
+func RestoreFile(path string, at time.Time) (Backup, error) {
+	backups := FileBackups(path)
+	if len(backups) == 0 {
+		return Backup{}, fmt.Errorf("no trustctl backups of %s", path)
+	}
+	chosen := backups[0]
+	if !at.IsZero() {
+		found := false
+		for _, b := range backups {
+			if b.Time.Equal(at) {
+				chosen, found = b, true
+				break
+			}
+		}
+		if !found {
+			return Backup{}, fmt.Errorf("no backup of %s taken at %d", path, at.Unix())
+		}
+	}
+	data, err := os.ReadFile(chosen.Path)
+	if err != nil {
+		return Backup{}, err
+	}
+	cs := newChangeSet(RunRestore, nil)
+	if err := cs.write(path, data); err != nil {
+		return Backup{}, err
+	}
+	return chosen, checkAndReload(cs)
+}
+
+// RevertRun puts every file run id edited back to the backup taken before the run's
+// first edit of it, then checks the configuration and reloads. Later edits of those
+// files by other runs are lost; they are reported before anything is written.
+func RevertRun(id string) (Run, error) {
+	run, err := LoadRun(id)
+	if err != nil {
+		return run, err
+	}
+	if len(run.Changes) == 0 {
+		return run, fmt.Errorf("run %s changed no files", id)
+	}
+	if runs, err := Runs(); err == nil {
+		for _, later := range runs {
+			if !later.Time.After(run.Time) || later.Reverted {
+				continue
+			}
+			for _, f := range later.Files() {
+				for _, own := range run.Files() {
+					if f == own {
+						ui.Warning("%s was edited again by run %s (%s); those changes are undone as well", f, later.ID, later.Kind)
+					}
+				}
+			}
+		}
+	}
+	contents := make([][]byte, len(run.Changes))
+	for i, c := range run.Changes {
+		if c.Created {
+			continue
+		}
+		if contents[i], err = os.ReadFile(c.Backup); err != nil {
+			return run, fmt.Errorf("backup of %s: %w", c.Path, err)
+		}
+	}
+	cs := newChangeSet(RunRestore, run.Domains)
+	cs.run.Server = run.Server
+	for i, c := range run.Changes {
+		var err error
+		switch {
+		case !c.Created:
+			err = cs.write(c.Path, contents[i])
+		case fileExists(c.Path):
+			// Files the run created, such as a keystore, are removed
+			err = cs.remove(c.Path)
+		}
+		if err != nil {
+			if rerr := cs.revert(); rerr != nil {
+				ui.Error("%v", rerr)
+			}
+			return run, err
+		}
+	}
+	return run, checkAndReload(cs)
+}
+
+// preInstallBackup returns the content of the newest backup of file that references
+// none of paths.
+func preInstallBackup(file string, paths []string) ([]byte, bool) {
+	for _, b := range FileBackups(file) {
+		content, err := os.ReadFile(b.Path)
+		if err == nil && !references(content, paths) {
+			return content, true
+		}
+	}
+	return nil, false
+}
+
+// vhostSpan is the extent of an nginx server block or Apache <VirtualHost> in a file,
+// keyed by its names and whether it serves TLS.
+type vhostSpan struct {
+	Start, End int
+	Key        string
+}
+
+// vhostSpans returns the server blocks or vhosts of a config file. Files with a
+// <VirtualHost> section are read as Apache configs, the rest as nginx configs.
+func vhostSpans(src string) ([]vhostSpan, error) {
+	key := func(names []string, tls bool) string {
+		for i := range names {
+			names[i] = strings.ToLower(names[i])
+		}
+		sort.Strings(names)
+		return fmt.Sprintf("%s tls=%t", strings.Join(names, " "), tls)
+	}
+	var spans []vhostSpan
+	if strings.Contains(strings.ToLower(src), "<virtualhost") {
+		dirs, err := parseApache(src)
+		if err != nil {
+			return nil, err
+		}
+		for _, vh := range apacheVhosts(dirs) {
+			spans = append(spans, vhostSpan{vh.Start, vh.End, key(vh.serverNames(), vh.tls())})
+		}
+		return spans, nil
+	}
+	dirs, err := parseNginx(src)
+	if err != nil {
+		return nil, err
+	}
+	walkNginx(dirs, func(d *nginxDirective) {
+		if d.Name == "server" && d.IsBlock {
+			spans = append(spans, vhostSpan{d.Start, d.End, key(d.serverNames(), d.tls())})
+		}
+	})
+	return spans, nil
+}
+
+// restoreVhosts returns current with each block that references paths replaced by
+// the block with the same names in backup, or removed when backup has none (the
+// installer added it). It reports false when that cannot account for every reference,
+// for example a certificate set outside any vhost, and the whole backup is restored.
+func restoreVhosts(file, current, backup string, paths []string) (string, bool) {
+	cur, err := vhostSpans(current)
+	if err != nil {
+		return "", false
+	}
+	old, err := vhostSpans(backup)
+	if err != nil {
+		return "", false
+	}
+	var edits []textEdit
+	for _, c := range cur {
+		if !references([]byte(current[c.Start:c.End]), paths) {
+			continue
+		}
+		e := textEdit{Start: c.Start, End: c.End}
+		found := false
+		for _, o := range old {
+			if o.Key == c.Key {
+				e.Text, found = backup[o.Start:o.End], true
+				break
+			}
+		}
+		if !found {
+			// Take the blank line the installer put before the block as well
+			if strings.HasSuffix(current[:e.Start], "\n\n") {
+				e.Start -= 2
+			}
+			if strings.HasPrefix(current[e.End:], "\n") {
+				e.End++
+			}
+		}
+		edits = append(edits, e)
+	}
+	out := applyEdits(current, edits)
+	if references([]byte(out), paths) {
+		ui.Debug("install: %s references the certificate outside its vhosts; restoring the whole backup", file)
+		return "", false
+	}
+	return out, true
+}
+
+func references(content []byte, paths []string) bool {
+	for _, p := range paths {
+		if p != "" && strings.Contains(string(content), p) {
+			return true
+		}
+	}
+	return false
+}
+
+// serverOf guesses from a config file's location which server it belongs to.
+func serverOf(path string) string {
+	if strings.Contains(path, "lighttpd") {
+		return "lighttpd"
+	}
+	if strings.Contains(path, "postfix") {
+		return "postfix"
+	}
+	if strings.Contains(path, "dovecot") {
+		return "dovecot"
+	}
+	if strings.Contains(path, "apache") || strings.Contains(path, "httpd") {
+		return "apache"
+	}
+	return "nginx"
+}
@@ -0,0 +1,106 @@
+package install
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// containerProxies own a host port on behalf of a server running in a container.
+var containerProxies = map[string]bool{
+	"docker-proxy": true,
+	"rootlessport": true,
+	"slirp4netns":  true,
+	"conmon":       true,
+}
+
+// socketOwnerServer finds the process listening on :80 or :443 by matching socket
+// inodes from /proc/net/tcp{,6} against /proc/<pid>/fd. Unlike scanning the process
+// list it ignores editors and tails, and it reports servers that live in a container
+// (published through a proxy, or in another mount namespace) as errContainerized,
+// since their configs are not on this host's filesystem.
+func socketOwnerServer() (string, error) {
+	inodes := map[string]bool{}
+	for _, f := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		if err := listeningInodes(f, inodes, 80, 443); err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	if len(inodes) == 0 {
+		return "", errors.New("nothing listens on :80 or :443")
+	}
+
+	selfNS, _ := os.Readlink("/proc/self/ns/mnt")
+	procs, _ := filepath.Glob("/proc/[0-9]*")
+	for _, proc := range procs {
+		fds, err := os.ReadDir(filepath.Join(proc, "fd"))
+		if err != nil {
+			continue // exited, or not ours to read without root
+		}
+		owns := false
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(proc, "fd", fd.Name()))
+			if err == nil && strings.HasPrefix(link, "socket:[") && inodes[link[8:len(link)-1]] {
+				owns = true
+				break
+			}
+		}
+		if !owns {
+			continue
+		}
+		comm, err := os.ReadFile(filepath.Join(proc, "comm"))
+		if err != nil {
+			continue
+		}
+		name := strings.TrimSpace(string(comm))
+		if containerProxies[name] || strings.HasPrefix(name, "containerd-shim") {
+			return "", fmt.Errorf("%w: port owned by %s", errContainerized, name)
+		}
+		if ns, err := os.Readlink(filepath.Join(proc, "ns/mnt")); err == nil && selfNS != "" && ns != selfNS {
+			return "", fmt.Errorf("%w: %s runs in another mount namespace", errContainerized, name)
+		}
+		switch name {
+		case "nginx", "openresty":
+			return "nginx", nil
+		case "apache2", "httpd":
+			return "apache", nil
+		case "lighttpd":
+			return "lighttpd", nil
+		}
+		return "", fmt.Errorf("port owned by unsupported server %s", name)
+	}
+	return "", errors.New("owner of :80/:443 not found")
+}
+
+// listeningInodes adds the inodes of sockets in LISTEN state on any of ports from a /proc/net/tcp-style file.
+func listeningInodes(file string, inodes map[string]bool, ports ...int) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Scan() // header
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		// sl local_address rem_address st tx:rx tr:when retrnsmt uid timeout inode
+		if len(fields) < 10 || fields[3] != "0A" {
+			continue
+		}
+		i := strings.LastIndexByte(fields[1], ':')
+		port, err := strconv.ParseUint(fields[1][i+1:], 16, 16)
+		if err != nil {
+			continue
+		}
+		for _, p := range ports {
+			if int(port) == p {
+				inodes[fields[9]] = true
+			}
+		}
+	}
+	return sc.Err()
+}
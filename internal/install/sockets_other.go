@@ -0,0 +1,10 @@
+//go:build !linux
+
+package install
+
+import "errors"
+
+// socketOwnerServer needs /proc; other platforms rely on their service manager.
+func socketOwnerServer() (string, error) {
+	return "", errors.New("socket inspection not supported on this platform")
+}
@@ -0,0 +1,182 @@
+package install
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// Vhost is the data of a 443 vhost template.
+type Vhost struct {
+	Domain     string // the name being installed
+	ServerName string // nginx: the HTTP block's server_name list; Apache: a single ServerName
+	CertPath   string // full chain
+	KeyPath    string // as the server refers to it; quoted for HSM keys
+	TLSProfile string // profile the TLS directives follow
+	TLS        string // TLS directives of the profile, one per line, tab-indented
+	HTTP2      bool
+	HTTP3      bool
+	Listen     string // nginx: listen directives and those enabling HTTP/2 and HTTP/3; Apache: Protocols for HTTP/2
+}
+
+// Template names, which are also the file names looked up in the templates directory.
+const (
+	nginxTemplate     = "nginx-443.conf.tmpl"
+	apacheTemplate    = "apache-443.conf.tmpl"
+	nginxTLSTemplate  = "nginx-tls.conf.tmpl"
+	apacheTLSTemplate = "apache-tls.conf.tmpl"
+	// lighttpd gets a TLS socket only when none is enabled; vhosts are conditionals
+	lighttpdTemplate    = "lighttpd-443.conf.tmpl"
+	lighttpdTLSTemplate = "lighttpd-tls.conf.tmpl"
+)
+
+// defaultTemplates are used for template names without a file in templatesDir.
+var defaultTemplates = map[string]string{
+	nginxTemplate: `server {
+{{.Listen}}	server_name {{.ServerName}};
+	ssl_certificate {{.CertPath}};
+	ssl_certificate_key {{.KeyPath}};
+{{.TLS}}	# proxy/serve static content as appropriate
+}
+`,
+	apacheTemplate: `<VirtualHost *:443>
+	ServerName {{.ServerName}}
+{{.Listen}}	SSLEngine on
+	SSLCertificateFile {{.CertPath}}
+	SSLCertificateKeyFile {{.KeyPath}}
+{{.TLS}}	# DocumentRoot /var/www/html
+</VirtualHost>
+`,
+	lighttpdTemplate: `$SERVER["socket"] == ":443" {
+	ssl.engine = "enable"
+	ssl.pemfile = "{{.CertPath}}"
+	ssl.privkey = "{{.KeyPath}}"
+{{.TLS}}}
+`,
+	// The TLS templates get a TLSProfile.
+	nginxTLSTemplate: `	# TLS profile {{.Name}}, Mozilla guidelines {{.Version}}
+	ssl_protocols{{range .Protocols}} {{.}}{{end}};
+{{- if .Ciphers}}
+	ssl_ciphers {{.Ciphers}};
+{{- end}}
+	ssl_prefer_server_ciphers {{if .PreferServerCiphers}}on{{else}}off{{end}};
+	ssl_session_timeout {{.SessionTimeout}}s;
+	ssl_session_cache shared:MozSSL:10m;
+	ssl_session_tickets off;
+	ssl_stapling on;
+	ssl_stapling_verify on;
+`,
+	apacheTLSTemplate: `	# TLS profile {{.Name}}, Mozilla guidelines {{.Version}}
+	SSLProtocol -all{{range .Protocols}} +{{.}}{{end}}
+{{- if .Ciphers}}
+	SSLCipherSuite {{.Ciphers}}
+{{- end}}
+	SSLHonorCipherOrder {{if .PreferServerCiphers}}on{{else}}off{{end}}
+	SSLSessionCacheTimeout {{.SessionTimeout}}
+	SSLSessionTickets off
+	# SSLUseStapling needs SSLStaplingCache in the global server config
+	SSLUseStapling on
+`,
+	lighttpdTLSTemplate: `	# TLS profile {{.Name}}, Mozilla guidelines {{.Version}}
+	ssl.openssl.ssl-conf-cmd = ("MinProtocol" => "{{index .Protocols 0}}",
+{{- if .Ciphers}} "CipherString" => "{{.Ciphers}}",{{end}}
+		"Options" => "{{if .PreferServerCiphers}}+{{else}}-{{end}}ServerPreference,-SessionTicket")
+`,
+}
+
+// DefaultTemplatesDir holds user templates unless SetTemplatesDir says otherwise.
+const DefaultTemplatesDir = "/etc/trustctl/templates"
+
+var templatesDir = DefaultTemplatesDir
+
+// SetTemplatesDir makes the installer read 443 vhost templates from dir; "" restores
+// DefaultTemplatesDir.
+func SetTemplatesDir(dir string) {
+	if dir == "" {
+		dir = DefaultTemplatesDir
+	}
+	templatesDir = dir
+}
+
+// TemplateNames lists the templates the installer renders.
+func TemplateNames() []string {
+	return []string{nginxTemplate, apacheTemplate, lighttpdTemplate, nginxTLSTemplate, apacheTLSTemplate, lighttpdTLSTemplate}
+}
+
+// Template returns the text of template name and where it comes from: the file of
+// that name in the templates directory, else the built-in default.
+func Template(name string) (text, source string, err error) {
+	def, ok := defaultTemplates[name]
+	if !ok {
+		return "", "", fmt.Errorf("unknown template %q (expected %s)", name, strings.Join(TemplateNames(), ", "))
+	}
+	path := filepath.Join(templatesDir, name)
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		return string(data), path, nil
+	case errors.Is(err, os.ErrNotExist):
+		return def, "built-in", nil
+	}
+	return "", "", fmt.Errorf("vhost template: %w", err)
+}
+
+// renderVhost executes template name with v. TLS defaults to the directives of
+// v.TLSProfile for the server and Listen to those for v.HTTP2 and v.HTTP3.
+func renderVhost(name string, v Vhost) (string, error) {
+	srv := "nginx"
+	switch name {
+	case apacheTemplate:
+		srv = "apache"
+	case lighttpdTemplate:
+		srv = "lighttpd"
+	}
+	if v.Listen == "" && srv != "lighttpd" {
+		var err error
+		if srv == "apache" {
+			v.Listen, err = apacheListen(v.HTTP2, v.HTTP3)
+		} else {
+			v.Listen, err = nginxListen(v.HTTP2, v.HTTP3)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	if v.TLS == "" {
+		tls, err := TLSDirectives(srv, v.TLSProfile)
+		if err != nil {
+			return "", err
+		}
+		v.TLS = tls
+	}
+	return render(name, v)
+}
+
+// render executes template name, read from templatesDir when that file exists, with
+// data. A template that does not parse or execute fails the installation rather than
+// silently falling back.
+func render(name string, data any) (string, error) {
+	text, source, err := Template(name)
+	if err != nil {
+		return "", err
+	}
+	t, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("template %s (%s): %w", name, source, err)
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("template %s (%s): %w", name, source, err)
+	}
+	out := b.String()
+	if strings.TrimSpace(out) == "" {
+		return "", fmt.Errorf("template %s (%s) renders nothing", name, source)
+	}
+	if !strings.HasSuffix(out, "\n") {
+		out += "\n"
+	}
+	return out, nil
+}
@@ -0,0 +1,478 @@
+package install
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/trustctl/trustctl/internal/bundle"
+	"github.com/trustctl/trustctl/internal/certinfo"
+	"github.com/trustctl/trustctl/internal/keygen"
+	"github.com/trustctl/trustctl/internal/keystore"
+	"github.com/trustctl/trustctl/internal/paths"
+	"github.com/trustctl/trustctl/internal/ui"
+)
+
+// tomcatConfPaths are searched for server.xml after $CATALINA_BASE and $CATALINA_HOME.
+var tomcatConfPaths = []string{
+	"/etc/tomcat10/server.xml",
+	"/etc/tomcat9/server.xml",
+	"/etc/tomcat/server.xml",
+	"/etc/tomcat8/server.xml",
+	"/opt/tomcat/conf/server.xml",
+	"/usr/local/tomcat/conf/server.xml",
+	"/usr/share/tomcat/conf/server.xml",
+}
+
+// tomcatServices are the systemd units Tomcat is packaged as, newest first.
+var tomcatServices = []string{"tomcat10", "tomcat9", "tomcat", "tomcat8"}
+
+// tomcatAttrs names the certificate attributes of a <Certificate> (Tomcat 8.5 and
+// later) or of a <Connector> in the older style.
+type tomcatAttrs struct {
+	Keystore, Password, Type, Alias string // JSSE keystore
+	Cert, Key, Chain                string // PEM files (APR/OpenSSL)
+}
+
+var (
+	tomcatCertificateAttrs = tomcatAttrs{"certificateKeystoreFile", "certificateKeystorePassword", "certificateKeystoreType", "certificateKeyAlias",
+		"certificateFile", "certificateKeyFile", "certificateChainFile"}
+	tomcatConnectorAttrs = tomcatAttrs{"keystoreFile", "keystorePass", "keystoreType", "keyAlias",
+		"SSLCertificateFile", "SSLCertificateKeyFile", "SSLCertificateChainFile"}
+)
+
+// tomcatServerXML returns the server.xml to edit: the one in the config directory
+// when set, else $CATALINA_BASE's, $CATALINA_HOME's or the first of tomcatConfPaths.
+func tomcatServerXML() (string, error) {
+	var candidates []string
+	if configDirOverride != "" {
+		candidates = []string{filepath.Join(configDirOverride, "server.xml")}
+	} else {
+		for _, env := range []string{"CATALINA_BASE", "CATALINA_HOME"} {
+			if dir := os.Getenv(env); dir != "" {
+				candidates = append(candidates, filepath.Join(dir, "conf", "server.xml"))
+			}
+		}
+		candidates = append(candidates, tomcatConfPaths...)
+	}
+	for _, c := range candidates {
+		if fileExists(c) {
+			return c, nil
+		}
+	}
+	return "", fmt.Errorf("no Tomcat server.xml found (looked at %s)", strings.Join(candidates, ", "))
+}
+
+// catalinaBase is the directory relative keystore paths in serverXML resolve against.
+func catalinaBase(serverXML string) string {
+	if dir := os.Getenv("CATALINA_BASE"); dir != "" && configDirOverride == "" {
+		return dir
+	}
+	conf := filepath.Dir(serverXML)
+	if filepath.Base(conf) == "conf" {
+		return filepath.Dir(conf)
+	}
+	// Debian and Ubuntu: /var/lib/tomcat9/conf links to /etc/tomcat9
+	if lib := filepath.Join("/var/lib", filepath.Base(conf)); fileExists(filepath.Join(lib, "conf")) {
+		return lib
+	}
+	return filepath.Dir(conf)
+}
+
+func tomcatService() string {
+	for _, s := range tomcatServices {
+		for _, dir := range []string{"/etc/systemd/system", "/lib/systemd/system", "/usr/lib/systemd/system"} {
+			if fileExists(filepath.Join(dir, s+".service")) {
+				return s
+			}
+		}
+	}
+	return "tomcat"
+}
+
+// tomcatTarget is an element whose attributes name a certificate: a <Certificate>,
+// an <SSLHostConfig> using the shorthand attributes, or an old-style <Connector>.
+type tomcatTarget struct {
+	elem      *xmlElement
+	attrs     tomcatAttrs
+	connector *xmlElement
+}
+
+func (t tomcatTarget) value(name string) string {
+	if a := t.elem.attr(name); a != nil {
+		return a.Value
+	}
+	return ""
+}
+
+// pem reports whether the target names PEM files rather than a keystore.
+func (t tomcatTarget) pem() bool {
+	return t.elem.attr(t.attrs.Cert) != nil
+}
+
+// tomcatTargets returns the certificate elements of the TLS connectors that serve
+// domains: per connector the <SSLHostConfig> whose hostName matches one of them, else
+// the default one, and in it the <Certificate> of the key's type.
+func tomcatTargets(elems []*xmlElement, domains []string, keyType string) []tomcatTarget {
+	var out []tomcatTarget
+	walkXML(elems, func(c *xmlElement) {
+		if c.Name != "Connector" {
+			return
+		}
+		hosts := c.find("SSLHostConfig")
+		if len(hosts) == 0 {
+			if a := c.attr("SSLEnabled"); (a != nil && strings.EqualFold(a.Value, "true")) || c.attr("keystoreFile") != nil || c.attr("SSLCertificateFile") != nil {
+				out = append(out, tomcatTarget{elem: c, attrs: tomcatConnectorAttrs, connector: c})
+			}
+			return
+		}
+		defaultName := "_default_"
+		if a := c.attr("defaultSSLHostConfigName"); a != nil {
+			defaultName = a.Value
+		}
+		var host *xmlElement
+		for _, h := range hosts {
+			name := "_default_"
+			if a := h.attr("hostName"); a != nil {
+				name = a.Value
+			}
+			for _, d := range domains {
+				if nameMatches(name, d) {
+					host = h
+				}
+			}
+			if host == nil && name == defaultName {
+				host = h
+			}
+		}
+		if host == nil {
+			return
+		}
+		certs := host.find("Certificate")
+		if len(certs) == 0 {
+			out = append(out, tomcatTarget{elem: host, attrs: tomcatCertificateAttrs, connector: c})
+			return
+		}
+		cert := certs[0]
+		for _, e := range certs {
+			if t := e.attr("type"); t != nil && strings.EqualFold(t.Value, keyType) {
+				cert = e
+			}
+		}
+		out = append(out, tomcatTarget{elem: cert, attrs: tomcatCertificateAttrs, connector: c})
+	})
+	return out
+}
+
+// deployTomcat converts certPath and keyPath into the keystore of the Tomcat
+// connectors serving domains (PKCS#12, or JKS through keytool when the connector
+// uses one), or points connectors configured with PEM files at them, updates
+// server.xml, restarts Tomcat and waits until it serves the certificate. Without a
+// TLS connector one is added on port 8443. Failures restore every file.
+func deployTomcat(domains []string, certPath, keyPath string, opts DeployOptions) error {
+	if opts.HTTP3 || opts.HSTS > 0 {
+		return errors.New("HTTP/3 and HSTS are not supported for Tomcat")
+	}
+	if keystore.IsURI(keyPath) {
+		return errors.New("Tomcat needs the private key in a file, not a key URI")
+	}
+	serverXML, err := tomcatServerXML()
+	if err != nil {
+		return err
+	}
+	info, err := certinfo.ParseFile(certPath)
+	if err != nil {
+		return fmt.Errorf("%s: %w", certPath, err)
+	}
+	key, err := keygen.LoadPrivateKey(keyPath)
+	if err != nil {
+		return fmt.Errorf("failed to load private key: %w", err)
+	}
+	keyType := "RSA"
+	if _, ok := key.Public().(*ecdsa.PublicKey); ok {
+		keyType = "EC"
+	}
+	content, err := os.ReadFile(serverXML)
+	if err != nil {
+		return err
+	}
+	src := string(content)
+	elems, err := parseXML(src)
+	if err != nil {
+		return fmt.Errorf("%s: %w", serverXML, err)
+	}
+	base := catalinaBase(serverXML)
+
+	cs := newChangeSet(RunInstall, domains)
+	cs.run.Server = "tomcat"
+	fail := func(err error) error {
+		if rerr := cs.revert(); rerr != nil {
+			ui.Error("%v", rerr)
+		}
+		return err
+	}
+	var edits []textEdit
+	set := func(e *xmlElement, name, value string) {
+		if edit, ok := setXMLAttr(src, e, name, value); ok {
+			edits = append(edits, edit)
+		}
+	}
+	port := "8443"
+	targets := tomcatTargets(elems, domains, keyType)
+	if len(targets) == 0 {
+		ks := filepath.Join(filepath.Dir(serverXML), "trustctl-"+domains[0]+".p12")
+		password, err := randomPassword()
+		if err != nil {
+			return fail(err)
+		}
+		if err := writeTomcatKeystore(cs, ks, "PKCS12", info, key, password, "tomcat", serverXML); err != nil {
+			return fail(err)
+		}
+		edit, err := tomcatConnector(src, elems, ks, password, keyType)
+		if err != nil {
+			return fail(err)
+		}
+		edits = append(edits, edit)
+		ui.Info("No TLS connector in %s; adding one on port %s with keystore %s", serverXML, port, ks)
+	}
+	written := map[string]bool{}
+	for _, t := range targets {
+		if p := t.connector.attr("port"); p != nil {
+			port = p.Value
+		}
+		if t.pem() {
+			set(t.elem, t.attrs.Cert, certPath)
+			set(t.elem, t.attrs.Key, keyPath)
+			switch {
+			case t.elem.attr(t.attrs.Chain) == nil:
+			case len(info.Chain) == 0:
+				// The old chain would not belong to the new certificate
+				if edit, ok := removeXMLAttr(src, t.elem, t.attrs.Chain); ok {
+					edits = append(edits, edit)
+				}
+			default:
+				chain := filepath.Join(filepath.Dir(serverXML), "trustctl-"+domains[0]+"-chain.pem")
+				if !written[chain] {
+					var data []byte
+					for _, c := range info.Chain {
+						data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})...)
+					}
+					if err := writeLike(cs, chain, data, serverXML); err != nil {
+						return fail(err)
+					}
+					written[chain] = true
+				}
+				set(t.elem, t.attrs.Chain, chain)
+			}
+			ui.Info("Pointed the PEM certificate of the connector on port %s (%s:%d) at %s", port, serverXML, t.elem.Line, certPath)
+			continue
+		}
+		ks := t.value(t.attrs.Keystore)
+		if ks == "" {
+			ks = filepath.Join(filepath.Dir(serverXML), "trustctl-"+domains[0]+".p12")
+			set(t.elem, t.attrs.Keystore, ks)
+		}
+		ks = strings.NewReplacer("${catalina.base}", base, "${catalina.home}", base).Replace(ks)
+		if !filepath.IsAbs(ks) {
+			ks = filepath.Join(base, ks)
+		}
+		storeType := t.value(t.attrs.Type)
+		if storeType == "" {
+			storeType = "PKCS12"
+			if strings.HasSuffix(strings.ToLower(ks), ".jks") {
+				storeType = "JKS"
+			} else {
+				set(t.elem, t.attrs.Type, storeType)
+			}
+		}
+		password := t.value(t.attrs.Password)
+		switch {
+		case strings.Contains(password, "${"):
+			return fail(fmt.Errorf("%s:%d: the keystore password %s is a property placeholder; set it in server.xml or install the keystore yourself", serverXML, t.elem.Line, password))
+		case password == "" && fileExists(ks):
+			password = "changeit" // Tomcat's default
+		case password == "":
+			var err error
+			if password, err = randomPassword(); err != nil {
+				return fail(err)
+			}
+			set(t.elem, t.attrs.Password, password)
+		}
+		alias := t.value(t.attrs.Alias)
+		if alias == "" {
+			alias = "tomcat"
+		}
+		if !written[ks] {
+			if err := writeTomcatKeystore(cs, ks, storeType, info, key, password, alias, serverXML); err != nil {
+				return fail(err)
+			}
+			written[ks] = true
+		}
+		ui.Info("Wrote the certificate into the %s keystore %s of the connector on port %s", storeType, ks, port)
+	}
+	if len(edits) > 0 {
+		out := applyEdits(src, edits)
+		if err := checkXML(out); err != nil {
+			return fail(fmt.Errorf("edited %s is not well-formed, not writing it: %w", serverXML, err))
+		}
+		if err := cs.write(serverXML, []byte(out)); err != nil {
+			return fail(err)
+		}
+	}
+
+	if !serviceRunning("tomcat") {
+		ui.Success("Tomcat is not running; updated its keystore and %s. Restart: %s", serverXML, reloadHint("tomcat"))
+		return nil
+	}
+	if opts.NoReload {
+		ui.Success("Updated Tomcat's keystore and %s; restart with: %s", serverXML, reloadHint("tomcat"))
+		return nil
+	}
+	ui.StepStart("Restarting Tomcat...")
+	if out, err := reload("tomcat"); err != nil {
+		return rollback("tomcat", cs, fmt.Errorf("restart failed: %v: %s", err, strings.TrimSpace(string(out))))
+	}
+	if opts.VerifyAddr == "" {
+		opts.VerifyAddr = net.JoinHostPort("127.0.0.1", port)
+	}
+	if opts.Timeout == 0 {
+		// Tomcat deploys its web applications before the connectors start
+		opts.Timeout = 2 * time.Minute
+	}
+	if err := waitForReload("tomcat", nil, probeAll(domains, opts.VerifyAddr), leafFingerprint(certPath), opts); err != nil {
+		return rollback("tomcat", cs, err)
+	}
+	ui.Success("Tomcat restarted and serving the new certificate on %s", opts.VerifyAddr)
+	return nil
+}
+
+// tomcatConnector returns the edit adding a TLS connector on port 8443 with the
+// keystore ks to the <Service> of server.xml, after its last connector.
+func tomcatConnector(src string, elems []*xmlElement, ks, password, keyType string) (textEdit, error) {
+	var service *xmlElement
+	walkXML(elems, func(e *xmlElement) {
+		if e.Name == "Service" && service == nil {
+			service = e
+		}
+	})
+	if service == nil || service.Empty {
+		return textEdit{}, errors.New("server.xml has no <Service> to add a TLS connector to")
+	}
+	at, indent := service.TagEnd, "\n    "
+	if conns := service.find("Connector"); len(conns) > 0 {
+		last := conns[len(conns)-1]
+		at, indent = last.End, "\n"+indentOf(src, last.Start)
+	}
+	text := indent + `<Connector port="8443" protocol="org.apache.coyote.http11.Http11NioProtocol" SSLEnabled="true" maxThreads="150">` +
+		indent + `    <SSLHostConfig>` +
+		indent + `        <Certificate certificateKeystoreFile="` + xmlEscape(ks) + `" certificateKeystorePassword="` + xmlEscape(password) +
+		`" certificateKeystoreType="PKCS12" type="` + keyType + `" />` +
+		indent + `    </SSLHostConfig>` +
+		indent + `</Connector>`
+	return textEdit{Start: at, End: at, Text: text}, nil
+}
+
+// writeTomcatKeystore writes the certificate chain and key as a keystore of
+// storeType to path. New keystores get serverXML's owner and mode.
+func writeTomcatKeystore(cs *changeSet, path, storeType string, info *certinfo.Info, key crypto.Signer, password, alias, serverXML string) error {
+	certs := append([]*x509.Certificate{info.Leaf}, info.Chain...)
+	data, err := bundle.Export(bundle.FormatPKCS12, certs, key, []byte(password), alias)
+	if err != nil {
+		return err
+	}
+	switch strings.ToUpper(storeType) {
+	case "PKCS12":
+	case "JKS":
+		if data, err = pkcs12ToJKS(data, password); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("keystore type %s of %s is not supported (expected PKCS12 or JKS)", storeType, path)
+	}
+	return writeLike(cs, path, data, serverXML)
+}
+
+// writeLike writes path through cs; a new file gets the owner and mode of like, less
+// access for others, so the server that reads like can read it too.
+func writeLike(cs *changeSet, path string, data []byte, like string) error {
+	created := !fileExists(path)
+	if err := cs.write(path, data); err != nil {
+		return err
+	}
+	if fi, err := os.Stat(like); err == nil && created {
+		os.Chmod(path, fi.Mode().Perm()&^0007)
+		keepOwner(path, fi)
+	}
+	return nil
+}
+
+// pkcs12ToJKS converts a PKCS#12 keystore to JKS with keytool.
+func pkcs12ToJKS(p12 []byte, password string) ([]byte, error) {
+	keytool, err := exec.LookPath("keytool")
+	if err != nil {
+		if home := os.Getenv("JAVA_HOME"); home != "" {
+			keytool, err = filepath.Join(home, "bin", "keytool"), nil
+		}
+	}
+	if err != nil {
+		return nil, errors.New("the connector uses a JKS keystore, which needs keytool (set JAVA_HOME); or switch it to PKCS12")
+	}
+	dir, err := os.MkdirTemp("", "trustctl-keystore")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	src, dst := filepath.Join(dir, "in.p12"), filepath.Join(dir, "out.jks")
+	if err := os.WriteFile(src, p12, 0600); err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(keytool, "-importkeystore", "-noprompt",
+		"-srckeystore", src, "-srcstoretype", "PKCS12", "-srcstorepass:env", "TRUSTCTL_STOREPASS",
+		"-destkeystore", dst, "-deststoretype", "JKS", "-deststorepass:env", "TRUSTCTL_STOREPASS", "-destkeypass:env", "TRUSTCTL_STOREPASS")
+	cmd.Env = append(os.Environ(), "TRUSTCTL_STOREPASS="+password)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("keytool -importkeystore failed: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return os.ReadFile(dst)
+}
+
+// randomPassword returns a password for a keystore trustctl creates.
+func randomPassword() (string, error) {
+	b := make([]byte, 18)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate a keystore password: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// tomcatRunning reports whether a Tomcat unit is active or a Catalina process runs.
+func tomcatRunning() bool {
+	if systemdActive(tomcatService()) {
+		return true
+	}
+	return exec.Command("pgrep", "-f", "org.apache.catalina.startup.Bootstrap").Run() == nil
+}
+
+// restartTomcat restarts Tomcat, which only reads its keystores and server.xml at
+// startup. Without root the unit cannot be restarted; a reload command is needed.
+func restartTomcat() ([]byte, error) {
+	switch {
+	case runtime.GOOS == "darwin":
+		return exec.Command("brew", "services", "restart", "tomcat").CombinedOutput()
+	case paths.Rootless():
+		return nil, errors.New("restarting Tomcat needs root; set one with --reload-command")
+	}
+	return exec.Command("systemctl", "restart", tomcatService()).CombinedOutput()
+}
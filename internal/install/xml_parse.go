@@ -0,0 +1,230 @@
+package install
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// xmlElement is one element of an XML configuration file such as Tomcat's server.xml.
+// Like nginxDirective it keeps source offsets, so attributes can be edited in place
+// without reformatting the file or losing its comments.
+type xmlElement struct {
+	Name     string
+	Attrs    []*xmlAttr
+	Start    int // offset of '<'
+	TagEnd   int // offset just past the '>' of the start tag
+	End      int // offset just past the end tag; TagEnd for an empty element
+	Empty    bool
+	Line     int
+	Children []*xmlElement
+	Parent   *xmlElement
+}
+
+// xmlAttr is an attribute; Start and End delimit its raw value inside the quotes.
+type xmlAttr struct {
+	Name       string
+	Value      string // entities decoded
+	Start, End int
+}
+
+// parseXML parses the elements of src. Comments, processing instructions, CDATA and
+// the DOCTYPE are skipped, so commented-out elements are not returned.
+func parseXML(src string) ([]*xmlElement, error) {
+	root := &xmlElement{}
+	cur := root
+	line := 1
+	skip := func(pos int, end string) (int, error) {
+		i := strings.Index(src[pos:], end)
+		if i < 0 {
+			return 0, fmt.Errorf("line %d: %q not closed", line, strings.TrimSuffix(end, ">"))
+		}
+		line += strings.Count(src[pos:pos+i], "\n")
+		return pos + i + len(end), nil
+	}
+	for pos := 0; pos < len(src); {
+		lt := strings.IndexByte(src[pos:], '<')
+		if lt < 0 {
+			break
+		}
+		line += strings.Count(src[pos:pos+lt], "\n")
+		pos += lt
+		var err error
+		switch {
+		case strings.HasPrefix(src[pos:], "<!--"):
+			pos, err = skip(pos, "-->")
+		case strings.HasPrefix(src[pos:], "<?"):
+			pos, err = skip(pos, "?>")
+		case strings.HasPrefix(src[pos:], "<![CDATA["):
+			pos, err = skip(pos, "]]>")
+		case strings.HasPrefix(src[pos:], "<!"):
+			pos, err = skip(pos, ">")
+		case strings.HasPrefix(src[pos:], "</"):
+			start := pos
+			if pos, err = skip(pos, ">"); err != nil {
+				break
+			}
+			name := strings.TrimSpace(src[start+2 : pos-1])
+			if cur == root || name != cur.Name {
+				return nil, fmt.Errorf("line %d: unexpected </%s>", line, name)
+			}
+			cur.End = pos
+			cur = cur.Parent
+		default:
+			var e *xmlElement
+			if e, pos, err = parseXMLTag(src, pos, &line); err != nil {
+				break
+			}
+			e.Parent = cur
+			cur.Children = append(cur.Children, e)
+			if !e.Empty {
+				cur = e
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cur != root {
+		return nil, fmt.Errorf("<%s> opened on line %d is not closed", cur.Name, cur.Line)
+	}
+	for _, e := range root.Children {
+		e.Parent = nil
+	}
+	return root.Children, nil
+}
+
+// parseXMLTag parses the start tag at src[pos], which is '<'.
+func parseXMLTag(src string, pos int, line *int) (*xmlElement, int, error) {
+	e := &xmlElement{Start: pos, Line: *line}
+	i := pos + 1
+	for i < len(src) && !strings.ContainsRune(" \t\r\n/>", rune(src[i])) {
+		i++
+	}
+	e.Name = src[pos+1 : i]
+	if e.Name == "" {
+		return nil, 0, fmt.Errorf("line %d: empty tag", *line)
+	}
+	for {
+		for i < len(src) && strings.ContainsRune(" \t\r\n", rune(src[i])) {
+			if src[i] == '\n' {
+				*line++
+			}
+			i++
+		}
+		switch {
+		case i >= len(src):
+			return nil, 0, fmt.Errorf("line %d: <%s> not closed", e.Line, e.Name)
+		case strings.HasPrefix(src[i:], "/>"):
+			e.Empty = true
+			e.TagEnd, e.End = i+2, i+2
+			return e, i + 2, nil
+		case src[i] == '>':
+			e.TagEnd = i + 1
+			return e, i + 1, nil
+		}
+		eq := strings.IndexByte(src[i:], '=')
+		if eq < 0 {
+			return nil, 0, fmt.Errorf("line %d: attribute without value in <%s>", *line, e.Name)
+		}
+		name := strings.TrimSpace(src[i : i+eq])
+		i += eq + 1
+		for i < len(src) && strings.ContainsRune(" \t\r\n", rune(src[i])) {
+			i++
+		}
+		if i >= len(src) || (src[i] != '"' && src[i] != '\'') {
+			return nil, 0, fmt.Errorf("line %d: unquoted value of %s in <%s>", *line, name, e.Name)
+		}
+		end := strings.IndexByte(src[i+1:], src[i])
+		if end < 0 {
+			return nil, 0, fmt.Errorf("line %d: value of %s in <%s> not closed", *line, name, e.Name)
+		}
+		raw := src[i+1 : i+1+end]
+		*line += strings.Count(raw, "\n")
+		e.Attrs = append(e.Attrs, &xmlAttr{Name: name, Value: xmlUnescape(raw), Start: i + 1, End: i + 1 + end})
+		i += end + 2
+	}
+}
+
+// attr returns the attribute name of e, or nil.
+func (e *xmlElement) attr(name string) *xmlAttr {
+	for _, a := range e.Attrs {
+		if a.Name == name {
+			return a
+		}
+	}
+	return nil
+}
+
+// find returns the child elements of e named name.
+func (e *xmlElement) find(name string) []*xmlElement {
+	var out []*xmlElement
+	for _, c := range e.Children {
+		if c.Name == name {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// walkXML calls fn for every element in elems and their children, depth first.
+func walkXML(elems []*xmlElement, fn func(*xmlElement)) {
+	for _, e := range elems {
+		fn(e)
+		walkXML(e.Children, fn)
+	}
+}
+
+// setXMLAttr returns the edit giving e the attribute name with value, and false when
+// it already has it. New attributes are appended to the start tag.
+func setXMLAttr(src string, e *xmlElement, name, value string) (textEdit, bool) {
+	if a := e.attr(name); a != nil {
+		if a.Value == value {
+			return textEdit{}, false
+		}
+		return textEdit{Start: a.Start, End: a.End, Text: xmlEscape(value)}, true
+	}
+	at := e.TagEnd - 1
+	if e.Empty {
+		at--
+	}
+	for at > e.Start && strings.ContainsRune(" \t\r\n", rune(src[at-1])) {
+		at--
+	}
+	return textEdit{Start: at, End: at, Text: " " + name + `="` + xmlEscape(value) + `"`}, true
+}
+
+// removeXMLAttr returns the edit removing the attribute name from e, and false when
+// e has none.
+func removeXMLAttr(src string, e *xmlElement, name string) (textEdit, bool) {
+	a := e.attr(name)
+	if a == nil {
+		return textEdit{}, false
+	}
+	start := e.Start + strings.LastIndex(src[e.Start:a.Start], name)
+	for start > e.Start && strings.ContainsRune(" \t\r\n", rune(src[start-1])) {
+		start--
+	}
+	return textEdit{Start: start, End: a.End + 1}, true
+}
+
+// checkXML reports whether src is well-formed XML.
+func checkXML(src string) error {
+	d := xml.NewDecoder(strings.NewReader(src))
+	for {
+		if _, err := d.Token(); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+var (
+	xmlEscaper   = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;", "'", "&apos;")
+	xmlUnescaper = strings.NewReplacer("&lt;", "<", "&gt;", ">", "&quot;", `"`, "&apos;", "'", "&amp;", "&")
+)
+
+func xmlEscape(s string) string   { return xmlEscaper.Replace(s) }
+func xmlUnescape(s string) string { return xmlUnescaper.Replace(s) }
@@ -0,0 +1,165 @@
+// Package keycheck refuses key material that is known to be compromised: Debian
+// OpenSSL (CVE-2008-0166) keys, keys with the ROCA structure (CVE-2017-15361) and,
+// when enabled, keys listed by pwnedkeys.com.
+package keycheck
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/trustctl/trustctl/internal/httpclient"
+)
+
+// ErrCompromised is wrapped by every rejection.
+var ErrCompromised = errors.New("key is known to be compromised")
+
+// BlocklistDirs are searched for Debian openssl-blacklist files (blacklist.RSA-<bits>).
+// TRUSTCTL_WEAKKEYS_DIR is searched first.
+var BlocklistDirs = []string{"/usr/share/openssl-blacklist", "/usr/local/share/openssl-blacklist"}
+
+// PwnedKeysURL is the pwnedkeys.com v1 endpoint; the SPKI SHA-256 in hex is appended.
+var PwnedKeysURL = "https://v1.pwnedkeys.com/"
+
+var pwnedLookup bool
+
+// SetPwnedKeysLookup enables the pwnedkeys.com query. Only the hash of the public key is sent.
+func SetPwnedKeysLookup(on bool) {
+	pwnedLookup = on
+}
+
+// Check returns an error wrapping ErrCompromised when pub is known to be compromised.
+// A failed pwnedkeys.com lookup is reported through warn (if non-nil) rather than refused.
+func Check(pub crypto.PublicKey, warn func(format string, a ...interface{})) error {
+	if k, ok := pub.(*rsa.PublicKey); ok {
+		if rocaVulnerable(k.N) {
+			return fmt.Errorf("%w: RSA modulus has the ROCA (CVE-2017-15361) structure", ErrCompromised)
+		}
+		listed, err := debianBlocklisted(k)
+		if err != nil && warn != nil {
+			warn("could not read Debian weak key list: %v", err)
+		}
+		if listed {
+			return fmt.Errorf("%w: key is on the Debian weak key list (CVE-2008-0166)", ErrCompromised)
+		}
+	}
+	if pwnedLookup {
+		pwned, err := pwnedKeys(pub)
+		if err != nil {
+			if warn != nil {
+				warn("pwnedkeys.com lookup failed: %v", err)
+			}
+		} else if pwned {
+			return fmt.Errorf("%w: key is listed on pwnedkeys.com", ErrCompromised)
+		}
+	}
+	return nil
+}
+
+// CheckSigner is Check for a private key.
+func CheckSigner(key crypto.Signer, warn func(format string, a ...interface{})) error {
+	return Check(key.Public(), warn)
+}
+
+// ROCA: Infineon-generated primes have the form k*M + (65537^a mod M), so the
+// modulus modulo each small prime lies in the subgroup generated by 65537.
+var rocaPrimes = []int64{3, 5, 7, 11, 13, 17, 19, 23, 29, 31, 37, 41, 43, 47, 53, 59, 61, 67, 71, 73,
+	79, 83, 89, 97, 101, 103, 107, 109, 113, 127, 131, 137, 139, 149, 151, 157, 163, 167}
+
+var rocaSubgroups = func() []map[int64]bool {
+	out := make([]map[int64]bool, len(rocaPrimes))
+	for i, p := range rocaPrimes {
+		set := map[int64]bool{}
+		g := int64(65537) % p
+		for x := int64(1); !set[x]; x = x * g % p {
+			set[x] = true
+		}
+		out[i] = set
+	}
+	return out
+}()
+
+func rocaVulnerable(n *big.Int) bool {
+	m := new(big.Int)
+	for i, p := range rocaPrimes {
+		m.Mod(n, big.NewInt(p))
+		if !rocaSubgroups[i][m.Int64()] {
+			return false
+		}
+	}
+	return true
+}
+
+// debianBlocklisted looks the modulus up in openssl-blacklist files, whose lines
+// are the last 20 hex digits of SHA-1("Modulus=<HEX>\n").
+func debianBlocklisted(k *rsa.PublicKey) (bool, error) {
+	name := fmt.Sprintf("blacklist.RSA-%d", k.N.BitLen())
+	dirs := BlocklistDirs
+	if d := os.Getenv("TRUSTCTL_WEAKKEYS_DIR"); d != "" {
+		dirs = append([]string{d}, dirs...)
+	}
+	sum := sha1.Sum([]byte("Modulus=" + strings.ToUpper(k.N.Text(16)) + "\n"))
+	want := hex.EncodeToString(sum[:])[20:]
+	for _, dir := range dirs {
+		f, err := os.Open(filepath.Join(dir, name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			if strings.TrimSpace(sc.Text()) == want {
+				f.Close()
+				return true, nil
+			}
+		}
+		err = sc.Err()
+		f.Close()
+		if err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// pwnedKeys asks pwnedkeys.com about the key: 404 means unknown, 200 means compromised.
+func pwnedKeys(pub crypto.PublicKey) (bool, error) {
+	switch pub.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+	default:
+		return false, nil
+	}
+	spki, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return false, err
+	}
+	sum := sha256.Sum256(spki)
+	client := httpclient.New(10 * time.Second)
+	resp, err := client.Get(PwnedKeysURL + hex.EncodeToString(sum[:]))
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, fmt.Errorf("unexpected status %s", resp.Status)
+}
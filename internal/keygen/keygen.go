@@ -1,53 +1,230 @@
 package keygen
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
-	"crypto/x509/pkix"
 	"encoding/pem"
+	"errors"
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
+	"strings"
+
+	"github.com/trustctl/trustctl/internal/cryptopolicy"
+)
+
+// Key types accepted by GenerateKey, in the notation of --key-type and metadata.
+const (
+	RSA2048 = "rsa2048"
+	RSA3072 = "rsa3072"
+	RSA4096 = "rsa4096"
+	EC256   = "ec256"
+	EC384   = "ec384"
+)
+
+// DefaultKeyType is used when none is configured, including for certificates
+// whose metadata predates per-certificate key types.
+const DefaultKeyType = RSA2048
+
+// KeyTypes lists the supported key types.
+var KeyTypes = []string{RSA2048, RSA3072, RSA4096, EC256, EC384}
+
+// ParseKeyType normalizes a key type name; empty selects DefaultKeyType, or RSA of
+// the configured minimum key size when that is larger.
+func ParseKeyType(s string) (string, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		if min := cryptopolicy.MinRSAKeySize(); min > 2048 {
+			return RSAKeyType(min)
+		}
+		return DefaultKeyType, nil
+	}
+	for _, t := range KeyTypes {
+		if s == t {
+			return t, nil
+		}
+	}
+	return "", fmt.Errorf("unknown key type %q (supported: %s)", s, strings.Join(KeyTypes, ", "))
+}
+
+// Private key file formats accepted by SavePrivateKey, in the notation of --key-format
+// and metadata. PKCS1 is the traditional per-algorithm encoding: "RSA PRIVATE KEY"
+// (PKCS#1) for RSA and "EC PRIVATE KEY" (SEC 1) for ECDSA. EncryptedPKCS8 encrypts
+// the key under the key passphrase (see SetPassphraseFunc).
+const (
+	PKCS8          = "pkcs8"
+	PKCS1          = "pkcs1"
+	EncryptedPKCS8 = "pkcs8-encrypted"
 )
 
-// GeneratePrivateKey creates a 2048-bit RSA private key
-func GeneratePrivateKey() (*rsa.PrivateKey, error) {
-	return rsa.GenerateKey(rand.Reader, 2048)
+// DefaultKeyFormat is used for new certificates: PKCS#8 "PRIVATE KEY" is what Java
+// and current OpenSSL policies expect. Metadata without a key format predates the
+// option and keeps PKCS1, which is what was written then.
+const DefaultKeyFormat = PKCS8
+
+// KeyFormats lists the supported private key file formats.
+var KeyFormats = []string{PKCS8, PKCS1, EncryptedPKCS8}
+
+// ParseKeyFormat normalizes a key format name; empty selects DefaultKeyFormat.
+func ParseKeyFormat(s string) (string, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return DefaultKeyFormat, nil
+	}
+	for _, f := range KeyFormats {
+		if s == f {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unknown key format %q (supported: %s)", s, strings.Join(KeyFormats, ", "))
+}
+
+// RSAKeyType returns the key type for an RSA key of bits (2048, 3072 or 4096).
+func RSAKeyType(bits int) (string, error) {
+	t := fmt.Sprintf("rsa%d", bits)
+	for _, k := range KeyTypes {
+		if k == t {
+			return t, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported RSA key size %d (supported: 2048, 3072, 4096)", bits)
+}
+
+// RSABits returns the modulus size of an RSA key type, 0 for other key types.
+func RSABits(keyType string) int {
+	var bits int
+	if _, err := fmt.Sscanf(keyType, "rsa%d", &bits); err != nil {
+		return 0
+	}
+	return bits
+}
+
+// Describe returns a human-readable name for a key type, e.g. "2048-bit RSA".
+func Describe(keyType string) string {
+	switch keyType {
+	case RSA2048, RSA3072, RSA4096:
+		return keyType[3:] + "-bit RSA"
+	case EC256:
+		return "ECDSA P-256"
+	case EC384:
+		return "ECDSA P-384"
+	}
+	return keyType
+}
+
+// CheckKeyType checks a key type against the crypto policy, so that a type the
+// policy refuses fails before any CA is contacted.
+func CheckKeyType(keyType string) error {
+	if bits := RSABits(keyType); bits > 0 {
+		return cryptopolicy.CheckRSAKeySize(bits)
+	}
+	switch keyType {
+	case EC256:
+		return cryptopolicy.CheckCurve(elliptic.P256())
+	case EC384:
+		return cryptopolicy.CheckCurve(elliptic.P384())
+	}
+	return nil
+}
+
+// GenerateKey creates a private key of the given type.
+func GenerateKey(keyType string) (crypto.Signer, error) {
+	keyType, err := ParseKeyType(keyType)
+	if err != nil {
+		return nil, err
+	}
+	switch keyType {
+	case EC256:
+		if err := cryptopolicy.CheckCurve(elliptic.P256()); err != nil {
+			return nil, err
+		}
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case EC384:
+		if err := cryptopolicy.CheckCurve(elliptic.P384()); err != nil {
+			return nil, err
+		}
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	}
+	bits := RSABits(keyType)
+	if err := cryptopolicy.CheckRSAKeySize(bits); err != nil {
+		return nil, err
+	}
+	return rsa.GenerateKey(rand.Reader, bits)
 }
 
-// SavePrivateKey saves RSA private key to PEM file with chmod 600
-func SavePrivateKey(key *rsa.PrivateKey, path string) error {
+// KeyTypeOf returns the key type of an existing key, or "" if it is not one of KeyTypes.
+func KeyTypeOf(key crypto.Signer) string {
+	return PublicKeyType(key.Public())
+}
+
+// PublicKeyType is KeyTypeOf for a public key, such as the one in a CSR.
+func PublicKeyType(pub crypto.PublicKey) string {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		t := fmt.Sprintf("rsa%d", pub.N.BitLen())
+		if _, err := ParseKeyType(t); err == nil {
+			return t
+		}
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P256():
+			return EC256
+		case elliptic.P384():
+			return EC384
+		}
+	}
+	return ""
+}
+
+// SavePrivateKey saves a private key to PEM file with chmod 600, as PKCS#8 or in the
+// traditional PKCS1 encoding depending on format
+func SavePrivateKey(key crypto.Signer, path, format string) error {
 	// Ensure directory exists
 	dir := filepath.Dir(path)
 	if err := os.MkdirAll(dir, 0700); err != nil {
 		return err
 	}
 
-	privKeyBytes := x509.MarshalPKCS1PrivateKey(key)
-	privKeyPEM := pem.EncodeToMemory(&pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: privKeyBytes,
-	})
+	block, err := encodePrivateKey(key, format)
+	if err != nil {
+		return err
+	}
 
 	// Write with restricted permissions
-	if err := os.WriteFile(path, privKeyPEM, 0600); err != nil {
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
 		return err
 	}
 	return nil
 }
 
-// GenerateCSR creates a Certificate Signing Request for domains
-func GenerateCSR(key *rsa.PrivateKey, domains []string) ([]byte, error) {
+// GenerateCSR creates a Certificate Signing Request for domains. IP addresses among
+// them go into the IPAddresses field; the common name is the first DNS name, and is
+// left empty for IP-only requests.
+func GenerateCSR(key crypto.Signer, domains []string) ([]byte, error) {
 	if len(domains) == 0 {
 		return nil, fmt.Errorf("at least one domain required for CSR")
 	}
+	if err := cryptopolicy.CheckPublicKey(key.Public()); err != nil {
+		return nil, err
+	}
 
 	template := x509.CertificateRequest{
-		Subject: pkix.Name{
-			CommonName: domains[0],
-		},
-		DNSNames: domains,
+		SignatureAlgorithm: signatureAlgorithm(key),
+	}
+	for _, d := range domains {
+		if ip := net.ParseIP(d); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+			continue
+		}
+		if template.Subject.CommonName == "" {
+			template.Subject.CommonName = d
+		}
+		template.DNSNames = append(template.DNSNames, d)
 	}
 
 	csrBytes, err := x509.CreateCertificateRequest(rand.Reader, &template, key)
@@ -63,6 +240,107 @@ func GenerateCSR(key *rsa.PrivateKey, domains []string) ([]byte, error) {
 	return csrPEM, nil
 }
 
+// ParseCSR parses a PEM or DER certificate signing request made elsewhere, verifies
+// its self-signature and checks its key against the crypto policy. It returns the
+// request and its PEM encoding.
+func ParseCSR(data []byte) (*x509.CertificateRequest, []byte, error) {
+	der := data
+	if block, _ := pem.Decode(data); block != nil {
+		if block.Type != "CERTIFICATE REQUEST" && block.Type != "NEW CERTIFICATE REQUEST" {
+			return nil, nil, fmt.Errorf("expected a CERTIFICATE REQUEST PEM block, got %s", block.Type)
+		}
+		der = block.Bytes
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid CSR: %w", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, nil, fmt.Errorf("CSR signature does not verify: %w", err)
+	}
+	if err := cryptopolicy.CheckPublicKey(csr.PublicKey); err != nil {
+		return nil, nil, err
+	}
+	if err := cryptopolicy.CheckSignatureAlgorithm(csr.SignatureAlgorithm); err != nil {
+		return nil, nil, err
+	}
+	if len(CSRNames(csr)) == 0 {
+		return nil, nil, errors.New("the CSR names no domain or IP address")
+	}
+	return csr, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr.Raw}), nil
+}
+
+// CSRNames returns the names csr asks for: its common name first when it is one of
+// the SANs, then the DNS and IP SANs; a CSR without SANs yields its common name.
+func CSRNames(csr *x509.CertificateRequest) []string {
+	var names []string
+	for _, d := range csr.DNSNames {
+		names = append(names, strings.ToLower(d))
+	}
+	for _, ip := range csr.IPAddresses {
+		names = append(names, ip.String())
+	}
+	cn := strings.ToLower(csr.Subject.CommonName)
+	if len(names) == 0 {
+		if cn == "" {
+			return nil
+		}
+		return []string{cn}
+	}
+	for i, n := range names {
+		if n == cn && i > 0 {
+			names = append(append([]string{cn}, names[:i]...), names[i+1:]...)
+			break
+		}
+	}
+	return names
+}
+
+func signatureAlgorithm(key crypto.Signer) x509.SignatureAlgorithm {
+	if pub, ok := key.Public().(*ecdsa.PublicKey); ok {
+		if pub.Curve == elliptic.P384() {
+			return x509.ECDSAWithSHA384
+		}
+		return x509.ECDSAWithSHA256
+	}
+	return x509.SHA256WithRSA
+}
+
+// encodePrivateKey returns key as a PKCS#8 "PRIVATE KEY" or "ENCRYPTED PRIVATE KEY"
+// block, or for PKCS1 as an "RSA PRIVATE KEY" or "EC PRIVATE KEY" block.
+func encodePrivateKey(key crypto.Signer, format string) (*pem.Block, error) {
+	switch key.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey:
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	if format == PKCS8 || format == EncryptedPKCS8 {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if format == PKCS8 {
+			return &pem.Block{Type: "PRIVATE KEY", Bytes: der}, nil
+		}
+		pass, err := keyPassphrase(true)
+		if err != nil {
+			return nil, err
+		}
+		if der, err = encryptPKCS8(der, pass); err != nil {
+			return nil, err
+		}
+		return &pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: der}, nil
+	}
+	if k, ok := key.(*rsa.PrivateKey); ok {
+		return &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}, nil
+	}
+	der, err := x509.MarshalECPrivateKey(key.(*ecdsa.PrivateKey))
+	if err != nil {
+		return nil, err
+	}
+	return &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}, nil
+}
+
 // SaveCSR saves CSR to file (informational, not required by trustctl)
 func SaveCSR(csr []byte, path string) error {
 	dir := filepath.Dir(path)
@@ -72,22 +350,63 @@ func SaveCSR(csr []byte, path string) error {
 	return os.WriteFile(path, csr, 0644)
 }
 
-// LoadPrivateKey loads a PEM-encoded private key from file
-func LoadPrivateKey(path string) (*rsa.PrivateKey, error) {
+// LoadPrivateKey loads a PEM-encoded RSA or ECDSA private key (PKCS#1, SEC 1 or PKCS#8) from file
+func LoadPrivateKey(path string) (crypto.Signer, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
+	return ParsePrivateKey(data)
+}
 
+// ParsePrivateKey parses a PEM-encoded RSA or ECDSA private key (PKCS#1, SEC 1 or
+// PKCS#8, decrypting encrypted PKCS#8 with the key passphrase) and checks it against
+// the crypto policy, including the minimum RSA key size.
+func ParsePrivateKey(data []byte) (crypto.Signer, error) {
 	block, _ := pem.Decode(data)
 	if block == nil {
 		return nil, fmt.Errorf("failed to decode PEM block")
 	}
 
-	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	var key crypto.Signer
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "ENCRYPTED PRIVATE KEY":
+		var pass, der []byte
+		if pass, err = keyPassphrase(false); err != nil {
+			return nil, err
+		}
+		if der, err = decryptPKCS8(block.Bytes, pass); err != nil {
+			return nil, err
+		}
+		var k interface{}
+		if k, err = x509.ParsePKCS8PrivateKey(der); err != nil {
+			return nil, errors.New("cannot decrypt private key: wrong key passphrase")
+		}
+		var ok bool
+		if key, ok = k.(crypto.Signer); !ok {
+			err = fmt.Errorf("unsupported private key type %T", k)
+		}
+	default:
+		var k interface{}
+		k, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err == nil {
+			var ok bool
+			if key, ok = k.(crypto.Signer); !ok {
+				err = fmt.Errorf("unsupported private key type %T", k)
+			}
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
+	if err := cryptopolicy.CheckPublicKey(key.Public()); err != nil {
+		return nil, err
+	}
 
 	return key, nil
 }
@@ -0,0 +1,74 @@
+// Package keystore opens certificate private keys that are held in hardware (an HSM
+// through PKCS#11, or the host TPM) instead of a key file. Keys are named by URI;
+// signing happens in the backend and no private key material is ever written to disk.
+package keystore
+
+import (
+	"crypto"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Opener returns a signer for the key named by uri.
+type Opener func(uri string) (crypto.Signer, error)
+
+var (
+	mu       sync.Mutex
+	backends = map[string]Opener{}
+)
+
+// Register makes a key backend available for URIs with scheme (e.g. "pkcs11").
+func Register(scheme string, open Opener) {
+	mu.Lock()
+	defer mu.Unlock()
+	backends[scheme] = open
+}
+
+// Schemes returns the registered URI schemes, sorted.
+func Schemes() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	out := make([]string, 0, len(backends))
+	for s := range backends {
+		out = append(out, s)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Scheme returns the lower-cased URI scheme of uri, or "" for a file path.
+func Scheme(uri string) string {
+	s, _, ok := strings.Cut(uri, ":")
+	if !ok {
+		return ""
+	}
+	return strings.ToLower(s)
+}
+
+// IsURI reports whether s names a key in a registered backend rather than a file.
+func IsURI(s string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	_, ok := backends[Scheme(s)]
+	return ok
+}
+
+// Open returns a signer for the key named by uri.
+func Open(uri string) (crypto.Signer, error) {
+	mu.Lock()
+	open, ok := backends[Scheme(uri)]
+	mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unsupported key URI %q (supported schemes: %s)", uri, strings.Join(Schemes(), ", "))
+	}
+	return open(uri)
+}
+
+// Public returns uri without its query attributes, which carry the PIN and the
+// module path, for writing into web server configuration and metadata output.
+func Public(uri string) string {
+	base, _, _ := strings.Cut(uri, "?")
+	return base
+}
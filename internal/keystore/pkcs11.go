@@ -0,0 +1,470 @@
+//go:build cgo && !windows
+
+package keystore
+
+/*
+#cgo linux LDFLAGS: -ldl
+#include <dlfcn.h>
+#include <stdlib.h>
+
+typedef unsigned long ck_ulong;
+typedef ck_ulong ck_rv;
+
+typedef struct { unsigned char major, minor; } ck_version;
+
+// CK_FUNCTION_LIST of PKCS#11 v2.x: the version, then the function pointers in
+// the order of the specification; only a few are called, by index.
+typedef struct {
+	ck_version version;
+	void *fn[68];
+} ck_function_list;
+
+typedef struct {
+	unsigned char label[32];
+	unsigned char manufacturerID[32];
+	unsigned char model[16];
+	unsigned char serialNumber[16];
+	ck_ulong flags;
+	ck_ulong ulMaxSessionCount, ulSessionCount, ulMaxRwSessionCount, ulRwSessionCount;
+	ck_ulong ulMaxPinLen, ulMinPinLen;
+	ck_ulong ulTotalPublicMemory, ulFreePublicMemory, ulTotalPrivateMemory, ulFreePrivateMemory;
+	ck_version hardwareVersion, firmwareVersion;
+	unsigned char utcTime[16];
+} ck_token_info;
+
+typedef struct { ck_ulong type; void *pValue; ck_ulong ulValueLen; } ck_attribute;
+typedef struct { ck_ulong mechanism; void *pParameter; ck_ulong ulParameterLen; } ck_mechanism;
+typedef struct {
+	void *CreateMutex, *DestroyMutex, *LockMutex, *UnlockMutex;
+	ck_ulong flags;
+	void *pReserved;
+} ck_c_initialize_args;
+
+static void *p11_load(const char *path, ck_function_list **list, const char **err) {
+	void *h = dlopen(path, RTLD_NOW | RTLD_LOCAL);
+	if (!h) {
+		*err = dlerror();
+		return NULL;
+	}
+	ck_rv (*get)(ck_function_list **) = (ck_rv (*)(ck_function_list **))dlsym(h, "C_GetFunctionList");
+	if (!get || get(list) != 0 || !*list) {
+		*err = "C_GetFunctionList failed";
+		dlclose(h);
+		return NULL;
+	}
+	return h;
+}
+
+static ck_rv p11_initialize(ck_function_list *f, ck_c_initialize_args *a) {
+	return ((ck_rv (*)(void *))f->fn[0])(a);
+}
+static ck_rv p11_get_slot_list(ck_function_list *f, ck_ulong *slots, ck_ulong *n) {
+	return ((ck_rv (*)(unsigned char, ck_ulong *, ck_ulong *))f->fn[4])(1, slots, n);
+}
+static ck_rv p11_get_token_info(ck_function_list *f, ck_ulong slot, ck_token_info *info) {
+	return ((ck_rv (*)(ck_ulong, ck_token_info *))f->fn[6])(slot, info);
+}
+static ck_rv p11_open_session(ck_function_list *f, ck_ulong slot, ck_ulong flags, ck_ulong *s) {
+	return ((ck_rv (*)(ck_ulong, ck_ulong, void *, void *, ck_ulong *))f->fn[12])(slot, flags, NULL, NULL, s);
+}
+static ck_rv p11_login(ck_function_list *f, ck_ulong s, ck_ulong user, unsigned char *pin, ck_ulong n) {
+	return ((ck_rv (*)(ck_ulong, ck_ulong, unsigned char *, ck_ulong))f->fn[18])(s, user, pin, n);
+}
+static ck_rv p11_get_attribute_value(ck_function_list *f, ck_ulong s, ck_ulong obj, ck_attribute *t, ck_ulong n) {
+	return ((ck_rv (*)(ck_ulong, ck_ulong, ck_attribute *, ck_ulong))f->fn[24])(s, obj, t, n);
+}
+static ck_rv p11_find_objects_init(ck_function_list *f, ck_ulong s, ck_attribute *t, ck_ulong n) {
+	return ((ck_rv (*)(ck_ulong, ck_attribute *, ck_ulong))f->fn[26])(s, t, n);
+}
+static ck_rv p11_find_objects(ck_function_list *f, ck_ulong s, ck_ulong *objs, ck_ulong max, ck_ulong *n) {
+	return ((ck_rv (*)(ck_ulong, ck_ulong *, ck_ulong, ck_ulong *))f->fn[27])(s, objs, max, n);
+}
+static ck_rv p11_find_objects_final(ck_function_list *f, ck_ulong s) {
+	return ((ck_rv (*)(ck_ulong))f->fn[28])(s);
+}
+static ck_rv p11_sign_init(ck_function_list *f, ck_ulong s, ck_mechanism *m, ck_ulong key) {
+	return ((ck_rv (*)(ck_ulong, ck_mechanism *, ck_ulong))f->fn[42])(s, m, key);
+}
+static ck_rv p11_sign(ck_function_list *f, ck_ulong s, unsigned char *data, ck_ulong n, unsigned char *sig, ck_ulong *siglen) {
+	return ((ck_rv (*)(ck_ulong, unsigned char *, ck_ulong, unsigned char *, ck_ulong *))f->fn[43])(s, data, n, sig, siglen);
+}
+*/
+import "C"
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sync"
+	"unsafe"
+)
+
+const (
+	ckrOK                         = 0x000
+	ckrUserAlreadyLoggedIn        = 0x100
+	ckrCryptokiAlreadyInitialized = 0x191
+
+	ckfOSLockingOK    = 0x02
+	ckfSerialSession  = 0x04
+	ckfLoginRequired  = 0x04
+	ckuUser           = 1
+	ckoPublicKey      = 2
+	ckoPrivateKey     = 3
+	ckkRSA            = 0
+	ckkEC             = 3
+	ckmRSAPKCS        = 0x0001
+	ckmECDSA          = 0x1041
+	ckaClass          = 0x000
+	ckaLabel          = 0x003
+	ckaKeyType        = 0x100
+	ckaID             = 0x102
+	ckaModulus        = 0x120
+	ckaPublicExponent = 0x122
+	ckaECParams       = 0x180
+	ckaECPoint        = 0x181
+)
+
+func init() {
+	Register("pkcs11", openPKCS11)
+}
+
+type p11Module struct {
+	fl *C.ck_function_list
+}
+
+var (
+	modulesMu sync.Mutex
+	modules   = map[string]*p11Module{}
+)
+
+// loadModule loads and initializes the PKCS#11 module at path once per process.
+func loadModule(path, initReserved string) (*p11Module, error) {
+	modulesMu.Lock()
+	defer modulesMu.Unlock()
+	if m, ok := modules[path]; ok {
+		return m, nil
+	}
+	cpath := C.CString(path)
+	defer C.free(unsafe.Pointer(cpath))
+	var fl *C.ck_function_list
+	var cerr *C.char
+	if C.p11_load(cpath, &fl, &cerr) == nil {
+		return nil, fmt.Errorf("load PKCS#11 module %s: %s", path, C.GoString(cerr))
+	}
+	args := (*C.ck_c_initialize_args)(C.calloc(1, C.sizeof_ck_c_initialize_args))
+	defer C.free(unsafe.Pointer(args))
+	args.flags = ckfOSLockingOK
+	if initReserved != "" {
+		reserved := C.CString(initReserved)
+		defer C.free(unsafe.Pointer(reserved))
+		args.pReserved = unsafe.Pointer(reserved)
+	}
+	if rv := C.p11_initialize(fl, args); rv != ckrOK && rv != ckrCryptokiAlreadyInitialized {
+		return nil, p11Error("C_Initialize", rv)
+	}
+	m := &p11Module{fl: fl}
+	modules[path] = m
+	return m, nil
+}
+
+func p11Error(fn string, rv C.ck_rv) error {
+	return fmt.Errorf("PKCS#11 %s: CKR 0x%x", fn, uint64(rv))
+}
+
+// pkcs11Key signs with a private key object inside a token session.
+type pkcs11Key struct {
+	mu      sync.Mutex
+	m       *p11Module
+	session C.ck_ulong
+	handle  C.ck_ulong
+	pub     crypto.PublicKey
+}
+
+func openPKCS11(raw string) (crypto.Signer, error) {
+	u, err := parsePKCS11URI(raw)
+	if err != nil {
+		return nil, err
+	}
+	path := u.ModulePath
+	if path == "" {
+		path = pkcs11ModulePath()
+	}
+	if path == "" {
+		return nil, errors.New("no PKCS#11 module configured: set --pkcs11-module, $TRUSTCTL_PKCS11_MODULE or module-path= in the URI")
+	}
+	m, err := loadModule(path, u.InitReserved)
+	if err != nil {
+		return nil, err
+	}
+
+	slot, info, err := m.findToken(u)
+	if err != nil {
+		return nil, err
+	}
+	defer C.free(unsafe.Pointer(info))
+	k := &pkcs11Key{m: m}
+	if rv := C.p11_open_session(m.fl, slot, ckfSerialSession, &k.session); rv != ckrOK {
+		return nil, p11Error("C_OpenSession", rv)
+	}
+	if info.flags&ckfLoginRequired != 0 {
+		pin, err := u.pin(pkcs11PIN)
+		if err != nil {
+			return nil, err
+		}
+		cpin := C.CBytes(pin)
+		rv := C.p11_login(m.fl, k.session, ckuUser, (*C.uchar)(cpin), C.ck_ulong(len(pin)))
+		C.free(cpin)
+		if rv != ckrOK && rv != ckrUserAlreadyLoggedIn {
+			return nil, p11Error("C_Login", rv)
+		}
+	}
+
+	tmpl := []p11Attr{{ckaClass, ulongBytes(ckoPrivateKey)}}
+	if u.Object != "" {
+		tmpl = append(tmpl, p11Attr{ckaLabel, []byte(u.Object)})
+	}
+	if u.ID != nil {
+		tmpl = append(tmpl, p11Attr{ckaID, u.ID})
+	}
+	if k.handle, err = k.findOne(tmpl); err != nil {
+		return nil, fmt.Errorf("private key %s: %w", Public(raw), err)
+	}
+	if k.pub, err = k.publicKey(); err != nil {
+		return nil, fmt.Errorf("private key %s: %w", Public(raw), err)
+	}
+	return k, nil
+}
+
+// findToken returns the first slot whose token matches u.
+func (m *p11Module) findToken(u *pkcs11URI) (C.ck_ulong, *C.ck_token_info, error) {
+	var n C.ck_ulong
+	if rv := C.p11_get_slot_list(m.fl, nil, &n); rv != ckrOK {
+		return 0, nil, p11Error("C_GetSlotList", rv)
+	}
+	if n == 0 {
+		return 0, nil, errors.New("PKCS#11 module has no token")
+	}
+	slots := (*C.ck_ulong)(C.calloc(C.size_t(n), C.sizeof_ck_ulong))
+	defer C.free(unsafe.Pointer(slots))
+	if rv := C.p11_get_slot_list(m.fl, slots, &n); rv != ckrOK {
+		return 0, nil, p11Error("C_GetSlotList", rv)
+	}
+	info := (*C.ck_token_info)(C.calloc(1, C.sizeof_ck_token_info))
+	for _, slot := range unsafe.Slice(slots, int(n)) {
+		if u.SlotID != nil && uint(slot) != *u.SlotID {
+			continue
+		}
+		if C.p11_get_token_info(m.fl, slot, info) != ckrOK {
+			continue
+		}
+		if u.matchToken(C.GoStringN((*C.char)(unsafe.Pointer(&info.label[0])), 32),
+			C.GoStringN((*C.char)(unsafe.Pointer(&info.manufacturerID[0])), 32),
+			C.GoStringN((*C.char)(unsafe.Pointer(&info.serialNumber[0])), 16),
+			C.GoStringN((*C.char)(unsafe.Pointer(&info.model[0])), 16)) {
+			return slot, info, nil
+		}
+	}
+	C.free(unsafe.Pointer(info))
+	return 0, nil, fmt.Errorf("no PKCS#11 token matches token=%q", u.Token)
+}
+
+type p11Attr struct {
+	typ   C.ck_ulong
+	value []byte
+}
+
+func ulongBytes(v C.ck_ulong) []byte {
+	return C.GoBytes(unsafe.Pointer(&v), C.sizeof_ck_ulong)
+}
+
+// cTemplate copies attrs into C memory, as PKCS#11 templates hold pointers.
+func cTemplate(attrs []p11Attr) (*C.ck_attribute, func()) {
+	t := (*C.ck_attribute)(C.calloc(C.size_t(len(attrs)), C.sizeof_ck_attribute))
+	elems := unsafe.Slice(t, len(attrs))
+	for i, a := range attrs {
+		elems[i]._type = a.typ
+		elems[i].pValue = C.CBytes(a.value)
+		elems[i].ulValueLen = C.ck_ulong(len(a.value))
+	}
+	return t, func() {
+		for i := range elems {
+			C.free(elems[i].pValue)
+		}
+		C.free(unsafe.Pointer(t))
+	}
+}
+
+// findOne returns the single object matching attrs.
+func (k *pkcs11Key) findOne(attrs []p11Attr) (C.ck_ulong, error) {
+	t, free := cTemplate(attrs)
+	defer free()
+	if rv := C.p11_find_objects_init(k.m.fl, k.session, t, C.ck_ulong(len(attrs))); rv != ckrOK {
+		return 0, p11Error("C_FindObjectsInit", rv)
+	}
+	defer C.p11_find_objects_final(k.m.fl, k.session)
+	objs := (*C.ck_ulong)(C.calloc(2, C.sizeof_ck_ulong))
+	defer C.free(unsafe.Pointer(objs))
+	var n C.ck_ulong
+	if rv := C.p11_find_objects(k.m.fl, k.session, objs, 2, &n); rv != ckrOK {
+		return 0, p11Error("C_FindObjects", rv)
+	}
+	switch n {
+	case 0:
+		return 0, errors.New("not found on the token")
+	case 1:
+		return unsafe.Slice(objs, 1)[0], nil
+	}
+	return 0, errors.New("matches several objects on the token; add id= to the URI")
+}
+
+// attribute reads one attribute of obj.
+func (k *pkcs11Key) attribute(obj, typ C.ck_ulong) ([]byte, error) {
+	t := (*C.ck_attribute)(C.calloc(1, C.sizeof_ck_attribute))
+	defer C.free(unsafe.Pointer(t))
+	t._type = typ
+	if rv := C.p11_get_attribute_value(k.m.fl, k.session, obj, t, 1); rv != ckrOK {
+		return nil, p11Error("C_GetAttributeValue", rv)
+	}
+	t.pValue = C.malloc(C.size_t(t.ulValueLen) + 1)
+	defer C.free(t.pValue)
+	if rv := C.p11_get_attribute_value(k.m.fl, k.session, obj, t, 1); rv != ckrOK {
+		return nil, p11Error("C_GetAttributeValue", rv)
+	}
+	return C.GoBytes(t.pValue, C.int(t.ulValueLen)), nil
+}
+
+func (k *pkcs11Key) publicKey() (crypto.PublicKey, error) {
+	kt, err := k.attribute(k.handle, ckaKeyType)
+	if err != nil {
+		return nil, err
+	}
+	switch *(*C.ck_ulong)(unsafe.Pointer(&kt[0])) {
+	case ckkRSA:
+		n, err := k.attribute(k.handle, ckaModulus)
+		if err != nil {
+			return nil, err
+		}
+		e, err := k.attribute(k.handle, ckaPublicExponent)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case ckkEC:
+		// EC private keys carry no point; read it from the public key object
+		tmpl := []p11Attr{{ckaClass, ulongBytes(ckoPublicKey)}}
+		if id, err := k.attribute(k.handle, ckaID); err == nil && len(id) > 0 {
+			tmpl = append(tmpl, p11Attr{ckaID, id})
+		} else if label, err := k.attribute(k.handle, ckaLabel); err == nil {
+			tmpl = append(tmpl, p11Attr{ckaLabel, label})
+		}
+		pubObj, err := k.findOne(tmpl)
+		if err != nil {
+			return nil, fmt.Errorf("public key: %w", err)
+		}
+		params, err := k.attribute(pubObj, ckaECParams)
+		if err != nil {
+			return nil, err
+		}
+		point, err := k.attribute(pubObj, ckaECPoint)
+		if err != nil {
+			return nil, err
+		}
+		return ecPublicKey(params, point)
+	}
+	return nil, errors.New("unsupported key type (only RSA and EC)")
+}
+
+var (
+	oidP256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 3, 1, 7}
+	oidP384 = asn1.ObjectIdentifier{1, 3, 132, 0, 34}
+	oidP521 = asn1.ObjectIdentifier{1, 3, 132, 0, 35}
+)
+
+func ecPublicKey(params, point []byte) (*ecdsa.PublicKey, error) {
+	var oid asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(params, &oid); err != nil {
+		return nil, fmt.Errorf("EC parameters: %w", err)
+	}
+	var curve elliptic.Curve
+	switch {
+	case oid.Equal(oidP256):
+		curve = elliptic.P256()
+	case oid.Equal(oidP384):
+		curve = elliptic.P384()
+	case oid.Equal(oidP521):
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("unsupported curve %s", oid)
+	}
+	// CKA_EC_POINT is a DER OCTET STRING; some modules return the bare point
+	var raw []byte
+	if rest, err := asn1.Unmarshal(point, &raw); err != nil || len(rest) > 0 {
+		raw = point
+	}
+	x, y := elliptic.Unmarshal(curve, raw)
+	if x == nil {
+		return nil, errors.New("invalid EC point")
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+func (k *pkcs11Key) Public() crypto.PublicKey {
+	return k.pub
+}
+
+// digestInfoPrefixes are the DER DigestInfo headers CKM_RSA_PKCS signatures need.
+var digestInfoPrefixes = map[crypto.Hash][]byte{
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA384: {0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+// Sign signs digest in the token: PKCS#1 v1.5 for RSA, ECDSA for EC keys.
+func (k *pkcs11Key) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	var mech C.ck_ulong
+	data := digest
+	switch k.pub.(type) {
+	case *rsa.PublicKey:
+		if _, ok := opts.(*rsa.PSSOptions); ok {
+			return nil, errors.New("PKCS#11 keys sign with PKCS#1 v1.5 only")
+		}
+		prefix, ok := digestInfoPrefixes[opts.HashFunc()]
+		if !ok {
+			return nil, fmt.Errorf("unsupported hash %v", opts.HashFunc())
+		}
+		mech, data = ckmRSAPKCS, append(append([]byte{}, prefix...), digest...)
+	default:
+		mech = ckmECDSA
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	m := (*C.ck_mechanism)(C.calloc(1, C.sizeof_ck_mechanism))
+	defer C.free(unsafe.Pointer(m))
+	m.mechanism = mech
+	if rv := C.p11_sign_init(k.m.fl, k.session, m, k.handle); rv != ckrOK {
+		return nil, p11Error("C_SignInit", rv)
+	}
+	in := C.CBytes(data)
+	defer C.free(in)
+	const maxSig = 1024
+	sig := C.malloc(maxSig)
+	defer C.free(sig)
+	n := C.ck_ulong(maxSig)
+	if rv := C.p11_sign(k.m.fl, k.session, (*C.uchar)(in), C.ck_ulong(len(data)), (*C.uchar)(sig), &n); rv != ckrOK {
+		return nil, p11Error("C_Sign", rv)
+	}
+	out := C.GoBytes(sig, C.int(n))
+	if mech == ckmECDSA {
+		// Tokens return r || s; x509 wants an ASN.1 ECDSA-Sig-Value
+		half := len(out) / 2
+		return asn1.Marshal(struct{ R, S *big.Int }{new(big.Int).SetBytes(out[:half]), new(big.Int).SetBytes(out[half:])})
+	}
+	return out, nil
+}
@@ -0,0 +1,17 @@
+//go:build !cgo || windows
+
+package keystore
+
+import (
+	"crypto"
+	"errors"
+)
+
+func init() {
+	Register("pkcs11", func(uri string) (crypto.Signer, error) {
+		if _, err := parsePKCS11URI(uri); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("PKCS#11 keys need a trustctl built with cgo on a Unix system")
+	})
+}
@@ -0,0 +1,169 @@
+package keystore
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	moduleMu   sync.Mutex
+	modulePath string
+	pinFunc    func() ([]byte, error)
+)
+
+// SetPKCS11Module sets the PKCS#11 module (shared library) for URIs without
+// module-path; empty falls back to $TRUSTCTL_PKCS11_MODULE.
+func SetPKCS11Module(path string) {
+	moduleMu.Lock()
+	defer moduleMu.Unlock()
+	modulePath = path
+}
+
+// SetPINFunc sets where token PINs come from when the URI has neither pin-value nor
+// pin-source.
+func SetPINFunc(f func() ([]byte, error)) {
+	moduleMu.Lock()
+	defer moduleMu.Unlock()
+	pinFunc = f
+}
+
+func pkcs11ModulePath() string {
+	moduleMu.Lock()
+	defer moduleMu.Unlock()
+	if modulePath != "" {
+		return modulePath
+	}
+	return os.Getenv("TRUSTCTL_PKCS11_MODULE")
+}
+
+func pkcs11PIN() ([]byte, error) {
+	moduleMu.Lock()
+	f := pinFunc
+	moduleMu.Unlock()
+	if f == nil {
+		return nil, errors.New("the PKCS#11 token requires a PIN: add pin-source= to the URI")
+	}
+	return f()
+}
+
+// pkcs11URI is an RFC 7512 PKCS#11 URI naming a private key, e.g.
+// pkcs11:token=web;object=www-key?pin-source=/etc/trustctl/hsm.pin
+type pkcs11URI struct {
+	Token, Manufacturer, Serial, Model string
+	SlotID                             *uint
+	Object                             string
+	ID                                 []byte
+
+	PINValue, PINSource string
+	ModulePath          string
+	// InitReserved is passed to C_Initialize as pReserved, for modules that are
+	// configured that way (NSS softokn); "x-init-reserved" in the query.
+	InitReserved string
+}
+
+func parsePKCS11URI(raw string) (*pkcs11URI, error) {
+	if Scheme(raw) != "pkcs11" {
+		return nil, fmt.Errorf("not a pkcs11 URI: %q", raw)
+	}
+	rest := raw[len("pkcs11:"):]
+	path, query, _ := strings.Cut(rest, "?")
+	u := &pkcs11URI{}
+	for _, attr := range strings.Split(path, ";") {
+		if attr == "" {
+			continue
+		}
+		k, v, err := pkcs11Attr(attr)
+		if err != nil {
+			return nil, err
+		}
+		switch k {
+		case "token":
+			u.Token = v
+		case "manufacturer":
+			u.Manufacturer = v
+		case "serial":
+			u.Serial = v
+		case "model":
+			u.Model = v
+		case "object":
+			u.Object = v
+		case "id":
+			u.ID = []byte(v)
+		case "slot-id":
+			n, err := strconv.ParseUint(v, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("pkcs11 URI: invalid slot-id %q", v)
+			}
+			id := uint(n)
+			u.SlotID = &id
+		case "type":
+			if v != "private" {
+				return nil, fmt.Errorf("pkcs11 URI: type=%s does not name a private key", v)
+			}
+		}
+	}
+	for _, attr := range strings.Split(query, "&") {
+		if attr == "" {
+			continue
+		}
+		k, v, err := pkcs11Attr(attr)
+		if err != nil {
+			return nil, err
+		}
+		switch k {
+		case "pin-value":
+			u.PINValue = v
+		case "pin-source":
+			u.PINSource = strings.TrimPrefix(v, "file:")
+		case "module-path":
+			u.ModulePath = v
+		case "x-init-reserved":
+			u.InitReserved = v
+		}
+	}
+	if u.Object == "" && u.ID == nil {
+		return nil, fmt.Errorf("pkcs11 URI %s names no key: add object= or id=", Public(raw))
+	}
+	return u, nil
+}
+
+func pkcs11Attr(attr string) (string, string, error) {
+	k, v, ok := strings.Cut(attr, "=")
+	if !ok {
+		return "", "", fmt.Errorf("pkcs11 URI: attribute %q has no value", attr)
+	}
+	v, err := url.PathUnescape(v)
+	if err != nil {
+		return "", "", fmt.Errorf("pkcs11 URI: attribute %s: %w", k, err)
+	}
+	return strings.ToLower(k), v, nil
+}
+
+// pin returns the PIN named by the URI: pin-value, the file at pin-source, else the
+// caller's fallback (environment or prompt).
+func (u *pkcs11URI) pin(fallback func() ([]byte, error)) ([]byte, error) {
+	switch {
+	case u.PINValue != "":
+		return []byte(u.PINValue), nil
+	case u.PINSource != "":
+		data, err := os.ReadFile(u.PINSource)
+		if err != nil {
+			return nil, fmt.Errorf("pkcs11 pin-source: %w", err)
+		}
+		return []byte(strings.TrimRight(string(data), "\r\n")), nil
+	}
+	return fallback()
+}
+
+// matchToken reports whether a token with these (space-padded) labels is the one the URI names.
+func (u *pkcs11URI) matchToken(label, manufacturer, serial, model string) bool {
+	return (u.Token == "" || u.Token == strings.TrimRight(label, " ")) &&
+		(u.Manufacturer == "" || u.Manufacturer == strings.TrimRight(manufacturer, " ")) &&
+		(u.Serial == "" || u.Serial == strings.TrimRight(serial, " ")) &&
+		(u.Model == "" || u.Model == strings.TrimRight(model, " "))
+}
@@ -0,0 +1,561 @@
+package keystore
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// TPM keys are generated inside the host TPM 2.0 under its storage root key and kept
+// in a TSS2 key file ("TSS2 PRIVATE KEY", the format of tpm2-openssl and
+// openssl_tpm2_engine): the private part is wrapped by the TPM and useless on any
+// other machine. Keys are named "tpm:<path to the key file>". The TPM is driven
+// directly over its character device, with empty owner and key authorization.
+
+var (
+	tpmMu     sync.Mutex
+	tpmDevice string
+
+	// tpmOpMu serializes TPM command sequences within the process.
+	tpmOpMu sync.Mutex
+)
+
+// SetTPMDevice sets the TPM character device; empty falls back to
+// $TRUSTCTL_TPM_DEVICE, then /dev/tpmrm0 (the kernel resource manager).
+func SetTPMDevice(path string) {
+	tpmMu.Lock()
+	defer tpmMu.Unlock()
+	tpmDevice = path
+}
+
+func tpmDevicePath() string {
+	tpmMu.Lock()
+	defer tpmMu.Unlock()
+	if tpmDevice != "" {
+		return tpmDevice
+	}
+	if p := os.Getenv("TRUSTCTL_TPM_DEVICE"); p != "" {
+		return p
+	}
+	return "/dev/tpmrm0"
+}
+
+func init() {
+	Register("tpm", openTPM)
+}
+
+// TPM 2.0 constants (TPM 2.0 Library, Part 2).
+const (
+	tpmSTNoSessions = 0x8001
+	tpmSTSessions   = 0x8002
+	tpmSTHashcheck  = 0x8024
+
+	tpmCCCreatePrimary = 0x131
+	tpmCCCreate        = 0x153
+	tpmCCLoad          = 0x157
+	tpmCCSign          = 0x15d
+	tpmCCFlushContext  = 0x165
+
+	tpmRHOwner = 0x40000001
+	tpmRHNull  = 0x40000007
+	tpmRSPW    = 0x40000009
+
+	tpmAlgRSA    = 0x0001
+	tpmAlgSHA256 = 0x000b
+	tpmAlgSHA384 = 0x000c
+	tpmAlgSHA512 = 0x000d
+	tpmAlgNull   = 0x0010
+	tpmAlgAES    = 0x0006
+	tpmAlgCFB    = 0x0043
+	tpmAlgRSASSA = 0x0014
+	tpmAlgECDSA  = 0x0018
+	tpmAlgECC    = 0x0023
+
+	tpmECCNistP256 = 0x0003
+	tpmECCNistP384 = 0x0004
+
+	tpmaFixedTPM            = 1 << 1
+	tpmaFixedParent         = 1 << 4
+	tpmaSensitiveDataOrigin = 1 << 5
+	tpmaUserWithAuth        = 1 << 6
+	tpmaNoDA                = 1 << 10
+	tpmaRestricted          = 1 << 16
+	tpmaDecrypt             = 1 << 17
+	tpmaSign                = 1 << 18
+)
+
+// oidLoadableKey is the TSS2 key file type of a key loaded with TPM2_Load.
+var oidLoadableKey = asn1.ObjectIdentifier{2, 23, 133, 10, 1, 3}
+
+// tssKey is the ASN.1 body of a "TSS2 PRIVATE KEY" PEM block. Pubkey and Privkey are
+// the marshalled TPM2B_PUBLIC and TPM2B_PRIVATE, size prefix included.
+type tssKey struct {
+	Type      asn1.ObjectIdentifier
+	EmptyAuth bool `asn1:"optional,explicit,tag:0"`
+	Parent    int
+	Pubkey    []byte
+	Privkey   []byte
+}
+
+// tpmBuf marshals TPM command parameters, big-endian.
+type tpmBuf struct{ bytes.Buffer }
+
+func (b *tpmBuf) u16(v uint16) { binary.Write(&b.Buffer, binary.BigEndian, v) }
+func (b *tpmBuf) u32(v uint32) { binary.Write(&b.Buffer, binary.BigEndian, v) }
+func (b *tpmBuf) tpm2b(data []byte) {
+	b.u16(uint16(len(data)))
+	b.Write(data)
+}
+
+// tpmReader unmarshals TPM responses; the first error sticks.
+type tpmReader struct {
+	data []byte
+	err  error
+}
+
+func (r *tpmReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.data) < n {
+		r.err = errors.New("short TPM response")
+		return nil
+	}
+	out := r.data[:n]
+	r.data = r.data[n:]
+	return out
+}
+
+func (r *tpmReader) u16() uint16 {
+	if b := r.next(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *tpmReader) u32() uint32 {
+	if b := r.next(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *tpmReader) tpm2b() []byte {
+	return r.next(int(r.u16()))
+}
+
+// tpm2bRaw returns a TPM2B with its size prefix, as stored in key files.
+func (r *tpmReader) tpm2bRaw() []byte {
+	if len(r.data) < 2 {
+		r.err = errors.New("short TPM response")
+		return nil
+	}
+	n := 2 + int(binary.BigEndian.Uint16(r.data))
+	return append([]byte{}, r.next(n)...)
+}
+
+// tpmConn is an open TPM device.
+type tpmConn struct {
+	f *os.File
+}
+
+func openTPMDevice() (*tpmConn, error) {
+	path := tpmDevicePath()
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("no TPM 2.0 device at %s (set --tpm-device or $TRUSTCTL_TPM_DEVICE)", path)
+		}
+		return nil, fmt.Errorf("open TPM: %w", err)
+	}
+	return &tpmConn{f: f}, nil
+}
+
+func (t *tpmConn) Close() error {
+	return t.f.Close()
+}
+
+// run sends a command and returns the response after its header. Commands with
+// authHandles get one empty-password session per handle.
+func (t *tpmConn) run(cc uint32, handles []uint32, authHandles int, params []byte) ([]byte, error) {
+	var body tpmBuf
+	for _, h := range handles {
+		body.u32(h)
+	}
+	tag := uint16(tpmSTNoSessions)
+	if authHandles > 0 {
+		tag = tpmSTSessions
+		var auth tpmBuf
+		for i := 0; i < authHandles; i++ {
+			auth.u32(tpmRSPW)
+			auth.tpm2b(nil) // nonce
+			auth.WriteByte(0)
+			auth.tpm2b(nil) // password
+		}
+		body.u32(uint32(auth.Len()))
+		body.Write(auth.Bytes())
+	}
+	body.Write(params)
+
+	var cmd tpmBuf
+	cmd.u16(tag)
+	cmd.u32(uint32(10 + body.Len()))
+	cmd.u32(cc)
+	cmd.Write(body.Bytes())
+	if _, err := t.f.Write(cmd.Bytes()); err != nil {
+		return nil, fmt.Errorf("TPM write: %w", err)
+	}
+	resp := make([]byte, 4096)
+	n, err := t.f.Read(resp)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("TPM read: %w", err)
+	}
+	if n < 10 {
+		return nil, errors.New("short TPM response")
+	}
+	if rc := binary.BigEndian.Uint32(resp[6:10]); rc != 0 {
+		return nil, fmt.Errorf("TPM command 0x%x failed: TPM_RC 0x%x", cc, rc)
+	}
+	return resp[10:n], nil
+}
+
+// flush evicts a transient object; errors are ignored, the resource manager drops
+// the handle with the connection anyway.
+func (t *tpmConn) flush(h uint32) {
+	var p tpmBuf
+	p.u32(h)
+	t.run(tpmCCFlushContext, nil, 0, p.Bytes())
+}
+
+// srkTemplate is the ECC P-256 storage root key of the TCG TPM v2.0 Provisioning
+// Guidance, which key files with parent TPM_RH_OWNER are created under.
+func srkTemplate() []byte {
+	var t tpmBuf
+	t.u16(tpmAlgECC)
+	t.u16(tpmAlgSHA256)
+	t.u32(tpmaFixedTPM | tpmaFixedParent | tpmaSensitiveDataOrigin | tpmaUserWithAuth | tpmaNoDA | tpmaRestricted | tpmaDecrypt)
+	t.tpm2b(nil) // authPolicy
+	t.u16(tpmAlgAES)
+	t.u16(128)
+	t.u16(tpmAlgCFB)
+	t.u16(tpmAlgNull) // scheme
+	t.u16(tpmECCNistP256)
+	t.u16(tpmAlgNull) // kdf
+	t.tpm2b(make([]byte, 32))
+	t.tpm2b(make([]byte, 32))
+	return t.Bytes()
+}
+
+// signingTemplate describes an unrestricted signing key of keyType; the signature
+// scheme is left open and chosen per signature.
+func signingTemplate(keyType string) ([]byte, error) {
+	var t tpmBuf
+	attrs := uint32(tpmaFixedTPM | tpmaFixedParent | tpmaSensitiveDataOrigin | tpmaUserWithAuth | tpmaNoDA | tpmaSign)
+	switch keyType {
+	case "ec256", "ec384":
+		curve := uint16(tpmECCNistP256)
+		if keyType == "ec384" {
+			curve = tpmECCNistP384
+		}
+		t.u16(tpmAlgECC)
+		t.u16(tpmAlgSHA256)
+		t.u32(attrs)
+		t.tpm2b(nil)
+		t.u16(tpmAlgNull) // symmetric
+		t.u16(tpmAlgNull) // scheme
+		t.u16(curve)
+		t.u16(tpmAlgNull) // kdf
+		t.tpm2b(nil)
+		t.tpm2b(nil)
+	case "rsa2048", "rsa3072", "rsa4096":
+		var bits int
+		fmt.Sscanf(keyType, "rsa%d", &bits)
+		t.u16(tpmAlgRSA)
+		t.u16(tpmAlgSHA256)
+		t.u32(attrs)
+		t.tpm2b(nil)
+		t.u16(tpmAlgNull) // symmetric
+		t.u16(tpmAlgNull) // scheme
+		t.u16(uint16(bits))
+		t.u32(0) // default exponent 65537
+		t.tpm2b(nil)
+	default:
+		return nil, fmt.Errorf("key type %s cannot be generated in the TPM", keyType)
+	}
+	return t.Bytes(), nil
+}
+
+// creationParams are the parameters of TPM2_CreatePrimary and TPM2_Create for
+// template, with empty key authorization and no creation data.
+func creationParams(template []byte) []byte {
+	var p tpmBuf
+	p.u16(4) // TPM2B_SENSITIVE_CREATE: empty userAuth and data
+	p.u16(0)
+	p.u16(0)
+	p.tpm2b(template)
+	p.tpm2b(nil) // outsideInfo
+	p.u32(0)     // creationPCR
+	return p.Bytes()
+}
+
+// loadParent returns the handle of parent, creating the storage root key for
+// TPM_RH_OWNER; flush reports whether the caller must flush it.
+func (t *tpmConn) loadParent(parent uint32) (handle uint32, flush bool, err error) {
+	if parent != tpmRHOwner {
+		return parent, false, nil // persistent key
+	}
+	resp, err := t.run(tpmCCCreatePrimary, []uint32{tpmRHOwner}, 1, creationParams(srkTemplate()))
+	if err != nil {
+		return 0, false, fmt.Errorf("create storage root key: %w", err)
+	}
+	r := &tpmReader{data: resp}
+	h := r.u32()
+	return h, true, r.err
+}
+
+// tpmPublic parses a marshalled TPM2B_PUBLIC into a Go public key.
+func tpmPublic(pub2b []byte) (crypto.PublicKey, error) {
+	r := &tpmReader{data: pub2b}
+	r = &tpmReader{data: r.tpm2b(), err: r.err}
+	typ := r.u16()
+	r.u16() // nameAlg
+	r.u32() // objectAttributes
+	r.tpm2b()
+	if alg := r.u16(); alg != tpmAlgNull { // symmetric
+		r.next(4)
+	}
+	if scheme := r.u16(); scheme != tpmAlgNull {
+		r.next(2)
+	}
+	var pub crypto.PublicKey
+	switch typ {
+	case tpmAlgRSA:
+		r.u16() // keyBits
+		e := int(r.u32())
+		if e == 0 {
+			e = 65537
+		}
+		n := r.tpm2b()
+		pub = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: e}
+	case tpmAlgECC:
+		var curve elliptic.Curve
+		switch id := r.u16(); id {
+		case tpmECCNistP256:
+			curve = elliptic.P256()
+		case tpmECCNistP384:
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported TPM curve 0x%x", id)
+		}
+		if kdf := r.u16(); kdf != tpmAlgNull {
+			r.next(2)
+		}
+		x, y := r.tpm2b(), r.tpm2b()
+		pub = &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	default:
+		return nil, fmt.Errorf("unsupported TPM key algorithm 0x%x", typ)
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("invalid TPM public area: %w", r.err)
+	}
+	return pub, nil
+}
+
+// tpmPath returns the key file named by a tpm: URI.
+func tpmPath(uri string) (string, error) {
+	if Scheme(uri) != "tpm" {
+		return "", fmt.Errorf("not a tpm key URI: %q", uri)
+	}
+	path := uri[len("tpm:"):]
+	if path == "" {
+		return "", errors.New("tpm key URI names no key file")
+	}
+	return path, nil
+}
+
+// TPMKeyFile returns the TSS2 key file a tpm: key URI names, which web servers load
+// through the OpenSSL tpm2 provider, and whether uri is one.
+func TPMKeyFile(uri string) (string, bool) {
+	path, err := tpmPath(uri)
+	return path, err == nil
+}
+
+// GenerateTPMKey creates a keyType key in the host TPM, writes its TSS2 key file to
+// path (chmod 600) and returns a signer for it.
+func GenerateTPMKey(keyType, path string) (crypto.Signer, error) {
+	template, err := signingTemplate(keyType)
+	if err != nil {
+		return nil, err
+	}
+	tpmOpMu.Lock()
+	defer tpmOpMu.Unlock()
+	t, err := openTPMDevice()
+	if err != nil {
+		return nil, err
+	}
+	defer t.Close()
+	parent, flush, err := t.loadParent(tpmRHOwner)
+	if err != nil {
+		return nil, err
+	}
+	if flush {
+		defer t.flush(parent)
+	}
+	resp, err := t.run(tpmCCCreate, []uint32{parent}, 1, creationParams(template))
+	if err != nil {
+		return nil, fmt.Errorf("create TPM key: %w", err)
+	}
+	r := &tpmReader{data: resp}
+	r.u32() // parameterSize
+	key := tssKey{Type: oidLoadableKey, EmptyAuth: true, Parent: tpmRHOwner}
+	key.Privkey = r.tpm2bRaw()
+	key.Pubkey = r.tpm2bRaw()
+	if r.err != nil {
+		return nil, fmt.Errorf("create TPM key: %w", r.err)
+	}
+	pub, err := tpmPublic(key.Pubkey)
+	if err != nil {
+		return nil, err
+	}
+	der, err := asn1.Marshal(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "TSS2 PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		return nil, err
+	}
+	return &tpmKey{key: key, pub: pub}, nil
+}
+
+func openTPM(uri string) (crypto.Signer, error) {
+	path, err := tpmPath(uri)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "TSS2 PRIVATE KEY" {
+		return nil, fmt.Errorf("%s is not a TSS2 PRIVATE KEY file", path)
+	}
+	var key tssKey
+	if _, err := asn1.Unmarshal(block.Bytes, &key); err != nil {
+		return nil, fmt.Errorf("%s: invalid TSS2 key: %w", path, err)
+	}
+	if !key.Type.Equal(oidLoadableKey) {
+		return nil, fmt.Errorf("%s: unsupported TSS2 key type %s", path, key.Type)
+	}
+	if !key.EmptyAuth {
+		return nil, fmt.Errorf("%s: TPM keys with an authorization value are not supported", path)
+	}
+	pub, err := tpmPublic(key.Pubkey)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &tpmKey{key: key, pub: pub}, nil
+}
+
+// tpmKey signs by loading its key file into the TPM for each signature; trustctl
+// signs rarely (CSRs), so no TPM handle is held between signatures.
+type tpmKey struct {
+	key tssKey
+	pub crypto.PublicKey
+}
+
+func (k *tpmKey) Public() crypto.PublicKey {
+	return k.pub
+}
+
+var tpmHashAlgs = map[crypto.Hash]uint16{
+	crypto.SHA256: tpmAlgSHA256,
+	crypto.SHA384: tpmAlgSHA384,
+	crypto.SHA512: tpmAlgSHA512,
+}
+
+// Sign signs digest in the TPM: RSASSA-PKCS1-v1_5 for RSA, ECDSA for EC keys.
+func (k *tpmKey) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	hashAlg, ok := tpmHashAlgs[opts.HashFunc()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported hash %v", opts.HashFunc())
+	}
+	sigAlg := uint16(tpmAlgECDSA)
+	if _, ok := k.pub.(*rsa.PublicKey); ok {
+		if _, ok := opts.(*rsa.PSSOptions); ok {
+			return nil, errors.New("TPM keys sign with PKCS#1 v1.5 only")
+		}
+		sigAlg = tpmAlgRSASSA
+	}
+
+	tpmOpMu.Lock()
+	defer tpmOpMu.Unlock()
+	t, err := openTPMDevice()
+	if err != nil {
+		return nil, err
+	}
+	defer t.Close()
+	parent, flush, err := t.loadParent(uint32(k.key.Parent))
+	if err != nil {
+		return nil, err
+	}
+	if flush {
+		defer t.flush(parent)
+	}
+	var p tpmBuf
+	p.Write(k.key.Privkey)
+	p.Write(k.key.Pubkey)
+	resp, err := t.run(tpmCCLoad, []uint32{parent}, 1, p.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("load TPM key: %w", err)
+	}
+	r := &tpmReader{data: resp}
+	handle := r.u32()
+	if r.err != nil {
+		return nil, fmt.Errorf("load TPM key: %w", r.err)
+	}
+	defer t.flush(handle)
+
+	p = tpmBuf{}
+	p.tpm2b(digest)
+	p.u16(sigAlg)
+	p.u16(hashAlg)
+	p.u16(tpmSTHashcheck) // null validation ticket
+	p.u32(tpmRHNull)
+	p.tpm2b(nil)
+	resp, err = t.run(tpmCCSign, []uint32{handle}, 1, p.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("TPM sign: %w", err)
+	}
+	r = &tpmReader{data: resp}
+	r.u32() // parameterSize
+	r.u16() // sigAlg
+	r.u16() // hash
+	if sigAlg == tpmAlgRSASSA {
+		sig := r.tpm2b()
+		if r.err != nil {
+			return nil, fmt.Errorf("TPM sign: %w", r.err)
+		}
+		return append([]byte{}, sig...), nil
+	}
+	rb, sb := r.tpm2b(), r.tpm2b()
+	if r.err != nil {
+		return nil, fmt.Errorf("TPM sign: %w", r.err)
+	}
+	return asn1.Marshal(struct{ R, S *big.Int }{new(big.Int).SetBytes(rb), new(big.Int).SetBytes(sb)})
+}
@@ -0,0 +1,63 @@
+package lock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrLocked is returned when the lock is held by another process and the timeout expired.
+var ErrLocked = errors.New("lock is held by another trustctl process")
+
+// Lock is an exclusive advisory lock backed by a lock file.
+type Lock struct {
+	f *os.File
+}
+
+// pollInterval is how often Acquire retries while waiting for a held lock.
+const pollInterval = 100 * time.Millisecond
+
+// Acquire takes an exclusive lock on path, creating the file if needed. It waits up
+// to timeout for another holder to release it; a zero timeout fails immediately.
+// The lock is released automatically if the process dies.
+func Acquire(path string, timeout time.Duration) (*Lock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		err := tryLock(f)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, ErrLocked) || !time.Now().Before(deadline) {
+			f.Close()
+			if errors.Is(err, ErrLocked) {
+				return nil, fmt.Errorf("%s: %w", path, ErrLocked)
+			}
+			return nil, err
+		}
+		time.Sleep(pollInterval)
+	}
+	// Record the holder for humans inspecting a stuck lock
+	f.Truncate(0)
+	fmt.Fprintf(f, "%d\n", os.Getpid())
+	return &Lock{f: f}, nil
+}
+
+// Release drops the lock.
+func (l *Lock) Release() error {
+	if l == nil || l.f == nil {
+		return nil
+	}
+	unlock(l.f)
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
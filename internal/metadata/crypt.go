@@ -0,0 +1,101 @@
+package metadata
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/trustctl/trustctl/internal/paths"
+	"github.com/trustctl/trustctl/internal/secretbox"
+)
+
+// encryptionKey opens sealed metadata records; while encryptWrites is set it also
+// seals records before they reach disk.
+var (
+	encryptionKey []byte
+	encryptWrites bool
+)
+
+// KeyFile returns the metadata encryption key path: $TRUSTCTL_METADATA_KEY_FILE or <credentials>/metadata.key.
+func KeyFile() string {
+	if v := os.Getenv("TRUSTCTL_METADATA_KEY_FILE"); v != "" {
+		return v
+	}
+	return filepath.Join(paths.Credentials(), "metadata.key")
+}
+
+// LoadEncryptionKey enables encryption at rest if the key file exists.
+func LoadEncryptionKey() error {
+	data, err := os.ReadFile(KeyFile())
+	if errors.Is(err, os.ErrNotExist) {
+		encryptionKey, encryptWrites = nil, false
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != secretbox.KeySize {
+		return fmt.Errorf("invalid metadata key in %s", KeyFile())
+	}
+	encryptionKey, encryptWrites = key, true
+	return nil
+}
+
+// GenerateEncryptionKey writes a new random key file (chmod 600) and enables it.
+// It refuses to replace an existing key, which would make stored metadata unreadable.
+func GenerateEncryptionKey() error {
+	key := make([]byte, secretbox.KeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(KeyFile()), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(KeyFile(), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(f, hex.EncodeToString(key)); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	encryptionKey, encryptWrites = key, true
+	return nil
+}
+
+// DisableEncryption stops sealing new writes for the rest of the process. Sealed
+// records stay readable while the key is loaded, so metadata can be rewritten in plaintext.
+func DisableEncryption() {
+	encryptWrites = false
+}
+
+// EncryptionEnabled reports whether new metadata writes are sealed.
+func EncryptionEnabled() bool {
+	return encryptWrites
+}
+
+func seal(data []byte) ([]byte, error) {
+	if !encryptWrites {
+		return data, nil
+	}
+	return secretbox.SealWithKey(encryptionKey, data)
+}
+
+func unseal(data []byte) ([]byte, error) {
+	if !secretbox.IsSealed(data) {
+		return data, nil
+	}
+	if encryptionKey == nil {
+		return nil, fmt.Errorf("metadata is encrypted but no key is available at %s", KeyFile())
+	}
+	return secretbox.OpenWithKey(encryptionKey, data)
+}
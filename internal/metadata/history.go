@@ -0,0 +1,39 @@
+package metadata
+
+import (
+	"time"
+)
+
+// Renewal outcomes recorded in history.
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// HistoryRecord is one renewal attempt for a certificate.
+type HistoryRecord struct {
+	At         time.Time `json:"at"`
+	Outcome    string    `json:"outcome"`
+	DurationMS int64     `json:"duration_ms"`
+	CAResponse string    `json:"ca_response,omitempty"` // short summary such as issuer and serial
+	Error      string    `json:"error,omitempty"`
+}
+
+// AppendHistory records a renewal attempt for a domain in the active backend.
+func AppendHistory(domain string, rec HistoryRecord) error {
+	return backend.AppendHistory(domain, rec)
+}
+
+// History returns the renewal attempts for a domain, oldest first.
+func History(domain string) ([]HistoryRecord, error) {
+	return backend.History(domain)
+}
+
+// FailureStreak returns the number of consecutive failures at the end of records.
+func FailureStreak(records []HistoryRecord) int {
+	n := 0
+	for i := len(records) - 1; i >= 0 && records[i].Outcome == OutcomeFailure; i-- {
+		n++
+	}
+	return n
+}
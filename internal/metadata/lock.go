@@ -0,0 +1,34 @@
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/trustctl/trustctl/internal/lock"
+	"github.com/trustctl/trustctl/internal/paths"
+)
+
+// LockTimeout bounds how long the locks below wait for another trustctl process
+// holding them; zero fails immediately.
+var LockTimeout time.Duration
+
+// LockDomain takes the per-domain lock that serializes metadata writes, key generation
+// and installs for one certificate. Different domains can be processed concurrently.
+func LockDomain(domain string) (*lock.Lock, error) {
+	return acquire("domain-"+domain, "working on "+domain)
+}
+
+// LockServerConfig takes the lock that serializes web server configuration edits and
+// reloads, which certificates for different domains share.
+func LockServerConfig() (*lock.Lock, error) {
+	return acquire("server-config", "editing the web server configuration")
+}
+
+func acquire(name, doing string) (*lock.Lock, error) {
+	l, err := lock.Acquire(paths.LockFile(name), LockTimeout)
+	if errors.Is(err, lock.ErrLocked) {
+		return nil, fmt.Errorf("another trustctl instance is running (%s); retry later or raise --lock-timeout: %w", doing, err)
+	}
+	return l, err
+}
@@ -1,81 +1,212 @@
 package metadata
 
 import (
-	"encoding/json"
 	"fmt"
-	"os"
-	"path/filepath"
+	"sort"
+	"strings"
 	"time"
+
+	"github.com/trustctl/trustctl/internal/bundle"
+	"github.com/trustctl/trustctl/internal/certinfo"
+	"github.com/trustctl/trustctl/internal/ui"
+	"github.com/trustctl/trustctl/internal/validation"
 )
 
+// InstallerNone is the InstallerType of certificates that are only obtained and
+// stored, never installed.
+const InstallerNone = "none"
+
 // CertMetadata stores the configuration and state for a certificate for renewal.
 type CertMetadata struct {
-	Domains          []string  `json:"domains"`
-	ValidationMethod string    `json:"validation_method"` // http, dns, email
-	DNSProvider      string    `json:"dns_provider,omitempty"`
-	ServerURL        string    `json:"server_url,omitempty"`
-	HMACIDCred       string    `json:"hmac_id_cred,omitempty"` // path to creds file
-	CredentialsPath  string    `json:"credentials_path"`
-	InstallerType    string    `json:"installer_type,omitempty"` // nginx, apache, tomcat
-	CertPath         string    `json:"cert_path"`
-	KeyPath          string    `json:"key_path"`
-	ChainPath        string    `json:"chain_path,omitempty"`
-	IssuedAt         time.Time `json:"issued_at"`
-	ExpiresAt        time.Time `json:"expires_at,omitempty"`
-	RenewalAttempts  int       `json:"renewal_attempts"`
-	LastRenewalAt    time.Time `json:"last_renewal_at,omitempty"`
+	Domains          []string          `json:"domains"`
+	ValidationMethod string            `json:"validation_method"` // http, dns, email, manual
+	DNSProvider      string            `json:"dns_provider,omitempty"`
+	ApproverEmail    string            `json:"approver_email,omitempty"` // recipient of enterprise CA validation emails
+	CA               string            `json:"ca,omitempty"`             // ACME CA name (letsencrypt, zerossl, ...) that issued; empty means letsencrypt
+	CAOrder          []string          `json:"ca_order,omitempty"`       // CAs to try in order when renewing, with fallbacks; empty means CA only
+	DirectoryURL     string            `json:"directory_url,omitempty"`  // ACME directory issued from; empty means the CA's production directory
+	ServerURL        string            `json:"server_url,omitempty"`
+	HMACIDCred       string            `json:"hmac_id_cred,omitempty"` // path to creds file
+	CredentialsPath  string            `json:"credentials_path"`
+	InstallerType    string            `json:"installer_type,omitempty"` // nginx, apache, tomcat; none for certonly
+	CertPath         string            `json:"cert_path"`
+	KeyPath          string            `json:"key_path"`
+	KeyType          string            `json:"key_type,omitempty"`        // rsa2048, ec256, ...; empty means rsa2048
+	KeyURI           string            `json:"key_uri,omitempty"`         // PKCS#11 or tpm: URI of a hardware-held key; no key file is written
+	ReuseKey         bool              `json:"reuse_key,omitempty"`       // renewals keep the existing private key
+	ExternalCSR      bool              `json:"external_csr,omitempty"`    // the CSR was supplied; renewals resubmit csr.pem
+	KeyRotation      *KeyRotation      `json:"key_rotation,omitempty"`    // rotate a reused key on a schedule
+	KeyCreatedAt     time.Time         `json:"key_created_at,omitempty"`  // when the current private key was generated
+	KeyRenewals      int               `json:"key_renewals,omitempty"`    // renewals on the current key since it was generated
+	KeyFormat        string            `json:"key_format,omitempty"`      // pkcs8 or pkcs1; empty means pkcs1, written before the option existed
+	PreferredChain   string            `json:"preferred_chain,omitempty"` // root CN of the chain to install when the CA offers several
+	Profile          string            `json:"profile,omitempty"`         // ACME certificate profile ordered (shortlived, tlsserver, ...)
+	ChainPath        string            `json:"chain_path,omitempty"`
+	CombinedPath     string            `json:"combined_path,omitempty"` // key+fullchain bundle, when enabled
+	Bundle           bundle.Options    `json:"bundle"`
+	IssuedAt         time.Time         `json:"issued_at"`
+	ExpiresAt        time.Time         `json:"expires_at,omitempty"`
+	RenewalAttempts  int               `json:"renewal_attempts"`
+	LastRenewalAt    time.Time         `json:"last_renewal_at,omitempty"`
+	CryptoMode       string            `json:"crypto_mode,omitempty"` // default, strict
+	Webroot          string            `json:"webroot,omitempty"`     // document root for http validation
+	HTTPAddr         string            `json:"http_addr,omitempty"`   // standalone http-01 listener instead of the webroot
+	Labels           map[string]string `json:"labels,omitempty"`      // free-form key=value tags (team=payments, env=prod)
+	Account          string            `json:"account,omitempty"`     // CA account profile the cert was issued under
+	JavaTruststores  []JavaTruststore  `json:"java_truststores,omitempty"`
+	RenewalInfo      *RenewalWindow    `json:"renewal_info,omitempty"` // ARI window for the current certificate
+	RetryAfter       time.Time         `json:"retry_after,omitempty"`  // CA rate limit: no renewal attempts before this
+	RevokedAt        time.Time         `json:"revoked_at,omitempty"`   // revoked through trustctl; renewals skip it
+	RevocationReason string            `json:"revocation_reason,omitempty"`
+	RenewBeforeDays  int               `json:"renew_days_before_expiry,omitempty"` // renew this many days before expiry; 0 uses the default
+	ImportedFrom     string            `json:"imported_from,omitempty"`            // directory of the certbot or acme.sh certificate taken over
+	Hooks            *Hooks            `json:"hooks,omitempty"`
+
+	// How a certificate with manual validation answers its challenges
+	Manual *validation.Manual `json:"manual,omitempty"`
+
+	// Details of the issued certificate, captured at issuance so callers need not re-parse PEM files
+	Serial            string   `json:"serial,omitempty"`
+	FingerprintSHA256 string   `json:"fingerprint_sha256,omitempty"`
+	IssuerDN          string   `json:"issuer_dn,omitempty"`
+	SANs              []string `json:"sans,omitempty"`
+	Chain             []string `json:"chain,omitempty"` // subject DNs of intermediates, leaf excluded
+}
+
+// JavaTruststore is a Java truststore whose CA entries are refreshed after each renewal.
+type JavaTruststore struct {
+	Path  string `json:"path"`
+	Alias string `json:"alias"` // entries are named <alias>-0, <alias>-1, ...
+}
+
+// Hooks are shell commands run around each issuance of the certificate, after the
+// executables in the shared hook directories.
+type Hooks struct {
+	Pre    string `json:"pre,omitempty"`    // before validation
+	Post   string `json:"post,omitempty"`   // after every attempt, successful or not
+	Deploy string `json:"deploy,omitempty"` // after the new certificate is saved and installed
+}
+
+// KeyRotation replaces a reused private key (ReuseKey) periodically; whichever limit
+// is reached first rotates it, and the old key is archived next to the certificate.
+type KeyRotation struct {
+	EveryRenewals int `json:"every_renewals,omitempty"` // new key on every Nth renewal
+	EveryDays     int `json:"every_days,omitempty"`     // new key once the key is this many days old
 }
 
-// Store saves metadata to a JSON file in /opt/trustctl/certs/<domain>/metadata.json
+// RenewalWindow is the renewal window the CA suggested through ACME Renewal
+// Information (ARI), kept so renewal decisions can be made without asking again.
+type RenewalWindow struct {
+	Start          time.Time `json:"start"`
+	End            time.Time `json:"end"`
+	RenewAt        time.Time `json:"renew_at"` // picked at random inside the window
+	ExplanationURL string    `json:"explanation_url,omitempty"`
+	CheckedAt      time.Time `json:"checked_at"`
+	NextCheck      time.Time `json:"next_check"` // when the CA may be asked again
+}
+
+// SetBundleFiles records the paths produced by bundle.Write.
+func (m *CertMetadata) SetBundleFiles(f *bundle.Files) {
+	m.CertPath = f.FullChain
+	m.ChainPath = f.Chain
+	m.CombinedPath = f.Combined
+}
+
+// Store saves metadata through the active backend (by default <certs>/<domain>/metadata.json)
 func (m *CertMetadata) Store() error {
-	if len(m.Domains) == 0 {
-		return fmt.Errorf("no domains in metadata")
-	}
-	primaryDomain := m.Domains[0]
-	metadataDir := filepath.Join("/opt/trustctl/certs", primaryDomain)
-	if err := os.MkdirAll(metadataDir, 0700); err != nil {
-		return err
+	return backend.Save(m)
+}
+
+// Load loads metadata for a domain from the active backend
+func Load(domain string) (*CertMetadata, error) {
+	return backend.Load(domain)
+}
+
+// ListAll returns all domains that have stored certificates/metadata
+func ListAll() ([]string, error) {
+	return backend.List()
+}
+
+// SetCertDetails records the serial, fingerprint, issuer, SANs, validity and chain of an issued certificate.
+func (m *CertMetadata) SetCertDetails(info *certinfo.Info) {
+	m.Serial = info.Serial
+	m.FingerprintSHA256 = info.FingerprintSHA256
+	m.IssuerDN = info.IssuerDN
+	m.SANs = info.SANs
+	m.Chain = info.ChainSubjects()
+	m.IssuedAt = info.NotBefore
+	m.ExpiresAt = info.NotAfter
+}
+
+// ReplacedOnDisk reports whether the certificate at CertPath no longer matches the
+// recorded fingerprint, i.e. it was replaced outside trustctl.
+func (m *CertMetadata) ReplacedOnDisk() (bool, error) {
+	if m.FingerprintSHA256 == "" {
+		return false, nil
 	}
-	metadataFile := filepath.Join(metadataDir, "metadata.json")
-	data, err := json.MarshalIndent(m, "", "  ")
+	info, err := certinfo.ParseFile(m.CertPath)
 	if err != nil {
-		return err
+		return false, err
 	}
-	if err := os.WriteFile(metadataFile, data, 0600); err != nil {
-		return err
+	return info.FingerprintSHA256 != m.FingerprintSHA256, nil
+}
+
+// ParseLabels parses key=value pairs as supplied on the command line.
+func ParseLabels(pairs []string) (map[string]string, error) {
+	labels := make(map[string]string, len(pairs))
+	for _, p := range pairs {
+		k, v, ok := strings.Cut(p, "=")
+		k = strings.TrimSpace(k)
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid label %q (expected key=value)", p)
+		}
+		labels[k] = strings.TrimSpace(v)
 	}
-	return nil
+	return labels, nil
 }
 
-// Load loads metadata from /opt/trustctl/certs/<domain>/metadata.json
-func Load(domain string) (*CertMetadata, error) {
-	metadataFile := filepath.Join("/opt/trustctl/certs", domain, "metadata.json")
-	data, err := os.ReadFile(metadataFile)
-	if err != nil {
-		return nil, err
+// MatchLabels reports whether the metadata carries every label in selector.
+func (m *CertMetadata) MatchLabels(selector map[string]string) bool {
+	for k, v := range selector {
+		if got, ok := m.Labels[k]; !ok || got != v {
+			return false
+		}
 	}
-	var m CertMetadata
-	if err := json.Unmarshal(data, &m); err != nil {
-		return nil, err
+	return true
+}
+
+// LabelString renders labels as a stable, comma-separated key=value list.
+func (m *CertMetadata) LabelString() string {
+	keys := make([]string, 0, len(m.Labels))
+	for k := range m.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, k+"="+m.Labels[k])
 	}
-	return &m, nil
+	return strings.Join(parts, ",")
 }
 
-// ListAll returns all domains that have stored certificates/metadata
-func ListAll() ([]string, error) {
-	certsDir := "/opt/trustctl/certs"
-	entries, err := os.ReadDir(certsDir)
+// LoadMatching loads metadata for every managed certificate whose labels match selector.
+// Records that fail to load are skipped with a warning.
+func LoadMatching(selector map[string]string) ([]*CertMetadata, error) {
+	domains, err := ListAll()
 	if err != nil {
 		return nil, err
 	}
-	var domains []string
-	for _, e := range entries {
-		if e.IsDir() {
-			// Check if metadata.json exists
-			if _, err := os.Stat(filepath.Join(certsDir, e.Name(), "metadata.json")); err == nil {
-				domains = append(domains, e.Name())
-			}
+	var out []*CertMetadata
+	for _, d := range domains {
+		m, err := Load(d)
+		if err != nil {
+			ui.Warning("skipping %s: %v", d, err)
+			continue
+		}
+		if len(m.Domains) == 0 || !m.MatchLabels(selector) {
+			continue
 		}
+		out = append(out, m)
 	}
-	return domains, nil
+	return out, nil
 }
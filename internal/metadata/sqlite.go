@@ -0,0 +1,237 @@
+package metadata
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS certificates (
+	domain            TEXT PRIMARY KEY,
+	validation_method TEXT NOT NULL DEFAULT '',
+	server_url        TEXT NOT NULL DEFAULT '',
+	expires_at        TEXT NOT NULL DEFAULT '',
+	data              TEXT NOT NULL,
+	updated_at        TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS certificates_expires_at ON certificates (expires_at);
+CREATE TABLE IF NOT EXISTS renewal_history (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	domain      TEXT NOT NULL,
+	at          TEXT NOT NULL,
+	outcome     TEXT NOT NULL,
+	duration_ms INTEGER NOT NULL DEFAULT 0,
+	ca_response TEXT NOT NULL DEFAULT '',
+	error       TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS renewal_history_domain ON renewal_history (domain, at);
+CREATE TABLE IF NOT EXISTS events (
+	id      INTEGER PRIMARY KEY AUTOINCREMENT,
+	domain  TEXT NOT NULL,
+	at      TEXT NOT NULL,
+	kind    TEXT NOT NULL,
+	message TEXT NOT NULL DEFAULT ''
+);
+-- Rate limits are kept in the certificate metadata (retry_after); earlier versions
+-- created an unused ledger table
+DROP TABLE IF EXISTS rate_limits;
+`
+
+// SQLiteBackend stores metadata, renewal history and events in one database.
+type SQLiteBackend struct {
+	db *sql.DB
+}
+
+// OpenSQLite opens (creating if needed) the database at path. On first use any
+// existing per-directory metadata.json files under certsDir are imported so
+// switching backends does not lose track of issued certificates.
+func OpenSQLite(path, certsDir string) (*SQLiteBackend, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite3", path+"?_busy_timeout=5000&_journal_mode=WAL&_foreign_keys=on")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("initialize metadata database: %w", err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		db.Close()
+		return nil, err
+	}
+	s := &SQLiteBackend{db: db}
+	if err := s.importJSON(certsDir); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *SQLiteBackend) importJSON(certsDir string) error {
+	var n int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM certificates`).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	src := &jsonBackend{certsDir: certsDir}
+	domains, err := src.List()
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	for _, d := range domains {
+		m, err := src.Load(d)
+		if err != nil {
+			return fmt.Errorf("import metadata for %s: %w", d, err)
+		}
+		if err := s.Save(m); err != nil {
+			return err
+		}
+		history, err := src.History(d)
+		if err != nil {
+			return fmt.Errorf("import history for %s: %w", d, err)
+		}
+		for _, rec := range history {
+			if err := s.AppendHistory(d, rec); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *SQLiteBackend) Save(m *CertMetadata) error {
+	if len(m.Domains) == 0 {
+		return fmt.Errorf("no domains in metadata")
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if data, err = seal(data); err != nil {
+		return err
+	}
+	expires := ""
+	if !m.ExpiresAt.IsZero() {
+		expires = m.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	method, serverURL := m.ValidationMethod, m.ServerURL
+	if EncryptionEnabled() {
+		// Keep the CA URL out of the plaintext index columns too
+		method, serverURL = "", ""
+	}
+	_, err = s.db.Exec(`INSERT INTO certificates (domain, validation_method, server_url, expires_at, data, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(domain) DO UPDATE SET
+			validation_method = excluded.validation_method,
+			server_url = excluded.server_url,
+			expires_at = excluded.expires_at,
+			data = excluded.data,
+			updated_at = excluded.updated_at`,
+		m.Domains[0], method, serverURL, expires, string(data), time.Now().UTC().Format(time.RFC3339))
+	return err
+}
+
+func (s *SQLiteBackend) Load(domain string) (*CertMetadata, error) {
+	var data string
+	err := s.db.QueryRow(`SELECT data FROM certificates WHERE domain = ?`, domain).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("no metadata for %s: %w", domain, os.ErrNotExist)
+	}
+	if err != nil {
+		return nil, err
+	}
+	plain, err := unseal([]byte(data))
+	if err != nil {
+		return nil, fmt.Errorf("metadata for %s: %w", domain, err)
+	}
+	var m CertMetadata
+	if err := json.Unmarshal(plain, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (s *SQLiteBackend) List() ([]string, error) {
+	rows, err := s.db.Query(`SELECT domain FROM certificates ORDER BY domain`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var domains []string
+	for rows.Next() {
+		var d string
+		if err := rows.Scan(&d); err != nil {
+			return nil, err
+		}
+		domains = append(domains, d)
+	}
+	return domains, rows.Err()
+}
+
+func (s *SQLiteBackend) Delete(domain string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, q := range []string{
+		`DELETE FROM certificates WHERE domain = ?`,
+		`DELETE FROM renewal_history WHERE domain = ?`,
+	} {
+		if _, err := tx.Exec(q, domain); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteBackend) AppendEvent(domain, kind, message string) error {
+	_, err := s.db.Exec(`INSERT INTO events (domain, at, kind, message) VALUES (?, ?, ?, ?)`,
+		domain, time.Now().UTC().Format(time.RFC3339), kind, message)
+	return err
+}
+
+func (s *SQLiteBackend) AppendHistory(domain string, rec HistoryRecord) error {
+	_, err := s.db.Exec(`INSERT INTO renewal_history (domain, at, outcome, duration_ms, ca_response, error) VALUES (?, ?, ?, ?, ?, ?)`,
+		domain, rec.At.UTC().Format(time.RFC3339Nano), rec.Outcome, rec.DurationMS, rec.CAResponse, rec.Error)
+	return err
+}
+
+func (s *SQLiteBackend) History(domain string) ([]HistoryRecord, error) {
+	rows, err := s.db.Query(`SELECT at, outcome, duration_ms, ca_response, error FROM renewal_history WHERE domain = ? ORDER BY at, id`, domain)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []HistoryRecord
+	for rows.Next() {
+		var rec HistoryRecord
+		var at string
+		if err := rows.Scan(&at, &rec.Outcome, &rec.DurationMS, &rec.CAResponse, &rec.Error); err != nil {
+			return nil, err
+		}
+		if rec.At, err = time.Parse(time.RFC3339Nano, at); err != nil {
+			return nil, err
+		}
+		out = append(out, rec)
+	}
+	return out, rows.Err()
+}
+
+func (s *SQLiteBackend) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,200 @@
+package metadata
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/trustctl/trustctl/internal/paths"
+)
+
+// Backend persists certificate metadata and the per-domain records that hang off it.
+// The JSON backend keeps the historical <certs>/<domain>/metadata.json layout;
+// the SQLite backend keeps everything in a single database.
+type Backend interface {
+	Save(m *CertMetadata) error
+	Load(domain string) (*CertMetadata, error)
+	List() ([]string, error)
+	Delete(domain string) error
+	// AppendEvent records a free-form event (issued, renewed, failed, ...) for a domain.
+	AppendEvent(domain, kind, message string) error
+	AppendHistory(domain string, rec HistoryRecord) error
+	History(domain string) ([]HistoryRecord, error)
+	Close() error
+}
+
+// Backend names accepted by Open.
+const (
+	BackendJSON   = "json"
+	BackendSQLite = "sqlite"
+)
+
+var backend Backend = &jsonBackend{certsDir: paths.Certs()}
+
+// Open selects the process-wide storage backend by name.
+func Open(name string) error {
+	var b Backend
+	if err := LoadEncryptionKey(); err != nil {
+		return err
+	}
+	switch name {
+	case "", BackendJSON:
+		b = &jsonBackend{certsDir: paths.Certs()}
+	case BackendSQLite:
+		s, err := OpenSQLite(paths.Database(), paths.Certs())
+		if err != nil {
+			return err
+		}
+		b = s
+	default:
+		return fmt.Errorf("unknown metadata store: %s", name)
+	}
+	if err := backend.Close(); err != nil {
+		return err
+	}
+	backend = b
+	return nil
+}
+
+// SetBackend replaces the process-wide storage backend.
+func SetBackend(b Backend) {
+	backend = b
+}
+
+// Close releases the process-wide storage backend.
+func Close() error {
+	return backend.Close()
+}
+
+// Delete removes stored metadata for a domain.
+func Delete(domain string) error {
+	return backend.Delete(domain)
+}
+
+// AppendEvent records an event for a domain in the active backend.
+func AppendEvent(domain, kind, message string) error {
+	return backend.AppendEvent(domain, kind, message)
+}
+
+type jsonBackend struct {
+	certsDir string
+}
+
+func (j *jsonBackend) Save(m *CertMetadata) error {
+	if len(m.Domains) == 0 {
+		return fmt.Errorf("no domains in metadata")
+	}
+	metadataDir := filepath.Join(j.certsDir, m.Domains[0])
+	if err := os.MkdirAll(metadataDir, 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if data, err = seal(data); err != nil {
+		return err
+	}
+	// Write to a temp file and rename so readers never see a partial file
+	metadataFile := filepath.Join(metadataDir, "metadata.json")
+	tmp := metadataFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, metadataFile)
+}
+
+func (j *jsonBackend) Load(domain string) (*CertMetadata, error) {
+	data, err := os.ReadFile(filepath.Join(j.certsDir, domain, "metadata.json"))
+	if err != nil {
+		return nil, err
+	}
+	if data, err = unseal(data); err != nil {
+		return nil, fmt.Errorf("metadata for %s: %w", domain, err)
+	}
+	var m CertMetadata
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (j *jsonBackend) List() ([]string, error) {
+	entries, err := os.ReadDir(j.certsDir)
+	if err != nil {
+		return nil, err
+	}
+	var domains []string
+	for _, e := range entries {
+		if e.IsDir() {
+			// Check if metadata.json exists
+			if _, err := os.Stat(filepath.Join(j.certsDir, e.Name(), "metadata.json")); err == nil {
+				domains = append(domains, e.Name())
+			}
+		}
+	}
+	return domains, nil
+}
+
+func (j *jsonBackend) Delete(domain string) error {
+	err := os.Remove(filepath.Join(j.certsDir, domain, "metadata.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (j *jsonBackend) AppendEvent(domain, kind, message string) error {
+	// The JSON layout has no event log; events are only kept by the SQLite backend.
+	return nil
+}
+
+// AppendHistory appends one JSON line to <certs>/<domain>/history.jsonl.
+func (j *jsonBackend) AppendHistory(domain string, rec HistoryRecord) error {
+	dir := filepath.Join(j.certsDir, domain)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, "history.jsonl"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (j *jsonBackend) History(domain string) ([]HistoryRecord, error) {
+	f, err := os.Open(filepath.Join(j.certsDir, domain, "history.jsonl"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var out []HistoryRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec HistoryRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			// Skip a torn trailing line rather than losing the whole history
+			continue
+		}
+		out = append(out, rec)
+	}
+	return out, scanner.Err()
+}
+
+func (j *jsonBackend) Close() error {
+	return nil
+}
@@ -0,0 +1,284 @@
+package migrate
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/trustctl/trustctl/internal/account"
+	"github.com/trustctl/trustctl/internal/keygen"
+	"github.com/trustctl/trustctl/internal/metadata"
+	"github.com/trustctl/trustctl/internal/paths"
+	"github.com/trustctl/trustctl/internal/secretbox"
+)
+
+// Manifest describes an export archive and the layout of the host it came from,
+// so absolute paths in metadata and accounts can be rewritten on import.
+type Manifest struct {
+	Version     int       `json:"version"`
+	CreatedAt   time.Time `json:"created_at"`
+	Hostname    string    `json:"hostname"`
+	Certs       string    `json:"certs"`
+	Credentials string    `json:"credentials"`
+	Domains     []string  `json:"domains"`
+}
+
+const manifestVersion = 1
+
+// Archive members are grouped under these prefixes.
+const (
+	certsPrefix       = "certs/"
+	credentialsPrefix = "credentials/"
+	metadataPrefix    = "metadata/"
+	manifestName      = "manifest.json"
+)
+
+// Export writes an encrypted archive of all certificates, keys, accounts and
+// metadata to w and returns the manifest that was embedded in it.
+func Export(w io.Writer, passphrase []byte) (*Manifest, error) {
+	domains, err := metadata.ListAll()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+	man := &Manifest{
+		Version:     manifestVersion,
+		CreatedAt:   time.Now(),
+		Hostname:    host,
+		Certs:       paths.Certs(),
+		Credentials: paths.Credentials(),
+		Domains:     domains,
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	data, err := json.MarshalIndent(man, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeMember(tw, manifestName, data, 0600); err != nil {
+		return nil, err
+	}
+	// Metadata is exported through the active backend so SQLite stores migrate too
+	for _, d := range domains {
+		m, err := metadata.Load(d)
+		if err != nil {
+			return nil, fmt.Errorf("load metadata for %s: %w", d, err)
+		}
+		data, err := json.MarshalIndent(m, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		if err := writeMember(tw, metadataPrefix+d+".json", data, 0600); err != nil {
+			return nil, err
+		}
+	}
+	if err := addTree(tw, paths.Certs(), certsPrefix); err != nil {
+		return nil, err
+	}
+	if err := addTree(tw, paths.Credentials(), credentialsPrefix); err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+
+	sealed, err := secretbox.Seal(passphrase, buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(sealed); err != nil {
+		return nil, err
+	}
+	return man, nil
+}
+
+// Import restores an archive produced by Export into the current layout, rewriting
+// paths from the source host. Existing certificates are left alone unless force is set.
+func Import(r io.Reader, passphrase []byte, force bool) (*Manifest, error) {
+	sealed, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := secretbox.Open(passphrase, sealed)
+	if err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(plain))
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gz)
+
+	var man *Manifest
+	metas := map[string][]byte{}
+	files := map[string][]byte{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		switch name := path.Clean(hdr.Name); {
+		case name == manifestName:
+			man = &Manifest{}
+			if err := json.Unmarshal(data, man); err != nil {
+				return nil, fmt.Errorf("invalid manifest: %w", err)
+			}
+		case strings.HasPrefix(name, metadataPrefix):
+			metas[strings.TrimSuffix(strings.TrimPrefix(name, metadataPrefix), ".json")] = data
+		default:
+			files[name] = data
+		}
+	}
+	if man == nil {
+		return nil, errors.New("archive has no manifest")
+	}
+	if man.Version != manifestVersion {
+		return nil, fmt.Errorf("unsupported archive version %d", man.Version)
+	}
+
+	if !force {
+		for d := range metas {
+			if _, err := metadata.Load(d); err == nil {
+				return nil, fmt.Errorf("certificate %s already exists on this host (use --force to overwrite)", d)
+			}
+		}
+	}
+
+	// Imported keys have to meet this host's crypto policy, e.g. its minimum RSA key size
+	for name, data := range files {
+		if path.Base(name) == "privkey.pem" {
+			if _, err := keygen.ParsePrivateKey(data); err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+
+	fix := strings.NewReplacer(man.Certs, paths.Certs(), man.Credentials, paths.Credentials())
+	for name, data := range files {
+		dst, err := destination(name)
+		if err != nil {
+			return nil, err
+		}
+		if filepath.Base(dst) == "metadata.json" {
+			// Rewritten below from the backend-neutral copy
+			continue
+		}
+		if strings.HasPrefix(name, credentialsPrefix) && strings.HasSuffix(name, "-account.json") {
+			if data, err = fixAccount(data, fix); err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(dst, data, 0600); err != nil {
+			return nil, err
+		}
+	}
+	for d, data := range metas {
+		var m metadata.CertMetadata
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, fmt.Errorf("metadata for %s: %w", d, err)
+		}
+		m.CertPath = fix.Replace(m.CertPath)
+		m.KeyPath = fix.Replace(m.KeyPath)
+		m.ChainPath = fix.Replace(m.ChainPath)
+		m.CombinedPath = fix.Replace(m.CombinedPath)
+		m.CredentialsPath = fix.Replace(m.CredentialsPath)
+		m.HMACIDCred = fix.Replace(m.HMACIDCred)
+		if err := m.Store(); err != nil {
+			return nil, fmt.Errorf("store metadata for %s: %w", d, err)
+		}
+	}
+	return man, nil
+}
+
+// destination maps an archive member onto the local layout, refusing anything
+// that would escape the target directory.
+func destination(name string) (string, error) {
+	var base, rel string
+	switch {
+	case strings.HasPrefix(name, certsPrefix):
+		base, rel = paths.Certs(), strings.TrimPrefix(name, certsPrefix)
+	case strings.HasPrefix(name, credentialsPrefix):
+		base, rel = paths.Credentials(), strings.TrimPrefix(name, credentialsPrefix)
+	default:
+		return "", fmt.Errorf("unexpected archive member %s", name)
+	}
+	if rel == "" || strings.HasPrefix(rel, "../") || path.IsAbs(rel) {
+		return "", fmt.Errorf("unsafe archive member %s", name)
+	}
+	return filepath.Join(base, filepath.FromSlash(rel)), nil
+}
+
+func fixAccount(data []byte, fix *strings.Replacer) ([]byte, error) {
+	var a account.AccountInfo
+	if err := json.Unmarshal(data, &a); err != nil {
+		return nil, err
+	}
+	a.AccountKey = fix.Replace(a.AccountKey)
+	return json.MarshalIndent(&a, "", "  ")
+}
+
+func addTree(tw *tar.Writer, root, prefix string) error {
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		return writeMember(tw, prefix+filepath.ToSlash(rel), data, 0600)
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func writeMember(tw *tar.Writer, name string, data []byte, mode int64) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    mode,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
@@ -0,0 +1,261 @@
+// Package ocsp asks a certificate's OCSP responder (RFC 6960) whether it has been
+// revoked. Only what a status check needs is implemented: a SHA-1 CertID request
+// over HTTP POST and a verified BasicOCSPResponse.
+package ocsp
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/trustctl/trustctl/internal/httpclient"
+)
+
+// Status values of a Response.
+const (
+	Good    = "good"
+	Revoked = "revoked"
+	Unknown = "unknown"
+)
+
+// ErrNoResponder is returned for certificates that name no OCSP responder, as is
+// the case for CAs that only publish CRLs.
+var ErrNoResponder = errors.New("certificate names no OCSP responder")
+
+// Response is the responder's answer for one certificate.
+type Response struct {
+	Responder  string
+	Status     string
+	RevokedAt  time.Time
+	Reason     int // CRLReason, when revoked and given
+	ThisUpdate time.Time
+	NextUpdate time.Time
+}
+
+var (
+	oidSHA1          = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidBasicResponse = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+)
+
+type certID struct {
+	HashAlgorithm  pkix.AlgorithmIdentifier
+	IssuerNameHash []byte
+	IssuerKeyHash  []byte
+	SerialNumber   *big.Int
+}
+
+type ocspRequest struct {
+	TBSRequest struct {
+		RequestList []struct {
+			Cert certID
+		}
+	}
+}
+
+type responseASN1 struct {
+	Status   asn1.Enumerated
+	Response struct {
+		ResponseType asn1.ObjectIdentifier
+		Response     []byte
+	} `asn1:"explicit,tag:0,optional"`
+}
+
+type basicResponse struct {
+	TBSResponseData    asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type responseData struct {
+	Raw         asn1.RawContent
+	Version     int           `asn1:"optional,default:0,explicit,tag:0"`
+	ResponderID asn1.RawValue // byName [1] or byKey [2]
+	ProducedAt  time.Time     `asn1:"generalized"`
+	Responses   []singleResponse
+}
+
+type singleResponse struct {
+	CertID     certID
+	CertStatus asn1.RawValue
+	ThisUpdate time.Time `asn1:"generalized"`
+	NextUpdate time.Time `asn1:"generalized,explicit,tag:0,optional"`
+}
+
+type revokedInfo struct {
+	RevocationTime time.Time       `asn1:"generalized"`
+	Reason         asn1.Enumerated `asn1:"explicit,tag:0,optional"`
+}
+
+var signatureAlgorithms = map[string]x509.SignatureAlgorithm{
+	"1.2.840.113549.1.1.5":  x509.SHA1WithRSA,
+	"1.2.840.113549.1.1.11": x509.SHA256WithRSA,
+	"1.2.840.113549.1.1.12": x509.SHA384WithRSA,
+	"1.2.840.113549.1.1.13": x509.SHA512WithRSA,
+	"1.2.840.10045.4.1":     x509.ECDSAWithSHA1,
+	"1.2.840.10045.4.3.2":   x509.ECDSAWithSHA256,
+	"1.2.840.10045.4.3.3":   x509.ECDSAWithSHA384,
+	"1.2.840.10045.4.3.4":   x509.ECDSAWithSHA512,
+	"1.3.101.112":           x509.PureEd25519,
+}
+
+// newCertID identifies leaf to its issuer's responder.
+func newCertID(leaf, issuer *x509.Certificate) (certID, error) {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return certID{}, err
+	}
+	nameHash := sha1.Sum(issuer.RawSubject)
+	keyHash := sha1.Sum(spki.PublicKey.RightAlign())
+	return certID{
+		HashAlgorithm:  pkix.AlgorithmIdentifier{Algorithm: oidSHA1, Parameters: asn1.NullRawValue},
+		IssuerNameHash: nameHash[:],
+		IssuerKeyHash:  keyHash[:],
+		SerialNumber:   leaf.SerialNumber,
+	}, nil
+}
+
+// Check asks the first OCSP responder named in leaf for its status.
+func Check(ctx context.Context, leaf, issuer *x509.Certificate) (*Response, error) {
+	if len(leaf.OCSPServer) == 0 {
+		return nil, ErrNoResponder
+	}
+	responder := leaf.OCSPServer[0]
+	id, err := newCertID(leaf, issuer)
+	if err != nil {
+		return nil, err
+	}
+	var req ocspRequest
+	req.TBSRequest.RequestList = []struct{ Cert certID }{{Cert: id}}
+	body, err := asn1.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, responder, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	hreq.Header.Set("Content-Type", "application/ocsp-request")
+	hreq.Header.Set("Accept", "application/ocsp-response")
+	resp, err := httpclient.New(15 * time.Second).Do(hreq)
+	if err != nil {
+		return nil, fmt.Errorf("OCSP request to %s: %w", responder, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OCSP responder %s returned HTTP %d", responder, resp.StatusCode)
+	}
+	der, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	r, err := parse(der, id, issuer)
+	if err != nil {
+		return nil, fmt.Errorf("OCSP responder %s: %w", responder, err)
+	}
+	r.Responder = responder
+	return r, nil
+}
+
+// parse verifies an OCSPResponse from issuer (or a responder it delegated to) and
+// returns the status it gives for id.
+func parse(der []byte, id certID, issuer *x509.Certificate) (*Response, error) {
+	var outer responseASN1
+	if _, err := asn1.Unmarshal(der, &outer); err != nil {
+		return nil, fmt.Errorf("invalid OCSP response: %w", err)
+	}
+	if outer.Status != 0 {
+		return nil, fmt.Errorf("OCSP response status %d", outer.Status)
+	}
+	if !outer.Response.ResponseType.Equal(oidBasicResponse) {
+		return nil, fmt.Errorf("unsupported OCSP response type %s", outer.Response.ResponseType)
+	}
+	var basic basicResponse
+	if _, err := asn1.Unmarshal(outer.Response.Response, &basic); err != nil {
+		return nil, fmt.Errorf("invalid OCSP response: %w", err)
+	}
+	var data responseData
+	if _, err := asn1.Unmarshal(basic.TBSResponseData.FullBytes, &data); err != nil {
+		return nil, fmt.Errorf("invalid OCSP response data: %w", err)
+	}
+
+	alg, ok := signatureAlgorithms[basic.SignatureAlgorithm.Algorithm.String()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported OCSP signature algorithm %s", basic.SignatureAlgorithm.Algorithm)
+	}
+	signer := issuer
+	if len(basic.Certificates) > 0 {
+		// A delegated responder certificate, issued by the CA for OCSP signing
+		c, err := x509.ParseCertificate(basic.Certificates[0].FullBytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OCSP responder certificate: %w", err)
+		}
+		if !bytes.Equal(c.Raw, issuer.Raw) {
+			if err := c.CheckSignatureFrom(issuer); err != nil {
+				return nil, fmt.Errorf("OCSP responder certificate not issued by the CA: %w", err)
+			}
+			if !hasOCSPSigning(c) {
+				return nil, errors.New("OCSP responder certificate lacks the OCSP signing usage")
+			}
+			signer = c
+		}
+	}
+	if err := signer.CheckSignature(alg, data.Raw, basic.Signature.RightAlign()); err != nil {
+		return nil, fmt.Errorf("OCSP response signature: %w", err)
+	}
+
+	for _, single := range data.Responses {
+		if single.CertID.SerialNumber.Cmp(id.SerialNumber) != 0 ||
+			!bytes.Equal(single.CertID.IssuerKeyHash, id.IssuerKeyHash) {
+			continue
+		}
+		r := &Response{ThisUpdate: single.ThisUpdate, NextUpdate: single.NextUpdate}
+		switch single.CertStatus.Tag {
+		case 0:
+			r.Status = Good
+		case 1:
+			r.Status = Revoked
+			var info revokedInfo
+			if _, err := asn1.UnmarshalWithParams(single.CertStatus.FullBytes, &info, "tag:1"); err == nil {
+				r.RevokedAt, r.Reason = info.RevocationTime, int(info.Reason)
+			}
+		default:
+			r.Status = Unknown
+		}
+		return r, nil
+	}
+	return nil, errors.New("OCSP response does not cover the certificate")
+}
+
+func hasOCSPSigning(c *x509.Certificate) bool {
+	for _, u := range c.ExtKeyUsage {
+		if u == x509.ExtKeyUsageOCSPSigning {
+			return true
+		}
+	}
+	return false
+}
+
+// ReasonName returns the RFC 5280 name of a CRLReason code.
+func ReasonName(code int) string {
+	names := []string{"unspecified", "keyCompromise", "cACompromise", "affiliationChanged",
+		"superseded", "cessationOfOperation", "certificateHold", "", "removeFromCRL",
+		"privilegeWithdrawn", "aACompromise"}
+	if code >= 0 && code < len(names) && names[code] != "" {
+		return names[code]
+	}
+	return fmt.Sprintf("reason %d", code)
+}
@@ -0,0 +1,6 @@
+package paths
+
+// On macOS state lives under the Homebrew prefix, next to the nginx/httpd it serves.
+func platformDefault() Layout {
+	return Homebrew()
+}
@@ -0,0 +1,13 @@
+//go:build !darwin
+
+package paths
+
+import "os"
+
+// root keeps the /opt/trustctl layout; other users get one under their home directory.
+func platformDefault() Layout {
+	if os.Geteuid() != 0 {
+		return User()
+	}
+	return Default()
+}
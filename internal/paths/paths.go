@@ -0,0 +1,230 @@
+package paths
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Layout describes where trustctl keeps its state on disk. Every package resolves
+// its directories through the active Layout instead of hardcoding /opt/trustctl.
+type Layout struct {
+	Base        string
+	Certs       string
+	Credentials string
+	Plugins     string
+	Logs        string
+	Database    string
+	Webroot     string
+}
+
+// DefaultWebroot is the document root used for HTTP validation when none is configured.
+const DefaultWebroot = "/var/www/html"
+
+// FromBase returns the self-contained layout rooted at base (the historical /opt/trustctl shape).
+func FromBase(base string) Layout {
+	return Layout{
+		Base:        base,
+		Certs:       filepath.Join(base, "certs"),
+		Credentials: filepath.Join(base, "credentials"),
+		Plugins:     filepath.Join(base, "plugins"),
+		Logs:        filepath.Join(base, "logs"),
+		Database:    filepath.Join(base, "trustctl.db"),
+		Webroot:     DefaultWebroot,
+	}
+}
+
+// Default returns the /opt/trustctl layout.
+func Default() Layout {
+	return FromBase("/opt/trustctl")
+}
+
+// User returns the layout rooted at UserBase, used by default when not running as root
+// so development and rootless setups need no write access to /opt. The webroot stays
+// the system one.
+func User() Layout {
+	return FromBase(UserBase())
+}
+
+// UserBase returns $XDG_CONFIG_HOME/trustctl, else ~/.config/trustctl. Without a
+// usable home directory it returns /opt/trustctl.
+func UserBase() string {
+	if d := os.Getenv("XDG_CONFIG_HOME"); filepath.IsAbs(d) {
+		return filepath.Join(d, "trustctl")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil || !filepath.IsAbs(home) {
+		return Default().Base
+	}
+	return filepath.Join(home, ".config", "trustctl")
+}
+
+// FHS returns a Filesystem Hierarchy Standard layout for distro packages:
+// secrets under /etc, state under /var/lib, plugins under /usr/lib and logs under /var/log.
+func FHS() Layout {
+	return Layout{
+		Base:        "/var/lib/trustctl",
+		Certs:       "/var/lib/trustctl/certs",
+		Credentials: "/etc/trustctl/credentials",
+		Plugins:     "/usr/lib/trustctl/plugins",
+		Logs:        "/var/log/trustctl",
+		Database:    "/var/lib/trustctl/trustctl.db",
+		Webroot:     DefaultWebroot,
+	}
+}
+
+// Homebrew returns a layout under the Homebrew prefix for macOS development and homelab hosts:
+// state under <prefix>/var/lib, credentials under <prefix>/etc and the Homebrew nginx/httpd docroot.
+func Homebrew() Layout {
+	prefix := HomebrewPrefix()
+	return Layout{
+		Base:        filepath.Join(prefix, "var/lib/trustctl"),
+		Certs:       filepath.Join(prefix, "var/lib/trustctl/certs"),
+		Credentials: filepath.Join(prefix, "etc/trustctl/credentials"),
+		Plugins:     filepath.Join(prefix, "lib/trustctl/plugins"),
+		Logs:        filepath.Join(prefix, "var/log/trustctl"),
+		Database:    filepath.Join(prefix, "var/lib/trustctl/trustctl.db"),
+		Webroot:     filepath.Join(prefix, "var/www"),
+	}
+}
+
+// HomebrewPrefix returns $HOMEBREW_PREFIX, or /opt/homebrew (Apple silicon) when present, else /usr/local.
+func HomebrewPrefix() string {
+	if p := os.Getenv("HOMEBREW_PREFIX"); p != "" {
+		return p
+	}
+	if fi, err := os.Stat("/opt/homebrew"); err == nil && fi.IsDir() {
+		return "/opt/homebrew"
+	}
+	return "/usr/local"
+}
+
+var current = platformDefault()
+
+// Set replaces the active layout.
+func Set(l Layout) {
+	current = l
+}
+
+// Current returns the active layout.
+func Current() Layout {
+	return current
+}
+
+// LoadEnv applies TRUSTCTL_LAYOUT (opt|fhs|homebrew|user), the base directory home
+// (else TRUSTCTL_HOME), which roots the whole layout like /opt/trustctl, and the
+// per-directory overrides TRUSTCTL_CERTS_DIR, TRUSTCTL_CREDENTIALS_DIR,
+// TRUSTCTL_PLUGINS_DIR, TRUSTCTL_LOGS_DIR, TRUSTCTL_DB and TRUSTCTL_WEBROOT to the
+// active layout.
+func LoadEnv(home string) error {
+	switch v := os.Getenv("TRUSTCTL_LAYOUT"); v {
+	case "":
+	case "opt":
+		current = Default()
+	case "fhs":
+		current = FHS()
+	case "homebrew":
+		current = Homebrew()
+	case "user":
+		current = User()
+	default:
+		return fmt.Errorf("unknown TRUSTCTL_LAYOUT: %s (expected opt, fhs, homebrew or user)", v)
+	}
+	if home == "" {
+		home = os.Getenv("TRUSTCTL_HOME")
+	}
+	if home != "" {
+		if !filepath.IsAbs(home) {
+			return fmt.Errorf("trustctl home must be an absolute path: %s", home)
+		}
+		current = FromBase(filepath.Clean(home))
+	}
+	for _, o := range overrides(&current) {
+		if v := os.Getenv(o.env); v != "" {
+			if !filepath.IsAbs(v) {
+				return fmt.Errorf("%s must be an absolute path: %s", o.env, v)
+			}
+			*o.dst = v
+		}
+	}
+	return nil
+}
+
+// Override applies the non-empty directories of dirs (from the configuration file)
+// to the active layout. Directories set through their TRUSTCTL_* environment
+// variable keep that value.
+func Override(dirs Layout) error {
+	src := overrides(&dirs)
+	for i, o := range overrides(&current) {
+		v := *src[i].dst
+		if v == "" || os.Getenv(o.env) != "" {
+			continue
+		}
+		if !filepath.IsAbs(v) {
+			return fmt.Errorf("%s must be an absolute path: %s", o.name, v)
+		}
+		*o.dst = v
+	}
+	return nil
+}
+
+type override struct {
+	env  string
+	name string // configuration file key
+	dst  *string
+}
+
+// overrides lists the directories of l that can be set one by one.
+func overrides(l *Layout) []override {
+	return []override{
+		{"TRUSTCTL_CERTS_DIR", "certs_dir", &l.Certs},
+		{"TRUSTCTL_CREDENTIALS_DIR", "credentials_dir", &l.Credentials},
+		{"TRUSTCTL_PLUGINS_DIR", "plugins_dir", &l.Plugins},
+		{"TRUSTCTL_LOGS_DIR", "logs_dir", &l.Logs},
+		{"TRUSTCTL_DB", "database", &l.Database},
+		{"TRUSTCTL_WEBROOT", "webroot", &l.Webroot},
+	}
+}
+
+// Rootless reports whether trustctl runs without root, where system service managers
+// (systemctl reloads, /etc/systemd units) are not available to it.
+func Rootless() bool {
+	return os.Geteuid() != 0
+}
+
+// Base returns the root of trustctl's state.
+func Base() string { return current.Base }
+
+// Certs returns the directory holding one subdirectory per managed certificate.
+func Certs() string { return current.Certs }
+
+// Credentials returns the directory holding CA accounts and provider credentials.
+func Credentials() string { return current.Credentials }
+
+// Plugins returns the directory DNS provider plugins are loaded from.
+func Plugins() string { return current.Plugins }
+
+// Logs returns the log directory.
+func Logs() string { return current.Logs }
+
+// Database returns the SQLite metadata database path.
+func Database() string { return current.Database }
+
+// Webroot returns the default document root for HTTP validation.
+func Webroot() string { return current.Webroot }
+
+// CertDir returns the directory for a certificate's files, keyed by its primary domain.
+func CertDir(domain string) string {
+	return filepath.Join(current.Certs, domain)
+}
+
+// Hooks returns the directory holding the pre, post and deploy hook directories
+// whose executables run for every certificate.
+func Hooks() string {
+	return filepath.Join(current.Base, "hooks")
+}
+
+// LockFile returns the path of the named lock file under <base>/locks.
+func LockFile(name string) string {
+	return filepath.Join(current.Base, "locks", name+".lock")
+}
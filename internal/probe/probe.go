@@ -0,0 +1,258 @@
+package probe
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/trustctl/trustctl/internal/cryptopolicy"
+)
+
+// STARTTLS protocols understood by Dial.
+const (
+	StartTLSNone = ""
+	StartTLSSMTP = "smtp"
+	StartTLSIMAP = "imap"
+	StartTLSPOP3 = "pop3"
+	StartTLSLDAP = "ldap"
+)
+
+// DefaultStartTLS picks the STARTTLS protocol conventionally spoken on port.
+func DefaultStartTLS(port string) string {
+	switch port {
+	case "25", "587":
+		return StartTLSSMTP
+	case "143":
+		return StartTLSIMAP
+	case "110":
+		return StartTLSPOP3
+	case "389":
+		return StartTLSLDAP
+	}
+	return StartTLSNone
+}
+
+// Result describes what an endpoint served during the handshake.
+type Result struct {
+	Addr       string
+	ServerName string
+	State      tls.ConnectionState
+	// VerifyErr is the chain verification error against the system roots, nil if trusted
+	VerifyErr error
+	// HostnameErr is set when the leaf does not cover ServerName
+	HostnameErr error
+}
+
+// Leaf returns the certificate presented first by the server.
+func (r *Result) Leaf() *x509.Certificate {
+	if len(r.State.PeerCertificates) == 0 {
+		return nil
+	}
+	return r.State.PeerCertificates[0]
+}
+
+// Options tune a probe.
+type Options struct {
+	ServerName string
+	StartTLS   string
+	Timeout    time.Duration
+	// Config overrides the TLS client configuration (protocol/cipher restrictions); nil uses the crypto policy default
+	Config *tls.Config
+}
+
+// Dial connects to addr (host:port), optionally upgrades via STARTTLS, completes a TLS
+// handshake with SNI and verifies the served chain. Untrusted chains still produce
+// a Result so callers can report what was served.
+func Dial(addr string, opts Options) (*Result, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if opts.ServerName == "" {
+		opts.ServerName = host
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	cfg := opts.Config
+	if cfg == nil {
+		cfg = cryptopolicy.TLSConfig()
+	}
+	cfg = cfg.Clone()
+	cfg.ServerName = opts.ServerName
+	// Verification is done below so an invalid chain can still be reported
+	cfg.InsecureSkipVerify = true
+
+	conn, err := net.DialTimeout("tcp", addr, opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(opts.Timeout))
+
+	if err := startTLS(conn, opts.StartTLS); err != nil {
+		return nil, fmt.Errorf("starttls %s: %w", opts.StartTLS, err)
+	}
+	tc := tls.Client(conn, cfg)
+	if err := tc.Handshake(); err != nil {
+		return nil, err
+	}
+	res := &Result{Addr: addr, ServerName: opts.ServerName, State: tc.ConnectionState()}
+	if leaf := res.Leaf(); leaf != nil {
+		inter := x509.NewCertPool()
+		for _, c := range res.State.PeerCertificates[1:] {
+			inter.AddCert(c)
+		}
+		_, res.VerifyErr = leaf.Verify(x509.VerifyOptions{Intermediates: inter})
+		res.HostnameErr = leaf.VerifyHostname(opts.ServerName)
+	} else {
+		res.VerifyErr = errors.New("server presented no certificate")
+	}
+	return res, nil
+}
+
+func startTLS(conn net.Conn, proto string) error {
+	r := bufio.NewReader(conn)
+	switch proto {
+	case StartTLSNone:
+		return nil
+	case StartTLSSMTP:
+		if _, err := readSMTP(r, 220); err != nil {
+			return err
+		}
+		fmt.Fprintf(conn, "EHLO trustctl\r\n")
+		if _, err := readSMTP(r, 250); err != nil {
+			return err
+		}
+		fmt.Fprintf(conn, "STARTTLS\r\n")
+		_, err := readSMTP(r, 220)
+		return err
+	case StartTLSIMAP:
+		if err := expectPrefix(r, "* OK"); err != nil {
+			return err
+		}
+		fmt.Fprintf(conn, "a1 STARTTLS\r\n")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return err
+			}
+			if strings.HasPrefix(line, "a1 ") {
+				if !strings.HasPrefix(line, "a1 OK") {
+					return fmt.Errorf("server refused: %s", strings.TrimSpace(line))
+				}
+				return nil
+			}
+		}
+	case StartTLSPOP3:
+		if err := expectPrefix(r, "+OK"); err != nil {
+			return err
+		}
+		fmt.Fprintf(conn, "STLS\r\n")
+		return expectPrefix(r, "+OK")
+	case StartTLSLDAP:
+		return ldapStartTLS(conn, r)
+	default:
+		return fmt.Errorf("unsupported protocol %q", proto)
+	}
+}
+
+// readSMTP reads a (possibly multi-line) SMTP reply and checks its code.
+func readSMTP(r *bufio.Reader, want int) (string, error) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		if len(line) < 4 {
+			return "", fmt.Errorf("short reply: %q", line)
+		}
+		code, err := strconv.Atoi(line[:3])
+		if err != nil {
+			return "", fmt.Errorf("malformed reply: %q", line)
+		}
+		if line[3] == '-' {
+			continue
+		}
+		if code != want {
+			return "", fmt.Errorf("unexpected reply: %s", strings.TrimSpace(line))
+		}
+		return line, nil
+	}
+}
+
+func expectPrefix(r *bufio.Reader, prefix string) error {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, prefix) {
+		return fmt.Errorf("unexpected reply: %s", strings.TrimSpace(line))
+	}
+	return nil
+}
+
+// ldapStartTLSRequest is a BER-encoded LDAPMessage (id 1) carrying the StartTLS
+// ExtendedRequest (OID 1.3.6.1.4.1.1466.20037).
+var ldapStartTLSRequest = append([]byte{0x30, 0x1d, 0x02, 0x01, 0x01, 0x77, 0x18, 0x80, 0x16}, "1.3.6.1.4.1.1466.20037"...)
+
+func ldapStartTLS(conn net.Conn, r *bufio.Reader) error {
+	if _, err := conn.Write(ldapStartTLSRequest); err != nil {
+		return err
+	}
+	// Read the response envelope: SEQUENCE tag then BER length
+	tag, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if tag != 0x30 {
+		return fmt.Errorf("unexpected LDAP response tag 0x%x", tag)
+	}
+	n, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	length := int(n)
+	if n&0x80 != 0 {
+		length = 0
+		for i := 0; i < int(n&0x7f); i++ {
+			b, err := r.ReadByte()
+			if err != nil {
+				return err
+			}
+			length = length<<8 | int(b)
+		}
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return err
+	}
+	// ExtendedResponse (0x78) begins with resultCode ENUMERATED; 0 means success
+	i := strings.IndexByte(string(body), 0x78)
+	if i < 0 || i+5 > len(body) {
+		return errors.New("malformed LDAP extended response")
+	}
+	// Skip the 0x78 tag and its length to reach the ENUMERATED resultCode
+	skip := 2
+	if body[i+1]&0x80 != 0 {
+		skip += int(body[i+1] & 0x7f)
+	}
+	if i+skip > len(body) {
+		return errors.New("malformed LDAP extended response")
+	}
+	rc := body[i+skip:]
+	if len(rc) < 3 || rc[0] != 0x0a || rc[1] != 0x01 {
+		return errors.New("malformed LDAP result code")
+	}
+	if rc[2] != 0 {
+		return fmt.Errorf("server refused StartTLS (resultCode %d)", rc[2])
+	}
+	return nil
+}
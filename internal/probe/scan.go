@@ -0,0 +1,157 @@
+package probe
+
+import (
+	"crypto/tls"
+	"net/http"
+	"time"
+)
+
+// Finding is one observation made by Scan.
+type Finding struct {
+	Severity string // ok, warn, fail
+	Message  string
+}
+
+// Severities used in findings.
+const (
+	SeverityOK   = "ok"
+	SeverityWarn = "warn"
+	SeverityFail = "fail"
+)
+
+// ScanReport grades an endpoint's TLS configuration.
+type ScanReport struct {
+	Addr        string
+	Protocols   map[string]bool // protocol name -> accepted
+	WeakCiphers []string
+	ChainErr    error
+	OCSPStapled bool
+	HSTS        string // header value, empty when absent or not checked
+	Findings    []Finding
+	Grade       string
+}
+
+var scanProtocols = []struct {
+	name    string
+	version uint16
+}{
+	{"TLS 1.0", tls.VersionTLS10},
+	{"TLS 1.1", tls.VersionTLS11},
+	{"TLS 1.2", tls.VersionTLS12},
+	{"TLS 1.3", tls.VersionTLS13},
+}
+
+// Scan probes which protocol versions and weak cipher suites addr accepts, checks
+// chain completeness, OCSP stapling and (for plain TLS endpoints) HSTS, then grades the result.
+func Scan(addr string, opts Options) (*ScanReport, error) {
+	// Baseline handshake with defaults; fails the scan if the endpoint is unreachable
+	base, err := Dial(addr, Options{ServerName: opts.ServerName, StartTLS: opts.StartTLS, Timeout: opts.Timeout, Config: &tls.Config{}})
+	if err != nil {
+		return nil, err
+	}
+	rep := &ScanReport{
+		Addr:        addr,
+		Protocols:   map[string]bool{},
+		ChainErr:    base.VerifyErr,
+		OCSPStapled: len(base.State.OCSPResponse) > 0,
+	}
+
+	for _, p := range scanProtocols {
+		cfg := &tls.Config{MinVersion: p.version, MaxVersion: p.version}
+		_, err := Dial(addr, Options{ServerName: opts.ServerName, StartTLS: opts.StartTLS, Timeout: opts.Timeout, Config: cfg})
+		rep.Protocols[p.name] = err == nil
+	}
+
+	for _, cs := range tls.InsecureCipherSuites() {
+		cfg := &tls.Config{MinVersion: tls.VersionTLS10, MaxVersion: tls.VersionTLS12, CipherSuites: []uint16{cs.ID}}
+		if _, err := Dial(addr, Options{ServerName: opts.ServerName, StartTLS: opts.StartTLS, Timeout: opts.Timeout, Config: cfg}); err == nil {
+			rep.WeakCiphers = append(rep.WeakCiphers, cs.Name)
+		}
+	}
+
+	if opts.StartTLS == StartTLSNone {
+		rep.HSTS = fetchHSTS(addr, base.ServerName, opts.Timeout)
+	}
+
+	rep.grade()
+	return rep, nil
+}
+
+func fetchHSTS(addr, serverName string, timeout time.Duration) string {
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	client := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{ServerName: serverName, InsecureSkipVerify: true},
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	req, err := http.NewRequest(http.MethodHead, "https://"+addr+"/", nil)
+	if err != nil {
+		return ""
+	}
+	req.Host = serverName
+	resp, err := client.Do(req)
+	if err != nil {
+		return ""
+	}
+	resp.Body.Close()
+	return resp.Header.Get("Strict-Transport-Security")
+}
+
+// grade turns observations into findings and a letter grade: A (clean), B (warnings),
+// C (legacy protocols or weak ciphers), F (untrusted or incomplete chain).
+func (r *ScanReport) grade() {
+	add := func(sev, msg string) { r.Findings = append(r.Findings, Finding{sev, msg}) }
+	worst := SeverityOK
+
+	for _, p := range scanProtocols {
+		accepted := r.Protocols[p.name]
+		switch {
+		case accepted && p.version < tls.VersionTLS12:
+			add(SeverityFail, p.name+" is enabled")
+			worst = SeverityFail
+		case !accepted && p.version == tls.VersionTLS13:
+			add(SeverityWarn, "TLS 1.3 is not supported")
+			if worst == SeverityOK {
+				worst = SeverityWarn
+			}
+		case accepted:
+			add(SeverityOK, p.name+" is enabled")
+		}
+	}
+	for _, c := range r.WeakCiphers {
+		add(SeverityFail, "weak cipher suite accepted: "+c)
+		worst = SeverityFail
+	}
+	if r.OCSPStapled {
+		add(SeverityOK, "OCSP response is stapled")
+	} else {
+		add(SeverityWarn, "OCSP stapling is not enabled")
+		if worst == SeverityOK {
+			worst = SeverityWarn
+		}
+	}
+	if r.HSTS != "" {
+		add(SeverityOK, "HSTS header present: "+r.HSTS)
+	} else {
+		add(SeverityWarn, "no Strict-Transport-Security header")
+		if worst == SeverityOK {
+			worst = SeverityWarn
+		}
+	}
+
+	switch {
+	case r.ChainErr != nil:
+		add(SeverityFail, "chain does not verify (missing intermediate or untrusted root): "+r.ChainErr.Error())
+		r.Grade = "F"
+	case worst == SeverityFail:
+		r.Grade = "C"
+	case worst == SeverityWarn:
+		r.Grade = "B"
+	default:
+		r.Grade = "A"
+	}
+}
@@ -0,0 +1,150 @@
+// Package ratelimit recognizes CA rate-limit responses and retries requests with
+// exponential backoff and jitter, honouring Retry-After.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// DefaultRetryAfter is assumed when a CA refuses with a rate limit but does not say
+// when to come back.
+const DefaultRetryAfter = time.Hour
+
+// Error reports that a CA refused a request because of a rate limit.
+type Error struct {
+	Detail     string
+	RetryAfter time.Time // zero when the CA did not say
+	Err        error     // underlying CA error, if any
+}
+
+func (e *Error) Error() string {
+	msg := "rate limited by CA"
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	if !e.RetryAfter.IsZero() {
+		msg += fmt.Sprintf(" (retry after %s)", e.RetryAfter.Format(time.RFC3339))
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error { return e.Err }
+
+// Until returns when the limited request may be retried, DefaultRetryAfter from now
+// if the CA did not say.
+func (e *Error) Until() time.Time {
+	if e.RetryAfter.IsZero() {
+		return time.Now().Add(DefaultRetryAfter)
+	}
+	return e.RetryAfter
+}
+
+// ParseRetryAfter parses a Retry-After header (delay in seconds or an HTTP date).
+func ParseRetryAfter(v string, now time.Time) (time.Time, bool) {
+	if v == "" {
+		return time.Time{}, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return now.Add(time.Duration(secs) * time.Second), true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// Let's Encrypt states the retry time in the problem detail, e.g.
+// "too many certificates (5) already issued for this exact set of domains in the last
+// 168 hours: example.com, retry after 2024-01-02 15:04:05 UTC".
+var reDetailRetry = regexp.MustCompile(`retry after (\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2})(?: ?UTC|Z)?`)
+
+// RetryAfterFromDetail extracts a retry time from a CA error message.
+func RetryAfterFromDetail(detail string) (time.Time, bool) {
+	m := reDetailRetry.FindStringSubmatch(detail)
+	if m == nil {
+		return time.Time{}, false
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02T15:04:05"} {
+		if t, err := time.ParseInLocation(layout, m[1], time.UTC); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// FromResponse returns an *Error for a 429 response, nil otherwise. detail is the
+// CA's message, if already read from the body.
+func FromResponse(resp *http.Response, detail string) *Error {
+	if resp == nil || resp.StatusCode != http.StatusTooManyRequests {
+		return nil
+	}
+	e := &Error{Detail: detail}
+	if t, ok := ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+		e.RetryAfter = t
+	} else if t, ok := RetryAfterFromDetail(detail); ok {
+		e.RetryAfter = t
+	}
+	return e
+}
+
+// Backoff returns the delay before retry attempt n (0-based): base doubled per
+// attempt, capped at max, with full jitter.
+func Backoff(attempt int, base, max time.Duration) time.Duration {
+	d := base
+	for i := 0; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return time.Duration(rand.Int63n(int64(d)) + 1)
+}
+
+// Policy bounds Retry.
+type Policy struct {
+	Attempts int           // total tries, including the first
+	Base     time.Duration // first backoff
+	Max      time.Duration // backoff cap
+	MaxWait  time.Duration // give up when the CA asks to wait longer than this
+}
+
+// DefaultPolicy retries a few times over about a minute; longer limits are left to
+// the next renewal run.
+var DefaultPolicy = Policy{Attempts: 4, Base: 2 * time.Second, Max: 30 * time.Second, MaxWait: time.Minute}
+
+// Retry calls fn until it succeeds or fails with something other than a rate limit.
+// Rate-limited attempts wait for Retry-After (plus jitter) or the backoff; when the
+// wait would exceed p.MaxWait or the attempts run out, the last *Error is returned.
+func Retry(ctx context.Context, p Policy, notify func(wait time.Duration, err *Error), fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		var rl *Error
+		if err == nil || !errors.As(err, &rl) || attempt+1 >= p.Attempts {
+			return err
+		}
+		wait := Backoff(attempt, p.Base, p.Max)
+		if !rl.RetryAfter.IsZero() {
+			wait = time.Until(rl.RetryAfter) + Backoff(0, p.Base, p.Base)
+		}
+		if wait > p.MaxWait {
+			return err
+		}
+		if notify != nil {
+			notify(wait, rl)
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package schedule
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Label names the scheduled renewal job (launchd label, systemd unit prefix).
+const Label = "io.trustctl.renew"
+
+// DefaultJitter is the random delay NewJob allows before each run.
+const DefaultJitter = time.Hour
+
+// Job describes the periodic `trustctl renew` run installed by Install.
+type Job struct {
+	Executable string // absolute path of the trustctl binary
+	LogFile    string // where stdout/stderr go when the scheduler does not capture them
+	// Jitter is the longest random delay before each run, so hosts sharing the
+	// schedule do not all reach the CA at the same minute.
+	Jitter time.Duration
+}
+
+// NewJob returns a job running the current executable.
+func NewJob(logDir string) (*Job, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return nil, err
+	}
+	return &Job{Executable: exe, LogFile: filepath.Join(logDir, "renew.log"), Jitter: DefaultJitter}, nil
+}
@@ -0,0 +1,84 @@
+package schedule
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// plistPath is /Library/LaunchDaemons for root and ~/Library/LaunchAgents otherwise.
+func plistPath() (string, error) {
+	if os.Geteuid() == 0 {
+		return filepath.Join("/Library/LaunchDaemons", Label+".plist"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, "Library/LaunchAgents", Label+".plist"), nil
+}
+
+// Install writes a launchd job running `trustctl renew` at 03:17 and 15:17 and loads it.
+// launchd cannot randomize start times, so the job passes j.Jitter to renew --jitter.
+func (j *Job) Install() (string, error) {
+	p, err := plistPath()
+	if err != nil {
+		return "", err
+	}
+	plist := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>%s</string>
+	<key>ProgramArguments</key>
+	<array>
+		<string>%s</string>
+		<string>renew</string>
+		<string>--jitter</string>
+		<string>%s</string>
+	</array>
+	<key>StartCalendarInterval</key>
+	<array>
+		<dict><key>Hour</key><integer>3</integer><key>Minute</key><integer>17</integer></dict>
+		<dict><key>Hour</key><integer>15</integer><key>Minute</key><integer>17</integer></dict>
+	</array>
+	<key>StandardOutPath</key>
+	<string>%s</string>
+	<key>StandardErrorPath</key>
+	<string>%s</string>
+</dict>
+</plist>
+`, Label, j.Executable, j.Jitter, j.LogFile, j.LogFile)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return "", err
+	}
+	// Reload cleanly if an older definition is loaded
+	exec.Command("launchctl", "unload", p).Run()
+	if err := os.WriteFile(p, []byte(plist), 0644); err != nil {
+		return "", err
+	}
+	return p, launchctl("load", "-w", p)
+}
+
+// Remove unloads and deletes the launchd job.
+func Remove() (string, error) {
+	p, err := plistPath()
+	if err != nil {
+		return "", err
+	}
+	if err := launchctl("unload", "-w", p); err != nil {
+		return "", err
+	}
+	return p, os.Remove(p)
+}
+
+func launchctl(args ...string) error {
+	out, err := exec.Command("launchctl", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("launchctl %s failed: %v: %s", args[0], err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
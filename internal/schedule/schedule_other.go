@@ -0,0 +1,103 @@
+//go:build !darwin
+
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+var unitName = strings.ReplaceAll(Label, ".", "-")
+
+// unitDir is /etc/systemd/system for root; other users get user units, run by their
+// own systemd instance (systemctl --user).
+func unitDir() (string, error) {
+	if os.Geteuid() == 0 {
+		return "/etc/systemd/system", nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "systemd", "user"), nil
+}
+
+// Install writes a systemd service and timer running `trustctl renew` twice a day, delayed by
+// up to j.Jitter, and enables the timer.
+func (j *Job) Install() (string, error) {
+	if _, err := exec.LookPath("systemctl"); err != nil {
+		return "", errors.New("systemctl not found; schedule `trustctl renew` with cron instead")
+	}
+	service := fmt.Sprintf(`[Unit]
+Description=trustctl certificate renewal
+
+[Service]
+Type=oneshot
+ExecStart=%s renew
+# 3: nothing was due
+SuccessExitStatus=3
+`, j.Executable)
+	timer := fmt.Sprintf(`[Unit]
+Description=Run trustctl renewal twice a day
+
+[Timer]
+OnCalendar=*-*-* 03,15:17:00
+RandomizedDelaySec=%d
+Persistent=true
+
+[Install]
+WantedBy=timers.target
+`, int(j.Jitter.Seconds()))
+	dir, err := unitDir()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	svcPath := filepath.Join(dir, unitName+".service")
+	timerPath := filepath.Join(dir, unitName+".timer")
+	if err := os.WriteFile(svcPath, []byte(service), 0644); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(timerPath, []byte(timer), 0644); err != nil {
+		return "", err
+	}
+	if err := systemctl("daemon-reload"); err != nil {
+		return "", err
+	}
+	return timerPath, systemctl("enable", "--now", unitName+".timer")
+}
+
+// Remove disables the timer and deletes both units.
+func Remove() (string, error) {
+	dir, err := unitDir()
+	if err != nil {
+		return "", err
+	}
+	timerPath := filepath.Join(dir, unitName+".timer")
+	if err := systemctl("disable", "--now", unitName+".timer"); err != nil {
+		return "", err
+	}
+	os.Remove(filepath.Join(dir, unitName+".service"))
+	if err := os.Remove(timerPath); err != nil {
+		return "", err
+	}
+	return timerPath, systemctl("daemon-reload")
+}
+
+func systemctl(args ...string) error {
+	cmdArgs := args
+	if os.Geteuid() != 0 {
+		cmdArgs = append([]string{"--user"}, args...)
+	}
+	out, err := exec.Command("systemctl", cmdArgs...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("systemctl %s failed: %v: %s", args[0], err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package secret
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+)
+
+// Source says where a secret may come from. Read tries, in order: Value (a
+// command-line flag, visible in the process list), File ("-" for stdin), the
+// systemd credential Credential, the environment variable Env, and finally an
+// interactive no-echo prompt.
+type Source struct {
+	Name  string // human name used in prompts and errors, e.g. "HMAC key"
+	Value string
+	File  string
+	// Credential names a systemd credential (LoadCredential= or
+	// LoadCredentialEncrypted=), read from $CREDENTIALS_DIRECTORY when set.
+	Credential string
+	Env        string
+	Confirm    bool // prompt twice, for secrets that protect new data
+}
+
+// ErrNoTerminal is returned when a prompt is needed but stdin is not a terminal.
+var ErrNoTerminal = errors.New("no terminal to prompt on")
+
+// Read resolves the secret described by s.
+func Read(s Source) ([]byte, error) {
+	switch {
+	case s.Value != "":
+		return []byte(s.Value), nil
+	case s.File == "-":
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		return nonEmpty(s, []byte(strings.TrimRight(line, "\r\n")))
+	case s.File != "":
+		data, err := os.ReadFile(s.File)
+		if err != nil {
+			return nil, err
+		}
+		return nonEmpty(s, bytes.TrimRight(data, "\r\n"))
+	}
+	if dir := os.Getenv("CREDENTIALS_DIRECTORY"); s.Credential != "" && dir != "" {
+		data, err := os.ReadFile(filepath.Join(dir, s.Credential))
+		if err == nil {
+			return nonEmpty(s, bytes.TrimRight(data, "\r\n"))
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	if s.Env != "" {
+		if v := os.Getenv(s.Env); v != "" {
+			return []byte(v), nil
+		}
+	}
+	if !IsTerminal(os.Stdin) {
+		return nil, fmt.Errorf("%s required: %w", s.Name, ErrNoTerminal)
+	}
+	v, err := Prompt(s.Name + ": ")
+	if err != nil {
+		return nil, err
+	}
+	if s.Confirm {
+		again, err := Prompt("Repeat " + s.Name + ": ")
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(v, again) {
+			return nil, fmt.Errorf("%s entries do not match", s.Name)
+		}
+	}
+	return nonEmpty(s, v)
+}
+
+func nonEmpty(s Source, v []byte) ([]byte, error) {
+	if len(v) == 0 {
+		return nil, fmt.Errorf("empty %s", s.Name)
+	}
+	return v, nil
+}
+
+// IsTerminal reports whether f is a terminal. /dev/null is a character device too,
+// so the terminal driver is asked as well.
+func IsTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	cmd := exec.Command("stty", "-g")
+	cmd.Stdin = f
+	return cmd.Run() == nil
+}
+
+// Prompt writes prompt to stderr and reads one line from the terminal with echo disabled.
+func Prompt(prompt string) ([]byte, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	if err := stty("-echo"); err != nil {
+		return nil, fmt.Errorf("cannot disable echo: %w", err)
+	}
+	// Restore echo if interrupted mid-prompt, then exit as the signal would have
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-sig:
+			stty("echo")
+			fmt.Fprintln(os.Stderr)
+			os.Exit(130)
+		case <-done:
+		}
+	}()
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	close(done)
+	signal.Stop(sig)
+	stty("echo")
+	fmt.Fprintln(os.Stderr)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return []byte(strings.TrimRight(line, "\r\n")), nil
+}
+
+func stty(arg string) error {
+	cmd := exec.Command("stty", arg)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}
@@ -0,0 +1,172 @@
+package secretbox
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"io"
+)
+
+// Sealed blobs are laid out as: magic | salt | nonce | AES-256-GCM ciphertext.
+// The key is derived from a passphrase with PBKDF2-HMAC-SHA256.
+// Blobs sealed with a raw key (SealWithKey) use keyMagic and carry no salt.
+var (
+	magic    = []byte("TCBOX1")
+	keyMagic = []byte("TCKEY1")
+)
+
+// KeySize is the length of raw keys accepted by SealWithKey and OpenWithKey.
+const KeySize = 32
+
+const (
+	saltSize   = 16
+	iterations = 600000
+)
+
+// ErrDecrypt is returned when a blob cannot be opened, usually because of a wrong passphrase.
+var ErrDecrypt = errors.New("decryption failed (wrong passphrase or corrupted data)")
+
+// Seal encrypts plaintext under a key derived from passphrase.
+func Seal(passphrase, plaintext []byte) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, errors.New("empty passphrase")
+	}
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, len(magic)+saltSize+len(nonce)+len(plaintext)+gcm.Overhead())
+	out = append(out, magic...)
+	out = append(out, salt...)
+	out = append(out, nonce...)
+	return gcm.Seal(out, nonce, plaintext, magic), nil
+}
+
+// Open decrypts a blob produced by Seal.
+func Open(passphrase, sealed []byte) ([]byte, error) {
+	if !bytes.HasPrefix(sealed, magic) {
+		return nil, errors.New("not a trustctl encrypted blob")
+	}
+	rest := sealed[len(magic):]
+	if len(rest) < saltSize {
+		return nil, ErrDecrypt
+	}
+	salt, rest := rest[:saltSize], rest[saltSize:]
+	gcm, err := newGCM(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) < gcm.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	nonce, ct := rest[:gcm.NonceSize()], rest[gcm.NonceSize():]
+	pt, err := gcm.Open(nil, nonce, ct, magic)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return pt, nil
+}
+
+// SealWithKey encrypts plaintext under a 32-byte key, skipping key derivation.
+// It suits keys stored in files, where a slow KDF adds nothing.
+func SealWithKey(key, plaintext []byte) ([]byte, error) {
+	if len(key) != KeySize {
+		return nil, errors.New("key must be 32 bytes")
+	}
+	gcm, err := keyGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, len(keyMagic)+len(nonce)+len(plaintext)+gcm.Overhead())
+	out = append(out, keyMagic...)
+	out = append(out, nonce...)
+	return gcm.Seal(out, nonce, plaintext, keyMagic), nil
+}
+
+// OpenWithKey decrypts a blob produced by SealWithKey.
+func OpenWithKey(key, sealed []byte) ([]byte, error) {
+	if !bytes.HasPrefix(sealed, keyMagic) {
+		return nil, errors.New("not a trustctl key-encrypted blob")
+	}
+	if len(key) != KeySize {
+		return nil, errors.New("key must be 32 bytes")
+	}
+	gcm, err := keyGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	rest := sealed[len(keyMagic):]
+	if len(rest) < gcm.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	pt, err := gcm.Open(nil, rest[:gcm.NonceSize()], rest[gcm.NonceSize():], keyMagic)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return pt, nil
+}
+
+// IsSealed reports whether data looks like a blob produced by Seal or SealWithKey.
+func IsSealed(data []byte) bool {
+	return bytes.HasPrefix(data, magic) || bytes.HasPrefix(data, keyMagic)
+}
+
+func keyGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func newGCM(passphrase, salt []byte) (cipher.AEAD, error) {
+	return keyGCM(PBKDF2(sha256.New, passphrase, salt, iterations, KeySize))
+}
+
+// PBKDF2 implements RFC 8018 PBKDF2 with HMAC over h.
+func PBKDF2(h func() hash.Hash, password, salt []byte, iter, keyLen int) []byte {
+	prf := hmac.New(h, password)
+	hashLen := prf.Size()
+	numBlocks := (keyLen + hashLen - 1) / hashLen
+
+	var buf [4]byte
+	dk := make([]byte, 0, numBlocks*hashLen)
+	u := make([]byte, hashLen)
+	for block := 1; block <= numBlocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(buf[:], uint32(block))
+		prf.Write(buf[:])
+		dk = prf.Sum(dk)
+		t := dk[len(dk)-hashLen:]
+		copy(u, t)
+		for n := 2; n <= iter; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = u[:0]
+			u = prf.Sum(u)
+			for i := range u {
+				t[i] ^= u[i]
+			}
+		}
+	}
+	return dk[:keyLen]
+}
@@ -0,0 +1,813 @@
+package selftest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trustctl/trustctl/internal/acme"
+)
+
+// acmeMock is a minimal in-process ACME server: directory, nonces, a single account,
+// and orders whose authorizations offer http-01 and dns-01 challenges. newAccount
+// registers the ES256 key embedded in the request, bound to the mock's EAB
+// credentials; later requests are signature-checked against it. The first signed
+// request is answered with badNonce to exercise the client's retry. Challenges are
+// checked like a CA would, by reading the token file under webroot or the TXT values
+// txt reports, and finalized orders are signed by a throwaway CA.
+type acmeMock struct {
+	srv     *httptest.Server
+	webroot string
+	txt     func(domain string) []string
+
+	caKey  *ecdsa.PrivateKey
+	caCert *x509.Certificate
+
+	mu        sync.Mutex
+	key       *ecdsa.PublicKey
+	next      int
+	nonces    map[string]bool
+	rejected  bool
+	contact   []string
+	authzs    []*mockAuthz
+	orders    []*mockOrder
+	tokens    map[string]string // name -> challenge token
+	validated map[string]string // name -> challenge type the mock validated
+	revoked   map[string]int    // serial -> revocation reason
+}
+
+type mockAuthz struct {
+	name   string // "*.example.com" for wildcards
+	token  string
+	status string
+	err    string // why validation failed
+}
+
+type mockOrder struct {
+	names   []string
+	authzs  []int // indexes into acmeMock.authzs
+	profile string
+	status  string
+	cert    []byte // PEM chain once issued
+}
+
+// mockTermsURL is advertised in the mock's directory.
+const mockTermsURL = "https://selftest.trustctl.invalid/terms"
+
+// External account binding credentials the mock requires for newAccount.
+var (
+	mockEABKID     = "selftest-eab"
+	mockEABHMACKey = []byte("selftest-eab-hmac-key-0123456789")
+)
+
+func newACMEMock(webroot string, txt func(domain string) []string) (*acmeMock, error) {
+	m := &acmeMock{webroot: webroot, txt: txt, nonces: map[string]bool{}, tokens: map[string]string{}, validated: map[string]string{}, revoked: map[string]int{}}
+	var err error
+	if m.caKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "trustctl selftest mock CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &m.caKey.PublicKey, m.caKey)
+	if err == nil {
+		m.caCert, err = x509.ParseCertificate(der)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/directory", m.directory)
+	mux.HandleFunc("/new-nonce", m.newNonce)
+	mux.HandleFunc("/new-account", m.newAccount)
+	mux.HandleFunc("/acct/1", m.account)
+	mux.HandleFunc("/key-change", m.keyChange)
+	mux.HandleFunc("/new-order", m.newOrder)
+	mux.HandleFunc("/authz/", m.authz)
+	mux.HandleFunc("/chall/", m.challenge)
+	mux.HandleFunc("/order/", m.order)
+	mux.HandleFunc("/cert/", m.certificate)
+	mux.HandleFunc("/revoke-cert", m.revokeCert)
+	m.srv = httptest.NewServer(mux)
+	return m, nil
+}
+
+func (m *acmeMock) DirectoryURL() string { return m.srv.URL + "/directory" }
+func (m *acmeMock) AccountURL() string   { return m.srv.URL + "/acct/1" }
+func (m *acmeMock) Close()               { m.srv.Close() }
+
+func (m *acmeMock) directory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"newNonce":   m.srv.URL + "/new-nonce",
+		"newAccount": m.srv.URL + "/new-account",
+		"newOrder":   m.srv.URL + "/new-order",
+		"revokeCert": m.srv.URL + "/revoke-cert",
+		"keyChange":  m.srv.URL + "/key-change",
+		"meta": map[string]interface{}{"termsOfService": mockTermsURL, "externalAccountRequired": true,
+			"profiles": map[string]string{"classic": "90-day certificates", "shortlived": "6-day certificates"}},
+	})
+}
+
+func (m *acmeMock) issueNonce(w http.ResponseWriter) {
+	m.mu.Lock()
+	m.next++
+	n := fmt.Sprintf("nonce-%d", m.next)
+	m.nonces[n] = true
+	m.mu.Unlock()
+	w.Header().Set("Replay-Nonce", n)
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+func (m *acmeMock) newNonce(w http.ResponseWriter, r *http.Request) {
+	m.issueNonce(w)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (m *acmeMock) problem(w http.ResponseWriter, status int, typ, detail string) {
+	m.issueNonce(w)
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"type": "urn:ietf:params:acme:error:" + typ, "detail": detail, "status": status})
+}
+
+// verify checks the JWS in r: a fresh nonce, ES256, the request URL and a valid
+// signature by the embedded JWK (newAccount) or the registered key (kid). It writes
+// a problem and returns ok=false on failure.
+func (m *acmeMock) verify(w http.ResponseWriter, r *http.Request, path string, useJWK bool) (payload []byte, key *ecdsa.PublicKey, ok bool) {
+	if r.Method != http.MethodPost {
+		m.problem(w, http.StatusMethodNotAllowed, "malformed", "POST required")
+		return nil, nil, false
+	}
+	var jws struct {
+		Protected string `json:"protected"`
+		Payload   string `json:"payload"`
+		Signature string `json:"signature"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&jws); err != nil {
+		m.problem(w, http.StatusBadRequest, "malformed", err.Error())
+		return nil, nil, false
+	}
+	var hdr struct {
+		Alg, Nonce, URL, KID string
+		JWK                  *struct{ Kty, Crv, X, Y string }
+	}
+	ph, err := base64.RawURLEncoding.DecodeString(jws.Protected)
+	if err == nil {
+		err = json.Unmarshal(ph, &hdr)
+	}
+	if err != nil {
+		m.problem(w, http.StatusBadRequest, "malformed", "bad protected header")
+		return nil, nil, false
+	}
+
+	m.mu.Lock()
+	validNonce := m.nonces[hdr.Nonce]
+	delete(m.nonces, hdr.Nonce)
+	reject := !m.rejected
+	m.rejected = true
+	key = m.key
+	m.mu.Unlock()
+	if !validNonce || reject {
+		m.problem(w, http.StatusBadRequest, "badNonce", "nonce "+hdr.Nonce+" is not valid")
+		return nil, nil, false
+	}
+	if hdr.Alg != "ES256" || hdr.URL != m.srv.URL+path {
+		m.problem(w, http.StatusBadRequest, "malformed", "unexpected alg or url in protected header")
+		return nil, nil, false
+	}
+	if useJWK {
+		if hdr.JWK == nil || hdr.KID != "" || hdr.JWK.Kty != "EC" || hdr.JWK.Crv != "P-256" {
+			m.problem(w, http.StatusBadRequest, "malformed", "newAccount must carry a P-256 jwk and no kid")
+			return nil, nil, false
+		}
+		x, errX := base64.RawURLEncoding.DecodeString(hdr.JWK.X)
+		y, errY := base64.RawURLEncoding.DecodeString(hdr.JWK.Y)
+		if errX != nil || errY != nil {
+			m.problem(w, http.StatusBadRequest, "malformed", "bad jwk coordinates")
+			return nil, nil, false
+		}
+		key = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	} else if key == nil || hdr.KID != m.AccountURL() {
+		m.problem(w, http.StatusBadRequest, "accountDoesNotExist", "unknown kid "+hdr.KID)
+		return nil, nil, false
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(jws.Signature)
+	if err != nil || len(sig) != 64 {
+		m.problem(w, http.StatusBadRequest, "malformed", "bad signature encoding")
+		return nil, nil, false
+	}
+	digest := sha256.Sum256([]byte(jws.Protected + "." + jws.Payload))
+	if !ecdsa.Verify(key, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		m.problem(w, http.StatusUnauthorized, "unauthorized", "signature does not verify")
+		return nil, nil, false
+	}
+	if jws.Payload != "" {
+		if payload, err = base64.RawURLEncoding.DecodeString(jws.Payload); err != nil {
+			m.problem(w, http.StatusBadRequest, "malformed", "bad payload")
+			return nil, nil, false
+		}
+	}
+	return payload, key, true
+}
+
+func (m *acmeMock) newAccount(w http.ResponseWriter, r *http.Request) {
+	payload, key, ok := m.verify(w, r, "/new-account", true)
+	if !ok {
+		return
+	}
+	var req struct {
+		Contact     []string        `json:"contact"`
+		TermsAgreed bool            `json:"termsOfServiceAgreed"`
+		EAB         json.RawMessage `json:"externalAccountBinding"`
+	}
+	if err := json.Unmarshal(payload, &req); err != nil {
+		m.problem(w, http.StatusBadRequest, "malformed", "bad payload")
+		return
+	}
+	m.mu.Lock()
+	status := http.StatusOK
+	if m.key == nil {
+		if !req.TermsAgreed {
+			m.mu.Unlock()
+			m.problem(w, http.StatusForbidden, "userActionRequired", "terms of service not agreed")
+			return
+		}
+		if err := m.checkEAB(req.EAB, key); err != nil {
+			m.mu.Unlock()
+			m.problem(w, http.StatusUnauthorized, "externalAccountRequired", err.Error())
+			return
+		}
+		m.key = key
+		m.contact = req.Contact
+		status = http.StatusCreated
+	} else if !m.key.Equal(key) {
+		m.mu.Unlock()
+		m.problem(w, http.StatusForbidden, "unauthorized", "the mock holds a single account")
+		return
+	}
+	m.mu.Unlock()
+	m.writeAccount(w, status)
+}
+
+// checkEAB verifies an RFC 8555 §7.3.4 binding: HS256 under the mock's key, its kid,
+// the newAccount URL and the account key as payload.
+func (m *acmeMock) checkEAB(raw json.RawMessage, key *ecdsa.PublicKey) error {
+	if len(raw) == 0 {
+		return errors.New("externalAccountBinding missing")
+	}
+	var jws struct {
+		Protected string `json:"protected"`
+		Payload   string `json:"payload"`
+		Signature string `json:"signature"`
+	}
+	if err := json.Unmarshal(raw, &jws); err != nil {
+		return err
+	}
+	var hdr struct{ Alg, KID, URL string }
+	ph, err := base64.RawURLEncoding.DecodeString(jws.Protected)
+	if err == nil {
+		err = json.Unmarshal(ph, &hdr)
+	}
+	if err != nil {
+		return errors.New("bad EAB protected header")
+	}
+	if hdr.Alg != "HS256" || hdr.KID != mockEABKID || hdr.URL != m.srv.URL+"/new-account" {
+		return errors.New("unexpected alg, kid or url in EAB protected header")
+	}
+	var jwk struct{ X, Y string }
+	pl, err := base64.RawURLEncoding.DecodeString(jws.Payload)
+	if err == nil {
+		err = json.Unmarshal(pl, &jwk)
+	}
+	if err != nil || jwk.X != base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))) ||
+		jwk.Y != base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))) {
+		return errors.New("EAB payload is not the account key")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(jws.Signature)
+	if err != nil {
+		return errors.New("bad EAB signature encoding")
+	}
+	mac := hmac.New(sha256.New, mockEABHMACKey)
+	mac.Write([]byte(jws.Protected + "." + jws.Payload))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return errors.New("EAB MAC does not verify")
+	}
+	return nil
+}
+
+func (m *acmeMock) account(w http.ResponseWriter, r *http.Request) {
+	payload, _, ok := m.verify(w, r, "/acct/1", false)
+	if !ok {
+		return
+	}
+	if payload != nil {
+		var upd struct {
+			Contact []string `json:"contact"`
+		}
+		if err := json.Unmarshal(payload, &upd); err != nil {
+			m.problem(w, http.StatusBadRequest, "malformed", "bad payload")
+			return
+		}
+		m.mu.Lock()
+		m.contact = upd.Contact
+		m.mu.Unlock()
+	}
+	m.writeAccount(w, http.StatusOK)
+}
+
+func (m *acmeMock) writeAccount(w http.ResponseWriter, status int) {
+	m.mu.Lock()
+	contact := append([]string(nil), m.contact...)
+	m.mu.Unlock()
+	m.issueNonce(w)
+	w.Header().Set("Location", m.AccountURL())
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "valid", "contact": contact})
+}
+
+// Token returns the challenge token last handed out for name.
+func (m *acmeMock) Token(name string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.tokens[name]
+}
+
+func (m *acmeMock) newOrder(w http.ResponseWriter, r *http.Request) {
+	payload, _, ok := m.verify(w, r, "/new-order", false)
+	if !ok {
+		return
+	}
+	var req struct {
+		Identifiers []struct{ Type, Value string } `json:"identifiers"`
+		Profile     string                         `json:"profile"`
+	}
+	if err := json.Unmarshal(payload, &req); err != nil || len(req.Identifiers) == 0 {
+		m.problem(w, http.StatusBadRequest, "malformed", "no identifiers")
+		return
+	}
+	if req.Profile != "" && req.Profile != "classic" && req.Profile != "shortlived" {
+		m.problem(w, http.StatusBadRequest, "invalidProfile", "unknown profile "+req.Profile)
+		return
+	}
+	o := &mockOrder{profile: req.Profile, status: "pending"}
+	m.mu.Lock()
+	for _, id := range req.Identifiers {
+		if id.Type != "dns" && id.Type != "ip" {
+			m.mu.Unlock()
+			m.problem(w, http.StatusBadRequest, "unsupportedIdentifier", id.Type)
+			return
+		}
+		tok := make([]byte, 16)
+		rand.Read(tok)
+		a := &mockAuthz{name: id.Value, token: base64.RawURLEncoding.EncodeToString(tok), status: "pending"}
+		m.authzs = append(m.authzs, a)
+		m.tokens[a.name] = a.token
+		o.names = append(o.names, id.Value)
+		o.authzs = append(o.authzs, len(m.authzs))
+	}
+	m.orders = append(m.orders, o)
+	n := len(m.orders)
+	m.mu.Unlock()
+	m.writeOrder(w, http.StatusCreated, n)
+}
+
+// writeOrder answers with order n, reflecting the state of its authorizations.
+func (m *acmeMock) writeOrder(w http.ResponseWriter, status, n int) {
+	m.mu.Lock()
+	o := m.orders[n-1]
+	var ids []map[string]string
+	var urls []string
+	ready := true
+	for i, name := range o.names {
+		ids = append(ids, map[string]string{"type": identifierType(name), "value": name})
+		urls = append(urls, fmt.Sprintf("%s/authz/%d", m.srv.URL, o.authzs[i]))
+		ready = ready && m.authzs[o.authzs[i]-1].status == "valid"
+	}
+	if o.status == "pending" && ready {
+		o.status = "ready"
+	}
+	body := map[string]interface{}{
+		"status":         o.status,
+		"identifiers":    ids,
+		"authorizations": urls,
+		"finalize":       fmt.Sprintf("%s/order/%d/finalize", m.srv.URL, n),
+		"profile":        o.profile,
+	}
+	if o.cert != nil {
+		body["certificate"] = fmt.Sprintf("%s/cert/%d", m.srv.URL, n)
+	}
+	m.mu.Unlock()
+	m.issueNonce(w)
+	w.Header().Set("Location", fmt.Sprintf("%s/order/%d", m.srv.URL, n))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func (m *acmeMock) authz(w http.ResponseWriter, r *http.Request) {
+	var i int
+	if _, err := fmt.Sscanf(r.URL.Path, "/authz/%d", &i); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if _, _, ok := m.verify(w, r, r.URL.Path, false); !ok {
+		return
+	}
+	m.mu.Lock()
+	if i < 1 || i > len(m.authzs) {
+		m.mu.Unlock()
+		m.problem(w, http.StatusNotFound, "malformed", "no such authorization")
+		return
+	}
+	a := *m.authzs[i-1]
+	m.mu.Unlock()
+	base := strings.TrimPrefix(a.name, "*.")
+	var challenges []interface{}
+	if identifierType(a.name) == "dns" {
+		challenges = append(challenges, m.challengeObject(i, &a, "dns-01"))
+	}
+	if base == a.name {
+		challenges = append(challenges, m.challengeObject(i, &a, "http-01"))
+	}
+	m.issueNonce(w)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"identifier": map[string]string{"type": identifierType(base), "value": base},
+		"status":     a.status,
+		"wildcard":   base != a.name,
+		"challenges": challenges,
+	})
+}
+
+func (m *acmeMock) challengeObject(i int, a *mockAuthz, typ string) map[string]interface{} {
+	ch := map[string]interface{}{"type": typ, "url": fmt.Sprintf("%s/chall/%d/%s", m.srv.URL, i, typ), "status": a.status, "token": a.token}
+	if a.err != "" {
+		ch["error"] = map[string]interface{}{"type": "urn:ietf:params:acme:error:incorrectResponse", "detail": a.err}
+	}
+	return ch
+}
+
+// Validated returns the challenge type the mock validated name with, if any.
+func (m *acmeMock) Validated(name string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.validated[name]
+}
+
+// challenge validates the authorization synchronously when the client accepts one of
+// its challenges: the http-01 token file must hold the key authorization, or a TXT
+// value for the base name must be its dns-01 digest.
+func (m *acmeMock) challenge(w http.ResponseWriter, r *http.Request) {
+	var i int
+	var typ string
+	if _, err := fmt.Sscanf(r.URL.Path, "/chall/%d/%s", &i, &typ); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if _, _, ok := m.verify(w, r, r.URL.Path, false); !ok {
+		return
+	}
+	m.mu.Lock()
+	if i < 1 || i > len(m.authzs) {
+		m.mu.Unlock()
+		m.problem(w, http.StatusNotFound, "malformed", "no such challenge")
+		return
+	}
+	a := m.authzs[i-1]
+	keyAuth := a.token + "." + thumbprint(m.key)
+	name := a.name
+	m.mu.Unlock()
+
+	base := strings.TrimPrefix(name, "*.")
+	var failure string
+	switch typ {
+	case "http-01":
+		got, err := os.ReadFile(filepath.Join(m.webroot, ".well-known", "acme-challenge", a.token))
+		if err != nil {
+			failure = "token file not served: " + err.Error()
+		} else if string(got) != keyAuth {
+			failure = fmt.Sprintf("token file holds %q, want %q", got, keyAuth)
+		}
+	case "dns-01":
+		failure = "no TXT record at _acme-challenge." + base + " holds the key authorization digest"
+		for _, v := range m.txt(base) {
+			if v == acme.DNS01Value(keyAuth) {
+				failure = ""
+			}
+		}
+	default:
+		m.problem(w, http.StatusNotFound, "malformed", "no such challenge")
+		return
+	}
+
+	m.mu.Lock()
+	if a.status == "pending" {
+		if failure == "" {
+			a.status = "valid"
+			m.validated[name] = typ
+		} else {
+			a.status, a.err = "invalid", failure
+		}
+	}
+	ch := m.challengeObject(i, a, typ)
+	m.mu.Unlock()
+	m.issueNonce(w)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ch)
+}
+
+// order answers POST-as-GET for an order and its finalize requests.
+func (m *acmeMock) order(w http.ResponseWriter, r *http.Request) {
+	var n int
+	if _, err := fmt.Sscanf(r.URL.Path, "/order/%d", &n); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	payload, _, ok := m.verify(w, r, r.URL.Path, false)
+	if !ok {
+		return
+	}
+	m.mu.Lock()
+	valid := n >= 1 && n <= len(m.orders)
+	m.mu.Unlock()
+	if !valid {
+		m.problem(w, http.StatusNotFound, "malformed", "no such order")
+		return
+	}
+	if !strings.HasSuffix(r.URL.Path, "/finalize") {
+		m.writeOrder(w, http.StatusOK, n)
+		return
+	}
+
+	var req struct {
+		CSR string `json:"csr"`
+	}
+	der, err := []byte(nil), json.Unmarshal(payload, &req)
+	if err == nil {
+		der, err = base64.RawURLEncoding.DecodeString(req.CSR)
+	}
+	var csr *x509.CertificateRequest
+	if err == nil {
+		csr, err = x509.ParseCertificateRequest(der)
+	}
+	if err == nil {
+		err = csr.CheckSignature()
+	}
+	if err != nil {
+		m.problem(w, http.StatusBadRequest, "badCSR", "bad csr: "+err.Error())
+		return
+	}
+
+	m.mu.Lock()
+	o := m.orders[n-1]
+	ready := o.status == "ready" || o.status == "pending"
+	for _, ai := range o.authzs {
+		ready = ready && m.authzs[ai-1].status == "valid"
+	}
+	names := append([]string(nil), o.names...)
+	profile := o.profile
+	m.mu.Unlock()
+	if !ready {
+		m.problem(w, http.StatusForbidden, "orderNotReady", "authorizations are not valid")
+		return
+	}
+	csrNames := append([]string(nil), csr.DNSNames...)
+	for _, ip := range csr.IPAddresses {
+		csrNames = append(csrNames, ip.String())
+	}
+	if !sameNames(csrNames, names) {
+		m.problem(w, http.StatusBadRequest, "badCSR", fmt.Sprintf("csr names %v do not match the order %v", csrNames, names))
+		return
+	}
+	cert, err := m.issue(csr, names, profile)
+	if err != nil {
+		m.problem(w, http.StatusInternalServerError, "serverInternal", err.Error())
+		return
+	}
+	m.mu.Lock()
+	o.status, o.cert = "valid", cert
+	m.mu.Unlock()
+	m.writeOrder(w, http.StatusOK, n)
+}
+
+// issue signs a certificate for csr's key, valid 6 days under the shortlived profile
+// and 90 days otherwise, and returns it with the mock CA as PEM chain.
+func (m *acmeMock) issue(csr *x509.CertificateRequest, names []string, profile string) ([]byte, error) {
+	lifetime := 90 * 24 * time.Hour
+	if profile == "shortlived" {
+		lifetime = 6 * 24 * time.Hour
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: csr.Subject.CommonName},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(lifetime),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, n := range names {
+		if ip := net.ParseIP(n); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, n)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, m.caCert, csr.PublicKey, m.caKey)
+	if err != nil {
+		return nil, err
+	}
+	out := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return append(out, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: m.caCert.Raw})...), nil
+}
+
+// certificate serves the chain of an issued order.
+func (m *acmeMock) certificate(w http.ResponseWriter, r *http.Request) {
+	var n int
+	if _, err := fmt.Sscanf(r.URL.Path, "/cert/%d", &n); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if _, _, ok := m.verify(w, r, r.URL.Path, false); !ok {
+		return
+	}
+	m.mu.Lock()
+	var cert []byte
+	if n >= 1 && n <= len(m.orders) {
+		cert = m.orders[n-1].cert
+	}
+	m.mu.Unlock()
+	if cert == nil {
+		m.problem(w, http.StatusNotFound, "malformed", "no such certificate")
+		return
+	}
+	m.issueNonce(w)
+	w.Header().Set("Content-Type", "application/pem-certificate-chain")
+	w.Write(cert)
+}
+
+// revokeCert revokes a certificate the mock issued, on behalf of the account.
+func (m *acmeMock) revokeCert(w http.ResponseWriter, r *http.Request) {
+	payload, _, ok := m.verify(w, r, "/revoke-cert", false)
+	if !ok {
+		return
+	}
+	var req struct {
+		Certificate string `json:"certificate"`
+		Reason      int    `json:"reason"`
+	}
+	if err := json.Unmarshal(payload, &req); err != nil {
+		m.problem(w, http.StatusBadRequest, "malformed", "bad payload")
+		return
+	}
+	der, err := base64.RawURLEncoding.DecodeString(req.Certificate)
+	var cert *x509.Certificate
+	if err == nil {
+		cert, err = x509.ParseCertificate(der)
+	}
+	if err != nil || cert.CheckSignatureFrom(m.caCert) != nil {
+		m.problem(w, http.StatusNotFound, "malformed", "certificate was not issued by this CA")
+		return
+	}
+	m.mu.Lock()
+	_, already := m.revoked[cert.SerialNumber.String()]
+	if !already {
+		m.revoked[cert.SerialNumber.String()] = req.Reason
+	}
+	m.mu.Unlock()
+	if already {
+		m.problem(w, http.StatusBadRequest, "alreadyRevoked", "certificate is already revoked")
+		return
+	}
+	m.issueNonce(w)
+}
+
+// Revoked returns the reason the certificate with serial was revoked for.
+func (m *acmeMock) Revoked(serial *big.Int) (int, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	reason, ok := m.revoked[serial.String()]
+	return reason, ok
+}
+
+// identifierType returns the ACME identifier type of name: ip for IP addresses (RFC 8738).
+func identifierType(name string) string {
+	if net.ParseIP(name) != nil {
+		return "ip"
+	}
+	return "dns"
+}
+
+func sameNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	set := map[string]bool{}
+	for _, n := range a {
+		set[strings.ToLower(n)] = true
+	}
+	for _, n := range b {
+		if !set[strings.ToLower(n)] {
+			return false
+		}
+	}
+	return true
+}
+
+// thumbprint is the RFC 7638 thumbprint of a P-256 account key.
+func thumbprint(key *ecdsa.PublicKey) string {
+	jwk := fmt.Sprintf(`{"crv":"P-256","kty":"EC","x":"%s","y":"%s"}`, b64Coord(key.X), b64Coord(key.Y))
+	sum := sha256.Sum256([]byte(jwk))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// keyChange checks an RFC 8555 §7.3.5 key change, signed by the current key, whose
+// inner JWS is signed by the new key, and switches the account to the new key.
+func (m *acmeMock) keyChange(w http.ResponseWriter, r *http.Request) {
+	payload, oldKey, ok := m.verify(w, r, "/key-change", false)
+	if !ok {
+		return
+	}
+	var inner struct{ Protected, Payload, Signature string }
+	var hdr struct {
+		Alg, Nonce, URL string
+		JWK             *struct{ Kty, Crv, X, Y string }
+	}
+	var req struct {
+		Account string
+		OldKey  struct{ X, Y string }
+	}
+	err := json.Unmarshal(payload, &inner)
+	ph, pl := []byte(nil), []byte(nil)
+	if err == nil {
+		ph, err = base64.RawURLEncoding.DecodeString(inner.Protected)
+	}
+	if err == nil {
+		err = json.Unmarshal(ph, &hdr)
+	}
+	if err == nil {
+		pl, err = base64.RawURLEncoding.DecodeString(inner.Payload)
+	}
+	if err == nil {
+		err = json.Unmarshal(pl, &req)
+	}
+	if err != nil || hdr.JWK == nil || hdr.Nonce != "" || hdr.Alg != "ES256" || hdr.URL != m.srv.URL+"/key-change" {
+		m.problem(w, http.StatusBadRequest, "malformed", "bad inner JWS")
+		return
+	}
+	if req.Account != m.AccountURL() || req.OldKey.X != b64Coord(oldKey.X) || req.OldKey.Y != b64Coord(oldKey.Y) {
+		m.problem(w, http.StatusBadRequest, "malformed", "inner payload does not name this account and its current key")
+		return
+	}
+	x, errX := base64.RawURLEncoding.DecodeString(hdr.JWK.X)
+	y, errY := base64.RawURLEncoding.DecodeString(hdr.JWK.Y)
+	sig, errS := base64.RawURLEncoding.DecodeString(inner.Signature)
+	if errX != nil || errY != nil || errS != nil || len(sig) != 64 {
+		m.problem(w, http.StatusBadRequest, "malformed", "bad inner jwk or signature")
+		return
+	}
+	newKey := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	digest := sha256.Sum256([]byte(inner.Protected + "." + inner.Payload))
+	if !ecdsa.Verify(newKey, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		m.problem(w, http.StatusUnauthorized, "unauthorized", "inner signature does not verify")
+		return
+	}
+	m.mu.Lock()
+	m.key = newKey
+	m.mu.Unlock()
+	m.writeAccount(w, http.StatusOK)
+}
+
+func b64Coord(n *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(n.FillBytes(make([]byte, 32)))
+}
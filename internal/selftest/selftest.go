@@ -0,0 +1,704 @@
+// Package selftest exercises the request, validate, issue and install pipeline
+// against an in-process mock ACME server and DNS provider in a temporary directory,
+// so a build can be verified without touching real CAs or production state.
+package selftest
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/trustctl/trustctl/internal/account"
+	"github.com/trustctl/trustctl/internal/acme"
+	"github.com/trustctl/trustctl/internal/acmetest"
+	"github.com/trustctl/trustctl/internal/certinfo"
+	"github.com/trustctl/trustctl/internal/dns"
+	"github.com/trustctl/trustctl/internal/hooks"
+	"github.com/trustctl/trustctl/internal/httpclient"
+	"github.com/trustctl/trustctl/internal/keygen"
+	"github.com/trustctl/trustctl/internal/metadata"
+	"github.com/trustctl/trustctl/internal/paths"
+	"github.com/trustctl/trustctl/internal/ui"
+	"github.com/trustctl/trustctl/pkg/trustctl"
+)
+
+// DNSProviderName is the name the mock DNS provider is registered under.
+const DNSProviderName = "selftest-mock"
+
+// Options control a selftest run.
+type Options struct {
+	// DirectoryURL targets an external ACME server (e.g. Pebble) instead of the
+	// built-in mock. The account used by the pipeline checks is registered there.
+	DirectoryURL string
+	// CAFile is a PEM bundle to trust for DirectoryURL (Pebble's minica root).
+	CAFile string
+	// ChallTestSrv is the management URL of the pebble-challtestsrv that publishes
+	// dns-01 records for an external server; without it the records only reach the
+	// mock provider, which needs Pebble's PEBBLE_VA_ALWAYS_VALID.
+	ChallTestSrv string
+	// HTTPAddr is where the webroot is served for an external server's http-01
+	// checks (acmetest.DefaultHTTPAddr when empty).
+	HTTPAddr string
+	// Keep leaves the temporary directory in place for inspection.
+	Keep bool
+	// Sink receives the pipeline's own progress output; nil discards it.
+	Sink ui.Sink
+}
+
+// Step is the outcome of one check.
+type Step struct {
+	Name   string
+	Err    error
+	Detail string
+}
+
+// Report collects the steps of a run.
+type Report struct {
+	Dir   string
+	Steps []Step
+}
+
+// OK reports whether every step passed.
+func (r *Report) OK() bool {
+	for _, s := range r.Steps {
+		if s.Err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Report) add(name string, err error, detail string) bool {
+	r.Steps = append(r.Steps, Step{Name: name, Err: err, Detail: detail})
+	return err == nil
+}
+
+// mockDNS records challenge records instead of publishing them; the mock CA reads
+// them back through txt.
+type mockDNS struct {
+	mu      sync.Mutex
+	records map[string][]string // domain -> key authorizations presented
+	cleaned map[string]bool
+}
+
+func (d *mockDNS) Present(domain, token, keyAuth string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.records[domain] = append(d.records[domain], keyAuth)
+	return nil
+}
+
+func (d *mockDNS) CleanUp(domain, token, keyAuth string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.records, domain)
+	d.cleaned[domain] = true
+	return nil
+}
+
+// txt returns the TXT values published at _acme-challenge.<domain>.
+func (d *mockDNS) txt(domain string) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var out []string
+	for _, keyAuth := range d.records[domain] {
+		out = append(out, acme.DNS01Value(keyAuth))
+	}
+	return out
+}
+
+func (d *mockDNS) cleanedUp(domain string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cleaned[domain] && len(d.records[domain]) == 0
+}
+
+// Run switches the process-wide layout and metadata store to a temporary directory
+// and runs the checks there. It restores the previous layout before returning but
+// leaves the metadata store closed, so it is meant to run in its own process.
+func Run(ctx context.Context, opts Options) (*Report, error) {
+	dir, err := os.MkdirTemp("", "trustctl-selftest-")
+	if err != nil {
+		return nil, err
+	}
+	rep := &Report{Dir: dir}
+	if !opts.Keep {
+		defer os.RemoveAll(dir)
+	}
+
+	prevLayout := paths.Current()
+	prevSink := ui.CurrentSink()
+	defer func() {
+		ui.SetSink(prevSink)
+		paths.Set(prevLayout)
+	}()
+
+	layout := paths.FromBase(dir)
+	layout.Webroot = filepath.Join(dir, "www")
+	paths.Set(layout)
+	for _, d := range []string{layout.Certs, layout.Logs, layout.Plugins, layout.Webroot} {
+		if err := os.MkdirAll(d, 0700); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(layout.Credentials, 0700); err != nil {
+		return nil, err
+	}
+	if err := metadata.Open(metadata.BackendJSON); err != nil {
+		return nil, err
+	}
+	defer metadata.Close()
+	rep.add("temporary layout", nil, dir)
+
+	// The mock DNS provider answers dns-01 unless a challtestsrv publishes the records
+	dnsMock := &mockDNS{records: map[string][]string{}, cleaned: map[string]bool{}}
+	var provider dns.DNSProvider = dnsMock
+	if opts.ChallTestSrv != "" {
+		provider = acmetest.NewChallTestSrv(opts.ChallTestSrv)
+	}
+	dns.Register(DNSProviderName, provider)
+	defer dns.Register(DNSProviderName, nil)
+
+	// Accounts and certificates are issued against the mock unless a directory is
+	// given; an external server fetches http-01 tokens from the webroot served here
+	directory := opts.DirectoryURL
+	var mock *acmeMock
+	if directory == "" {
+		if mock, err = newACMEMock(layout.Webroot, dnsMock.txt); err != nil {
+			return nil, err
+		}
+		defer mock.Close()
+		directory = mock.DirectoryURL()
+	} else {
+		if opts.CAFile != "" {
+			// The pipeline uses default HTTP clients; make them trust the external server
+			os.Setenv("SSL_CERT_FILE", opts.CAFile)
+		}
+		addr := opts.HTTPAddr
+		if addr == "" {
+			addr = acmetest.DefaultHTTPAddr
+		}
+		srv, err := acmetest.ServeWebroot(addr, layout.Webroot)
+		if !rep.add("http-01 webroot served", err, addr) {
+			return rep, nil
+		}
+		defer srv.Close()
+	}
+	if !checkACME(ctx, rep, opts, mock) {
+		return rep, nil
+	}
+	_, err = account.Load(account.CANameFor("", "", directory), "")
+	if !rep.add("acme account available to the pipeline", err, "") {
+		return rep, nil
+	}
+
+	sink := opts.Sink
+	if sink == nil {
+		sink = ui.Discard
+	}
+	ui.SetSink(sink)
+	defer ui.SetSink(prevSink)
+
+	// HTTP-01 pipeline. Certificates are only stored (NoInstall): installing them would
+	// edit the web server of the host running the selftest
+	httpDomain := "http.selftest.trustctl.invalid"
+	cert, err := trustctl.Request(ctx, trustctl.RequestOptions{Domains: []string{httpDomain}, Validation: "http", Email: "selftest@trustctl.invalid", DirectoryURL: directory, NoInstall: true})
+	if !rep.add("request (http validation)", err, httpDomain) {
+		return rep, nil
+	}
+	if mock != nil {
+		rep.add("http-01 challenge validated (mock)", checkValidated(mock, httpDomain, acme.ChallengeHTTP01), "")
+	}
+	rep.add("http-01 token removed after issuance", checkEmpty(filepath.Join(layout.Webroot, ".well-known", "acme-challenge")), "")
+	rep.add("certificate files written", checkFiles(cert.CertPath, cert.KeyPath), filepath.Dir(cert.CertPath))
+	detail, err := checkIssued(cert.CertPath, httpDomain)
+	rep.add("certificate issued for the requested names", err, detail)
+
+	stored, err := metadata.Load(httpDomain)
+	if err == nil && stored.ValidationMethod != "http" {
+		err = fmt.Errorf("stored validation method %q", stored.ValidationMethod)
+	}
+	rep.add("metadata stored", err, "")
+
+	// IP address identifier (RFC 8738) over http-01; external CAs would have to reach
+	// the documentation address, so only against the mock
+	if mock != nil {
+		ip := "192.0.2.10"
+		cert, err := trustctl.Request(ctx, trustctl.RequestOptions{Domains: []string{ip}, Validation: "http", DirectoryURL: directory, NoInstall: true})
+		if err == nil {
+			err = checkValidated(mock, ip, acme.ChallengeHTTP01)
+		}
+		if err == nil {
+			_, err = checkIssued(cert.CertPath, ip)
+		}
+		if rep.add("ip address certificate (http validation)", err, ip) {
+			rep.add("revoke with the account key", checkRevoke(ctx, mock, ip), "")
+		}
+	}
+
+	// A CSR made elsewhere: issued and renewed without trustctl touching a key
+	csrDomain := "csr.selftest.trustctl.invalid"
+	rep.add("request and renew with a supplied CSR", checkExternalCSR(ctx, csrDomain, directory), csrDomain)
+
+	// Manual validation with an auth hook publishing the http-01 token
+	manualDomain := "manual.selftest.trustctl.invalid"
+	rep.add("request with manual validation and auth hooks", checkManual(ctx, manualDomain, directory), manualDomain)
+
+	// DNS-01 pipeline through the mock provider or challtestsrv
+	dnsDomains := []string{"dns.selftest.trustctl.invalid", "www.dns.selftest.trustctl.invalid"}
+	dnsOpts := trustctl.RequestOptions{Domains: dnsDomains, Validation: "dns", DNSProvider: DNSProviderName, DirectoryURL: directory, NoInstall: true}
+	if mock != nil {
+		dnsOpts.Profile = "shortlived"
+	}
+	cert, err = trustctl.Request(ctx, dnsOpts)
+	if rep.add("request (dns validation)", err, dnsDomains[0]) {
+		for _, d := range dnsDomains {
+			var err error
+			if mock != nil {
+				err = checkValidated(mock, d, acme.ChallengeDNS01)
+			}
+			if err == nil && provider == dnsMock && !dnsMock.cleanedUp(d) {
+				err = errors.New("challenge record was not cleaned up")
+			}
+			rep.add("dns-01 challenge for "+d, err, "")
+		}
+		detail, err := checkIssued(cert.CertPath, dnsDomains...)
+		rep.add("certificate issued for every name", err, detail)
+		if dnsOpts.Profile != "" {
+			stored, err := metadata.Load(dnsDomains[0])
+			if err == nil && stored.Profile != dnsOpts.Profile {
+				err = fmt.Errorf("stored profile %q", stored.Profile)
+			}
+			rep.add("acme profile ordered and kept for renewal", err, dnsOpts.Profile)
+		}
+	}
+
+	// Renewal from stored metadata, once the renewal window has opened
+	var rec *trustctl.HistoryRecord
+	err = openRenewalWindow(httpDomain)
+	if err == nil {
+		rec, err = trustctl.Renew(ctx, httpDomain, trustctl.RenewOptions{})
+	}
+	if err == nil {
+		var hist []metadata.HistoryRecord
+		if hist, err = metadata.History(httpDomain); err == nil && len(hist) == 0 {
+			err = errors.New("no history recorded")
+		}
+	}
+	if err == nil {
+		var renewed *metadata.CertMetadata
+		if renewed, err = metadata.Load(httpDomain); err == nil && stored != nil && renewed.Serial == stored.Serial {
+			err = errors.New("certificate serial unchanged")
+		}
+	}
+	detail = ""
+	if rec != nil {
+		detail = rec.CAResponse
+	}
+	rep.add("renew from metadata", err, detail)
+	rep.add("renewal skipped until due, unless forced", checkForceRenew(ctx, httpDomain), "")
+	rep.add("pre, deploy and post hooks run around renewal", checkHooks(ctx, httpDomain), "")
+
+	// Renewal keeping the private key (DANE/TLSA pinning)
+	rep.add("renew reusing the private key", checkReuseKey(ctx, dnsDomains[0]), "")
+	rep.add("scheduled rotation of a reused key", checkKeyRotation(ctx, dnsDomains[0]), "")
+
+	return rep, nil
+}
+
+// openRenewalWindow records a renewal window that has already passed for domain, as
+// if the CA's ARI had asked for early replacement, so Renew goes ahead.
+func openRenewalWindow(domain string) error {
+	meta, err := metadata.Load(domain)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	meta.RenewalInfo = &metadata.RenewalWindow{
+		Start:     now.Add(-2 * time.Hour),
+		End:       now.Add(-time.Hour),
+		RenewAt:   now.Add(-time.Hour),
+		CheckedAt: now,
+		NextCheck: now.Add(time.Hour),
+	}
+	return meta.Store()
+}
+
+// checkForceRenew checks a freshly renewed certificate is left alone and renewed
+// with Force.
+func checkForceRenew(ctx context.Context, domain string) error {
+	if _, err := trustctl.Renew(ctx, domain, trustctl.RenewOptions{}); !errors.Is(err, trustctl.ErrNotDue) {
+		return fmt.Errorf("renewal of a fresh certificate returned %v", err)
+	}
+	before, err := metadata.Load(domain)
+	if err != nil {
+		return err
+	}
+	if _, err := trustctl.Renew(ctx, domain, trustctl.RenewOptions{Force: true}); err != nil {
+		return err
+	}
+	after, err := metadata.Load(domain)
+	if err == nil && after.Serial == before.Serial {
+		err = errors.New("certificate serial unchanged")
+	}
+	return err
+}
+
+// checkManual requests a certificate for domain with manual http validation whose
+// hooks write and remove the token in the webroot, and checks the token is gone and
+// the settings were kept for renewal.
+func checkManual(ctx context.Context, domain, directory string) error {
+	dir := filepath.Join(paths.Webroot(), ".well-known", "acme-challenge")
+	manual := trustctl.ManualValidation{
+		Challenge:   "http",
+		AuthHook:    fmt.Sprintf("mkdir -p %q && printf %%s \"$TRUSTCTL_VALIDATION\" > %q/\"$TRUSTCTL_TOKEN\" && echo \"$TRUSTCTL_TOKEN\"", dir, dir),
+		CleanupHook: fmt.Sprintf("rm %q/\"$TRUSTCTL_AUTH_OUTPUT\"", dir),
+	}
+	cert, err := trustctl.Request(ctx, trustctl.RequestOptions{Domains: []string{domain}, Validation: "manual", Manual: manual, DirectoryURL: directory, NoInstall: true})
+	if err != nil {
+		return err
+	}
+	if _, err := checkIssued(cert.CertPath, domain); err != nil {
+		return err
+	}
+	if err := checkEmpty(dir); err != nil {
+		return err
+	}
+	if m := cert.Manual; m == nil || *m != manual {
+		return errors.New("manual validation settings not stored")
+	}
+	return nil
+}
+
+// checkHooks renews domain with hook commands and a shared deploy hook that log to a
+// file, and checks they ran in order with the certificate's paths, and were stored.
+func checkHooks(ctx context.Context, domain string) error {
+	log := filepath.Join(paths.Base(), "hooks.log")
+	shared := filepath.Join(paths.Hooks(), string(hooks.Deploy))
+	if err := os.MkdirAll(shared, 0700); err != nil {
+		return err
+	}
+	defer os.RemoveAll(paths.Hooks())
+	script := fmt.Sprintf("#!/bin/sh\necho \"shared $RENEWED_DOMAINS\" >> %q\n", log)
+	if err := os.WriteFile(filepath.Join(shared, "10-log"), []byte(script), 0700); err != nil {
+		return err
+	}
+	pre, post := fmt.Sprintf("echo \"pre $TRUSTCTL_HOOK\" >> %q", log), fmt.Sprintf("echo post >> %q", log)
+	deploy := fmt.Sprintf("echo \"deploy $TRUSTCTL_CERT_PATH\" >> %q", log)
+	if _, err := trustctl.Renew(ctx, domain, trustctl.RenewOptions{Force: true, PreHook: &pre, PostHook: &post, DeployHook: &deploy}); err != nil {
+		return err
+	}
+	meta, err := metadata.Load(domain)
+	if err != nil {
+		return err
+	}
+	got, err := os.ReadFile(log)
+	if err != nil {
+		return err
+	}
+	want := fmt.Sprintf("pre pre\nshared %s\ndeploy %s\npost\n", domain, meta.CertPath)
+	switch {
+	case string(got) != want:
+		return fmt.Errorf("hooks logged %q, want %q", got, want)
+	case meta.Hooks == nil || meta.Hooks.Deploy != deploy:
+		return errors.New("hooks not stored in metadata")
+	}
+	none := ""
+	_, err = trustctl.Renew(ctx, domain, trustctl.RenewOptions{PreHook: &none, PostHook: &none, DeployHook: &none})
+	if !errors.Is(err, trustctl.ErrNotDue) {
+		return fmt.Errorf("renewal of a fresh certificate returned %v", err)
+	}
+	if meta, err = metadata.Load(domain); err == nil && meta.Hooks != nil {
+		err = errors.New("hooks not removed from metadata")
+	}
+	return err
+}
+
+// checkReuseKey renews domain with --reuse-key and checks the certificate changed
+// while the private key file did not.
+func checkReuseKey(ctx context.Context, domain string) error {
+	before, err := metadata.Load(domain)
+	if err != nil {
+		return err
+	}
+	key, err := os.ReadFile(before.KeyPath)
+	if err != nil {
+		return err
+	}
+	if err := openRenewalWindow(domain); err != nil {
+		return err
+	}
+	if _, err := trustctl.Renew(ctx, domain, trustctl.RenewOptions{ReuseKey: true}); err != nil {
+		return err
+	}
+	after, err := metadata.Load(domain)
+	if err != nil {
+		return err
+	}
+	switch now, err := os.ReadFile(after.KeyPath); {
+	case err != nil:
+		return err
+	case !bytes.Equal(now, key):
+		return errors.New("private key was replaced")
+	case after.Serial == before.Serial:
+		return errors.New("certificate serial unchanged")
+	case !after.ReuseKey:
+		return errors.New("reuse-key preference not stored")
+	}
+	return nil
+}
+
+// checkExternalCSR requests a certificate for a CSR signed by a key trustctl never
+// sees, checks no key was stored and that a renewal resubmits the same key.
+func checkExternalCSR(ctx context.Context, domain, directory string) error {
+	key, err := keygen.GenerateKey(keygen.EC256)
+	if err != nil {
+		return err
+	}
+	csr, err := keygen.GenerateCSR(key, []string{domain})
+	if err != nil {
+		return err
+	}
+	cert, err := trustctl.Request(ctx, trustctl.RequestOptions{CSR: csr, Validation: "http", DirectoryURL: directory})
+	if err != nil {
+		return err
+	}
+	if cert.KeyPath != "" || !cert.ExternalCSR {
+		return errors.New("a private key was stored for the supplied CSR")
+	}
+	if err := openRenewalWindow(domain); err != nil {
+		return err
+	}
+	if _, err := trustctl.Renew(ctx, domain, trustctl.RenewOptions{}); err != nil {
+		return err
+	}
+	info, err := certinfo.ParseFile(cert.CertPath)
+	if err != nil {
+		return err
+	}
+	if pub, ok := info.Leaf.PublicKey.(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(key.Public()) {
+		return errors.New("renewed certificate is not for the CSR's key")
+	}
+	return nil
+}
+
+// checkKeyRotation renews domain, which reuses its key, under a policy of a new key
+// on every renewal and checks the key was replaced and the old one archived.
+func checkKeyRotation(ctx context.Context, domain string) error {
+	before, err := metadata.Load(domain)
+	if err != nil {
+		return err
+	}
+	key, err := os.ReadFile(before.KeyPath)
+	if err != nil {
+		return err
+	}
+	if err := openRenewalWindow(domain); err != nil {
+		return err
+	}
+	if _, err := trustctl.Renew(ctx, domain, trustctl.RenewOptions{KeyRotation: &trustctl.KeyRotation{EveryRenewals: 1}}); err != nil {
+		return err
+	}
+	now, err := os.ReadFile(before.KeyPath)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(now, key) {
+		return errors.New("private key was not rotated")
+	}
+	archived, err := filepath.Glob(filepath.Join(filepath.Dir(before.KeyPath), "archive", "privkey-*.pem"))
+	if err != nil {
+		return err
+	}
+	for _, path := range archived {
+		if old, err := os.ReadFile(path); err == nil && bytes.Equal(old, key) {
+			return nil
+		}
+	}
+	return errors.New("rotated key was not archived")
+}
+
+// checkRevoke revokes domain's certificate as superseded and checks the CA recorded
+// it and renewals skip it.
+func checkRevoke(ctx context.Context, m *acmeMock, domain string) error {
+	cert, err := trustctl.Revoke(ctx, domain, trustctl.RevokeOptions{Reason: "superseded"})
+	if err != nil {
+		return err
+	}
+	info, err := certinfo.ParseFile(cert.CertPath)
+	if err != nil {
+		return err
+	}
+	if reason, ok := m.Revoked(info.Leaf.SerialNumber); !ok || reason != acme.RevocationReasons["superseded"] {
+		return errors.New("the CA did not record the revocation")
+	}
+	if stored, err := metadata.Load(domain); err != nil || stored.RevokedAt.IsZero() {
+		return errors.New("revocation not stored in metadata")
+	}
+	if _, err := trustctl.Renew(ctx, domain, trustctl.RenewOptions{}); !errors.Is(err, trustctl.ErrRevoked) {
+		return fmt.Errorf("renewal of a revoked certificate returned %v", err)
+	}
+	return nil
+}
+
+func checkValidated(m *acmeMock, name, typ string) error {
+	if got := m.Validated(name); got != typ {
+		return fmt.Errorf("validated with %q, want %s", got, typ)
+	}
+	return nil
+}
+
+// checkIssued parses the certificate at path and checks it covers names.
+func checkIssued(path string, names ...string) (string, error) {
+	info, err := certinfo.ParseFile(path)
+	if err != nil {
+		return "", err
+	}
+	for _, n := range names {
+		if err := info.Leaf.VerifyHostname(n); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("serial %s, issuer %s", info.Serial, info.IssuerDN), nil
+}
+
+func checkEmpty(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("%d file(s) left in %s", len(entries), dir)
+	}
+	return nil
+}
+
+// checkACME verifies directory, nonce and JWS handling and registers the account the
+// pipeline checks issue under. Against the built-in mock it also checks the terms of
+// service and external account binding refusals, registers with EAB, updates and
+// reads back the account, which checks signatures and badNonce retries, and rotates
+// the account key.
+func checkACME(ctx context.Context, rep *Report, opts Options, m *acmeMock) bool {
+	const email = "selftest@trustctl.invalid"
+	if m == nil {
+		httpClient := httpclient.New(30 * time.Second)
+		if opts.CAFile != "" {
+			pem, err := os.ReadFile(opts.CAFile)
+			if err != nil {
+				return rep.add("acme CA file", err, opts.CAFile)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return rep.add("acme CA file", errors.New("no certificates found"), opts.CAFile)
+			}
+			httpClient.Transport = httpclient.Transport(&tls.Config{RootCAs: pool})
+		}
+		c := acme.NewClient(opts.DirectoryURL, nil)
+		c.HTTP = httpClient
+		d, err := c.Directory(ctx)
+		if !rep.add("acme directory", err, opts.DirectoryURL) {
+			return false
+		}
+		resp, err := c.HTTP.Head(d.NewNonce)
+		if err == nil {
+			resp.Body.Close()
+			if resp.Header.Get("Replay-Nonce") == "" {
+				err = errors.New("no Replay-Nonce header")
+			}
+		}
+		if !rep.add("acme nonce", err, d.NewNonce) {
+			return false
+		}
+		acc, err := account.Create(ctx, account.CANameFor("", "", opts.DirectoryURL), "", email, account.CreateOptions{DirectoryURL: opts.DirectoryURL, AgreeTOS: true, HTTPClient: httpClient})
+		if err == nil {
+			err = acc.Store()
+		}
+		detail := ""
+		if acc != nil {
+			detail = acc.AccountURL
+		}
+		return rep.add("acme account registration", err, detail)
+	}
+
+	caName := account.CANameFor("", "", m.DirectoryURL())
+	_, err := account.Create(ctx, caName, "", email, account.CreateOptions{DirectoryURL: m.DirectoryURL()})
+	var tos *account.TermsError
+	if !errors.As(err, &tos) {
+		err = fmt.Errorf("registration without accepting the terms returned %v", err)
+	} else {
+		err = nil
+	}
+	if !rep.add("acme terms of service required (mock)", err, "") {
+		return false
+	}
+	_, err = account.Create(ctx, caName, "", email, account.CreateOptions{DirectoryURL: m.DirectoryURL(), AgreeTOS: true})
+	if !errors.Is(err, account.ErrEABRequired) {
+		err = fmt.Errorf("registration without external account binding returned %v", err)
+	} else {
+		err = nil
+	}
+	if !rep.add("acme external account binding required (mock)", err, "") {
+		return false
+	}
+	eab, err := acme.NewExternalAccountBinding(mockEABKID, base64.RawURLEncoding.EncodeToString(mockEABHMACKey))
+	if err != nil {
+		return rep.add("acme external account binding", err, "")
+	}
+	acc, err := account.Create(ctx, caName, "", email, account.CreateOptions{DirectoryURL: m.DirectoryURL(), AgreeTOS: true, EAB: eab})
+	if err == nil {
+		err = acc.Store()
+	}
+	if !rep.add("acme account registration with nonce retry (mock)", err, m.AccountURL()) {
+		return false
+	}
+
+	key, err := acc.LoadKey()
+	if !rep.add("acme account key stored", err, acc.AccountKey) {
+		return false
+	}
+	c := acme.NewClient(acc.Directory(), key)
+	c.KID = acc.AccountURL
+	contact := "mailto:ops@selftest.trustctl.invalid"
+	if _, err := c.UpdateAccount(ctx, []string{contact}); !rep.add("acme signed account update", err, "") {
+		return false
+	}
+	got, err := c.GetAccount(ctx)
+	if err == nil && (len(got.Contact) != 1 || got.Contact[0] != contact) {
+		err = fmt.Errorf("account contact %v, want %s", got.Contact, contact)
+	}
+	if !rep.add("acme account read-back", err, "") {
+		return false
+	}
+
+	// Rotate the key; the pipeline checks then sign with the new one
+	before := acc.KeyThumbprint()
+	backup, err := acc.RotateKey(ctx, nil)
+	if err == nil && acc.KeyThumbprint() == before {
+		err = errors.New("account key unchanged")
+	}
+	if err == nil {
+		_, err = os.Stat(backup)
+	}
+	return rep.add("acme account key rotation", err, backup)
+}
+
+func checkFiles(files ...string) error {
+	for _, f := range files {
+		if f == "" {
+			return errors.New("file path not recorded in metadata")
+		}
+		if _, err := os.Stat(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
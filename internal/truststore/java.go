@@ -0,0 +1,193 @@
+package truststore
+
+import (
+	"bufio"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/trustctl/trustctl/internal/metadata"
+	"github.com/trustctl/trustctl/internal/ui"
+)
+
+// JavaStorepassEnv overrides the truststore password ("changeit", the JDK default).
+const JavaStorepassEnv = "TRUSTCTL_JAVA_STOREPASS"
+
+// DefaultJavaStorepass returns $TRUSTCTL_JAVA_STOREPASS, or "changeit".
+func DefaultJavaStorepass() string {
+	if p := os.Getenv(JavaStorepassEnv); p != "" {
+		return p
+	}
+	return "changeit"
+}
+
+// JavaStore manages CA entries in a Java truststore (cacerts, JKS or PKCS12) through keytool.
+type JavaStore struct {
+	Path     string
+	Password string
+	Keytool  string
+}
+
+// NewJavaStore locates keytool (on PATH or under $JAVA_HOME/bin) for the truststore at path.
+func NewJavaStore(path, password string) (*JavaStore, error) {
+	kt, err := exec.LookPath("keytool")
+	if err != nil {
+		if home := os.Getenv("JAVA_HOME"); home != "" {
+			kt = filepath.Join(home, "bin", "keytool")
+			if _, serr := os.Stat(kt); serr != nil {
+				return nil, fmt.Errorf("keytool not found on PATH or in $JAVA_HOME/bin")
+			}
+		} else {
+			return nil, fmt.Errorf("keytool not found on PATH (set JAVA_HOME)")
+		}
+	}
+	return &JavaStore{Path: path, Password: password, Keytool: kt}, nil
+}
+
+// Aliases returns the aliases in the truststore starting with prefix.
+func (j *JavaStore) Aliases(prefix string) ([]string, error) {
+	out, err := j.run("-list")
+	if err != nil {
+		return nil, err
+	}
+	var aliases []string
+	sc := bufio.NewScanner(strings.NewReader(out))
+	for sc.Scan() {
+		// Entry lines look like: "<alias>, <date>, trustedCertEntry,"
+		line := sc.Text()
+		i := strings.Index(line, ", ")
+		if i <= 0 || !strings.Contains(line, "trustedCertEntry") {
+			continue
+		}
+		if alias := line[:i]; strings.HasPrefix(alias, prefix) {
+			aliases = append(aliases, alias)
+		}
+	}
+	return aliases, nil
+}
+
+// Refresh replaces every entry named <prefix>-N with the given CA certificates,
+// so a renewed chain never leaves stale intermediates behind. It returns the aliases written.
+func (j *JavaStore) Refresh(prefix string, cas []*x509.Certificate) ([]string, error) {
+	old, err := j.Aliases(prefix + "-")
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range old {
+		if _, err := j.run("-delete", "-alias", a); err != nil {
+			return nil, err
+		}
+	}
+
+	tmp, err := os.CreateTemp("", "trustctl-ca-*.pem")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	tmp.Close()
+
+	var written []string
+	for i, c := range cas {
+		alias := fmt.Sprintf("%s-%d", prefix, i)
+		if err := os.WriteFile(tmp.Name(), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw}), 0600); err != nil {
+			return written, err
+		}
+		if _, err := j.run("-importcert", "-noprompt", "-trustcacerts", "-alias", alias, "-file", tmp.Name()); err != nil {
+			return written, err
+		}
+		written = append(written, alias)
+	}
+	return written, nil
+}
+
+// RefreshFromFile imports the CA certificates of the PEM chain at certPath under alias.
+func (j *JavaStore) RefreshFromFile(certPath, alias string) error {
+	cas, err := ReadCAs(certPath)
+	if err != nil {
+		return err
+	}
+	written, err := j.Refresh(alias, cas)
+	if err != nil {
+		return err
+	}
+	for i, a := range written {
+		ui.Success("%s: %s", a, cas[i].Subject)
+	}
+	return nil
+}
+
+// RefreshJavaTruststores re-imports the renewed chain into every truststore recorded for the certificate.
+// Failures are warnings: the renewal itself has already succeeded.
+func RefreshJavaTruststores(meta *metadata.CertMetadata) {
+	for _, t := range meta.JavaTruststores {
+		js, err := NewJavaStore(t.Path, DefaultJavaStorepass())
+		if err == nil {
+			err = js.RefreshFromFile(meta.CertPath, t.Alias)
+		}
+		if err != nil {
+			ui.Warning("failed to refresh Java truststore %s: %v", t.Path, err)
+		}
+	}
+}
+
+// Remove deletes every entry named <prefix>-N and returns the aliases removed.
+func (j *JavaStore) Remove(prefix string) ([]string, error) {
+	old, err := j.Aliases(prefix + "-")
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range old {
+		if _, err := j.run("-delete", "-alias", a); err != nil {
+			return nil, err
+		}
+	}
+	return old, nil
+}
+
+// storepassEnv carries the store password to keytool so it never appears in the process list.
+const storepassEnv = "TRUSTCTL_KEYTOOL_STOREPASS"
+
+func (j *JavaStore) run(args ...string) (string, error) {
+	args = append(args, "-keystore", j.Path, "-storepass:env", storepassEnv)
+	cmd := exec.Command(j.Keytool, args...)
+	cmd.Env = append(os.Environ(), storepassEnv+"="+j.Password)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("keytool %s failed: %v: %s", args[0], err, strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
+
+// ReadCAs returns the CA certificates (everything but non-CA leaves) from a PEM file.
+func ReadCAs(file string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var cas []*x509.Certificate
+	for {
+		var b *pem.Block
+		b, data = pem.Decode(data)
+		if b == nil {
+			break
+		}
+		if b.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(b.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if c.IsCA {
+			cas = append(cas, c)
+		}
+	}
+	if len(cas) == 0 {
+		return nil, fmt.Errorf("no CA certificates found in %s", file)
+	}
+	return cas, nil
+}
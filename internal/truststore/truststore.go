@@ -0,0 +1,135 @@
+package truststore
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// filePrefix marks anchors written by trustctl so they can be listed and removed.
+const filePrefix = "trustctl-"
+
+var validName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// Store describes an OS trust store: where anchors go and how to rebuild the bundle.
+type Store struct {
+	Name      string
+	AnchorDir string
+	Update    []string
+}
+
+// known stores, probed in order
+var stores = []Store{
+	// Debian, Ubuntu, Alpine
+	{Name: "debian", AnchorDir: "/usr/local/share/ca-certificates", Update: []string{"update-ca-certificates"}},
+	// RHEL, CentOS, Fedora
+	{Name: "rhel", AnchorDir: "/etc/pki/ca-trust/source/anchors", Update: []string{"update-ca-trust", "extract"}},
+	// SUSE
+	{Name: "suse", AnchorDir: "/etc/pki/trust/anchors", Update: []string{"update-ca-certificates"}},
+}
+
+// Detect returns the trust store of this host, chosen by which update tool and
+// anchor directory are present.
+func Detect() (*Store, error) {
+	for _, s := range stores {
+		if _, err := exec.LookPath(s.Update[0]); err != nil {
+			continue
+		}
+		if fi, err := os.Stat(s.AnchorDir); err == nil && fi.IsDir() {
+			st := s
+			return &st, nil
+		}
+	}
+	return nil, errors.New("no supported system trust store found (need update-ca-certificates or update-ca-trust)")
+}
+
+// ReadRoot loads the root certificate from a PEM file. If the file holds a chain
+// the last certificate is used; it must be a self-signed CA.
+func ReadRoot(file string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var last *x509.Certificate
+	for {
+		var b *pem.Block
+		b, data = pem.Decode(data)
+		if b == nil {
+			break
+		}
+		if b.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(b.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		last = c
+	}
+	if last == nil {
+		return nil, fmt.Errorf("no certificate found in %s", file)
+	}
+	if !last.IsCA {
+		return nil, fmt.Errorf("%s is not a CA certificate", last.Subject)
+	}
+	if err := last.CheckSignatureFrom(last); err != nil {
+		return nil, fmt.Errorf("%s is not self-signed; pass the root, not an intermediate", last.Subject)
+	}
+	return last, nil
+}
+
+func (s *Store) anchorPath(name string) string {
+	return filepath.Join(s.AnchorDir, filePrefix+name+".crt")
+}
+
+// Install writes root as anchor <name> and rebuilds the system bundle.
+func (s *Store) Install(name string, root *x509.Certificate) (string, error) {
+	if !validName.MatchString(name) {
+		return "", fmt.Errorf("invalid anchor name %q", name)
+	}
+	p := s.anchorPath(name)
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.Raw})
+	if err := os.WriteFile(p, data, 0644); err != nil {
+		return "", err
+	}
+	return p, s.update()
+}
+
+// Remove deletes anchor <name> and rebuilds the system bundle.
+func (s *Store) Remove(name string) (string, error) {
+	if !validName.MatchString(name) {
+		return "", fmt.Errorf("invalid anchor name %q", name)
+	}
+	p := s.anchorPath(name)
+	if err := os.Remove(p); err != nil {
+		return "", err
+	}
+	return p, s.update()
+}
+
+// List returns the anchor names installed by trustctl.
+func (s *Store) List() ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(s.AnchorDir, filePrefix+"*.crt"))
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, m := range matches {
+		names = append(names, strings.TrimSuffix(strings.TrimPrefix(filepath.Base(m), filePrefix), ".crt"))
+	}
+	return names, nil
+}
+
+func (s *Store) update() error {
+	out, err := exec.Command(s.Update[0], s.Update[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s failed: %v: %s", strings.Join(s.Update, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
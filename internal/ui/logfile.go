@@ -0,0 +1,125 @@
+package ui
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Log files are rotated once they pass LogMaxBytes, keeping LogKeep older
+// generations as <name>.1 (newest) to <name>.<LogKeep>.
+const (
+	LogMaxBytes = 10 << 20
+	LogKeep     = 5
+)
+
+// LogFile is a sink writing every message, debug included, as one JSON object per
+// line for later troubleshooting: time, level, msg and the fields it was opened with.
+type LogFile struct {
+	mu     sync.Mutex
+	path   string
+	f      *os.File
+	size   int64
+	fields map[string]interface{}
+}
+
+// OpenLogFile opens path for appending, creating it and its directory owner-only,
+// and rotates it first when it is already too large. fields are added to every entry.
+func OpenLogFile(path string, fields map[string]interface{}) (*LogFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	l := &LogFile{path: path, fields: fields}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	if l.size >= LogMaxBytes {
+		if err := l.rotate(); err != nil {
+			l.f.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+func (l *LogFile) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.size = f, fi.Size()
+	return nil
+}
+
+// rotate shifts <path>.N to <path>.N+1, dropping the oldest, and starts a new file.
+func (l *LogFile) rotate() error {
+	l.f.Close()
+	os.Remove(fmt.Sprintf("%s.%d", l.path, LogKeep))
+	for i := LogKeep - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1))
+	}
+	if err := os.Rename(l.path, l.path+".1"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return l.open()
+}
+
+// Message appends one entry. Write errors are dropped: logging must never fail the
+// operation being logged.
+func (l *LogFile) Message(level Level, msg string) {
+	entry := make(map[string]interface{}, len(l.fields)+3)
+	for k, v := range l.fields {
+		entry[k] = v
+	}
+	entry["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	entry["level"] = level.String()
+	entry["msg"] = msg
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return
+	}
+	if l.size+int64(len(line)) > LogMaxBytes && l.size > 0 {
+		if err := l.rotate(); err != nil {
+			l.f = nil
+			return
+		}
+	}
+	n, _ := l.f.Write(line)
+	l.size += int64(n)
+}
+
+// Close closes the file.
+func (l *LogFile) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
+
+// Tee returns a sink delivering every message to each of sinks.
+func Tee(sinks ...Sink) Sink {
+	return SinkFunc(func(level Level, msg string) {
+		for _, s := range sinks {
+			s.Message(level, msg)
+		}
+	})
+}
@@ -2,29 +2,166 @@ package ui
 
 import (
 	"fmt"
+	"io"
 	"os"
 )
 
+// Level classifies a UI message.
+type Level int
+
+const (
+	LevelInfo Level = iota
+	LevelSuccess
+	LevelWarning
+	LevelError
+	LevelStepStart
+	LevelStepDone
+	LevelDebug // detail for troubleshooting; consoles only show it when asked to
+)
+
+// String returns the lowercase level name.
+func (l Level) String() string {
+	switch l {
+	case LevelInfo:
+		return "info"
+	case LevelSuccess:
+		return "success"
+	case LevelWarning:
+		return "warning"
+	case LevelError:
+		return "error"
+	case LevelStepStart:
+		return "step"
+	case LevelStepDone:
+		return "done"
+	case LevelDebug:
+		return "debug"
+	}
+	return fmt.Sprintf("level(%d)", int(l))
+}
+
+// Sink receives every message emitted through this package. Programs embedding
+// trustctl install their own with SetSink to route progress into their logger.
+type Sink interface {
+	Message(level Level, msg string)
+}
+
+// SinkFunc adapts a function to Sink.
+type SinkFunc func(level Level, msg string)
+
+// Message calls f.
+func (f SinkFunc) Message(level Level, msg string) { f(level, msg) }
+
+// Discard drops all messages.
+var Discard Sink = SinkFunc(func(Level, string) {})
+
+// Console is the default sink: emoji-prefixed lines, warnings and errors on stderr.
+// Quiet keeps only warnings and errors, Plain replaces the emoji with plain-text
+// prefixes, Color highlights successes, warnings and errors with ANSI colors, and
+// Debug also shows debug messages.
+type Console struct {
+	Out, Err io.Writer
+	Quiet    bool
+	Plain    bool
+	Color    bool
+	Debug    bool
+}
+
+var prefixes = map[Level]string{
+	LevelInfo:      "ℹ️  ",
+	LevelSuccess:   "✅ ",
+	LevelWarning:   "⚠️  ",
+	LevelError:     "❌ ",
+	LevelStepStart: "🔄 ",
+	LevelStepDone:  "✔️  ",
+	LevelDebug:     "🐛 ",
+}
+
+var plainPrefixes = map[Level]string{
+	LevelWarning: "warning: ",
+	LevelError:   "error: ",
+	LevelDebug:   "debug: ",
+}
+
+var colors = map[Level]string{
+	LevelSuccess:  "\x1b[32m",
+	LevelStepDone: "\x1b[32m",
+	LevelWarning:  "\x1b[33m",
+	LevelError:    "\x1b[31m",
+	LevelDebug:    "\x1b[2m",
+}
+
+// Message writes msg with its level prefix, unless the console filters it out.
+func (c Console) Message(level Level, msg string) {
+	switch {
+	case level == LevelDebug && !c.Debug:
+		return
+	case c.Quiet && level != LevelWarning && level != LevelError && level != LevelDebug:
+		return
+	}
+	w := c.Out
+	if level == LevelWarning || level == LevelError || level == LevelDebug {
+		w = c.Err
+	}
+	line := prefixes[level] + msg
+	if c.Plain {
+		line = plainPrefixes[level] + msg
+	}
+	if code, ok := colors[level]; ok && c.Color {
+		line = code + line + "\x1b[0m"
+	}
+	fmt.Fprint(w, line+"\n")
+}
+
+// ColorEnabled reports whether ANSI colors suit f: it is a terminal, NO_COLOR
+// (https://no-color.org) is unset and TERM is not dumb.
+func ColorEnabled(f *os.File) bool {
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+var sink Sink = Console{Out: os.Stdout, Err: os.Stderr}
+
+// SetSink replaces the active sink; nil discards output.
+func SetSink(s Sink) {
+	if s == nil {
+		s = Discard
+	}
+	sink = s
+}
+
+// CurrentSink returns the active sink.
+func CurrentSink() Sink {
+	return sink
+}
+
 func Info(format string, a ...interface{}) {
-	fmt.Printf("ℹ️  "+format+"\n", a...)
+	sink.Message(LevelInfo, fmt.Sprintf(format, a...))
 }
 
 func Success(format string, a ...interface{}) {
-	fmt.Printf("✅ "+format+"\n", a...)
+	sink.Message(LevelSuccess, fmt.Sprintf(format, a...))
 }
 
 func Warning(format string, a ...interface{}) {
-	fmt.Fprintf(os.Stderr, "⚠️  "+format+"\n", a...)
+	sink.Message(LevelWarning, fmt.Sprintf(format, a...))
 }
 
 func Error(format string, a ...interface{}) {
-	fmt.Fprintf(os.Stderr, "❌ "+format+"\n", a...)
+	sink.Message(LevelError, fmt.Sprintf(format, a...))
 }
 
 func StepStart(format string, a ...interface{}) {
-	fmt.Printf("🔄 "+format+"\n", a...)
+	sink.Message(LevelStepStart, fmt.Sprintf(format, a...))
 }
 
 func StepDone(format string, a ...interface{}) {
-	fmt.Printf("✔️  "+format+"\n", a...)
+	sink.Message(LevelStepDone, fmt.Sprintf(format, a...))
+}
+
+func Debug(format string, a ...interface{}) {
+	sink.Message(LevelDebug, fmt.Sprintf(format, a...))
 }
@@ -0,0 +1,108 @@
+package validation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/trustctl/trustctl/internal/ui"
+)
+
+// EmailDCV is implemented by enterprise CA clients that validate domain control by
+// email: the CA mails an approval link to one of the addresses it accepts for the
+// domain and reports when it has been followed.
+type EmailDCV interface {
+	ApproverEmails(ctx context.Context, domain string) ([]string, error)
+	StartEmailDCV(ctx context.Context, domain, approver string) error
+	EmailDCVStatus(ctx context.Context, domain string) (approved bool, err error)
+}
+
+// Approval emails are waited for this long, checking at EmailPollInterval.
+var (
+	EmailTimeout      = time.Hour
+	EmailPollInterval = 30 * time.Second
+)
+
+// WithEmailDCV sets the CA used for email validation and the approver address to
+// send to; an empty approver picks the CA's first listed address.
+func (v *Validator) WithEmailDCV(dcv EmailDCV, approver string) *Validator {
+	v.dcv = dcv
+	v.approver = approver
+	return v
+}
+
+func (v *Validator) doEmail(ctx context.Context, domains []string) error {
+	if v.dcv == nil {
+		return errors.New("email validation requires an enterprise CA with email DCV (--serverurl)")
+	}
+	var pending []string
+	seen := map[string]bool{}
+	for _, d := range domains {
+		domain := challengeDomain(d)
+		if seen[domain] {
+			continue
+		}
+		seen[domain] = true
+		// CAs keep a domain validated for a while; only mail when needed
+		if ok, err := v.dcv.EmailDCVStatus(ctx, domain); err == nil && ok {
+			ui.Info("%s is already validated at the CA", domain)
+			continue
+		}
+		approver, err := v.approverFor(ctx, domain)
+		if err != nil {
+			return err
+		}
+		if err := v.dcv.StartEmailDCV(ctx, domain, approver); err != nil {
+			return fmt.Errorf("start email validation for %s: %w", domain, err)
+		}
+		ui.Info("Validation email for %s sent to %s; follow the link in it to approve", domain, approver)
+		pending = append(pending, domain)
+	}
+
+	deadline := time.Now().Add(EmailTimeout)
+	for len(pending) > 0 {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("email validation not approved within %s: %s", EmailTimeout, strings.Join(pending, ", "))
+		}
+		if err := sleep(ctx, EmailPollInterval); err != nil {
+			return err
+		}
+		var still []string
+		for _, domain := range pending {
+			ok, err := v.dcv.EmailDCVStatus(ctx, domain)
+			if err != nil {
+				return err
+			}
+			if ok {
+				ui.Success("%s approved", domain)
+				continue
+			}
+			still = append(still, domain)
+		}
+		pending = still
+	}
+	return nil
+}
+
+// approverFor returns the approver address for domain: the configured one, which the
+// CA must accept, or the first address the CA lists.
+func (v *Validator) approverFor(ctx context.Context, domain string) (string, error) {
+	approvers, err := v.dcv.ApproverEmails(ctx, domain)
+	if err != nil {
+		return "", fmt.Errorf("fetch approver emails for %s: %w", domain, err)
+	}
+	if len(approvers) == 0 {
+		return "", fmt.Errorf("the CA offers no approver email addresses for %s", domain)
+	}
+	if v.approver == "" {
+		return approvers[0], nil
+	}
+	for _, a := range approvers {
+		if strings.EqualFold(a, v.approver) {
+			return a, nil
+		}
+	}
+	return "", fmt.Errorf("the CA does not accept %s as approver for %s (choose one of: %s)", v.approver, domain, strings.Join(approvers, ", "))
+}
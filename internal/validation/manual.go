@@ -0,0 +1,166 @@
+package validation
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/trustctl/trustctl/internal/acme"
+	"github.com/trustctl/trustctl/internal/secret"
+	"github.com/trustctl/trustctl/internal/ui"
+)
+
+// Manual configures manual validation, for DNS hosted by providers without an API
+// or web servers trustctl cannot write to: the user, or their own scripts, publish
+// the challenge responses.
+type Manual struct {
+	Challenge string `json:"challenge"` // dns (default) or http
+	// AuthHook is a shell command publishing one challenge response, run once per
+	// name with TRUSTCTL_DOMAIN, TRUSTCTL_VALIDATION and TRUSTCTL_TOKEN set (and
+	// certbot's CERTBOT_* equivalents). Without it the user is prompted.
+	AuthHook string `json:"auth_hook,omitempty"`
+	// CleanupHook removes a response once the CA has checked it, with the same
+	// variables plus TRUSTCTL_AUTH_OUTPUT, the auth hook's output.
+	CleanupHook string `json:"cleanup_hook,omitempty"`
+}
+
+// Method returns the validation method the challenge is answered by, dns when unset.
+func (m Manual) Method() string {
+	if c := strings.ToLower(m.Challenge); c != "" {
+		return c
+	}
+	return "dns"
+}
+
+// CheckManual rejects challenges manual validation cannot answer.
+func CheckManual(m Manual) error {
+	switch m.Method() {
+	case "dns", "http":
+		return nil
+	}
+	return fmt.Errorf("unknown manual challenge %q (expected dns or http)", m.Challenge)
+}
+
+// ManualConfirm waits until the user confirms the responses printed for manual
+// validation are published. Programs embedding trustctl without a terminal can
+// replace it.
+var ManualConfirm = func(ctx context.Context) error {
+	if !secret.IsTerminal(os.Stdin) {
+		return fmt.Errorf("manual validation without an auth hook needs confirmation: %w", secret.ErrNoTerminal)
+	}
+	fmt.Fprint(os.Stderr, "Press Enter once everything above is published... ")
+	done := make(chan error, 1)
+	go func() {
+		_, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		done <- err
+	}()
+	select {
+	case <-ctx.Done():
+		fmt.Fprintln(os.Stderr)
+		return ctx.Err()
+	case err := <-done:
+		return err
+	}
+}
+
+// WithManual sets how manual validation publishes the challenge responses.
+func (v *Validator) WithManual(m Manual) *Validator {
+	v.manual = m
+	return v
+}
+
+// manualResponse is one challenge response to publish by hand.
+type manualResponse struct {
+	domain string // the name validated; the base name for wildcards with dns
+	token  string // http only
+	value  string // TXT value, or the content of the token file
+}
+
+func (v *Validator) doManual(ctx context.Context, domains []string) error {
+	var responses []manualResponse
+	seen := map[manualResponse]bool{}
+	for _, d := range domains {
+		c := v.challenge(d)
+		r := manualResponse{domain: d, token: c.Token, value: c.KeyAuth}
+		if v.manual.Method() == "dns" {
+			r = manualResponse{domain: challengeDomain(d), value: acme.DNS01Value(c.KeyAuth)}
+		}
+		if !seen[r] {
+			seen[r] = true
+			responses = append(responses, r)
+		}
+	}
+
+	if v.manual.AuthHook == "" {
+		for _, r := range responses {
+			if v.manual.Method() == "dns" {
+				ui.Info("Create a DNS TXT record:\n    _acme-challenge.%s. 60 IN TXT \"%s\"", r.domain, r.value)
+			} else {
+				ui.Info("Serve this content:\n    %s\nat:\n    http://%s/.well-known/acme-challenge/%s", r.value, r.domain, r.token)
+			}
+		}
+		if len(responses) > 1 && v.manual.Method() == "dns" {
+			ui.Info("Names sharing a record need one TXT value each; keep them all")
+		}
+		if err := ManualConfirm(ctx); err != nil {
+			return err
+		}
+		ui.Info("Remove the records or files once the certificate is issued")
+		return nil
+	}
+
+	for _, r := range responses {
+		r := r
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		env := r.env()
+		out, err := runManualHook(v.manual.AuthHook, env)
+		if v.manual.CleanupHook != "" {
+			cleanup := append(env, "TRUSTCTL_AUTH_OUTPUT="+out, "CERTBOT_AUTH_OUTPUT="+out)
+			v.cleanups = append(v.cleanups, func() {
+				if _, err := runManualHook(v.manual.CleanupHook, cleanup); err != nil {
+					ui.Warning("manual cleanup hook for %s: %v", r.domain, err)
+				}
+			})
+		}
+		if err != nil {
+			return fmt.Errorf("manual auth hook for %s: %w", r.domain, err)
+		}
+	}
+	return nil
+}
+
+// env describes r to manual hooks.
+func (r manualResponse) env() []string {
+	return []string{
+		"TRUSTCTL_DOMAIN=" + r.domain,
+		"TRUSTCTL_VALIDATION=" + r.value,
+		"TRUSTCTL_TOKEN=" + r.token,
+		"CERTBOT_DOMAIN=" + r.domain,
+		"CERTBOT_VALIDATION=" + r.value,
+		"CERTBOT_TOKEN=" + r.token,
+	}
+}
+
+// runManualHook runs command through the shell with env added and returns its
+// trimmed standard output.
+func runManualHook(command string, env []string) (string, error) {
+	cmd := exec.Command("/bin/sh", "-c", command)
+	cmd.Env = append(os.Environ(), env...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		var exit *exec.ExitError
+		if errors.As(err, &exit) && stderr.Len() > 0 {
+			return "", fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
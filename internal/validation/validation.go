@@ -1,87 +1,333 @@
 package validation
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/trustctl/trustctl/internal/acme"
 	"github.com/trustctl/trustctl/internal/dns"
+	"github.com/trustctl/trustctl/internal/paths"
 )
 
 type Validator struct {
 	vtype       string
 	dnsProvider dns.DNSProvider
+	webroot     string
+	httpAddr    string
+	zones       *dns.ZoneResolver
+	challenges  map[string]Challenge
+	dcv         EmailDCV
+	approver    string
+	manual      Manual
+	cleanups    []func()
+}
+
+// Challenge is what the CA asked to be published for one name.
+type Challenge struct {
+	Token   string
+	KeyAuth string // token.thumbprint, see acme.KeyAuthorization
 }
 
 func NewValidator(vtype string, provider dns.DNSProvider) *Validator {
-	return &Validator{vtype: vtype, dnsProvider: provider}
+	return &Validator{vtype: vtype, dnsProvider: provider, webroot: paths.Webroot()}
+}
+
+// WithWebroot overrides the document root used for HTTP validation.
+func (v *Validator) WithWebroot(dir string) *Validator {
+	if dir != "" {
+		v.webroot = dir
+	}
+	return v
+}
+
+// WithHTTPAddr answers http-01 from a listener on addr, e.g. ":8080" behind a port 80
+// redirect, instead of writing token files into the webroot. Unprivileged users can
+// bind ports from 1024 up.
+func (v *Validator) WithHTTPAddr(addr string) *Validator {
+	v.httpAddr = addr
+	return v
+}
+
+// WithZoneResolver overrides the resolver used to find challenge zones.
+func (v *Validator) WithZoneResolver(r *dns.ZoneResolver) *Validator {
+	v.zones = r
+	return v
+}
+
+// WithChallenges sets the challenges to publish, keyed by name ("*.example.com" for
+// wildcards).
+func (v *Validator) WithChallenges(c map[string]Challenge) *Validator {
+	v.challenges = c
+	return v
 }
 
-// Validate performs validation for provided domains according to vtype.
-func (v *Validator) Validate(domains []string) error {
+// challenge returns the challenge for domain. CAs that are not driven through ACME
+// (enterprise-ca) hand out their DCV values out of band, so names without an ACME
+// challenge keep getting placeholders.
+func (v *Validator) challenge(domain string) Challenge {
+	if c, ok := v.challenges[strings.ToLower(domain)]; ok {
+		return c
+	}
+	return Challenge{Token: domain + ".token", KeyAuth: "token-placeholder"}
+}
+
+// CheckWildcards rejects malformed wildcard names and wildcards with a validation
+// method other than dns or email: ACME CAs only validate "*.example.com" through
+// DNS-01 at _acme-challenge.example.com, enterprise CAs also by email for example.com.
+func CheckWildcards(domains []string, vtype string) error {
+	for _, d := range domains {
+		if !strings.Contains(d, "*") {
+			continue
+		}
+		base := strings.TrimPrefix(d, "*.")
+		if base == d || strings.Contains(base, "*") || !strings.Contains(base, ".") {
+			return fmt.Errorf("invalid wildcard name %q: only a leading \"*.\" label is allowed, as in *.example.com", d)
+		}
+		if vtype != "dns" && vtype != "email" {
+			return fmt.Errorf("wildcard name %s requires --validation dns", d)
+		}
+	}
+	return nil
+}
+
+// CheckIPAddresses rejects IP address identifiers with a validation method other than
+// http: CAs only validate IP addresses through http-01 or tls-alpn-01 (RFC 8738),
+// never by DNS or email.
+func CheckIPAddresses(domains []string, vtype string) error {
+	for _, d := range domains {
+		if net.ParseIP(d) != nil && vtype != "http" {
+			return fmt.Errorf("IP address %s requires --validation http", d)
+		}
+	}
+	return nil
+}
+
+// challengeDomain returns the name whose _acme-challenge record validates domain.
+func challengeDomain(domain string) string {
+	return strings.TrimPrefix(domain, "*.")
+}
+
+// CleanUp removes the records and token files Validate published. Call it once the
+// CA has checked them.
+func (v *Validator) CleanUp() {
+	for _, f := range v.cleanups {
+		f()
+	}
+	v.cleanups = nil
+}
+
+// Validate performs validation for provided domains according to vtype; the
+// challenge responses stay published until CleanUp. Cancelling ctx stops waiting
+// for propagation or approval; what was published is still removed by CleanUp.
+func (v *Validator) Validate(ctx context.Context, domains []string) error {
+	method := v.vtype
+	if method == "manual" {
+		if err := CheckManual(v.manual); err != nil {
+			return err
+		}
+		method = v.manual.Method()
+	}
+	if err := CheckWildcards(domains, method); err != nil {
+		return err
+	}
+	if err := CheckIPAddresses(domains, method); err != nil {
+		return err
+	}
 	switch v.vtype {
 	case "dns":
 		if v.dnsProvider == nil {
 			return errors.New("dns provider not configured")
 		}
-		return v.doDNS(domains)
+		return v.doDNS(ctx, domains)
 	case "http":
-		return v.doHTTP(domains)
+		return v.doHTTP(ctx, domains)
 	case "email":
-		return errors.New("email validation not implemented yet")
+		return v.doEmail(ctx, domains)
+	case "manual":
+		return v.doManual(ctx, domains)
 	default:
 		return fmt.Errorf("unknown validation type: %s", v.vtype)
 	}
 }
 
-func (v *Validator) doDNS(domains []string) error {
-	// Parallel Present
-	var wg sync.WaitGroup
-	errs := make(chan error, len(domains))
+// cleanupTimeout bounds the removal of each challenge record by a ContextProvider.
+const cleanupTimeout = 30 * time.Second
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+func (v *Validator) doDNS(ctx context.Context, domains []string) error {
+	if zp, ok := v.dnsProvider.(dns.ZoneProvider); ok {
+		return v.doDNSZones(ctx, zp, domains)
+	}
+	cp, cancellable := v.dnsProvider.(dns.ContextProvider)
+
+	// Wildcards are validated at the base name; *.example.com and example.com share
+	// _acme-challenge.example.com, with one TXT value per authorization
+	type target struct {
+		domain string
+		Challenge
+	}
+	var targets []target
+	seen := map[target]bool{}
 	for _, d := range domains {
+		t := target{challengeDomain(d), v.challenge(d)}
+		if !seen[t] {
+			seen[t] = true
+			targets = append(targets, t)
+		}
+	}
+
+	// Parallel Present; every attempted record is cleaned up, failed ones included
+	var wg sync.WaitGroup
+	errs := make(chan error, len(targets))
+	for _, t := range targets {
+		t := t
+		v.cleanups = append(v.cleanups, func() {
+			if !cancellable {
+				_ = v.dnsProvider.CleanUp(t.domain, t.Token, t.KeyAuth)
+				return
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+			defer cancel()
+			_ = cp.CleanUpContext(ctx, t.domain, t.Token, t.KeyAuth)
+		})
 		wg.Add(1)
-		go func(domain string) {
+		go func() {
 			defer wg.Done()
-			token := "acme-token"
-			keyAuth := "key-auth"
-			if err := v.dnsProvider.Present(domain, token, keyAuth); err != nil {
+			var err error
+			if cancellable {
+				err = cp.PresentContext(ctx, t.domain, t.Token, t.KeyAuth)
+			} else {
+				err = v.dnsProvider.Present(t.domain, t.Token, t.KeyAuth)
+			}
+			if err != nil {
 				errs <- err
-				return
 			}
-		}(d)
+		}()
 	}
 	wg.Wait()
 	close(errs)
 	for e := range errs {
 		return e
 	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
 	// Wait for propagation (simple fixed sleep for scaffold)
-	time.Sleep(5 * time.Second)
+	return sleep(ctx, 5*time.Second)
+}
 
-	// Cleanup should be handled after issuance; for scaffold, perform cleanup now
+// doDNSZones places records for zone-aware providers at the location found by
+// following CNAMEs and SOA records, so delegated and nested zones get the record.
+func (v *Validator) doDNSZones(ctx context.Context, zp dns.ZoneProvider, domains []string) error {
+	resolver := v.zones
+	if resolver == nil {
+		resolver = dns.NewZoneResolver()
+	}
+	type txt struct {
+		rec   *dns.ChallengeRecord
+		value string
+	}
+	var records []txt
+	seen := map[string]bool{}
 	for _, d := range domains {
-		_ = v.dnsProvider.CleanUp(d, "acme-token", "key-auth")
+		rec, err := resolver.Resolve(d)
+		if err != nil {
+			return fmt.Errorf("zone lookup for %s: %w", d, err)
+		}
+		value := acme.DNS01Value(v.challenge(d).KeyAuth)
+		if !seen[rec.FQDN+" "+value] {
+			seen[rec.FQDN+" "+value] = true
+			records = append(records, txt{rec, value})
+		}
 	}
-	return nil
+
+	for _, r := range records {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := zp.PresentTXT(r.rec.Zone, r.rec.FQDN, r.value); err != nil {
+			return fmt.Errorf("present %s in zone %s: %w", r.rec.FQDN, r.rec.Zone, err)
+		}
+		r := r
+		v.cleanups = append(v.cleanups, func() { _ = zp.CleanUpTXT(r.rec.Zone, r.rec.FQDN, r.value) })
+	}
+
+	// Wait for propagation (simple fixed sleep for scaffold)
+	return sleep(ctx, 5*time.Second)
 }
 
-func (v *Validator) doHTTP(domains []string) error {
+func (v *Validator) doHTTP(ctx context.Context, domains []string) error {
+	if v.httpAddr != "" {
+		return v.serveHTTP(ctx, domains)
+	}
 	// Place challenge token under /.well-known/acme-challenge/<token>
-	base := "/var/www/html/.well-known/acme-challenge"
+	base := filepath.Join(v.webroot, ".well-known", "acme-challenge")
 	if err := os.MkdirAll(base, 0755); err != nil {
 		return err
 	}
 	for _, d := range domains {
-		tokenFile := filepath.Join(base, fmt.Sprintf("%s.token", d))
-		if err := os.WriteFile(tokenFile, []byte("token-placeholder"), 0644); err != nil {
+		c := v.challenge(d)
+		if c.Token == "" || filepath.Base(c.Token) != c.Token {
+			return fmt.Errorf("invalid http-01 token for %s", d)
+		}
+		tokenFile := filepath.Join(base, c.Token)
+		if err := os.WriteFile(tokenFile, []byte(c.KeyAuth), 0644); err != nil {
 			return err
 		}
+		v.cleanups = append(v.cleanups, func() { _ = os.Remove(tokenFile) })
 	}
 	// Give user/ACME client time to validate
-	time.Sleep(2 * time.Second)
-	return nil
+	return sleep(ctx, 2*time.Second)
+}
+
+// serveHTTP answers /.well-known/acme-challenge/<token> for domains from a listener
+// on v.httpAddr until CleanUp.
+func (v *Validator) serveHTTP(ctx context.Context, domains []string) error {
+	tokens := make(map[string]string, len(domains))
+	for _, d := range domains {
+		c := v.challenge(d)
+		if c.Token == "" || strings.Contains(c.Token, "/") {
+			return fmt.Errorf("invalid http-01 token for %s", d)
+		}
+		tokens[c.Token] = c.KeyAuth
+	}
+	ln, err := net.Listen("tcp", v.httpAddr)
+	if err != nil {
+		return fmt.Errorf("http-01 listener: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/acme-challenge/", func(w http.ResponseWriter, r *http.Request) {
+		keyAuth, ok := tokens[strings.TrimPrefix(r.URL.Path, "/.well-known/acme-challenge/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		io.WriteString(w, keyAuth)
+	})
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go srv.Serve(ln)
+	v.cleanups = append(v.cleanups, func() { _ = srv.Close() })
+	return sleep(ctx, 2*time.Second)
 }
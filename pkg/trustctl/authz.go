@@ -0,0 +1,112 @@
+package trustctl
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/trustctl/trustctl/internal/account"
+	"github.com/trustctl/trustctl/internal/acme"
+	"github.com/trustctl/trustctl/internal/ca"
+	"github.com/trustctl/trustctl/internal/validation"
+)
+
+// challengeTypes maps validation methods to the ACME challenges that answer them.
+var challengeTypes = map[string]string{
+	"http": acme.ChallengeHTTP01,
+	"dns":  acme.ChallengeDNS01,
+}
+
+// acmeOrder is an open ACME order with the challenges of its pending authorizations.
+type acmeOrder struct {
+	client  *acme.Client
+	order   *acme.Order
+	issuer  string
+	pending []pendingAuthz
+}
+
+type pendingAuthz struct {
+	url       string
+	name      string
+	challenge acme.Challenge
+	keyAuth   string
+}
+
+// authorize opens an ACME order for domains under acc, with the certificate profile
+// when set, and picks the vtype challenge of every pending authorization. Accounts
+// that are not registered over ACME (enterprise-ca) and methods without an ACME
+// challenge return a nil order.
+func authorize(ctx context.Context, acc *account.AccountInfo, domains []string, vtype, profile, issuer string) (*acmeOrder, error) {
+	typ, ok := challengeTypes[vtype]
+	if !ok || acc.AccountURL == "" || acc.Directory() == "" {
+		return nil, nil
+	}
+	key, err := acc.LoadKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load account key: %w", err)
+	}
+	client := acme.NewClient(acc.Directory(), key)
+	client.KID = acc.AccountURL
+	order, err := client.NewOrder(ctx, acme.Identifiers(domains), profile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create order: %w", err)
+	}
+	o := &acmeOrder{client: client, order: order, issuer: issuer}
+	for _, u := range order.Authorizations {
+		authz, err := client.GetAuthorization(ctx, u)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch authorization: %w", err)
+		}
+		if authz.Status == "valid" {
+			continue
+		}
+		ch := authz.Challenge(typ)
+		if ch == nil {
+			return nil, fmt.Errorf("the CA offers no %s challenge for %s", typ, authz.Name())
+		}
+		keyAuth, err := acme.KeyAuthorization(key, ch.Token)
+		if err != nil {
+			return nil, err
+		}
+		o.pending = append(o.pending, pendingAuthz{url: u, name: authz.Name(), challenge: *ch, keyAuth: keyAuth})
+	}
+	return o, nil
+}
+
+// challenges returns what the validator has to publish, keyed by name; nil for a nil order.
+func (o *acmeOrder) challenges() map[string]validation.Challenge {
+	if o == nil {
+		return nil
+	}
+	out := map[string]validation.Challenge{}
+	for _, p := range o.pending {
+		out[p.name] = validation.Challenge{Token: p.challenge.Token, KeyAuth: p.keyAuth}
+	}
+	return out
+}
+
+// complete asks the CA to check the published challenges, waits for the
+// authorizations, finalizes the order with csr and downloads the certificate with
+// the alternate chains the CA offers.
+func (o *acmeOrder) complete(ctx context.Context, domains []string, csr []byte) (*ca.CertificateMeta, error) {
+	for i := range o.pending {
+		p := &o.pending[i]
+		if err := o.client.Accept(ctx, &p.challenge); err != nil {
+			return nil, fmt.Errorf("failed to start validation of %s: %w", p.name, err)
+		}
+	}
+	for _, p := range o.pending {
+		if _, err := o.client.WaitAuthorization(ctx, p.url); err != nil {
+			// The CA could not verify what was published
+			return nil, classify(ErrValidation, err)
+		}
+	}
+	order, err := o.client.Finalize(ctx, o.order, csr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to finalize order: %w", err)
+	}
+	chains, err := o.client.FetchCertificate(ctx, order.Certificate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download certificate: %w", err)
+	}
+	return &ca.CertificateMeta{Domains: domains, PEM: chains[0], Alternates: chains[1:], Issuer: o.issuer}, nil
+}
@@ -0,0 +1,276 @@
+package trustctl
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/trustctl/trustctl/internal/account"
+	"github.com/trustctl/trustctl/internal/install"
+)
+
+// Installer targets of a BatchSpec besides the names of install.Servers(), which
+// install into that server.
+const (
+	InstallerAuto = "auto" // install into the detected web server (default)
+	InstallerNone = "none" // only obtain and store the certificate
+)
+
+// BatchSpec is one certificate of a domains file. Empty fields keep the value of the
+// base RequestOptions.
+type BatchSpec struct {
+	Domains     []string          `yaml:"domains"`
+	Validation  string            `yaml:"validation"`
+	DNSProvider string            `yaml:"dns_provider"`
+	Webroot     string            `yaml:"webroot"`
+	HTTPAddr    string            `yaml:"http_addr"`
+	Installer   string            `yaml:"installer"` // auto, none or a server of install.Servers()
+	KeyType     string            `yaml:"key_type"`
+	Labels      map[string]string `yaml:"labels"`
+}
+
+// batchColumns are the CSV header names, in BatchSpec order. Labels are not
+// available in CSV.
+var batchColumns = []string{"domains", "validation", "dns_provider", "webroot", "http_addr", "installer", "key_type"}
+
+// LoadBatch reads a domains file: a YAML list of BatchSpec, or with a .csv extension a
+// CSV file whose header names the columns (domains required, separated by spaces or
+// semicolons; see batchColumns for the rest).
+func LoadBatch(path string) ([]BatchSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var specs []BatchSpec
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		specs, err = parseBatchCSV(data)
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err = dec.Decode(&specs); errors.Is(err, io.EOF) {
+			err = nil
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("%s: no certificates listed", path)
+	}
+	for i, s := range specs {
+		if len(s.Domains) == 0 {
+			return nil, fmt.Errorf("%s: entry %d has no domains", path, i+1)
+		}
+		if !validInstaller(s.Installer) {
+			return nil, fmt.Errorf("%s: entry %d: unknown installer %q (expected auto, none, %s)", path, i+1, s.Installer, strings.Join(install.Servers(), ", "))
+		}
+	}
+	return specs, nil
+}
+
+// validInstaller reports whether name is an installer a BatchSpec accepts.
+func validInstaller(name string) bool {
+	switch name = strings.ToLower(name); name {
+	case "", InstallerAuto, InstallerNone:
+		return true
+	}
+	return knownServer(name)
+}
+
+func parseBatchCSV(data []byte) ([]BatchSpec, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	col := map[string]int{}
+	for i, name := range rows[0] {
+		name = strings.ToLower(strings.TrimSpace(name))
+		known := false
+		for _, c := range batchColumns {
+			known = known || c == name
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown column %q (expected %s)", name, strings.Join(batchColumns, ", "))
+		}
+		col[name] = i
+	}
+	if _, ok := col["domains"]; !ok {
+		return nil, errors.New("header has no domains column")
+	}
+	specs := make([]BatchSpec, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		field := func(name string) string {
+			if i, ok := col[name]; ok && i < len(row) {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		}
+		specs = append(specs, BatchSpec{
+			Domains:     strings.FieldsFunc(field("domains"), func(r rune) bool { return r == ' ' || r == ';' }),
+			Validation:  field("validation"),
+			DNSProvider: field("dns_provider"),
+			Webroot:     field("webroot"),
+			HTTPAddr:    field("http_addr"),
+			Installer:   field("installer"),
+			KeyType:     field("key_type"),
+		})
+	}
+	return specs, nil
+}
+
+// BatchOptions adjust RequestBatch.
+type BatchOptions struct {
+	Parallel int           // certificates requested at the same time (default 1)
+	Timeout  time.Duration // bounds each certificate; 0 for no limit
+}
+
+// BatchResult is the outcome of one BatchSpec. Issued is false and Err nil when a
+// valid certificate for the same names already existed.
+type BatchResult struct {
+	Domains     []string
+	Issued      bool
+	Certificate *Certificate
+	Err         error
+}
+
+// RequestBatch requests one certificate per spec, each with base overridden by the
+// spec's fields, continuing past failures. Results are in spec order.
+func RequestBatch(ctx context.Context, base RequestOptions, specs []BatchSpec, opts BatchOptions) []BatchResult {
+	if opts.Parallel < 1 {
+		opts.Parallel = 1
+	}
+	results := make([]BatchResult, len(specs))
+	if opts.Parallel > 1 && len(specs) > 1 {
+		if err := prepareParallel(ctx, base, specs); err != nil {
+			for i, spec := range specs {
+				results[i] = BatchResult{Domains: spec.Domains, Err: err}
+			}
+			return results
+		}
+	}
+	sem := make(chan struct{}, opts.Parallel)
+	var wg sync.WaitGroup
+	for i, spec := range specs {
+		results[i].Domains = spec.Domains
+		if err := ctx.Err(); err != nil {
+			results[i].Err = err
+			continue
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(r *BatchResult, spec BatchSpec) {
+			defer func() { <-sem; wg.Done() }()
+			rctx, cancel := ctx, context.CancelFunc(func() {})
+			if opts.Timeout > 0 {
+				rctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+			}
+			defer cancel()
+			cert, err := Request(rctx, spec.apply(base))
+			r.Certificate = cert
+			switch {
+			case errors.Is(err, ErrDuplicate):
+			case err != nil:
+				r.Certificate, r.Err = nil, err
+			default:
+				r.Issued = true
+			}
+		}(&results[i], spec)
+	}
+	wg.Wait()
+	return results
+}
+
+// prepareParallel checks that specs can be requested at the same time and sets up
+// the ACME accounts they share beforehand, so the workers all load the same account
+// instead of each registering one.
+func prepareParallel(ctx context.Context, base RequestOptions, specs []BatchSpec) error {
+	listeners := map[string][]string{}
+	for _, spec := range specs {
+		o := spec.apply(base)
+		if validationType(o.Validation) == "http" && o.HTTPAddr != "" && len(o.Domains) > 0 {
+			listeners[o.HTTPAddr] = append(listeners[o.HTTPAddr], o.Domains[0])
+		}
+	}
+	for addr, names := range listeners {
+		if len(names) > 1 {
+			return fmt.Errorf("%s share the standalone HTTP address %s, which only one request can listen on at a time; use --parallel 1",
+				strings.Join(names, ", "), addr)
+		}
+	}
+
+	if err := account.ValidateName(base.Account); err != nil {
+		return err
+	}
+	if base.Account == "" {
+		base.Account = account.DefaultName
+	}
+	if base.Email == "" && len(specs[0].Domains) > 0 {
+		base.Email = "admin@" + specs[0].Domains[0]
+	}
+	candidates, err := caCandidates(append([]string{base.CA}, base.FallbackCAs...), base.ServerURL, base.DirectoryURL, base.Staging)
+	if err != nil {
+		return err
+	}
+	for i, cand := range candidates {
+		o := base
+		o.CA = cand.CA
+		if i > 0 {
+			o.HMACID, o.HMACKey = "", ""
+		}
+		if _, err := setupAccount(ctx, o, account.CANameFor(o.CA, o.ServerURL, cand.Directory), cand.Directory); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply returns base with the fields set in s.
+func (s BatchSpec) apply(base RequestOptions) RequestOptions {
+	o := base
+	o.Domains = s.Domains
+	for dst, v := range map[*string]string{&o.Validation: s.Validation, &o.DNSProvider: s.DNSProvider, &o.KeyType: s.KeyType} {
+		if v != "" {
+			*dst = v
+		}
+	}
+	if s.Webroot != "" || s.HTTPAddr != "" {
+		o.Webroot, o.HTTPAddr = s.Webroot, s.HTTPAddr
+	}
+	switch installer := strings.ToLower(s.Installer); installer {
+	case "":
+	case InstallerNone:
+		o.NoInstall = true
+	case InstallerAuto:
+		o.NoInstall, o.Server = false, ""
+	default:
+		o.NoInstall, o.Server = false, installer
+	}
+	if len(s.Labels) > 0 {
+		labels := make(map[string]string, len(base.Labels)+len(s.Labels))
+		for k, v := range base.Labels {
+			labels[k] = v
+		}
+		for k, v := range s.Labels {
+			labels[k] = v
+		}
+		o.Labels = labels
+	}
+	return o
+}
@@ -0,0 +1,89 @@
+package trustctl
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/trustctl/trustctl/internal/install"
+	"github.com/trustctl/trustctl/internal/metadata"
+	"github.com/trustctl/trustctl/internal/paths"
+	"github.com/trustctl/trustctl/internal/ui"
+)
+
+// DeleteOptions adjust Delete. The zero value removes the certificate directory and
+// leaves web server configuration alone.
+type DeleteOptions struct {
+	// Archive moves the certificate directory to <base>/archive/<domain>-<UTC time>
+	// instead of removing it.
+	Archive bool
+	// Uninstall restores the nginx/Apache files that reference the certificate from
+	// the backups trustctl took before installing it, and reloads the server.
+	Uninstall bool
+}
+
+// Delete stops managing the certificate whose primary domain is domain: its metadata
+// is removed so renewals no longer touch it, and its directory is removed or archived.
+// It does not revoke the certificate.
+func Delete(domain string, opts DeleteOptions) error {
+	domainLock, err := metadata.LockDomain(domain)
+	if err != nil {
+		return err
+	}
+	defer domainLock.Release()
+
+	meta, err := metadata.Load(domain)
+	if err != nil {
+		return fmt.Errorf("failed to load metadata for %s: %w", domain, err)
+	}
+	dir := paths.CertDir(domain)
+
+	if opts.Uninstall {
+		ui.StepStart("Reverting web server configuration for %s", domain)
+		serverLock, err := metadata.LockServerConfig()
+		if err != nil {
+			return err
+		}
+		restored, unresolved, err := install.Uninstall(dir+string(filepath.Separator), meta.CertPath, meta.KeyPath, meta.CombinedPath)
+		serverLock.Release()
+		if err != nil {
+			return fmt.Errorf("failed to revert web server configuration: %w", err)
+		}
+		for _, f := range restored {
+			ui.StepDone("Restored %s from its backup", f)
+		}
+		if len(unresolved) > 0 {
+			for _, f := range unresolved {
+				ui.Error("%s references the certificate and has no backup from before it was installed", f)
+			}
+			return fmt.Errorf("%d configuration file(s) still reference the certificate; edit them and delete again", len(unresolved))
+		}
+	} else if files := install.FilesReferencing(dir+string(filepath.Separator), meta.CertPath, meta.KeyPath, meta.CombinedPath); len(files) > 0 {
+		for _, f := range files {
+			ui.Warning("%s still references the certificate", f)
+		}
+	}
+
+	if err := metadata.Delete(domain); err != nil {
+		return fmt.Errorf("failed to remove metadata for %s: %w", domain, err)
+	}
+	if opts.Archive {
+		archive := filepath.Join(paths.Base(), "archive")
+		if err := os.MkdirAll(archive, 0700); err != nil {
+			return err
+		}
+		dst := filepath.Join(archive, fmt.Sprintf("%s-%s", domain, time.Now().UTC().Format("20060102T150405Z")))
+		if err := os.Rename(dir, dst); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("metadata removed but %s could not be archived: %w", dir, err)
+		}
+		ui.Success("Stopped managing %s; files archived in %s", domain, dst)
+		return nil
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("metadata removed but %s could not be deleted: %w", dir, err)
+	}
+	ui.Success("Stopped managing %s and deleted %s", domain, dir)
+	return nil
+}
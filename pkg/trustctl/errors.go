@@ -0,0 +1,40 @@
+package trustctl
+
+import (
+	"context"
+	"errors"
+
+	"github.com/trustctl/trustctl/internal/ratelimit"
+)
+
+// Failure classes wrapped by the errors of Request, Renew and Install, so callers can
+// branch on what went wrong with errors.Is. A CA rate limit is recognized with
+// IsRateLimited instead.
+var (
+	ErrValidation = errors.New("validation failed")   // a challenge could not be published or the CA rejected it
+	ErrCA         = errors.New("CA error")            // account, order or issuance failed at the CA
+	ErrInstall    = errors.New("installation failed") // the certificate was issued but not deployed
+)
+
+// classError adds a failure class to an error without changing its message.
+type classError struct {
+	class error
+	err   error
+}
+
+func (e *classError) Error() string   { return e.err.Error() }
+func (e *classError) Unwrap() []error { return []error{e.class, e.err} }
+
+// classify marks err as belonging to class. Cancellation is left unclassified.
+func classify(class, err error) error {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return &classError{class: class, err: err}
+}
+
+// IsRateLimited reports whether err is, or was caused by, a CA rate limit.
+func IsRateLimited(err error) bool {
+	var rl *ratelimit.Error
+	return errors.Is(err, ErrRateLimited) || errors.As(err, &rl)
+}
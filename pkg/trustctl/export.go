@@ -0,0 +1,94 @@
+package trustctl
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/trustctl/trustctl/internal/bundle"
+	"github.com/trustctl/trustctl/internal/certinfo"
+	"github.com/trustctl/trustctl/internal/keygen"
+	"github.com/trustctl/trustctl/internal/metadata"
+	"github.com/trustctl/trustctl/internal/ui"
+)
+
+// ExportOptions select what Export writes and how.
+type ExportOptions struct {
+	Format  string // pem (default), der, pkcs12 or p7b
+	Content string // cert, chain, fullchain or fullchain+key; empty picks the format's usual content
+	Out     string // destination file, - for stdout
+	// Password protects PKCS#12 files; it is required for that format.
+	Password []byte
+	// Alias names the key entry of a PKCS#12 file (default: the domain).
+	Alias string
+}
+
+// Export writes the managed certificate for domain, its chain and optionally its
+// private key to opts.Out in opts.Format, leaf first and intermediates in issuing
+// order, for appliances and Java applications that cannot read trustctl's files.
+func Export(domain string, opts ExportOptions) error {
+	if opts.Out == "" {
+		return errors.New("an output file is required")
+	}
+	if opts.Format == "" {
+		opts.Format = bundle.FormatPEM
+	}
+	if opts.Content == "" {
+		opts.Content = bundle.DefaultContent(opts.Format)
+	}
+	if opts.Alias == "" {
+		opts.Alias = domain
+	}
+
+	meta, err := metadata.Load(domain)
+	if err != nil {
+		return fmt.Errorf("failed to load metadata for %s: %w", domain, err)
+	}
+	info, err := certinfo.ParseFile(meta.CertPath)
+	if err != nil {
+		return fmt.Errorf("%s: %w", meta.CertPath, err)
+	}
+	certs, err := bundle.Select(opts.Content, info.Leaf, info.Chain)
+	if err != nil {
+		return err
+	}
+	var key crypto.Signer
+	if opts.Content == bundle.ContentFullChainKey {
+		switch {
+		case meta.ExternalCSR:
+			return fmt.Errorf("%s was issued for a supplied CSR; trustctl does not hold its private key", domain)
+		case meta.KeyURI != "":
+			return fmt.Errorf("the private key of %s is held in hardware and cannot be exported", domain)
+		}
+		if key, err = keygen.LoadPrivateKey(meta.KeyPath); err != nil {
+			return fmt.Errorf("failed to load private key: %w", err)
+		}
+	}
+	data, err := bundle.Export(opts.Format, certs, key, opts.Password, opts.Alias)
+	if err != nil {
+		return err
+	}
+
+	if opts.Out == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	mode := os.FileMode(0644)
+	if key != nil {
+		mode = 0600
+	}
+	if err := os.MkdirAll(filepath.Dir(opts.Out), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(opts.Out, data, mode); err != nil {
+		return err
+	}
+	// WriteFile keeps the mode of an existing file; never leave a key world-readable
+	if err := os.Chmod(opts.Out, mode); err != nil {
+		return err
+	}
+	ui.Success("Exported %s of %s as %s to %s", opts.Content, domain, opts.Format, opts.Out)
+	return nil
+}
@@ -0,0 +1,193 @@
+package trustctl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/trustctl/trustctl/internal/account"
+	"github.com/trustctl/trustctl/internal/acme"
+	"github.com/trustctl/trustctl/internal/ca"
+	"github.com/trustctl/trustctl/internal/creds"
+	"github.com/trustctl/trustctl/internal/dns"
+	"github.com/trustctl/trustctl/internal/paths"
+	"github.com/trustctl/trustctl/internal/ui"
+	"github.com/trustctl/trustctl/internal/validation"
+)
+
+// caCandidate is a CA issuance is attempted at.
+type caCandidate struct {
+	CA        string // ACME CA name; empty for an enterprise CA
+	Directory string // ACME directory; empty for an enterprise CA
+}
+
+// caCandidates returns the CAs to try in order: the ACME CAs in cas (letsencrypt for
+// an empty first entry) at their production or staging directories, or the single
+// enterprise CA at serverURL. A custom directoryURL names one server, so it cannot be
+// combined with fallbacks.
+func caCandidates(cas []string, serverURL, directoryURL string, staging bool) ([]caCandidate, error) {
+	if serverURL != "" {
+		if len(cas) > 1 {
+			return nil, errors.New("fallback CAs cannot be combined with an enterprise CA server URL")
+		}
+		if _, err := resolveDirectory("", serverURL, directoryURL, staging); err != nil {
+			return nil, err
+		}
+		return []caCandidate{{}}, nil
+	}
+	if directoryURL != "" && len(cas) > 1 {
+		return nil, errors.New("fallback CAs cannot be combined with a custom ACME directory")
+	}
+	var out []caCandidate
+	seen := map[string]bool{}
+	for i, name := range cas {
+		if name == "" && i == 0 {
+			name = "letsencrypt"
+		}
+		if _, ok := acme.KnownDirectories[name]; !ok {
+			return nil, fmt.Errorf("unknown CA %q (known: %s)", name, strings.Join(ca.ACMENames(), ", "))
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		dir, err := resolveDirectory(name, "", directoryURL, staging)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, caCandidate{CA: name, Directory: dir})
+	}
+	return out, nil
+}
+
+// caOrder returns the CA names to keep in metadata for renewals, nil without fallbacks.
+func caOrder(candidates []caCandidate) []string {
+	if len(candidates) < 2 {
+		return nil
+	}
+	names := make([]string, len(candidates))
+	for i, c := range candidates {
+		names[i] = c.CA
+	}
+	return names
+}
+
+// issueFrom sets up the account at opts.CA (or the enterprise CA), validates the
+// names and requests the certificate for csr there.
+func issueFrom(ctx context.Context, opts RequestOptions, directory, vtype, webroot string, dnsProvider dns.DNSProvider, csr []byte) (*ca.CertificateMeta, error) {
+	domains := opts.Domains
+	caName := account.CANameFor(opts.CA, opts.ServerURL, directory)
+
+	acc, err := setupAccount(ctx, opts, caName, directory)
+	if err != nil {
+		return nil, err
+	}
+
+	ui.Info("Checking credential permissions...")
+	if err := creds.AssertPermissions(paths.Credentials()); err != nil {
+		ui.Error("credentials permission check failed: %v", err)
+		return nil, fmt.Errorf("credentials permission check failed: %w", err)
+	}
+
+	// Resolve CA
+	ui.StepStart("Resolving Certificate Authority...")
+	resolver := ca.NewResolver(paths.Credentials())
+	caClient, err := resolver.Resolve(opts.CA, directory, opts.ServerURL, opts.HMACID, opts.HMACKey)
+	if err != nil {
+		ui.Error("CA resolution failed: %v", err)
+		return nil, classify(ErrCA, fmt.Errorf("CA resolution failed: %w", err))
+	}
+	if opts.ServerURL == "" {
+		ui.Info("Using %s (ACME v2, %s)", ca.IssuerName(opts.CA, ""), directory)
+	} else {
+		ui.Info("Using enterprise CA: %s", opts.ServerURL)
+	}
+	ui.StepDone("CA resolved")
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	order, err := authorize(ctx, acc, domains, acmeMethod(vtype, &opts.Manual), opts.Profile, ca.IssuerName(opts.CA, opts.ServerURL))
+	if err != nil {
+		ui.Error("%v", err)
+		return nil, classify(ErrCA, err)
+	}
+
+	// Run validation; the responses stay published until the CA has checked them
+	ui.StepStart("🔐 Validating domains via %s...", strings.ToUpper(vtype))
+	validator := validation.NewValidator(vtype, dnsProvider).WithWebroot(webroot).WithHTTPAddr(opts.HTTPAddr).WithChallenges(order.challenges()).WithManual(opts.Manual)
+	defer validator.CleanUp()
+	if dcv, ok := caClient.(validation.EmailDCV); ok {
+		validator.WithEmailDCV(dcv, opts.ApproverEmail)
+	}
+	switch {
+	case vtype == "http" && opts.HTTPAddr != "":
+		ui.Info("Answering http-01 on %s", opts.HTTPAddr)
+	case vtype == "http" && webroot != "":
+		ui.Info("Using webroot: %s", webroot)
+	}
+	if err := validator.Validate(ctx, domains); err != nil {
+		ui.Error("validation failed: %v", err)
+		return nil, classify(ErrValidation, fmt.Errorf("validation failed: %w", err))
+	}
+	ui.Success("✅ Validation successful for: %s", strings.Join(domains, ", "))
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Request certificate from CA
+	ui.StepStart("📝 Requesting certificate from CA...")
+	var certMeta *ca.CertificateMeta
+	if order != nil {
+		certMeta, err = order.complete(ctx, domains, csr)
+	} else {
+		certMeta, err = requestCertificate(ctx, caClient, domains, csr)
+	}
+	if err != nil {
+		ui.Error("certificate request failed: %v", err)
+		return nil, classify(ErrCA, fmt.Errorf("certificate request failed: %w", err))
+	}
+	ui.Success("📜 Certificate issued by %s", certMeta.Issuer)
+	return certMeta, nil
+}
+
+// setupAccount loads the opts.Account account at caName, registering and storing it
+// at directory first when it does not exist yet.
+func setupAccount(ctx context.Context, opts RequestOptions, caName, directory string) (acc *account.AccountInfo, err error) {
+	ui.StepStart("Checking %s account (%s)...", caName, opts.Account)
+	if account.Exists(caName, opts.Account) {
+		ui.Info("Account found for %s (%s)", caName, opts.Account)
+		acc, err = account.Load(caName, opts.Account)
+		if err != nil {
+			ui.Error("failed to load account: %v", err)
+			return nil, err
+		}
+	} else {
+		ui.StepStart("Creating new %s account (%s)...", caName, opts.Account)
+		createOpts := account.CreateOptions{DirectoryURL: directory, AgreeTOS: opts.AgreeTOS}
+		if opts.ServerURL == "" && opts.HMACID != "" {
+			if createOpts.EAB, err = acme.NewExternalAccountBinding(opts.HMACID, opts.HMACKey); err != nil {
+				ui.Error("%v", err)
+				return nil, err
+			}
+		}
+		acc, err = account.Create(ctx, caName, opts.Account, opts.Email, createOpts)
+		if err != nil {
+			ui.Error("failed to create account: %v", err)
+			return nil, classify(ErrCA, err)
+		}
+		if err := acc.Store(); err != nil {
+			ui.Error("failed to store account: %v", err)
+			return nil, err
+		}
+		if acc.AccountURL != "" {
+			ui.Success("Account registered and stored: %s", acc.AccountURL)
+		} else {
+			ui.Success("Account stored for %s", caName)
+		}
+	}
+	return acc, nil
+}
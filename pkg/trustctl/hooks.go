@@ -0,0 +1,65 @@
+package trustctl
+
+import (
+	"strings"
+
+	"github.com/trustctl/trustctl/internal/hooks"
+	"github.com/trustctl/trustctl/internal/metadata"
+	"github.com/trustctl/trustctl/internal/paths"
+)
+
+// runHooks runs meta's hooks for stage: the executables in the shared hook directory,
+// then the certificate's own command.
+func runHooks(stage hooks.Stage, meta *metadata.CertMetadata) error {
+	command := ""
+	if h := meta.Hooks; h != nil {
+		switch stage {
+		case hooks.Pre:
+			command = h.Pre
+		case hooks.Post:
+			command = h.Post
+		case hooks.Deploy:
+			command = h.Deploy
+		}
+	}
+	return hooks.Run(paths.Hooks(), stage, command, hookEnv(meta))
+}
+
+// hookEnv describes meta's certificate to hooks. RENEWED_LINEAGE and RENEWED_DOMAINS
+// let certbot deploy hooks run unchanged.
+func hookEnv(meta *metadata.CertMetadata) []string {
+	dir := paths.CertDir(meta.Domains[0])
+	domains := strings.Join(meta.Domains, " ")
+	return []string{
+		"TRUSTCTL_PRIMARY_DOMAIN=" + meta.Domains[0],
+		"TRUSTCTL_DOMAINS=" + domains,
+		"TRUSTCTL_CERT_DIR=" + dir,
+		"TRUSTCTL_CERT_PATH=" + meta.CertPath,
+		"TRUSTCTL_CHAIN_PATH=" + meta.ChainPath,
+		"TRUSTCTL_KEY_PATH=" + meta.KeyPath,
+		"TRUSTCTL_COMBINED_PATH=" + meta.CombinedPath,
+		"RENEWED_LINEAGE=" + dir,
+		"RENEWED_DOMAINS=" + domains,
+	}
+}
+
+// setHooks applies the hook commands given in opts to meta and reports whether any
+// changed. An empty command removes that hook.
+func setHooks(meta *metadata.CertMetadata, opts RenewOptions) bool {
+	if opts.PreHook == nil && opts.PostHook == nil && opts.DeployHook == nil {
+		return false
+	}
+	h := Hooks{}
+	if meta.Hooks != nil {
+		h = *meta.Hooks
+	}
+	for dst, src := range map[*string]*string{&h.Pre: opts.PreHook, &h.Post: opts.PostHook, &h.Deploy: opts.DeployHook} {
+		if src != nil {
+			*dst = *src
+		}
+	}
+	if meta.Hooks = &h; h == (Hooks{}) {
+		meta.Hooks = nil
+	}
+	return true
+}
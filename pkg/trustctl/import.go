@@ -0,0 +1,420 @@
+package trustctl
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/trustctl/trustctl/internal/account"
+	"github.com/trustctl/trustctl/internal/bundle"
+	"github.com/trustctl/trustctl/internal/certinfo"
+	"github.com/trustctl/trustctl/internal/cryptopolicy"
+	"github.com/trustctl/trustctl/internal/hooks"
+	"github.com/trustctl/trustctl/internal/importer"
+	"github.com/trustctl/trustctl/internal/keygen"
+	"github.com/trustctl/trustctl/internal/metadata"
+	"github.com/trustctl/trustctl/internal/paths"
+	"github.com/trustctl/trustctl/internal/ui"
+	"github.com/trustctl/trustctl/internal/validation"
+)
+
+// Clients Import takes certificates over from.
+const (
+	ImportCertbot = "certbot"
+	ImportAcmeSh  = "acme.sh"
+)
+
+// ImportOptions select the certificates and accounts Import takes over.
+type ImportOptions struct {
+	Source  string   // ImportCertbot or ImportAcmeSh
+	Dir     string   // the client's configuration directory; empty uses its default
+	Names   []string // certbot lineages or acme.sh main domains to import; empty imports all
+	Account string   // account profile to store imported ACME accounts under
+	// Symlink links the certificate files instead of copying them. The links are
+	// replaced with trustctl's own files on the first renewal.
+	Symlink bool
+	DryRun  bool // only report what would be imported
+}
+
+// ImportResult lists what Import took over.
+type ImportResult struct {
+	Imported []*metadata.CertMetadata
+	Skipped  []string // names already managed by trustctl
+	Accounts []string // account profiles stored, as <ca>/<name>
+}
+
+// Import takes over the certificates of certbot or acme.sh: it copies (or links) the
+// files into the trustctl certificate store, converts their renewal settings into
+// metadata and stores the client's ACME accounts, so trustctl renew picks them up.
+// Certificates trustctl already manages are left alone.
+func Import(opts ImportOptions) (*ImportResult, error) {
+	if opts.Account == "" {
+		opts.Account = account.DefaultName
+	}
+	if err := account.ValidateName(opts.Account); err != nil {
+		return nil, err
+	}
+	var (
+		certs    []*importer.Certificate
+		accounts []*importer.Account
+		err      error
+	)
+	switch opts.Source {
+	case ImportCertbot:
+		if opts.Dir == "" {
+			opts.Dir = importer.CertbotDir
+		}
+		certs, accounts, err = importer.Certbot(opts.Dir)
+	case ImportAcmeSh:
+		if opts.Dir == "" {
+			opts.Dir = importer.AcmeShDir()
+		}
+		certs, accounts, err = importer.AcmeSh(opts.Dir)
+	default:
+		return nil, fmt.Errorf("unknown client %q (expected %s or %s)", opts.Source, ImportCertbot, ImportAcmeSh)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(opts.Names) > 0 {
+		certs, err = selectImports(certs, opts.Names)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	res := &ImportResult{}
+	ui.StepStart("📥 Importing %d %s certificate(s) from %s", len(certs), opts.Source, opts.Dir)
+	for _, c := range certs {
+		meta, err := importCertificate(c, opts)
+		if errors.Is(err, errAlreadyManaged) {
+			ui.Info("%s is already managed by trustctl, skipping", c.Name)
+			res.Skipped = append(res.Skipped, c.Name)
+			continue
+		}
+		if err != nil {
+			return res, fmt.Errorf("%s: %w", c.Name, err)
+		}
+		for _, n := range c.Notes {
+			ui.Warning("%s: %s", c.Name, n)
+		}
+		res.Imported = append(res.Imported, meta)
+	}
+
+	// Accounts the imported certificates renew with go first, so they win when the
+	// client has several accounts at one CA
+	used := map[string]bool{}
+	for _, c := range certs {
+		used[c.AccountURL] = true
+	}
+	for _, first := range []bool{true, false} {
+		for _, a := range accounts {
+			if used[a.URL] != first {
+				continue
+			}
+			name, err := importAccount(a, opts)
+			if err != nil {
+				return res, err
+			}
+			if name != "" {
+				res.Accounts = append(res.Accounts, name)
+			}
+		}
+	}
+
+	for _, meta := range res.Imported {
+		caName := account.CANameFor(meta.CA, "", meta.DirectoryURL)
+		if !account.Exists(caName, opts.Account) && !opts.DryRun {
+			ui.Warning("%s: no %s account was imported; create one with trustctl account create before renewing", meta.Domains[0], caName)
+		}
+	}
+	if opts.Source == ImportCertbot {
+		if err := importCertbotHooks(opts.Dir, opts.DryRun); err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+var errAlreadyManaged = errors.New("already managed by trustctl")
+
+// selectImports keeps the certificates named in names, failing for unknown names.
+func selectImports(certs []*importer.Certificate, names []string) ([]*importer.Certificate, error) {
+	byName := map[string]*importer.Certificate{}
+	for _, c := range certs {
+		byName[c.Name] = c
+	}
+	var out []*importer.Certificate
+	for _, n := range names {
+		c, ok := byName[n]
+		if !ok {
+			return nil, fmt.Errorf("no certificate named %s", n)
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+func importCertificate(c *importer.Certificate, opts ImportOptions) (*metadata.CertMetadata, error) {
+	info, err := certinfo.ParseFile(c.FullChainPath)
+	if err != nil {
+		return nil, err
+	}
+	key, err := keygen.LoadPrivateKey(c.KeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load private key: %w", err)
+	}
+	if k, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool }); !ok || !k.Equal(info.Leaf.PublicKey) {
+		return nil, fmt.Errorf("%s does not match the certificate in %s", c.KeyPath, c.FullChainPath)
+	}
+
+	// The lineage name is the primary domain when the certificate covers it, which is
+	// how both clients name their directories
+	domains := canonicalNames(info.SANs)
+	primary := strings.ToLower(c.Name)
+	for i, d := range domains {
+		if d == primary {
+			domains = append(append([]string{d}, domains[:i]...), domains[i+1:]...)
+			break
+		}
+	}
+	if len(domains) == 0 {
+		return nil, fmt.Errorf("%s names no domains", c.FullChainPath)
+	}
+	if _, err := metadata.Load(domains[0]); err == nil {
+		return nil, errAlreadyManaged
+	}
+	if time.Now().After(info.NotAfter) {
+		ui.Warning("%s expired on %s; it is renewed on the next trustctl renew", domains[0], info.NotAfter.Format("2006-01-02"))
+	}
+
+	meta := &metadata.CertMetadata{
+		Domains:          domains,
+		ValidationMethod: c.Validation,
+		DNSProvider:      c.DNSProvider,
+		CA:               importer.CAName(c.DirectoryURL),
+		DirectoryURL:     c.DirectoryURL,
+		CredentialsPath:  paths.Credentials(),
+		KeyType:          keygen.KeyTypeOf(key),
+		KeyFormat:        keyFileFormat(c.KeyPath),
+		ReuseKey:         c.ReuseKey,
+		PreferredChain:   c.PreferredChain,
+		CryptoMode:       string(cryptopolicy.CurrentMode()),
+		Webroot:          c.Webroot,
+		Account:          opts.Account,
+		ImportedFrom:     c.Source,
+	}
+	if meta.CA == "" && meta.DirectoryURL == "" {
+		meta.CA = "letsencrypt"
+	}
+	if c.NoInstall {
+		meta.InstallerType = metadata.InstallerNone
+	}
+	if c.PreHook != "" || c.PostHook != "" || c.DeployHook != "" {
+		meta.Hooks = &Hooks{Pre: c.PreHook, Post: c.PostHook, Deploy: c.DeployHook}
+	}
+	if c.Validation == "manual" {
+		meta.Manual = &validation.Manual{Challenge: c.ManualType, AuthHook: c.ManualAuthHook, CleanupHook: c.ManualCleanup}
+	}
+	if fi, err := os.Stat(c.KeyPath); err == nil {
+		meta.KeyCreatedAt = fi.ModTime()
+	}
+	meta.SetCertDetails(info)
+
+	if opts.DryRun {
+		ui.Info("Would import %s (%s, %s validation, expires %s)", strings.Join(domains, ", "), c.Source, meta.ValidationMethod, info.NotAfter.Format("2006-01-02"))
+		return meta, nil
+	}
+
+	domainLock, err := metadata.LockDomain(domains[0])
+	if err != nil {
+		return nil, err
+	}
+	defer domainLock.Release()
+
+	certDir := paths.CertDir(domains[0])
+	if err := os.MkdirAll(certDir, 0700); err != nil {
+		return nil, err
+	}
+	meta.KeyPath = filepath.Join(certDir, "privkey.pem")
+	if opts.Symlink {
+		files := &bundle.Files{}
+		for name, target := range c.Files {
+			if _, err := os.Stat(target); err != nil {
+				continue // certbot writes no chain.pem for certificates without intermediates
+			}
+			link := filepath.Join(certDir, name)
+			os.Remove(link)
+			if err := os.Symlink(target, link); err != nil {
+				return nil, err
+			}
+			switch name {
+			case "cert.pem":
+				files.Cert = link
+			case "chain.pem":
+				files.Chain = link
+			case "fullchain.pem":
+				files.FullChain = link
+			}
+		}
+		meta.SetBundleFiles(files)
+	} else {
+		if err := copyFile(c.KeyPath, meta.KeyPath, 0600); err != nil {
+			return nil, fmt.Errorf("failed to copy private key: %w", err)
+		}
+		chain, err := os.ReadFile(c.FullChainPath)
+		if err != nil {
+			return nil, err
+		}
+		files, err := bundle.Write(certDir, chain, meta.KeyPath, meta.Bundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save certificate: %w", err)
+		}
+		if files.ChainErr != nil {
+			ui.Warning("%s: chain does not verify against the system roots: %v", domains[0], files.ChainErr)
+		}
+		meta.SetBundleFiles(files)
+	}
+
+	if err := meta.Store(); err != nil {
+		return nil, fmt.Errorf("failed to save metadata: %w", err)
+	}
+	if err := metadata.AppendEvent(domains[0], "imported", "from "+c.Source); err != nil {
+		ui.Warning("failed to record event: %v", err)
+	}
+	ui.Success("Imported %s (%s validation, expires %s)", strings.Join(domains, ", "), meta.ValidationMethod, info.NotAfter.Format("2006-01-02"))
+	return meta, nil
+}
+
+// importAccount stores a as the opts.Account profile at its CA and returns
+// "<ca>/<name>", or "" when trustctl already has an account there.
+func importAccount(a *importer.Account, opts ImportOptions) (string, error) {
+	caName := account.CANameFor(importer.CAName(a.DirectoryURL), "", a.DirectoryURL)
+	label := caName + "/" + opts.Account
+	if account.Exists(caName, opts.Account) {
+		if existing, err := account.Load(caName, opts.Account); err == nil && existing.AccountURL != a.URL {
+			ui.Warning("Keeping the existing %s account; %s is not imported", label, a.URL)
+		}
+		return "", nil
+	}
+	if opts.DryRun {
+		ui.Info("Would import account %s as %s", a.URL, label)
+		return label, nil
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(a.Key)
+	if err != nil {
+		return "", err
+	}
+	acc := &account.AccountInfo{
+		CA:           caName,
+		Name:         opts.Account,
+		Email:        a.Email,
+		DirectoryURL: a.DirectoryURL,
+		AccountURL:   a.URL,
+		AccountKey:   strings.TrimSuffix(account.File(caName, opts.Account), ".json") + "-key.pem",
+		CreatedAt:    time.Now(),
+	}
+	if acc.Name == account.DefaultName {
+		acc.Name = ""
+	}
+	if err := os.MkdirAll(paths.Credentials(), 0700); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(acc.AccountKey, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		return "", fmt.Errorf("failed to write account key: %w", err)
+	}
+	if err := acc.Store(); err != nil {
+		return "", err
+	}
+	ui.Success("Imported account %s as %s", a.URL, label)
+	return label, nil
+}
+
+// importCertbotHooks copies the executables in certbot's renewal-hooks directories
+// into trustctl's hook directories, keeping hooks that are already there.
+func importCertbotHooks(dir string, dryRun bool) error {
+	for _, stage := range []hooks.Stage{hooks.Pre, hooks.Post, hooks.Deploy} {
+		for _, src := range hooks.Executables(filepath.Join(dir, "renewal-hooks"), stage) {
+			dst := filepath.Join(paths.Hooks(), string(stage), filepath.Base(src))
+			if _, err := os.Stat(dst); err == nil {
+				continue
+			}
+			if dryRun {
+				ui.Info("Would copy %s hook %s", stage, src)
+				continue
+			}
+			if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+				return err
+			}
+			if err := copyFile(src, dst, 0755); err != nil {
+				return err
+			}
+			ui.Info("Copied %s hook %s to %s", stage, src, dst)
+		}
+	}
+	return nil
+}
+
+// detachImported replaces the files an import linked into dir with copies, so
+// writing the renewed certificate does not overwrite the other client's files.
+func detachImported(dir string) error {
+	for _, name := range []string{"cert.pem", "chain.pem", "fullchain.pem", "privkey.pem"} {
+		p := filepath.Join(dir, name)
+		if fi, err := os.Lstat(p); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		tmp := p + ".tmp"
+		if err := copyFile(p, tmp, 0600); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keyFileFormat returns the metadata key format of the PEM key at path, so renewals
+// write keys the way the other client did.
+func keyFileFormat(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return ""
+	}
+	switch block.Type {
+	case "PRIVATE KEY":
+		return keygen.PKCS8
+	case "ENCRYPTED PRIVATE KEY":
+		return keygen.EncryptedPKCS8
+	}
+	return keygen.PKCS1
+}
+
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
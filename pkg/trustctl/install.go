@@ -0,0 +1,174 @@
+package trustctl
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/trustctl/trustctl/internal/ca"
+	"github.com/trustctl/trustctl/internal/certinfo"
+	"github.com/trustctl/trustctl/internal/install"
+	"github.com/trustctl/trustctl/internal/keygen"
+	"github.com/trustctl/trustctl/internal/keystore"
+	"github.com/trustctl/trustctl/internal/metadata"
+	"github.com/trustctl/trustctl/internal/ui"
+)
+
+// InstallOptions describe a certificate obtained elsewhere for Install.
+type InstallOptions struct {
+	CertPath string   // PEM certificate followed by its chain
+	KeyPath  string   // PEM private key, or a pkcs11: or tpm: key URI
+	Domains  []string // vhosts to configure; empty means every name on the certificate
+	// VerifyAddr is the TLS endpoint probed for the certificate after the reload
+	// (default: the listen addresses of the configured vhosts, with 127.0.0.1 for a
+	// wildcard) and Timeout bounds the wait (default 30s).
+	VerifyAddr string
+	Timeout    time.Duration
+	// NoReload only edits the configuration and prints the reload command.
+	NoReload bool
+	// TLSProfile sets the TLS directives of 443 vhosts the installer adds: modern,
+	// intermediate or old (default: Config.TLSProfile).
+	TLSProfile string
+	// HTTP2 and HTTP3 enable those protocols in 443 vhosts the installer adds; HTTP/3
+	// needs nginx 1.25 or later.
+	HTTP2, HTTP3 bool
+	// HSTS, when positive, also sets the Strict-Transport-Security header of the
+	// vhosts to this max-age.
+	HSTS time.Duration
+	// Server is nginx, apache, lighttpd, tomcat, haproxy, caddy, postfix, dovecot or
+	// mail (Postfix and Dovecot); empty detects the web server. Tomcat gets the
+	// certificate in the connector's keystore, HAProxy as a combined PEM file and Caddy
+	// through its admin API, and a managed certificate installed this way is put back
+	// there on renewal.
+	Server string
+}
+
+// Install points the nginx or Apache vhosts for opts.Domains at an existing
+// certificate and key, checks the configuration and reloads the server, restoring the
+// previous configuration if it does not end up serving the certificate. The
+// certificate is not registered for renewal.
+func Install(opts InstallOptions) error {
+	if opts.CertPath == "" || opts.KeyPath == "" {
+		return errors.New("a certificate and a private key are required")
+	}
+	certPath, err := filepath.Abs(opts.CertPath)
+	if err != nil {
+		return err
+	}
+	keyPath := opts.KeyPath
+	if !keystore.IsURI(keyPath) {
+		if keyPath, err = filepath.Abs(keyPath); err != nil {
+			return err
+		}
+	}
+
+	info, err := certinfo.ParseFile(certPath)
+	if err != nil {
+		return fmt.Errorf("%s: %w", certPath, err)
+	}
+	if time.Now().After(info.NotAfter) {
+		return fmt.Errorf("%s expired on %s", certPath, info.NotAfter.Format("2006-01-02"))
+	}
+	if len(info.Chain) == 0 {
+		ui.Warning("%s holds no intermediate certificates; clients may not be able to build the chain", certPath)
+	}
+	var key crypto.Signer
+	if keystore.IsURI(keyPath) {
+		key, err = keystore.Open(keyPath)
+	} else {
+		key, err = keygen.LoadPrivateKey(keyPath)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load private key: %w", err)
+	}
+	if k, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool }); !ok || !k.Equal(info.Leaf.PublicKey) {
+		return fmt.Errorf("%s does not match the certificate in %s", keystore.Public(keyPath), certPath)
+	}
+
+	domains := opts.Domains
+	if len(domains) == 0 {
+		domains = info.SANs
+	}
+	for _, d := range domains {
+		if err := info.Leaf.VerifyHostname(d); err != nil {
+			return fmt.Errorf("the certificate does not cover %s (it covers %s)", d, strings.Join(info.SANs, ", "))
+		}
+	}
+
+	ui.StepStart("🔗 Installing %s for %s", certPath, strings.Join(domains, ", "))
+	serverLock, err := metadata.LockServerConfig()
+	if err != nil {
+		return err
+	}
+	defer serverLock.Release()
+	err = install.Deploy(domains, certPath, keyPath, install.DeployOptions{VerifyAddr: opts.VerifyAddr, Timeout: opts.Timeout, NoReload: opts.NoReload,
+		TLSProfile: opts.TLSProfile, HSTS: opts.HSTS, HTTP2: opts.HTTP2, HTTP3: opts.HTTP3, Server: opts.Server})
+	if err != nil {
+		return classify(ErrInstall, err)
+	}
+	if opts.Server != "" {
+		rememberInstaller(certPath, opts.Server)
+	}
+	return nil
+}
+
+// installCertificate deploys a newly issued or renewed certificate into opts.Server,
+// or the web server Server detects when it is empty, under the server configuration
+// lock, and returns the server it configured. On a host without a web server the
+// install is skipped with a warning and the server is empty; enterprise certificates
+// are often deployed elsewhere, so they get the CA installer's message instead.
+func installCertificate(domains []string, certPath, keyPath string, opts install.DeployOptions, enterprise bool, certMeta *ca.CertificateMeta) (string, error) {
+	if opts.Server == "" {
+		srv, _, err := install.Server()
+		switch {
+		case errors.Is(err, install.ErrNoServer) && enterprise:
+			return "", ca.InstallCertificate(certMeta)
+		case errors.Is(err, install.ErrNoServer):
+			ui.Warning("Not installing the certificate: %v; configure your server to use %s and %s", err, certPath, keyPath)
+			return "", nil
+		case err != nil:
+			return "", err
+		}
+		opts.Server = srv
+	}
+	serverLock, err := metadata.LockServerConfig()
+	if err != nil {
+		return "", err
+	}
+	defer serverLock.Release()
+	if err := install.Deploy(domains, certPath, keyPath, opts); err != nil {
+		return "", err
+	}
+	return opts.Server, nil
+}
+
+// knownServer reports whether name is one of install.Servers().
+func knownServer(name string) bool {
+	for _, s := range install.Servers() {
+		if name == s {
+			return true
+		}
+	}
+	return false
+}
+
+// rememberInstaller records server as the installer of the managed certificate stored
+// at certPath, if any, so renewals install it the same way.
+func rememberInstaller(certPath, server string) {
+	metas, err := metadata.LoadMatching(nil)
+	if err != nil {
+		return
+	}
+	for _, m := range metas {
+		if m.CertPath != certPath || m.InstallerType == server {
+			continue
+		}
+		m.InstallerType = server
+		if err := m.Store(); err != nil {
+			ui.Warning("failed to record the installer of %s: %v", m.Domains[0], err)
+		}
+	}
+}
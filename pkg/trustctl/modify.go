@@ -0,0 +1,97 @@
+package trustctl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/trustctl/trustctl/internal/metadata"
+	"github.com/trustctl/trustctl/internal/ui"
+	"github.com/trustctl/trustctl/internal/validation"
+)
+
+// Modify changes the names on the certificate whose primary domain is domain and
+// reissues and reinstalls it with its stored validation, CA and key settings. The
+// stored metadata only changes once the new certificate has been issued.
+func Modify(ctx context.Context, domain string, add, remove []string) (*Certificate, error) {
+	domainLock, err := metadata.LockDomain(domain)
+	if err != nil {
+		return nil, err
+	}
+	defer domainLock.Release()
+
+	meta, err := metadata.Load(domain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load metadata for %s: %w", domain, err)
+	}
+	names, err := modifyNames(meta.Domains, add, remove)
+	if err != nil {
+		return nil, err
+	}
+	if err := validation.CheckWildcards(names, acmeMethod(validationType(meta.ValidationMethod), meta.Manual)); err != nil {
+		return nil, err
+	}
+	ui.StepStart("Modifying certificate for %s", domain)
+	ui.Info("Domains: %s -> %s", strings.Join(meta.Domains, ","), strings.Join(names, ","))
+	previous := strings.Join(meta.Domains, ",")
+	meta.Domains = names
+
+	rec := metadata.HistoryRecord{At: time.Now(), Outcome: metadata.OutcomeSuccess}
+	err = reissue(ctx, meta, &rec)
+	rec.DurationMS = time.Since(rec.At).Milliseconds()
+	if err != nil {
+		rec.Outcome = metadata.OutcomeFailure
+		rec.Error = err.Error()
+	}
+	if herr := metadata.AppendHistory(domain, rec); herr != nil {
+		ui.Warning("failed to record renewal history: %v", herr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := metadata.AppendEvent(domain, "modified", previous+" -> "+strings.Join(names, ",")); err != nil {
+		ui.Warning("failed to record event: %v", err)
+	}
+	ui.Success("Certificate for %s now covers %s", domain, strings.Join(names, ", "))
+	return meta, nil
+}
+
+// modifyNames applies additions and removals to a certificate's name list. The
+// primary domain keys the certificate's storage and cannot be removed.
+func modifyNames(current, add, remove []string) ([]string, error) {
+	drop := map[string]bool{}
+	for _, d := range remove {
+		d = strings.ToLower(strings.TrimSpace(d))
+		if d == strings.ToLower(current[0]) {
+			return nil, fmt.Errorf("%s is the primary domain and cannot be removed; request a new certificate instead", current[0])
+		}
+		drop[d] = true
+	}
+	var names []string
+	seen := map[string]bool{}
+	for _, d := range current {
+		if drop[strings.ToLower(d)] {
+			delete(drop, strings.ToLower(d))
+			continue
+		}
+		seen[strings.ToLower(d)] = true
+		names = append(names, d)
+	}
+	for d := range drop {
+		return nil, fmt.Errorf("%s is not on the certificate", d)
+	}
+	for _, d := range add {
+		d = strings.TrimSpace(d)
+		if d == "" || seen[strings.ToLower(d)] {
+			continue
+		}
+		seen[strings.ToLower(d)] = true
+		names = append(names, d)
+	}
+	if sanKey(names) == sanKey(current) {
+		return nil, errors.New("the certificate already covers exactly these names")
+	}
+	return names, nil
+}
@@ -0,0 +1,604 @@
+package trustctl
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/trustctl/trustctl/internal/account"
+	"github.com/trustctl/trustctl/internal/acme"
+	"github.com/trustctl/trustctl/internal/bundle"
+	"github.com/trustctl/trustctl/internal/ca"
+	"github.com/trustctl/trustctl/internal/certinfo"
+	"github.com/trustctl/trustctl/internal/creds"
+	"github.com/trustctl/trustctl/internal/cryptopolicy"
+	"github.com/trustctl/trustctl/internal/dns"
+	"github.com/trustctl/trustctl/internal/hooks"
+	"github.com/trustctl/trustctl/internal/install"
+	"github.com/trustctl/trustctl/internal/keycheck"
+	"github.com/trustctl/trustctl/internal/keygen"
+	"github.com/trustctl/trustctl/internal/keystore"
+	"github.com/trustctl/trustctl/internal/metadata"
+	"github.com/trustctl/trustctl/internal/paths"
+	"github.com/trustctl/trustctl/internal/truststore"
+	"github.com/trustctl/trustctl/internal/ui"
+	"github.com/trustctl/trustctl/internal/validation"
+)
+
+// RenewOptions adjust a renewal. The zero value renews with the stored settings.
+type RenewOptions struct {
+	// DirectoryURL or Staging switch the ACME directory the certificate is renewed
+	// from; the choice is kept in metadata for later renewals.
+	DirectoryURL string
+	Staging      bool
+	// ReuseKey or NewKey switch whether renewals keep the existing private key
+	// instead of generating a new one; the choice is kept in metadata.
+	ReuseKey bool
+	NewKey   bool
+	// KeyRotation, when set, replaces the stored key rotation policy; a zero policy
+	// removes it.
+	KeyRotation *KeyRotation
+	// RenewDaysBeforeExpiry, when set, replaces how many days before expiry the
+	// certificate is renewed; zero goes back to the default. Kept in metadata.
+	RenewDaysBeforeExpiry *int
+	// PreHook, PostHook and DeployHook, when set, replace the certificate's hook
+	// command for that stage; an empty command removes it. Kept in metadata.
+	PreHook    *string
+	PostHook   *string
+	DeployHook *string
+	// Force renews even when the certificate is not yet due. Rate limits recorded
+	// from the CA are still honored.
+	Force bool
+}
+
+// Renew renews the certificate whose primary domain is domain using its stored
+// metadata, and records the attempt in its renewal history. A certificate that is not
+// due yet is left alone and ErrNotDue is returned without a record; so is one that is
+// still rate limited by the CA (ErrRateLimited), was revoked (ErrRevoked) or is being
+// worked on by another trustctl instance (ErrLocked).
+func Renew(ctx context.Context, domain string, opts RenewOptions) (*HistoryRecord, error) {
+	rec := metadata.HistoryRecord{At: time.Now(), Outcome: metadata.OutcomeSuccess}
+	err := renewDomain(ctx, domain, opts, &rec)
+	if skipped(err) {
+		return nil, err
+	}
+	rec.DurationMS = time.Since(rec.At).Milliseconds()
+	if err != nil {
+		rec.Outcome = metadata.OutcomeFailure
+		rec.Error = err.Error()
+	}
+	if herr := metadata.AppendHistory(domain, rec); herr != nil {
+		ui.Warning("failed to record renewal history: %v", herr)
+	}
+	return &rec, err
+}
+
+// RenewAll renews every certificate matching selector (nil for all) that is due,
+// continuing past failures. It returns one history record per certificate attempted
+// and stops early only when ctx is done.
+func RenewAll(ctx context.Context, selector map[string]string, opts RenewOptions) ([]*HistoryRecord, error) {
+	certs, err := metadata.LoadMatching(selector)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list certificates: %w", err)
+	}
+	var recs []*HistoryRecord
+	for _, m := range certs {
+		if err := ctx.Err(); err != nil {
+			return recs, err
+		}
+		domain := m.Domains[0]
+		rec, err := Renew(ctx, domain, opts)
+		if skipped(err) {
+			continue
+		}
+		recs = append(recs, rec)
+		if err != nil {
+			ui.Error("renewal failed for %s: %v", domain, err)
+		}
+	}
+	return recs, nil
+}
+
+// renewDomain renews one certificate, filling in the CA response summary of rec.
+func renewDomain(ctx context.Context, domain string, opts RenewOptions, rec *metadata.HistoryRecord) error {
+	ui.StepStart("Renewing certificate for %s", domain)
+
+	domainLock, err := metadata.LockDomain(domain)
+	if err != nil {
+		return err
+	}
+	defer domainLock.Release()
+
+	// Load metadata
+	meta, err := metadata.Load(domain)
+	if err != nil {
+		return fmt.Errorf("failed to load metadata for %s: %w", domain, err)
+	}
+	if !meta.RevokedAt.IsZero() {
+		ui.Info("Not renewing %s: revoked on %s; request a new certificate to replace it", domain, meta.RevokedAt.Format("2006-01-02"))
+		return ErrRevoked
+	}
+	// Choices made on the command line are kept even when the certificate is not due
+	changed := false
+	if opts.DirectoryURL != "" || opts.Staging {
+		changed = true
+		if meta.DirectoryURL, err = resolveDirectory(meta.CA, meta.ServerURL, opts.DirectoryURL, opts.Staging); err != nil {
+			return err
+		}
+		ui.Info("Renewing from ACME directory %s", meta.DirectoryURL)
+	}
+	switch {
+	case opts.ReuseKey && opts.NewKey:
+		return errors.New("reusing the key and generating a new one are mutually exclusive")
+	case opts.ReuseKey, opts.NewKey:
+		meta.ReuseKey = opts.ReuseKey
+		changed = true
+	}
+	if opts.KeyRotation != nil {
+		if err := checkKeyRotation(opts.KeyRotation); err != nil {
+			return err
+		}
+		if meta.KeyRotation = opts.KeyRotation; *opts.KeyRotation == (KeyRotation{}) {
+			meta.KeyRotation = nil
+		}
+		changed = true
+	}
+
+	if setHooks(meta, opts) {
+		changed = true
+	}
+	if opts.RenewDaysBeforeExpiry != nil {
+		if *opts.RenewDaysBeforeExpiry < 0 {
+			return errors.New("days before expiry must not be negative")
+		}
+		meta.RenewBeforeDays = *opts.RenewDaysBeforeExpiry
+		changed = true
+	}
+	if changed {
+		if err := meta.Store(); err != nil {
+			return err
+		}
+	}
+
+	// Renew only inside the window suggested by the CA (ARI) or near expiry, and not
+	// while an earlier attempt is rate limited
+	now := time.Now()
+	if now.Before(meta.RetryAfter) {
+		ui.Info("Not renewing %s: rate limited by the CA until %s", domain, meta.RetryAfter.Format(time.RFC3339))
+		return ErrRateLimited
+	}
+	if refreshRenewalInfo(ctx, meta, now) {
+		if err := meta.Store(); err != nil {
+			ui.Warning("failed to store renewal information: %v", err)
+		}
+	}
+	due, reason := renewalDue(meta, now)
+	switch {
+	case !due && opts.Force:
+		ui.Info("Renewing %s although not due (forced): %s", domain, reason)
+	case !due:
+		ui.Info("Not renewing %s yet: %s", domain, reason)
+		return ErrNotDue
+	default:
+		ui.Info("Renewal due: %s", reason)
+	}
+
+	if err := reissue(ctx, meta, rec); err != nil {
+		recordRateLimit(domain, err)
+		return err
+	}
+	ui.Success("Renewal complete for %s", domain)
+	return nil
+}
+
+// renewalKey returns the private key to renew meta with: the HSM key it names, the
+// existing key when the certificate reuses its key (DANE/TLSA or pinning setups),
+// else a fresh key of the type recorded for it, moved up to the configured minimum
+// RSA key size.
+func renewalKey(meta *metadata.CertMetadata, rotate bool) (key crypto.Signer, keyType string, reused bool, err error) {
+	if meta.KeyURI != "" {
+		ui.StepStart("Opening private key %s", keystore.Public(meta.KeyURI))
+		if key, err = keystore.Open(meta.KeyURI); err != nil {
+			return nil, "", false, fmt.Errorf("failed to open private key: %w", err)
+		}
+		if err := cryptopolicy.CheckPublicKey(key.Public()); err != nil {
+			return nil, "", false, err
+		}
+		return key, keygen.KeyTypeOf(key), true, nil
+	}
+	if meta.ReuseKey && meta.KeyPath != "" && !rotate {
+		ui.StepStart("Reusing private key %s", meta.KeyPath)
+		key, err := keygen.LoadPrivateKey(meta.KeyPath)
+		if err != nil {
+			return nil, "", false, fmt.Errorf("cannot reuse private key (renew with --no-reuse-key for a new one): %w", err)
+		}
+		if err := keycheck.CheckSigner(key, ui.Warning); err != nil {
+			return nil, "", false, err
+		}
+		return key, keygen.KeyTypeOf(key), true, nil
+	}
+
+	keyType, err = keygen.ParseKeyType(meta.KeyType)
+	if err != nil {
+		return nil, "", false, err
+	}
+	if bits := keygen.RSABits(keyType); bits > 0 && bits < cryptopolicy.MinRSAKeySize() {
+		upgraded, err := keygen.RSAKeyType(cryptopolicy.MinRSAKeySize())
+		if err != nil {
+			return nil, "", false, err
+		}
+		ui.Warning("%s keys are below the configured minimum; renewing with a %s key", keygen.Describe(keyType), keygen.Describe(upgraded))
+		keyType = upgraded
+	}
+	if err := keygen.CheckKeyType(keyType); err != nil {
+		return nil, "", false, fmt.Errorf("stored key type %s: %w", keyType, err)
+	}
+	ui.StepStart("Generating %s private key...", keygen.Describe(keyType))
+	key, err = keygen.GenerateKey(keyType)
+	if err != nil {
+		return nil, "", false, fmt.Errorf("failed to generate private key: %w", err)
+	}
+	if err := keycheck.CheckSigner(key, ui.Warning); err != nil {
+		return nil, "", false, err
+	}
+	return key, keyType, false, nil
+}
+
+// checkKeyRotation rejects negative rotation limits.
+func checkKeyRotation(r *KeyRotation) error {
+	if r != nil && (r.EveryRenewals < 0 || r.EveryDays < 0) {
+		return errors.New("key rotation limits cannot be negative")
+	}
+	return nil
+}
+
+// keyRotationDue returns why a reused key is due for rotation under meta's policy,
+// or "" when it is not.
+func keyRotationDue(meta *metadata.CertMetadata, now time.Time) string {
+	r := meta.KeyRotation
+	if r == nil || !meta.ReuseKey || meta.KeyPath == "" {
+		return ""
+	}
+	if r.EveryRenewals > 0 && meta.KeyRenewals+1 >= r.EveryRenewals {
+		return fmt.Sprintf("renewal %d on the same key (policy: every %d)", meta.KeyRenewals+1, r.EveryRenewals)
+	}
+	created := meta.KeyCreatedAt
+	if created.IsZero() {
+		// Keys from before rotation was tracked: the key file was last written with the key
+		if fi, err := os.Stat(meta.KeyPath); err == nil {
+			created = fi.ModTime()
+		}
+	}
+	if age := now.Sub(created); r.EveryDays > 0 && !created.IsZero() && age >= time.Duration(r.EveryDays)*24*time.Hour {
+		return fmt.Sprintf("key is %d days old (policy: every %d days)", int(age.Hours()/24), r.EveryDays)
+	}
+	return ""
+}
+
+// archiveKey moves the private key at path into the archive directory next to it,
+// named by the time it was retired, and returns the new path.
+func archiveKey(path string) (string, error) {
+	dir := filepath.Join(filepath.Dir(path), "archive")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	dst := filepath.Join(dir, fmt.Sprintf("%s-%s%s", base, time.Now().UTC().Format("20060102T150405Z"), filepath.Ext(path)))
+	if err := os.Rename(path, dst); err != nil {
+		return "", err
+	}
+	return dst, nil
+}
+
+// externalCSR returns the supplied CSR kept with meta's certificate, for renewals
+// of certificates whose key trustctl never saw. Replacing csr.pem with a CSR for the
+// same names rotates the key at the next renewal.
+func externalCSR(meta *metadata.CertMetadata) ([]byte, string, error) {
+	path := filepath.Join(filepath.Dir(meta.CertPath), "csr.pem")
+	ui.StepStart("Reusing the supplied CSR %s", path)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("certificate was requested with --csr but its CSR is unreadable: %w", err)
+	}
+	csr, csrPEM, err := keygen.ParseCSR(data)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %w", path, err)
+	}
+	if names := canonicalNames(keygen.CSRNames(csr)); sanKey(names) != sanKey(meta.Domains) {
+		return nil, "", fmt.Errorf("%s requests %s, not the certificate's %s", path, strings.Join(names, ", "), strings.Join(meta.Domains, ", "))
+	}
+	return csrPEM, keygen.PublicKeyType(csr.PublicKey), nil
+}
+
+// reissue obtains a new certificate for meta's current settings, writes and installs
+// it and stores the updated metadata. Callers hold the domain lock.
+func reissue(ctx context.Context, meta *metadata.CertMetadata, rec *metadata.HistoryRecord) error {
+	domain := meta.Domains[0]
+	ui.Info("Validation method: %s | Domains: %s | CA: %s",
+		meta.ValidationMethod, strings.Join(meta.Domains, ","), ca.IssuerName(meta.CA, meta.ServerURL))
+
+	// A certificate issued under strict-crypto must not silently renew without it
+	mode, err := cryptopolicy.ParseMode(meta.CryptoMode)
+	if err != nil {
+		return err
+	}
+	switch {
+	case mode == cryptopolicy.ModeFIPS && !cryptopolicy.FIPS():
+		return fmt.Errorf("%s was issued in FIPS mode; rerun with --fips", domain)
+	case mode == cryptopolicy.ModeStrict && !cryptopolicy.Strict():
+		return fmt.Errorf("%s was issued in strict-crypto mode; rerun with --strict-crypto", domain)
+	}
+
+	// Verify credentials exist
+	if err := creds.AssertPermissions(meta.CredentialsPath); err != nil {
+		return fmt.Errorf("credentials check failed: %w", err)
+	}
+	ui.StepDone("Credentials verified")
+
+	// Renew under the account profile the certificate was issued with
+	accountName := meta.Account
+	if accountName == "" {
+		accountName = account.DefaultName
+	}
+
+	// Setup validation using stored method
+	var dnsProvider dns.DNSProvider
+	if meta.ValidationMethod == "dns" {
+		if meta.DNSProvider == "" {
+			return fmt.Errorf("dns validation configured but no dns_provider in metadata")
+		}
+		ui.StepStart("Loading DNS provider: %s", meta.DNSProvider)
+		loader := dns.NewPluginLoader(paths.Plugins(), meta.CredentialsPath)
+		dnsProvider, err = loader.Load(meta.DNSProvider)
+		if err != nil {
+			return fmt.Errorf("failed to load dns provider: %w", err)
+		}
+		ui.Success("DNS provider loaded")
+	}
+
+	var newKey crypto.Signer
+	var keyType string
+	var csr []byte
+	reused := true
+	rotation := ""
+	if meta.ExternalCSR {
+		if csr, keyType, err = externalCSR(meta); err != nil {
+			return err
+		}
+	} else {
+		if rotation = keyRotationDue(meta, time.Now()); rotation != "" {
+			ui.Info("Rotating the private key: %s", rotation)
+		}
+		if newKey, keyType, reused, err = renewalKey(meta, rotation != ""); err != nil {
+			return err
+		}
+		if csr, err = keygen.GenerateCSR(newKey, meta.Domains); err != nil {
+			return fmt.Errorf("failed to generate CSR: %w", err)
+		}
+	}
+	keyFormat := meta.KeyFormat
+	if keyFormat == "" {
+		keyFormat = keygen.PKCS1
+	}
+
+	// Try the stored CA order until one issues
+	candidates, err := renewCandidates(meta)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := runHooks(hooks.Post, meta); err != nil {
+			ui.Warning("%v", err)
+		}
+	}()
+	if err := runHooks(hooks.Pre, meta); err != nil {
+		return err
+	}
+	var certMeta *ca.CertificateMeta
+	var failures []error
+	for i, cand := range candidates {
+		if i > 0 {
+			ui.Warning("Falling back to %s", ca.IssuerName(cand.CA, ""))
+		}
+		certMeta, err = renewFrom(ctx, meta, cand, accountName, dnsProvider, csr)
+		if err == nil {
+			if meta.ServerURL == "" {
+				meta.CA, meta.DirectoryURL = cand.CA, cand.Directory
+			}
+			break
+		}
+		if ctx.Err() != nil || len(candidates) == 1 {
+			return err
+		}
+		failures = append(failures, fmt.Errorf("%s: %w", ca.IssuerName(cand.CA, meta.ServerURL), err))
+	}
+	if certMeta == nil {
+		return fmt.Errorf("no CA renewed the certificate: %w", errors.Join(failures...))
+	}
+	ui.Success("Certificate renewed by %s", certMeta.Issuer)
+	rec.CAResponse = "issued by " + certMeta.Issuer
+
+	certInfo, err := certinfo.Parse(certMeta.PEM)
+	if err != nil {
+		ui.Warning("could not parse renewed certificate: %v", err)
+	} else if err := cryptopolicy.CheckCertificate(certInfo.Leaf); err != nil {
+		return fmt.Errorf("renewed certificate rejected: %w", err)
+	}
+	if certInfo != nil {
+		rec.CAResponse += ", serial " + certInfo.Serial
+	}
+
+	if meta.ImportedFrom != "" && meta.CertPath != "" {
+		if err := detachImported(filepath.Dir(meta.CertPath)); err != nil {
+			return fmt.Errorf("failed to replace the imported certificate files: %w", err)
+		}
+	}
+	if meta.KeyPath != "" && !reused {
+		if rotation != "" {
+			archived, err := archiveKey(meta.KeyPath)
+			if err != nil {
+				return fmt.Errorf("failed to archive the rotated private key: %w", err)
+			}
+			ui.Info("Previous private key archived: %s", archived)
+		}
+		if err := keygen.SavePrivateKey(newKey, meta.KeyPath, keyFormat); err != nil {
+			return fmt.Errorf("failed to save private key: %w", err)
+		}
+	}
+	if reused {
+		meta.KeyRenewals++
+	} else {
+		meta.KeyCreatedAt, meta.KeyRenewals = time.Now(), 0
+	}
+	if meta.CertPath != "" {
+		if err := keygen.SaveCSR(csr, filepath.Join(filepath.Dir(meta.CertPath), "csr.pem")); err != nil {
+			ui.Warning("failed to save CSR: %v", err)
+		}
+	}
+	if keyType != "" {
+		meta.KeyType = keyType
+	}
+
+	if meta.CertPath != "" {
+		files, err := bundle.Write(filepath.Dir(meta.CertPath), chooseChain(certMeta, meta.PreferredChain), meta.KeyPath, meta.Bundle)
+		if err != nil {
+			return fmt.Errorf("failed to save certificate: %w", err)
+		}
+		meta.SetBundleFiles(files)
+		for _, subject := range files.Fetched {
+			ui.Info("Fetched missing intermediate via AIA: %s", subject)
+		}
+		if files.ChainErr != nil {
+			ui.Warning("chain does not verify against the system roots: %v", files.ChainErr)
+		}
+		ui.Success("Certificate saved: %s", meta.CertPath)
+	}
+
+	// Install renewed certificate, unless it was requested without installation. Every
+	// server is deployed to again: the paths are unchanged, but servers only read the
+	// files when reloaded and some get a keystore, bundle or API call built from them.
+	installKey := meta.KeyPath
+	if meta.KeyURI != "" {
+		installKey = meta.KeyURI
+	}
+	var installErr error
+	switch {
+	case meta.InstallerType == metadata.InstallerNone:
+		ui.Info("Not installing the renewed certificate (requested with --no-install)")
+	case installKey == "":
+		ui.Info("Not installing the renewed certificate: its key stays with the CSR's owner")
+	default:
+		ui.StepStart("Installing renewed certificate...")
+		srv, err := installCertificate(meta.Domains, meta.CertPath, installKey, install.DeployOptions{Server: meta.InstallerType}, meta.ServerURL != "", certMeta)
+		if err != nil {
+			// The renewed files are in place; record them before reporting the failure
+			installErr = classify(ErrInstall, fmt.Errorf("installation failed: %w", err))
+		} else if srv != "" {
+			// Detected servers are kept so later renewals configure the same one
+			meta.InstallerType = srv
+			ui.Success("Certificate reinstalled into %s", srv)
+		}
+	}
+	truststore.RefreshJavaTruststores(meta)
+
+	// Update metadata with renewal timestamp
+	meta.LastRenewalAt = time.Now()
+	meta.RenewalAttempts++
+	meta.CryptoMode = string(cryptopolicy.CurrentMode())
+	if certInfo != nil {
+		meta.SetCertDetails(certInfo)
+	} else {
+		// Unknown validity is renewed on the next run rather than on the old dates
+		meta.IssuedAt, meta.ExpiresAt = time.Now(), time.Time{}
+	}
+	meta.RenewalInfo = nil // the window belonged to the previous certificate
+	meta.RetryAfter = time.Time{}
+	if err := meta.Store(); err != nil {
+		ui.Warning("failed to update renewal metadata: %v", err)
+	}
+	if installErr != nil {
+		return installErr
+	}
+	if err := runHooks(hooks.Deploy, meta); err != nil {
+		ui.Error("%v", err)
+	}
+
+	return nil
+}
+
+// renewCandidates returns the CAs to renew at: the stored CA order at the directories
+// matching the stored one (production or staging), or the issuing CA alone.
+func renewCandidates(meta *metadata.CertMetadata) ([]caCandidate, error) {
+	if len(meta.CAOrder) == 0 || meta.ServerURL != "" {
+		return []caCandidate{{CA: meta.CA, Directory: meta.DirectoryURL}}, nil
+	}
+	staging := meta.DirectoryURL != "" && meta.DirectoryURL == acme.StagingDirectories[meta.CA]
+	if !staging && meta.DirectoryURL != "" && meta.DirectoryURL != acme.KnownDirectories[meta.CA] {
+		// A custom directory overrides the order
+		return []caCandidate{{CA: meta.CA, Directory: meta.DirectoryURL}}, nil
+	}
+	return caCandidates(meta.CAOrder, "", "", staging)
+}
+
+// renewFrom validates meta's names and requests the certificate at one CA, under the
+// certificate's account profile there.
+func renewFrom(ctx context.Context, meta *metadata.CertMetadata, cand caCandidate, accountName string, dnsProvider dns.DNSProvider, csr []byte) (*ca.CertificateMeta, error) {
+	acc, err := account.Load(account.CANameFor(cand.CA, meta.ServerURL, cand.Directory), accountName)
+	if err != nil {
+		return nil, fmt.Errorf("issuing account unavailable: %w", err)
+	}
+	ui.StepDone("Using account %s", accountName)
+
+	// Resolve CA using stored settings
+	resolver := ca.NewResolver(meta.CredentialsPath)
+	caClient, err := resolver.Resolve(cand.CA, cand.Directory, meta.ServerURL, meta.HMACIDCred, "")
+	if err != nil {
+		return nil, classify(ErrCA, fmt.Errorf("CA resolution failed: %w", err))
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	order, err := authorize(ctx, acc, meta.Domains, acmeMethod(meta.ValidationMethod, meta.Manual), meta.Profile, ca.IssuerName(cand.CA, meta.ServerURL))
+	if err != nil {
+		return nil, classify(ErrCA, err)
+	}
+
+	// Validate domains
+	ui.StepStart("Validating domains for renewal...")
+	validator := validation.NewValidator(meta.ValidationMethod, dnsProvider).WithWebroot(meta.Webroot).WithHTTPAddr(meta.HTTPAddr).WithChallenges(order.challenges())
+	defer validator.CleanUp()
+	if dcv, ok := caClient.(validation.EmailDCV); ok {
+		validator.WithEmailDCV(dcv, meta.ApproverEmail)
+	}
+	if meta.Manual != nil {
+		validator.WithManual(*meta.Manual)
+	}
+	if err := validator.Validate(ctx, meta.Domains); err != nil {
+		return nil, classify(ErrValidation, fmt.Errorf("validation failed: %w", err))
+	}
+	ui.Success("Validation successful")
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Request renewed certificate
+	ui.StepStart("Requesting renewed certificate...")
+	var certMeta *ca.CertificateMeta
+	if order != nil {
+		certMeta, err = order.complete(ctx, meta.Domains, csr)
+	} else {
+		certMeta, err = requestCertificate(ctx, caClient, meta.Domains, csr)
+	}
+	if err != nil {
+		return nil, classify(ErrCA, fmt.Errorf("certificate request failed: %w", err))
+	}
+	return certMeta, nil
+}
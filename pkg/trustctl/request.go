@@ -0,0 +1,640 @@
+package trustctl
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/trustctl/trustctl/internal/account"
+	"github.com/trustctl/trustctl/internal/acme"
+	"github.com/trustctl/trustctl/internal/bundle"
+	"github.com/trustctl/trustctl/internal/ca"
+	"github.com/trustctl/trustctl/internal/certinfo"
+	"github.com/trustctl/trustctl/internal/cryptopolicy"
+	"github.com/trustctl/trustctl/internal/dns"
+	"github.com/trustctl/trustctl/internal/hooks"
+	"github.com/trustctl/trustctl/internal/install"
+	"github.com/trustctl/trustctl/internal/keycheck"
+	"github.com/trustctl/trustctl/internal/keygen"
+	"github.com/trustctl/trustctl/internal/keystore"
+	"github.com/trustctl/trustctl/internal/metadata"
+	"github.com/trustctl/trustctl/internal/paths"
+	"github.com/trustctl/trustctl/internal/ui"
+	"github.com/trustctl/trustctl/internal/validation"
+)
+
+// RequestOptions describe a new certificate. Zero values select the defaults of `trustctl request`.
+type RequestOptions struct {
+	Domains     []string
+	Validation  string // http (default), dns, email (enterprise CAs), manual
+	DNSProvider string
+	CA          string // ACME CA: letsencrypt (default), zerossl, google, buypass
+	// FallbackCAs are tried in order when CA fails or rate-limits the request (e.g.
+	// zerossl after letsencrypt); kept for renewals. Their accounts must already
+	// exist or not need external account binding.
+	FallbackCAs  []string
+	DirectoryURL string // ACME directory, e.g. Pebble; defaults to the CA's; kept for renewals
+	Staging      bool   // use the CA's staging directory instead of production
+	ServerURL    string // enterprise CA; overrides CA
+	HMACID       string // enterprise HMAC ID, or the EAB key ID for an ACME CA
+	HMACKey      string // enterprise HMAC key, or the base64url EAB HMAC key for an ACME CA
+	Webroot      string
+	HTTPAddr     string // answer http-01 from trustctl's own listener, e.g. ":8080", instead of the webroot; kept for renewals
+	Email        string
+	Labels       map[string]string
+	Account      string
+	KeyType      string // rsa2048 (default), rsa3072, rsa4096, ec256, ec384; kept for renewals
+	KeyFormat    string // private key file format: pkcs8 (default), pkcs1 or pkcs8-encrypted; kept for renewals
+	ReuseKey     bool   // renewals keep this private key instead of generating a new one
+	// KeyRotation replaces a reused key every N renewals or M days, archiving the old
+	// one; kept for renewals.
+	KeyRotation *KeyRotation
+	// KeyURI names an existing key in an HSM (a PKCS#11 URI) to sign the CSR with
+	// instead of generating one; no private key file is written. Kept for renewals.
+	KeyURI string
+	// TPM generates the key inside the host TPM 2.0 and stores it as a TSS2 key file
+	// (privkey.tss) that only this TPM can use, instead of privkey.pem.
+	TPM bool
+	// CSR is a PEM or DER certificate signing request made elsewhere (an appliance or
+	// HSM) to submit as is; trustctl then generates and stores no key. Domains default
+	// to the names it requests. Renewals resubmit the csr.pem kept with the certificate.
+	CSR []byte
+	// PreferredChain selects, by root Common Name, among the chains the CA offers
+	// (e.g. "ISRG Root X1"); kept for renewals.
+	PreferredChain string
+	// Profile is the ACME certificate profile to order (e.g. "tlsserver",
+	// "shortlived"); empty uses the CA's default. Kept for renewals.
+	Profile string
+	// RenewDaysBeforeExpiry renews the certificate this many days before it expires
+	// instead of the default (Config.RenewDaysBeforeExpiry, else the last third of
+	// its lifetime). Kept for renewals.
+	RenewDaysBeforeExpiry int
+	// Manual sets the challenge (dns by default) and optional auth/cleanup hooks of
+	// manual validation; kept for renewals.
+	Manual ManualValidation
+	// Hooks run before validation, after every attempt and after the certificate is
+	// deployed, after the executables in the shared hook directories. Kept for renewals.
+	Hooks Hooks
+	// ApproverEmail receives the enterprise CA's validation email for email
+	// validation; empty uses the first address the CA offers. Kept for renewals.
+	ApproverEmail string
+	ForceRenewal  bool   // issue even when a valid certificate for the same names exists
+	AgreeTOS      bool   // accept the CA's terms of service when a new account is registered
+	NoInstall     bool   // only obtain and store the certificate (certonly); kept for renewals
+	Server        string // installer target, one of install.Servers(); empty detects the web server
+	Bundle        BundleOptions
+	// TLSProfile sets the TLS directives of 443 vhosts the installer adds: modern,
+	// intermediate or old (default: Config.TLSProfile). Renewals keep the vhosts
+	// as they are.
+	TLSProfile string
+	// HSTS, when positive, also sets the Strict-Transport-Security header of the
+	// vhosts to this max-age.
+	HSTS time.Duration
+	// HTTP2 and HTTP3 enable those protocols in 443 vhosts the installer adds; HTTP/3
+	// needs nginx 1.25 or later.
+	HTTP2, HTTP3 bool
+}
+
+// ErrDuplicate is returned by Request, together with the existing certificate, when a
+// certificate for exactly the requested names is still valid and ForceRenewal is not set.
+var ErrDuplicate = errors.New("a valid certificate for these names already exists")
+
+// duplicateMinRemaining is how long an existing certificate for the same names must
+// still be valid for a new request to be treated as a duplicate.
+const duplicateMinRemaining = 30 * 24 * time.Hour
+
+// TermsError is returned by Request when a new account has to be registered and the
+// CA's terms of service (at URL) were not accepted with AgreeTOS.
+type TermsError = account.TermsError
+
+// ErrEABRequired is returned by Request when a new account has to be registered with
+// a CA that requires external account binding and HMACID/HMACKey were not given.
+var ErrEABRequired = account.ErrEABRequired
+
+// Request obtains a certificate, stores it with its metadata for renewal and installs it.
+func Request(ctx context.Context, opts RequestOptions) (*Certificate, error) {
+	var externalCSR []byte
+	var externalKeyType string
+	if len(opts.CSR) > 0 {
+		if opts.KeyType != "" || opts.KeyURI != "" || opts.TPM || opts.ReuseKey || opts.KeyRotation != nil || opts.Bundle.Combined {
+			return nil, errors.New("a supplied CSR brings its own key: --key-type, --key-uri, --tpm, --reuse-key, key rotation and combined bundles do not apply")
+		}
+		csr, csrPEM, err := keygen.ParseCSR(opts.CSR)
+		if err != nil {
+			return nil, err
+		}
+		names := canonicalNames(keygen.CSRNames(csr))
+		if len(opts.Domains) == 0 {
+			opts.Domains = names
+		} else if sanKey(canonicalNames(opts.Domains)) != sanKey(names) {
+			return nil, fmt.Errorf("domains %s do not match the names in the CSR (%s)", strings.Join(opts.Domains, ", "), strings.Join(names, ", "))
+		}
+		externalCSR, externalKeyType = csrPEM, keygen.PublicKeyType(csr.PublicKey)
+	}
+	if len(opts.Domains) == 0 {
+		return nil, errors.New("at least one domain is required")
+	}
+	if err := account.ValidateName(opts.Account); err != nil {
+		return nil, err
+	}
+	if opts.Account == "" {
+		opts.Account = account.DefaultName
+	}
+	if opts.Server != "" && !knownServer(opts.Server) {
+		return nil, fmt.Errorf("unknown server %q (expected one of %s)", opts.Server, strings.Join(install.Servers(), ", "))
+	}
+	if opts.TLSProfile != "" {
+		if err := install.CheckTLSProfile(opts.TLSProfile); err != nil {
+			return nil, err
+		}
+	}
+	candidates, err := caCandidates(append([]string{opts.CA}, opts.FallbackCAs...), opts.ServerURL, opts.DirectoryURL, opts.Staging)
+	if err != nil {
+		return nil, err
+	}
+	keyType, err := keygen.ParseKeyType(opts.KeyType)
+	if err != nil {
+		return nil, err
+	}
+	if err := keygen.CheckKeyType(keyType); err != nil {
+		return nil, err
+	}
+	keyFormat, err := keygen.ParseKeyFormat(opts.KeyFormat)
+	if err != nil {
+		return nil, err
+	}
+	method := validationType(opts.Validation)
+	if method == "manual" {
+		if err := validation.CheckManual(opts.Manual); err != nil {
+			return nil, err
+		}
+		method = opts.Manual.Method()
+	}
+	if err := validation.CheckWildcards(opts.Domains, method); err != nil {
+		return nil, err
+	}
+	if err := validation.CheckIPAddresses(opts.Domains, method); err != nil {
+		return nil, err
+	}
+	if opts.KeyURI != "" && !keystore.IsURI(opts.KeyURI) {
+		return nil, fmt.Errorf("unsupported key URI %q (supported schemes: %s)", opts.KeyURI, strings.Join(keystore.Schemes(), ", "))
+	}
+	if opts.KeyURI != "" && opts.TPM {
+		return nil, errors.New("--key-uri and --tpm both choose where the key lives; use one")
+	}
+	if opts.RenewDaysBeforeExpiry < 0 {
+		return nil, errors.New("days before expiry must not be negative")
+	}
+	if err := checkKeyRotation(opts.KeyRotation); err != nil {
+		return nil, err
+	}
+	if (opts.KeyURI != "" || opts.TPM) && opts.KeyRotation != nil {
+		return nil, errors.New("key rotation needs a key file trustctl generates; rotate HSM and TPM keys with their own tools")
+	}
+	if opts.KeyRotation != nil && !opts.ReuseKey {
+		ui.Warning("A key rotation policy only applies with --reuse-key; without it every renewal uses a new key")
+	}
+	if (opts.KeyURI != "" || opts.TPM) && opts.Bundle.Combined {
+		return nil, errors.New("a combined key+chain bundle needs the private key, which stays in the HSM or TPM")
+	}
+	if opts.Profile != "" && opts.ServerURL != "" {
+		return nil, errors.New("certificate profiles are an ACME feature; enterprise CAs select them on their side")
+	}
+	if validationType(opts.Validation) == "email" && opts.ServerURL == "" {
+		return nil, errors.New("email validation requires an enterprise CA (--serverurl)")
+	}
+	opts.Domains = canonicalNames(opts.Domains)
+	domains := opts.Domains
+	webroot := opts.Webroot
+	email := opts.Email
+
+	primaryDomain := domains[0]
+	certDir := paths.CertDir(primaryDomain)
+
+	domainLock, err := metadata.LockDomain(primaryDomain)
+	if err != nil {
+		ui.Error("%v", err)
+		return nil, err
+	}
+	defer domainLock.Release()
+
+	ui.StepStart("🤝 trustctl - Certificate Automation Agent")
+	ui.Info("Processing %d domain(s): %s", len(domains), strings.Join(domains, ", "))
+
+	// Re-issuing an identical, still valid certificate only burns the CA's duplicate-certificate limit
+	if dup, err := FindDuplicate(domains); err != nil {
+		ui.Warning("could not check for existing certificates: %v", err)
+	} else if dup != nil {
+		if !opts.ForceRenewal {
+			return dup, ErrDuplicate
+		}
+		ui.Warning("Issuing a duplicate of %s (forced)", dup.CertPath)
+	}
+
+	if cryptopolicy.FIPS() {
+		ui.Info("FIPS mode enabled: RSA 2048/3072/4096, NIST curves, SHA-2, TLS 1.2+ with approved cipher suites")
+	} else if cryptopolicy.Strict() {
+		ui.Info("Strict-crypto mode enabled: RSA >= %d, NIST curves, SHA-2, TLS 1.2+", cryptopolicy.MinRSABits)
+	}
+
+	// Setup directory structure
+	ui.StepStart("Creating certificate directory: %s", certDir)
+	if err := os.MkdirAll(certDir, 0700); err != nil {
+		ui.Error("failed to create cert directory: %v", err)
+		return nil, err
+	}
+	ui.Success("Directory created with chmod 700")
+
+	// Generate private key, or use the one held in the HSM; a supplied CSR needs neither
+	var csr []byte
+	keyPath, keyURI := "", opts.KeyURI
+	if externalCSR != nil {
+		csr, keyType = externalCSR, externalKeyType
+		ui.Info("Using the supplied %s CSR; no private key is generated or stored", keygen.Describe(keyType))
+	} else {
+		var privateKey crypto.Signer
+		if opts.TPM {
+			tssPath := fmt.Sprintf("%s/privkey.tss", certDir)
+			ui.StepStart("Generating %s private key in the TPM...", keygen.Describe(keyType))
+			if privateKey, err = keystore.GenerateTPMKey(keyType, tssPath); err != nil {
+				ui.Error("failed to generate TPM key: %v", err)
+				return nil, err
+			}
+			keyURI = "tpm:" + tssPath
+			ui.Success("TPM key saved: %s (usable only with this host's TPM)", tssPath)
+		} else if opts.KeyURI != "" {
+			ui.StepStart("Opening private key %s", keystore.Public(opts.KeyURI))
+			if privateKey, err = keystore.Open(opts.KeyURI); err != nil {
+				ui.Error("failed to open private key: %v", err)
+				return nil, err
+			}
+			if err := cryptopolicy.CheckPublicKey(privateKey.Public()); err != nil {
+				ui.Error("%v", err)
+				return nil, err
+			}
+			keyType = keygen.KeyTypeOf(privateKey)
+			ui.Success("Using %s key held in the token; no key file is written", keygen.Describe(keyType))
+		} else {
+			ui.StepStart("Generating %s private key...", keygen.Describe(keyType))
+			if privateKey, err = keygen.GenerateKey(keyType); err != nil {
+				ui.Error("failed to generate private key: %v", err)
+				return nil, err
+			}
+		}
+		if err := keycheck.CheckSigner(privateKey, ui.Warning); err != nil {
+			ui.Error("%v", err)
+			return nil, err
+		}
+
+		if keyURI == "" {
+			keyPath = fmt.Sprintf("%s/privkey.pem", certDir)
+			if err := keygen.SavePrivateKey(privateKey, keyPath, keyFormat); err != nil {
+				ui.Error("failed to save private key: %v", err)
+				return nil, err
+			}
+			ui.Success("Private key saved: %s (chmod 600)", keyPath)
+			if keyFormat == keygen.EncryptedPKCS8 {
+				ui.Info("The key is encrypted: the web server needs the key passphrase too (nginx ssl_password_file, Apache SSLPassPhraseDialog)")
+			}
+		}
+
+		// Generate CSR
+		ui.StepStart("Generating Certificate Signing Request (CSR)...")
+		if csr, err = keygen.GenerateCSR(privateKey, domains); err != nil {
+			ui.Error("failed to generate CSR: %v", err)
+			return nil, err
+		}
+	}
+
+	csrPath := fmt.Sprintf("%s/csr.pem", certDir)
+	if err := keygen.SaveCSR(csr, csrPath); err != nil {
+		ui.Error("failed to save CSR: %v", err)
+		return nil, err
+	}
+	ui.Success("CSR saved: %s", csrPath)
+
+	// Setup HTTP validation
+	if vtype := strings.ToLower(opts.Validation); (vtype == "" || vtype == "http") && opts.HTTPAddr == "" {
+		if webroot == "" {
+			webroot = paths.Webroot()
+		}
+		ui.StepStart("Setting up HTTP validation with webroot: %s", webroot)
+		challengeDir := fmt.Sprintf("%s/.well-known/acme-challenge", webroot)
+		if err := os.MkdirAll(challengeDir, 0755); err != nil {
+			ui.Error("failed to create challenge directory: %v", err)
+			return nil, err
+		}
+		ui.Success("Challenge directory ready: %s", challengeDir)
+	}
+
+	// Detect validation method
+	vtype := validationType(opts.Validation)
+
+	// DNS plugin loader (only needed for dns validation)
+	var dnsProvider dns.DNSProvider
+	if vtype == "dns" {
+		if opts.DNSProvider == "" {
+			ui.Error("a DNS provider is required for dns validation")
+			return nil, errors.New("a DNS provider is required for dns validation")
+		}
+		ui.StepStart("Loading DNS provider plugin: %s", opts.DNSProvider)
+		loader := dns.NewPluginLoader(paths.Plugins(), paths.Credentials())
+		dnsProvider, err = loader.Load(opts.DNSProvider)
+		if err != nil {
+			ui.Error("failed to load dns provider: %v", err)
+			return nil, fmt.Errorf("failed to load dns provider: %w", err)
+		}
+		ui.Success("Loaded DNS provider: %s", opts.DNSProvider)
+	}
+	if email == "" {
+		email = "admin@" + primaryDomain
+	}
+
+	// Hooks see the certificate's paths once it is saved
+	var hookSet *Hooks
+	if opts.Hooks != (Hooks{}) {
+		h := opts.Hooks
+		hookSet = &h
+	}
+	pending := &metadata.CertMetadata{Domains: domains, Hooks: hookSet}
+	defer func() {
+		if err := runHooks(hooks.Post, pending); err != nil {
+			ui.Warning("%v", err)
+		}
+	}()
+	if err := runHooks(hooks.Pre, pending); err != nil {
+		ui.Error("%v", err)
+		return nil, err
+	}
+
+	// Try the CAs in order until one issues
+	var certMeta *ca.CertificateMeta
+	var issuer caCandidate
+	var failures []error
+	for i, cand := range candidates {
+		if i > 0 {
+			ui.Warning("Falling back to %s", ca.IssuerName(cand.CA, ""))
+		}
+		o := opts
+		o.CA, o.Email = cand.CA, email
+		if i > 0 {
+			// EAB credentials belong to the primary CA
+			o.HMACID, o.HMACKey = "", ""
+		}
+		certMeta, err = issueFrom(ctx, o, cand.Directory, vtype, webroot, dnsProvider, csr)
+		if err == nil {
+			issuer = cand
+			break
+		}
+		if ctx.Err() != nil || len(candidates) == 1 {
+			return nil, err
+		}
+		failures = append(failures, fmt.Errorf("%s: %w", ca.IssuerName(cand.CA, opts.ServerURL), err))
+	}
+	if certMeta == nil {
+		return nil, fmt.Errorf("no CA issued the certificate: %w", errors.Join(failures...))
+	}
+
+	certInfo, err := certinfo.Parse(certMeta.PEM)
+	if err != nil {
+		ui.Warning("could not parse issued certificate: %v", err)
+	} else if err := cryptopolicy.CheckCertificate(certInfo.Leaf); err != nil {
+		ui.Error("issued certificate rejected: %v", err)
+		return nil, err
+	}
+
+	// Save certificate files
+	ui.StepStart("💾 Saving certificate files...")
+	bundleOpts := opts.Bundle
+	files, err := bundle.Write(certDir, chooseChain(certMeta, opts.PreferredChain), keyPath, bundleOpts)
+	if err != nil {
+		ui.Error("failed to save certificate: %v", err)
+		return nil, err
+	}
+	fullchainPath := files.FullChain
+	for _, subject := range files.Fetched {
+		ui.Info("Fetched missing intermediate via AIA: %s", subject)
+	}
+	if files.ChainErr != nil {
+		ui.Warning("chain does not verify against the system roots: %v", files.ChainErr)
+	}
+	ui.Success("Certificate saved: %s", fullchainPath)
+	if files.Combined != "" {
+		ui.Success("Combined key+chain bundle saved: %s (chmod 600)", files.Combined)
+	}
+
+	// Install the certificate into the server found here; renewals install it the same way
+	installer, installKey := "", keyPath
+	if keyURI != "" {
+		installKey = keyURI
+	}
+	var installErr error
+	switch {
+	case opts.NoInstall:
+		installer = metadata.InstallerNone
+		ui.Info("Not installing the certificate (--no-install); renewals only refresh the files")
+	case installKey == "":
+		installer = metadata.InstallerNone
+		ui.Info("Not installing the certificate: its key stays with the CSR's owner")
+	default:
+		ui.StepStart("🔗 Installing certificate for %s", strings.Join(domains, ", "))
+		srv, err := installCertificate(domains, fullchainPath, installKey, install.DeployOptions{Server: opts.Server, TLSProfile: opts.TLSProfile, HSTS: opts.HSTS, HTTP2: opts.HTTP2, HTTP3: opts.HTTP3}, opts.ServerURL != "", certMeta)
+		if err != nil {
+			// Still recorded below, so renewals and duplicate checks see the certificate
+			ui.Error("installation failed: %v", err)
+			installErr = classify(ErrInstall, fmt.Errorf("installation failed: %w", err))
+		} else if srv != "" {
+			installer = srv
+			ui.Success("Certificate installed")
+		}
+	}
+
+	// Save metadata for renewal. An EAB key ID is only needed to register the account.
+	hmacIDCred, caLabel := "", issuer.CA
+	if opts.ServerURL != "" {
+		hmacIDCred, caLabel = opts.HMACID, ""
+	}
+	ui.StepStart("📋 Saving certificate metadata for renewal...")
+	meta := &metadata.CertMetadata{
+		Domains:          domains,
+		ValidationMethod: vtype,
+		ApproverEmail:    opts.ApproverEmail,
+		Profile:          opts.Profile,
+		DNSProvider:      opts.DNSProvider,
+		CA:               caLabel,
+		DirectoryURL:     issuer.Directory,
+		CAOrder:          caOrder(candidates),
+		ServerURL:        opts.ServerURL,
+		HMACIDCred:       hmacIDCred,
+		CredentialsPath:  paths.Credentials(),
+		KeyPath:          keyPath,
+		KeyType:          keyType,
+		KeyFormat:        keyFormat,
+		ReuseKey:         opts.ReuseKey,
+		KeyRotation:      opts.KeyRotation,
+		RenewBeforeDays:  opts.RenewDaysBeforeExpiry,
+		KeyURI:           keyURI,
+		ExternalCSR:      externalCSR != nil,
+		PreferredChain:   opts.PreferredChain,
+		Bundle:           bundleOpts,
+		IssuedAt:         time.Now(),
+		RenewalAttempts:  0,
+		CryptoMode:       string(cryptopolicy.CurrentMode()),
+		Labels:           opts.Labels,
+		Account:          opts.Account,
+		Hooks:            hookSet,
+	}
+	meta.SetBundleFiles(files)
+	if vtype == "http" {
+		meta.Webroot = webroot
+		meta.HTTPAddr = opts.HTTPAddr
+	}
+	meta.InstallerType = installer
+	if vtype == "manual" {
+		manual := opts.Manual
+		manual.Challenge = manual.Method()
+		meta.Manual = &manual
+	}
+	if keyPath != "" {
+		meta.KeyCreatedAt = meta.IssuedAt
+	}
+	if certInfo != nil {
+		meta.SetCertDetails(certInfo)
+	}
+	if err := meta.Store(); err != nil {
+		ui.Warning("failed to save metadata: %v", err)
+	} else {
+		ui.Success("Metadata saved for renewal")
+	}
+	if err := metadata.AppendEvent(primaryDomain, "issued", certMeta.Issuer); err != nil {
+		ui.Warning("failed to record event: %v", err)
+	}
+	pending = meta
+	if installErr != nil {
+		return nil, installErr
+	}
+	if err := runHooks(hooks.Deploy, meta); err != nil {
+		ui.Error("%v", err)
+	}
+
+	ui.Success("✨ Certificate request complete!")
+	ui.Info("Files stored in: %s", certDir)
+	if installer != "" && installer != metadata.InstallerNone {
+		ui.Info("Installed into %s; renewals install it there again", installer)
+	} else if externalCSR != nil {
+		ui.Info("Next: Configure your web server or appliance to use %s with the key behind the CSR", fullchainPath)
+	} else if opts.TPM {
+		ui.Info("Next: Configure your web server to use %s and %s through the OpenSSL tpm2 provider", fullchainPath, strings.TrimPrefix(keyURI, "tpm:"))
+	} else if opts.KeyURI != "" {
+		ui.Info("Next: Configure your web server to use %s and the token key %s (nginx: ssl_certificate_key \"engine:pkcs11:...\"; Apache: SSLCertificateKeyFile)", fullchainPath, keystore.Public(opts.KeyURI))
+	} else {
+		ui.Info("Next: Configure your web server to use %s and %s", fullchainPath, keyPath)
+	}
+	ui.Info("To renew: trustctl renew")
+
+	return meta, nil
+}
+
+// FindDuplicate returns a managed certificate covering exactly domains (in any order)
+// that is still valid for at least duplicateMinRemaining, or nil.
+func FindDuplicate(domains []string) (*metadata.CertMetadata, error) {
+	certs, err := metadata.LoadMatching(nil)
+	if err != nil {
+		return nil, err
+	}
+	want := sanKey(domains)
+	for _, m := range certs {
+		if sanKey(m.Domains) != want || !m.RevokedAt.IsZero() || time.Until(m.ExpiresAt) < duplicateMinRemaining {
+			continue
+		}
+		if w := m.RenewalInfo; w != nil && !time.Now().Before(w.RenewAt) {
+			continue // the CA wants it replaced
+		}
+		if _, err := os.Stat(m.CertPath); err != nil {
+			continue
+		}
+		return m, nil
+	}
+	return nil, nil
+}
+
+// canonicalNames returns names with IP addresses in canonical form, the way CAs echo
+// ip identifiers back and certificates list them.
+func canonicalNames(names []string) []string {
+	out := make([]string, len(names))
+	for i, n := range names {
+		if ip := net.ParseIP(n); ip != nil {
+			n = ip.String()
+		}
+		out[i] = n
+	}
+	return out
+}
+
+// sanKey normalizes a name set for comparison.
+func sanKey(names []string) string {
+	set := make([]string, 0, len(names))
+	for _, n := range names {
+		set = append(set, strings.ToLower(strings.TrimSuffix(n, ".")))
+	}
+	sort.Strings(set)
+	return strings.Join(set, ",")
+}
+
+// resolveDirectory returns the ACME directory to use: directoryURL if set, else the
+// CA's staging or production directory. Enterprise CAs have none.
+func resolveDirectory(caName, serverURL, directoryURL string, staging bool) (string, error) {
+	switch {
+	case serverURL != "":
+		if directoryURL != "" || staging {
+			return "", errors.New("an ACME directory or staging cannot be combined with an enterprise CA server URL")
+		}
+		return "", nil
+	case directoryURL != "" && staging:
+		return "", errors.New("choose either a custom ACME directory or staging, not both")
+	case directoryURL != "":
+		return directoryURL, nil
+	}
+	return acme.DirectoryFor(caName, staging)
+}
+
+// chooseChain returns the chain to install: the one leading to the preferred root
+// when the CA offers it, otherwise the CA's default.
+func chooseChain(cert *ca.CertificateMeta, preferred string) []byte {
+	chains := append([][]byte{cert.PEM}, cert.Alternates...)
+	chain, matched := bundle.SelectChain(chains, preferred)
+	switch {
+	case matched && len(chains) > 1:
+		ui.Info("Using the chain to %s", preferred)
+	case preferred != "" && !matched:
+		ui.Warning("no chain to %q offered (roots: %s); using the default chain",
+			preferred, strings.Join(bundle.ChainRoots(chains), ", "))
+	}
+	return chain
+}
+
+// acmeMethod returns the validation method whose ACME challenge answers vtype: the
+// chosen challenge for manual validation, else vtype itself.
+func acmeMethod(vtype string, manual *ManualValidation) string {
+	if vtype != "manual" {
+		return vtype
+	}
+	if manual == nil {
+		return ManualValidation{}.Method()
+	}
+	return manual.Method()
+}
+
+// validationType normalizes a validation method name, http when empty.
+func validationType(v string) string {
+	if v = strings.ToLower(v); v == "" {
+		return "http"
+	}
+	return v
+}
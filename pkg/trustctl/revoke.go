@@ -0,0 +1,175 @@
+package trustctl
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/trustctl/trustctl/internal/account"
+	"github.com/trustctl/trustctl/internal/acme"
+	"github.com/trustctl/trustctl/internal/ca"
+	"github.com/trustctl/trustctl/internal/certinfo"
+	"github.com/trustctl/trustctl/internal/keygen"
+	"github.com/trustctl/trustctl/internal/keystore"
+	"github.com/trustctl/trustctl/internal/metadata"
+	"github.com/trustctl/trustctl/internal/ui"
+)
+
+// RevokeOptions adjust a revocation. The zero value revokes with the account key and
+// no reason, and keeps the certificate files.
+type RevokeOptions struct {
+	Reason string // RFC 5280 reason name (keyCompromise, superseded, ...); empty means unspecified
+	// UseCertKey signs an ACME revocation with the certificate's own private key
+	// instead of the account key, e.g. when the account is lost or the CA only
+	// accepts keyCompromise that way.
+	UseCertKey bool
+	HMACKey    string // enterprise CA HMAC key, which is not stored
+	// DeleteFiles removes the certificate, chain and private key files once revoked.
+	DeleteFiles bool
+}
+
+// Revoke revokes the certificate whose primary domain is domain at the CA that issued
+// it and marks its metadata revoked, so renewals skip it from then on.
+func Revoke(ctx context.Context, domain string, opts RevokeOptions) (*Certificate, error) {
+	domainLock, err := metadata.LockDomain(domain)
+	if err != nil {
+		return nil, err
+	}
+	defer domainLock.Release()
+
+	meta, err := metadata.Load(domain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load metadata for %s: %w", domain, err)
+	}
+	if !meta.RevokedAt.IsZero() {
+		return nil, fmt.Errorf("%s was already revoked on %s", domain, meta.RevokedAt.Format(time.RFC3339))
+	}
+	reason, err := acme.ParseRevocationReason(opts.Reason)
+	if err != nil {
+		return nil, err
+	}
+	info, err := certinfo.ParseFile(meta.CertPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate: %w", err)
+	}
+
+	ui.StepStart("Revoking certificate for %s (serial %s)", domain, info.Serial)
+	if meta.ServerURL != "" {
+		err = revokeEnterprise(ctx, meta, info.Leaf.SerialNumber.Text(16), reason, opts.HMACKey)
+	} else {
+		err = revokeACME(ctx, meta, info.Leaf.Raw, reason, opts.UseCertKey)
+	}
+	var p *acme.Problem
+	switch {
+	case errors.As(err, &p) && strings.HasSuffix(p.Type, ":alreadyRevoked"):
+		ui.Info("The CA reports the certificate as already revoked")
+	case err != nil:
+		return nil, fmt.Errorf("revocation failed: %w", err)
+	}
+
+	name := opts.Reason
+	if name == "" {
+		name = "unspecified"
+	}
+	meta.RevokedAt = time.Now().UTC()
+	meta.RevocationReason = name
+	if opts.DeleteFiles {
+		for _, path := range certFiles(meta) {
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				ui.Warning("failed to remove %s: %v", path, err)
+			}
+		}
+		ui.StepDone("Removed the certificate and key files")
+	}
+	if err := meta.Store(); err != nil {
+		return nil, fmt.Errorf("certificate revoked but metadata could not be updated: %w", err)
+	}
+	if err := metadata.AppendEvent(domain, "revoked", fmt.Sprintf("serial %s, reason %s", info.Serial, name)); err != nil {
+		ui.Warning("failed to record event: %v", err)
+	}
+	ui.Success("Certificate for %s revoked (%s)", domain, name)
+	return meta, nil
+}
+
+// revokeACME revokes cert at the ACME CA of meta, signed by the account the
+// certificate was issued under or by the certificate's own key.
+func revokeACME(ctx context.Context, meta *metadata.CertMetadata, cert []byte, reason int, useCertKey bool) error {
+	directory := meta.DirectoryURL
+	if directory == "" {
+		var err error
+		if directory, err = acme.DirectoryFor(meta.CA, false); err != nil {
+			return err
+		}
+	}
+	if useCertKey {
+		key, err := certKey(meta)
+		if err != nil {
+			return err
+		}
+		return acme.NewClient(directory, key).RevokeCert(ctx, cert, reason)
+	}
+
+	accountName := meta.Account
+	if accountName == "" {
+		accountName = account.DefaultName
+	}
+	acc, err := account.Load(account.CANameFor(meta.CA, meta.ServerURL, directory), accountName)
+	if err != nil {
+		return fmt.Errorf("issuing account unavailable (revoke with the certificate key instead): %w", err)
+	}
+	if acc.AccountURL == "" {
+		return fmt.Errorf("account %s is not registered over ACME", accountName)
+	}
+	key, err := acc.LoadKey()
+	if err != nil {
+		return fmt.Errorf("failed to load account key: %w", err)
+	}
+	client := acme.NewClient(directory, key)
+	client.KID = acc.AccountURL
+	return client.RevokeCert(ctx, cert, reason)
+}
+
+// revokeEnterprise revokes the certificate with serial through the enterprise CA's API.
+func revokeEnterprise(ctx context.Context, meta *metadata.CertMetadata, serial string, reason int, hmacKey string) error {
+	client, err := ca.NewResolver(meta.CredentialsPath).Resolve("", "", meta.ServerURL, meta.HMACIDCred, hmacKey)
+	if err != nil {
+		return fmt.Errorf("CA resolution failed: %w", err)
+	}
+	r, ok := client.(ca.Revoker)
+	if !ok {
+		return fmt.Errorf("%s does not support revocation", meta.ServerURL)
+	}
+	return r.Revoke(ctx, serial, reason)
+}
+
+// certKey loads the private key of meta's certificate.
+func certKey(meta *metadata.CertMetadata) (crypto.Signer, error) {
+	switch {
+	case meta.KeyURI != "":
+		return keystore.Open(meta.KeyURI)
+	case meta.ExternalCSR || meta.KeyPath == "":
+		return nil, errors.New("trustctl does not hold the certificate's private key; revoke with the account key")
+	}
+	return keygen.LoadPrivateKey(meta.KeyPath)
+}
+
+// certFiles returns the files trustctl wrote for meta's certificate: the PEM bundles,
+// the CSR and the private key (or the TPM key blob).
+func certFiles(meta *metadata.CertMetadata) []string {
+	dir := filepath.Dir(meta.CertPath)
+	files := []string{meta.CertPath, filepath.Join(dir, "cert.pem"), filepath.Join(dir, "csr.pem")}
+	for _, path := range []string{meta.ChainPath, meta.CombinedPath, meta.KeyPath} {
+		if path != "" {
+			files = append(files, path)
+		}
+	}
+	if path, ok := keystore.TPMKeyFile(meta.KeyURI); ok {
+		files = append(files, path)
+	}
+	return files
+}
@@ -0,0 +1,171 @@
+package trustctl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"time"
+
+	"github.com/trustctl/trustctl/internal/acme"
+	"github.com/trustctl/trustctl/internal/ca"
+	"github.com/trustctl/trustctl/internal/certinfo"
+	"github.com/trustctl/trustctl/internal/lock"
+	"github.com/trustctl/trustctl/internal/metadata"
+	"github.com/trustctl/trustctl/internal/ratelimit"
+	"github.com/trustctl/trustctl/internal/ui"
+)
+
+// ErrNotDue is returned by Renew when the certificate is not yet due for renewal.
+var ErrNotDue = errors.New("certificate is not due for renewal")
+
+// ErrRateLimited is returned by Renew when an earlier attempt was rate limited by the
+// CA and its retry-after time has not passed.
+var ErrRateLimited = errors.New("renewal is rate limited by the CA")
+
+// ErrRevoked is returned by Renew for a certificate revoked through Revoke; a
+// replacement has to be requested.
+var ErrRevoked = errors.New("certificate was revoked")
+
+// ErrLocked is wrapped by errors from operations on a certificate (or the web server
+// configuration) that another trustctl instance is working on. Renew skips such a
+// certificate without a record, so overlapping runs split the work between them.
+var ErrLocked = lock.ErrLocked
+
+// skipped reports whether a Renew error means no attempt was made.
+func skipped(err error) bool {
+	return errors.Is(err, ErrNotDue) || errors.Is(err, ErrRateLimited) || errors.Is(err, ErrRevoked) || errors.Is(err, ErrLocked)
+}
+
+// requestCertificate asks the CA for a certificate, backing off while it is rate limited.
+func requestCertificate(ctx context.Context, client ca.CAClient, domains []string, csr []byte) (*ca.CertificateMeta, error) {
+	var out *ca.CertificateMeta
+	notify := func(wait time.Duration, rl *ratelimit.Error) {
+		ui.Warning("%v; retrying in %s", rl, wait.Round(time.Second))
+	}
+	err := ratelimit.Retry(ctx, ratelimit.DefaultPolicy, notify, func() error {
+		var err error
+		out, err = client.RequestCertificate(ctx, domains, csr)
+		return err
+	})
+	return out, err
+}
+
+// recordRateLimit stores when domain may be retried after err, if err is a CA rate limit.
+func recordRateLimit(domain string, err error) {
+	var rl *ratelimit.Error
+	if !errors.As(err, &rl) {
+		return
+	}
+	meta, lerr := metadata.Load(domain)
+	if lerr != nil {
+		return
+	}
+	meta.RetryAfter = rl.Until()
+	if serr := meta.Store(); serr != nil {
+		ui.Warning("failed to record rate limit: %v", serr)
+		return
+	}
+	ui.Info("Renewals of %s are paused until %s", domain, meta.RetryAfter.Format(time.RFC3339))
+}
+
+// defaultRenewBeforeDays is Config.RenewDaysBeforeExpiry.
+var defaultRenewBeforeDays int
+
+// renewalDue reports whether meta's certificate should be renewed at now, and why.
+// The ARI window suggested by the CA wins; without one the certificate is renewed
+// the configured number of days before expiry, or in the last third of its lifetime
+// when none is set or the setting exceeds the lifetime, moved earlier by a
+// per-certificate offset so a fleet does not renew at once. Validity is read from
+// the certificate file when metadata does not record it.
+func renewalDue(meta *metadata.CertMetadata, now time.Time) (bool, string) {
+	if w := meta.RenewalInfo; w != nil {
+		if now.Before(w.RenewAt) {
+			return false, fmt.Sprintf("the CA suggests renewing between %s and %s, scheduled for %s",
+				w.Start.Format(time.RFC3339), w.End.Format(time.RFC3339), w.RenewAt.Format(time.RFC3339))
+		}
+		return true, "inside the renewal window suggested by the CA"
+	}
+	issued, expires := meta.IssuedAt, meta.ExpiresAt
+	if expires.IsZero() {
+		info, err := certinfo.ParseFile(meta.CertPath)
+		if err != nil {
+			return true, "expiry unknown"
+		}
+		issued, expires = info.NotBefore, info.NotAfter
+	}
+	lifetime := expires.Sub(issued)
+	before := lifetime / 3
+	days := meta.RenewBeforeDays
+	if days == 0 {
+		days = defaultRenewBeforeDays
+	}
+	if d := time.Duration(days) * 24 * time.Hour; d > 0 && d < lifetime {
+		before = d
+	}
+	at := expires.Add(-before - renewalOffset(meta, before/10))
+	if now.Before(at) {
+		return false, fmt.Sprintf("expires %s, renewing from %s", expires.Format("2006-01-02"), at.Format("2006-01-02"))
+	}
+	return true, fmt.Sprintf("expires %s", expires.Format("2006-01-02"))
+}
+
+// renewalOffset returns a duration in [0, max) that is stable for one certificate and
+// spread evenly across certificates.
+func renewalOffset(meta *metadata.CertMetadata, max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	h := fnv.New64a()
+	h.Write([]byte(meta.FingerprintSHA256))
+	h.Write([]byte(meta.Domains[0]))
+	return time.Duration(h.Sum64() % uint64(max))
+}
+
+// refreshRenewalInfo asks the CA for the ARI window of meta's certificate unless the
+// last answer said not to ask yet, and reports whether meta changed. Errors keep the
+// stored window; CAs without ARI are skipped silently.
+func refreshRenewalInfo(ctx context.Context, meta *metadata.CertMetadata, now time.Time) bool {
+	if meta.ServerURL != "" {
+		return false
+	}
+	if w := meta.RenewalInfo; w != nil && now.Before(w.NextCheck) {
+		return false
+	}
+	info, err := certinfo.ParseFile(meta.CertPath)
+	if err != nil {
+		return false
+	}
+	directory := meta.DirectoryURL
+	if directory == "" {
+		if directory, err = acme.DirectoryFor(meta.CA, false); err != nil {
+			return false
+		}
+	}
+	ri, err := acme.NewClient(directory, nil).GetRenewalInfo(ctx, info.Leaf)
+	if errors.Is(err, acme.ErrNoRenewalInfo) {
+		return false
+	}
+	if err != nil {
+		ui.Warning("could not fetch renewal information for %s: %v", meta.Domains[0], err)
+		return false
+	}
+
+	start, end := ri.SuggestedWindow.Start, ri.SuggestedWindow.End
+	w := meta.RenewalInfo
+	if w == nil || !w.Start.Equal(start) || !w.End.Equal(end) {
+		w = &metadata.RenewalWindow{Start: start, End: end, RenewAt: start}
+		if span := end.Sub(start); span > 0 {
+			w.RenewAt = start.Add(time.Duration(rand.Int63n(int64(span))))
+		}
+		if ri.ExplanationURL != "" {
+			ui.Info("The CA moved the renewal window of %s: %s", meta.Domains[0], ri.ExplanationURL)
+		}
+	}
+	w.ExplanationURL = ri.ExplanationURL
+	w.CheckedAt = now
+	w.NextCheck = ri.RetryAfter
+	meta.RenewalInfo = w
+	return true
+}
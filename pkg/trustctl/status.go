@@ -0,0 +1,98 @@
+package trustctl
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/trustctl/trustctl/internal/certinfo"
+	"github.com/trustctl/trustctl/internal/metadata"
+)
+
+// Health states reported by Status, from worst to best.
+const (
+	HealthExpired        = "EXPIRED"
+	HealthRevoked        = "REVOKED"
+	HealthExpiring       = "EXPIRING"
+	HealthRenewalFailing = "RENEWAL-FAILING"
+	HealthOK             = "OK"
+)
+
+// DefaultWarnDays is how close to expiry a certificate is reported EXPIRING when
+// StatusOptions does not say otherwise.
+const DefaultWarnDays = 14
+
+// StatusOptions adjust Status.
+type StatusOptions struct {
+	// WarnDays reports certificates expiring within this many days as EXPIRING
+	// (default DefaultWarnDays).
+	WarnDays int
+}
+
+// CertStatus is the health of one managed certificate.
+type CertStatus struct {
+	Domain        string    `json:"domain"`
+	Health        string    `json:"health"`
+	ExpiresAt     time.Time `json:"expires_at,omitempty"`
+	DaysLeft      int       `json:"days_left"`
+	FailureStreak int       `json:"failure_streak,omitempty"` // consecutive failed renewal attempts
+	Detail        string    `json:"detail,omitempty"`
+}
+
+// OK reports whether the certificate needs no attention.
+func (s *CertStatus) OK() bool {
+	return s.Health == HealthOK
+}
+
+// Status returns the health of every managed certificate whose labels match selector
+// (nil for all): EXPIRED, REVOKED, EXPIRING within opts.WarnDays, RENEWAL-FAILING when
+// the last renewal attempt failed, and OK otherwise.
+func Status(selector map[string]string, opts StatusOptions) ([]*CertStatus, error) {
+	if opts.WarnDays <= 0 {
+		opts.WarnDays = DefaultWarnDays
+	}
+	certs, err := metadata.LoadMatching(selector)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list certificates: %w", err)
+	}
+	now := time.Now()
+	out := make([]*CertStatus, 0, len(certs))
+	for _, m := range certs {
+		out = append(out, certStatus(m, now, opts.WarnDays))
+	}
+	return out, nil
+}
+
+func certStatus(m *metadata.CertMetadata, now time.Time, warnDays int) *CertStatus {
+	s := &CertStatus{Domain: m.Domains[0], ExpiresAt: m.ExpiresAt}
+	if s.ExpiresAt.IsZero() {
+		info, err := certinfo.ParseFile(m.CertPath)
+		if err != nil {
+			s.Health = HealthExpired
+			s.Detail = fmt.Sprintf("certificate unreadable: %v", err)
+			return s
+		}
+		s.ExpiresAt = info.NotAfter
+	}
+	s.DaysLeft = int(s.ExpiresAt.Sub(now).Hours() / 24)
+	if records, err := metadata.History(s.Domain); err == nil {
+		s.FailureStreak = metadata.FailureStreak(records)
+		if s.FailureStreak > 0 {
+			s.Detail = fmt.Sprintf("last %d renewal attempt(s) failed: %s", s.FailureStreak, records[len(records)-1].Error)
+		}
+	}
+
+	switch {
+	case !now.Before(s.ExpiresAt):
+		s.Health = HealthExpired
+	case !m.RevokedAt.IsZero():
+		s.Health = HealthRevoked
+		s.Detail = "revoked " + m.RevokedAt.Format("2006-01-02")
+	case s.DaysLeft < warnDays:
+		s.Health = HealthExpiring
+	case s.FailureStreak > 0:
+		s.Health = HealthRenewalFailing
+	default:
+		s.Health = HealthOK
+	}
+	return s
+}
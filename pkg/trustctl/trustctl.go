@@ -0,0 +1,197 @@
+// Package trustctl is the embeddable API behind the trustctl CLI: issuance, renewal
+// and access to the certificate metadata store. Progress is reported through a Sink
+// instead of being printed, so callers can route it into their own logging.
+//
+// The underlying state (paths, metadata store, crypto policy) is process-wide, so a
+// program opens the library once with Open and closes it with Close.
+package trustctl
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/trustctl/trustctl/internal/bundle"
+	"github.com/trustctl/trustctl/internal/cryptopolicy"
+	"github.com/trustctl/trustctl/internal/httpclient"
+	"github.com/trustctl/trustctl/internal/install"
+	"github.com/trustctl/trustctl/internal/keycheck"
+	"github.com/trustctl/trustctl/internal/keygen"
+	"github.com/trustctl/trustctl/internal/keystore"
+	"github.com/trustctl/trustctl/internal/metadata"
+	"github.com/trustctl/trustctl/internal/paths"
+	"github.com/trustctl/trustctl/internal/ui"
+	"github.com/trustctl/trustctl/internal/validation"
+)
+
+// Certificate is the stored metadata of one managed certificate.
+type Certificate = metadata.CertMetadata
+
+// HistoryRecord is one renewal attempt.
+type HistoryRecord = metadata.HistoryRecord
+
+// BundleOptions control which certificate files are written.
+type BundleOptions = bundle.Options
+
+// BackupRetention limits the vhost backups kept of each config file.
+type BackupRetention = install.Retention
+
+// DefaultBackupKeepLast is the number of backups kept of each config file unless
+// Config.BackupRetention says otherwise.
+const DefaultBackupKeepLast = 10
+
+// KeyRotation is the key rotation policy of a certificate that reuses its key.
+type KeyRotation = metadata.KeyRotation
+
+// ManualValidation configures manual validation: the challenge answered and the
+// optional hooks that publish and remove the responses.
+type ManualValidation = validation.Manual
+
+// Hooks are the commands run before validation, after every attempt and after a new
+// certificate is deployed.
+type Hooks = metadata.Hooks
+
+// Layout is the set of directories trustctl keeps its state in.
+type Layout = paths.Layout
+
+// Sink receives progress messages; Level classifies them.
+type (
+	Sink  = ui.Sink
+	Level = ui.Level
+)
+
+// Message levels delivered to a Sink.
+const (
+	LevelInfo      = ui.LevelInfo
+	LevelSuccess   = ui.LevelSuccess
+	LevelWarning   = ui.LevelWarning
+	LevelError     = ui.LevelError
+	LevelStepStart = ui.LevelStepStart
+	LevelStepDone  = ui.LevelStepDone
+	LevelDebug     = ui.LevelDebug
+)
+
+// SinkFunc adapts a function to Sink.
+type SinkFunc = ui.SinkFunc
+
+// Discard is a Sink that drops all messages.
+var Discard = ui.Discard
+
+// Config selects the storage and policy used by the library. Paths come from the
+// same TRUSTCTL_* environment variables as the CLI.
+type Config struct {
+	Store           string // metadata backend: json (default) or sqlite
+	StrictCrypto    bool
+	FIPS            bool   // strict crypto narrowed to FIPS-approved key sizes, curves and hashes; implies StrictCrypto
+	MinRSAKeySize   int    // refuse RSA keys below this size (2048, 3072 or 4096) when generated, reused or imported
+	CheckPwnedKeys  bool   // also look new keys up on pwnedkeys.com (sends only the key's hash)
+	ServerConfigDir string // only look for web server vhost files here
+	ReloadCommand   string // shell command reloading the web server instead of systemctl or its control command
+	TemplatesDir    string // 443 vhost templates for the installer (default /etc/trustctl/templates)
+	TLSProfile      string // TLS profile of new 443 vhosts: modern, intermediate (default) or old
+	Proxy           string // outbound proxy URL instead of HTTPS_PROXY/HTTP_PROXY; NO_PROXY still applies
+	Sink            Sink   // nil keeps the current sink (console output by default)
+	// KeyPassphrase returns the passphrase of encrypted private keys (key format
+	// pkcs8-encrypted). It is called at most once, when the first one is read or
+	// written; confirm is set before a new key is encrypted.
+	KeyPassphrase func(confirm bool) ([]byte, error)
+	// PKCS11Module is the PKCS#11 library for key URIs without module-path (default
+	// $TRUSTCTL_PKCS11_MODULE); PKCS11PIN supplies token PINs they do not carry.
+	PKCS11Module string
+	PKCS11PIN    func() ([]byte, error)
+	// TPMDevice is the TPM 2.0 character device for --tpm keys (default
+	// $TRUSTCTL_TPM_DEVICE, then /dev/tpmrm0).
+	TPMDevice string
+	// RenewDaysBeforeExpiry renews certificates this many days before they expire
+	// unless their metadata sets its own; 0 renews in the last third of the lifetime.
+	RenewDaysBeforeExpiry int
+	// LockTimeout is how long to wait for another trustctl instance working on the
+	// same certificate or web server configuration; 0 fails at once.
+	LockTimeout time.Duration
+	// BackupRetention limits the vhost backups kept of each config file; KeepLast 0
+	// keeps the default of 10.
+	BackupRetention BackupRetention
+	// Home roots the whole layout at this directory instead of $TRUSTCTL_HOME or the
+	// default (/opt/trustctl for root, ~/.config/trustctl for other users).
+	Home string
+	// Dirs replaces the non-empty directories of the layout (Base excepted), such as
+	// those from a configuration file; TRUSTCTL_* variables still take precedence.
+	Dirs Layout
+}
+
+// Open applies cfg and opens the metadata store.
+func Open(cfg Config) error {
+	if cfg.Sink != nil {
+		ui.SetSink(cfg.Sink)
+	}
+	switch {
+	case cfg.FIPS:
+		cryptopolicy.SetMode(cryptopolicy.ModeFIPS)
+	case cfg.StrictCrypto:
+		cryptopolicy.SetMode(cryptopolicy.ModeStrict)
+	}
+	if err := cryptopolicy.SetMinRSABits(cfg.MinRSAKeySize); err != nil {
+		return err
+	}
+	keycheck.SetPwnedKeysLookup(cfg.CheckPwnedKeys)
+	keygen.SetPassphraseFunc(cfg.KeyPassphrase)
+	keystore.SetPKCS11Module(cfg.PKCS11Module)
+	keystore.SetPINFunc(cfg.PKCS11PIN)
+	keystore.SetTPMDevice(cfg.TPMDevice)
+	if cfg.RenewDaysBeforeExpiry < 0 {
+		return errors.New("days before expiry must not be negative")
+	}
+	defaultRenewBeforeDays = cfg.RenewDaysBeforeExpiry
+	if cfg.LockTimeout < 0 {
+		return errors.New("lock timeout must not be negative")
+	}
+	metadata.LockTimeout = cfg.LockTimeout
+	if err := httpclient.SetProxy(cfg.Proxy); err != nil {
+		return err
+	}
+	if err := paths.LoadEnv(cfg.Home); err != nil {
+		return err
+	}
+	if err := paths.Override(cfg.Dirs); err != nil {
+		return err
+	}
+	if cfg.ServerConfigDir != "" {
+		if fi, err := os.Stat(cfg.ServerConfigDir); err != nil || !fi.IsDir() {
+			return fmt.Errorf("server config dir %s is not a directory", cfg.ServerConfigDir)
+		}
+		install.SetConfigDir(cfg.ServerConfigDir)
+	}
+	install.SetReloadCommand(cfg.ReloadCommand)
+	install.SetTemplatesDir(cfg.TemplatesDir)
+	if err := install.SetTLSProfile(cfg.TLSProfile); err != nil {
+		return err
+	}
+	if cfg.BackupRetention.KeepLast == 0 {
+		cfg.BackupRetention.KeepLast = DefaultBackupKeepLast
+	}
+	install.SetRetention(cfg.BackupRetention)
+	// A fresh --home or ~/.config/trustctl has no install script to create its layout
+	for _, dir := range []string{paths.Logs(), paths.Certs(), paths.Credentials()} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			ui.Warning("couldn't create %s: %v", dir, err)
+		}
+	}
+	if cfg.Store == "" {
+		cfg.Store = metadata.BackendJSON
+	}
+	if err := metadata.Open(cfg.Store); err != nil {
+		return fmt.Errorf("failed to open metadata store: %w", err)
+	}
+	return nil
+}
+
+// Close releases the metadata store.
+func Close() error {
+	return metadata.Close()
+}
+
+// Certificates returns managed certificates whose labels match selector (nil for all).
+func Certificates(selector map[string]string) ([]*Certificate, error) {
+	return metadata.LoadMatching(selector)
+}
@@ -1,6 +1,10 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/trustctl/trustctl/internal/acme"
+)
 
 // This file is a skeleton for building a DNS plugin as a Go plugin (.so).
 // Build with: `go build -buildmode=plugin -o cloudflare.so cloudflare.go`
@@ -8,7 +12,7 @@ import "fmt"
 type cfProvider struct{}
 
 func (c *cfProvider) Present(domain, token, keyAuth string) error {
-	fmt.Printf("[cloudflare plugin] present TXT for %s\n", domain)
+	fmt.Printf("[cloudflare plugin] present TXT _acme-challenge.%s = %s\n", domain, acme.DNS01Value(keyAuth))
 	return nil
 }
 
@@ -17,5 +21,17 @@ func (c *cfProvider) CleanUp(domain, token, keyAuth string) error {
 	return nil
 }
 
+// PresentTXT is called instead of Present when the plugin implements it: trustctl
+// has already followed CNAMEs and found the zone that holds the record.
+func (c *cfProvider) PresentTXT(zone, fqdn, value string) error {
+	fmt.Printf("[cloudflare plugin] present TXT %s = %s in zone %s\n", fqdn, value, zone)
+	return nil
+}
+
+func (c *cfProvider) CleanUpTXT(zone, fqdn, value string) error {
+	fmt.Printf("[cloudflare plugin] cleanup TXT %s in zone %s\n", fqdn, zone)
+	return nil
+}
+
 // Provider is the exported symbol the loader expects.
 var Provider cfProvider